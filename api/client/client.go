@@ -9,9 +9,12 @@ import (
 	"github.com/NetSys/quilt/api"
 	"github.com/NetSys/quilt/api/pb"
 	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/faults"
 
+	"github.com/satori/go.uuid"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 const (
@@ -20,6 +23,13 @@ const (
 
 	// The timeout for connecting to the daemon.
 	connectTimeout = 5 * time.Second
+
+	// The number of times a request that changes the deployment is sent again
+	// while the daemon is unavailable, and how long to wait before each.  The
+	// requests carry an idempotency key, so the daemon runs them at most once no
+	// matter how many times they're sent.
+	idempotentRetries  = 2
+	idempotentInterval = 100 * time.Millisecond
 )
 
 // Client provides methods to interact with the Quilt daemon.
@@ -46,6 +56,36 @@ type Client interface {
 	// QueryClusters retrieves cluster information tracked by the Quilt daemon.
 	QueryClusters() ([]db.Cluster, error)
 
+	// QueryCounters retrieves the debugging counters tracked by the Quilt daemon.
+	QueryCounters() ([]pb.Counter, error)
+
+	// QueryMinionCounters retrieves the debugging counters tracked by the minion
+	// running on the machine with the given public IP.
+	QueryMinionCounters(host string) ([]pb.Counter, error)
+
+	// InjectFault requests that the server inject the given failures.  It fails
+	// unless the server has fault injection enabled.
+	InjectFault(fault faults.Fault) error
+
+	// FreezeMachines requests that the Quilt daemon stop booting and terminating
+	// machines if `frozen` is true, or resume doing so otherwise.
+	FreezeMachines(frozen bool) error
+
+	// QueryStatus retrieves a summary of the health of each label's containers.
+	QueryStatus() ([]api.LabelStatus, error)
+
+	// DrainMachine requests that the Quilt daemon move the containers off of the
+	// machine with the given database ID, and then terminate it.
+	DrainMachine(id int) error
+
+	// NetTest requests that the leader probe whether the containers of the `from`
+	// label can reach those of the `to` label on `port` over `protocol`.
+	NetTest(from, to string, port int, protocol string) ([]api.ProbeResult, error)
+
+	// PromoteCanary requests that the Quilt daemon promote the canaries of `label`
+	// in the deployed Stitch, or remove them if `abort` is true.
+	PromoteCanary(label string, abort bool) error
+
 	// Deploy makes a request to the Quilt daemon to deploy the given deployment.
 	Deploy(deployment string) error
 
@@ -212,11 +252,154 @@ func (c clientImpl) QueryClusters() ([]db.Cluster, error) {
 	return rows.([]db.Cluster), nil
 }
 
+// QueryCounters retrieves the debugging counters tracked by the Quilt daemon.
+func (c clientImpl) QueryCounters() ([]pb.Counter, error) {
+	ctx, _ := context.WithTimeout(context.Background(), requestTimeout)
+	reply, err := c.pbClient.QueryCounters(ctx, &pb.CountersRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return derefCounters(reply.Counters), nil
+}
+
+// QueryMinionCounters retrieves the debugging counters tracked by the minion
+// running on the machine with the given public IP.
+func (c clientImpl) QueryMinionCounters(host string) ([]pb.Counter, error) {
+	ctx, _ := context.WithTimeout(context.Background(), requestTimeout)
+	reply, err := c.pbClient.QueryMinionCounters(ctx,
+		&pb.MinionCountersRequest{Host: host})
+	if err != nil {
+		return nil, err
+	}
+
+	return derefCounters(reply.Counters), nil
+}
+
+// InjectFault requests that the server inject the given failures.  It fails unless
+// the server has fault injection enabled.
+func (c clientImpl) InjectFault(fault faults.Fault) error {
+	ctx, _ := context.WithTimeout(context.Background(), requestTimeout)
+	_, err := c.pbClient.InjectFault(ctx, &pb.FaultRequest{
+		BootFailures:        int32(fault.BootFailures),
+		DropEtcdSyncs:       int32(fault.DropEtcdSyncs),
+		DelayedDockerStarts: int32(fault.DelayedDockerStarts),
+		DockerStartDelay:    int64(fault.DockerStartDelay),
+	})
+	return err
+}
+
+// FreezeMachines requests that the Quilt daemon stop booting and terminating
+// machines if `frozen` is true, or resume doing so otherwise.
+func (c clientImpl) FreezeMachines(frozen bool) error {
+	ctx, _ := context.WithTimeout(context.Background(), requestTimeout)
+	req := &pb.FreezeRequest{Frozen: frozen, IdempotencyKey: newIdempotencyKey()}
+	return retryIdempotent(func() error {
+		_, err := c.pbClient.FreezeMachines(ctx, req)
+		return err
+	})
+}
+
+// QueryStatus retrieves a summary of the health of each label's containers.
+func (c clientImpl) QueryStatus() ([]api.LabelStatus, error) {
+	ctx, _ := context.WithTimeout(context.Background(), requestTimeout)
+	reply, err := c.pbClient.QueryStatus(ctx, &pb.StatusRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []api.LabelStatus
+	if err := json.Unmarshal([]byte(reply.Labels), &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// DrainMachine requests that the Quilt daemon move the containers off of the machine
+// with the given database ID, and then terminate it.
+func (c clientImpl) DrainMachine(id int) error {
+	ctx, _ := context.WithTimeout(context.Background(), requestTimeout)
+	req := &pb.DrainRequest{ID: int32(id), IdempotencyKey: newIdempotencyKey()}
+	return retryIdempotent(func() error {
+		_, err := c.pbClient.DrainMachine(ctx, req)
+		return err
+	})
+}
+
+// NetTest requests that the leader probe whether the containers of the `from` label
+// can reach those of the `to` label on `port` over `protocol`.
+func (c clientImpl) NetTest(from, to string, port int, protocol string) (
+	[]api.ProbeResult, error) {
+
+	ctx, _ := context.WithTimeout(context.Background(), requestTimeout)
+	reply, err := c.pbClient.NetTest(ctx, &pb.NetTestRequest{
+		From:     from,
+		To:       to,
+		Port:     int32(port),
+		Protocol: protocol,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []api.ProbeResult
+	if err := json.Unmarshal([]byte(reply.Results), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// PromoteCanary requests that the Quilt daemon promote the canaries of `label` in the
+// deployed Stitch, or remove them if `abort` is true.
+func (c clientImpl) PromoteCanary(label string, abort bool) error {
+	ctx, _ := context.WithTimeout(context.Background(), requestTimeout)
+	req := &pb.CanaryRequest{
+		Label:          label,
+		Abort:          abort,
+		IdempotencyKey: newIdempotencyKey(),
+	}
+	return retryIdempotent(func() error {
+		_, err := c.pbClient.PromoteCanary(ctx, req)
+		return err
+	})
+}
+
+// newIdempotencyKey generates the key that identifies a request, and its retries,
+// to the daemon.
+var newIdempotencyKey = func() string {
+	return uuid.NewV4().String()
+}
+
+// retryIdempotent calls `send` until it succeeds, fails for a reason other than the
+// daemon being unavailable, or has been retried idempotentRetries times.
+func retryIdempotent(send func() error) error {
+	err := send()
+	for i := 0; i < idempotentRetries && grpc.Code(err) == codes.Unavailable; i++ {
+		time.Sleep(idempotentInterval)
+		err = send()
+	}
+	return err
+}
+
+func derefCounters(counters []*pb.Counter) []pb.Counter {
+	var res []pb.Counter
+	for _, c := range counters {
+		res = append(res, *c)
+	}
+	return res
+}
+
 // Deploy makes a request to the Quilt daemon to deploy the given deployment.
 func (c clientImpl) Deploy(deployment string) error {
 	ctx, _ := context.WithTimeout(context.Background(), requestTimeout)
-	_, err := c.pbClient.Deploy(ctx, &pb.DeployRequest{Deployment: deployment})
-	return err
+	req := &pb.DeployRequest{
+		Deployment:     deployment,
+		IdempotencyKey: newIdempotencyKey(),
+	}
+	return retryIdempotent(func() error {
+		_, err := c.pbClient.Deploy(ctx, req)
+		return err
+	})
 }
 
 func (c clientImpl) Host() string {
@@ -7,7 +7,9 @@ import (
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
+	"github.com/NetSys/quilt/api"
 	"github.com/NetSys/quilt/api/pb"
 	"github.com/NetSys/quilt/db"
 )
@@ -29,6 +31,55 @@ func (c mockAPIClient) Deploy(ctx context.Context, in *pb.DeployRequest,
 	return &pb.DeployReply{}, nil
 }
 
+func (c mockAPIClient) QueryCounters(ctx context.Context, in *pb.CountersRequest,
+	opts ...grpc.CallOption) (*pb.CountersReply, error) {
+
+	return &pb.CountersReply{}, nil
+}
+
+func (c mockAPIClient) QueryMinionCounters(ctx context.Context,
+	in *pb.MinionCountersRequest, opts ...grpc.CallOption) (*pb.CountersReply,
+	error) {
+
+	return &pb.CountersReply{}, nil
+}
+
+func (c mockAPIClient) InjectFault(ctx context.Context, in *pb.FaultRequest,
+	opts ...grpc.CallOption) (*pb.FaultReply, error) {
+
+	return &pb.FaultReply{}, nil
+}
+
+func (c mockAPIClient) FreezeMachines(ctx context.Context, in *pb.FreezeRequest,
+	opts ...grpc.CallOption) (*pb.FreezeReply, error) {
+
+	return &pb.FreezeReply{}, nil
+}
+
+func (c mockAPIClient) QueryStatus(ctx context.Context, in *pb.StatusRequest,
+	opts ...grpc.CallOption) (*pb.StatusReply, error) {
+
+	return &pb.StatusReply{Labels: c.mockResponse}, c.mockError
+}
+
+func (c mockAPIClient) DrainMachine(ctx context.Context, in *pb.DrainRequest,
+	opts ...grpc.CallOption) (*pb.DrainReply, error) {
+
+	return &pb.DrainReply{}, nil
+}
+
+func (c mockAPIClient) NetTest(ctx context.Context, in *pb.NetTestRequest,
+	opts ...grpc.CallOption) (*pb.NetTestReply, error) {
+
+	return &pb.NetTestReply{Results: c.mockResponse}, c.mockError
+}
+
+func (c mockAPIClient) PromoteCanary(ctx context.Context, in *pb.CanaryRequest,
+	opts ...grpc.CallOption) (*pb.CanaryReply, error) {
+
+	return &pb.CanaryReply{}, nil
+}
+
 func TestUnmarshalMachine(t *testing.T) {
 	t.Parallel()
 
@@ -93,6 +144,69 @@ func TestUnmarshalContainer(t *testing.T) {
 	}
 }
 
+func TestUnmarshalStatus(t *testing.T) {
+	t.Parallel()
+
+	apiClient := mockAPIClient{
+		mockResponse: `[{"Label":"web","Desired":2,"Running":1,"Unhealthy":0,` +
+			`"Machines":1,"PublicPorts":[80],"PlacementFailure":"no minions"}]`,
+	}
+	c := clientImpl{pbClient: apiClient}
+	res, err := c.QueryStatus()
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	exp := []api.LabelStatus{
+		{
+			Label:            "web",
+			Desired:          2,
+			Running:          1,
+			Machines:         1,
+			PublicPorts:      []int{80},
+			PlacementFailure: "no minions",
+		},
+	}
+
+	if !reflect.DeepEqual(exp, res) {
+		t.Errorf("Bad unmarshalling of statuses: expected %v, got %v.",
+			exp, res)
+	}
+}
+
+func TestUnmarshalNetTest(t *testing.T) {
+	t.Parallel()
+
+	apiClient := mockAPIClient{
+		mockResponse: `[{"FromIP":"10.0.0.2","ToHostname":"1.db.q",` +
+			`"ToIP":"10.0.0.3","Hops":[{"Name":"dns","OK":true,` +
+			`"Detail":"1.db.q is 10.0.0.3"}],"Error":""}]`,
+	}
+	c := clientImpl{pbClient: apiClient}
+	res, err := c.NetTest("web", "db", 5432, "tcp")
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	exp := []api.ProbeResult{
+		{
+			FromIP:     "10.0.0.2",
+			ToHostname: "1.db.q",
+			ToIP:       "10.0.0.3",
+			Hops: []api.ProbeHop{
+				{Name: "dns", OK: true, Detail: "1.db.q is 10.0.0.3"},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(exp, res) {
+		t.Errorf("Bad unmarshalling of probe results: expected %v, got %v.",
+			exp, res)
+	}
+}
+
 func TestUnmarshalError(t *testing.T) {
 	t.Parallel()
 
@@ -131,3 +245,55 @@ func TestGrpcError(t *testing.T) {
 			exp.Error(), err.Error())
 	}
 }
+
+// deployClient fails the first `unavailable` deploys as if the daemon were
+// unreachable, and records the idempotency key of each.
+type deployClient struct {
+	mockAPIClient
+	unavailable int
+	keys        []string
+}
+
+func (c *deployClient) Deploy(ctx context.Context, in *pb.DeployRequest,
+	opts ...grpc.CallOption) (*pb.DeployReply, error) {
+
+	c.keys = append(c.keys, in.IdempotencyKey)
+	if len(c.keys) <= c.unavailable {
+		return nil, grpc.Errorf(codes.Unavailable, "transport is closing")
+	}
+	return &pb.DeployReply{}, nil
+}
+
+func TestDeployIdempotencyKey(t *testing.T) {
+	t.Parallel()
+
+	// Retries of a deploy reuse its key.
+	apiClient := &deployClient{unavailable: 1}
+	c := clientImpl{pbClient: apiClient}
+	if err := c.Deploy("spec"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(apiClient.keys) != 2 || apiClient.keys[0] == "" ||
+		apiClient.keys[0] != apiClient.keys[1] {
+		t.Errorf("expected a key sent twice, got %v", apiClient.keys)
+	}
+
+	// Other deploys get their own key.
+	if err := c.Deploy("spec"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(apiClient.keys) != 3 || apiClient.keys[2] == apiClient.keys[0] {
+		t.Errorf("expected a new key, got %v", apiClient.keys)
+	}
+
+	// The retries give up eventually.
+	apiClient = &deployClient{unavailable: idempotentRetries + 1}
+	c = clientImpl{pbClient: apiClient}
+	if err := c.Deploy("spec"); grpc.Code(err) != codes.Unavailable {
+		t.Errorf("expected the daemon to be unavailable, got %v", err)
+	}
+	if len(apiClient.keys) != idempotentRetries+1 {
+		t.Errorf("expected %d attempts, got %d", idempotentRetries+1,
+			len(apiClient.keys))
+	}
+}
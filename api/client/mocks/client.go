@@ -1,7 +1,10 @@
 package mocks
 
 import (
+	"github.com/NetSys/quilt/api"
+	"github.com/NetSys/quilt/api/pb"
 	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/faults"
 )
 
 // Client implements a mocked version of a Quilt client.
@@ -13,6 +16,29 @@ type Client struct {
 	HostReturn      string
 	DeployArg       string
 
+	CountersReturn       []pb.Counter
+	MinionCountersReturn map[string][]pb.Counter
+	CountersErr          error
+
+	InjectFaultArgs []faults.Fault
+	InjectFaultErr  error
+
+	FreezeArgs []bool
+	FreezeErr  error
+
+	StatusReturn []api.LabelStatus
+	StatusErr    error
+
+	DrainArgs []int
+	DrainErr  error
+
+	NetTestReturn []api.ProbeResult
+	NetTestErr    error
+
+	PromoteCanaryArgs []string
+	AbortCanaryArgs   []string
+	PromoteCanaryErr  error
+
 	MachineErr, ContainerErr, EtcdErr, ClusterErr, HostErr, DeployErr error
 }
 
@@ -59,6 +85,86 @@ func (c *Client) QueryClusters() ([]db.Cluster, error) {
 	return c.ClusterReturn, nil
 }
 
+// QueryCounters retrieves the debugging counters tracked by the Quilt daemon.
+func (c *Client) QueryCounters() ([]pb.Counter, error) {
+	if c.CountersErr != nil {
+		return nil, c.CountersErr
+	}
+	return c.CountersReturn, nil
+}
+
+// QueryMinionCounters retrieves the debugging counters tracked by the minion
+// running on the machine with the given public IP.
+func (c *Client) QueryMinionCounters(host string) ([]pb.Counter, error) {
+	if c.CountersErr != nil {
+		return nil, c.CountersErr
+	}
+	return c.MinionCountersReturn[host], nil
+}
+
+// InjectFault requests that the server inject the given failures.
+func (c *Client) InjectFault(fault faults.Fault) error {
+	if c.InjectFaultErr != nil {
+		return c.InjectFaultErr
+	}
+	c.InjectFaultArgs = append(c.InjectFaultArgs, fault)
+	return nil
+}
+
+// FreezeMachines requests that the Quilt daemon stop booting and terminating
+// machines if `frozen` is true, or resume doing so otherwise.
+func (c *Client) FreezeMachines(frozen bool) error {
+	if c.FreezeErr != nil {
+		return c.FreezeErr
+	}
+	c.FreezeArgs = append(c.FreezeArgs, frozen)
+	return nil
+}
+
+// QueryStatus retrieves a summary of the health of each label's containers.
+func (c *Client) QueryStatus() ([]api.LabelStatus, error) {
+	if c.StatusErr != nil {
+		return nil, c.StatusErr
+	}
+	return c.StatusReturn, nil
+}
+
+// DrainMachine requests that the Quilt daemon move the containers off of the
+// machine with the given database ID, and then terminate it.
+func (c *Client) DrainMachine(id int) error {
+	if c.DrainErr != nil {
+		return c.DrainErr
+	}
+	c.DrainArgs = append(c.DrainArgs, id)
+	return nil
+}
+
+// NetTest requests that the leader probe whether the containers of the `from`
+// label can reach those of the `to` label on `port` over `protocol`.
+func (c *Client) NetTest(from, to string, port int, protocol string) (
+	[]api.ProbeResult, error) {
+
+	if c.NetTestErr != nil {
+		return nil, c.NetTestErr
+	}
+	return c.NetTestReturn, nil
+}
+
+// PromoteCanary requests that the Quilt daemon promote the canaries of `label` in the
+// deployed Stitch, or remove them if `abort` is true.
+func (c *Client) PromoteCanary(label string, abort bool) error {
+	if c.PromoteCanaryErr != nil {
+		return c.PromoteCanaryErr
+	}
+
+	if abort {
+		c.AbortCanaryArgs = append(c.AbortCanaryArgs, label)
+	} else {
+		c.PromoteCanaryArgs = append(c.PromoteCanaryArgs, label)
+	}
+	return nil
+}
+
 // Close the grpc connection.
 func (c *Client) Close() error {
 	return nil
@@ -0,0 +1,38 @@
+package api
+
+// A ProbeHop is the outcome of one step of a connectivity probe from one container to
+// another: resolving the destination's hostname, routing to it, or connecting to it.
+type ProbeHop struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// A ProbeResult is the outcome of a connectivity probe from one container to
+// another.
+type ProbeResult struct {
+	FromIP string
+
+	ToHostname string // The hostname the source container knows the destination by.
+	ToIP       string
+
+	Hops []ProbeHop
+
+	// Why the probe couldn't be run at all, e.g. because the source container's
+	// minion is unreachable.
+	Error string
+}
+
+// OK returns whether every hop of the probe succeeded.
+func (result ProbeResult) OK() bool {
+	if result.Error != "" {
+		return false
+	}
+
+	for _, hop := range result.Hops {
+		if !hop.OK {
+			return false
+		}
+	}
+	return true
+}
@@ -13,6 +13,22 @@ It has these top-level messages:
 	QueryReply
 	DeployRequest
 	DeployReply
+	CountersRequest
+	MinionCountersRequest
+	Counter
+	CountersReply
+	FaultRequest
+	FaultReply
+	FreezeRequest
+	FreezeReply
+	StatusRequest
+	StatusReply
+	DrainRequest
+	DrainReply
+	NetTestRequest
+	NetTestReply
+	CanaryRequest
+	CanaryReply
 */
 package pb
 
@@ -55,7 +71,8 @@ func (*QueryReply) ProtoMessage()               {}
 func (*QueryReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
 
 type DeployRequest struct {
-	Deployment string `protobuf:"bytes,1,opt,name=Deployment,json=deployment" json:"Deployment,omitempty"`
+	Deployment     string `protobuf:"bytes,1,opt,name=Deployment,json=deployment" json:"Deployment,omitempty"`
+	IdempotencyKey string `protobuf:"bytes,2,opt,name=IdempotencyKey,json=idempotencyKey" json:"IdempotencyKey,omitempty"`
 }
 
 func (m *DeployRequest) Reset()                    { *m = DeployRequest{} }
@@ -71,11 +88,185 @@ func (m *DeployReply) String() string            { return proto.CompactTextStrin
 func (*DeployReply) ProtoMessage()               {}
 func (*DeployReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
 
+type CountersRequest struct {
+}
+
+func (m *CountersRequest) Reset()                    { *m = CountersRequest{} }
+func (m *CountersRequest) String() string            { return proto.CompactTextString(m) }
+func (*CountersRequest) ProtoMessage()               {}
+func (*CountersRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+type MinionCountersRequest struct {
+	Host string `protobuf:"bytes,1,opt,name=Host,json=host" json:"Host,omitempty"`
+}
+
+func (m *MinionCountersRequest) Reset()                    { *m = MinionCountersRequest{} }
+func (m *MinionCountersRequest) String() string            { return proto.CompactTextString(m) }
+func (*MinionCountersRequest) ProtoMessage()               {}
+func (*MinionCountersRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+type Counter struct {
+	Pkg       string `protobuf:"bytes,1,opt,name=Pkg,json=pkg" json:"Pkg,omitempty"`
+	Name      string `protobuf:"bytes,2,opt,name=Name,json=name" json:"Name,omitempty"`
+	Value     uint64 `protobuf:"varint,3,opt,name=Value,json=value" json:"Value,omitempty"`
+	PrevValue uint64 `protobuf:"varint,4,opt,name=PrevValue,json=prevValue" json:"PrevValue,omitempty"`
+}
+
+func (m *Counter) Reset()                    { *m = Counter{} }
+func (m *Counter) String() string            { return proto.CompactTextString(m) }
+func (*Counter) ProtoMessage()               {}
+func (*Counter) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+type CountersReply struct {
+	Counters []*Counter `protobuf:"bytes,1,rep,name=Counters,json=counters" json:"Counters,omitempty"`
+}
+
+func (m *CountersReply) Reset()                    { *m = CountersReply{} }
+func (m *CountersReply) String() string            { return proto.CompactTextString(m) }
+func (*CountersReply) ProtoMessage()               {}
+func (*CountersReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *CountersReply) GetCounters() []*Counter {
+	if m != nil {
+		return m.Counters
+	}
+	return nil
+}
+
+type FaultRequest struct {
+	BootFailures        int32 `protobuf:"varint,1,opt,name=BootFailures,json=bootFailures" json:"BootFailures,omitempty"`
+	DropEtcdSyncs       int32 `protobuf:"varint,2,opt,name=DropEtcdSyncs,json=dropEtcdSyncs" json:"DropEtcdSyncs,omitempty"`
+	DelayedDockerStarts int32 `protobuf:"varint,3,opt,name=DelayedDockerStarts,json=delayedDockerStarts" json:"DelayedDockerStarts,omitempty"`
+	DockerStartDelay    int64 `protobuf:"varint,4,opt,name=DockerStartDelay,json=dockerStartDelay" json:"DockerStartDelay,omitempty"`
+}
+
+func (m *FaultRequest) Reset()                    { *m = FaultRequest{} }
+func (m *FaultRequest) String() string            { return proto.CompactTextString(m) }
+func (*FaultRequest) ProtoMessage()               {}
+func (*FaultRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+type FaultReply struct {
+}
+
+func (m *FaultReply) Reset()                    { *m = FaultReply{} }
+func (m *FaultReply) String() string            { return proto.CompactTextString(m) }
+func (*FaultReply) ProtoMessage()               {}
+func (*FaultReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+type FreezeRequest struct {
+	Frozen         bool   `protobuf:"varint,1,opt,name=Frozen,json=frozen" json:"Frozen,omitempty"`
+	IdempotencyKey string `protobuf:"bytes,2,opt,name=IdempotencyKey,json=idempotencyKey" json:"IdempotencyKey,omitempty"`
+}
+
+func (m *FreezeRequest) Reset()                    { *m = FreezeRequest{} }
+func (m *FreezeRequest) String() string            { return proto.CompactTextString(m) }
+func (*FreezeRequest) ProtoMessage()               {}
+func (*FreezeRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+type FreezeReply struct {
+}
+
+func (m *FreezeReply) Reset()                    { *m = FreezeReply{} }
+func (m *FreezeReply) String() string            { return proto.CompactTextString(m) }
+func (*FreezeReply) ProtoMessage()               {}
+func (*FreezeReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+type StatusRequest struct {
+}
+
+func (m *StatusRequest) Reset()                    { *m = StatusRequest{} }
+func (m *StatusRequest) String() string            { return proto.CompactTextString(m) }
+func (*StatusRequest) ProtoMessage()               {}
+func (*StatusRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+type StatusReply struct {
+	Labels string `protobuf:"bytes,1,opt,name=Labels,json=labels" json:"Labels,omitempty"`
+}
+
+func (m *StatusReply) Reset()                    { *m = StatusReply{} }
+func (m *StatusReply) String() string            { return proto.CompactTextString(m) }
+func (*StatusReply) ProtoMessage()               {}
+func (*StatusReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+type DrainRequest struct {
+	ID             int32  `protobuf:"varint,1,opt,name=ID,json=iD" json:"ID,omitempty"`
+	IdempotencyKey string `protobuf:"bytes,2,opt,name=IdempotencyKey,json=idempotencyKey" json:"IdempotencyKey,omitempty"`
+}
+
+func (m *DrainRequest) Reset()                    { *m = DrainRequest{} }
+func (m *DrainRequest) String() string            { return proto.CompactTextString(m) }
+func (*DrainRequest) ProtoMessage()               {}
+func (*DrainRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+type DrainReply struct {
+}
+
+func (m *DrainReply) Reset()                    { *m = DrainReply{} }
+func (m *DrainReply) String() string            { return proto.CompactTextString(m) }
+func (*DrainReply) ProtoMessage()               {}
+func (*DrainReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+type NetTestRequest struct {
+	From     string `protobuf:"bytes,1,opt,name=From,json=from" json:"From,omitempty"`
+	To       string `protobuf:"bytes,2,opt,name=To,json=to" json:"To,omitempty"`
+	Port     int32  `protobuf:"varint,3,opt,name=Port,json=port" json:"Port,omitempty"`
+	Protocol string `protobuf:"bytes,4,opt,name=Protocol,json=protocol" json:"Protocol,omitempty"`
+}
+
+func (m *NetTestRequest) Reset()                    { *m = NetTestRequest{} }
+func (m *NetTestRequest) String() string            { return proto.CompactTextString(m) }
+func (*NetTestRequest) ProtoMessage()               {}
+func (*NetTestRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+
+type NetTestReply struct {
+	Results string `protobuf:"bytes,1,opt,name=Results,json=results" json:"Results,omitempty"`
+}
+
+func (m *NetTestReply) Reset()                    { *m = NetTestReply{} }
+func (m *NetTestReply) String() string            { return proto.CompactTextString(m) }
+func (*NetTestReply) ProtoMessage()               {}
+func (*NetTestReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
+
+type CanaryRequest struct {
+	Label          string `protobuf:"bytes,1,opt,name=Label,json=label" json:"Label,omitempty"`
+	Abort          bool   `protobuf:"varint,2,opt,name=Abort,json=abort" json:"Abort,omitempty"`
+	IdempotencyKey string `protobuf:"bytes,3,opt,name=IdempotencyKey,json=idempotencyKey" json:"IdempotencyKey,omitempty"`
+}
+
+func (m *CanaryRequest) Reset()                    { *m = CanaryRequest{} }
+func (m *CanaryRequest) String() string            { return proto.CompactTextString(m) }
+func (*CanaryRequest) ProtoMessage()               {}
+func (*CanaryRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
+
+type CanaryReply struct {
+}
+
+func (m *CanaryReply) Reset()                    { *m = CanaryReply{} }
+func (m *CanaryReply) String() string            { return proto.CompactTextString(m) }
+func (*CanaryReply) ProtoMessage()               {}
+func (*CanaryReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
 func init() {
 	proto.RegisterType((*DBQuery)(nil), "DBQuery")
 	proto.RegisterType((*QueryReply)(nil), "QueryReply")
 	proto.RegisterType((*DeployRequest)(nil), "DeployRequest")
 	proto.RegisterType((*DeployReply)(nil), "DeployReply")
+	proto.RegisterType((*CountersRequest)(nil), "CountersRequest")
+	proto.RegisterType((*MinionCountersRequest)(nil), "MinionCountersRequest")
+	proto.RegisterType((*Counter)(nil), "Counter")
+	proto.RegisterType((*CountersReply)(nil), "CountersReply")
+	proto.RegisterType((*FaultRequest)(nil), "FaultRequest")
+	proto.RegisterType((*FaultReply)(nil), "FaultReply")
+	proto.RegisterType((*FreezeRequest)(nil), "FreezeRequest")
+	proto.RegisterType((*FreezeReply)(nil), "FreezeReply")
+	proto.RegisterType((*StatusRequest)(nil), "StatusRequest")
+	proto.RegisterType((*StatusReply)(nil), "StatusReply")
+	proto.RegisterType((*DrainRequest)(nil), "DrainRequest")
+	proto.RegisterType((*DrainReply)(nil), "DrainReply")
+	proto.RegisterType((*NetTestRequest)(nil), "NetTestRequest")
+	proto.RegisterType((*NetTestReply)(nil), "NetTestReply")
+	proto.RegisterType((*CanaryRequest)(nil), "CanaryRequest")
+	proto.RegisterType((*CanaryReply)(nil), "CanaryReply")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -91,6 +282,14 @@ const _ = grpc.SupportPackageIsVersion3
 type APIClient interface {
 	Query(ctx context.Context, in *DBQuery, opts ...grpc.CallOption) (*QueryReply, error)
 	Deploy(ctx context.Context, in *DeployRequest, opts ...grpc.CallOption) (*DeployReply, error)
+	QueryCounters(ctx context.Context, in *CountersRequest, opts ...grpc.CallOption) (*CountersReply, error)
+	QueryMinionCounters(ctx context.Context, in *MinionCountersRequest, opts ...grpc.CallOption) (*CountersReply, error)
+	InjectFault(ctx context.Context, in *FaultRequest, opts ...grpc.CallOption) (*FaultReply, error)
+	FreezeMachines(ctx context.Context, in *FreezeRequest, opts ...grpc.CallOption) (*FreezeReply, error)
+	QueryStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusReply, error)
+	DrainMachine(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainReply, error)
+	NetTest(ctx context.Context, in *NetTestRequest, opts ...grpc.CallOption) (*NetTestReply, error)
+	PromoteCanary(ctx context.Context, in *CanaryRequest, opts ...grpc.CallOption) (*CanaryReply, error)
 }
 
 type aPIClient struct {
@@ -119,11 +318,91 @@ func (c *aPIClient) Deploy(ctx context.Context, in *DeployRequest, opts ...grpc.
 	return out, nil
 }
 
+func (c *aPIClient) QueryCounters(ctx context.Context, in *CountersRequest, opts ...grpc.CallOption) (*CountersReply, error) {
+	out := new(CountersReply)
+	err := grpc.Invoke(ctx, "/API/QueryCounters", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *aPIClient) QueryMinionCounters(ctx context.Context, in *MinionCountersRequest, opts ...grpc.CallOption) (*CountersReply, error) {
+	out := new(CountersReply)
+	err := grpc.Invoke(ctx, "/API/QueryMinionCounters", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *aPIClient) InjectFault(ctx context.Context, in *FaultRequest, opts ...grpc.CallOption) (*FaultReply, error) {
+	out := new(FaultReply)
+	err := grpc.Invoke(ctx, "/API/InjectFault", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *aPIClient) FreezeMachines(ctx context.Context, in *FreezeRequest, opts ...grpc.CallOption) (*FreezeReply, error) {
+	out := new(FreezeReply)
+	err := grpc.Invoke(ctx, "/API/FreezeMachines", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *aPIClient) QueryStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusReply, error) {
+	out := new(StatusReply)
+	err := grpc.Invoke(ctx, "/API/QueryStatus", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *aPIClient) DrainMachine(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainReply, error) {
+	out := new(DrainReply)
+	err := grpc.Invoke(ctx, "/API/DrainMachine", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *aPIClient) NetTest(ctx context.Context, in *NetTestRequest, opts ...grpc.CallOption) (*NetTestReply, error) {
+	out := new(NetTestReply)
+	err := grpc.Invoke(ctx, "/API/NetTest", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *aPIClient) PromoteCanary(ctx context.Context, in *CanaryRequest, opts ...grpc.CallOption) (*CanaryReply, error) {
+	out := new(CanaryReply)
+	err := grpc.Invoke(ctx, "/API/PromoteCanary", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for API service
 
 type APIServer interface {
 	Query(context.Context, *DBQuery) (*QueryReply, error)
 	Deploy(context.Context, *DeployRequest) (*DeployReply, error)
+	QueryCounters(context.Context, *CountersRequest) (*CountersReply, error)
+	QueryMinionCounters(context.Context, *MinionCountersRequest) (*CountersReply, error)
+	InjectFault(context.Context, *FaultRequest) (*FaultReply, error)
+	FreezeMachines(context.Context, *FreezeRequest) (*FreezeReply, error)
+	QueryStatus(context.Context, *StatusRequest) (*StatusReply, error)
+	DrainMachine(context.Context, *DrainRequest) (*DrainReply, error)
+	NetTest(context.Context, *NetTestRequest) (*NetTestReply, error)
+	PromoteCanary(context.Context, *CanaryRequest) (*CanaryReply, error)
 }
 
 func RegisterAPIServer(s *grpc.Server, srv APIServer) {
@@ -166,6 +445,150 @@ func _API_Deploy_Handler(srv interface{}, ctx context.Context, dec func(interfac
 	return interceptor(ctx, in, info, handler)
 }
 
+func _API_QueryCounters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).QueryCounters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/API/QueryCounters",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).QueryCounters(ctx, req.(*CountersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _API_QueryMinionCounters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MinionCountersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).QueryMinionCounters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/API/QueryMinionCounters",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).QueryMinionCounters(ctx, req.(*MinionCountersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _API_InjectFault_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FaultRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).InjectFault(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/API/InjectFault",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).InjectFault(ctx, req.(*FaultRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _API_FreezeMachines_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FreezeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).FreezeMachines(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/API/FreezeMachines",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).FreezeMachines(ctx, req.(*FreezeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _API_QueryStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).QueryStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/API/QueryStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).QueryStatus(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _API_DrainMachine_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).DrainMachine(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/API/DrainMachine",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).DrainMachine(ctx, req.(*DrainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _API_NetTest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NetTestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).NetTest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/API/NetTest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).NetTest(ctx, req.(*NetTestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _API_PromoteCanary_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CanaryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).PromoteCanary(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/API/PromoteCanary",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).PromoteCanary(ctx, req.(*CanaryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _API_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "API",
 	HandlerType: (*APIServer)(nil),
@@ -178,6 +601,38 @@ var _API_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Deploy",
 			Handler:    _API_Deploy_Handler,
 		},
+		{
+			MethodName: "QueryCounters",
+			Handler:    _API_QueryCounters_Handler,
+		},
+		{
+			MethodName: "QueryMinionCounters",
+			Handler:    _API_QueryMinionCounters_Handler,
+		},
+		{
+			MethodName: "InjectFault",
+			Handler:    _API_InjectFault_Handler,
+		},
+		{
+			MethodName: "FreezeMachines",
+			Handler:    _API_FreezeMachines_Handler,
+		},
+		{
+			MethodName: "QueryStatus",
+			Handler:    _API_QueryStatus_Handler,
+		},
+		{
+			MethodName: "DrainMachine",
+			Handler:    _API_DrainMachine_Handler,
+		},
+		{
+			MethodName: "NetTest",
+			Handler:    _API_NetTest_Handler,
+		},
+		{
+			MethodName: "PromoteCanary",
+			Handler:    _API_PromoteCanary_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: fileDescriptor0,
@@ -186,17 +641,53 @@ var _API_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("pb/pb.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 189 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xe2, 0xe2, 0x2e, 0x48, 0xd2, 0x2f,
-	0x48, 0xd2, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x57, 0x92, 0xe7, 0x62, 0x77, 0x71, 0x0a, 0x2c, 0x4d,
-	0x2d, 0xaa, 0x14, 0x12, 0xe1, 0x62, 0x0d, 0x49, 0x4c, 0xca, 0x49, 0x95, 0x60, 0x54, 0x60, 0xd4,
-	0xe0, 0x0c, 0x62, 0x2d, 0x01, 0x71, 0x94, 0x8c, 0xb8, 0xb8, 0xc0, 0xd2, 0x41, 0xa9, 0x05, 0x39,
-	0x95, 0x42, 0x2a, 0x5c, 0xbc, 0x60, 0x35, 0xce, 0xf9, 0x79, 0x25, 0xa9, 0x79, 0x25, 0xc5, 0x50,
-	0xb5, 0xbc, 0x25, 0xc8, 0x82, 0x4a, 0xfa, 0x5c, 0xbc, 0x2e, 0xa9, 0x05, 0x39, 0xf9, 0x95, 0x41,
-	0xa9, 0x85, 0xa5, 0xa9, 0xc5, 0x25, 0x42, 0x72, 0x5c, 0x5c, 0x10, 0x81, 0xdc, 0xd4, 0xbc, 0x12,
-	0xa8, 0x1e, 0xae, 0x14, 0xb8, 0x88, 0x12, 0x2f, 0x17, 0x37, 0x4c, 0x43, 0x41, 0x4e, 0xa5, 0x51,
-	0x20, 0x17, 0xb3, 0x63, 0x80, 0xa7, 0x90, 0x02, 0x17, 0x2b, 0xc4, 0x65, 0x1c, 0x7a, 0x50, 0x37,
-	0x4a, 0x71, 0xeb, 0x21, 0x1c, 0xa3, 0xc4, 0x20, 0xa4, 0xc1, 0xc5, 0x06, 0xd1, 0x27, 0xc4, 0xa7,
-	0x87, 0x62, 0xa3, 0x14, 0x8f, 0x1e, 0x92, 0x81, 0x4a, 0x0c, 0x49, 0x6c, 0x60, 0xef, 0x1a, 0x03,
-	0x02, 0x00, 0x00, 0xff, 0xff, 0x52, 0x06, 0x87, 0x98, 0xfd, 0x00, 0x00, 0x00,
+	// 761 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x03, 0x94, 0x94, 0x6f, 0x6f, 0xfb, 0x34,
+	0x10, 0xc7, 0xd3, 0xa6, 0xe9, 0x9f, 0xcb, 0x9f, 0x15, 0xef, 0xc7, 0x54, 0x45, 0x08, 0x2a, 0x0b,
+	0x50, 0xc5, 0xc0, 0x9b, 0x36, 0xf1, 0x18, 0x6d, 0x0b, 0x15, 0x15, 0x6c, 0x94, 0x6c, 0x82, 0xc7,
+	0x69, 0xe2, 0x6d, 0x65, 0x69, 0x1c, 0x1c, 0x67, 0x52, 0xf6, 0xc2, 0x78, 0x3f, 0xbc, 0x13, 0x64,
+	0xc7, 0xe9, 0x92, 0x31, 0x24, 0x78, 0x78, 0xdf, 0xb3, 0xcf, 0xe7, 0xbb, 0xcf, 0x1d, 0xd8, 0xf9,
+	0xe6, 0x24, 0xdf, 0x90, 0x9c, 0x33, 0xc1, 0xf0, 0x67, 0x30, 0x0a, 0x2e, 0x7f, 0x29, 0x29, 0xaf,
+	0xd0, 0x07, 0xb0, 0xee, 0xa2, 0x4d, 0x4a, 0x67, 0xbd, 0x79, 0x6f, 0x31, 0x09, 0x2d, 0x21, 0x0d,
+	0x7c, 0x06, 0xa0, 0xdc, 0x21, 0xcd, 0xd3, 0x0a, 0x7d, 0x0e, 0xae, 0x3a, 0x73, 0xc5, 0x32, 0x41,
+	0x33, 0x51, 0xe8, 0xb3, 0xae, 0x68, 0x8b, 0xf8, 0x37, 0x70, 0x03, 0x9a, 0xa7, 0xac, 0x0a, 0xe9,
+	0x1f, 0x25, 0x2d, 0x04, 0xfa, 0x14, 0xa0, 0x16, 0x76, 0x34, 0x13, 0xfa, 0x0e, 0x24, 0x7b, 0x05,
+	0x7d, 0x09, 0xde, 0x2a, 0xa1, 0xbb, 0x9c, 0x09, 0x9a, 0xc5, 0xd5, 0x8f, 0xb4, 0x9a, 0xf5, 0xd5,
+	0x19, 0x6f, 0xdb, 0x51, 0xb1, 0x0b, 0x76, 0x13, 0x38, 0x4f, 0x2b, 0xfc, 0x11, 0x1c, 0x5c, 0xb1,
+	0x32, 0x13, 0x94, 0x17, 0xfa, 0x25, 0x7c, 0x0c, 0x1f, 0x5f, 0x6f, 0xb3, 0x2d, 0xcb, 0xde, 0x38,
+	0x10, 0x82, 0xc1, 0x0f, 0xac, 0x68, 0x1e, 0x1f, 0x3c, 0xb2, 0x42, 0xe0, 0x18, 0x46, 0xfa, 0x18,
+	0x9a, 0x82, 0xb9, 0x7e, 0x7a, 0xd0, 0x5e, 0x33, 0x7f, 0x7a, 0x90, 0x17, 0x6e, 0xa2, 0x1d, 0xd5,
+	0x99, 0x0c, 0xb2, 0x68, 0x47, 0x65, 0x89, 0x7e, 0x8d, 0xd2, 0x92, 0xce, 0xcc, 0x79, 0x6f, 0x31,
+	0x08, 0xad, 0x67, 0x69, 0xa0, 0x4f, 0x60, 0xb2, 0xe6, 0xf4, 0xb9, 0xf6, 0x0c, 0x94, 0x67, 0x92,
+	0x37, 0x02, 0xfe, 0x16, 0xdc, 0xd7, 0x5c, 0xea, 0x1a, 0x8e, 0x1b, 0x61, 0xd6, 0x9b, 0x9b, 0x0b,
+	0xfb, 0x6c, 0x4c, 0xb4, 0x10, 0x8e, 0x63, 0xed, 0xc1, 0x7f, 0xf6, 0xc0, 0x59, 0x46, 0x65, 0x2a,
+	0x9a, 0x0f, 0x60, 0x70, 0x2e, 0x19, 0x13, 0xcb, 0x68, 0x9b, 0x96, 0x9c, 0xd6, 0x95, 0xb7, 0x42,
+	0x67, 0xd3, 0xd2, 0x64, 0x7b, 0x02, 0xce, 0xf2, 0xef, 0x45, 0x9c, 0xdc, 0x56, 0x59, 0x5c, 0xa8,
+	0xe4, 0xad, 0xd0, 0x4d, 0xda, 0x22, 0x3a, 0x85, 0xc3, 0x80, 0xa6, 0x51, 0x45, 0x93, 0x80, 0xc5,
+	0x4f, 0x94, 0xdf, 0x8a, 0x88, 0x8b, 0x42, 0xfd, 0xc9, 0x0a, 0x0f, 0x93, 0x7f, 0xba, 0xd0, 0x57,
+	0x30, 0x6d, 0xd9, 0xea, 0xb2, 0xfa, 0xa8, 0x19, 0x4e, 0x93, 0x37, 0x3a, 0x76, 0x00, 0x74, 0xde,
+	0xb2, 0x45, 0x3f, 0x83, 0xbb, 0xe4, 0x94, 0xbe, 0xd0, 0xe6, 0x1b, 0x47, 0x30, 0x5c, 0x72, 0xf6,
+	0x42, 0x33, 0xf5, 0x81, 0x71, 0x38, 0xbc, 0x57, 0xd6, 0xff, 0x41, 0xa0, 0x09, 0x28, 0xe3, 0x1f,
+	0x80, 0x7b, 0x2b, 0x22, 0x51, 0xee, 0x01, 0xf8, 0x02, 0xec, 0x46, 0x90, 0xc5, 0x3e, 0x82, 0xe1,
+	0x4f, 0xd1, 0x86, 0xa6, 0x0d, 0xa9, 0xc3, 0x54, 0x59, 0x78, 0x09, 0x4e, 0xc0, 0xa3, 0x6d, 0xd6,
+	0xa4, 0xe5, 0x41, 0x7f, 0x15, 0xe8, 0x9a, 0xf6, 0xb7, 0xc1, 0x7f, 0x4e, 0xc7, 0x01, 0xd0, 0x71,
+	0x64, 0x36, 0x09, 0x78, 0x37, 0x54, 0xdc, 0xd1, 0x42, 0xb4, 0xb0, 0x5b, 0x72, 0xb6, 0x6b, 0xb0,
+	0xbb, 0xe7, 0x6c, 0x27, 0xdf, 0xba, 0x63, 0x3a, 0x5e, 0x5f, 0x30, 0x79, 0x66, 0xcd, 0xb8, 0xd0,
+	0x0d, 0x18, 0xe4, 0x8c, 0x0b, 0xe4, 0xc3, 0x78, 0x2d, 0x07, 0x34, 0x66, 0xa9, 0xaa, 0xf4, 0x24,
+	0x1c, 0xe7, 0xda, 0xc6, 0x0b, 0x70, 0xf6, 0xaf, 0xc8, 0x3f, 0xce, 0x60, 0x14, 0xd2, 0xa2, 0x4c,
+	0xf7, 0xe3, 0x38, 0xe2, 0xb5, 0x89, 0x63, 0x70, 0xaf, 0xa2, 0x2c, 0xe2, 0xfb, 0x41, 0xfc, 0x00,
+	0x96, 0x2a, 0x47, 0x33, 0xe3, 0xaa, 0x1a, 0x52, 0xbd, 0xd8, 0xc8, 0x0c, 0xfa, 0xaa, 0x25, 0x56,
+	0x24, 0x8d, 0x77, 0x4a, 0x60, 0xfe, 0x5b, 0x47, 0x9a, 0x47, 0xf2, 0xb4, 0x3a, 0xfb, 0xcb, 0x04,
+	0xf3, 0x62, 0xbd, 0x42, 0x73, 0xb0, 0xea, 0xbd, 0x32, 0x26, 0x7a, 0xc3, 0xf8, 0x36, 0x79, 0x5d,
+	0x25, 0xd8, 0x40, 0x0b, 0x18, 0xd6, 0xd3, 0x8c, 0x3c, 0xd2, 0xd9, 0x17, 0xbe, 0x43, 0xda, 0x63,
+	0x6e, 0xa0, 0x73, 0x70, 0xd5, 0xcd, 0x66, 0x6e, 0xd0, 0x94, 0xbc, 0x99, 0x6f, 0xdf, 0x23, 0x9d,
+	0x29, 0xc3, 0x06, 0xfa, 0x0e, 0x0e, 0xd5, 0xa5, 0xee, 0x3e, 0x40, 0x47, 0xe4, 0xdd, 0x05, 0xf1,
+	0x4e, 0x80, 0x63, 0xb0, 0x57, 0xd9, 0xef, 0x34, 0x16, 0x8a, 0x67, 0xe4, 0x92, 0xf6, 0x3c, 0xfa,
+	0x36, 0x69, 0x61, 0x6e, 0xa0, 0x53, 0xf0, 0x6a, 0x2e, 0xaf, 0xa3, 0xf8, 0x71, 0x9b, 0xd1, 0x02,
+	0x79, 0xa4, 0x43, 0xbe, 0xef, 0x90, 0x36, 0xb8, 0x06, 0xfa, 0x06, 0x6c, 0x95, 0x5f, 0x8d, 0x2b,
+	0xf2, 0x48, 0x07, 0x64, 0xdf, 0x21, 0x2d, 0x8e, 0xb1, 0x81, 0xbe, 0xd6, 0xc4, 0xea, 0xf8, 0xc8,
+	0x25, 0x6d, 0x80, 0x7d, 0x9b, 0xb4, 0x38, 0x94, 0xb9, 0x8f, 0x34, 0x23, 0xe8, 0x80, 0x74, 0x99,
+	0xf4, 0x5d, 0xd2, 0xc6, 0x07, 0x1b, 0xe8, 0x04, 0xdc, 0x35, 0x67, 0x3b, 0x26, 0x68, 0xdd, 0x48,
+	0xe4, 0x91, 0x0e, 0x36, 0xbe, 0x43, 0x5a, 0x1d, 0xc6, 0xc6, 0x66, 0xa8, 0x58, 0x3c, 0xff, 0x7b,
+	0x00, 0xd0, 0xe9, 0xd6, 0xbb, 0x4b, 0x06, 0x00, 0x00,
 }
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/NetSys/quilt/db"
+)
+
+// The number of idempotency keys whose results the daemon remembers.  Once there are
+// more, the least recently used are forgotten.
+const maxIdempotencyKeys = 256
+
+// idempotency answers replays of API requests with the result of the original, so
+// that clients can safely retry requests that may or may not have reached the daemon.
+// The results of completed requests are kept in the cluster row, along with the rest
+// of the daemon's state.
+type idempotency struct {
+	sync.Mutex
+	inFlight map[string]*pendingRequest
+}
+
+type pendingRequest struct {
+	done chan struct{}
+	err  error
+}
+
+func newIdempotency() *idempotency {
+	return &idempotency{inFlight: map[string]*pendingRequest{}}
+}
+
+// do runs `fn` on behalf of the request of `method` with the given idempotency key.
+// If a request with the same method and key has already completed, its result is
+// returned instead, and if one is still running, do waits for it and returns its
+// result.  Requests without a key are always run.
+func (idem *idempotency) do(conn db.Conn, method, key string, fn func() error) error {
+	if key == "" {
+		return fn()
+	}
+	key = method + "/" + key
+
+	idem.Lock()
+	if req, ok := idem.inFlight[key]; ok {
+		idem.Unlock()
+		<-req.done
+		return req.err
+	}
+
+	if res, ok := lookupRequest(conn, key); ok {
+		idem.Unlock()
+		if res.Error == "" {
+			return nil
+		}
+		return errors.New(res.Error)
+	}
+
+	req := &pendingRequest{done: make(chan struct{})}
+	idem.inFlight[key] = req
+	idem.Unlock()
+
+	req.err = fn()
+	res := db.Request{Key: key}
+	if req.err != nil {
+		res.Error = req.err.Error()
+	}
+
+	idem.Lock()
+	recordRequest(conn, res)
+	delete(idem.inFlight, key)
+	idem.Unlock()
+
+	close(req.done)
+	return req.err
+}
+
+// lookupRequest finds the result of the request with `key`, and marks it as the most
+// recently used.
+func lookupRequest(conn db.Conn, key string) (res db.Request, ok bool) {
+	conn.Txn(db.ClusterTable).Run(func(view db.Database) error {
+		cluster, err := view.GetCluster()
+		if err != nil {
+			return nil
+		}
+
+		// The rows' slices may still be read by other transactions, so they're
+		// copied rather than modified in place.
+		var requests []db.Request
+		for _, r := range cluster.Requests {
+			if r.Key == key {
+				res, ok = r, true
+			} else {
+				requests = append(requests, r)
+			}
+		}
+
+		if ok {
+			cluster.Requests = append(requests, res)
+			view.Commit(cluster)
+		}
+		return nil
+	})
+	return res, ok
+}
+
+// recordRequest remembers the result of a request as the most recently used, and
+// forgets the least recently used results beyond maxIdempotencyKeys.
+func recordRequest(conn db.Conn, res db.Request) {
+	conn.Txn(db.ClusterTable).Run(func(view db.Database) error {
+		cluster, err := view.GetCluster()
+		if err != nil {
+			cluster = view.InsertCluster()
+		}
+
+		requests := append([]db.Request{}, cluster.Requests...)
+		requests = append(requests, res)
+		if len(requests) > maxIdempotencyKeys {
+			requests = requests[len(requests)-maxIdempotencyKeys:]
+		}
+		cluster.Requests = requests
+		view.Commit(cluster)
+		return nil
+	})
+}
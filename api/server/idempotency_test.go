@@ -0,0 +1,146 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/NetSys/quilt/api/pb"
+	"github.com/NetSys/quilt/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotentReplay(t *testing.T) {
+	conn := db.New()
+	s := server{conn: conn, idempotency: newIdempotency()}
+	ctx := context.Background()
+
+	getSpec := func() string {
+		return conn.SelectFromCluster(nil)[0].Spec
+	}
+
+	_, err := s.Deploy(ctx, &pb.DeployRequest{
+		Deployment: `{"Namespace": "a"}`, IdempotencyKey: "a"})
+	assert.NoError(t, err)
+	_, err = s.Deploy(ctx, &pb.DeployRequest{
+		Deployment: `{"Namespace": "b"}`, IdempotencyKey: "b"})
+	assert.NoError(t, err)
+	specB := getSpec()
+
+	// A replay that arrives after the original completed doesn't deploy again.
+	_, err = s.Deploy(ctx, &pb.DeployRequest{
+		Deployment: `{"Namespace": "a"}`, IdempotencyKey: "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, specB, getSpec())
+
+	// Failures are replayed too.
+	_, err = s.DrainMachine(ctx, &pb.DrainRequest{ID: 3, IdempotencyKey: "c"})
+	assert.EqualError(t, err, "no machine with ID 3")
+
+	var m db.Machine
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m = view.InsertMachine()
+		m.Provider = db.Amazon
+		view.Commit(m)
+		return nil
+	})
+
+	_, err = s.DrainMachine(ctx, &pb.DrainRequest{ID: int32(m.ID),
+		IdempotencyKey: "c"})
+	assert.EqualError(t, err, "no machine with ID 3")
+	assert.False(t, conn.SelectFromMachine(nil)[0].Draining)
+
+	// The same key may be used by different methods.
+	_, err = s.FreezeMachines(ctx, &pb.FreezeRequest{Frozen: true,
+		IdempotencyKey: "c"})
+	assert.NoError(t, err)
+	assert.True(t, conn.SelectFromCluster(nil)[0].FreezeMachines)
+
+	// The results are kept with the rest of the daemon's state, so they outlive
+	// the server that recorded them.
+	s = server{conn: conn, idempotency: newIdempotency()}
+	_, err = s.FreezeMachines(ctx, &pb.FreezeRequest{Frozen: false,
+		IdempotencyKey: "c"})
+	assert.NoError(t, err)
+	assert.True(t, conn.SelectFromCluster(nil)[0].FreezeMachines)
+
+	// Requests without a key always run.
+	_, err = s.FreezeMachines(ctx, &pb.FreezeRequest{Frozen: false})
+	assert.NoError(t, err)
+	assert.False(t, conn.SelectFromCluster(nil)[0].FreezeMachines)
+	_, err = s.FreezeMachines(ctx, &pb.FreezeRequest{Frozen: true})
+	assert.NoError(t, err)
+	assert.True(t, conn.SelectFromCluster(nil)[0].FreezeMachines)
+}
+
+func TestIdempotentInFlight(t *testing.T) {
+	conn := db.New()
+	idem := newIdempotency()
+
+	runs := 0
+	release := make(chan struct{})
+	fn := func() error {
+		runs++
+		<-release
+		return errors.New("failed")
+	}
+
+	original := make(chan error)
+	go func() { original <- idem.do(conn, "Deploy", "key", fn) }()
+
+	// Wait for the original to start before replaying it.
+	for {
+		idem.Lock()
+		_, ok := idem.inFlight["Deploy/key"]
+		idem.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	replay := make(chan error)
+	go func() { replay <- idem.do(conn, "Deploy", "key", fn) }()
+
+	select {
+	case err := <-replay:
+		t.Fatalf("replay returned %v before the original completed", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.EqualError(t, <-original, "failed")
+	assert.EqualError(t, <-replay, "failed")
+	assert.Equal(t, 1, runs)
+}
+
+func TestIdempotencyEviction(t *testing.T) {
+	conn := db.New()
+	idem := newIdempotency()
+
+	runs := map[string]int{}
+	do := func(key string) {
+		idem.do(conn, "Deploy", key, func() error {
+			runs[key]++
+			return nil
+		})
+	}
+
+	for i := 0; i < maxIdempotencyKeys; i++ {
+		do(fmt.Sprintf("%d", i))
+	}
+
+	// Replaying the oldest key makes it the most recently used, so the next
+	// oldest is forgotten instead.
+	do("0")
+	do("new")
+	assert.Len(t, conn.SelectFromCluster(nil)[0].Requests, maxIdempotencyKeys)
+
+	do("0")
+	do("1")
+	assert.Equal(t, 1, runs["0"])
+	assert.Equal(t, 2, runs["1"])
+}
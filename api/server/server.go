@@ -7,14 +7,19 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/NetSys/quilt/api"
 	"github.com/NetSys/quilt/api/pb"
+	"github.com/NetSys/quilt/counter"
 	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/faults"
 	"github.com/NetSys/quilt/minion/ipdef"
+	minionPB "github.com/NetSys/quilt/minion/pb"
 	"github.com/NetSys/quilt/stitch"
 
 	"golang.org/x/net/context"
@@ -24,7 +29,8 @@ import (
 )
 
 type server struct {
-	conn db.Conn
+	conn        db.Conn
+	idempotency *idempotency
 }
 
 // Run accepts incoming `quiltctl` connections and responds to them.
@@ -35,7 +41,7 @@ func Run(conn db.Conn, listenAddr string) error {
 	}
 
 	var sock net.Listener
-	apiServer := server{conn}
+	apiServer := server{conn, newIdempotency()}
 	for {
 		sock, err = net.Listen(proto, addr)
 
@@ -70,7 +76,7 @@ func (s server) Query(cts context.Context, query *pb.DBQuery) (*pb.QueryReply, e
 	case db.MachineTable:
 		rows = s.conn.SelectFromMachine(nil)
 	case db.ContainerTable:
-		rows = s.conn.SelectFromContainer(nil)
+		rows = s.queryContainers()
 	case db.EtcdTable:
 		rows = s.conn.SelectFromEtcd(nil)
 	case db.ConnectionTable:
@@ -91,27 +97,60 @@ func (s server) Query(cts context.Context, query *pb.DBQuery) (*pb.QueryReply, e
 	return &pb.QueryReply{TableContents: string(json)}, nil
 }
 
+// queryContainers returns the declared containers, followed by those that the workers
+// are still tearing down because they're no longer declared.
+func (s server) queryContainers() []db.Container {
+	var dbcs []db.Container
+	s.conn.Txn(db.ContainerTable, db.MinionTable).Run(func(view db.Database) error {
+		dbcs = view.SelectFromContainer(nil)
+		if self, err := view.MinionSelf(); err == nil {
+			dbcs = append(dbcs, self.Orphans...)
+		}
+		return nil
+	})
+	return dbcs
+}
+
+// Deploy replaces the deployed Stitch.  Replays of a request with the same
+// idempotency key return the result of the original instead of deploying again.
 func (s server) Deploy(cts context.Context, deployReq *pb.DeployRequest) (
 	*pb.DeployReply, error) {
 
-	stitch, err := stitch.FromJSON(deployReq.Deployment)
+	err := s.idempotency.do(s.conn, "Deploy", deployReq.IdempotencyKey,
+		func() error { return s.deploy(deployReq.Deployment) })
+	return &pb.DeployReply{}, err
+}
+
+func (s server) deploy(deployment string) error {
+	stitch, err := stitch.FromJSON(deployment)
 	if err != nil {
-		return &pb.DeployReply{}, err
+		return err
 	}
 
 	if len(stitch.Machines) > ipdef.MaxMinionCount {
-		return &pb.DeployReply{}, fmt.Errorf("cannot boot more than %d "+
-			"machines", ipdef.MaxMinionCount)
+		return fmt.Errorf("cannot boot more than %d machines",
+			ipdef.MaxMinionCount)
+	}
+
+	if len(stitch.Networks) > ipdef.MaxNetworkCount {
+		return fmt.Errorf("cannot create more than %d networks",
+			ipdef.MaxNetworkCount)
 	}
 
 	for _, c := range stitch.Containers {
 		parts := strings.Split(c.Image, ":")
 		if len(parts) > 3 || (len(parts) >= 2 && parts[1] == "") {
-			return &pb.DeployReply{}, fmt.Errorf("could not parse "+
-				"container image: %s", c.Image)
+			return fmt.Errorf("could not parse container image: %s",
+				c.Image)
 		}
 	}
 
+	// Deployments built in Go never pass through the Javascript bindings, so the
+	// daemon is the first to check them.
+	if err := stitch.Validate(); err != nil {
+		return err
+	}
+
 	err = s.conn.Txn(db.ClusterTable).Run(func(view db.Database) error {
 		cluster, err := view.GetCluster()
 		if err != nil {
@@ -123,18 +162,425 @@ func (s server) Deploy(cts context.Context, deployReq *pb.DeployRequest) (
 		return nil
 	})
 	if err != nil {
-		return &pb.DeployReply{}, err
+		return err
 	}
 
 	// XXX: Remove this error when the Vagrant provider is done.
 	for _, machine := range stitch.Machines {
 		if machine.Provider == db.Vagrant {
-			err = errors.New("The Vagrant provider is in development." +
+			return errors.New("The Vagrant provider is in development." +
 				" The stitch will continue to run, but" +
 				" probably won't work correctly.")
-			return &pb.DeployReply{}, err
 		}
 	}
 
-	return &pb.DeployReply{}, nil
+	return nil
+}
+
+func (s server) QueryCounters(ctx context.Context, in *pb.CountersRequest) (
+	*pb.CountersReply, error) {
+
+	reply := &pb.CountersReply{}
+	for _, c := range counter.Dump() {
+		reply.Counters = append(reply.Counters, &pb.Counter{
+			Pkg:       c.Pkg,
+			Name:      c.Name,
+			Value:     c.Value,
+			PrevValue: c.PrevValue,
+		})
+	}
+	return reply, nil
+}
+
+func (s server) QueryMinionCounters(ctx context.Context,
+	in *pb.MinionCountersRequest) (*pb.CountersReply, error) {
+
+	machines := s.conn.SelectFromMachine(func(m db.Machine) bool {
+		return m.PublicIP == in.Host
+	})
+	if in.Host == "" || len(machines) == 0 {
+		return nil, fmt.Errorf("no machine with public IP: %s", in.Host)
+	}
+
+	counters, err := getMinionCounters(in.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &pb.CountersReply{}
+	for _, c := range counters {
+		reply.Counters = append(reply.Counters, &pb.Counter{
+			Pkg:       c.Pkg,
+			Name:      c.Name,
+			Value:     c.Value,
+			PrevValue: c.PrevValue,
+		})
+	}
+	return reply, nil
+}
+
+// InjectFault injects the requested failures into this daemon or minion.  It's
+// refused unless fault injection was enabled on the command line.
+func (s server) InjectFault(ctx context.Context, in *pb.FaultRequest) (
+	*pb.FaultReply, error) {
+
+	err := faults.Inject(faults.Fault{
+		BootFailures:        int(in.BootFailures),
+		DropEtcdSyncs:       int(in.DropEtcdSyncs),
+		DelayedDockerStarts: int(in.DelayedDockerStarts),
+		DockerStartDelay:    time.Duration(in.DockerStartDelay),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.FaultReply{}, nil
+}
+
+// FreezeMachines freezes, or thaws, the machines in the cluster.  While frozen, the
+// daemon won't boot or terminate machines, but containers are still deployed.
+func (s server) FreezeMachines(ctx context.Context, in *pb.FreezeRequest) (
+	*pb.FreezeReply, error) {
+
+	err := s.idempotency.do(s.conn, "FreezeMachines", in.IdempotencyKey,
+		func() error { return s.freezeMachines(in.Frozen) })
+	if err != nil {
+		return nil, err
+	}
+	return &pb.FreezeReply{}, nil
+}
+
+func (s server) freezeMachines(frozen bool) error {
+	return s.conn.Txn(db.ClusterTable).Run(func(view db.Database) error {
+		cluster, err := view.GetCluster()
+		if err != nil {
+			cluster = view.InsertCluster()
+		}
+
+		cluster.FreezeMachines = frozen
+		view.Commit(cluster)
+		return nil
+	})
+}
+
+// DrainMachine marks the machine with the requested ID as draining.  The engine
+// terminates it once its containers have moved elsewhere, and boots a replacement
+// if the Stitch still calls for one.
+func (s server) DrainMachine(ctx context.Context, in *pb.DrainRequest) (
+	*pb.DrainReply, error) {
+
+	err := s.idempotency.do(s.conn, "DrainMachine", in.IdempotencyKey,
+		func() error { return s.drainMachine(int(in.ID)) })
+	if err != nil {
+		return nil, err
+	}
+	return &pb.DrainReply{}, nil
+}
+
+func (s server) drainMachine(id int) error {
+	return s.conn.Txn(db.MachineTable).Run(func(view db.Database) error {
+		machines := view.SelectFromMachine(func(m db.Machine) bool {
+			return m.ID == id
+		})
+		if len(machines) == 0 {
+			return fmt.Errorf("no machine with ID %d", id)
+		}
+
+		m := machines[0]
+		if m.Provider == db.Static {
+			// Static machines can't be replaced, so draining one would
+			// only shrink the cluster.
+			return fmt.Errorf("machine %d is static, so it can't be drained",
+				id)
+		}
+
+		m.Draining = true
+		view.Commit(m)
+		return nil
+	})
+}
+
+// PromoteCanary rewrites the deployed Stitch so that the canaries of the requested
+// label are promoted, or, if Abort is set, removed.  The engine then rolls the
+// label's containers as it would for any other change to the Stitch.
+func (s server) PromoteCanary(ctx context.Context, in *pb.CanaryRequest) (
+	*pb.CanaryReply, error) {
+
+	err := s.idempotency.do(s.conn, "PromoteCanary", in.IdempotencyKey,
+		func() error { return s.promoteCanary(in.Label, in.Abort) })
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CanaryReply{}, nil
+}
+
+func (s server) promoteCanary(label string, abort bool) error {
+	return s.conn.Txn(db.ClusterTable).Run(func(view db.Database) error {
+		cluster, err := view.GetCluster()
+		if err != nil || cluster.Spec == "" {
+			return errors.New("no deployment")
+		}
+
+		spec, err := stitch.FromJSON(cluster.Spec)
+		if err != nil {
+			return err
+		}
+
+		if abort {
+			spec, err = spec.AbortCanary(label)
+		} else {
+			spec, err = spec.PromoteCanary(label)
+		}
+		if err != nil {
+			return err
+		}
+
+		cluster.Spec = spec.String()
+		view.Commit(cluster)
+		return nil
+	})
+}
+
+// QueryStatus summarizes the health of each label's containers.  Only the leader
+// tracks containers, so other daemons report no labels.
+func (s server) QueryStatus(ctx context.Context, in *pb.StatusRequest) (
+	*pb.StatusReply, error) {
+
+	var statuses []api.LabelStatus
+	s.conn.Txn(db.ContainerTable, db.ConnectionTable).Run(
+		func(view db.Database) error {
+			statuses = labelStatuses(view.SelectFromContainer(nil),
+				view.SelectFromConnection(nil))
+			return nil
+		})
+
+	json, err := json.Marshal(statuses)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.StatusReply{Labels: string(json)}, nil
+}
+
+// labelStatuses summarizes the containers of each label, sorted by label name.
+func labelStatuses(dbcs []db.Container, conns []db.Connection) []api.LabelStatus {
+	statusMap := map[string]*api.LabelStatus{}
+	minions := map[string]map[string]struct{}{}
+	failureIDs := map[string]int{}
+	for _, dbc := range dbcs {
+		for _, label := range dbc.Labels {
+			status := statusMap[label]
+			if status == nil {
+				status = &api.LabelStatus{Label: label}
+				statusMap[label] = status
+				minions[label] = map[string]struct{}{}
+			}
+
+			status.Desired++
+			if dbc.Canary {
+				status.Canaries++
+			}
+			// Docker only lists running containers, so the workers report
+			// those that exited with an empty status, just like those that
+			// haven't booted yet.  Either way, a container that's placed
+			// but not running is unhealthy.
+			if dbc.ActualState == "running" {
+				status.Running++
+			} else if dbc.Minion != "" {
+				status.Unhealthy++
+			}
+
+			if dbc.Minion != "" {
+				minions[label][dbc.Minion] = struct{}{}
+			}
+
+			if !dbc.RolloutAfter.IsZero() && (status.RolloutAfter.IsZero() ||
+				dbc.RolloutAfter.Before(status.RolloutAfter)) {
+				status.RolloutAfter = dbc.RolloutAfter
+			}
+
+			// Database IDs are allocated in order, so the lowest belongs to
+			// the container that has been waiting the longest.
+			id, ok := failureIDs[label]
+			if dbc.PlacementFailure != "" && (!ok || dbc.ID < id) {
+				failureIDs[label] = dbc.ID
+				status.PlacementFailure = dbc.PlacementFailure
+			}
+		}
+	}
+
+	for _, conn := range conns {
+		status := statusMap[conn.To]
+		if conn.From != stitch.PublicInternetLabel || status == nil {
+			continue
+		}
+		status.PublicPorts = append(status.PublicPorts, conn.MinPort)
+	}
+
+	var labels []string
+	for label := range statusMap {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var statuses []api.LabelStatus
+	for _, label := range labels {
+		status := statusMap[label]
+		status.Machines = len(minions[label])
+		sort.Ints(status.PublicPorts)
+		statuses = append(statuses, *status)
+	}
+	return statuses
+}
+
+// NetTest probes whether the containers of the From label can reach those of the To
+// label.  Each probe runs on the worker of its source container, so only the leader,
+// which tracks every container, can orchestrate them.
+func (s server) NetTest(ctx context.Context, in *pb.NetTestRequest) (
+	*pb.NetTestReply, error) {
+
+	var dbcs []db.Container
+	var conns []db.Connection
+	var labels []db.Label
+	s.conn.Txn(db.ContainerTable, db.ConnectionTable, db.LabelTable).Run(
+		func(view db.Database) error {
+			dbcs = db.SortContainers(view.SelectFromContainer(nil))
+			conns = view.SelectFromConnection(nil)
+			labels = view.SelectFromLabel(nil)
+			return nil
+		})
+
+	var from []db.Container
+	for _, dbc := range dbcs {
+		for _, label := range dbc.Labels {
+			if label == in.From {
+				from = append(from, dbc)
+			}
+		}
+	}
+	if len(from) == 0 {
+		return nil, fmt.Errorf("no label: %s", in.From)
+	}
+
+	var to *db.Label
+	for i := range labels {
+		if labels[i].Label == in.To {
+			to = &labels[i]
+		}
+	}
+	if to == nil {
+		return nil, fmt.Errorf("no label: %s", in.To)
+	}
+
+	if !connected(conns, in.From, in.To, int(in.Port), in.Protocol) {
+		return nil, fmt.Errorf("no Connection declared from %s to %s on %d/%s",
+			in.From, in.To, in.Port, in.Protocol)
+	}
+
+	// Probe each of the To label's containers by the hostnames the From containers
+	// know them by, as well as its load balanced IP if it has one.
+	type dest struct{ hostname, ip string }
+	var dests []dest
+	for i, ip := range to.ContainerIPs {
+		dests = append(dests, dest{fmt.Sprintf("%d.%s.q", i+1, to.Label), ip})
+	}
+	if to.IP != "" && len(to.ContainerIPs) != 1 {
+		dests = append(dests, dest{to.Label + ".q", to.IP})
+	}
+
+	results := make([]api.ProbeResult, len(from)*len(dests))
+	var wg sync.WaitGroup
+	for i, dbc := range from {
+		for j, d := range dests {
+			result := &results[i*len(dests)+j]
+			result.FromIP = dbc.IP
+			result.ToHostname = d.hostname
+			result.ToIP = d.ip
+			if dbc.Minion == "" || dbc.IP == "" {
+				result.Error = "source container isn't running"
+				continue
+			}
+
+			wg.Add(1)
+			go func(minion string) {
+				defer wg.Done()
+				hops, err := probeMinion(minion, &minionPB.ProbeRequest{
+					FromIP:   result.FromIP,
+					Hostname: result.ToHostname,
+					IP:       result.ToIP,
+					Port:     in.Port,
+					Protocol: in.Protocol,
+				})
+				if err != nil {
+					result.Error = err.Error()
+				}
+				result.Hops = hops
+			}(dbc.Minion)
+		}
+	}
+	wg.Wait()
+
+	json, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.NetTestReply{Results: string(json)}, nil
+}
+
+// connected returns whether any of `conns` allows `from` to connect to `to` on
+// `port` over `protocol`.
+func connected(conns []db.Connection, from, to string, port int,
+	protocol string) bool {
+
+	for _, conn := range conns {
+		if conn.From != from || conn.To != to ||
+			port < conn.MinPort || port > conn.MaxPort {
+			continue
+		}
+
+		for _, p := range stitch.Protocols(conn.Protocol) {
+			if p == protocol {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Stored in a variable so it can be mocked out in the unit tests.
+var probeMinion = func(host string, req *minionPB.ProbeRequest) ([]api.ProbeHop,
+	error) {
+
+	cc, err := grpc.Dial(host+":9999", grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	defer cc.Close()
+
+	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
+	reply, err := minionPB.NewMinionClient(cc).Probe(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var hops []api.ProbeHop
+	if err := json.Unmarshal([]byte(reply.Hops), &hops); err != nil {
+		return nil, err
+	}
+	return hops, nil
+}
+
+// Stored in a variable so it can be mocked out in the unit tests.
+var getMinionCounters = func(host string) ([]*minionPB.MinionCounter, error) {
+	cc, err := grpc.Dial(host+":9999", grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	defer cc.Close()
+
+	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	reply, err := minionPB.NewMinionClient(cc).GetMinionCounters(ctx,
+		&minionPB.Request{})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Counters, nil
 }
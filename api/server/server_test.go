@@ -1,13 +1,19 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"golang.org/x/net/context"
 
+	"github.com/NetSys/quilt/api"
 	"github.com/NetSys/quilt/api/pb"
 	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/faults"
+	minionPB "github.com/NetSys/quilt/minion/pb"
 	"github.com/NetSys/quilt/stitch"
 	"github.com/stretchr/testify/assert"
 )
@@ -37,10 +43,19 @@ func TestMachineResponse(t *testing.T) {
 	})
 
 	exp := `[{"ID":1,"Role":"Master","Provider":"Amazon","Region":"",` +
-		`"Size":"size","DiskSize":0,"SSHKeys":null,"CloudID":"",` +
-		`"PublicIP":"8.8.8.8","PrivateIP":"9.9.9.9","Connected":false}]`
-
-	checkQuery(t, server{conn}, db.MachineTable, exp)
+		`"Size":"size","Arch":"","DiskSize":0,"SpotPrice":0,"SSHKeys":null,` +
+		`"SSHKeyPath":"","DedicatedTo":"","FloatingIP":"","DisableNAT":false,` +
+		`"NetworkTier":"","Sizes":null,` +
+		`"CloudID":"",` +
+		`"PublicIP":"8.8.8.8","PrivateIP":"9.9.9.9","Failed":false,` +
+		`"Draining":false,"DrainStart":"0001-01-01T00:00:00Z",` +
+		`"ReplaceAfter":"0001-01-01T00:00:00Z",` +
+		`"Connected":false,"Containers":0,"Unscheduled":0,` +
+		`"SupervisorStatus":null,` +
+		`"BootRequested":"0001-01-01T00:00:00Z","BootTimings":{"Instance":0,` +
+		`"Minion":0,"ImagePull":0,"FirstContainer":0}}]`
+
+	checkQuery(t, server{conn: conn}, db.MachineTable, exp)
 }
 
 func TestContainerResponse(t *testing.T) {
@@ -50,6 +65,8 @@ func TestContainerResponse(t *testing.T) {
 	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
 		c := view.InsertContainer()
 		c.DockerID = "docker-id"
+		c.DesiredState = db.DesiredRunning
+		c.ActualState = "running"
 		c.Image = "image"
 		c.Command = []string{"cmd", "arg"}
 		c.Labels = []string{"labelA", "labelB"}
@@ -59,10 +76,49 @@ func TestContainerResponse(t *testing.T) {
 	})
 
 	exp := `[{"ID":1,"Pid":0,"IP":"","Mac":"","Minion":"",` +
-		`"EndpointID":"","StitchID":0,"DockerID":"docker-id","Image":"image",` +
-		`"Command":["cmd","arg"],"Labels":["labelA","labelB"],"Env":null}]`
+		`"EndpointID":"","StitchID":0,"DockerID":"docker-id",` +
+		`"Image":"image",` +
+		`"Command":["cmd","arg"],"Labels":["labelA","labelB"],"Env":null,` +
+		`"ShmSize":0,"Init":false,"StopSignal":"","Arch":"","NetworkMode":"",` +
+		`"Network":"",` +
+		`"FilepathToContent":null,` +
+		`"DesiredState":"running","ActualState":"running",` +
+		`"LabelSize":0,"LabelIndex":0,` +
+		`"RestartOnResize":false,"PlacementFailure":"","Canary":false,` +
+		`"RolloutAfter":"0001-01-01T00:00:00Z"}]`
+
+	checkQuery(t, server{conn: conn}, db.ContainerTable, exp)
+}
+
+func TestOrphanedContainerResponse(t *testing.T) {
+	t.Parallel()
+
+	conn := db.New()
+	orphan := db.Container{Minion: "1.1.1.1", DockerID: "orphan-id",
+		Image: "image", DesiredState: db.DesiredRemoved, ActualState: "running"}
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		c := view.InsertContainer()
+		c.DesiredState = db.DesiredRunning
+		view.Commit(c)
+
+		self := view.InsertMinion()
+		self.Self = true
+		self.Orphans = []db.Container{orphan}
+		view.Commit(self)
+		return nil
+	})
 
-	checkQuery(t, server{conn}, db.ContainerTable, exp)
+	reply, err := server{conn: conn}.Query(context.Background(),
+		&pb.DBQuery{Table: string(db.ContainerTable)})
+	assert.NoError(t, err)
+
+	// The containers the workers are tearing down are listed after the declared
+	// containers.
+	var dbcs []db.Container
+	assert.NoError(t, json.Unmarshal([]byte(reply.TableContents), &dbcs))
+	assert.Len(t, dbcs, 2)
+	assert.Equal(t, db.DesiredRunning, dbcs[0].DesiredState)
+	assert.Equal(t, orphan, dbcs[1])
 }
 
 func TestBadDeployment(t *testing.T) {
@@ -137,6 +193,50 @@ func TestDeploy(t *testing.T) {
 	assert.Equal(t, exp, actual)
 }
 
+func TestDeployBuilder(t *testing.T) {
+	conn := db.New()
+	s := server{conn: conn}
+
+	exp, err := stitch.NewDeployment("namespace").
+		AddMachine(stitch.Machine{Provider: "Amazon", Role: "Master"}).
+		AddMachine(stitch.Machine{Provider: "Amazon", Role: "Worker"}).
+		AddContainer("web", stitch.Container{Image: "nginx"}).
+		Connect(stitch.Connection{From: stitch.PublicInternetLabel, To: "web",
+			MinPort: 80, MaxPort: 80}).
+		Build()
+	assert.NoError(t, err)
+
+	_, err = s.Deploy(context.Background(),
+		&pb.DeployRequest{Deployment: exp.String()})
+	assert.NoError(t, err)
+
+	var spec string
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		clst, err := view.GetCluster()
+		assert.NoError(t, err)
+		spec = clst.Spec
+		return nil
+	})
+	assert.Equal(t, exp.String(), spec)
+}
+
+func TestInvalidDeployment(t *testing.T) {
+	conn := db.New()
+	s := server{conn: conn}
+
+	deployment := `{"Connections":[
+		{"From":"a", "To":"b", "MinPort":80, "MaxPort":80, "BandwidthLimit":-1}]}`
+	_, err := s.Deploy(context.Background(),
+		&pb.DeployRequest{Deployment: deployment})
+	assert.EqualError(t, err, "connection a->b has a negative bandwidth limit: -1")
+
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		_, err := view.GetCluster()
+		assert.Error(t, err)
+		return nil
+	})
+}
+
 func TestVagrantDeployment(t *testing.T) {
 	conn := db.New()
 	s := server{conn: conn}
@@ -175,3 +275,320 @@ func TestVagrantDeployment(t *testing.T) {
 
 	assert.Equal(t, exp, actual)
 }
+
+func TestQueryMinionCounters(t *testing.T) {
+	conn := db.New()
+	s := server{conn: conn}
+
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.InsertMachine()
+		m.PublicIP = "8.8.8.8"
+		view.Commit(m)
+		return nil
+	})
+
+	getMinionCounters = func(host string) ([]*minionPB.MinionCounter, error) {
+		assert.Equal(t, "8.8.8.8", host)
+		return []*minionPB.MinionCounter{{
+			Pkg:       "scheduler",
+			Name:      "Loop",
+			Value:     3,
+			PrevValue: 1,
+		}}, nil
+	}
+
+	reply, err := s.QueryMinionCounters(context.Background(),
+		&pb.MinionCountersRequest{Host: "8.8.8.8"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*pb.Counter{{
+		Pkg:       "scheduler",
+		Name:      "Loop",
+		Value:     3,
+		PrevValue: 1,
+	}}, reply.Counters)
+
+	// Only minions the daemon manages may be queried.
+	_, err = s.QueryMinionCounters(context.Background(),
+		&pb.MinionCountersRequest{Host: "1.1.1.1"})
+	assert.EqualError(t, err, "no machine with public IP: 1.1.1.1")
+}
+
+func TestInjectFault(t *testing.T) {
+	s := server{conn: db.New()}
+
+	req := &pb.FaultRequest{BootFailures: 1}
+	_, err := s.InjectFault(context.Background(), req)
+	assert.Equal(t, faults.ErrDisabled, err)
+	assert.NoError(t, faults.BootFailure())
+
+	faults.Enable()
+	_, err = s.InjectFault(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, faults.ErrBootFailure, faults.BootFailure())
+	assert.NoError(t, faults.BootFailure())
+}
+
+func TestFreezeMachines(t *testing.T) {
+	conn := db.New()
+	s := server{conn: conn}
+
+	isFrozen := func() bool {
+		clusters := conn.SelectFromCluster(nil)
+		assert.Len(t, clusters, 1)
+		return clusters[0].FreezeMachines
+	}
+
+	_, err := s.FreezeMachines(context.Background(), &pb.FreezeRequest{Frozen: true})
+	assert.NoError(t, err)
+	assert.True(t, isFrozen())
+
+	// Deploying doesn't thaw the machines.
+	_, err = s.Deploy(context.Background(), &pb.DeployRequest{Deployment: "{}"})
+	assert.NoError(t, err)
+	assert.True(t, isFrozen())
+
+	_, err = s.FreezeMachines(context.Background(), &pb.FreezeRequest{Frozen: false})
+	assert.NoError(t, err)
+	assert.False(t, isFrozen())
+}
+
+func TestDrainMachine(t *testing.T) {
+	conn := db.New()
+	s := server{conn: conn}
+
+	var worker, static db.Machine
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		worker = view.InsertMachine()
+		worker.Provider = db.Amazon
+		view.Commit(worker)
+
+		static = view.InsertMachine()
+		static.Provider = db.Static
+		view.Commit(static)
+		return nil
+	})
+
+	_, err := s.DrainMachine(context.Background(),
+		&pb.DrainRequest{ID: int32(worker.ID)})
+	assert.NoError(t, err)
+
+	_, err = s.DrainMachine(context.Background(),
+		&pb.DrainRequest{ID: int32(static.ID)})
+	assert.EqualError(t, err, fmt.Sprintf(
+		"machine %d is static, so it can't be drained", static.ID))
+
+	_, err = s.DrainMachine(context.Background(), &pb.DrainRequest{ID: 100})
+	assert.EqualError(t, err, "no machine with ID 100")
+
+	for _, m := range conn.SelectFromMachine(nil) {
+		assert.Equal(t, m.ID == worker.ID, m.Draining)
+	}
+}
+
+func TestPromoteCanary(t *testing.T) {
+	conn := db.New()
+	s := server{conn: conn}
+
+	_, err := s.PromoteCanary(context.Background(),
+		&pb.CanaryRequest{Label: "app"})
+	assert.EqualError(t, err, "no deployment")
+
+	spec, err := stitch.FromJavascript(`var app = new Service("app",
+		new Container("app:v1").replicate(2));
+	app.canary({image: "app:v2"});
+	deployment.deploy(app);`, stitch.DefaultImportGetter)
+	assert.NoError(t, err)
+
+	deploy := func() {
+		_, err := s.Deploy(context.Background(),
+			&pb.DeployRequest{Deployment: spec.String()})
+		assert.NoError(t, err)
+	}
+	getSpec := func() stitch.Stitch {
+		var clst db.Cluster
+		conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+			clst, _ = view.GetCluster()
+			return nil
+		})
+
+		deployed, err := stitch.FromJSON(clst.Spec)
+		assert.NoError(t, err)
+		return deployed
+	}
+
+	deploy()
+	_, err = s.PromoteCanary(context.Background(),
+		&pb.CanaryRequest{Label: "app"})
+	assert.NoError(t, err)
+	exp, _ := spec.PromoteCanary("app")
+	assert.Equal(t, exp, getSpec())
+
+	deploy()
+	_, err = s.PromoteCanary(context.Background(),
+		&pb.CanaryRequest{Label: "app", Abort: true})
+	assert.NoError(t, err)
+	exp, _ = spec.AbortCanary("app")
+	assert.Equal(t, exp, getSpec())
+
+	// Failures leave the deployment alone.
+	_, err = s.PromoteCanary(context.Background(),
+		&pb.CanaryRequest{Label: "app"})
+	assert.EqualError(t, err, "label app has no canaries")
+	assert.Equal(t, exp, getSpec())
+}
+
+func TestNetTest(t *testing.T) {
+	conn := db.New()
+	s := server{conn: conn}
+
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		web := view.InsertContainer()
+		web.StitchID = 1
+		web.IP = "10.0.0.2"
+		web.Minion = "192.168.0.1"
+		web.Labels = []string{"web"}
+		view.Commit(web)
+
+		idle := view.InsertContainer()
+		idle.StitchID = 2
+		idle.Labels = []string{"web"}
+		view.Commit(idle)
+
+		label := view.InsertLabel()
+		label.Label = "db"
+		label.IP = "10.0.0.10"
+		label.ContainerIPs = []string{"10.0.0.3", "10.0.0.4"}
+		view.Commit(label)
+
+		c := view.InsertConnection()
+		c.From = "web"
+		c.To = "db"
+		c.MinPort = 5432
+		c.MaxPort = 5432
+		c.Protocol = "tcp"
+		view.Commit(c)
+		return nil
+	})
+
+	probeMinion = func(host string, req *minionPB.ProbeRequest) (
+		[]api.ProbeHop, error) {
+
+		assert.Equal(t, "192.168.0.1", host)
+		assert.Equal(t, "10.0.0.2", req.FromIP)
+		if req.IP == "10.0.0.4" {
+			return nil, errors.New("unreachable")
+		}
+		return []api.ProbeHop{{Name: "connect", OK: true}}, nil
+	}
+
+	_, err := s.NetTest(context.Background(), &pb.NetTestRequest{
+		From: "web", To: "db", Port: 80, Protocol: "tcp"})
+	assert.EqualError(t, err, "no Connection declared from web to db on 80/tcp")
+
+	_, err = s.NetTest(context.Background(), &pb.NetTestRequest{
+		From: "db", To: "web", Port: 5432, Protocol: "tcp"})
+	assert.EqualError(t, err, "no label: db")
+
+	_, err = s.NetTest(context.Background(), &pb.NetTestRequest{
+		From: "web", To: "cache", Port: 5432, Protocol: "tcp"})
+	assert.EqualError(t, err, "no label: cache")
+
+	reply, err := s.NetTest(context.Background(), &pb.NetTestRequest{
+		From: "web", To: "db", Port: 5432, Protocol: "tcp"})
+	assert.NoError(t, err)
+
+	var results []api.ProbeResult
+	assert.NoError(t, json.Unmarshal([]byte(reply.Results), &results))
+	ok := []api.ProbeHop{{Name: "connect", OK: true}}
+	assert.Equal(t, []api.ProbeResult{
+		{FromIP: "10.0.0.2", ToHostname: "1.db.q", ToIP: "10.0.0.3", Hops: ok},
+		{FromIP: "10.0.0.2", ToHostname: "2.db.q", ToIP: "10.0.0.4",
+			Error: "unreachable"},
+		{FromIP: "10.0.0.2", ToHostname: "db.q", ToIP: "10.0.0.10", Hops: ok},
+		{ToHostname: "1.db.q", ToIP: "10.0.0.3",
+			Error: "source container isn't running"},
+		{ToHostname: "2.db.q", ToIP: "10.0.0.4",
+			Error: "source container isn't running"},
+		{ToHostname: "db.q", ToIP: "10.0.0.10",
+			Error: "source container isn't running"},
+	}, results)
+}
+
+func TestQueryStatus(t *testing.T) {
+	conn := db.New()
+	s := server{conn: conn}
+
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		for i, status := range []string{"running", "running", "exited", ""} {
+			dbc := view.InsertContainer()
+			dbc.Labels = []string{"web"}
+			dbc.ActualState = status
+			dbc.Canary = i == 0
+			if i > 0 {
+				// The label's earliest deferred rollout is reported.
+				dbc.RolloutAfter = time.Date(2017, 6, 10-i, 2, 0, 0, 0,
+					time.UTC)
+			}
+			if status != "" {
+				dbc.Minion = "1.1.1.1"
+			}
+			view.Commit(dbc)
+		}
+
+		// A container that exited is no longer listed by Docker, so its
+		// worker reports it without a status.
+		dbc := view.InsertContainer()
+		dbc.Labels = []string{"web"}
+		dbc.Minion = "2.2.2.2"
+		view.Commit(dbc)
+
+		for _, failure := range []string{"region us-west-1", "no minions"} {
+			dbc := view.InsertContainer()
+			dbc.Labels = []string{"db", "web"}
+			dbc.PlacementFailure = failure
+			view.Commit(dbc)
+		}
+
+		for _, port := range []int{443, 80} {
+			c := view.InsertConnection()
+			c.From = stitch.PublicInternetLabel
+			c.To = "web"
+			c.MinPort = port
+			c.MaxPort = port
+			view.Commit(c)
+		}
+
+		c := view.InsertConnection()
+		c.From = "web"
+		c.To = "db"
+		c.MinPort = 5432
+		c.MaxPort = 5432
+		view.Commit(c)
+		return nil
+	})
+
+	reply, err := s.QueryStatus(context.Background(), &pb.StatusRequest{})
+	assert.NoError(t, err)
+
+	var statuses []api.LabelStatus
+	assert.NoError(t, json.Unmarshal([]byte(reply.Labels), &statuses))
+	assert.Equal(t, []api.LabelStatus{
+		{
+			Label:            "db",
+			Desired:          2,
+			PlacementFailure: "region us-west-1",
+		},
+		{
+			Label:            "web",
+			Desired:          7,
+			Running:          2,
+			Unhealthy:        2,
+			Canaries:         1,
+			Machines:         2,
+			PublicPorts:      []int{80, 443},
+			PlacementFailure: "region us-west-1",
+			RolloutAfter:     time.Date(2017, 6, 7, 2, 0, 0, 0, time.UTC),
+		},
+	}, statuses)
+	assert.True(t, statuses[0].Degraded())
+}
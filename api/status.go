@@ -0,0 +1,30 @@
+package api
+
+import "time"
+
+// LabelStatus summarizes the health of the containers in a label.
+type LabelStatus struct {
+	Label string
+
+	Desired   int // The containers the deployment declares.
+	Running   int // The containers Docker reports as running.
+	Unhealthy int // The placed containers that aren't running.
+	Canaries  int // The desired containers that are canaries.
+
+	Machines    int   // The number of minions the containers are placed on.
+	PublicPorts []int // The ports the label accepts from the public internet.
+
+	// Why the scheduler couldn't place the longest waiting of the containers, or
+	// empty if they're all placed.
+	PlacementFailure string
+
+	// When the deferred rollout of a new image to some of the containers may
+	// begin, or zero if none is deferred.  See stitch.MaintenanceWindow.
+	RolloutAfter time.Time
+}
+
+// Degraded returns whether fewer of the label's containers are running than the
+// deployment declares.
+func (status LabelStatus) Degraded() bool {
+	return status.Running < status.Desired
+}
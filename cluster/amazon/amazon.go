@@ -4,27 +4,49 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/NetSys/quilt/cluster/acl"
 	"github.com/NetSys/quilt/cluster/cloudcfg"
 	"github.com/NetSys/quilt/cluster/machine"
+	"github.com/NetSys/quilt/counter"
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/join"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 
 	log "github.com/Sirupsen/logrus"
 )
 
+// The error code EC2 returns when it has no capacity for the requested instance type.
+const insufficientCapacity = "InsufficientInstanceCapacity"
+
+// The statuses EC2 gives spot requests that it can't fulfill for lack of capacity.
+// Rather than fail, the requests stay open in case capacity frees up.
+var capacityStatuses = map[string]struct{}{
+	"capacity-not-available":  {},
+	"capacity-oversubscribed": {},
+}
+
 // The Cluster object represents a connection to Amazon EC2.
 type Cluster struct {
 	namespace string
+	clusterID string
 
 	clients   map[string]client
 	newClient func(string) client
+
+	// The regions in which the cluster adopted the security group that versions of
+	// Quilt before cluster IDs named after the namespace alone.
+	adopted map[string]struct{}
+
+	// The ACL rules planned for removal in the last tick, keyed by region.
+	plannedRemovals map[string]map[string]struct{}
 }
 
 type awsID struct {
@@ -36,32 +58,79 @@ type awsID struct {
 // region preference.
 const DefaultRegion = "us-west-1"
 
-const spotPrice = "0.5"
+// The spot price bid for machines that don't specify their own.
+const defaultSpotPrice = "0.5"
 
-// Ubuntu 16.04, 64-bit hvm-ssd
+// Ubuntu 16.04, 64-bit hvm-ssd.  Their kernels have the drivers for enhanced
+// networking, so it's enabled on every size that offers it.
 var amis = map[string]string{
 	"ap-southeast-2": "ami-550c3c36",
 	"us-west-1":      "ami-26074946",
 	"us-west-2":      "ami-e1fe2281",
 }
 
-// New creates a new Amazon EC2 cluster.
-func New(namespace string) (*Cluster, error) {
-	clst := newAmazon(namespace)
+// Regions returns the regions Amazon machines may be booted in, sorted.
+func Regions() []string {
+	var regions []string
+	for region := range amis {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+var (
+	aclAddCounter    = counter.New("amazon", "Add ACL Rule")
+	aclRemoveCounter = counter.New("amazon", "Remove ACL Rule")
+)
+
+// New creates a new Amazon EC2 cluster.  Machines belong to the cluster only if they
+// carry both `namespace` and `clusterID`, so that daemons sharing a namespace don't
+// manage each other's machines.
+func New(namespace, clusterID string) (*Cluster, error) {
+	clst := newAmazon(namespace, clusterID)
+	if err := clst.adoptLegacyGroups(); err != nil {
+		return nil, errors.New("AWS failed to connect")
+	}
+
 	if _, err := clst.List(); err != nil {
 		return nil, errors.New("AWS failed to connect")
 	}
+
+	foreign, err := clst.foreignInstances()
+	if err != nil {
+		return nil, errors.New("AWS failed to connect")
+	}
+	if len(foreign) > 0 {
+		log.WithField("instances", strings.Join(foreign, ", ")).Errorf(
+			"Amazon: Refusing to manage machines in namespace %s that "+
+				"weren't booted by this daemon", clst.namespace)
+	}
 	return clst, nil
 }
 
-func newAmazon(namespace string) *Cluster {
+func newAmazon(namespace, clusterID string) *Cluster {
 	return &Cluster{
 		namespace: strings.ToLower(namespace),
+		clusterID: strings.ToLower(clusterID),
 		clients:   make(map[string]client),
 		newClient: newClient,
+		adopted:   map[string]struct{}{},
+
+		plannedRemovals: map[string]map[string]struct{}{},
 	}
 }
 
+// groupName returns the name of the security group that holds the cluster's
+// instances in `region`: the legacy group named after the namespace if the cluster
+// adopted it, and otherwise one named after both the namespace and the cluster ID.
+func (clst Cluster) groupName(region string) string {
+	if _, ok := clst.adopted[region]; ok {
+		return clst.namespace
+	}
+	return clst.namespace + "-" + clst.clusterID
+}
+
 // Boot creates instances in the `clst` configured according to the `bootSet`.
 func (clst Cluster) Boot(bootSet []machine.Machine) error {
 	if len(bootSet) <= 0 {
@@ -69,34 +138,45 @@ func (clst Cluster) Boot(bootSet []machine.Machine) error {
 	}
 
 	type bootReq struct {
-		cfg      string
-		size     string
-		region   string
-		diskSize int
+		cfg       string
+		size      string
+		region    string
+		diskSize  int
+		spotPrice string
 	}
 
 	bootReqMap := make(map[bootReq]int64) // From boot request to an instance count.
 	for _, m := range bootSet {
 		br := bootReq{
-			cfg:      cloudcfg.Ubuntu(m.SSHKeys, "xenial"),
-			size:     m.Size,
-			region:   m.Region,
-			diskSize: m.DiskSize,
+			cfg:       cloudcfg.Ubuntu(m.SSHKeys, "xenial"),
+			size:      m.Size,
+			region:    m.Region,
+			diskSize:  m.DiskSize,
+			spotPrice: defaultSpotPrice,
+		}
+		if m.SpotPrice != 0 {
+			br.spotPrice = strconv.FormatFloat(m.SpotPrice, 'f', -1, 64)
 		}
 		bootReqMap[br] = bootReqMap[br] + 1
 	}
 
+	// A failed request doesn't hold back the others, and the first error is
+	// returned once those that succeeded are tagged.
 	var awsIDs []awsID
+	var bootErr error
 	for br, count := range bootReqMap {
 		client := clst.getClient(br.region)
-		groupID, _, err := clst.getCreateSecurityGroup(client)
+		groupID, _, err := clst.getCreateSecurityGroup(client, br.region)
 		if err != nil {
-			return err
+			if bootErr == nil {
+				bootErr = err
+			}
+			continue
 		}
 
 		cloudConfig64 := base64.StdEncoding.EncodeToString([]byte(br.cfg))
 		resp, err := client.RequestSpotInstances(&ec2.RequestSpotInstancesInput{
-			SpotPrice: aws.String(spotPrice),
+			SpotPrice: aws.String(br.spotPrice),
 			LaunchSpecification: &ec2.RequestSpotLaunchSpecification{
 				ImageId:          aws.String(amis[br.region]),
 				InstanceType:     aws.String(br.size),
@@ -109,8 +189,16 @@ func (clst Cluster) Boot(bootSet []machine.Machine) error {
 			InstanceCount: &count,
 		})
 
+		if aerr, ok := err.(awserr.Error); ok &&
+			aerr.Code() == insufficientCapacity {
+			err = machine.CapacityError{
+				Size: br.size, Region: br.region, Err: err}
+		}
 		if err != nil {
-			return err
+			if bootErr == nil {
+				bootErr = err
+			}
+			continue
 		}
 
 		for _, request := range resp.SpotInstanceRequests {
@@ -120,10 +208,14 @@ func (clst Cluster) Boot(bootSet []machine.Machine) error {
 		}
 	}
 
+	// Untagged requests aren't listed, so they would never be stopped.
 	if err := clst.tagSpotRequests(awsIDs); err != nil {
 		return err
 	}
 
+	if bootErr != nil {
+		return bootErr
+	}
 	return clst.wait(awsIDs, true)
 }
 
@@ -194,8 +286,9 @@ func (clst Cluster) List() ([]machine.Machine, error) {
 		insts, err := client.DescribeInstances(&ec2.DescribeInstancesInput{
 			Filters: []*ec2.Filter{
 				{
-					Name:   aws.String("instance.group-name"),
-					Values: []*string{aws.String(clst.namespace)},
+					Name: aws.String("instance.group-name"),
+					Values: []*string{
+						aws.String(clst.groupName(region))},
 				},
 			},
 		})
@@ -230,23 +323,11 @@ func (clst Cluster) List() ([]machine.Machine, error) {
 			// To mitigate this issue, we rely not only on the spot request
 			// tags, but additionally on the instance security group. If a
 			// spot request has a running instance in the appropriate
-			// security group, it is by definition in our namespace.
+			// security group, it is by definition in our cluster.
 			// Thus, we only check the tags for spot requests without
 			// running instances.
-			if inst == nil {
-				var isOurs bool
-				for _, tag := range spot.Tags {
-					ns := clst.namespace
-					if tag != nil && tag.Key != nil &&
-						*tag.Key == ns {
-						isOurs = true
-						break
-					}
-				}
-
-				if !isOurs {
-					continue
-				}
+			if inst == nil && clst.spotOwner(spot) != clst.clusterID {
+				continue
 			}
 
 			machine := machine.Machine{
@@ -306,6 +387,83 @@ func (clst Cluster) List() ([]machine.Machine, error) {
 	return machines, nil
 }
 
+// foreignInstances returns the spot requests that carry the cluster's namespace, but
+// not its cluster ID.  These were either booted by another daemon, or by a version
+// of Quilt that predates cluster IDs, so it's unsafe for us to manage them.
+func (clst Cluster) foreignInstances() ([]string, error) {
+	var foreign []string
+	for region := range amis {
+		client := clst.getClient(region)
+
+		spots, err := client.DescribeSpotInstanceRequests(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Older versions of Quilt named the security group after the namespace
+		// alone, and didn't record a cluster ID.  Unless we adopted that group,
+		// its instances belong to another daemon.
+		insts, err := client.DescribeInstances(&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("instance.group-name"),
+					Values: []*string{aws.String(clst.namespace)},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		legacy := make(map[string]struct{})
+		for _, res := range insts.Reservations {
+			for _, inst := range res.Instances {
+				if *inst.State.Name == ec2.InstanceStateNamePending ||
+					*inst.State.Name == ec2.InstanceStateNameRunning {
+					legacy[*inst.InstanceId] = struct{}{}
+				}
+			}
+		}
+
+		for _, spot := range spots.SpotInstanceRequests {
+			if *spot.State != ec2.SpotInstanceStateActive &&
+				*spot.State != ec2.SpotInstanceStateOpen {
+				continue
+			}
+
+			_, isLegacy := legacy[aws.StringValue(spot.InstanceId)]
+			_, adopted := clst.adopted[region]
+			owner := clst.spotOwner(spot)
+			if (isLegacy && !adopted) ||
+				(owner != "" && owner != clst.clusterID) {
+				foreign = append(foreign, fmt.Sprintf("%s (%s)",
+					*spot.SpotInstanceRequestId, region))
+			}
+		}
+	}
+
+	sort.Strings(foreign)
+	return foreign, nil
+}
+
+// spotOwner returns the cluster ID `spot` was tagged with, or the empty string if
+// it wasn't tagged with the cluster's namespace.  Spot requests tagged by versions
+// of Quilt that predate cluster IDs have an empty tag value, and so are owned by an
+// unknown cluster.
+func (clst Cluster) spotOwner(spot *ec2.SpotInstanceRequest) string {
+	for _, tag := range spot.Tags {
+		if tag == nil || aws.StringValue(tag.Key) != clst.namespace {
+			continue
+		}
+
+		if owner := aws.StringValue(tag.Value); owner != "" {
+			return owner
+		}
+		return "unknown"
+	}
+	return ""
+}
+
 func (clst Cluster) getClient(region string) client {
 	if _, ok := clst.clients[region]; !ok {
 		clst.clients[region] = clst.newClient(region)
@@ -326,7 +484,7 @@ OuterLoop:
 				Tags: []*ec2.Tag{
 					{
 						Key:   aws.String(clst.namespace),
-						Value: aws.String(""),
+						Value: aws.String(clst.clusterID),
 					},
 				},
 				Resources: aws.StringSlice(spotIDs),
@@ -350,10 +508,16 @@ OuterLoop:
 }
 
 /* Wait for the spot request 'ids' to have booted or terminated depending on the value
- * of 'boot' */
+ * of 'boot'.  Booting stops early if EC2 is out of capacity for any of them. */
 func (clst *Cluster) wait(awsIDs []awsID, boot bool) error {
 OuterLoop:
 	for i := 0; i < 100; i++ {
+		if boot {
+			if err := clst.capacityError(awsIDs); err != nil {
+				return err
+			}
+		}
+
 		machines, err := clst.List()
 		if err != nil {
 			log.WithError(err).Warn("Failed to get machines.")
@@ -384,64 +548,171 @@ OuterLoop:
 	return errors.New("timed out")
 }
 
-// SetACLs adds and removes acls in `clst` so that it conforms to `acls`.
-func (clst *Cluster) SetACLs(acls []acl.ACL) error {
-	for region := range amis {
+// capacityError returns a CapacityError if EC2 can't fulfill any of the spot requests
+// `awsIDs` for lack of capacity.  Those requests are cancelled, so that they aren't
+// fulfilled after their machines have fallen back to another size.
+func (clst *Cluster) capacityError(awsIDs []awsID) error {
+	for region, ids := range groupByRegion(awsIDs) {
 		client := clst.getClient(region)
-
-		groupID, ingress, err := clst.getCreateSecurityGroup(client)
+		spots, err := client.DescribeSpotInstanceRequests(
+			&ec2.DescribeSpotInstanceRequestsInput{
+				SpotInstanceRequestIds: aws.StringSlice(getSpotIDs(ids)),
+			})
 		if err != nil {
-			return err
+			log.WithError(err).Warn("Failed to get spot request status.")
+			continue
 		}
 
-		rangesToAdd, foundGroup, rulesToRemove := syncACLs(acls, groupID, ingress)
+		var failed []string
+		var capErr machine.CapacityError
+		for _, spot := range spots.SpotInstanceRequests {
+			if spot.Status == nil || spot.LaunchSpecification == nil {
+				continue
+			}
+
+			code := aws.StringValue(spot.Status.Code)
+			if _, ok := capacityStatuses[code]; !ok {
+				continue
+			}
 
-		if len(rangesToAdd) != 0 {
-			logACLs(true, rangesToAdd)
-			_, err = client.AuthorizeSecurityGroupIngress(
-				&ec2.AuthorizeSecurityGroupIngressInput{
-					GroupName:     aws.String(clst.namespace),
-					IpPermissions: rangesToAdd,
-				},
-			)
-			if err != nil {
-				return err
+			failed = append(failed, *spot.SpotInstanceRequestId)
+			capErr = machine.CapacityError{
+				Size: aws.StringValue(
+					spot.LaunchSpecification.InstanceType),
+				Region: region,
+				Err: fmt.Errorf("%s: %s", code,
+					aws.StringValue(spot.Status.Message)),
 			}
 		}
 
-		if !foundGroup {
-			log.WithField("Group", clst.namespace).Debug("Amazon: Add group")
-			_, err = client.AuthorizeSecurityGroupIngress(
-				&ec2.AuthorizeSecurityGroupIngressInput{
-					GroupName: aws.String(
-						clst.namespace),
-					SourceSecurityGroupName: aws.String(
-						clst.namespace),
-				},
-			)
-			if err != nil {
-				return err
-			}
+		if len(failed) == 0 {
+			continue
 		}
 
-		if len(rulesToRemove) != 0 {
-			logACLs(false, rulesToRemove)
-			_, err = client.RevokeSecurityGroupIngress(
-				&ec2.RevokeSecurityGroupIngressInput{
-					GroupName:     aws.String(clst.namespace),
-					IpPermissions: rulesToRemove,
-				},
-			)
-			if err != nil {
-				return err
+		_, err = client.CancelSpotInstanceRequests(
+			&ec2.CancelSpotInstanceRequestsInput{
+				SpotInstanceRequestIds: aws.StringSlice(failed),
+			})
+		if err != nil {
+			log.WithError(err).Warn("Failed to cancel spot requests.")
+		}
+		return capErr
+	}
+	return nil
+}
+
+// SetACLs adds and removes acls in `clst` so that it conforms to `acls`.  Every rule
+// in the Quilt security group that isn't implied by `acls` is removed, including
+// rules left behind by a previous daemon that crashed mid-sync.  So that a bad tick
+// can't strip the group, removals are only logged the first tick they're planned,
+// and are made if they're planned again on the next.  A failure in one region
+// doesn't hold back the others, and the first error is returned.
+func (clst *Cluster) SetACLs(acls []acl.ACL) error {
+	var regions []string
+	for region := range amis {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	var firstErr error
+	for _, region := range regions {
+		if err := clst.setRegionACLs(region, acls); err != nil {
+			log.WithError(err).WithField("region", region).Debug(
+				"Amazon: Failed to set ACLs")
+			if firstErr == nil {
+				firstErr = err
 			}
 		}
 	}
+	return firstErr
+}
 
-	return nil
+func (clst *Cluster) setRegionACLs(region string, acls []acl.ACL) error {
+	client := clst.getClient(region)
+
+	groupID, ingress, err := clst.getCreateSecurityGroup(client, region)
+	if err != nil {
+		return err
+	}
+
+	rangesToAdd, foundGroup, rulesToRemove := syncACLs(acls, groupID, ingress)
+
+	var firstErr error
+	if len(rangesToAdd) != 0 {
+		logACLs("Add", rangesToAdd)
+		_, err = client.AuthorizeSecurityGroupIngress(
+			&ec2.AuthorizeSecurityGroupIngressInput{
+				GroupName:     aws.String(clst.groupName(region)),
+				IpPermissions: rangesToAdd,
+			},
+		)
+		if err == nil {
+			aclAddCounter.Add(uint64(len(rangesToAdd)))
+		} else {
+			firstErr = err
+		}
+	}
+
+	if !foundGroup {
+		log.WithField("Group", clst.groupName(region)).Debug(
+			"Amazon: Add group")
+		_, err = client.AuthorizeSecurityGroupIngress(
+			&ec2.AuthorizeSecurityGroupIngressInput{
+				GroupName: aws.String(
+					clst.groupName(region)),
+				SourceSecurityGroupName: aws.String(
+					clst.groupName(region)),
+			},
+		)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	toRevoke := clst.planRemovals(region, rulesToRemove)
+	if len(toRevoke) != 0 {
+		logACLs("Remove", toRevoke)
+		_, err = client.RevokeSecurityGroupIngress(
+			&ec2.RevokeSecurityGroupIngressInput{
+				GroupName:     aws.String(clst.groupName(region)),
+				IpPermissions: toRevoke,
+			},
+		)
+		if err == nil {
+			aclRemoveCounter.Add(uint64(len(toRevoke)))
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// planRemovals records `perms` as the removals planned in `region`, and returns
+// those that were also planned in the previous tick.
+func (clst *Cluster) planRemovals(region string,
+	perms []*ec2.IpPermission) []*ec2.IpPermission {
+
+	prev := clst.plannedRemovals[region]
+	planned := map[string]struct{}{}
+	clst.plannedRemovals[region] = planned
+
+	var ready, pending []*ec2.IpPermission
+	for _, perm := range perms {
+		key := perm.String()
+		planned[key] = struct{}{}
+		if _, ok := prev[key]; ok {
+			ready = append(ready, perm)
+		} else {
+			pending = append(pending, perm)
+		}
+	}
+
+	logACLs("Plan to remove", pending)
+	return ready
 }
 
-func (clst *Cluster) getCreateSecurityGroup(client client) (
+func (clst *Cluster) getCreateSecurityGroup(client client, region string) (
 	string, []*ec2.IpPermission, error) {
 
 	resp, err := client.DescribeSecurityGroups(
@@ -450,7 +721,7 @@ func (clst *Cluster) getCreateSecurityGroup(client client) (
 				{
 					Name: aws.String("group-name"),
 					Values: []*string{
-						aws.String(clst.namespace),
+						aws.String(clst.groupName(region)),
 					},
 				},
 			},
@@ -463,7 +734,7 @@ func (clst *Cluster) getCreateSecurityGroup(client client) (
 	groups := resp.SecurityGroups
 	if len(groups) > 1 {
 		err := errors.New("Multiple Security Groups with the same name: " +
-			clst.namespace)
+			clst.groupName(region))
 		return "", nil, err
 	}
 
@@ -474,7 +745,7 @@ func (clst *Cluster) getCreateSecurityGroup(client client) (
 	csgResp, err := client.CreateSecurityGroup(
 		&ec2.CreateSecurityGroupInput{
 			Description: aws.String("Quilt Group"),
-			GroupName:   aws.String(clst.namespace),
+			GroupName:   aws.String(clst.groupName(region)),
 		})
 	if err != nil {
 		return "", nil, err
@@ -559,12 +830,7 @@ func syncACLs(desiredACLs []acl.ACL, desiredGroupID string,
 	return rangesToAdd, foundGroup, toRemove
 }
 
-func logACLs(add bool, perms []*ec2.IpPermission) {
-	action := "Remove"
-	if add {
-		action = "Add"
-	}
-
+func logACLs(action string, perms []*ec2.IpPermission) {
 	for _, perm := range perms {
 		if len(perm.IpRanges) != 0 {
 			// Each rule has three variants (TCP, UDP, and ICMP), but
@@ -3,11 +3,13 @@ package amazon
 
 import (
 	"encoding/base64"
+	"errors"
 	"reflect"
 	"sort"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -19,6 +21,8 @@ import (
 )
 
 const testNamespace = "namespace"
+const testClusterID = "clusterid"
+const testGroupName = testNamespace + "-" + testClusterID
 
 func TestList(t *testing.T) {
 	t.Parallel()
@@ -65,7 +69,7 @@ func TestList(t *testing.T) {
 					Tags: []*ec2.Tag{
 						{
 							Key:   aws.String(testNamespace),
-							Value: aws.String(""),
+							Value: aws.String(testClusterID),
 						},
 					},
 					InstanceId: aws.String("inst1"),
@@ -85,7 +89,7 @@ func TestList(t *testing.T) {
 					Tags: []*ec2.Tag{
 						{
 							Key:   aws.String(testNamespace),
-							Value: aws.String(""),
+							Value: aws.String(testClusterID),
 						},
 					},
 				},
@@ -100,6 +104,18 @@ func TestList(t *testing.T) {
 						},
 					},
 				},
+				// A spot request in our namespace, but booted by
+				// another daemon.
+				{
+					SpotInstanceRequestId: aws.String("spot5"),
+					State: aws.String(ec2.SpotInstanceStateOpen),
+					Tags: []*ec2.Tag{
+						{
+							Key:   aws.String(testNamespace),
+							Value: aws.String("other"),
+						},
+					},
+				},
 			},
 		}, nil,
 	)
@@ -112,7 +128,7 @@ func TestList(t *testing.T) {
 		&ec2.DescribeSpotInstanceRequestsOutput{}, nil,
 	)
 
-	amazonCluster := newAmazon(testNamespace)
+	amazonCluster := newAmazon(testNamespace, testClusterID)
 	amazonCluster.newClient = func(region string) client {
 		if region == "us-west-1" {
 			return mc
@@ -146,6 +162,88 @@ func TestList(t *testing.T) {
 	}, spots)
 }
 
+func TestForeignInstances(t *testing.T) {
+	t.Parallel()
+
+	mc := new(mockClient)
+	// An instance in the security group used before cluster IDs existed.
+	legacyInst := &ec2.Instance{
+		InstanceId: aws.String("inst1"),
+		State: &ec2.InstanceState{
+			Name: aws.String(ec2.InstanceStateNameRunning),
+		},
+	}
+	mc.On("DescribeInstances", mock.Anything).Return(
+		&ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{
+				{Instances: []*ec2.Instance{legacyInst}},
+			},
+		}, nil,
+	)
+
+	tagged := func(id, key, value string) *ec2.SpotInstanceRequest {
+		return &ec2.SpotInstanceRequest{
+			SpotInstanceRequestId: aws.String(id),
+			State: aws.String(ec2.SpotInstanceStateOpen),
+			Tags: []*ec2.Tag{
+				{Key: aws.String(key), Value: aws.String(value)},
+			},
+		}
+	}
+	mc.On("DescribeSpotInstanceRequests", mock.Anything).Return(
+		&ec2.DescribeSpotInstanceRequestsOutput{
+			SpotInstanceRequests: []*ec2.SpotInstanceRequest{
+				{
+					SpotInstanceRequestId: aws.String("legacyInst"),
+					State: aws.String(ec2.SpotInstanceStateActive),
+					InstanceId: aws.String("inst1"),
+				},
+				tagged("legacyTag", testNamespace, ""),
+				tagged("otherCluster", testNamespace, "other"),
+				tagged("ours", testNamespace, testClusterID),
+				tagged("otherNamespace", "notOurs", "other"),
+			},
+		}, nil,
+	)
+
+	emptyClient := new(mockClient)
+	emptyClient.On("DescribeInstances", mock.Anything).Return(
+		&ec2.DescribeInstancesOutput{}, nil,
+	)
+	emptyClient.On("DescribeSpotInstanceRequests", mock.Anything).Return(
+		&ec2.DescribeSpotInstanceRequestsOutput{}, nil,
+	)
+
+	amazonCluster := newAmazon(testNamespace, testClusterID)
+	amazonCluster.newClient = func(region string) client {
+		if region == "us-west-1" {
+			return mc
+		}
+		return emptyClient
+	}
+
+	foreign, err := amazonCluster.foreignInstances()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"legacyInst (us-west-1)", "legacyTag (us-west-1)",
+		"otherCluster (us-west-1)"}, foreign)
+
+	mc.AssertCalled(t, "DescribeInstances", &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("instance.group-name"),
+				Values: []*string{aws.String(testNamespace)},
+			},
+		},
+	})
+
+	// Once we adopt the legacy group, its instances are ours.
+	amazonCluster.adopted["us-west-1"] = struct{}{}
+	foreign, err = amazonCluster.foreignInstances()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"legacyTag (us-west-1)", "otherCluster (us-west-1)"},
+		foreign)
+}
+
 func TestNewACLs(t *testing.T) {
 	t.Parallel()
 
@@ -196,12 +294,12 @@ func TestNewACLs(t *testing.T) {
 		&ec2.DescribeInstancesOutput{}, nil,
 	)
 
-	cluster := newAmazon(testNamespace)
+	cluster := newAmazon(testNamespace, testClusterID)
 	cluster.newClient = func(region string) client {
 		return mc
 	}
 
-	err := cluster.SetACLs([]acl.ACL{
+	acls := []acl.ACL{
 		{
 			CidrIP:  "foo",
 			MinPort: 1,
@@ -212,13 +310,19 @@ func TestNewACLs(t *testing.T) {
 			MinPort: 80,
 			MaxPort: 80,
 		},
-	})
+	}
+
+	// Removals are only planned in the first tick, and are made in the second.
+	err := cluster.SetACLs(acls)
+	assert.Nil(t, err)
+	mc.AssertNotCalled(t, "RevokeSecurityGroupIngress", mock.Anything)
 
+	err = cluster.SetACLs(acls)
 	assert.Nil(t, err)
 
 	mc.AssertCalled(t, "RevokeSecurityGroupIngress",
 		&ec2.RevokeSecurityGroupIngressInput{
-			GroupName: aws.String(testNamespace),
+			GroupName: aws.String(testGroupName),
 			IpPermissions: []*ec2.IpPermission{
 				{
 					IpRanges: []*ec2.IpRange{
@@ -234,8 +338,8 @@ func TestNewACLs(t *testing.T) {
 
 	mc.AssertCalled(t, "AuthorizeSecurityGroupIngress",
 		&ec2.AuthorizeSecurityGroupIngressInput{
-			GroupName:               aws.String(testNamespace),
-			SourceSecurityGroupName: aws.String(testNamespace),
+			GroupName:               aws.String(testGroupName),
+			SourceSecurityGroupName: aws.String(testGroupName),
 		},
 	)
 
@@ -307,6 +411,66 @@ func TestNewACLs(t *testing.T) {
 	}
 }
 
+func TestStaleACLs(t *testing.T) {
+	t.Parallel()
+
+	stale := &ec2.IpPermission{
+		IpRanges:   []*ec2.IpRange{{CidrIp: aws.String("stale")}},
+		FromPort:   aws.Int64(1),
+		ToPort:     aws.Int64(65535),
+		IpProtocol: aws.String("tcp"),
+	}
+	foo := &ec2.IpPermission{
+		IpRanges:   []*ec2.IpRange{{CidrIp: aws.String("foo")}},
+		FromPort:   aws.Int64(1),
+		ToPort:     aws.Int64(65535),
+		IpProtocol: aws.String("tcp"),
+	}
+
+	// A previous daemon crashed after opening "stale", and before opening the
+	// rest of "foo".  Adding "foo" keeps failing, which mustn't stop the cleanup.
+	mc := new(mockClient)
+	mc.On("DescribeSecurityGroups", mock.Anything).Return(
+		&ec2.DescribeSecurityGroupsOutput{
+			SecurityGroups: []*ec2.SecurityGroup{
+				{
+					IpPermissions: []*ec2.IpPermission{stale, foo},
+					GroupId:       aws.String(""),
+				},
+			},
+		}, nil,
+	)
+	mc.On("AuthorizeSecurityGroupIngress", mock.Anything).Return(
+		nil, errors.New("authorize"),
+	)
+	mc.On("RevokeSecurityGroupIngress", mock.Anything).Return(
+		&ec2.RevokeSecurityGroupIngressOutput{}, nil,
+	)
+
+	cluster := newAmazon(testNamespace, testClusterID)
+	cluster.newClient = func(region string) client {
+		return mc
+	}
+
+	// The first tick plans to remove both rules, but "foo" is wanted again by the
+	// second, so only "stale" is removed.
+	err := cluster.SetACLs(nil)
+	assert.EqualError(t, err, "authorize")
+	mc.AssertNotCalled(t, "RevokeSecurityGroupIngress", mock.Anything)
+
+	removed := aclRemoveCounter.Get()
+	err = cluster.SetACLs([]acl.ACL{{CidrIP: "foo", MinPort: 1, MaxPort: 65535}})
+	assert.EqualError(t, err, "authorize")
+
+	exp := &ec2.RevokeSecurityGroupIngressInput{
+		GroupName:     aws.String(testGroupName),
+		IpPermissions: []*ec2.IpPermission{stale},
+	}
+	mc.AssertCalled(t, "RevokeSecurityGroupIngress", exp)
+	mc.AssertNumberOfCalls(t, "RevokeSecurityGroupIngress", len(amis))
+	assert.True(t, aclRemoveCounter.Get()-removed >= uint64(len(amis)))
+}
+
 func TestBoot(t *testing.T) {
 	t.Parallel()
 
@@ -347,7 +511,7 @@ func TestBoot(t *testing.T) {
 					Tags: []*ec2.Tag{
 						{
 							Key:   aws.String(testNamespace),
-							Value: aws.String(""),
+							Value: aws.String(testClusterID),
 						},
 					},
 				},
@@ -357,7 +521,7 @@ func TestBoot(t *testing.T) {
 					Tags: []*ec2.Tag{
 						{
 							Key:   aws.String(testNamespace),
-							Value: aws.String(""),
+							Value: aws.String(testClusterID),
 						},
 					},
 				},
@@ -365,7 +529,7 @@ func TestBoot(t *testing.T) {
 		}, nil,
 	)
 
-	amazonCluster := newAmazon(testNamespace)
+	amazonCluster := newAmazon(testNamespace, testClusterID)
 	amazonCluster.newClient = func(region string) client {
 		return mc
 	}
@@ -387,7 +551,7 @@ func TestBoot(t *testing.T) {
 	cfg := cloudcfg.Ubuntu(nil, "xenial")
 	mc.AssertCalled(t, "RequestSpotInstances",
 		&ec2.RequestSpotInstancesInput{
-			SpotPrice: aws.String(spotPrice),
+			SpotPrice: aws.String(defaultSpotPrice),
 			LaunchSpecification: &ec2.RequestSpotLaunchSpecification{
 				ImageId:      aws.String(amis["us-west-1"]),
 				InstanceType: aws.String("m4.large"),
@@ -405,7 +569,7 @@ func TestBoot(t *testing.T) {
 			Tags: []*ec2.Tag{
 				{
 					Key:   aws.String(testNamespace),
-					Value: aws.String(""),
+					Value: aws.String(testClusterID),
 				},
 			},
 			Resources: aws.StringSlice([]string{"spot1", "spot2"}),
@@ -413,6 +577,156 @@ func TestBoot(t *testing.T) {
 	)
 }
 
+func TestBootCapacityError(t *testing.T) {
+	t.Parallel()
+
+	mc := new(mockClient)
+	mc.On("DescribeSecurityGroups", mock.Anything).Return(
+		&ec2.DescribeSecurityGroupsOutput{
+			SecurityGroups: []*ec2.SecurityGroup{
+				{
+					GroupId: aws.String("groupId"),
+				},
+			},
+		}, nil,
+	)
+	capErr := awserr.New(insufficientCapacity, "no m4.large capacity", nil)
+	mc.On("RequestSpotInstances", mock.Anything).Return(nil, capErr)
+
+	amazonCluster := newAmazon(testNamespace, testClusterID)
+	amazonCluster.newClient = func(region string) client {
+		return mc
+	}
+
+	err := amazonCluster.Boot([]machine.Machine{
+		{Region: "us-west-1", Size: "m4.large", DiskSize: 32},
+	})
+	assert.Equal(t, machine.CapacityError{
+		Size: "m4.large", Region: "us-west-1", Err: capErr}, err)
+
+	// Other errors aren't capacity errors.
+	mc = new(mockClient)
+	mc.On("DescribeSecurityGroups", mock.Anything).Return(
+		&ec2.DescribeSecurityGroupsOutput{
+			SecurityGroups: []*ec2.SecurityGroup{
+				{
+					GroupId: aws.String("groupId"),
+				},
+			},
+		}, nil,
+	)
+	otherErr := awserr.New("Unsupported", "unsupported", nil)
+	mc.On("RequestSpotInstances", mock.Anything).Return(nil, otherErr)
+
+	// The cluster caches its clients, so a new one is needed to use the new mock.
+	amazonCluster = newAmazon(testNamespace, testClusterID)
+	amazonCluster.newClient = func(region string) client {
+		return mc
+	}
+
+	err = amazonCluster.Boot([]machine.Machine{
+		{Region: "us-west-1", Size: "m4.large", DiskSize: 32},
+	})
+	assert.Equal(t, otherErr, err)
+}
+
+func TestBootSpotCapacity(t *testing.T) {
+	t.Parallel()
+
+	groups := &ec2.DescribeSecurityGroupsOutput{
+		SecurityGroups: []*ec2.SecurityGroup{{GroupId: aws.String("groupId")}},
+	}
+
+	// EC2 accepts the spot request, but marks it as lacking capacity.
+	mc := new(mockClient)
+	mc.On("DescribeSecurityGroups", mock.Anything).Return(groups, nil)
+	mc.On("RequestSpotInstances", mock.Anything).Return(
+		&ec2.RequestSpotInstancesOutput{
+			SpotInstanceRequests: []*ec2.SpotInstanceRequest{
+				{SpotInstanceRequestId: aws.String("spot1")},
+			},
+		}, nil)
+	mc.On("CreateTags", mock.Anything).Return(&ec2.CreateTagsOutput{}, nil)
+	mc.On("DescribeSpotInstanceRequests", mock.Anything).Return(
+		&ec2.DescribeSpotInstanceRequestsOutput{
+			SpotInstanceRequests: []*ec2.SpotInstanceRequest{{
+				SpotInstanceRequestId: aws.String("spot1"),
+				State: aws.String(ec2.SpotInstanceStateOpen),
+				Status: &ec2.SpotInstanceStatus{
+					Code:    aws.String("capacity-not-available"),
+					Message: aws.String("no capacity"),
+				},
+				LaunchSpecification: &ec2.LaunchSpecification{
+					InstanceType: aws.String("m4.large"),
+				},
+			}},
+		}, nil)
+	mc.On("CancelSpotInstanceRequests", mock.Anything).Return(
+		&ec2.CancelSpotInstanceRequestsOutput{}, nil)
+
+	amazonCluster := newAmazon(testNamespace, testClusterID)
+	amazonCluster.newClient = func(region string) client {
+		return mc
+	}
+
+	err := amazonCluster.Boot([]machine.Machine{
+		{Region: "us-west-1", Size: "m4.large", DiskSize: 32},
+	})
+	assert.Equal(t, machine.CapacityError{Size: "m4.large", Region: "us-west-1",
+		Err: errors.New("capacity-not-available: no capacity")}, err)
+
+	mc.AssertCalled(t, "CreateTags", mock.Anything)
+	mc.AssertCalled(t, "CancelSpotInstanceRequests",
+		&ec2.CancelSpotInstanceRequestsInput{
+			SpotInstanceRequestIds: aws.StringSlice([]string{"spot1"}),
+		})
+}
+
+func TestBootTagsOnFailure(t *testing.T) {
+	t.Parallel()
+
+	groups := &ec2.DescribeSecurityGroupsOutput{
+		SecurityGroups: []*ec2.SecurityGroup{{GroupId: aws.String("groupId")}},
+	}
+
+	ok := new(mockClient)
+	ok.On("DescribeSecurityGroups", mock.Anything).Return(groups, nil)
+	ok.On("RequestSpotInstances", mock.Anything).Return(
+		&ec2.RequestSpotInstancesOutput{
+			SpotInstanceRequests: []*ec2.SpotInstanceRequest{
+				{SpotInstanceRequestId: aws.String("spot1")},
+			},
+		}, nil)
+	ok.On("CreateTags", mock.Anything).Return(&ec2.CreateTagsOutput{}, nil)
+
+	failing := new(mockClient)
+	failing.On("DescribeSecurityGroups", mock.Anything).Return(groups, nil)
+	bootErr := awserr.New("Unsupported", "unsupported", nil)
+	failing.On("RequestSpotInstances", mock.Anything).Return(nil, bootErr)
+
+	amazonCluster := newAmazon(testNamespace, testClusterID)
+	amazonCluster.newClient = func(region string) client {
+		if region == "us-west-2" {
+			return failing
+		}
+		return ok
+	}
+
+	// Whichever request is made first, the one that succeeded is tagged.
+	err := amazonCluster.Boot([]machine.Machine{
+		{Region: "us-west-1", Size: "m4.large", DiskSize: 32},
+		{Region: "us-west-2", Size: "m4.large", DiskSize: 32},
+	})
+	assert.Equal(t, bootErr, err)
+	ok.AssertCalled(t, "CreateTags", &ec2.CreateTagsInput{
+		Tags: []*ec2.Tag{{
+			Key:   aws.String(testNamespace),
+			Value: aws.String(testClusterID),
+		}},
+		Resources: aws.StringSlice([]string{"spot1"}),
+	})
+}
+
 func TestStop(t *testing.T) {
 	t.Parallel()
 
@@ -452,7 +766,7 @@ func TestStop(t *testing.T) {
 		&ec2.DescribeInstancesOutput{}, nil,
 	)
 
-	amazonCluster := newAmazon(testNamespace)
+	amazonCluster := newAmazon(testNamespace, testClusterID)
 	amazonCluster.newClient = func(region string) client {
 		return mc
 	}
@@ -18,6 +18,9 @@ type client interface {
 
 	CreateTags(*ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error)
 
+	DeleteSecurityGroup(*ec2.DeleteSecurityGroupInput) (
+		*ec2.DeleteSecurityGroupOutput, error)
+
 	DescribeSecurityGroups(*ec2.DescribeSecurityGroupsInput) (
 		*ec2.DescribeSecurityGroupsOutput, error)
 
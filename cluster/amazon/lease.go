@@ -0,0 +1,124 @@
+package amazon
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/NetSys/quilt/cluster/machine"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The error code EC2 returns when creating a security group whose name is taken.
+const duplicateGroup = "InvalidGroup.Duplicate"
+
+// The tag recording when a lease was last renewed.
+const leaseRenewedTag = "quilt-lease-renewed"
+
+// The format of a lease group's description.  Descriptions can't be changed, so only
+// what's fixed for the life of the lease is recorded in it.
+const leaseDescription = "Quilt lease held by %s since %s"
+
+// The namespace's lease is a security group in the default region.  Security group
+// names are unique, so creating the group is an atomic test-and-set.
+func (clst Cluster) leaseName() string {
+	return "quilt-lease-" + clst.namespace
+}
+
+// GetLease returns the namespace's lease, and whether it has one.
+func (clst Cluster) GetLease() (machine.Lease, bool, error) {
+	resp, err := clst.getClient(DefaultRegion).DescribeSecurityGroups(
+		&ec2.DescribeSecurityGroupsInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("group-name"),
+					Values: []*string{aws.String(clst.leaseName())},
+				},
+			},
+		})
+	if err != nil {
+		return machine.Lease{}, false, err
+	}
+
+	switch len(resp.SecurityGroups) {
+	case 0:
+		return machine.Lease{}, false, nil
+	case 1:
+	default:
+		return machine.Lease{}, false, errors.New("multiple security " +
+			"groups with the same name: " + clst.leaseName())
+	}
+
+	group := resp.SecurityGroups[0]
+	var acquired string
+	l := machine.Lease{ID: aws.StringValue(group.GroupId)}
+	_, err = fmt.Sscanf(aws.StringValue(group.Description), leaseDescription,
+		&l.Holder, &acquired)
+	if err == nil {
+		l.Acquired, err = time.Parse(time.RFC3339, acquired)
+	}
+	if err != nil {
+		return machine.Lease{}, false, fmt.Errorf("malformed lease %s: %s",
+			l.ID, aws.StringValue(group.Description))
+	}
+
+	l.Renewed = l.Acquired
+	for _, tag := range group.Tags {
+		if aws.StringValue(tag.Key) != leaseRenewedTag {
+			continue
+		}
+
+		renewed, err := time.Parse(time.RFC3339, aws.StringValue(tag.Value))
+		if err == nil && renewed.After(l.Renewed) {
+			l.Renewed = renewed
+		}
+	}
+	return l, true, nil
+}
+
+// CreateLease creates a lease on the namespace held by `l.Holder`, and returns it.
+// It fails with machine.ErrLeaseExists if the namespace already has a lease.
+func (clst Cluster) CreateLease(l machine.Lease) (machine.Lease, error) {
+	resp, err := clst.getClient(DefaultRegion).CreateSecurityGroup(
+		&ec2.CreateSecurityGroupInput{
+			GroupName: aws.String(clst.leaseName()),
+			Description: aws.String(fmt.Sprintf(leaseDescription, l.Holder,
+				l.Acquired.UTC().Format(time.RFC3339))),
+		})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == duplicateGroup {
+		return machine.Lease{}, machine.ErrLeaseExists
+	} else if err != nil {
+		return machine.Lease{}, err
+	}
+
+	l.ID = aws.StringValue(resp.GroupId)
+	l.Renewed = l.Acquired
+	return l, nil
+}
+
+// RenewLease records that `l` was renewed at `l.Renewed`.  It fails if `l` no longer
+// exists because another daemon took it over.
+func (clst Cluster) RenewLease(l machine.Lease) error {
+	_, err := clst.getClient(DefaultRegion).CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String(l.ID)},
+		Tags: []*ec2.Tag{
+			{
+				Key:   aws.String(leaseRenewedTag),
+				Value: aws.String(l.Renewed.UTC().Format(time.RFC3339)),
+			},
+		},
+	})
+	return err
+}
+
+// DeleteLease deletes `l`.  It fails if `l` was already deleted, even if the
+// namespace has since been leased again, so that daemons racing to take over an
+// expired lease can't delete each other's.
+func (clst Cluster) DeleteLease(l machine.Lease) error {
+	_, err := clst.getClient(DefaultRegion).DeleteSecurityGroup(
+		&ec2.DeleteSecurityGroupInput{GroupId: aws.String(l.ID)})
+	return err
+}
@@ -0,0 +1,108 @@
+package amazon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/NetSys/quilt/cluster/machine"
+)
+
+func TestGetLease(t *testing.T) {
+	t.Parallel()
+
+	mc := new(mockClient)
+	clst := newAmazon(testNamespace, testClusterID)
+	clst.newClient = func(region string) client {
+		assert.Equal(t, DefaultRegion, region)
+		return mc
+	}
+
+	describe := mc.On("DescribeSecurityGroups", &ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("group-name"),
+			Values: []*string{aws.String("quilt-lease-namespace")},
+		}},
+	})
+
+	describe.Return(&ec2.DescribeSecurityGroupsOutput{}, nil)
+	_, ok, err := clst.GetLease()
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	acquired := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	group := &ec2.SecurityGroup{
+		GroupId: aws.String("sg-1"),
+		Description: aws.String("Quilt lease held by other " +
+			"since 2017-01-02T03:04:05Z"),
+	}
+	describe.Return(&ec2.DescribeSecurityGroupsOutput{
+		SecurityGroups: []*ec2.SecurityGroup{group}}, nil)
+
+	// A lease that was never renewed.
+	lease, ok, err := clst.GetLease()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, machine.Lease{ID: "sg-1", Holder: "other", Acquired: acquired,
+		Renewed: acquired}, lease)
+
+	group.Tags = []*ec2.Tag{{
+		Key:   aws.String(leaseRenewedTag),
+		Value: aws.String("2017-01-02T03:14:05Z"),
+	}}
+	lease, _, err = clst.GetLease()
+	assert.NoError(t, err)
+	assert.Equal(t, acquired.Add(10*time.Minute), lease.Renewed)
+
+	group.Description = aws.String("Quilt Group")
+	_, _, err = clst.GetLease()
+	assert.EqualError(t, err, "malformed lease sg-1: Quilt Group")
+}
+
+func TestCreateLease(t *testing.T) {
+	t.Parallel()
+
+	mc := new(mockClient)
+	clst := newAmazon(testNamespace, testClusterID)
+	clst.newClient = func(region string) client { return mc }
+
+	acquired := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	create := mc.On("CreateSecurityGroup", &ec2.CreateSecurityGroupInput{
+		GroupName: aws.String("quilt-lease-namespace"),
+		Description: aws.String("Quilt lease held by clusterid " +
+			"since 2017-01-02T03:04:05Z"),
+	})
+
+	create.Return(&ec2.CreateSecurityGroupOutput{GroupId: aws.String("sg-1")}, nil)
+	lease, err := clst.CreateLease(machine.Lease{Holder: testClusterID,
+		Acquired: acquired})
+	assert.NoError(t, err)
+	assert.Equal(t, machine.Lease{ID: "sg-1", Holder: testClusterID,
+		Acquired: acquired, Renewed: acquired}, lease)
+
+	create.Return(nil, awserr.New(duplicateGroup, "already exists", nil))
+	_, err = clst.CreateLease(machine.Lease{Holder: testClusterID,
+		Acquired: acquired})
+	assert.Equal(t, machine.ErrLeaseExists, err)
+
+	mc.On("CreateTags", &ec2.CreateTagsInput{
+		Resources: []*string{aws.String("sg-1")},
+		Tags: []*ec2.Tag{{
+			Key:   aws.String(leaseRenewedTag),
+			Value: aws.String("2017-01-02T03:14:05Z"),
+		}},
+	}).Return(nil, nil).Once()
+	lease.Renewed = acquired.Add(10 * time.Minute)
+	assert.NoError(t, clst.RenewLease(lease))
+
+	mc.On("DeleteSecurityGroup", &ec2.DeleteSecurityGroupInput{
+		GroupId: aws.String("sg-1"),
+	}).Return(nil, nil).Once()
+	assert.NoError(t, clst.DeleteLease(lease))
+
+	mc.AssertExpectations(t)
+}
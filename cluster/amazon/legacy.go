@@ -0,0 +1,149 @@
+package amazon
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The tag recording which daemon adopted a legacy security group.
+const adoptedByTag = "quilt-adopted-by"
+
+// adoptLegacyGroups takes over the security groups that versions of Quilt before
+// cluster IDs named after the namespace alone, so that upgrading the daemon doesn't
+// strand the machines in them.  The first daemon to find a legacy group untagged
+// claims it by tagging it with its cluster ID, and from then on keeps its instances
+// in that group rather than in one named after its cluster ID.  Groups claimed by
+// another daemon are left alone, and their machines are reported as foreign.
+func (clst Cluster) adoptLegacyGroups() error {
+	for region := range amis {
+		client := clst.getClient(region)
+
+		groupID, owner, err := clst.legacyGroup(client)
+		if err != nil {
+			return err
+		} else if groupID == "" {
+			continue
+		}
+
+		if owner == "" {
+			_, err := client.CreateTags(&ec2.CreateTagsInput{
+				Tags: []*ec2.Tag{{
+					Key:   aws.String(adoptedByTag),
+					Value: aws.String(clst.clusterID),
+				}},
+				Resources: []*string{aws.String(groupID)},
+			})
+			if err != nil {
+				return err
+			}
+
+			// Another daemon may have claimed the group at the same time, in
+			// which case whichever tag stuck wins.
+			if _, owner, err = clst.legacyGroup(client); err != nil {
+				return err
+			}
+		}
+
+		if owner != clst.clusterID {
+			continue
+		}
+
+		clst.adopted[region] = struct{}{}
+		if err := clst.adoptLegacySpots(client, groupID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacyGroup returns the ID of the namespace's legacy security group, and the cluster
+// ID of the daemon that adopted it.  The ID is empty if there's no such group.
+func (clst Cluster) legacyGroup(client client) (string, string, error) {
+	resp, err := client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("group-name"),
+				Values: []*string{aws.String(clst.namespace)},
+			},
+		},
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	switch len(resp.SecurityGroups) {
+	case 0:
+		return "", "", nil
+	case 1:
+	default:
+		return "", "", errors.New(
+			"multiple security groups with the same name: " + clst.namespace)
+	}
+
+	group := resp.SecurityGroups[0]
+	for _, tag := range group.Tags {
+		if aws.StringValue(tag.Key) == adoptedByTag {
+			return aws.StringValue(group.GroupId), aws.StringValue(tag.Value),
+				nil
+		}
+	}
+	return aws.StringValue(group.GroupId), "", nil
+}
+
+// adoptLegacySpots tags the spot requests that legacy versions of Quilt booted into
+// the adopted group, `groupID`, with the cluster's ID.  Only requests whose instances
+// are in the group are provably ours, so open requests are left for whoever booted
+// them.  It's repeated every time the daemon starts, in case it crashed partway
+// through.
+func (clst Cluster) adoptLegacySpots(client client, groupID string) error {
+	insts, err := client.DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("instance.group-id"),
+				Values: []*string{aws.String(groupID)},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	inGroup := map[string]struct{}{}
+	for _, res := range insts.Reservations {
+		for _, inst := range res.Instances {
+			inGroup[aws.StringValue(inst.InstanceId)] = struct{}{}
+		}
+	}
+
+	spots, err := client.DescribeSpotInstanceRequests(nil)
+	if err != nil {
+		return err
+	}
+
+	var spotIDs []string
+	for _, spot := range spots.SpotInstanceRequests {
+		_, ours := inGroup[aws.StringValue(spot.InstanceId)]
+		if ours && clst.spotOwner(spot) == "unknown" {
+			spotIDs = append(spotIDs,
+				aws.StringValue(spot.SpotInstanceRequestId))
+		}
+	}
+
+	if len(spotIDs) == 0 {
+		return nil
+	}
+
+	log.WithField("spots", spotIDs).Info("Amazon: Adopting legacy spot requests")
+	_, err = client.CreateTags(&ec2.CreateTagsInput{
+		Tags: []*ec2.Tag{{
+			Key:   aws.String(clst.namespace),
+			Value: aws.String(clst.clusterID),
+		}},
+		Resources: aws.StringSlice(spotIDs),
+	})
+	return err
+}
@@ -0,0 +1,119 @@
+package amazon
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAdoptLegacyGroups(t *testing.T) {
+	t.Parallel()
+
+	group := &ec2.SecurityGroup{GroupId: aws.String("sg-legacy")}
+	mc := new(mockClient)
+	mc.On("DescribeSecurityGroups", &ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("group-name"),
+			Values: []*string{aws.String(testNamespace)},
+		}},
+	}).Return(&ec2.DescribeSecurityGroupsOutput{
+		SecurityGroups: []*ec2.SecurityGroup{group}}, nil)
+
+	adoptTags := &ec2.CreateTagsInput{
+		Tags: []*ec2.Tag{{
+			Key:   aws.String(adoptedByTag),
+			Value: aws.String(testClusterID),
+		}},
+		Resources: []*string{aws.String("sg-legacy")},
+	}
+	mc.On("CreateTags", adoptTags).Run(func(mock.Arguments) {
+		group.Tags = adoptTags.Tags
+	}).Return(&ec2.CreateTagsOutput{}, nil)
+
+	mc.On("DescribeInstances", &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("instance.group-id"),
+			Values: []*string{aws.String("sg-legacy")},
+		}},
+	}).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{
+			Instances: []*ec2.Instance{
+				{InstanceId: aws.String("i-legacy")},
+			},
+		}},
+	}, nil)
+
+	// Of the spot requests without a known owner, only the one whose instance is
+	// in the legacy group is provably ours.
+	untagged := []*ec2.Tag{{
+		Key:   aws.String(testNamespace),
+		Value: aws.String(""),
+	}}
+	mc.On("DescribeSpotInstanceRequests", mock.Anything).Return(
+		&ec2.DescribeSpotInstanceRequestsOutput{
+			SpotInstanceRequests: []*ec2.SpotInstanceRequest{
+				{
+					SpotInstanceRequestId: aws.String("legacy"),
+					InstanceId:            aws.String("i-legacy"),
+					Tags:                  untagged,
+				},
+				{
+					SpotInstanceRequestId: aws.String("elsewhere"),
+					InstanceId:            aws.String("i-elsewhere"),
+					Tags:                  untagged,
+				},
+				{
+					SpotInstanceRequestId: aws.String("open"),
+					Tags:                  untagged,
+				},
+				{
+					SpotInstanceRequestId: aws.String("ours"),
+					Tags: []*ec2.Tag{{
+						Key:   aws.String(testNamespace),
+						Value: aws.String(testClusterID),
+					}},
+				},
+			},
+		}, nil)
+	spotTags := &ec2.CreateTagsInput{
+		Tags: []*ec2.Tag{{
+			Key:   aws.String(testNamespace),
+			Value: aws.String(testClusterID),
+		}},
+		Resources: []*string{aws.String("legacy")},
+	}
+	mc.On("CreateTags", spotTags).Return(&ec2.CreateTagsOutput{}, nil)
+
+	emptyClient := new(mockClient)
+	emptyClient.On("DescribeSecurityGroups", mock.Anything).Return(
+		&ec2.DescribeSecurityGroupsOutput{}, nil)
+
+	newCluster := func(clusterID string) *Cluster {
+		clst := newAmazon(testNamespace, clusterID)
+		clst.newClient = func(region string) client {
+			if region == "us-west-1" {
+				return mc
+			}
+			return emptyClient
+		}
+		return clst
+	}
+
+	// The first daemon to find the legacy group adopts it, along with the spot
+	// requests booted into it.
+	clst := newCluster(testClusterID)
+	assert.NoError(t, clst.adoptLegacyGroups())
+	mc.AssertCalled(t, "CreateTags", adoptTags)
+	mc.AssertCalled(t, "CreateTags", spotTags)
+	assert.Equal(t, testNamespace, clst.groupName("us-west-1"))
+	assert.Equal(t, testGroupName, clst.groupName("us-west-2"))
+
+	// Other daemons leave it alone.
+	other := newCluster("other")
+	assert.NoError(t, other.adoptLegacyGroups())
+	assert.Equal(t, testNamespace+"-other", other.groupName("us-west-1"))
+	mc.AssertNumberOfCalls(t, "CreateTags", 2)
+}
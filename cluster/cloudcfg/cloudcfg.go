@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"strings"
 	"text/template"
+
+	"github.com/NetSys/quilt/faults"
 )
 
 const (
@@ -11,19 +13,22 @@ const (
 )
 
 // Ubuntu generates a cloud config file for the Ubuntu operating system with the
-// corresponding `version`.
+// corresponding `version`.  If fault injection is enabled in the daemon, it's enabled
+// in the minion too.
 func Ubuntu(keys []string, version string) string {
 	t := template.Must(template.New("cloudConfig").Parse(cfgTemplate))
 
 	var cloudConfigBytes bytes.Buffer
 	err := t.Execute(&cloudConfigBytes, struct {
-		QuiltImage    string
-		UbuntuVersion string
-		SSHKeys       string
+		QuiltImage     string
+		UbuntuVersion  string
+		SSHKeys        string
+		FaultInjection bool
 	}{
-		QuiltImage:    quiltImage,
-		UbuntuVersion: version,
-		SSHKeys:       strings.Join(keys, "\n"),
+		QuiltImage:     quiltImage,
+		UbuntuVersion:  version,
+		SSHKeys:        strings.Join(keys, "\n"),
+		FaultInjection: faults.Enabled(),
 	})
 	if err != nil {
 		panic(err)
@@ -1,6 +1,10 @@
 package cloudcfg
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/NetSys/quilt/faults"
+)
 
 func TestCloudConfig(t *testing.T) {
 	cfgTemplate = "({{.QuiltImage}}) ({{.SSHKeys}}) ({{.UbuntuVersion}})"
@@ -11,3 +15,17 @@ func TestCloudConfig(t *testing.T) {
 		t.Errorf("res: %s\nexp: %s", res, exp)
 	}
 }
+
+func TestCloudConfigFaultInjection(t *testing.T) {
+	cfgTemplate = "quilt minion{{if .FaultInjection}} -insecure-fault-injection{{end}}"
+
+	if res := Ubuntu(nil, "1"); res != "quilt minion" {
+		t.Errorf("res: %s\nexp: quilt minion", res)
+	}
+
+	faults.Enable()
+	exp := "quilt minion -insecure-fault-injection"
+	if res := Ubuntu(nil, "1"); res != exp {
+		t.Errorf("res: %s\nexp: %s", res, exp)
+	}
+}
@@ -31,8 +31,9 @@ initialize_docker() {
 
 	[Service]
 	# The below empty ExecStart deletes the official one installed by docker daemon.
+	# With live restore, the containers keep running while the daemon restarts.
 	ExecStart=
-	ExecStart=/usr/bin/docker daemon --bridge=none -H unix:///var/run/docker.sock
+	ExecStart=/usr/bin/docker daemon --bridge=none --live-restore -H unix:///var/run/docker.sock
 
 	[Install]
 	WantedBy=multi-user.target
@@ -57,8 +58,9 @@ initialize_minion() {
 	-v /etc/ssl/certs/ca-certificates.crt:/etc/ssl/certs/ca-certificates.crt \
 	-v /home/quilt/.ssh:/home/quilt/.ssh:rw \
 	-v /proc:/hostproc:ro -v /var/run/netns:/var/run/netns:rw \
-	-v /run/docker:/run/docker:rw {{.QuiltImage}} \
-	quilt minion
+	-v /run/docker:/run/docker:rw -v /var/lib/quilt:/var/lib/quilt:rw \
+	{{.QuiltImage}} \
+	quilt minion{{if .FaultInjection}} -insecure-fault-injection{{end}}
 	Restart=on-failure
 
 	[Install]
@@ -93,6 +95,10 @@ setup_user() {
 echo -n "Start Boot Script: " >> /var/log/bootscript.log
 date >> /var/log/bootscript.log
 
+# Record when booting began, so that the minion can report how long it took to start.
+mkdir -p /var/lib/quilt
+date +%s > /var/lib/quilt/boot-start
+
 export DEBIAN_FRONTEND=noninteractive
 
 ssh_keys="{{.SSHKeys}}"
@@ -9,8 +9,10 @@ import (
 	"github.com/NetSys/quilt/cluster/foreman"
 	"github.com/NetSys/quilt/cluster/google"
 	"github.com/NetSys/quilt/cluster/machine"
+	"github.com/NetSys/quilt/cluster/static"
 	"github.com/NetSys/quilt/cluster/vagrant"
 	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/faults"
 	"github.com/NetSys/quilt/join"
 	"github.com/NetSys/quilt/util"
 	log "github.com/Sirupsen/logrus"
@@ -27,14 +29,24 @@ type provider interface {
 }
 
 // Store the providers in a variable so we can change it in the tests
-var allProviders = []db.Provider{db.Amazon, db.Google, db.Vagrant}
+var allProviders = []db.Provider{db.Amazon, db.Google, db.Vagrant, db.Static}
 
 type cluster struct {
 	namespace string
+	clusterID string
 	conn      db.Conn
 	providers map[db.Provider]provider
+
+	// After failing to get the cluster ID, the cluster waits `idBackoff` before
+	// trying again at `idRetry`.
+	idBackoff time.Duration
+	idRetry   time.Time
 }
 
+// The bounds on how long to wait before retrying a failure to get the cluster ID.
+const minIDBackoff = 30 * time.Second
+const maxIDBackoff = 10 * time.Minute
+
 var myIP = util.MyIP
 var sleep = time.Sleep
 
@@ -58,7 +70,15 @@ func updateCluster(conn db.Conn, clst *cluster) *cluster {
 	}
 
 	if clst == nil || clst.namespace != namespace {
-		clst = newCluster(conn, namespace)
+		clst = &cluster{namespace: namespace, conn: conn}
+	}
+
+	// Without an ID, the cluster can't tell its machines apart from those of other
+	// daemons, so it doesn't touch any until it connects.
+	if clst.clusterID == "" {
+		if !clst.connect() {
+			return clst
+		}
 		clst.runOnce()
 		foreman.Init(clst.conn)
 	}
@@ -70,22 +90,49 @@ func updateCluster(conn db.Conn, clst *cluster) *cluster {
 }
 
 func newCluster(conn db.Conn, namespace string) *cluster {
-	clst := &cluster{
-		namespace: namespace,
-		conn:      conn,
-		providers: make(map[db.Provider]provider),
+	clst := &cluster{namespace: namespace, conn: conn}
+	clst.connect()
+	return clst
+}
+
+// connect gets the cluster ID and connects to the providers, returning whether it
+// succeeded.  Failures to get the ID are retried with exponential backoff, rather than
+// on every tick.
+func (clst *cluster) connect() bool {
+	now := timeNow()
+	if now.Before(clst.idRetry) {
+		return false
+	}
+
+	clusterID, err := getClusterID()
+	if err != nil {
+		clst.idBackoff *= 2
+		if clst.idBackoff < minIDBackoff {
+			clst.idBackoff = minIDBackoff
+		} else if clst.idBackoff > maxIDBackoff {
+			clst.idBackoff = maxIDBackoff
+		}
+		clst.idRetry = now.Add(clst.idBackoff)
+		log.WithError(err).Errorf("Failed to get cluster ID, retrying in %s",
+			clst.idBackoff)
+		return false
 	}
+	clst.clusterID = clusterID
 
+	clst.providers = make(map[db.Provider]provider)
 	for _, p := range allProviders {
-		prvdr, err := newProvider(p, namespace)
+		prvdr, err := newProvider(p, clst.namespace, clusterID)
 		if err != nil {
 			log.Debugf("Failed to connect to provider %s: %s", p, err)
 		} else {
+			if faults.Enabled() {
+				prvdr = faultyProvider{prvdr}
+			}
 			clst.providers[p] = prvdr
 		}
 	}
 
-	return clst
+	return true
 }
 
 func (clst cluster) runOnce() {
@@ -100,19 +147,33 @@ func (clst cluster) runOnce() {
 	 * instances) that should be reflected in the database.  Therefore, if updates
 	 * are necessary the code loops so that database can be updated before the next
 	 * runOnce() call.  Once the loop as converged, it then updates the cluster ACLs
-	 * before finally exiting. */
+	 * before finally exiting.
+	 *
+	 * None of this happens unless the daemon holds the namespace's lease, so that
+	 * daemons sharing a namespace don't undo each other's changes. */
+	if !clst.holdLeases() {
+		return
+	}
+
 	for i := 0; i < 2; i++ {
 		jr, err := clst.join()
 		if err != nil {
 			return
 		}
 
+		if jr.frozen {
+			skipFrozen(jr.boot, "boot")
+			skipFrozen(jr.terminate, "halt")
+			jr.boot, jr.terminate = nil, nil
+		}
+
 		if len(jr.boot) == 0 && len(jr.terminate) == 0 {
 			// ACLs must be processed after Quilt learns about what machines
 			// are in the cloud.  If we didn't, inter-machine ACLs could get
 			// removed when the Quilt controller restarts, even if there are
 			// running cloud machines that still need to communicate.
-			clst.syncACLs(jr.acl.Admin, jr.acl.ApplicationPorts, jr.machines)
+			clst.syncACLs(jr.acl.Admin, jr.acl.SSH, jr.acl.ApplicationPorts,
+				jr.machines)
 			return
 		}
 
@@ -121,6 +182,15 @@ func (clst cluster) runOnce() {
 	}
 }
 
+// skipFrozen logs the machines that weren't booted or halted because the machines
+// are frozen.
+func skipFrozen(machines []machine.Machine, action string) {
+	for _, m := range machines {
+		log.WithField("machine", m).
+			Infof("Machines are frozen, skipping %s.", action)
+	}
+}
+
 func (clst cluster) updateCloud(machines []machine.Machine, boot bool) {
 	if len(machines) == 0 {
 		return
@@ -154,6 +224,10 @@ func (clst cluster) updateCloud(machines []machine.Machine, boot bool) {
 			log.WithError(err).
 				Warnf("Unable to %s machines on %s.", actionString, p)
 		}
+
+		if capErr, ok := err.(machine.CapacityError); ok {
+			clst.fallBack(p, capErr)
+		}
 	}
 
 	if noFailures {
@@ -164,9 +238,30 @@ func (clst cluster) updateCloud(machines []machine.Machine, boot bool) {
 	}
 }
 
+// fallBack switches the unbooted machines that the provider is out of capacity for to
+// their next size, so that the next attempt to boot them may succeed.
+func (clst cluster) fallBack(p db.Provider, capErr machine.CapacityError) {
+	clst.conn.Txn(db.MachineTable).Run(func(view db.Database) error {
+		machines := view.SelectFromMachine(func(m db.Machine) bool {
+			return m.Provider == p && m.Region == capErr.Region &&
+				m.Size == capErr.Size && m.CloudID == "" &&
+				len(m.Sizes) > 1
+		})
+
+		for _, m := range machines {
+			m.Size = m.NextSize()
+			log.WithField("machine", m).Infof(
+				"No capacity for %s, falling back.", capErr.Size)
+			view.Commit(m)
+		}
+		return nil
+	})
+}
+
 type joinResult struct {
 	machines []db.Machine
 	acl      db.ACL
+	frozen   bool
 
 	boot      []machine.Machine
 	terminate []machine.Machine
@@ -184,17 +279,18 @@ func (clst cluster) join() (joinResult, error) {
 	err = clst.conn.Txn(db.ACLTable, db.ClusterTable,
 		db.MachineTable).Run(func(view db.Database) error {
 
-		namespace, err := view.GetClusterNamespace()
+		dbCluster, err := view.GetCluster()
 		if err != nil {
-			log.WithError(err).Error("Failed to get namespace")
+			log.WithError(err).Error("Failed to get cluster")
 			return err
 		}
 
-		if clst.namespace != namespace {
+		if clst.namespace != dbCluster.Namespace {
 			err := errors.New("namespace change during a cluster run")
 			log.WithError(err).Debug("Cluster run abort")
 			return err
 		}
+		res.frozen = dbCluster.FreezeMachines
 
 		res.acl, err = view.GetACL()
 		if err != nil {
@@ -207,17 +303,24 @@ func (clst cluster) join() (joinResult, error) {
 		res.boot = dbResult.boot
 		res.terminate = dbResult.stop
 
+		now := timeNow()
+		paired := map[int]bool{}
 		for _, pair := range dbResult.pairs {
 			dbm := pair.L.(db.Machine)
 			m := pair.R.(machine.Machine)
+			paired[dbm.ID] = true
 
 			dbm.CloudID = m.ID
 			dbm.PublicIP = m.PublicIP
 			dbm.PrivateIP = m.PrivateIP
+			dbm.Failed = m.Failed
 
 			// We just booted the machine, can't possibly be connected.
 			if dbm.PublicIP == "" {
 				dbm.Connected = false
+			} else if !dbm.BootRequested.IsZero() &&
+				dbm.BootTimings.Instance == 0 {
+				dbm.BootTimings.Instance = now.Sub(dbm.BootRequested)
 			}
 
 			// If we overwrite the machine's size before the machine has
@@ -231,12 +334,23 @@ func (clst cluster) join() (joinResult, error) {
 			dbm.Provider = m.Provider
 			view.Commit(dbm)
 		}
+
+		// Note when each machine was first requested, so that we can time how
+		// long the provider takes to run it.
+		if !res.frozen {
+			for _, dbm := range res.machines {
+				if !paired[dbm.ID] && dbm.BootRequested.IsZero() {
+					dbm.BootRequested = now
+					view.Commit(dbm)
+				}
+			}
+		}
 		return nil
 	})
 	return res, err
 }
 
-func (clst cluster) syncACLs(adminACLs []string, appACLs []db.PortRange,
+func (clst cluster) syncACLs(adminACLs, sshACLs []string, appACLs []db.PortRange,
 	machines []db.Machine) {
 
 	// Always allow traffic from the Quilt controller.
@@ -255,6 +369,13 @@ func (clst cluster) syncACLs(adminACLs []string, appACLs []db.PortRange,
 			MaxPort: 65535,
 		})
 	}
+	for _, sshACL := range sshACLs {
+		acls = append(acls, acl.ACL{
+			CidrIP:  sshACL,
+			MinPort: 22,
+			MaxPort: 22,
+		})
+	}
 	for _, appACL := range appACLs {
 		acls = append(acls, acl.ACL{
 			CidrIP:  "0.0.0.0/0",
@@ -307,9 +428,11 @@ func syncDB(cloudMachines []machine.Machine, dbMachines []db.Machine) syncDBResu
 		switch {
 		case dbm.Provider != m.Provider:
 			return -1
+		case dbm.Provider == db.Static && dbm.PublicIP != m.PublicIP:
+			return -1
 		case dbm.Region != m.Region:
 			return -1
-		case dbm.Size != m.Size:
+		case !dbm.AllowsSize(m.Size):
 			return -1
 		case m.DiskSize != 0 && dbm.DiskSize != m.DiskSize:
 			return -1
@@ -335,11 +458,19 @@ func syncDB(cloudMachines []machine.Machine, dbMachines []db.Machine) syncDBResu
 	for _, dbm := range dbmIface {
 		m := dbm.(db.Machine)
 		ret.boot = append(ret.boot, machine.Machine{
-			Size:     m.Size,
-			Provider: m.Provider,
-			Region:   m.Region,
-			DiskSize: m.DiskSize,
-			SSHKeys:  m.SSHKeys})
+			Size:      m.Size,
+			Provider:  m.Provider,
+			Region:    m.Region,
+			DiskSize:  m.DiskSize,
+			SpotPrice: m.SpotPrice,
+			SSHKeys:   m.SSHKeys,
+
+			NetworkTier: m.NetworkTier,
+
+			// Static machines are adopted at the addresses the spec gives.
+			PublicIP:   m.PublicIP,
+			PrivateIP:  m.PrivateIP,
+			SSHKeyPath: m.SSHKeyPath})
 	}
 
 	return ret
@@ -369,14 +500,16 @@ func groupBy(machines []machine.Machine) map[db.Provider][]machine.Machine {
 	return machineMap
 }
 
-func newProviderImpl(p db.Provider, namespace string) (provider, error) {
+func newProviderImpl(p db.Provider, namespace, clusterID string) (provider, error) {
 	switch p {
 	case db.Amazon:
-		return amazon.New(namespace)
+		return amazon.New(namespace, clusterID)
 	case db.Google:
-		return google.New(namespace)
+		return google.New(namespace, clusterID)
 	case db.Vagrant:
-		return vagrant.New(namespace)
+		return vagrant.New(namespace, clusterID)
+	case db.Static:
+		return static.New(namespace)
 	default:
 		panic("Unimplemented")
 	}
@@ -384,3 +517,16 @@ func newProviderImpl(p db.Provider, namespace string) (provider, error) {
 
 // Stored in a variable so it may be mocked out
 var newProvider = newProviderImpl
+
+// faultyProvider fails the Boot calls that faults.BootFailure says should fail, so
+// that integration tests can exercise providers that are out of capacity.
+type faultyProvider struct {
+	provider
+}
+
+func (p faultyProvider) Boot(machines []machine.Machine) error {
+	if err := faults.BootFailure(); err != nil {
+		return err
+	}
+	return p.provider.Boot(machines)
+}
@@ -1,12 +1,14 @@
 package cluster
 
 import (
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/NetSys/quilt/cluster/acl"
 	"github.com/NetSys/quilt/cluster/machine"
 	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/faults"
 	"github.com/NetSys/quilt/stitch"
 	"github.com/stretchr/testify/assert"
 )
@@ -33,12 +35,15 @@ type fakeProvider struct {
 	idCounter   int
 	cloudConfig string
 
+	// Sizes the provider is out of capacity for.
+	noCapacity map[string]struct{}
+
 	bootRequests []bootRequest
 	stopRequests []string
 	aclRequests  []acl.ACL
 }
 
-func newFakeProvider(p db.Provider, namespace string) (provider, error) {
+func newFakeProvider(p db.Provider, namespace, clusterID string) (provider, error) {
 	var ret fakeProvider
 	ret.namespace = namespace
 	ret.machines = make(map[string]machine.Machine)
@@ -71,6 +76,13 @@ func (p *fakeProvider) List() ([]machine.Machine, error) {
 }
 
 func (p *fakeProvider) Boot(bootSet []machine.Machine) error {
+	for _, m := range bootSet {
+		if _, ok := p.noCapacity[m.Size]; ok {
+			return machine.CapacityError{Size: m.Size, Region: m.Region,
+				Err: errors.New("no capacity")}
+		}
+	}
+
 	for _, bootSet := range bootSet {
 		p.idCounter++
 		bootSet.ID = string(p.idCounter)
@@ -116,6 +128,7 @@ func TestPanicBadProvider(t *testing.T) {
 		allProviders = temp
 	}()
 	allProviders = []db.Provider{FakeAmazon}
+	getClusterID = func() (string, error) { return "clusterid", nil }
 	conn := db.New()
 	newCluster(conn, "test")
 }
@@ -163,6 +176,24 @@ func TestSyncDB(t *testing.T) {
 	checkSyncDB([]machine.Machine{cmNoSize, cmLarge}, []db.Machine{}, syncDBResult{
 		stop: []machine.Machine{cmNoSize, cmLarge},
 	})
+
+	// Static machines are only matched with the machine at their address, and
+	// failed ones aren't replaced.
+	dbStatic := db.Machine{Provider: db.Static, PublicIP: "1.1.1.1",
+		PrivateIP: "10.0.0.1", SSHKeyPath: "key"}
+	cmStatic := machine.Machine{Provider: db.Static, PublicIP: "1.1.1.1",
+		PrivateIP: "10.0.0.1", SSHKeyPath: "key"}
+	cmOtherStatic := machine.Machine{Provider: db.Static, PublicIP: "2.2.2.2"}
+	checkSyncDB([]machine.Machine{cmOtherStatic}, []db.Machine{dbStatic},
+		syncDBResult{
+			boot: []machine.Machine{cmStatic},
+			stop: []machine.Machine{cmOtherStatic},
+		})
+
+	cmFailed := cmStatic
+	cmFailed.Failed = true
+	checkSyncDB([]machine.Machine{cmFailed}, []db.Machine{dbStatic},
+		syncDBResult{})
 }
 
 func TestSync(t *testing.T) {
@@ -254,13 +285,134 @@ func TestSync(t *testing.T) {
 		[]string{toRemove.CloudID})
 }
 
+func TestFreezeMachines(t *testing.T) {
+	clst := newTestCluster("ns")
+	setNamespace(clst.conn, "ns")
+	amzn := clst.providers[FakeAmazon].(*fakeProvider)
+
+	setFrozen := func(frozen bool) {
+		clst.conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+			dbCluster, _ := view.GetCluster()
+			dbCluster.FreezeMachines = frozen
+			view.Commit(dbCluster)
+			return nil
+		})
+	}
+
+	setFrozen(true)
+	clst.conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.InsertMachine()
+		m.Role = db.Master
+		m.Provider = FakeAmazon
+		m.Size = "m4.large"
+		view.Commit(m)
+		return nil
+	})
+	clst.runOnce()
+	assert.Empty(t, amzn.bootRequests)
+
+	setFrozen(false)
+	clst.runOnce()
+	assert.Len(t, amzn.bootRequests, 1)
+	amzn.clearLogs()
+
+	// Frozen machines aren't halted either, even once they're removed from the
+	// database.
+	setFrozen(true)
+	clst.conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		view.Remove(view.SelectFromMachine(nil)[0])
+		return nil
+	})
+	clst.runOnce()
+	assert.Empty(t, amzn.stopRequests)
+	assert.Len(t, amzn.machines, 1)
+
+	setFrozen(false)
+	clst.runOnce()
+	assert.Len(t, amzn.stopRequests, 1)
+	assert.Empty(t, amzn.machines)
+}
+
+func TestSizeFallback(t *testing.T) {
+	clst := newTestCluster("ns")
+	setNamespace(clst.conn, "ns")
+	amzn := clst.providers[FakeAmazon].(*fakeProvider)
+	amzn.noCapacity = map[string]struct{}{"m4.large": {}}
+
+	clst.conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.InsertMachine()
+		m.Role = db.Master
+		m.Provider = FakeAmazon
+		m.Size = "m4.large"
+		m.Sizes = []string{"m4.large", "m5.large"}
+		view.Commit(m)
+		return nil
+	})
+	clst.runOnce()
+	assert.Equal(t, []bootRequest{{size: "m5.large",
+		cloudConfig: amazonCloudConfig}}, amzn.bootRequests)
+	amzn.clearLogs()
+
+	// The machine booted with the fallback size is adopted rather than replaced.
+	clst.runOnce()
+	assert.Empty(t, amzn.bootRequests)
+	assert.Empty(t, amzn.stopRequests)
+
+	machines := clst.conn.SelectFromMachine(nil)
+	assert.Len(t, machines, 1)
+	assert.Equal(t, "m5.large", machines[0].Size)
+	assert.NotEmpty(t, machines[0].CloudID)
+}
+
+func TestBootTimings(t *testing.T) {
+	start := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := start
+	timeNow = func() time.Time { return now }
+
+	clst := newTestCluster("ns")
+	setNamespace(clst.conn, "ns")
+	amzn := clst.providers[FakeAmazon].(*fakeProvider)
+
+	clst.conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.InsertMachine()
+		m.Role = db.Master
+		m.Provider = FakeAmazon
+		m.Size = "m4.large"
+		view.Commit(m)
+		return nil
+	})
+	clst.runOnce()
+	assert.Len(t, amzn.bootRequests, 1)
+
+	machines := clst.conn.SelectFromMachine(nil)
+	assert.Len(t, machines, 1)
+	assert.Equal(t, start, machines[0].BootRequested)
+	assert.Zero(t, machines[0].BootTimings.Instance)
+
+	// The instance's phase ends once the provider reports it running.
+	now = start.Add(time.Minute)
+	clst.runOnce()
+	assert.Zero(t, clst.conn.SelectFromMachine(nil)[0].BootTimings.Instance)
+
+	for id, m := range amzn.machines {
+		m.PublicIP = "1.2.3.4"
+		amzn.machines[id] = m
+	}
+	now = start.Add(3 * time.Minute)
+	clst.runOnce()
+
+	machines = clst.conn.SelectFromMachine(nil)
+	assert.Equal(t, start, machines[0].BootRequested)
+	assert.Equal(t, 3*time.Minute, machines[0].BootTimings.Instance)
+}
+
 func TestACLs(t *testing.T) {
 	myIP = func() (string, error) {
 		return "5.6.7.8", nil
 	}
 
 	clst := newTestCluster("ns")
-	clst.syncACLs([]string{"admin"},
+	clst.syncACLs([]string{"admin"}, []string{"ssh"},
 		[]db.PortRange{
 			{
 				MinPort: 80,
@@ -287,6 +439,11 @@ func TestACLs(t *testing.T) {
 			MinPort: 1,
 			MaxPort: 65535,
 		},
+		{
+			CidrIP:  "ssh",
+			MinPort: 22,
+			MaxPort: 22,
+		},
 		{
 			CidrIP:  "0.0.0.0/0",
 			MinPort: 80,
@@ -302,6 +459,28 @@ func TestACLs(t *testing.T) {
 	assert.Equal(t, exp, actual)
 }
 
+func TestRoleACLsOnlySSH(t *testing.T) {
+	myIP = func() (string, error) {
+		return "5.6.7.8", nil
+	}
+
+	clst := newTestCluster("ns")
+	clst.syncACLs(nil, []string{"9.9.9.0/24"},
+		[]db.PortRange{{MinPort: 80, MaxPort: 80}},
+		[]db.Machine{{Provider: FakeAmazon, PublicIP: "8.8.8.8"}})
+
+	// A CIDR from the WorkerACL may SSH into the machines, but can't reach any
+	// other port that the AdminACL would open.
+	for _, rule := range clst.providers[FakeAmazon].(*fakeProvider).aclRequests {
+		if rule.CidrIP == "9.9.9.0/24" {
+			assert.Equal(t, 22, rule.MinPort)
+			assert.Equal(t, 22, rule.MaxPort)
+		}
+	}
+	assert.Contains(t, clst.providers[FakeAmazon].(*fakeProvider).aclRequests,
+		acl.ACL{CidrIP: "9.9.9.0/24", MinPort: 22, MaxPort: 22})
+}
+
 func TestUpdateCluster(t *testing.T) {
 	conn := db.New()
 
@@ -375,6 +554,52 @@ func TestUpdateCluster(t *testing.T) {
 	assert.Empty(t, amzn.stopRequests)
 }
 
+func TestClusterIDBackoff(t *testing.T) {
+	mock()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	calls := 0
+	getClusterID = func() (string, error) {
+		calls++
+		return "", errors.New("unavailable")
+	}
+
+	conn := db.New()
+	setNamespace(conn, "ns")
+
+	clst := updateCluster(conn, nil)
+	assert.Equal(t, 1, calls)
+	assert.Empty(t, clst.providers)
+
+	// The failure isn't retried until the backoff expires.
+	clst = updateCluster(conn, clst)
+	assert.Equal(t, 1, calls)
+
+	now = now.Add(minIDBackoff)
+	clst = updateCluster(conn, clst)
+	assert.Equal(t, 2, calls)
+
+	// Each failure doubles the backoff.
+	now = now.Add(minIDBackoff)
+	clst = updateCluster(conn, clst)
+	assert.Equal(t, 2, calls)
+
+	getClusterID = func() (string, error) {
+		calls++
+		return "clusterid", nil
+	}
+	now = now.Add(minIDBackoff)
+	clst = updateCluster(conn, clst)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, "clusterid", clst.clusterID)
+	assert.NotEmpty(t, clst.providers)
+
+	// Once the ID is known, it's never fetched again.
+	clst = updateCluster(conn, clst)
+	assert.Equal(t, 3, calls)
+}
+
 func setNamespace(conn db.Conn, ns string) {
 	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
 		clst, err := view.GetCluster()
@@ -390,5 +615,21 @@ func setNamespace(conn db.Conn, ns string) {
 
 func mock() {
 	newProvider = newFakeProvider
+	getClusterID = func() (string, error) { return "clusterid", nil }
 	allProviders = []db.Provider{FakeAmazon, FakeVagrant}
 }
+
+func TestFaultyProvider(t *testing.T) {
+	inner, _ := newFakeProvider(FakeAmazon, "ns", "clusterid")
+	prvdr := faultyProvider{inner}
+
+	faults.Enable()
+	assert.NoError(t, faults.Inject(faults.Fault{BootFailures: 1}))
+
+	toBoot := []machine.Machine{{Provider: FakeAmazon, Size: "m4.large"}}
+	assert.Equal(t, faults.ErrBootFailure, prvdr.Boot(toBoot))
+	assert.Empty(t, inner.(*fakeProvider).bootRequests)
+
+	assert.NoError(t, prvdr.Boot(toBoot))
+	assert.Len(t, inner.(*fakeProvider).bootRequests, 1)
+}
@@ -1,6 +1,7 @@
 package foreman
 
 import (
+	"encoding/json"
 	"reflect"
 	"strings"
 	"sync"
@@ -12,6 +13,8 @@ import (
 
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/minion/pb"
+	"github.com/NetSys/quilt/stitch"
+	"github.com/NetSys/quilt/util"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -89,6 +92,7 @@ func RunOnce(conn db.Conn) {
 
 		return nil
 	})
+	spec = resolveLocalACLs(spec)
 
 	updateMinionMap(machines)
 
@@ -102,10 +106,46 @@ func RunOnce(conn db.Conn) {
 			log.WithField("machine", m.machine).Debug("New connection.")
 		}
 
-		if connected != m.machine.Connected {
+		// The container counts and boot timings are reported by the minion,
+		// not configured.  Only the timings of the first boot are kept, so a
+		// restarted minion doesn't overwrite them.
+		containers := int(m.config.Containers)
+		unscheduled := int(m.config.Unscheduled)
+		status := supervisorStatus(m.config.SupervisorStatus)
+		timings := firstBootTimings(m.machine.BootTimings, m.config)
+		m.config.Containers = 0
+		m.config.Unscheduled = 0
+		m.config.SupervisorStatus = ""
+		m.config.BootMinion = 0
+		m.config.BootImagePull = 0
+		m.config.BootFirstContainer = 0
+
+		if connected != m.machine.Connected ||
+			containers != m.machine.Containers ||
+			unscheduled != m.machine.Unscheduled ||
+			!reflect.DeepEqual(status, m.machine.SupervisorStatus) ||
+			(connected && timings != m.machine.BootTimings) {
 			tr := conn.Txn(db.MachineTable)
 			tr.Run(func(view db.Database) error {
+				// Reread the machine so that changes made since this
+				// run began, such as draining it, aren't undone.
+				dbms := view.SelectFromMachine(func(dbm db.Machine) bool {
+					return dbm.ID == m.machine.ID
+				})
+				if len(dbms) == 0 {
+					return nil
+				}
+
+				m.machine = dbms[0]
 				m.machine.Connected = connected
+				m.machine.Containers = containers
+				m.machine.Unscheduled = unscheduled
+				m.machine.SupervisorStatus = status
+				if connected {
+					instance := m.machine.BootTimings.Instance
+					m.machine.BootTimings = timings
+					m.machine.BootTimings.Instance = instance
+				}
 				view.Commit(m.machine)
 				return nil
 			})
@@ -136,6 +176,10 @@ func RunOnce(conn db.Conn) {
 			Region:         m.machine.Region,
 			EtcdMembers:    etcdIPs,
 			AuthorizedKeys: m.machine.SSHKeys,
+			DedicatedTo:    m.machine.DedicatedTo,
+			FloatingIP:     m.machine.FloatingIP,
+			Draining:       m.machine.Draining,
+			DisableNAT:     m.machine.DisableNAT,
 		}
 
 		if reflect.DeepEqual(newConfig, m.config) {
@@ -222,3 +266,74 @@ func (c clientImpl) setMinion(cfg pb.MinionConfig) error {
 func (c clientImpl) Close() {
 	c.cc.Close()
 }
+
+// supervisorStatus decodes the health of the system containers reported by a minion.
+func supervisorStatus(encoded string) map[string]db.ComponentStatus {
+	if encoded == "" {
+		return nil
+	}
+
+	var status map[string]db.ComponentStatus
+	if err := json.Unmarshal([]byte(encoded), &status); err != nil {
+		log.WithError(err).Warn("Malformed supervisor status.")
+		return nil
+	}
+	return status
+}
+
+// firstBootTimings fills in the phases missing from `recorded` with those reported in
+// `cfg`.  Phases that were already recorded are kept.
+func firstBootTimings(recorded db.BootTimings, cfg pb.MinionConfig) db.BootTimings {
+	timings := recorded
+	if timings.Minion == 0 {
+		timings.Minion = time.Duration(cfg.BootMinion)
+	}
+	if timings.ImagePull == 0 {
+		timings.ImagePull = time.Duration(cfg.BootImagePull)
+	}
+	if timings.FirstContainer == 0 {
+		timings.FirstContainer = time.Duration(cfg.BootFirstContainer)
+	}
+	return timings
+}
+
+var myIP = util.MyIP
+
+// resolveLocalACLs replaces the "local" entries of the AdminACL in `specStr` with the
+// daemon's address.  Minions without a role ACL restrict SSH to the AdminACL
+// themselves, but only the daemon knows its own address.  If it can't be determined,
+// the "local" entries are dropped, so the minions are never sent one.
+func resolveLocalACLs(specStr string) string {
+	spec, err := stitch.FromJSON(specStr)
+	if err != nil {
+		return specStr
+	}
+
+	var hasLocal bool
+	for _, acl := range spec.AdminACL {
+		hasLocal = hasLocal || acl == "local"
+	}
+	if !hasLocal {
+		return specStr
+	}
+
+	local := ""
+	if ip, err := myIP(); err != nil {
+		log.WithError(err).Warn("Failed to get IP address, dropping the " +
+			"local ACL.")
+	} else {
+		local = ip + "/32"
+	}
+
+	var resolved []string
+	for _, acl := range spec.AdminACL {
+		switch {
+		case acl != "local":
+			resolved = append(resolved, acl)
+		case local != "":
+			resolved = append(resolved, local)
+		}
+	}
+	spec.AdminACL = resolved
+	return spec.String()
+}
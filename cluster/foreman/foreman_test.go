@@ -1,12 +1,15 @@
 package foreman
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/minion/pb"
+	"github.com/NetSys/quilt/stitch"
 )
 
 type clients struct {
@@ -243,6 +246,110 @@ func TestConfigConsistency(t *testing.T) {
 	})
 }
 
+func TestDrain(t *testing.T) {
+	conn, clients := startTest()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.InsertMachine()
+		m.PublicIP = "1.1.1.1"
+		m.PrivateIP = "1.1.1.1"
+		m.CloudID = "ID"
+		m.Role = db.Worker
+		m.Draining = true
+		view.Commit(m)
+		return nil
+	})
+
+	RunOnce(conn)
+	fc := clients.clients["1.1.1.1"]
+	assert.True(t, fc.mc.Draining)
+
+	fc.mc.Containers = 3
+	fc.mc.Unscheduled = 2
+	fc.mc.SupervisorStatus = `{"etcd":{"State":"unhealthy","Restarts":1}}`
+	RunOnce(conn)
+
+	machines := conn.SelectFromMachine(nil)
+	assert.Len(t, machines, 1)
+	assert.Equal(t, 3, machines[0].Containers)
+	assert.Equal(t, 2, machines[0].Unscheduled)
+	assert.Equal(t, map[string]db.ComponentStatus{
+		"etcd": {State: db.ComponentUnhealthy, Restarts: 1},
+	}, machines[0].SupervisorStatus)
+	assert.True(t, machines[0].Draining)
+	assert.True(t, machines[0].Connected)
+}
+
+func TestBootTimings(t *testing.T) {
+	conn, clients := startTest()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.InsertMachine()
+		m.PublicIP = "1.1.1.1"
+		m.PrivateIP = "1.1.1.1"
+		m.CloudID = "ID"
+		m.Role = db.Worker
+		m.BootTimings.Instance = time.Minute
+		view.Commit(m)
+		return nil
+	})
+
+	RunOnce(conn)
+	fc := clients.clients["1.1.1.1"]
+	fc.mc.BootMinion = int64(2 * time.Minute)
+	fc.mc.BootImagePull = int64(time.Second)
+	fc.mc.BootFirstContainer = int64(3 * time.Minute)
+	RunOnce(conn)
+
+	// The instance's timing is measured by the cluster, not the minion.
+	machines := conn.SelectFromMachine(nil)
+	assert.Len(t, machines, 1)
+	assert.Equal(t, db.BootTimings{
+		Instance:       time.Minute,
+		Minion:         2 * time.Minute,
+		ImagePull:      time.Second,
+		FirstContainer: 3 * time.Minute,
+	}, machines[0].BootTimings)
+	assert.Equal(t, 6*time.Minute, machines[0].BootTimings.Total())
+
+	// A restarted minion's timings don't replace those of its first boot.
+	fc.mc.BootMinion = int64(time.Hour)
+	fc.mc.BootImagePull = 0
+	fc.mc.BootFirstContainer = int64(time.Second)
+	RunOnce(conn)
+	machines = conn.SelectFromMachine(nil)
+	assert.Equal(t, 6*time.Minute, machines[0].BootTimings.Total())
+	assert.Equal(t, time.Second, machines[0].BootTimings.ImagePull)
+}
+
+func TestResolveLocalACLs(t *testing.T) {
+	myIP = func() (string, error) {
+		return "5.6.7.8", nil
+	}
+
+	spec, err := stitch.FromJavascript(`createDeployment({
+		adminACL: ["local", "1.2.3.4/32"],
+		workerACL: ["10.0.0.0/8"]
+	});`, stitch.DefaultImportGetter)
+	assert.NoError(t, err)
+
+	resolved, err := stitch.FromJSON(resolveLocalACLs(spec.String()))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"5.6.7.8/32", "1.2.3.4/32"}, resolved.AdminACL)
+	assert.Equal(t, []string{"10.0.0.0/8"}, resolved.WorkerACL)
+
+	// Specs without a local ACL are passed through untouched.
+	spec.AdminACL = []string{"1.2.3.4/32"}
+	assert.Equal(t, spec.String(), resolveLocalACLs(spec.String()))
+
+	// If the address is unknown, the local ACL is dropped rather than sent on.
+	myIP = func() (string, error) {
+		return "", errors.New("no address")
+	}
+	spec.AdminACL = []string{"local", "1.2.3.4/32"}
+	resolved, err = stitch.FromJSON(resolveLocalACLs(spec.String()))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.2.3.4/32"}, resolved.AdminACL)
+}
+
 func startTest() (db.Conn, *clients) {
 	conn := db.New()
 	minions = map[string]*minion{}
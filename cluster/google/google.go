@@ -52,6 +52,14 @@ const (
 
 var supportedZones = []string{"us-central1-a", "us-east1-b", "europe-west1-b"}
 
+// Regions returns the zones Google machines may be booted in.
+func Regions() []string {
+	return append([]string{}, supportedZones...)
+}
+
+// The metadata key holding the cluster ID of the daemon that booted an instance.
+const clusterIDKey = "quilt-cluster-id"
+
 var authClient *http.Client  // the oAuth client
 var service *compute.Service // gce service
 
@@ -63,15 +71,18 @@ type Cluster struct {
 	ipv4Range string // ipv4 range of the internal network
 	intFW     string // gce internal firewall name
 
-	ns string // cluster namespace
-	id int    // the id of the cluster, used externally
+	ns        string // cluster namespace
+	clusterID string // the ID of the daemon managing the cluster
+	id        int    // the id of the cluster, used externally
 }
 
 // New creates a GCE cluster.
 //
 // Clusters are differentiated (namespace) by setting the description and
-// filtering off of that.
-func New(namespace string) (*Cluster, error) {
+// filtering off of that.  Instances also record the `clusterID` of the daemon that
+// booted them in their metadata, so that daemons sharing a namespace don't manage
+// each other's machines.  The network and firewalls are still shared by the namespace.
+func New(namespace, clusterID string) (*Cluster, error) {
 	if err := gceInit(); err != nil {
 		log.WithError(err).Debug("failed to start up gce")
 		return nil, err
@@ -80,6 +91,7 @@ func New(namespace string) (*Cluster, error) {
 	clst := Cluster{
 		projID:    "declarative-infrastructure",
 		ns:        namespace,
+		clusterID: clusterID,
 		ipv4Range: "192.168.0.0/16",
 	}
 	clst.baseURL = fmt.Sprintf("%s/%s", computeBaseURL, clst.projID)
@@ -97,21 +109,136 @@ func New(namespace string) (*Cluster, error) {
 		return nil, err
 	}
 
+	if err := clst.adoptLegacy(); err != nil {
+		return nil, err
+	}
+
+	_, foreign, err := clst.listInstances()
+	if err != nil {
+		return nil, err
+	}
+	if len(foreign) > 0 {
+		var names []string
+		for _, item := range foreign {
+			names = append(names, item.Name)
+		}
+		log.WithField("instances", strings.Join(names, ", ")).Errorf(
+			"Google: Refusing to manage machines in namespace %s that "+
+				"weren't booted by this daemon", clst.ns)
+	}
+
 	return &clst, nil
 }
 
-// List the current machines in the cluster.
-func (clst *Cluster) List() ([]machine.Machine, error) {
-	// XXX: This doesn't use the instance group listing functionality because
-	// listing that way doesn't get you information about the instances
-	var mList []machine.Machine
+// listInstances returns the instances in the cluster's namespace, split into those
+// booted by this daemon, and those booted by another daemon or by a version of Quilt
+// that predates cluster IDs.  The instances are keyed by their zone.
+func (clst *Cluster) listInstances() (ours map[string][]*compute.Instance,
+	foreign []*compute.Instance, err error) {
+
+	ours = map[string][]*compute.Instance{}
 	for _, zone := range supportedZones {
 		list, err := service.Instances.List(clst.projID, zone).
 			Filter(fmt.Sprintf("description eq %s", clst.ns)).Do()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+
 		for _, item := range list.Items {
+			if instanceClusterID(item) == clst.clusterID {
+				ours[zone] = append(ours[zone], item)
+			} else {
+				foreign = append(foreign, item)
+			}
+		}
+	}
+	return ours, foreign, nil
+}
+
+// adoptLegacy takes over the instances that versions of Quilt before cluster IDs
+// booted into the namespace, so that upgrading the daemon doesn't strand them.  Those
+// versions ran one daemon per namespace, so the legacy instances are only adopted if
+// no other daemon has booted instances into the namespace since.  Each is claimed by
+// recording the cluster ID in its metadata, which fails if another daemon changed the
+// metadata first.
+func (clst *Cluster) adoptLegacy() error {
+	_, foreign, err := clst.listInstances()
+	if err != nil {
+		return err
+	}
+
+	var ops []*compute.Operation
+	for _, inst := range legacyInstances(foreign) {
+		zone := inst.Zone[strings.LastIndex(inst.Zone, "/")+1:]
+		op, err := service.Instances.SetMetadata(clst.projID, zone, inst.Name,
+			withClusterID(inst.Metadata, clst.clusterID)).Do()
+		if err != nil {
+			log.WithError(err).Warnf("Google: Failed to adopt legacy "+
+				"instance %s", inst.Name)
+			continue
+		}
+		log.Infof("Google: Adopted legacy instance %s", inst.Name)
+		ops = append(ops, op)
+	}
+	return clst.operationWait(ops, local)
+}
+
+// legacyInstances returns the instances in `foreign` that were booted by versions of
+// Quilt that predate cluster IDs, or none if any of them were booted by another
+// daemon.
+func legacyInstances(foreign []*compute.Instance) []*compute.Instance {
+	var legacy []*compute.Instance
+	for _, inst := range foreign {
+		if instanceClusterID(inst) != "" {
+			return nil
+		}
+		legacy = append(legacy, inst)
+	}
+	return legacy
+}
+
+// withClusterID returns a copy of `metadata` that records `clusterID`.  It keeps the
+// fingerprint, so that setting it fails if the metadata changed in the meantime.
+func withClusterID(metadata *compute.Metadata, clusterID string) *compute.Metadata {
+	result := &compute.Metadata{}
+	if metadata != nil {
+		result.Fingerprint = metadata.Fingerprint
+		result.Items = append(result.Items, metadata.Items...)
+	}
+	result.Items = append(result.Items, &compute.MetadataItems{
+		Key:   clusterIDKey,
+		Value: &clusterID,
+	})
+	return result
+}
+
+// instanceClusterID returns the cluster ID recorded in the metadata of `inst`, or the
+// empty string if it has none.
+func instanceClusterID(inst *compute.Instance) string {
+	if inst.Metadata == nil {
+		return ""
+	}
+
+	for _, item := range inst.Metadata.Items {
+		if item != nil && item.Key == clusterIDKey && item.Value != nil {
+			return *item.Value
+		}
+	}
+	return ""
+}
+
+// List the current machines in the cluster.
+func (clst *Cluster) List() ([]machine.Machine, error) {
+	// XXX: This doesn't use the instance group listing functionality because
+	// listing that way doesn't get you information about the instances
+	instances, _, err := clst.listInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	var mList []machine.Machine
+	for _, zone := range supportedZones {
+		for _, item := range instances[zone] {
 			// XXX: This make some iffy assumptions about NetworkInterfaces
 			machineSplitURL := strings.Split(item.MachineType, "/")
 			mtype := machineSplitURL[len(machineSplitURL)-1]
@@ -313,6 +440,10 @@ func (clst *Cluster) instanceNew(name string, size string, zone string,
 					Key:   "startup-script",
 					Value: &cloudConfig,
 				},
+				{
+					Key:   clusterIDKey,
+					Value: &clst.clusterID,
+				},
 			},
 		},
 	}
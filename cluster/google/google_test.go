@@ -1,6 +1,58 @@
 package google
 
-import "testing"
+import (
+	"testing"
 
-func TestDummy(t *testing.T) {
+	"github.com/stretchr/testify/assert"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestLegacyInstances(t *testing.T) {
+	id := "clusterid"
+	legacy := &compute.Instance{Name: "legacy"}
+	other := &compute.Instance{Name: "other", Metadata: &compute.Metadata{
+		Items: []*compute.MetadataItems{{Key: clusterIDKey, Value: &id}},
+	}}
+
+	assert.Equal(t, []*compute.Instance{legacy},
+		legacyInstances([]*compute.Instance{legacy}))
+
+	// Legacy instances might belong to another daemon in the namespace.
+	assert.Nil(t, legacyInstances([]*compute.Instance{legacy, other}))
+	assert.Nil(t, legacyInstances(nil))
+}
+
+func TestWithClusterID(t *testing.T) {
+	script := "script"
+	metadata := &compute.Metadata{
+		Fingerprint: "fingerprint",
+		Items: []*compute.MetadataItems{
+			{Key: "startup-script", Value: &script},
+		},
+	}
+
+	adopted := withClusterID(metadata, "clusterid")
+	assert.Equal(t, "fingerprint", adopted.Fingerprint)
+	assert.Equal(t, "clusterid", instanceClusterID(
+		&compute.Instance{Metadata: adopted}))
+	assert.Len(t, adopted.Items, 2)
+	assert.Len(t, metadata.Items, 1)
+
+	adopted = withClusterID(nil, "clusterid")
+	assert.Equal(t, "clusterid", instanceClusterID(
+		&compute.Instance{Metadata: adopted}))
+}
+
+func TestInstanceClusterID(t *testing.T) {
+	assert.Equal(t, "", instanceClusterID(&compute.Instance{}))
+
+	script, id := "script", "clusterid"
+	assert.Equal(t, "clusterid", instanceClusterID(&compute.Instance{
+		Metadata: &compute.Metadata{
+			Items: []*compute.MetadataItems{
+				{Key: "startup-script", Value: &script},
+				{Key: clusterIDKey, Value: &id},
+			},
+		},
+	}))
 }
@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/NetSys/quilt/cluster/machine"
+	"github.com/NetSys/quilt/db"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// A leaser is a provider that can record which daemon manages the namespace, so that
+// two daemons sharing credentials and a namespace don't fight over its machines.
+type leaser interface {
+	GetLease() (machine.Lease, bool, error)
+
+	CreateLease(machine.Lease) (machine.Lease, error)
+
+	RenewLease(machine.Lease) error
+
+	DeleteLease(machine.Lease) error
+}
+
+// How often the lease's holder renews it.
+const leaseRenewInterval = time.Minute
+
+// How long after its last renewal a lease may be taken over by another daemon.  This
+// bounds how long a crashed daemon keeps others from managing its namespace.
+const leaseExpiry = 5 * time.Minute
+
+var timeNow = time.Now
+
+// A leaseHeldError is returned when another daemon holds a namespace's lease.
+type leaseHeldError struct {
+	provider db.Provider
+	lease    machine.Lease
+}
+
+func (err leaseHeldError) Error() string {
+	return fmt.Sprintf("%s namespace is managed by %s (last renewed %s)",
+		err.provider, err.lease, err.lease.Renewed.Format(time.RFC3339))
+}
+
+// holdLeases makes sure this daemon holds the lease of every provider that supports
+// them, and returns false if it doesn't, in which case the cluster must not be
+// modified.
+func (clst cluster) holdLeases() bool {
+	held := true
+	for name, prvdr := range clst.providers {
+		l, ok := asLeaser(prvdr)
+		if !ok {
+			continue
+		}
+
+		err := holdLease(l, clst.clusterID)
+		if heldErr, ok := err.(leaseHeldError); ok {
+			heldErr.provider = name
+			log.WithField("lease", heldErr.lease).Errorf(
+				"Refusing to manage cluster: %s", heldErr)
+			held = false
+		} else if err != nil {
+			log.WithError(err).Warnf("Failed to acquire lease on %s.", name)
+			held = false
+		}
+	}
+	return held
+}
+
+// holdLease acquires the lease of `prvdr` for `holder` if it's free or expired, and
+// renews it if `holder` already holds it.
+func holdLease(prvdr leaser, holder string) error {
+	now := timeNow()
+	lease, ok, err := prvdr.GetLease()
+	if err != nil {
+		return err
+	}
+
+	if ok && lease.Holder == holder {
+		if now.Sub(lease.Renewed) < leaseRenewInterval {
+			return nil
+		}
+		lease.Renewed = now
+		return prvdr.RenewLease(lease)
+	}
+
+	if ok {
+		if now.Sub(lease.Renewed) < leaseExpiry {
+			return leaseHeldError{lease: lease}
+		}
+
+		log.WithField("lease", lease).Info("Taking over expired lease.")
+		// If another daemon deleted the lease first, this fails and the lease
+		// goes to whichever daemon creates the next one.
+		if err := prvdr.DeleteLease(lease); err != nil {
+			return err
+		}
+	}
+
+	lease, err = prvdr.CreateLease(machine.Lease{Holder: holder, Acquired: now})
+	if err == machine.ErrLeaseExists {
+		// Another daemon created the lease since we looked.  Report it on the
+		// next attempt.
+		return fmt.Errorf("lost race to acquire lease: %s", err)
+	} else if err != nil {
+		return err
+	}
+
+	log.WithField("lease", lease).Info("Acquired lease.")
+	return nil
+}
+
+// asLeaser returns `prvdr` as a leaser if it supports leases.
+func asLeaser(prvdr provider) (leaser, bool) {
+	if faulty, ok := prvdr.(faultyProvider); ok {
+		prvdr = faulty.provider
+	}
+	l, ok := prvdr.(leaser)
+	return l, ok
+}
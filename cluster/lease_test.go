@@ -0,0 +1,197 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/NetSys/quilt/cluster/machine"
+	"github.com/NetSys/quilt/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLeases is a provider's lease, shared by every daemon managing the namespace.
+type fakeLeases struct {
+	lease  *machine.Lease
+	nextID int
+}
+
+func (fl *fakeLeases) GetLease() (machine.Lease, bool, error) {
+	if fl.lease == nil {
+		return machine.Lease{}, false, nil
+	}
+	return *fl.lease, true, nil
+}
+
+func (fl *fakeLeases) CreateLease(l machine.Lease) (machine.Lease, error) {
+	if fl.lease != nil {
+		return machine.Lease{}, machine.ErrLeaseExists
+	}
+
+	fl.nextID++
+	l.ID = fmt.Sprintf("lease-%d", fl.nextID)
+	l.Renewed = l.Acquired
+	fl.lease = &l
+	return l, nil
+}
+
+func (fl *fakeLeases) RenewLease(l machine.Lease) error {
+	if fl.lease == nil || fl.lease.ID != l.ID {
+		return errors.New("no such lease")
+	}
+	fl.lease.Renewed = l.Renewed
+	return nil
+}
+
+func (fl *fakeLeases) DeleteLease(l machine.Lease) error {
+	if fl.lease == nil || fl.lease.ID != l.ID {
+		return errors.New("no such lease")
+	}
+	fl.lease = nil
+	return nil
+}
+
+type leasingProvider struct {
+	provider
+	*fakeLeases
+}
+
+func TestHoldLease(t *testing.T) {
+	start := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := start
+	timeNow = func() time.Time { return now }
+
+	leases := &fakeLeases{}
+	assert.NoError(t, holdLease(leases, "a"))
+	assert.Equal(t, machine.Lease{ID: "lease-1", Holder: "a", Acquired: start,
+		Renewed: start}, *leases.lease)
+
+	// The second daemon refuses to act, and reports who holds the lease.
+	now = start.Add(30 * time.Second)
+	err := holdLease(leases, "b")
+	assert.Equal(t, leaseHeldError{lease: *leases.lease}, err)
+	assert.Equal(t, "Amazon namespace is managed by daemon a since "+
+		"2017-01-02T03:04:05Z (last renewed 2017-01-02T03:04:05Z)",
+		leaseHeldError{provider: db.Amazon, lease: *leases.lease}.Error())
+
+	// Renewals are rate limited.
+	assert.NoError(t, holdLease(leases, "a"))
+	assert.Equal(t, start, leases.lease.Renewed)
+
+	now = start.Add(leaseRenewInterval)
+	assert.NoError(t, holdLease(leases, "a"))
+	assert.Equal(t, now, leases.lease.Renewed)
+
+	// Renewals keep the lease from expiring.
+	now = start.Add(leaseExpiry)
+	assert.IsType(t, leaseHeldError{}, holdLease(leases, "b"))
+
+	// A restarted daemon keeps its own lease.
+	assert.NoError(t, holdLease(leases, "a"))
+	assert.Equal(t, "lease-1", leases.lease.ID)
+	assert.Equal(t, now, leases.lease.Renewed)
+}
+
+func TestLeaseTakeover(t *testing.T) {
+	start := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := start
+	timeNow = func() time.Time { return now }
+
+	// Daemon "a" acquires the lease and then crashes.
+	leases := &fakeLeases{}
+	assert.NoError(t, holdLease(leases, "a"))
+
+	now = start.Add(leaseExpiry - time.Second)
+	assert.IsType(t, leaseHeldError{}, holdLease(leases, "b"))
+
+	now = start.Add(leaseExpiry)
+	assert.NoError(t, holdLease(leases, "b"))
+	assert.Equal(t, machine.Lease{ID: "lease-2", Holder: "b", Acquired: now,
+		Renewed: now}, *leases.lease)
+
+	// When "a" recovers, it defers to "b".
+	err := holdLease(leases, "a")
+	assert.Equal(t, leaseHeldError{lease: *leases.lease}, err)
+}
+
+func TestLeaseTakeoverRace(t *testing.T) {
+	start := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := start
+	timeNow = func() time.Time { return now }
+
+	leases := &fakeLeases{}
+	assert.NoError(t, holdLease(leases, "a"))
+	now = start.Add(leaseExpiry)
+
+	// Both "b" and "c" see the expired lease, but "b" takes it over first.  "c"
+	// must not delete the lease "b" just created.
+	expired := *leases.lease
+	assert.NoError(t, holdLease(leases, "b"))
+	assert.Error(t, leases.DeleteLease(expired))
+	assert.Equal(t, "b", leases.lease.Holder)
+
+	// Or "c" deletes the expired lease, but "b" creates its own before "c" does.
+	leases.lease = &expired
+	racer := &racingLeases{fakeLeases: leases, race: func() {
+		leases.CreateLease(machine.Lease{Holder: "b", Acquired: now})
+	}}
+	assert.EqualError(t, holdLease(racer, "c"),
+		"lost race to acquire lease: namespace is already leased")
+	assert.Equal(t, "b", leases.lease.Holder)
+	assert.IsType(t, leaseHeldError{}, holdLease(leases, "c"))
+}
+
+// racingLeases runs `race` right before creating a lease, to simulate another daemon
+// acting concurrently.
+type racingLeases struct {
+	*fakeLeases
+	race func()
+}
+
+func (rl *racingLeases) CreateLease(l machine.Lease) (machine.Lease, error) {
+	rl.race()
+	return rl.fakeLeases.CreateLease(l)
+}
+
+func TestSplitBrain(t *testing.T) {
+	start := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := start
+	timeNow = func() time.Time { return now }
+
+	leases := &fakeLeases{}
+	newLeasingCluster := func(clusterID string) (*cluster, *fakeProvider) {
+		clst := newTestCluster("ns")
+		clst.clusterID = clusterID
+		amzn, _ := newFakeProvider(FakeAmazon, "ns", clusterID)
+		clst.providers = map[db.Provider]provider{
+			FakeAmazon: leasingProvider{amzn, leases}}
+		setNamespace(clst.conn, "ns")
+		clst.conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+			m := view.InsertMachine()
+			m.Role = db.Master
+			m.Provider = FakeAmazon
+			m.Size = "m4.large"
+			view.Commit(m)
+			return nil
+		})
+		return clst, amzn.(*fakeProvider)
+	}
+
+	a, amznA := newLeasingCluster("a")
+	b, amznB := newLeasingCluster("b")
+
+	a.runOnce()
+	b.runOnce()
+	assert.Len(t, amznA.bootRequests, 1)
+	assert.Empty(t, amznB.bootRequests)
+
+	// Once "a" stops renewing its lease, "b" takes over.
+	now = start.Add(leaseExpiry)
+	b.runOnce()
+	assert.Len(t, amznB.bootRequests, 1)
+
+	a.runOnce()
+	assert.Len(t, amznA.bootRequests, 1)
+	assert.Equal(t, "b", leases.lease.Holder)
+}
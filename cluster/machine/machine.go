@@ -1,7 +1,11 @@
 package machine
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/stitch"
@@ -25,16 +29,70 @@ type Machine struct {
 	Size      string
 	DiskSize  int
 	SSHKeys   []string
+	SpotPrice float64
 	Provider  db.Provider
 	Region    string
+
+	// The provider specific networking performance to boot the machine with.
+	NetworkTier string
+
+	// The private key used to log into static machines.
+	SSHKeyPath string
+
+	// Whether a static machine is unreachable.
+	Failed bool
+}
+
+// A CapacityError reports that a provider is out of capacity for machines of Size in
+// Region.  Unlike other boot failures, booting machines of another size may succeed.
+type CapacityError struct {
+	Size   string
+	Region string
+	Err    error
+}
+
+func (err CapacityError) Error() string {
+	return fmt.Sprintf("no capacity for %s machines in %s: %s", err.Size,
+		err.Region, err.Err)
+}
+
+// A Lease records which daemon manages a namespace on a provider.  A daemon must hold
+// the lease to manage the namespace's machines, and renews it periodically so that
+// other daemons can tell whether it's still running.
+type Lease struct {
+	ID string // The provider's name for the lease, which changes if it's retaken.
+
+	Holder   string    // The cluster ID of the daemon that holds the lease.
+	Acquired time.Time // When the holder acquired the lease.
+	Renewed  time.Time // When the holder last renewed the lease.
 }
 
+func (l Lease) String() string {
+	return fmt.Sprintf("daemon %s since %s", l.Holder,
+		l.Acquired.Format(time.RFC3339))
+}
+
+// ErrLeaseExists is returned when creating a lease on a namespace that already has
+// one.
+var ErrLeaseExists = errors.New("namespace is already leased")
+
 // ChooseSize returns an acceptable machine size for the given provider that fits the
-// provided ram, cpu, and price constraints.
-func ChooseSize(provider db.Provider, ram, cpu stitch.Range, maxPrice float64) string {
+// provided ram, cpu, and price constraints, and that offers the network tier, if any.
+func ChooseSize(provider db.Provider, ram, cpu stitch.Range, maxPrice float64,
+	networkTier string) string {
+
 	switch provider {
 	case db.Amazon:
-		return chooseBestSize(amazonDescriptions, ram, cpu, maxPrice)
+		descriptions := amazonDescriptions
+		if networkTier != "" {
+			descriptions = nil
+			for _, d := range amazonDescriptions {
+				if SizeHasNetworkTier(provider, d.Size, networkTier) {
+					descriptions = append(descriptions, d)
+				}
+			}
+		}
+		return chooseBestSize(descriptions, ram, cpu, maxPrice)
 	case db.Google:
 		return chooseBestSize(googleDescriptions, ram, cpu, maxPrice)
 	case db.Vagrant:
@@ -44,6 +102,70 @@ func ChooseSize(provider db.Provider, ram, cpu stitch.Range, maxPrice float64) s
 	}
 }
 
+// SizePrice returns the hourly price of the given provider's machines of the given
+// size in the given region, or zero if it's unknown.
+func SizePrice(provider db.Provider, region, size string) float64 {
+	var descriptions []Description
+	switch provider {
+	case db.Amazon:
+		descriptions = amazonDescriptions
+	case db.Google:
+		descriptions = googleDescriptions
+	}
+
+	for _, d := range descriptions {
+		if d.Size == size && (d.Region == "" || d.Region == region) {
+			return d.Price
+		}
+	}
+	return 0
+}
+
+// Graviton instance families end with a "g" after their generation, e.g. "m6g" or
+// "c6gn".  The first generation, "a1", doesn't.
+var amazonArmFamily = regexp.MustCompile(`^(a1|[a-z]+[0-9]+g[a-z]*)$`)
+
+// Google's Arm machine series end with an "a" after their generation, e.g. "t2a".
+var googleArmSeries = regexp.MustCompile(`^[a-z][0-9]a$`)
+
+// SizeArch returns the CPU architecture of the given provider's machines of the given
+// size, or the empty string if it's unknown.
+func SizeArch(provider db.Provider, size string) string {
+	switch provider {
+	case db.Amazon:
+		if amazonArmFamily.MatchString(strings.SplitN(size, ".", 2)[0]) {
+			return stitch.ARM64
+		}
+		return stitch.AMD64
+	case db.Google:
+		if googleArmSeries.MatchString(strings.SplitN(size, "-", 2)[0]) {
+			return stitch.ARM64
+		}
+		return stitch.AMD64
+	default:
+		return ""
+	}
+}
+
+// Amazon instance families with enhanced networking, through either the Elastic
+// Network Adapter or, in older families, the Intel 82599 Virtual Function.  Every
+// family from the fifth generation on has it.
+var amazonEnhancedFamily = regexp.MustCompile(`^(a1|c3|c4|d2|f1|g3[a-z]*|h1|i2|` +
+	`i3[a-z]*|m4|p2|p3[a-z]*|r3|r4|t3[a-z]*|x1[a-z]*|z1d|[a-z]+[5-9][a-z]*)$`)
+
+// SizeHasNetworkTier returns whether the given provider's machines of the given size
+// offer the network tier.
+func SizeHasNetworkTier(provider db.Provider, size, tier string) bool {
+	switch provider {
+	case db.Amazon:
+		family := strings.SplitN(size, ".", 2)[0]
+		return tier == stitch.NetworkTierEnhanced &&
+			amazonEnhancedFamily.MatchString(family)
+	default:
+		return false
+	}
+}
+
 func chooseBestSize(descriptions []Description, ram, cpu stitch.Range,
 	maxPrice float64) string {
 	var best Description
@@ -3,6 +3,7 @@ package machine
 import (
 	"testing"
 
+	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/stitch"
 )
 
@@ -61,3 +62,83 @@ func TestConstraints(t *testing.T) {
 	checkConstraint(testDescriptions, stitch.Range{Min: 3},
 		stitch.Range{}, 0, "size4")
 }
+
+func TestSizeArch(t *testing.T) {
+	for _, test := range []struct {
+		provider db.Provider
+		size     string
+		exp      string
+	}{
+		{db.Amazon, "m4.large", stitch.AMD64},
+		{db.Amazon, "g2.2xlarge", stitch.AMD64},
+		{db.Amazon, "a1.large", stitch.ARM64},
+		{db.Amazon, "m6g.large", stitch.ARM64},
+		{db.Amazon, "c6gn.xlarge", stitch.ARM64},
+		{db.Google, "n1-standard-1", stitch.AMD64},
+		{db.Google, "g1-small", stitch.AMD64},
+		{db.Google, "t2a-standard-1", stitch.ARM64},
+		{db.Vagrant, "1,1", ""},
+	} {
+		if arch := SizeArch(test.provider, test.size); arch != test.exp {
+			t.Errorf("wrong architecture for %s %s: expected %q, got %q",
+				test.provider, test.size, test.exp, arch)
+		}
+	}
+}
+
+func TestSizeHasNetworkTier(t *testing.T) {
+	for _, test := range []struct {
+		provider db.Provider
+		size     string
+		tier     string
+		exp      bool
+	}{
+		{db.Amazon, "m4.large", stitch.NetworkTierEnhanced, true},
+		{db.Amazon, "c5n.xlarge", stitch.NetworkTierEnhanced, true},
+		{db.Amazon, "m6g.large", stitch.NetworkTierEnhanced, true},
+		{db.Amazon, "m3.medium", stitch.NetworkTierEnhanced, false},
+		{db.Amazon, "g2.2xlarge", stitch.NetworkTierEnhanced, false},
+		{db.Amazon, "m4.large", "ultra", false},
+		{db.Google, "n1-standard-1", stitch.NetworkTierEnhanced, false},
+	} {
+		if SizeHasNetworkTier(test.provider, test.size, test.tier) != test.exp {
+			t.Errorf("expected %s %s to have network tier %q: %t",
+				test.provider, test.size, test.tier, test.exp)
+		}
+	}
+
+	// Only sizes with the tier are chosen for machines that ask for it.
+	size := ChooseSize(db.Amazon, stitch.Range{}, stitch.Range{}, 0, "")
+	if SizeHasNetworkTier(db.Amazon, size, stitch.NetworkTierEnhanced) {
+		t.Errorf("expected the cheapest size to lack enhanced networking, "+
+			"got %s", size)
+	}
+
+	size = ChooseSize(db.Amazon, stitch.Range{}, stitch.Range{}, 0,
+		stitch.NetworkTierEnhanced)
+	if !SizeHasNetworkTier(db.Amazon, size, stitch.NetworkTierEnhanced) {
+		t.Errorf("chose %s, which lacks enhanced networking", size)
+	}
+}
+
+func TestSizePrice(t *testing.T) {
+	for _, test := range []struct {
+		provider db.Provider
+		region   string
+		size     string
+		exp      float64
+	}{
+		{db.Amazon, "us-west-1", "m4.large", 0.14},
+		{db.Amazon, "us-west-2", "m4.large", 0.12},
+		{db.Amazon, "us-west-2", "m4.xlarge", 0.239},
+		{db.Google, "us-east1-b", "n1-standard-1", 0.050},
+		{db.Amazon, "us-west-2", "unknown", 0},
+		{db.Vagrant, "", "1,1", 0},
+	} {
+		price := SizePrice(test.provider, test.region, test.size)
+		if price != test.exp {
+			t.Errorf("wrong price for %s %s in %s: expected %g, got %g",
+				test.provider, test.size, test.region, test.exp, price)
+		}
+	}
+}
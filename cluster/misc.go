@@ -1,12 +1,20 @@
 package cluster
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/NetSys/quilt/cluster/amazon"
 	"github.com/NetSys/quilt/cluster/google"
 	"github.com/NetSys/quilt/cluster/machine"
 	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/util"
+
+	"github.com/mitchellh/go-homedir"
 )
 
 // DefaultRegion populates `m.Region` for the provided db.Machine if one isn't
@@ -22,7 +30,7 @@ func DefaultRegion(m db.Machine) db.Machine {
 		m.Region = amazon.DefaultRegion
 	case db.Google:
 		m.Region = google.DefaultRegion
-	case db.Vagrant:
+	case db.Vagrant, db.Static:
 	default:
 		panic(fmt.Sprintf("Unknown Cloud Provider: %s", m.Provider))
 	}
@@ -30,6 +38,61 @@ func DefaultRegion(m db.Machine) db.Machine {
 	return m
 }
 
+// Regions returns the regions each provider may boot machines in, keyed by the
+// provider's name.  Vagrant and static machines have no regions, so they're absent.
+func Regions() map[string][]string {
+	return map[string][]string{
+		string(db.Amazon): amazon.Regions(),
+		string(db.Google): google.Regions(),
+	}
+}
+
 // ChooseSize returns an acceptable machine size for the given provider that fits the
-// provided ram, cpu, and price constraints.
+// provided ram, cpu, and price constraints, and that offers the network tier, if any.
 var ChooseSize = machine.ChooseSize
+
+// SizeHasNetworkTier returns whether the given provider's machines of the given size
+// offer the network tier.
+var SizeHasNetworkTier = machine.SizeHasNetworkTier
+
+// SizeArch returns the CPU architecture of the given provider's machines of the given
+// size, or the empty string if it's unknown.
+var SizeArch = machine.SizeArch
+
+// SizePrice returns the hourly price of the given provider's machines of the given
+// size in the given region, or zero if it's unknown.
+var SizePrice = machine.SizePrice
+
+// getClusterID returns the ID that distinguishes this daemon's cloud resources from
+// those of other daemons using the same namespace.  The ID is generated the first
+// time the daemon runs, and is persisted so that it survives restarts.
+var getClusterID = func() (string, error) {
+	dir, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return loadClusterID(filepath.Join(dir, ".quilt", "cluster_id"))
+}
+
+func loadClusterID(path string) (string, error) {
+	id, err := util.ReadFile(path)
+	if err == nil && strings.TrimSpace(id) != "" {
+		return strings.TrimSpace(id), nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	id = hex.EncodeToString(idBytes)
+
+	if err := util.AppFs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := util.WriteFile(path, []byte(id+"\n"), 0644); err != nil {
+		return "", err
+	}
+	return id, nil
+}
@@ -5,6 +5,10 @@ import (
 
 	"github.com/NetSys/quilt/cluster/machine"
 	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/util"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDefaultRegion(t *testing.T) {
@@ -55,7 +59,7 @@ func TestNewProviderFailure(t *testing.T) {
 			t.Error("provider.New did not panic on invalid provider")
 		}
 	}()
-	newProviderImpl("FakeAmazon", "namespace")
+	newProviderImpl("FakeAmazon", "namespace", "clusterid")
 }
 
 func TestGroupBy(t *testing.T) {
@@ -83,3 +87,22 @@ func TestGroupBy(t *testing.T) {
 		t.Errorf("unexpected Vagrant machines: %v", m)
 	}
 }
+
+func TestLoadClusterID(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+	path := "/home/user/.quilt/cluster_id"
+
+	id, err := loadClusterID(path)
+	assert.NoError(t, err)
+	assert.Len(t, id, 16)
+
+	// The ID is persisted, so later runs of the daemon reuse it.
+	again, err := loadClusterID(path)
+	assert.NoError(t, err)
+	assert.Equal(t, id, again)
+
+	util.WriteFile(path, []byte("abc123\n"), 0644)
+	id, err = loadClusterID(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", id)
+}
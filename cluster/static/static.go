@@ -0,0 +1,297 @@
+// Package static adopts existing machines, such as bare-metal servers, in place of
+// booting cloud instances.  Static machines are declared in the spec by address, and
+// Quilt logs into them as root over SSH to install and remove the minion.
+package static
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NetSys/quilt/cluster/acl"
+	"github.com/NetSys/quilt/cluster/cloudcfg"
+	"github.com/NetSys/quilt/cluster/machine"
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/util"
+
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ssh"
+)
+
+// The iptables chain that enforces the ACLs on each machine.
+const aclChain = "quilt-acl"
+
+const sshTimeout = 10 * time.Second
+
+// The Cluster object represents the set of adopted machines.
+//
+// Static machines can't be listed through any API, so the Cluster only knows the
+// machines it adopted itself.  After the daemon restarts, Boot adopts the spec's
+// machines again, which leaves machines whose minion is already running untouched.
+type Cluster struct {
+	namespace string
+
+	mutex    sync.Mutex
+	machines map[string]machine.Machine // Keyed by public IP.
+	aclRules map[string]string          // The ACL rules installed on each machine.
+}
+
+// New creates a new static cluster.
+func New(namespace string) (*Cluster, error) {
+	return &Cluster{
+		namespace: namespace,
+		machines:  map[string]machine.Machine{},
+		aclRules:  map[string]string{},
+	}, nil
+}
+
+// Boot adopts the machines in `bootSet` by installing and starting the minion on
+// them.
+func (clst *Cluster) Boot(bootSet []machine.Machine) error {
+	return forEach(bootSet, func(m machine.Machine) error {
+		if err := runScript(m, bootScript(m)); err != nil {
+			return fmt.Errorf("failed to adopt %s: %s", m.PublicIP, err)
+		}
+
+		m.ID = m.PublicIP
+		m.Provider = db.Static
+
+		clst.mutex.Lock()
+		clst.machines[m.PublicIP] = m
+		clst.mutex.Unlock()
+		return nil
+	})
+}
+
+// List returns the adopted machines.  Machines that can't be reached are marked as
+// Failed rather than omitted, so that they aren't adopted again.
+func (clst *Cluster) List() ([]machine.Machine, error) {
+	machines := append([]machine.Machine{}, clst.adopted()...)
+
+	var wg sync.WaitGroup
+	for i := range machines {
+		wg.Add(1)
+		go func(m *machine.Machine) {
+			defer wg.Done()
+			m.Failed = !reachable(m.PublicIP)
+		}(&machines[i])
+	}
+	wg.Wait()
+
+	return machines, nil
+}
+
+// Stop stops the minion on `machines`, and removes everything Quilt installed.
+func (clst *Cluster) Stop(machines []machine.Machine) error {
+	return forEach(machines, func(m machine.Machine) error {
+		if err := runScript(m, stopScript); err != nil {
+			return fmt.Errorf("failed to release %s: %s", m.PublicIP, err)
+		}
+
+		clst.mutex.Lock()
+		delete(clst.machines, m.PublicIP)
+		delete(clst.aclRules, m.PublicIP)
+		clst.mutex.Unlock()
+		return nil
+	})
+}
+
+// SetACLs enforces `acls` with iptables on each of the adopted machines, as there's
+// no cloud firewall in front of them.
+func (clst *Cluster) SetACLs(acls []acl.ACL) error {
+	machines := clst.adopted()
+	return forEach(machines, func(m machine.Machine) error {
+		rules := aclRules(acls, m, machines)
+
+		clst.mutex.Lock()
+		installed := clst.aclRules[m.PublicIP]
+		clst.mutex.Unlock()
+
+		if rules == installed {
+			return nil
+		}
+
+		if err := runScript(m, aclScript(rules)); err != nil {
+			return fmt.Errorf("failed to set ACLs on %s: %s", m.PublicIP, err)
+		}
+
+		clst.mutex.Lock()
+		clst.aclRules[m.PublicIP] = rules
+		clst.mutex.Unlock()
+		return nil
+	})
+}
+
+// adopted returns the adopted machines, sorted by public IP so that the ACL rules
+// generated from them are stable.
+func (clst *Cluster) adopted() []machine.Machine {
+	clst.mutex.Lock()
+	defer clst.mutex.Unlock()
+
+	var ips []string
+	for ip := range clst.machines {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	var machines []machine.Machine
+	for _, ip := range ips {
+		machines = append(machines, clst.machines[ip])
+	}
+	return machines
+}
+
+// forEach calls `fn` on each of `machines` in parallel, and returns one of the
+// errors it returned, if any.
+func forEach(machines []machine.Machine, fn func(machine.Machine) error) error {
+	errChan := make(chan error, 1)
+
+	var wg sync.WaitGroup
+	for _, m := range machines {
+		wg.Add(1)
+		go func(m machine.Machine) {
+			defer wg.Done()
+			if err := fn(m); err != nil {
+				select {
+				case errChan <- err:
+				default:
+				}
+			}
+		}(m)
+	}
+	wg.Wait()
+
+	var err error
+	select {
+	case err = <-errChan:
+	default:
+	}
+	return err
+}
+
+// bootScript installs and starts the minion, unless it's already running because the
+// machine was adopted before.
+func bootScript(m machine.Machine) string {
+	return "systemctl is-active --quiet minion.service && exit 0\n" +
+		cloudcfg.Ubuntu(m.SSHKeys, "xenial")
+}
+
+var stopScript = fmt.Sprintf(`systemctl disable --now minion.service ovs.service
+rm -f /etc/systemd/system/minion.service /etc/systemd/system/ovs.service
+systemctl daemon-reload
+docker ps -aq --filter label=quilt | xargs -r docker rm -f
+docker rm -f minion etcd ovn-controller ovn-northd ovsdb-server ovs-vswitchd
+iptables -D INPUT -j %[1]s
+iptables -F %[1]s
+iptables -X %[1]s
+exit 0
+`, aclChain)
+
+// aclRules returns the iptables rules that admit `acls` into `m`.  Traffic from the
+// other adopted machines is admitted as well, and everything else addressed to `m`
+// is dropped.
+func aclRules(acls []acl.ACL, m machine.Machine, machines []machine.Machine) string {
+	rules := []string{
+		"-m conntrack --ctstate RELATED,ESTABLISHED -j RETURN",
+		"-p icmp -j RETURN",
+	}
+
+	for _, a := range acls {
+		for _, protocol := range []string{"tcp", "udp"} {
+			rules = append(rules, fmt.Sprintf("-s %[1]s -p %[2]s -m %[2]s "+
+				"--dport %[3]d:%[4]d -j RETURN",
+				a.CidrIP, protocol, a.MinPort, a.MaxPort))
+		}
+	}
+
+	for _, other := range machines {
+		if other.PrivateIP != "" {
+			rules = append(rules, fmt.Sprintf("-s %s/32 -j RETURN",
+				other.PrivateIP))
+		}
+	}
+
+	for _, ip := range []string{m.PublicIP, m.PrivateIP} {
+		if ip != "" {
+			rules = append(rules, fmt.Sprintf("-d %s/32 -j DROP", ip))
+		}
+	}
+
+	// Declaring the chain to iptables-restore flushes it.
+	lines := []string{fmt.Sprintf(":%s - [0:0]", aclChain)}
+	for _, rule := range rules {
+		lines = append(lines, fmt.Sprintf("-A %s %s", aclChain, rule))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// aclScript atomically replaces the rules in the ACL chain with `rules`, and makes
+// sure that INPUT jumps to it.
+func aclScript(rules string) string {
+	return fmt.Sprintf(`set -e
+iptables-restore --noflush <<EOF
+*filter
+%[2]s
+COMMIT
+EOF
+iptables -C INPUT -j %[1]s 2>/dev/null || iptables -I INPUT -j %[1]s
+`, aclChain, rules)
+}
+
+// runScript runs `script` as root on `m`.  It's a variable so that it may be mocked.
+var runScript = func(m machine.Machine, script string) error {
+	keyPath, err := homedir.Expand(m.SSHKeyPath)
+	if err != nil {
+		return err
+	}
+
+	key, err := util.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+
+	signer, err := ssh.ParsePrivateKey([]byte(key))
+	if err != nil {
+		return err
+	}
+
+	client, err := ssh.Dial("tcp", net.JoinHostPort(m.PublicIP, "22"),
+		&ssh.ClientConfig{
+			User:    "root",
+			Auth:    []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			Timeout: sshTimeout,
+		})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdin = strings.NewReader(script)
+	session.Stderr = &stderr
+	if err := session.Run("bash -s"); err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// reachable reports whether the SSH port of `ip` accepts connections.  It's a
+// variable so that it may be mocked.
+var reachable = func(ip string) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, "22"), sshTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,114 @@
+package static
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/NetSys/quilt/cluster/acl"
+	"github.com/NetSys/quilt/cluster/machine"
+	"github.com/NetSys/quilt/db"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeHosts struct {
+	sync.Mutex
+	scripts map[string][]string // The scripts run on each host.
+	down    map[string]bool
+}
+
+func mockHosts() *fakeHosts {
+	hosts := &fakeHosts{scripts: map[string][]string{}, down: map[string]bool{}}
+	runScript = func(m machine.Machine, script string) error {
+		hosts.Lock()
+		defer hosts.Unlock()
+
+		if hosts.down[m.PublicIP] {
+			return errors.New("unreachable")
+		}
+		hosts.scripts[m.PublicIP] = append(hosts.scripts[m.PublicIP], script)
+		return nil
+	}
+	reachable = func(ip string) bool {
+		hosts.Lock()
+		defer hosts.Unlock()
+		return !hosts.down[ip]
+	}
+	return hosts
+}
+
+func TestBootStop(t *testing.T) {
+	hosts := mockHosts()
+	clst, _ := New("ns")
+
+	a := machine.Machine{PublicIP: "1.1.1.1", PrivateIP: "10.0.0.1",
+		SSHKeyPath: "key", SSHKeys: []string{"ssh-rsa a"}}
+	b := machine.Machine{PublicIP: "2.2.2.2", PrivateIP: "10.0.0.2"}
+	hosts.down["2.2.2.2"] = true
+
+	err := clst.Boot([]machine.Machine{a, b})
+	assert.EqualError(t, err, "failed to adopt 2.2.2.2: unreachable")
+	assert.Len(t, hosts.scripts["1.1.1.1"], 1)
+	assert.Contains(t, hosts.scripts["1.1.1.1"][0], "ssh-rsa a")
+
+	// Machines that couldn't be adopted aren't listed, so they're retried.
+	adopted := a
+	adopted.ID = "1.1.1.1"
+	adopted.Provider = db.Static
+	machines, err := clst.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []machine.Machine{adopted}, machines)
+
+	// Adopted machines that become unreachable are listed as failed.
+	hosts.down["1.1.1.1"] = true
+	machines, _ = clst.List()
+	adopted.Failed = true
+	assert.Equal(t, []machine.Machine{adopted}, machines)
+
+	assert.Error(t, clst.Stop(machines))
+	machines, _ = clst.List()
+	assert.Len(t, machines, 1)
+
+	hosts.down["1.1.1.1"] = false
+	assert.NoError(t, clst.Stop(machines))
+	assert.Equal(t, stopScript, hosts.scripts["1.1.1.1"][1])
+	machines, _ = clst.List()
+	assert.Empty(t, machines)
+}
+
+func TestSetACLs(t *testing.T) {
+	hosts := mockHosts()
+	clst, _ := New("ns")
+
+	assert.NoError(t, clst.Boot([]machine.Machine{
+		{PublicIP: "1.1.1.1", PrivateIP: "10.0.0.1"},
+		{PublicIP: "2.2.2.2", PrivateIP: "10.0.0.2"},
+	}))
+
+	acls := []acl.ACL{{CidrIP: "5.5.5.5/32", MinPort: 80, MaxPort: 81}}
+	assert.NoError(t, clst.SetACLs(acls))
+
+	scripts := hosts.scripts["1.1.1.1"]
+	assert.Len(t, scripts, 2)
+	assert.Equal(t, aclScript(strings.Join([]string{
+		":quilt-acl - [0:0]",
+		"-A quilt-acl -m conntrack --ctstate RELATED,ESTABLISHED -j RETURN",
+		"-A quilt-acl -p icmp -j RETURN",
+		"-A quilt-acl -s 5.5.5.5/32 -p tcp -m tcp --dport 80:81 -j RETURN",
+		"-A quilt-acl -s 5.5.5.5/32 -p udp -m udp --dport 80:81 -j RETURN",
+		"-A quilt-acl -s 10.0.0.1/32 -j RETURN",
+		"-A quilt-acl -s 10.0.0.2/32 -j RETURN",
+		"-A quilt-acl -d 1.1.1.1/32 -j DROP",
+		"-A quilt-acl -d 10.0.0.1/32 -j DROP",
+	}, "\n")), scripts[1])
+
+	// Unchanged ACLs aren't installed again.
+	assert.NoError(t, clst.SetACLs(acls))
+	assert.Len(t, hosts.scripts["1.1.1.1"], 2)
+	assert.Len(t, hosts.scripts["2.2.2.2"], 2)
+
+	assert.NoError(t, clst.SetACLs(nil))
+	assert.Len(t, hosts.scripts["1.1.1.1"], 3)
+	assert.NotContains(t, hosts.scripts["1.1.1.1"][2], "5.5.5.5")
+}
@@ -40,7 +40,7 @@ Vagrant.configure(2) do |config|
 end
 `
 
-func initMachine(cloudConfig string, size string, id string) error {
+func initMachine(cloudConfig, size, id, namespace, clusterID string) error {
 	vdir, err := vagrantDir()
 	if err != nil {
 		return err
@@ -76,6 +76,18 @@ func initMachine(cloudConfig string, size string, id string) error {
 		return err
 	}
 
+	err = util.WriteFile(path+"/namespace", []byte(namespace), 0644)
+	if err != nil {
+		destroy(id)
+		return err
+	}
+
+	err = util.WriteFile(path+"/cluster_id", []byte(clusterID), 0644)
+	if err != nil {
+		destroy(id)
+		return err
+	}
+
 	return nil
 }
 
@@ -204,6 +216,47 @@ func vagrantDir() (string, error) {
 	return vagrantDir, nil
 }
 
+// owner returns the namespace and cluster ID the machine with the given ID was booted
+// with.  Both are empty for machines booted before they were recorded.
+func owner(id string) (namespace, clusterID string) {
+	vdir, err := vagrantDir()
+	if err != nil {
+		return "", ""
+	}
+
+	namespace, _ = util.ReadFile(vdir + id + "/namespace")
+	clusterID, _ = util.ReadFile(vdir + id + "/cluster_id")
+	return namespace, clusterID
+}
+
+// claim records `namespace` and `clusterID` as the owner of the machine with the given
+// ID, unless another cluster ID is recorded first.  It returns whether the machine is
+// owned by `clusterID`.
+func claim(id, namespace, clusterID string) (bool, error) {
+	vdir, err := vagrantDir()
+	if err != nil {
+		return false, err
+	}
+
+	// Creating the file exclusively ensures that only one daemon claims it.
+	if _, owner := owner(id); owner == "" {
+		f, err := util.AppFs.OpenFile(vdir+id+"/cluster_id",
+			os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.Write([]byte(clusterID))
+			f.Close()
+		}
+		if err != nil && !os.IsExist(err) {
+			return false, err
+		}
+	}
+
+	if _, owner := owner(id); owner != clusterID {
+		return false, nil
+	}
+	return true, util.WriteFile(vdir+id+"/namespace", []byte(namespace), 0644)
+}
+
 func size(id string) string {
 	size, _, err := shell(id, "cat size")
 	if err != nil {
@@ -1,6 +1,7 @@
 package vagrant
 
 import (
+	"strings"
 	"sync"
 
 	"github.com/NetSys/quilt/cluster/acl"
@@ -14,13 +15,87 @@ import (
 // The Cluster object represents a connection to Amazon EC2.
 type Cluster struct {
 	namespace string
+	clusterID string
 }
 
-// New creates a new vagrant cluster.
-func New(namespace string) (*Cluster, error) {
-	clst := Cluster{namespace}
+// New creates a new vagrant cluster.  Machines belong to the cluster only if they were
+// booted with both `namespace` and `clusterID`, so that daemons on the same host don't
+// manage each other's machines.
+func New(namespace, clusterID string) (*Cluster, error) {
+	clst := Cluster{namespace, clusterID}
 	err := addBox("boxcutter/ubuntu1604", "virtualbox")
-	return &clst, err
+	if err != nil {
+		return &clst, err
+	}
+
+	if err := clst.adoptLegacy(); err != nil {
+		return &clst, err
+	}
+
+	_, foreign, err := clst.listIDs()
+	if err != nil {
+		return &clst, err
+	}
+	if len(foreign) > 0 {
+		log.WithField("instances", strings.Join(foreign, ", ")).Errorf(
+			"Vagrant: Refusing to manage machines in namespace %s that "+
+				"weren't booted by this daemon", clst.namespace)
+	}
+	return &clst, nil
+}
+
+// listIDs returns the IDs of the machines booted by this daemon, and of those in its
+// namespace that were booted by another daemon.  Machines booted by versions of Quilt
+// that predate cluster IDs recorded neither, so they're in every namespace.
+func (clst Cluster) listIDs() (ours, foreign []string, err error) {
+	instanceIDs, err := list()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, id := range instanceIDs {
+		namespace, clusterID := owner(id)
+		switch {
+		case namespace == clst.namespace && clusterID == clst.clusterID:
+			ours = append(ours, id)
+		case namespace == clst.namespace || namespace == "":
+			foreign = append(foreign, id)
+		}
+	}
+	return ours, foreign, nil
+}
+
+// adoptLegacy takes over the machines booted by versions of Quilt that predate cluster
+// IDs, so that upgrading the daemon doesn't strand them.  Those versions ran one daemon
+// per host, so the legacy machines are only adopted if no other daemon has booted
+// machines on the host since.
+func (clst Cluster) adoptLegacy() error {
+	instanceIDs, err := list()
+	if err != nil {
+		return err
+	}
+
+	var legacy []string
+	for _, id := range instanceIDs {
+		_, clusterID := owner(id)
+		switch clusterID {
+		case "":
+			legacy = append(legacy, id)
+		case clst.clusterID:
+		default:
+			return nil
+		}
+	}
+
+	for _, id := range legacy {
+		adopted, err := claim(id, clst.namespace, clst.clusterID)
+		if err != nil {
+			return err
+		} else if adopted {
+			log.Infof("Vagrant: Adopted legacy machine %s", id)
+		}
+	}
+	return nil
 }
 
 // Boot creates instances in the `clst` configured according to the `bootSet`.
@@ -34,7 +109,7 @@ func (clst Cluster) Boot(bootSet []machine.Machine) error {
 		wg.Add(1)
 		go func(m machine.Machine) {
 			defer wg.Done()
-			if err := bootMachine(m); err != nil {
+			if err := clst.bootMachine(m); err != nil {
 				select {
 				case errChan <- err:
 				default:
@@ -53,10 +128,11 @@ func (clst Cluster) Boot(bootSet []machine.Machine) error {
 	return err
 }
 
-func bootMachine(m machine.Machine) error {
+func (clst Cluster) bootMachine(m machine.Machine) error {
 	id := uuid.NewV4().String()
 
-	err := initMachine(cloudcfg.Ubuntu(m.SSHKeys, "xenial"), m.Size, id)
+	err := initMachine(cloudcfg.Ubuntu(m.SSHKeys, "xenial"), m.Size, id,
+		clst.namespace, clst.clusterID)
 	if err == nil {
 		err = up(id)
 	}
@@ -71,7 +147,7 @@ func bootMachine(m machine.Machine) error {
 // List queries `clst` for the list of booted machines.
 func (clst Cluster) List() ([]machine.Machine, error) {
 	machines := []machine.Machine{}
-	instanceIDs, err := list()
+	instanceIDs, _, err := clst.listIDs()
 
 	if err != nil {
 		return machines, err
@@ -3,6 +3,8 @@ package vagrant
 import (
 	"testing"
 
+	"github.com/NetSys/quilt/util"
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -10,3 +12,45 @@ func TestSetACLs(t *testing.T) {
 	clst := Cluster{}
 	assert.Nil(t, clst.SetACLs(nil))
 }
+
+func TestOwner(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+	vdir, err := vagrantDir()
+	assert.NoError(t, err)
+
+	namespace, clusterID := owner("legacy")
+	assert.Equal(t, "", namespace)
+	assert.Equal(t, "", clusterID)
+
+	util.WriteFile(vdir+"ours/namespace", []byte("ns"), 0644)
+	util.WriteFile(vdir+"ours/cluster_id", []byte("clusterid"), 0644)
+	namespace, clusterID = owner("ours")
+	assert.Equal(t, "ns", namespace)
+	assert.Equal(t, "clusterid", clusterID)
+}
+
+func TestClaim(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+	vdir, err := vagrantDir()
+	assert.NoError(t, err)
+	util.AppFs.MkdirAll(vdir+"legacy", 0755)
+
+	adopted, err := claim("legacy", "ns", "clusterid")
+	assert.NoError(t, err)
+	assert.True(t, adopted)
+	namespace, clusterID := owner("legacy")
+	assert.Equal(t, "ns", namespace)
+	assert.Equal(t, "clusterid", clusterID)
+
+	// Claiming is idempotent, but the first daemon to claim a machine keeps it.
+	adopted, err = claim("legacy", "ns", "clusterid")
+	assert.NoError(t, err)
+	assert.True(t, adopted)
+
+	adopted, err = claim("legacy", "other", "otherid")
+	assert.NoError(t, err)
+	assert.False(t, adopted)
+	namespace, clusterID = owner("legacy")
+	assert.Equal(t, "ns", namespace)
+	assert.Equal(t, "clusterid", clusterID)
+}
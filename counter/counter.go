@@ -0,0 +1,103 @@
+// Package counter implements cheap, increment-only counters that the daemon and
+// minion use to track how often their internal events happen.  The counters are
+// dumped by `quilt counters` to help debug problems at scale.
+package counter
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// A Counter tracks the number of times some event has occurred.
+type Counter struct {
+	Pkg  string
+	Name string
+
+	value     uint64
+	prevValue uint64
+}
+
+// A Value is a snapshot of a Counter.  PrevValue is the value the counter had when
+// it was last dumped.
+type Value struct {
+	Pkg       string
+	Name      string
+	Value     uint64
+	PrevValue uint64
+}
+
+var (
+	mutex    sync.Mutex
+	counters = map[string]*Counter{}
+)
+
+// New returns the counter named `name` in package `pkg`, creating it if it doesn't
+// already exist.
+func New(pkg, name string) *Counter {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	key := pkg + "." + name
+	if c, ok := counters[key]; ok {
+		return c
+	}
+
+	c := &Counter{Pkg: pkg, Name: name}
+	counters[key] = c
+	return c
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by `n`.
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Get returns the counter's current value.
+func (c *Counter) Get() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// Dump returns the values of every counter, sorted by package and name.  Each call
+// records the current values, so that the next Dump can report what changed.
+func Dump() []Value {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var values []Value
+	for _, c := range counters {
+		value := atomic.LoadUint64(&c.value)
+		prevValue := atomic.SwapUint64(&c.prevValue, value)
+		values = append(values, Value{
+			Pkg:       c.Pkg,
+			Name:      c.Name,
+			Value:     value,
+			PrevValue: prevValue,
+		})
+	}
+
+	sort.Sort(valueSlice(values))
+	return values
+}
+
+type valueSlice []Value
+
+func (vs valueSlice) Len() int {
+	return len(vs)
+}
+
+func (vs valueSlice) Swap(i, j int) {
+	vs[i], vs[j] = vs[j], vs[i]
+}
+
+func (vs valueSlice) Less(i, j int) bool {
+	if vs[i].Pkg != vs[j].Pkg {
+		return vs[i].Pkg < vs[j].Pkg
+	}
+	return vs[i].Name < vs[j].Name
+}
@@ -0,0 +1,36 @@
+package counter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounters(t *testing.T) {
+	counters = map[string]*Counter{}
+
+	b := New("pkg", "b")
+	a := New("pkg", "a")
+	other := New("other", "c")
+	assert.Equal(t, a, New("pkg", "a"))
+
+	a.Inc()
+	a.Inc()
+	b.Add(5)
+	assert.Equal(t, uint64(2), a.Get())
+
+	assert.Equal(t, []Value{
+		{Pkg: "other", Name: "c"},
+		{Pkg: "pkg", Name: "a", Value: 2},
+		{Pkg: "pkg", Name: "b", Value: 5},
+	}, Dump())
+
+	a.Inc()
+	other.Inc()
+
+	assert.Equal(t, []Value{
+		{Pkg: "other", Name: "c", Value: 1},
+		{Pkg: "pkg", Name: "a", Value: 3, PrevValue: 2},
+		{Pkg: "pkg", Name: "b", Value: 5, PrevValue: 5},
+	}, Dump())
+}
@@ -12,6 +12,10 @@ type ACL struct {
 
 	Admin            []string
 	ApplicationPorts []PortRange
+
+	// CIDRs that may only SSH into the machines.  The minions further restrict
+	// them according to the ACL for their role.
+	SSH []string
 }
 
 // PortRange represents a range of ports for which to allow traffic.
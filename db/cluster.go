@@ -3,6 +3,7 @@ package db
 import (
 	"errors"
 	"log"
+	"time"
 )
 
 // A Cluster is a group of Machines which can operate containers.
@@ -11,6 +12,26 @@ type Cluster struct {
 
 	Namespace string // Cloud Provider Namespace
 	Spec      string `rowStringer:"omit"`
+
+	// While the machines are frozen, the daemon neither boots nor terminates
+	// machines, though containers may still change.
+	FreezeMachines bool
+
+	// The number of workers booted from the spec's autoscale template, and when
+	// the count last changed or containers last waited on it.
+	AutoscaledWorkers int
+	LastAutoscale     time.Time `rowStringer:"omit"`
+
+	// The results of the most recent API requests that carried an idempotency
+	// key, from least to most recently used, so that the daemon can answer
+	// replays of them without running them again.
+	Requests []Request `rowStringer:"omit"`
+}
+
+// A Request is the result of an API request that carried an idempotency key.
+type Request struct {
+	Key   string
+	Error string // Empty if the request succeeded.
 }
 
 // InsertCluster creates a new Cluster and interts it into 'db'.
@@ -5,14 +5,27 @@ import (
 )
 
 // A Connection allows the members of two labels to speak to each other on the port
-// range [MinPort, MaxPort] inclusive.
+// range [MinPort, MaxPort] inclusive.  If Protocol is set, only traffic of that
+// protocol is allowed.
 type Connection struct {
 	ID int
 
-	From    string
-	To      string
-	MinPort int
-	MaxPort int
+	From     string
+	To       string
+	MinPort  int
+	MaxPort  int
+	Protocol string
+
+	BandwidthLimit int // Bits per second each From container may send, or zero.
+	DSCP           int // The DSCP value the From containers' traffic is marked with.
+
+	// Whether the connection may cross from one isolated network into another.
+	AllowCrossNetwork bool
+
+	// The hex encoded UDP query with which the workers probe the To containers of a
+	// public connection, and whether the containers must reply to it.
+	ProbeUDPQuery       string
+	ProbeExpectResponse bool
 }
 
 // InsertConnection creates a new connection row and inserts it into the database.
@@ -51,11 +64,19 @@ func (conn Conn) SelectFromConnection(check func(Connection) bool) []Connection
 }
 
 func (c Connection) String() string {
+	if c.Protocol == "icmp" {
+		return fmt.Sprintf("Connection-%d{%s->%s:icmp}", c.ID, c.From, c.To)
+	}
+
 	port := fmt.Sprintf("%d", c.MinPort)
 	if c.MaxPort != c.MinPort {
 		port += fmt.Sprintf("-%d", c.MaxPort)
 	}
 
+	if c.Protocol != "" {
+		port += "/" + c.Protocol
+	}
+
 	return fmt.Sprintf("Connection-%d{%s->%s:%s}", c.ID, c.From, c.To, port)
 }
 
@@ -71,6 +92,8 @@ func (c Connection) less(r row) bool {
 		return c.MaxPort < o.MaxPort
 	case c.MinPort != o.MaxPort:
 		return c.MinPort < o.MinPort
+	case c.Protocol != o.Protocol:
+		return c.Protocol < o.Protocol
 	default:
 		return c.ID < o.ID
 	}
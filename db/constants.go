@@ -61,12 +61,15 @@ const (
 
 	// Vagrant implements local virtual machines.
 	Vagrant = "Vagrant"
+
+	// Static adopts existing machines, such as bare-metal servers.
+	Static = "Static"
 )
 
 // ParseProvider returns the Provider represented by 'name' or an error.
 func ParseProvider(name string) (Provider, error) {
 	switch name {
-	case "Amazon", "Google", "Vagrant":
+	case "Amazon", "Google", "Vagrant", "Static":
 		return Provider(name), nil
 	default:
 		return "", errors.New("unknown provider")
@@ -1,9 +1,12 @@
 package db
 
 import (
+	"encoding/json"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/NetSys/quilt/util"
 )
@@ -25,8 +28,67 @@ type Container struct {
 	Command    []string
 	Labels     []string
 	Env        map[string]string
+	ShmSize    int    // The size of /dev/shm in bytes, or zero for Docker's default.
+	Init       bool   // Run an init process as PID 1 that reaps zombies.
+	StopSignal string // The signal that stops the container, if not the default.
+	Arch       string // The CPU architecture required to run, if any.
+
+	// "host" or "none" if the container doesn't join the overlay, in which case
+	// it's never given an IP.  Empty for overlay containers.
+	NetworkMode string
+
+	// The isolated network the container is in, or empty for the default network.
+	// Traffic between networks is dropped unless a connection allows it.
+	Network string
+
+	FilepathToContent map[string]string // Files written before the container starts.
+
+	// The state the deployment wants the container in, either DesiredRunning or
+	// DesiredRemoved, and the state Docker reports for it, e.g. "running".  The
+	// actual state is empty until Docker has reported on the container.
+	DesiredState string
+	ActualState  string
+
+	// The number of replicas in the container's LabelSize annotated label, and
+	// this container's ordinal among them.  Zero if it has no such label.  They're
+	// injected into the environment when the container starts, and left out of
+	// the ConfigKey unless RestartOnResize, so that resizing a label doesn't
+	// restart its containers.
+	LabelSize       int
+	LabelIndex      int
+	RestartOnResize bool
+
+	// Why the scheduler last failed to place the container, or empty if it was
+	// placed.
+	PlacementFailure string
+
+	// Whether the container is one of its label's canaries.  It's left out of the
+	// ConfigKey, so that promoting a canary doesn't restart it.
+	Canary bool
+
+	// If set, the spec runs the container with a new image, but the rollout is
+	// deferred until the deployment's maintenance window opens at this time.
+	// Until then the container keeps its current Image.
+	RolloutAfter time.Time
 }
 
+// The environment variables that expose a container's LabelSize and LabelIndex.
+const (
+	LabelSizeEnv  = "QUILT_LABEL_SIZE"
+	LabelIndexEnv = "QUILT_LABEL_INDEX"
+)
+
+// The states the deployment may want a container in.
+const (
+	// DesiredRunning is the desired state of the containers the deployment
+	// declares.
+	DesiredRunning = "running"
+
+	// DesiredRemoved is the desired state of containers that are no longer
+	// declared, but that their worker hasn't finished tearing down.
+	DesiredRemoved = "removed"
+)
+
 // ContainerSlice is an alias for []Container to allow for joins
 type ContainerSlice []Container
 
@@ -60,6 +122,62 @@ func (conn Conn) SelectFromContainer(check func(Container) bool) []Container {
 	return containers
 }
 
+// ConfigKey returns a canonical string describing how `c` should be run: its image,
+// command, environment, and runtime options.  Containers with equal keys are
+// interchangeable.  Nil and empty collections produce the same key, and since
+// encoding/json sorts map keys, so do maps that differ only in iteration order.
+func (c Container) ConfigKey() string {
+	key := struct {
+		Image   string
+		Command []string
+		Env     map[string]string
+		ShmSize int
+		Init    bool
+		Files   map[string]string
+
+		// Omitted when empty so that the keys of containers that predate
+		// the field don't change.
+		StopSignal  string            `json:",omitempty"`
+		NetworkMode string            `json:",omitempty"`
+		LabelEnv    map[string]string `json:",omitempty"`
+		Network     string            `json:",omitempty"`
+	}{Image: c.Image, ShmSize: c.ShmSize, Init: c.Init, StopSignal: c.StopSignal,
+		NetworkMode: c.NetworkMode, Network: c.Network}
+
+	if len(c.Command) > 0 {
+		key.Command = c.Command
+	}
+	if len(c.Env) > 0 {
+		key.Env = c.Env
+	}
+	if len(c.FilepathToContent) > 0 {
+		key.Files = c.FilepathToContent
+	}
+	if c.RestartOnResize {
+		key.LabelEnv = c.LabelEnv()
+	}
+
+	js, err := json.Marshal(key)
+	if err != nil {
+		panic(fmt.Sprintf("failed to marshal container key: %s", err))
+	}
+	return string(js)
+}
+
+// LabelEnv returns the environment variables describing `c`'s LabelSize and
+// LabelIndex, or nil if it has no LabelSize.  They're a snapshot taken when the
+// container starts, and go stale as the label is resized.
+func (c Container) LabelEnv() map[string]string {
+	if c.LabelSize == 0 {
+		return nil
+	}
+
+	return map[string]string{
+		LabelSizeEnv:  strconv.Itoa(c.LabelSize),
+		LabelIndexEnv: strconv.Itoa(c.LabelIndex),
+	}
+}
+
 func (c Container) getID() int {
 	return c.ID
 }
@@ -73,6 +191,10 @@ func (c Container) String() string {
 		tags = append(tags, fmt.Sprintf("DockerID: %s", id))
 	}
 
+	if c.ActualState != "" {
+		tags = append(tags, fmt.Sprintf("ActualState: %s", c.ActualState))
+	}
+
 	if c.Minion != "" {
 		tags = append(tags, fmt.Sprintf("Minion: %s", c.Minion))
 	}
@@ -101,6 +223,49 @@ func (c Container) String() string {
 		tags = append(tags, fmt.Sprintf("Env: %s", c.Env))
 	}
 
+	if c.ShmSize != 0 {
+		tags = append(tags, fmt.Sprintf("ShmSize: %d", c.ShmSize))
+	}
+
+	if c.Init {
+		tags = append(tags, "Init")
+	}
+
+	if c.StopSignal != "" {
+		tags = append(tags, fmt.Sprintf("StopSignal: %s", c.StopSignal))
+	}
+
+	if c.NetworkMode != "" {
+		tags = append(tags, fmt.Sprintf("NetworkMode: %s", c.NetworkMode))
+	}
+
+	if c.Canary {
+		tags = append(tags, "Canary")
+	}
+
+	if c.Arch != "" {
+		tags = append(tags, fmt.Sprintf("Arch: %s", c.Arch))
+	}
+
+	if c.PlacementFailure != "" {
+		tags = append(tags, fmt.Sprintf("PlacementFailure: %s",
+			c.PlacementFailure))
+	}
+
+	if c.LabelSize != 0 {
+		tags = append(tags, fmt.Sprintf("LabelIndex: %d/%d", c.LabelIndex,
+			c.LabelSize))
+	}
+
+	if len(c.FilepathToContent) > 0 {
+		var paths []string
+		for path := range c.FilepathToContent {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		tags = append(tags, fmt.Sprintf("Files: %s", paths))
+	}
+
 	return fmt.Sprintf("Container-%d{%s}", c.ID, strings.Join(tags, ", "))
 }
 
@@ -116,16 +116,32 @@ func TestMachineString(t *testing.T) {
 		PublicIP:  "1.2.3.4",
 		PrivateIP: "5.6.7.8",
 		DiskSize:  56,
+		Draining:  true,
 		Connected: true,
 	}
 	got = m.String()
 	exp = "Machine-1{Amazon us-west-1 m4.large, CloudID1234, PublicIP=1.2.3.4," +
-		" PrivateIP=5.6.7.8, Disk=56GB, Connected}"
+		" PrivateIP=5.6.7.8, Disk=56GB, Draining, Connected}"
 	if got != exp {
 		t.Errorf("\nGot: %s\nExp: %s", got, exp)
 	}
 }
 
+func TestMachineSizes(t *testing.T) {
+	m := Machine{Size: "m4.large"}
+	assert.True(t, m.AllowsSize("m4.large"))
+	assert.False(t, m.AllowsSize("m5.large"))
+	assert.Equal(t, "m4.large", m.NextSize())
+
+	m.Sizes = []string{"m4.large", "m5.large", "m5a.large"}
+	assert.True(t, m.AllowsSize("m5a.large"))
+	assert.False(t, m.AllowsSize("c4.large"))
+	assert.Equal(t, "m5.large", m.NextSize())
+
+	m.Size = "m5a.large"
+	assert.Equal(t, "m4.large", m.NextSize())
+}
+
 func TestTxnBasic(t *testing.T) {
 	conn := New()
 	conn.Txn(AllTables...).Run(func(view Database) error {
@@ -547,6 +563,89 @@ func TestSortContainers(t *testing.T) {
 	}
 }
 
+func TestContainerConfigKey(t *testing.T) {
+	t.Parallel()
+
+	c := Container{
+		ID:      1,
+		Image:   "image",
+		Command: []string{"a", "b"},
+		Env:     map[string]string{"a": "1", "b": "2", "c": "3"},
+	}
+	key := c.ConfigKey()
+
+	// Fields that aren't part of the container's configuration are ignored.
+	other := c
+	other.ID = 2
+	other.DockerID = "docker"
+	other.Labels = []string{"label"}
+	assert.Equal(t, key, other.ConfigKey())
+
+	other.Env = map[string]string{"c": "3", "b": "2", "a": "1"}
+	assert.Equal(t, key, other.ConfigKey())
+
+	other.Env = map[string]string{"a": "1", "b": "2"}
+	assert.NotEqual(t, key, other.ConfigKey())
+
+	other = c
+	other.Command = []string{"b", "a"}
+	assert.NotEqual(t, key, other.ConfigKey())
+
+	other = c
+	other.Init = true
+	assert.NotEqual(t, key, other.ConfigKey())
+
+	other = c
+	other.StopSignal = "SIGQUIT"
+	assert.NotEqual(t, key, other.ConfigKey())
+
+	// Keys of containers without a stop signal are unchanged from before the
+	// field existed, so that upgrading doesn't restart them.
+	assert.NotContains(t, key, "StopSignal")
+
+	other = c
+	other.NetworkMode = "host"
+	assert.NotEqual(t, key, other.ConfigKey())
+	assert.NotContains(t, key, "NetworkMode")
+
+	// Moving a container to another network changes its IP.
+	other = c
+	other.Network = "db"
+	assert.NotEqual(t, key, other.ConfigKey())
+	assert.NotContains(t, key, "Network\"")
+
+	// Resizing a label only restarts its containers if they asked for it.
+	other = c
+	other.LabelSize = 3
+	other.LabelIndex = 1
+	assert.Equal(t, key, other.ConfigKey())
+
+	other.RestartOnResize = true
+	resized := other
+	resized.LabelSize = 4
+	assert.NotEqual(t, key, other.ConfigKey())
+	assert.NotEqual(t, other.ConfigKey(), resized.ConfigKey())
+
+	empty := Container{Image: "image"}
+	assert.Equal(t, empty.ConfigKey(), Container{
+		Image:   "image",
+		Command: []string{},
+		Env:     map[string]string{},
+
+		FilepathToContent: map[string]string{},
+	}.ConfigKey())
+}
+
+func TestContainerLabelEnv(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, Container{}.LabelEnv())
+	assert.Equal(t, map[string]string{
+		LabelSizeEnv:  "3",
+		LabelIndexEnv: "0",
+	}, Container{LabelSize: 3}.LabelEnv())
+}
+
 func TestGetClusterNamespace(t *testing.T) {
 	conn := New()
 
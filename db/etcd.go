@@ -13,8 +13,37 @@ type Etcd struct {
 
 	Leader   bool   // True if this Minion is the leader.
 	LeaderIP string // IP address of the current leader, or ""
+
+	// The state of tunnel encryption.  Tunnels are encrypted with the workers' keys
+	// of generation EncryptionGeneration.  While a key rotation is staged, the
+	// workers also hold keys of generation EncryptionNext, which the tunnels switch
+	// to once every worker has published them.  Zero means no generation.
+	Encryption           EncryptionStatus
+	EncryptionGeneration int
+	EncryptionNext       int
 }
 
+// EncryptionStatus describes whether the tunnels between workers are encrypted.
+type EncryptionStatus string
+
+const (
+	// EncryptionDisabled means the spec didn't ask for encryption.
+	EncryptionDisabled EncryptionStatus = ""
+
+	// EncryptionPending means the spec asked for encryption, but it isn't enabled
+	// because some workers can't encrypt their tunnels, or haven't published their
+	// keys yet.
+	EncryptionPending EncryptionStatus = "pending"
+
+	// EncryptionEnabled means the tunnels between workers are encrypted.
+	EncryptionEnabled EncryptionStatus = "enabled"
+
+	// EncryptionDegraded means encryption was enabled, but some workers can no
+	// longer encrypt their tunnels.  The rest keep their keys, and the keys aren't
+	// rotated until every worker can again.
+	EncryptionDegraded EncryptionStatus = "degraded"
+)
+
 func (e Etcd) String() string {
 	return defaultString(e)
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 )
 
 // Machine represents a physical or virtual machine operated by a cloud provider on
@@ -12,20 +13,96 @@ type Machine struct {
 	ID int //Database ID
 
 	/* Populated by the policy engine. */
-	Role     Role
-	Provider Provider
-	Region   string
-	Size     string
-	DiskSize int
-	SSHKeys  []string `rowStringer:"omit"`
+	Role      Role
+	Provider  Provider
+	Region    string
+	Size      string
+	Arch      string // The CPU architecture, e.g. "amd64", if it's known.
+	DiskSize  int
+	SpotPrice float64
+	SSHKeys   []string `rowStringer:"omit"`
+
+	// The private key used to log into static machines.
+	SSHKeyPath string `rowStringer:"omit"`
+
+	// If set, only containers with this label are scheduled on the machine.
+	DedicatedTo string
+
+	FloatingIP string // The floating IP reserved for the machine, if any.
+
+	// If set, the machine's minion doesn't run the NAT loop.
+	DisableNAT bool
+
+	// The provider specific networking performance the machine is booted with.
+	NetworkTier string
+
+	// The sizes the machine may be booted with, in order of preference.  Size is
+	// the one in use, or the next to try.  If empty, only Size is allowed.
+	Sizes []string `rowStringer:"omit"`
 
 	/* Populated by the cloud provider. */
 	CloudID   string //Cloud Provider ID
 	PublicIP  string
 	PrivateIP string
 
+	// Whether the machine is unreachable.  Set only for static machines, which
+	// can't be replaced.
+	Failed bool
+
+	// Whether the machine is being drained so that it may be terminated.  No
+	// containers are placed on a draining machine, and those already there are
+	// moved elsewhere.  Set by the policy engine, or by an operator.
+	Draining   bool
+	DrainStart time.Time `rowStringer:"omit"` // When draining began.
+
+	// If set, the spec no longer matches the machine, but its replacement is
+	// deferred until the deployment's maintenance window opens at this time.
+	// Set by the policy engine.
+	ReplaceAfter time.Time `rowStringer:"omit"`
+
 	/* Populated by the foreman. */
 	Connected bool // Whether the minion on this machine has connected back.
+
+	// The number of containers scheduled on the machine, as reported by its
+	// minion.
+	Containers int
+
+	// The number of containers the scheduler failed to place, as reported by the
+	// machine's minion if it's the leader.
+	Unscheduled int
+
+	// The health of the system containers run by the machine's supervisor, as
+	// reported by its minion.
+	SupervisorStatus map[string]ComponentStatus `rowStringer:"omit"`
+
+	// When the machine was first requested from its cloud provider, and how long
+	// each phase of its boot took.  The timing of the phases after the instance
+	// is running is reported by the minion.
+	BootRequested time.Time   `rowStringer:"omit"`
+	BootTimings   BootTimings `rowStringer:"omit"`
+}
+
+// BootTimings records how long each phase of booting a machine took.  Phases that
+// haven't completed are zero.
+type BootTimings struct {
+	// From requesting the instance until the cloud provider reports it running.
+	Instance time.Duration
+
+	// From the start of the boot script until the minion started.
+	Minion time.Duration
+
+	// Pulling the images of the minion's system containers.
+	ImagePull time.Duration
+
+	// From the minion starting until it ran its first container.
+	FirstContainer time.Duration
+}
+
+// Total returns the time from requesting the instance until its first container ran,
+// or as much of it as has completed.  Image pulls overlap the other phases, and so
+// aren't counted separately.
+func (bt BootTimings) Total() time.Duration {
+	return bt.Instance + bt.Minion + bt.FirstContainer
 }
 
 // InsertMachine creates a new Machine and inserts it into 'db'.
@@ -57,6 +134,31 @@ func (cn Conn) SelectFromMachine(check func(Machine) bool) []Machine {
 	return machines
 }
 
+// AllowsSize reports whether the machine may be booted with the given size.
+func (m Machine) AllowsSize(size string) bool {
+	if len(m.Sizes) == 0 {
+		return size == m.Size
+	}
+
+	for _, s := range m.Sizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// NextSize returns the size to try if the provider is out of capacity for Size.  After
+// the last of Sizes, it wraps around to the first, as capacity may have freed up.
+func (m Machine) NextSize() string {
+	for i, s := range m.Sizes {
+		if s == m.Size {
+			return m.Sizes[(i+1)%len(m.Sizes)]
+		}
+	}
+	return m.Size
+}
+
 func (m Machine) getID() int {
 	return m.ID
 }
@@ -82,10 +184,42 @@ func (m Machine) String() string {
 		tags = append(tags, "PrivateIP="+m.PrivateIP)
 	}
 
+	if m.Arch != "" {
+		tags = append(tags, "Arch="+m.Arch)
+	}
+
 	if m.DiskSize != 0 {
 		tags = append(tags, fmt.Sprintf("Disk=%dGB", m.DiskSize))
 	}
 
+	if m.SpotPrice != 0 {
+		tags = append(tags, fmt.Sprintf("SpotPrice=%g", m.SpotPrice))
+	}
+
+	if m.DedicatedTo != "" {
+		tags = append(tags, "DedicatedTo="+m.DedicatedTo)
+	}
+
+	if m.FloatingIP != "" {
+		tags = append(tags, "FloatingIP="+m.FloatingIP)
+	}
+
+	if m.NetworkTier != "" {
+		tags = append(tags, "NetworkTier="+m.NetworkTier)
+	}
+
+	if m.DisableNAT {
+		tags = append(tags, "DisableNAT")
+	}
+
+	if m.Failed {
+		tags = append(tags, "Failed")
+	}
+
+	if m.Draining {
+		tags = append(tags, "Draining")
+	}
+
 	if m.Connected {
 		tags = append(tags, "Connected")
 	}
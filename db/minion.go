@@ -1,6 +1,9 @@
 package db
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 // The Minion table is instantiated on the minions with one row.  That row contains the
 // configuration that minion needs to operate, including its ID, Role, and IP address
@@ -13,12 +16,74 @@ type Minion struct {
 	AuthorizedKeys string `json:"-" rowStringer:"omit"`
 	SupervisorInit bool   `json:"-"`
 
+	// If set, the minion doesn't NAT its containers' traffic, even if it's a
+	// worker.
+	DisableNAT bool `json:"-"`
+
+	// When the minion started, and how long the phases of its boot took.
+	Started     time.Time   `json:"-" rowStringer:"omit"`
+	BootTimings BootTimings `json:"-" rowStringer:"omit"`
+
+	// When the minion lost contact with its Docker daemon, or the zero time if
+	// the daemon is reachable.  Containers are left alone during the outage.
+	DockerUnavailableSince time.Time `json:"-" rowStringer:"omit"`
+
+	// The health of the system containers run by the supervisor, keyed by
+	// container name.
+	SupervisorStatus map[string]ComponentStatus `json:"-" rowStringer:"omit"`
+
+	// The containers a worker is tearing down because they're no longer declared.
+	// The leader collects those of every worker.
+	Orphans []Container `json:"-" rowStringer:"omit"`
+
 	// Below fields are included in the JSON encoding.
 	Role      Role
 	PrivateIP string
 	Provider  string
 	Size      string
 	Region    string
+	Arch      string // The minion's CPU architecture, as in Go's GOARCH.
+
+	// If set, only containers with this label are scheduled on the minion.
+	DedicatedTo string
+
+	FloatingIP string // The floating IP reserved for the minion, if any.
+
+	// Whether the minion is being drained.  Containers are moved off of draining
+	// minions, and none are placed on them.
+	Draining bool
+
+	// The effective values of the sysctls set by the spec's NetworkTuning, as
+	// comma separated key=value pairs.
+	Sysctls string
+
+	// Whether the minion is able to encrypt its tunnels, as it runs a working IPsec
+	// daemon.  Encryption is only enabled once every worker supports it.
+	EncryptionSupported bool
+
+	// The public halves of the keys the worker encrypts its tunnels with, by
+	// generation.  The private halves never leave the worker.
+	EncryptionKeys map[int]string `json:",omitempty" rowStringer:"omit"`
+}
+
+// The states of the system containers run by the supervisor.
+const (
+	// ComponentStarting is the state of a container that hasn't yet passed its
+	// liveness probe since it was started.
+	ComponentStarting = "starting"
+
+	// ComponentHealthy is the state of a container that passed its last probe.
+	ComponentHealthy = "healthy"
+
+	// ComponentUnhealthy is the state of a container that's failing its probes.
+	// It's restarted once it fails enough of them in a row.
+	ComponentUnhealthy = "unhealthy"
+)
+
+// ComponentStatus is the health of a system container run by the supervisor.
+type ComponentStatus struct {
+	State    string
+	Restarts int // The times the supervisor restarted it after failed probes.
 }
 
 // InsertMinion creates a new Minion and inserts it into 'db'.
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// How long the containers must all be placed before an idle autoscaled worker is
+// terminated, so that workers aren't churned while a label is being resized.
+var autoscaleCooldown = 10 * time.Minute
+
+// autoscaleTxn decides how many workers to boot from the spec's autoscale template,
+// and returns that many copies of it.  A worker is booted at a time while the
+// scheduler fails to place containers, until the count reaches the spec's Max.  Once
+// every container has been placed for autoscaleCooldown, an idle worker like the
+// template is drained, and the count drops back towards Min.  While the machines are
+// frozen, the count doesn't change.
+func autoscaleTxn(view db.Database, cluster *db.Cluster,
+	spec stitch.Stitch) []stitch.Machine {
+
+	as := spec.AutoscaleWorkers
+	count := cluster.AutoscaledWorkers
+	switch {
+	case count < as.Min:
+		count = as.Min
+	case count > as.Max:
+		count = as.Max
+	}
+
+	if !cluster.FreezeMachines && as.Max > 0 {
+		count = autoscale(view, cluster, as, spec.MaxPrice, count)
+	}
+
+	cluster.AutoscaledWorkers = count
+	var machines []stitch.Machine
+	for i := 0; i < count; i++ {
+		machines = append(machines, as.Template)
+	}
+	return machines
+}
+
+func autoscale(view db.Database, cluster *db.Cluster, as stitch.Autoscale,
+	maxPrice float64, count int) int {
+
+	now := timeNow()
+	pending := 0
+	workers := view.SelectFromMachine(func(m db.Machine) bool {
+		if m.Unscheduled > pending {
+			pending = m.Unscheduled
+		}
+		return m.Role == db.Worker && !m.Draining
+	})
+
+	if pending > 0 {
+		cluster.LastAutoscale = now
+		if count >= as.Max {
+			return count
+		}
+
+		// Workers that haven't connected yet may be able to take the
+		// containers, so wait for them before booting another.
+		for _, m := range workers {
+			if !m.Connected {
+				return count
+			}
+		}
+
+		log.WithField("unscheduled", pending).Infof(
+			"Booting autoscaled worker %d of at most %d.", count+1, as.Max)
+		return count + 1
+	}
+
+	if count <= as.Min || now.Sub(cluster.LastAutoscale) < autoscaleCooldown {
+		return count
+	}
+
+	template, ok := convertMachine(as.Template, maxPrice)
+	if !ok {
+		return count
+	}
+
+	for _, m := range workers {
+		if m.Connected && m.Containers == 0 && machineScore(template, m) >= 0 {
+			log.WithField("machine", m).Infof("Draining idle autoscaled "+
+				"worker, leaving %d of at least %d.", count-1, as.Min)
+			m.Draining = true
+			view.Commit(m)
+			cluster.LastAutoscale = now
+			return count - 1
+		}
+	}
+	return count
+}
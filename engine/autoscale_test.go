@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/NetSys/quilt/db"
+)
+
+func TestAutoscale(t *testing.T) {
+	conn := db.New()
+
+	code := `var m = new Machine({provider: "Amazon", size: "m4.large"});
+	deployment.deploy([m.asMaster(), m.asWorker()]);
+	deployment.autoscaleWorkers({min: 1, max: 3, template:
+		new Machine({provider: "Amazon", size: "m4.xlarge"})});`
+	spec := prog(t, code)
+
+	// Boots the machines, and has the minions report in.
+	update := func(unscheduled, containers int) {
+		conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+			for _, m := range view.SelectFromMachine(nil) {
+				m.CloudID = "id"
+				m.PublicIP = "1.2.3.4"
+				m.PrivateIP = "10.0.0.1"
+				m.Connected = true
+				m.Containers = containers
+				if m.Role == db.Master {
+					m.Unscheduled = unscheduled
+				}
+				view.Commit(m)
+			}
+			return nil
+		})
+		updateStitch(t, conn, spec)
+	}
+
+	checkWorkers := func(expLarge, expXLarge int) {
+		_, workers := selectMachines(conn)
+		sizes := map[string]int{}
+		for _, m := range workers {
+			sizes[m.Size]++
+		}
+		assert.Equal(t, map[string]int{"m4.large": expLarge,
+			"m4.xlarge": expXLarge}, sizes)
+	}
+
+	// The minimum is booted right away.
+	updateStitch(t, conn, spec)
+	checkWorkers(1, 1)
+
+	// While containers can't be placed, a worker is booted at a time.
+	update(4, 1)
+	checkWorkers(1, 2)
+	updateStitch(t, conn, spec)
+	checkWorkers(1, 2)
+
+	update(4, 1)
+	checkWorkers(1, 3)
+	update(4, 1)
+	checkWorkers(1, 3)
+
+	// Frozen machines aren't scaled down.
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.Now().Add(autoscaleCooldown) }
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		cluster, _ := view.GetCluster()
+		cluster.FreezeMachines = true
+		view.Commit(cluster)
+		return nil
+	})
+	update(0, 0)
+	checkWorkers(1, 3)
+
+	// Once the containers have all been placed for the cooldown, an idle worker
+	// like the template is drained.
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		cluster, _ := view.GetCluster()
+		cluster.FreezeMachines = false
+		view.Commit(cluster)
+		return nil
+	})
+	timeNow = time.Now
+	update(0, 0)
+	checkWorkers(1, 3)
+
+	timeNow = func() time.Time { return time.Now().Add(autoscaleCooldown) }
+	update(0, 0)
+	checkWorkers(1, 2)
+
+	// The next waits for another cooldown.
+	update(0, 0)
+	checkWorkers(1, 2)
+
+	timeNow = func() time.Time { return time.Now().Add(2 * autoscaleCooldown) }
+	update(0, 0)
+	checkWorkers(1, 1)
+
+	// The minimum is kept.
+	timeNow = func() time.Time { return time.Now().Add(3 * autoscaleCooldown) }
+	update(0, 0)
+	checkWorkers(1, 1)
+}
@@ -1,6 +1,9 @@
 package engine
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/NetSys/quilt/cluster"
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/join"
@@ -13,6 +16,12 @@ import (
 var myIP = util.MyIP
 var defaultDiskSize = 32
 
+// How long to wait for a machine's containers to move elsewhere before terminating
+// it anyway.
+var drainTimeout = 10 * time.Minute
+
+var timeNow = time.Now
+
 // Run updates the database in response to stitch changes in the cluster table.
 func Run(conn db.Conn) {
 	for range conn.TriggerTick(30, db.ClusterTable, db.MachineTable, db.ACLTable).C {
@@ -33,6 +42,7 @@ func updateTxn(view db.Database) error {
 	}
 
 	cluster.Namespace = stitch.Namespace
+	stitch.Machines = append(stitch.Machines, autoscaleTxn(view, &cluster, stitch)...)
 	view.Commit(cluster)
 
 	machineTxn(view, stitch)
@@ -46,11 +56,31 @@ func aclTxn(view db.Database, specHandle stitch.Stitch) {
 		aclRow = view.InsertACL()
 	}
 
+	// The cloud provider's ACL applies to every machine, so it must let anyone
+	// allowed to SSH into any of them reach port 22.  The minions further restrict
+	// SSH according to the ACL for their role.  Only the AdminACL opens the other
+	// ports.
 	aclRow.Admin = resolveACLs(specHandle.AdminACL)
 
+	var sshACLs []string
+	seen := map[string]struct{}{}
+	for _, acl := range specHandle.AdminACL {
+		seen[acl] = struct{}{}
+	}
+	for _, acls := range [][]string{specHandle.MasterACL, specHandle.WorkerACL} {
+		for _, acl := range acls {
+			if _, ok := seen[acl]; !ok {
+				seen[acl] = struct{}{}
+				sshACLs = append(sshACLs, acl)
+			}
+		}
+	}
+	aclRow.SSH = resolveACLs(sshACLs)
+
 	var applicationPorts []db.PortRange
 	for _, conn := range specHandle.Connections {
-		if conn.From == stitch.PublicInternetLabel {
+		if conn.From == stitch.PublicInternetLabel &&
+			conn.Protocol != stitch.ICMP {
 			applicationPorts = append(applicationPorts, db.PortRange{
 				MinPort: conn.MinPort,
 				MaxPort: conn.MaxPort,
@@ -71,43 +101,15 @@ func toDBMachine(machines []stitch.Machine, maxPrice float64) []db.Machine {
 	var hasMaster, hasWorker bool
 	var dbMachines []db.Machine
 	for _, stitchm := range machines {
-		var m db.Machine
-
-		role, err := db.ParseRole(stitchm.Role)
-		if err != nil {
-			log.WithError(err).Error("Error parsing role.")
-			continue
-		}
-		m.Role = role
-
-		hasMaster = hasMaster || role == db.Master
-		hasWorker = hasWorker || role == db.Worker
-
-		p, err := db.ParseProvider(stitchm.Provider)
-		if err != nil {
-			log.WithError(err).Error("Error parsing provider.")
-			continue
+		// Roles are counted even if the machine is skipped for other reasons.
+		if role, err := db.ParseRole(stitchm.Role); err == nil {
+			hasMaster = hasMaster || role == db.Master
+			hasWorker = hasWorker || role == db.Worker
 		}
-		m.Provider = p
-		m.Size = stitchm.Size
 
-		if m.Size == "" {
-			m.Size = cluster.ChooseSize(p, stitchm.RAM, stitchm.CPU,
-				maxPrice)
-			if m.Size == "" {
-				log.Errorf("No valid size for %v, skipping.", m)
-				continue
-			}
+		if m, ok := convertMachine(stitchm, maxPrice); ok {
+			dbMachines = append(dbMachines, m)
 		}
-
-		m.DiskSize = stitchm.DiskSize
-		if m.DiskSize == 0 {
-			m.DiskSize = defaultDiskSize
-		}
-
-		m.SSHKeys = stitchm.SSHKeys
-		m.Region = stitchm.Region
-		dbMachines = append(dbMachines, cluster.DefaultRegion(m))
 	}
 
 	if hasMaster && !hasWorker {
@@ -121,43 +123,136 @@ func toDBMachine(machines []stitch.Machine, maxPrice float64) []db.Machine {
 	return dbMachines
 }
 
+// convertMachine converts a single machine specified in the Stitch into a db.Machine.
+// It returns false if the machine is invalid, and should be skipped.
+func convertMachine(stitchm stitch.Machine, maxPrice float64) (db.Machine, bool) {
+	var m db.Machine
+
+	role, err := db.ParseRole(stitchm.Role)
+	if err != nil {
+		log.WithError(err).Error("Error parsing role.")
+		return db.Machine{}, false
+	}
+	m.Role = role
+	m.DedicatedTo = stitchm.DedicatedTo
+	m.FloatingIP = stitchm.FloatingIP
+	m.DisableNAT = stitchm.DisableNAT
+	m.NetworkTier = stitchm.NetworkTier
+
+	p, err := db.ParseProvider(stitchm.Provider)
+	if err != nil {
+		log.WithError(err).Error("Error parsing provider.")
+		return db.Machine{}, false
+	}
+	m.Provider = p
+
+	// Static machines already exist, so there's nothing to choose.
+	if p == db.Static {
+		m.PublicIP = stitchm.PublicIP
+		m.PrivateIP = stitchm.PrivateIP
+		m.SSHKeyPath = stitchm.SSHKeyPath
+		m.SSHKeys = stitchm.SSHKeys
+		m.Arch = stitchm.Arch
+		return m, true
+	}
+
+	m.Size = stitchm.Size
+	if m.Size == "" {
+		m.Size = cluster.ChooseSize(p, stitchm.RAM, stitchm.CPU, maxPrice,
+			stitchm.NetworkTier)
+		if m.Size == "" {
+			log.Errorf("No valid size for %v, skipping.", m)
+			return db.Machine{}, false
+		}
+	}
+
+	m.Arch = cluster.SizeArch(p, m.Size)
+	if stitchm.Arch != "" && m.Arch != "" && stitchm.Arch != m.Arch {
+		log.Errorf("Size %s is %s, not %s, skipping.", m.Size, m.Arch,
+			stitchm.Arch)
+		return db.Machine{}, false
+	} else if m.Arch == "" {
+		m.Arch = stitchm.Arch
+	}
+
+	if m.NetworkTier != "" && !cluster.SizeHasNetworkTier(p, m.Size, m.NetworkTier) {
+		log.Errorf("Size %s has no %s network tier, skipping.", m.Size,
+			m.NetworkTier)
+		return db.Machine{}, false
+	}
+
+	m.DiskSize = stitchm.DiskSize
+	if m.DiskSize == 0 {
+		m.DiskSize = defaultDiskSize
+	}
+
+	m.SSHKeys = stitchm.SSHKeys
+	m.SpotPrice = stitchm.SpotPrice
+	m.Region = stitchm.Region
+	m = cluster.DefaultRegion(m)
+
+	if len(stitchm.SizeFallbacks) != 0 {
+		m.Sizes = append([]string{m.Size}, stitchm.SizeFallbacks...)
+		if err := checkFallbacks(m, maxPrice); err != nil {
+			log.WithError(err).Errorf("Bad size fallbacks for %v, "+
+				"skipping.", m)
+			return db.Machine{}, false
+		}
+	}
+	return m, true
+}
+
+// checkFallbacks verifies that each of the machine's fallback sizes could stand in for
+// its first choice.  They must share its architecture and network tier, and even the
+// most expensive must fit within `maxPrice`.
+func checkFallbacks(m db.Machine, maxPrice float64) error {
+	for _, size := range m.Sizes[1:] {
+		arch := cluster.SizeArch(m.Provider, size)
+		if arch != "" && m.Arch != "" && arch != m.Arch {
+			return fmt.Errorf("size %s is %s, not %s", size, arch, m.Arch)
+		}
+
+		if m.NetworkTier != "" &&
+			!cluster.SizeHasNetworkTier(m.Provider, size, m.NetworkTier) {
+			return fmt.Errorf("size %s has no %s network tier", size,
+				m.NetworkTier)
+		}
+
+		price := cluster.SizePrice(m.Provider, m.Region, size)
+		if maxPrice != 0 && price > maxPrice {
+			return fmt.Errorf("size %s costs %g, more than the max price %g",
+				size, price, maxPrice)
+		}
+	}
+	return nil
+}
+
 func machineTxn(view db.Database, stitch stitch.Stitch) {
 	// XXX: How best to deal with machines that don't specify enough information?
 	maxPrice := stitch.MaxPrice
 	stitchMachines := toDBMachine(stitch.Machines, maxPrice)
 
-	dbMachines := view.SelectFromMachine(nil)
+	// Draining machines are on their way out, so they can't stand in for the
+	// Stitch's.
+	dbMachines := view.SelectFromMachine(func(m db.Machine) bool {
+		return !m.Draining
+	})
 
 	scoreFun := func(left, right interface{}) int {
-		stitchMachine := left.(db.Machine)
-		dbMachine := right.(db.Machine)
-
-		switch {
-		case dbMachine.Provider != stitchMachine.Provider:
-			return -1
-		case dbMachine.Region != stitchMachine.Region:
-			return -1
-		case dbMachine.Size != "" && stitchMachine.Size != dbMachine.Size:
-			return -1
-		case dbMachine.Role != db.None && dbMachine.Role != stitchMachine.Role:
-			return -1
-		case dbMachine.DiskSize != stitchMachine.DiskSize:
-			return -1
-		case dbMachine.PrivateIP == "":
-			return 2
-		case dbMachine.PublicIP == "":
-			return 1
-		default:
-			return 0
-		}
+		return machineScore(left.(db.Machine), right.(db.Machine))
 	}
 
 	pairs, bootList, terminateList := join.Join(stitchMachines, dbMachines, scoreFun)
+	bootList, terminateList = deferReplacements(view, bootList, terminateList,
+		stitch.MaintenanceWindow)
 
+	// Machines are drained of their containers before they're terminated.
 	for _, toTerminate := range terminateList {
 		toTerminate := toTerminate.(db.Machine)
-		view.Remove(toTerminate)
+		toTerminate.Draining = true
+		view.Commit(toTerminate)
 	}
+	drainTxn(view)
 
 	for _, bootSet := range bootList {
 		bootSet := bootSet.(db.Machine)
@@ -170,13 +265,120 @@ func machineTxn(view db.Database, stitch stitch.Stitch) {
 		dbMachine := pair.R.(db.Machine)
 
 		dbMachine.Role = stitchMachine.Role
-		dbMachine.Size = stitchMachine.Size
+		dbMachine.Sizes = stitchMachine.Sizes
+		if !stitchMachine.AllowsSize(dbMachine.Size) {
+			// Keep a fallback size rather than churning the machine.
+			dbMachine.Size = stitchMachine.Size
+		}
+		dbMachine.Arch = stitchMachine.Arch
 		dbMachine.DiskSize = stitchMachine.DiskSize
 		dbMachine.Provider = stitchMachine.Provider
 		dbMachine.Region = stitchMachine.Region
 		dbMachine.SSHKeys = stitchMachine.SSHKeys
+		dbMachine.SpotPrice = stitchMachine.SpotPrice
+		dbMachine.SSHKeyPath = stitchMachine.SSHKeyPath
+		dbMachine.DedicatedTo = stitchMachine.DedicatedTo
+		dbMachine.FloatingIP = stitchMachine.FloatingIP
+		dbMachine.DisableNAT = stitchMachine.DisableNAT
+		dbMachine.NetworkTier = stitchMachine.NetworkTier
+		if stitchMachine.Provider == db.Static {
+			dbMachine.PublicIP = stitchMachine.PublicIP
+			dbMachine.PrivateIP = stitchMachine.PrivateIP
+		}
+		dbMachine.ReplaceAfter = time.Time{}
+		view.Commit(dbMachine)
+	}
+}
+
+// machineScore rates how well `dbMachine` stands in for `stitchMachine`.  Negative
+// scores can't stand in at all, and lower scores are better.
+func machineScore(stitchMachine, dbMachine db.Machine) int {
+	switch {
+	case dbMachine.Provider != stitchMachine.Provider:
+		return -1
+	case dbMachine.Provider == db.Static &&
+		dbMachine.PublicIP != stitchMachine.PublicIP:
+		// A static machine is a particular box, never a replacement.
+		return -1
+	case dbMachine.Region != stitchMachine.Region:
+		return -1
+	case dbMachine.Size != "" && !stitchMachine.AllowsSize(dbMachine.Size):
+		return -1
+	case dbMachine.Role != db.None && dbMachine.Role != stitchMachine.Role:
+		return -1
+	case dbMachine.DiskSize != stitchMachine.DiskSize:
+		return -1
+	case dbMachine.NetworkTier != stitchMachine.NetworkTier:
+		return -1
+	case dbMachine.PrivateIP == "":
+		return 2
+	case dbMachine.PublicIP == "":
+		return 1
+	default:
+		return 0
+	}
+}
+
+// deferReplacements keeps the running machines that would be replaced by a machine
+// of the same role, unless the maintenance window is open.  Neither the replacement
+// is booted nor the old machine terminated until it opens, which is recorded in the
+// machine's ReplaceAfter.  Machines whose minions aren't connected may be dead, so
+// they're replaced right away, as are machines that aren't being replaced at all.
+func deferReplacements(view db.Database, bootList, terminateList []interface{},
+	window stitch.MaintenanceWindow) (boot, terminate []interface{}) {
+
+	now := timeNow()
+	if window.Open(now) {
+		return bootList, terminateList
+	}
+
+	for _, tt := range terminateList {
+		dbMachine := tt.(db.Machine)
+
+		replacement := -1
+		for i, b := range bootList {
+			if b.(db.Machine).Role == dbMachine.Role {
+				replacement = i
+				break
+			}
+		}
+
+		if !dbMachine.Connected || replacement < 0 {
+			terminate = append(terminate, dbMachine)
+			continue
+		}
+
+		bootList = append(bootList[:replacement], bootList[replacement+1:]...)
+		dbMachine.ReplaceAfter = window.NextOpen(now)
 		view.Commit(dbMachine)
 	}
+	return bootList, terminate
+}
+
+// drainTxn terminates draining machines once the scheduler has moved their containers
+// elsewhere, or once they've been draining for drainTimeout.  Machines whose minions
+// aren't connected can't be drained, so they're terminated right away.
+func drainTxn(view db.Database) {
+	now := timeNow()
+	draining := view.SelectFromMachine(func(m db.Machine) bool {
+		return m.Draining
+	})
+	for _, m := range draining {
+		if m.DrainStart.IsZero() {
+			m.DrainStart = now
+			view.Commit(m)
+		}
+
+		switch {
+		case !m.Connected || m.Containers == 0:
+		case now.Sub(m.DrainStart) >= drainTimeout:
+			log.WithField("machine", m).Warningf("Timed out draining machine "+
+				"with %d containers.", m.Containers)
+		default:
+			continue
+		}
+		view.Remove(m)
+	}
 }
 
 func resolveACLs(acls []string) []string {
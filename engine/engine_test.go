@@ -3,7 +3,9 @@ package engine
 import (
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/NetSys/quilt/cluster"
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/join"
 	"github.com/NetSys/quilt/stitch"
@@ -197,6 +199,299 @@ func TestSort(t *testing.T) {
 	})
 }
 
+func TestMachineArch(t *testing.T) {
+	machines := toDBMachine([]stitch.Machine{
+		{Provider: "Amazon", Role: "Master", Size: "m4.large"},
+		{Provider: "Amazon", Role: "Worker", Size: "a1.large"},
+		{Provider: "Amazon", Role: "Worker", Size: "m4.large", Arch: "arm64"},
+		{Provider: "Vagrant", Role: "Worker", Size: "1,1", Arch: "arm64"},
+	}, 0)
+
+	// The machine whose size contradicts its architecture is skipped.
+	var archs []string
+	for _, m := range machines {
+		archs = append(archs, m.Arch)
+	}
+	assert.Equal(t, []string{"amd64", "arm64", "arm64"}, archs)
+}
+
+func TestMachineNetworkTier(t *testing.T) {
+	enhanced := stitch.NetworkTierEnhanced
+	machines := toDBMachine([]stitch.Machine{
+		{Provider: "Amazon", Role: "Master", Size: "m4.large"},
+		{Provider: "Amazon", Role: "Worker", Size: "c4.large",
+			NetworkTier: enhanced},
+		{Provider: "Amazon", Role: "Worker", Size: "m3.medium",
+			NetworkTier: enhanced},
+		{Provider: "Amazon", Role: "Worker", Size: "c4.large",
+			SizeFallbacks: []string{"g2.2xlarge"}, NetworkTier: enhanced},
+		{Provider: "Amazon", Role: "Worker", RAM: stitch.Range{Min: 1},
+			NetworkTier: enhanced},
+	}, 0)
+
+	// Machines whose sizes lack the network tier are skipped.
+	assert.Len(t, machines, 3)
+	assert.Equal(t, "c4.large", machines[1].Size)
+	assert.True(t, cluster.SizeHasNetworkTier(db.Amazon, machines[2].Size,
+		enhanced))
+}
+
+func TestSizeFallbacks(t *testing.T) {
+	machines := toDBMachine([]stitch.Machine{
+		{Provider: "Amazon", Role: "Master", Size: "m4.large"},
+		{Provider: "Amazon", Role: "Worker", Size: "m4.large",
+			SizeFallbacks: []string{"m4.xlarge"}},
+		{Provider: "Amazon", Role: "Worker", Size: "m4.large",
+			SizeFallbacks: []string{"a1.large"}},
+	}, 0)
+	assert.Len(t, machines, 2)
+	assert.Nil(t, machines[0].Sizes)
+	assert.Equal(t, []string{"m4.large", "m4.xlarge"}, machines[1].Sizes)
+
+	// The worst case fallback must fit within the max price.
+	machines = toDBMachine([]stitch.Machine{
+		{Provider: "Amazon", Role: "Master", Size: "m4.large"},
+		{Provider: "Amazon", Role: "Worker", Size: "m4.large",
+			SizeFallbacks: []string{"m4.xlarge"}},
+	}, 0.2)
+	assert.Len(t, machines, 1)
+	assert.Equal(t, db.Role(db.Master), machines[0].Role)
+
+	conn := db.New()
+	code := `var m = new Machine({provider: "Amazon", size: "m4.large"});
+	deployment.deploy([m.asMaster(),
+		m.asWorker().withSizeFallbacks(["m4.xlarge"])]);`
+	updateStitch(t, conn, prog(t, code))
+	_, workers := selectMachines(conn)
+	assert.Len(t, workers, 1)
+	assert.Equal(t, "m4.large", workers[0].Size)
+
+	// A worker booted with a fallback size isn't replaced.
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		workers[0].Size = "m4.xlarge"
+		workers[0].CloudID = "sir-1"
+		view.Commit(workers[0])
+		return nil
+	})
+	updateStitch(t, conn, prog(t, code))
+	_, fallbacks := selectMachines(conn)
+	assert.Len(t, fallbacks, 1)
+	assert.Equal(t, workers[0].ID, fallbacks[0].ID)
+	assert.Equal(t, "m4.xlarge", fallbacks[0].Size)
+}
+
+func TestStaticMachines(t *testing.T) {
+	conn := db.New()
+
+	machine := func(ip string) string {
+		return `Machine.static({role: "Worker", publicIP: "` + ip +
+			`", privateIP: "10.0.0.1", sshKeyPath: "~/.ssh/id_rsa"})`
+	}
+	code := `deployment.deploy([new Machine({provider: "Amazon", role: "Master"}),` +
+		machine("1.1.1.1") + "," + machine("2.2.2.2") + "]);"
+	updateStitch(t, conn, prog(t, code))
+
+	_, workers := selectMachines(conn)
+	assert.Len(t, workers, 2)
+	for _, m := range workers {
+		assert.Equal(t, db.Provider(db.Static), m.Provider)
+		assert.Equal(t, "", m.Size)
+		assert.Equal(t, "10.0.0.1", m.PrivateIP)
+		assert.Equal(t, "~/.ssh/id_rsa", m.SSHKeyPath)
+	}
+
+	// Static machines are matched by address, so the machine that's no longer in
+	// the spec is removed rather than repurposed for the new one.
+	code = `deployment.deploy([new Machine({provider: "Amazon", role: "Master"}),` +
+		machine("1.1.1.1") + "," + machine("3.3.3.3") + "]);"
+	updateStitch(t, conn, prog(t, code))
+
+	_, newWorkers := selectMachines(conn)
+	ips := map[string]int{}
+	for _, m := range newWorkers {
+		ips[m.PublicIP] = m.ID
+	}
+	assert.Len(t, ips, 2)
+	assert.Contains(t, ips, "3.3.3.3")
+	for _, m := range workers {
+		if m.PublicIP == "1.1.1.1" {
+			assert.Equal(t, m.ID, ips["1.1.1.1"])
+		} else {
+			assert.NotEqual(t, m.ID, ips["3.3.3.3"])
+		}
+	}
+}
+
+func TestDedicatedMachines(t *testing.T) {
+	conn := db.New()
+
+	code := `var m = new Machine({provider: "Amazon", size: "m4.large"});
+	deployment.deploy([m.asMaster(), m.asWorker()]);`
+	updateStitch(t, conn, prog(t, code))
+	_, workers := selectMachines(conn)
+	assert.Len(t, workers, 1)
+	assert.Equal(t, "", workers[0].DedicatedTo)
+
+	// Dedicating a machine doesn't require a new one.
+	code = `var m = new Machine({provider: "Amazon", size: "m4.large"});
+	deployment.deploy([m.asMaster(), m.asWorker().dedicateTo("database")]);`
+	updateStitch(t, conn, prog(t, code))
+	_, dedicated := selectMachines(conn)
+	assert.Len(t, dedicated, 1)
+	assert.Equal(t, workers[0].ID, dedicated[0].ID)
+	assert.Equal(t, "database", dedicated[0].DedicatedTo)
+
+	// Neither does disabling its NAT.
+	code = `var m = new Machine({provider: "Amazon", size: "m4.large"});
+	deployment.deploy([m.asMaster(),
+		new Machine({provider: "Amazon", size: "m4.large", disableNAT: true})
+			.asWorker()]);`
+	updateStitch(t, conn, prog(t, code))
+	_, bastions := selectMachines(conn)
+	assert.Len(t, bastions, 1)
+	assert.Equal(t, workers[0].ID, bastions[0].ID)
+	assert.True(t, bastions[0].DisableNAT)
+}
+
+func TestDrain(t *testing.T) {
+	conn := db.New()
+
+	code := `var m = new Machine({provider: "Amazon", size: "m4.large"});
+	deployment.deploy([m.asMaster()].concat(m.asWorker().replicate(2)));`
+	updateStitch(t, conn, prog(t, code))
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		for _, m := range view.SelectFromMachine(nil) {
+			m.CloudID = "id"
+			m.PublicIP = "1.2.3.4"
+			m.PrivateIP = "10.0.0.1"
+			m.Connected = true
+			m.Containers = 2
+			view.Commit(m)
+		}
+		return nil
+	})
+
+	draining := func() (drained, running []db.Machine) {
+		_, workers := selectMachines(conn)
+		for _, m := range workers {
+			if m.Draining {
+				drained = append(drained, m)
+			} else {
+				running = append(running, m)
+			}
+		}
+		return drained, running
+	}
+
+	// Scaling down drains the extra machine rather than terminating it.
+	code = `var m = new Machine({provider: "Amazon", size: "m4.large"});
+	deployment.deploy([m.asMaster(), m.asWorker()]);`
+	updateStitch(t, conn, prog(t, code))
+	updateStitch(t, conn, prog(t, code))
+	drained, running := draining()
+	assert.Len(t, drained, 1)
+	assert.Len(t, running, 1)
+	assert.False(t, drained[0].DrainStart.IsZero())
+
+	// Once its containers have moved, it's terminated.
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		drained[0].Containers = 0
+		view.Commit(drained[0])
+		return nil
+	})
+	updateStitch(t, conn, prog(t, code))
+	drained, running = draining()
+	assert.Len(t, drained, 0)
+	assert.Len(t, running, 1)
+
+	// A machine drained by an operator is replaced, and terminated once the drain
+	// times out even if it isn't empty.
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		running[0].Draining = true
+		view.Commit(running[0])
+		return nil
+	})
+	updateStitch(t, conn, prog(t, code))
+	drained, running = draining()
+	assert.Len(t, drained, 1)
+	assert.Len(t, running, 1)
+	assert.NotEqual(t, drained[0].ID, running[0].ID)
+
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.Now().Add(drainTimeout) }
+	updateStitch(t, conn, prog(t, code))
+	drained, running = draining()
+	assert.Len(t, drained, 0)
+	assert.Len(t, running, 1)
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	conn := db.New()
+
+	// Wednesday, with the window closed until Saturday.
+	now := time.Date(2017, 6, 7, 12, 0, 0, 0, time.UTC)
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return now }
+
+	code := `var m = new Machine({provider: "Amazon", size: "m4.large"});
+	deployment.maintenanceWindow({days: ["Sat"], start: "02:00", end: "04:00"});
+	deployment.deploy([m.asMaster()].concat(m.asWorker().replicate(2)));`
+	updateStitch(t, conn, prog(t, code))
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		for i, m := range db.SortMachines(view.SelectFromMachine(nil)) {
+			m.CloudID = "id"
+			m.PublicIP = "1.2.3.4"
+			m.PrivateIP = "10.0.0.1"
+			m.Containers = 2
+
+			// The last worker's minion is unreachable.
+			m.Connected = i < 2
+			view.Commit(m)
+		}
+		return nil
+	})
+
+	// Booting machines isn't disruptive, so the window didn't delay it.
+	_, workers := selectMachines(conn)
+	assert.Len(t, workers, 2)
+
+	// Changing the workers' disks would replace them.  The connected worker is
+	// kept until the window opens, but the other may be dead, so it's replaced.
+	code = `var m = new Machine({provider: "Amazon", size: "m4.large",
+		diskSize: 64});
+	deployment.maintenanceWindow({days: ["Sat"], start: "02:00", end: "04:00"});
+	deployment.deploy([m.asMaster()].concat(m.asWorker().replicate(2)));`
+	updateStitch(t, conn, prog(t, code))
+	_, workers = selectMachines(conn)
+
+	var deferred, booted []db.Machine
+	for _, m := range workers {
+		switch {
+		case m.Draining:
+			t.Errorf("unexpected draining machine: %v", m)
+		case m.DiskSize == 64:
+			booted = append(booted, m)
+		default:
+			deferred = append(deferred, m)
+		}
+	}
+	assert.Len(t, booted, 1)
+	if assert.Len(t, deferred, 1) {
+		assert.True(t, deferred[0].Connected)
+		assert.Equal(t, time.Date(2017, 6, 10, 2, 0, 0, 0, time.UTC),
+			deferred[0].ReplaceAfter)
+	}
+
+	// Once the window opens, it's replaced too.
+	now = time.Date(2017, 6, 10, 3, 0, 0, 0, time.UTC)
+	updateStitch(t, conn, prog(t, code))
+	_, workers = selectMachines(conn)
+	assert.Len(t, workers, 3)
+	for _, m := range workers {
+		assert.Equal(t, m.DiskSize != 64, m.Draining)
+	}
+}
+
 func TestACLs(t *testing.T) {
 	conn := db.New()
 
@@ -221,6 +516,21 @@ func TestACLs(t *testing.T) {
 	acl, err = selectACL(conn)
 	assert.Nil(t, err)
 	assert.Equal(t, []string{"1.2.3.4/32"}, acl.Admin)
+
+	// The role ACLs are only allowed through the cloud ACL to SSH.
+	code = `createDeployment({
+			adminACL: ["1.2.3.4/32"],
+			masterACL: ["8.8.8.8/32"],
+			workerACL: ["1.2.3.4/32", "9.9.9.0/24"]
+		}).deploy([
+			new Machine({provider: "Amazon", role: "Master"}),
+			new Machine({provider: "Amazon", role: "Worker"})
+		]);`
+	updateStitch(t, conn, prog(t, code))
+	acl, err = selectACL(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1.2.3.4/32"}, acl.Admin)
+	assert.Equal(t, []string{"8.8.8.8/32", "9.9.9.0/24"}, acl.SSH)
 }
 
 func prog(t *testing.T, code string) stitch.Stitch {
@@ -0,0 +1,186 @@
+package export
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+)
+
+// CloudFormation only describes Amazon resources, so the other machines are listed
+// in the template's metadata instead.
+type cfnTemplate struct {
+	AWSTemplateFormatVersion string
+	Description              string
+	Metadata                 cfnMetadata
+	Resources                map[string]cfnResource
+}
+
+type cfnMetadata struct {
+	Namespace         string
+	UnmanagedMachines []cfnMachine `json:",omitempty"`
+}
+
+// A cfnMachine records the attributes of a machine that Quilt chooses at runtime.
+type cfnMachine struct {
+	ID         int
+	Provider   db.Provider `json:",omitempty"`
+	Role       db.Role     `json:",omitempty"`
+	Region     string      `json:",omitempty"`
+	InstanceID string      `json:",omitempty"`
+	PublicIP   string      `json:",omitempty"`
+	PrivateIP  string      `json:",omitempty"`
+}
+
+type cfnResource struct {
+	Type       string
+	Properties interface{}
+	Metadata   interface{} `json:",omitempty"`
+}
+
+type cfnInstance struct {
+	InstanceType   string
+	SecurityGroups []cfnRef
+	Tags           []cfnTag
+}
+
+type cfnSecurityGroup struct {
+	GroupDescription     string
+	SecurityGroupIngress []cfnIngress
+	Tags                 []cfnTag
+}
+
+type cfnIngress struct {
+	Description string
+	IPProtocol  string `json:"IpProtocol"`
+	FromPort    int
+	ToPort      int
+	CidrIP      string `json:"CidrIp"`
+}
+
+type cfnGroupIngress struct {
+	GroupID               cfnRef `json:"GroupId"`
+	SourceSecurityGroupID cfnRef `json:"SourceSecurityGroupId"`
+	IPProtocol            string `json:"IpProtocol"`
+	Description           string
+}
+
+type cfnRef struct {
+	Ref string
+}
+
+type cfnTag struct {
+	Key   string
+	Value string
+}
+
+// cloudFormation renders the deployment as a CloudFormation template.
+func cloudFormation(spec stitch.Stitch, machines []db.Machine) (string, error) {
+	machines = sortedMachines(machines)
+	rules := firewallRules(spec, machines)
+
+	tmpl := cfnTemplate{
+		AWSTemplateFormatVersion: "2010-09-09",
+		Description:              notice,
+		Metadata:                 cfnMetadata{Namespace: spec.Namespace},
+		Resources:                map[string]cfnResource{},
+	}
+
+	var ingress []cfnIngress
+	for _, r := range rules {
+		for _, protocol := range []string{"tcp", "udp", "icmp"} {
+			minPort, maxPort := r.minPort, r.maxPort
+			if protocol == "icmp" {
+				minPort, maxPort = -1, -1
+			}
+			ingress = append(ingress, cfnIngress{
+				Description: r.description,
+				IPProtocol:  protocol,
+				FromPort:    minPort,
+				ToPort:      maxPort,
+				CidrIP:      r.cidr,
+			})
+		}
+	}
+
+	for _, m := range machines {
+		info := cfnMachine{
+			ID:         m.ID,
+			Region:     m.Region,
+			InstanceID: m.CloudID,
+			PublicIP:   m.PublicIP,
+			PrivateIP:  m.PrivateIP,
+		}
+
+		if m.Provider != db.Amazon {
+			info.Provider = m.Provider
+			info.Role = m.Role
+			tmpl.Metadata.UnmanagedMachines = append(
+				tmpl.Metadata.UnmanagedMachines, info)
+			continue
+		}
+
+		// CloudFormation templates are regional, so each region has its own
+		// security group, as in the Amazon provider.
+		group := cfnName("quilt", m.Region)
+		if _, ok := tmpl.Resources[group]; !ok {
+			tmpl.Resources[group] = cfnResource{
+				Type: "AWS::EC2::SecurityGroup",
+				Properties: cfnSecurityGroup{
+					GroupDescription:     "Quilt Group",
+					SecurityGroupIngress: ingress,
+					Tags: []cfnTag{{"quilt-namespace",
+						spec.Namespace}},
+				},
+				Metadata: map[string]string{"Region": m.Region},
+			}
+			tmpl.Resources[group+"Internal"] = cfnResource{
+				Type: "AWS::EC2::SecurityGroupIngress",
+				Properties: cfnGroupIngress{
+					GroupID:               cfnRef{group},
+					SourceSecurityGroupID: cfnRef{group},
+					IPProtocol:            "-1",
+					Description:           "Other Quilt machines",
+				},
+			}
+		}
+
+		var cfnTags []cfnTag
+		for _, tag := range tags(spec.Namespace, m) {
+			cfnTags = append(cfnTags, cfnTag{tag[0], tag[1]})
+		}
+
+		tmpl.Resources[cfnName(resourceName(m))] = cfnResource{
+			Type: "AWS::EC2::Instance",
+			Properties: cfnInstance{
+				InstanceType:   m.Size,
+				SecurityGroups: []cfnRef{{group}},
+				Tags:           cfnTags,
+			},
+			Metadata: info,
+		}
+	}
+
+	out, err := json.MarshalIndent(tmpl, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out) + "\n", nil
+}
+
+// cfnName converts `words` into a CloudFormation logical ID, which must be
+// alphanumeric, e.g. "quilt" and "us-west-1" become "QuiltUsWest1".
+func cfnName(words ...string) string {
+	var name string
+	for _, word := range words {
+		for _, part := range strings.FieldsFunc(word, isSeparator) {
+			name += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return name
+}
+
+func isSeparator(r rune) bool {
+	return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+}
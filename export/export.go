@@ -0,0 +1,131 @@
+// Package export renders a deployment as Terraform or CloudFormation documents, for
+// auditors who review infrastructure in those formats.  The documents describe the
+// machines Quilt manages and the firewall rules it installs for them.  They're meant
+// to be read and diffed, and are NOT meant to be applied: Quilt owns the resources
+// they describe, and would fight any other tool managing them.
+package export
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+)
+
+// The formats a deployment may be exported to.
+const (
+	Terraform      = "terraform"
+	CloudFormation = "cloudformation"
+)
+
+// The notice at the top of every exported document.
+const notice = "Generated by `quilt export` for review only.  Quilt manages these " +
+	"resources, so this document must not be applied."
+
+// Export renders the deployment described by `spec` and `machines` in `format`.
+func Export(format string, spec stitch.Stitch, machines []db.Machine) (string, error) {
+	switch format {
+	case Terraform:
+		return terraform(spec, machines), nil
+	case CloudFormation:
+		return cloudFormation(spec, machines)
+	default:
+		return "", fmt.Errorf("unknown export format: %s", format)
+	}
+}
+
+// A rule admits traffic from `cidr` to the ports `minPort` through `maxPort`.  The
+// cloud firewalls don't distinguish protocols, so each rule admits TCP, UDP, and
+// ICMP, just as Quilt installs them.
+type rule struct {
+	cidr        string
+	minPort     int
+	maxPort     int
+	description string
+}
+
+// firewallRules returns the rules Quilt installs in the cloud firewalls, in the same
+// way as the engine and cluster packages.
+func firewallRules(spec stitch.Stitch, machines []db.Machine) []rule {
+	var rules []rule
+	seen := map[rule]struct{}{}
+	add := func(r rule) {
+		if _, ok := seen[r]; !ok {
+			seen[r] = struct{}{}
+			rules = append(rules, r)
+		}
+	}
+
+	for _, acls := range [][]string{spec.AdminACL, spec.MasterACL, spec.WorkerACL} {
+		for _, acl := range acls {
+			// The "local" ACL is resolved by the daemon to its own address,
+			// which isn't known here.
+			description := "Admin ACL"
+			if acl == "local" {
+				description = "The Quilt daemon's address"
+			}
+			add(rule{acl, 1, 65535, description})
+		}
+	}
+
+	for _, c := range spec.Connections {
+		if c.From == stitch.PublicInternetLabel && c.Protocol != stitch.ICMP {
+			add(rule{"0.0.0.0/0", c.MinPort, c.MaxPort,
+				"Public connection to " + c.To})
+		}
+	}
+
+	for _, m := range sortedMachines(machines) {
+		if m.PublicIP != "" {
+			add(rule{m.PublicIP + "/32", 1, 65535,
+				fmt.Sprintf("Quilt machine %d", m.ID)})
+		}
+	}
+	return rules
+}
+
+// tags returns the tags describing `m`, sorted by key.
+func tags(namespace string, m db.Machine) [][2]string {
+	tags := [][2]string{
+		{"quilt-id", fmt.Sprintf("%d", m.ID)},
+		{"quilt-namespace", namespace},
+	}
+	if m.Role != db.None {
+		tags = append(tags, [2]string{"quilt-role", string(m.Role)})
+	}
+	if m.DedicatedTo != "" {
+		tags = append(tags, [2]string{"quilt-dedicated-to", m.DedicatedTo})
+	}
+	sort.Sort(tagSlice(tags))
+	return tags
+}
+
+type tagSlice [][2]string
+
+func (tags tagSlice) Len() int           { return len(tags) }
+func (tags tagSlice) Swap(i, j int)      { tags[i], tags[j] = tags[j], tags[i] }
+func (tags tagSlice) Less(i, j int) bool { return tags[i][0] < tags[j][0] }
+
+// resourceName returns the name of the resource describing `m`.
+func resourceName(m db.Machine) string {
+	role := strings.ToLower(string(m.Role))
+	if role == "" {
+		role = "machine"
+	}
+	return fmt.Sprintf("%s_%d", role, m.ID)
+}
+
+// sortedMachines returns `machines` sorted by ID, so that exports are stable.
+func sortedMachines(machines []db.Machine) []db.Machine {
+	sorted := append([]db.Machine{}, machines...)
+	sort.Sort(machineSlice(sorted))
+	return sorted
+}
+
+type machineSlice []db.Machine
+
+func (ms machineSlice) Len() int           { return len(ms) }
+func (ms machineSlice) Swap(i, j int)      { ms[i], ms[j] = ms[j], ms[i] }
+func (ms machineSlice) Less(i, j int) bool { return ms[i].ID < ms[j].ID }
@@ -0,0 +1,109 @@
+package export
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden export files")
+
+var testSpec = stitch.Stitch{
+	Namespace: "audit",
+	AdminACL:  []string{"1.2.3.4/32", "local"},
+	WorkerACL: []string{"1.2.3.4/32"},
+	Connections: []stitch.Connection{
+		{From: "public", To: "web", MinPort: 80, MaxPort: 80},
+		{From: "public", To: "web", MinPort: 443, MaxPort: 443, Protocol: "tcp"},
+		{From: "public", To: "web", MinPort: 0, MaxPort: 0, Protocol: "icmp"},
+		{From: "web", To: "db", MinPort: 5432, MaxPort: 5432},
+	},
+}
+
+// The machines of each provider, keyed by the name of their golden files.
+var testMachines = map[string][]db.Machine{
+	"amazon": {
+		{ID: 2, Role: db.Worker, Provider: db.Amazon, Region: "us-west-1",
+			Size: "m4.large", CloudID: "sir-2", PublicIP: "8.8.8.9",
+			PrivateIP: "10.0.0.2", DedicatedTo: "db"},
+		{ID: 1, Role: db.Master, Provider: db.Amazon, Region: "us-west-1",
+			Size: "m4.large", CloudID: "sir-1", PublicIP: "8.8.8.8",
+			PrivateIP: "10.0.0.1"},
+		{ID: 3, Role: db.Worker, Provider: db.Amazon, Region: "us-east-1",
+			Size: "m4.xlarge"},
+	},
+	"google": {
+		{ID: 1, Role: db.Master, Provider: db.Google, Region: "us-east1-b",
+			Size: "n1-standard-1", CloudID: "quilt-1", PublicIP: "8.8.8.8",
+			PrivateIP: "10.0.0.1"},
+		{ID: 2, Role: db.Worker, Provider: db.Google, Region: "us-east1-b",
+			Size: "n1-standard-1", CloudID: "quilt-2", PublicIP: "8.8.8.9",
+			PrivateIP: "10.0.0.2"},
+	},
+	"static": {
+		{ID: 1, Role: db.Master, Provider: db.Static, PublicIP: "8.8.8.8",
+			PrivateIP: "10.0.0.1"},
+		{ID: 2, Role: db.Worker, Provider: db.Vagrant, PublicIP: "8.8.8.9",
+			PrivateIP: "10.0.0.2"},
+	},
+}
+
+func TestGoldenExports(t *testing.T) {
+	for name, machines := range testMachines {
+		for format, ext := range map[string]string{
+			Terraform:      ".tf",
+			CloudFormation: ".json",
+		} {
+			out, err := Export(format, testSpec, machines)
+			if err != nil {
+				t.Errorf("Failed to export %s: %s", name, err)
+				continue
+			}
+			checkGolden(t, filepath.Join("testdata", name+ext), out)
+		}
+	}
+}
+
+func checkGolden(t *testing.T, goldenPath, out string) {
+	if *updateGolden {
+		if err := ioutil.WriteFile(goldenPath, []byte(out), 0644); err != nil {
+			t.Fatalf("Failed to write golden file: %s", err)
+		}
+	}
+
+	golden, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("Failed to read golden file: %s", err)
+	}
+
+	if out != string(golden) {
+		t.Errorf("Export doesn't match %s.  Rerun with -update if the change "+
+			"is intended.", goldenPath)
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	t.Parallel()
+
+	_, err := Export("pulumi", testSpec, nil)
+	assert.EqualError(t, err, "unknown export format: pulumi")
+}
+
+func TestFirewallRules(t *testing.T) {
+	t.Parallel()
+
+	// The admin ACLs of each role are merged, and ICMP connections open no ports,
+	// just as in the engine.
+	assert.Equal(t, []rule{
+		{"1.2.3.4/32", 1, 65535, "Admin ACL"},
+		{"local", 1, 65535, "The Quilt daemon's address"},
+		{"0.0.0.0/0", 80, 80, "Public connection to web"},
+		{"0.0.0.0/0", 443, 443, "Public connection to web"},
+		{"8.8.8.8/32", 1, 65535, "Quilt machine 1"},
+	}, firewallRules(testSpec, []db.Machine{{ID: 1, PublicIP: "8.8.8.8"}, {ID: 2}}))
+}
@@ -0,0 +1,216 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+)
+
+// terraform renders the deployment as HCL.  Attributes that Quilt chooses at runtime,
+// such as the machines' addresses, are written as they appear in Terraform state.
+// Machines that no Terraform provider describes are listed in comments.
+func terraform(spec stitch.Stitch, machines []db.Machine) string {
+	machines = sortedMachines(machines)
+	rules := firewallRules(spec, machines)
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "# %s\n", notice)
+	fmt.Fprintf(buf, "# Namespace: %s\n", spec.Namespace)
+
+	var amazon, google, other []db.Machine
+	for _, m := range machines {
+		switch m.Provider {
+		case db.Amazon:
+			amazon = append(amazon, m)
+		case db.Google:
+			google = append(google, m)
+		default:
+			other = append(other, m)
+		}
+	}
+
+	if len(amazon) > 0 {
+		terraformAmazon(buf, spec.Namespace, rules, amazon)
+	}
+
+	if len(google) > 0 {
+		terraformGoogle(buf, spec.Namespace, rules, google)
+	}
+
+	for _, m := range other {
+		fmt.Fprintf(buf, "\n# %s machine %d is not managed by a cloud "+
+			"provider:\n", m.Provider, m.ID)
+		fmt.Fprintf(buf, "#   role = %q, public_ip = %q, private_ip = %q\n",
+			m.Role, m.PublicIP, m.PrivateIP)
+	}
+	return buf.String()
+}
+
+// terraformAmazon writes a security group in each region with Amazon machines, and
+// the machines themselves.
+func terraformAmazon(buf *bytes.Buffer, namespace string, rules []rule,
+	machines []db.Machine) {
+
+	regionSet := map[string]struct{}{}
+	for _, m := range machines {
+		regionSet[m.Region] = struct{}{}
+	}
+
+	var regions []string
+	for region := range regionSet {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	for _, region := range regions {
+		fmt.Fprintf(buf, "\nprovider \"aws\" {\n")
+		fmt.Fprintf(buf, "  alias  = %q\n", region)
+		fmt.Fprintf(buf, "  region = %q\n", region)
+		fmt.Fprintf(buf, "}\n")
+
+		fmt.Fprintf(buf, "\nresource \"aws_security_group\" %q {\n",
+			resourceRegion(region))
+		fmt.Fprintf(buf, "  provider    = \"aws.%s\"\n", region)
+		fmt.Fprintf(buf, "  name_prefix = \"%s-\"\n", namespace)
+		fmt.Fprintf(buf, "  description = \"Quilt Group\"\n")
+
+		for _, r := range rules {
+			for _, protocol := range []string{"tcp", "udp", "icmp"} {
+				minPort, maxPort := r.minPort, r.maxPort
+				if protocol == "icmp" {
+					minPort, maxPort = -1, -1
+				}
+				fmt.Fprintf(buf, "\n  ingress {\n")
+				fmt.Fprintf(buf, "    description = %q\n", r.description)
+				fmt.Fprintf(buf, "    protocol    = %q\n", protocol)
+				fmt.Fprintf(buf, "    from_port   = %d\n", minPort)
+				fmt.Fprintf(buf, "    to_port     = %d\n", maxPort)
+				fmt.Fprintf(buf, "    cidr_blocks = [%q]\n", r.cidr)
+				fmt.Fprintf(buf, "  }\n")
+			}
+		}
+
+		fmt.Fprintf(buf, "\n  ingress {\n")
+		fmt.Fprintf(buf, "    description = \"Other Quilt machines\"\n")
+		fmt.Fprintf(buf, "    protocol    = \"-1\"\n")
+		fmt.Fprintf(buf, "    from_port   = 0\n")
+		fmt.Fprintf(buf, "    to_port     = 0\n")
+		fmt.Fprintf(buf, "    self        = true\n")
+		fmt.Fprintf(buf, "  }\n")
+		fmt.Fprintf(buf, "}\n")
+	}
+
+	for _, m := range machines {
+		fmt.Fprintf(buf, "\nresource \"aws_instance\" %q {\n", resourceName(m))
+		fmt.Fprintf(buf, "  provider        = \"aws.%s\"\n", m.Region)
+		fmt.Fprintf(buf, "  instance_type   = %q\n", m.Size)
+		fmt.Fprintf(buf, "  security_groups = "+
+			"[\"${aws_security_group.%s.name}\"]\n", resourceRegion(m.Region))
+		writeTerraformMachine(buf, m)
+		fmt.Fprintf(buf, "\n  tags {\n")
+		writeTerraformTags(buf, "    ", tags(namespace, m))
+		fmt.Fprintf(buf, "  }\n")
+		fmt.Fprintf(buf, "}\n")
+	}
+}
+
+// terraformGoogle writes the firewalls of the Google network, and the Google
+// machines.  As in the Google provider, there's a firewall for each port range.
+func terraformGoogle(buf *bytes.Buffer, namespace string, rules []rule,
+	machines []db.Machine) {
+
+	type portRange struct{ min, max int }
+	var ranges []portRange
+	cidrs := map[portRange][]string{}
+	for _, r := range rules {
+		key := portRange{r.minPort, r.maxPort}
+		if _, ok := cidrs[key]; !ok {
+			ranges = append(ranges, key)
+		}
+		cidrs[key] = append(cidrs[key], r.cidr)
+	}
+
+	for _, ports := range ranges {
+		name := fmt.Sprintf("%s-%d-%d", namespace, ports.min, ports.max)
+		fmt.Fprintf(buf, "\nresource \"google_compute_firewall\" %q {\n",
+			strings.Replace(name, "-", "_", -1))
+		fmt.Fprintf(buf, "  name          = %q\n", name)
+		fmt.Fprintf(buf, "  network       = %q\n", namespace)
+		fmt.Fprintf(buf, "  source_ranges = [%s]\n", quoteList(cidrs[ports]))
+		for _, protocol := range []string{"tcp", "udp"} {
+			fmt.Fprintf(buf, "\n  allow {\n")
+			fmt.Fprintf(buf, "    protocol = %q\n", protocol)
+			fmt.Fprintf(buf, "    ports    = [\"%d-%d\"]\n",
+				ports.min, ports.max)
+			fmt.Fprintf(buf, "  }\n")
+		}
+		fmt.Fprintf(buf, "\n  allow {\n")
+		fmt.Fprintf(buf, "    protocol = \"icmp\"\n")
+		fmt.Fprintf(buf, "  }\n")
+		fmt.Fprintf(buf, "}\n")
+	}
+
+	for _, m := range machines {
+		fmt.Fprintf(buf, "\nresource \"google_compute_instance\" %q {\n",
+			resourceName(m))
+		fmt.Fprintf(buf, "  name         = %q\n", m.CloudID)
+		fmt.Fprintf(buf, "  machine_type = %q\n", m.Size)
+		fmt.Fprintf(buf, "  zone         = %q\n", m.Region)
+		fmt.Fprintf(buf, "\n  network_interface {\n")
+		fmt.Fprintf(buf, "    network    = %q\n", namespace)
+		fmt.Fprintf(buf, "    network_ip = %q\n", m.PrivateIP)
+		fmt.Fprintf(buf, "\n    access_config {\n")
+		fmt.Fprintf(buf, "      nat_ip = %q\n", m.PublicIP)
+		fmt.Fprintf(buf, "    }\n")
+		fmt.Fprintf(buf, "  }\n")
+		fmt.Fprintf(buf, "\n  labels {\n")
+		writeTerraformTags(buf, "    ", tags(namespace, m))
+		fmt.Fprintf(buf, "  }\n")
+		fmt.Fprintf(buf, "}\n")
+	}
+}
+
+// writeTerraformMachine writes the attributes of an Amazon instance that Quilt
+// chooses at runtime.
+func writeTerraformMachine(buf *bytes.Buffer, m db.Machine) {
+	for _, attr := range [][2]string{
+		{"id", m.CloudID},
+		{"public_ip", m.PublicIP},
+		{"private_ip", m.PrivateIP},
+	} {
+		if attr[1] != "" {
+			fmt.Fprintf(buf, "  %-15s = %q\n", attr[0], attr[1])
+		}
+	}
+}
+
+func writeTerraformTags(buf *bytes.Buffer, indent string, tags [][2]string) {
+	width := 0
+	for _, tag := range tags {
+		if len(tag[0]) > width {
+			width = len(tag[0])
+		}
+	}
+
+	for _, tag := range tags {
+		fmt.Fprintf(buf, "%s%-*s = %q\n", indent, width+2,
+			fmt.Sprintf("%q", tag[0]), tag[1])
+	}
+}
+
+// resourceRegion returns the name of the security group resource in `region`.
+func resourceRegion(region string) string {
+	return "quilt_" + strings.Replace(region, "-", "_", -1)
+}
+
+func quoteList(strs []string) string {
+	var quoted []string
+	for _, str := range strs {
+		quoted = append(quoted, fmt.Sprintf("%q", str))
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,371 @@
+# Generated by `quilt export` for review only.  Quilt manages these resources, so this document must not be applied.
+# Namespace: audit
+
+provider "aws" {
+  alias  = "us-east-1"
+  region = "us-east-1"
+}
+
+resource "aws_security_group" "quilt_us_east_1" {
+  provider    = "aws.us-east-1"
+  name_prefix = "audit-"
+  description = "Quilt Group"
+
+  ingress {
+    description = "Admin ACL"
+    protocol    = "tcp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["1.2.3.4/32"]
+  }
+
+  ingress {
+    description = "Admin ACL"
+    protocol    = "udp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["1.2.3.4/32"]
+  }
+
+  ingress {
+    description = "Admin ACL"
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["1.2.3.4/32"]
+  }
+
+  ingress {
+    description = "The Quilt daemon's address"
+    protocol    = "tcp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["local"]
+  }
+
+  ingress {
+    description = "The Quilt daemon's address"
+    protocol    = "udp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["local"]
+  }
+
+  ingress {
+    description = "The Quilt daemon's address"
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["local"]
+  }
+
+  ingress {
+    description = "Public connection to web"
+    protocol    = "tcp"
+    from_port   = 80
+    to_port     = 80
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    description = "Public connection to web"
+    protocol    = "udp"
+    from_port   = 80
+    to_port     = 80
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    description = "Public connection to web"
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    description = "Public connection to web"
+    protocol    = "tcp"
+    from_port   = 443
+    to_port     = 443
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    description = "Public connection to web"
+    protocol    = "udp"
+    from_port   = 443
+    to_port     = 443
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    description = "Public connection to web"
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    description = "Quilt machine 1"
+    protocol    = "tcp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["8.8.8.8/32"]
+  }
+
+  ingress {
+    description = "Quilt machine 1"
+    protocol    = "udp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["8.8.8.8/32"]
+  }
+
+  ingress {
+    description = "Quilt machine 1"
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["8.8.8.8/32"]
+  }
+
+  ingress {
+    description = "Quilt machine 2"
+    protocol    = "tcp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["8.8.8.9/32"]
+  }
+
+  ingress {
+    description = "Quilt machine 2"
+    protocol    = "udp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["8.8.8.9/32"]
+  }
+
+  ingress {
+    description = "Quilt machine 2"
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["8.8.8.9/32"]
+  }
+
+  ingress {
+    description = "Other Quilt machines"
+    protocol    = "-1"
+    from_port   = 0
+    to_port     = 0
+    self        = true
+  }
+}
+
+provider "aws" {
+  alias  = "us-west-1"
+  region = "us-west-1"
+}
+
+resource "aws_security_group" "quilt_us_west_1" {
+  provider    = "aws.us-west-1"
+  name_prefix = "audit-"
+  description = "Quilt Group"
+
+  ingress {
+    description = "Admin ACL"
+    protocol    = "tcp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["1.2.3.4/32"]
+  }
+
+  ingress {
+    description = "Admin ACL"
+    protocol    = "udp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["1.2.3.4/32"]
+  }
+
+  ingress {
+    description = "Admin ACL"
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["1.2.3.4/32"]
+  }
+
+  ingress {
+    description = "The Quilt daemon's address"
+    protocol    = "tcp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["local"]
+  }
+
+  ingress {
+    description = "The Quilt daemon's address"
+    protocol    = "udp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["local"]
+  }
+
+  ingress {
+    description = "The Quilt daemon's address"
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["local"]
+  }
+
+  ingress {
+    description = "Public connection to web"
+    protocol    = "tcp"
+    from_port   = 80
+    to_port     = 80
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    description = "Public connection to web"
+    protocol    = "udp"
+    from_port   = 80
+    to_port     = 80
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    description = "Public connection to web"
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    description = "Public connection to web"
+    protocol    = "tcp"
+    from_port   = 443
+    to_port     = 443
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    description = "Public connection to web"
+    protocol    = "udp"
+    from_port   = 443
+    to_port     = 443
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    description = "Public connection to web"
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    description = "Quilt machine 1"
+    protocol    = "tcp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["8.8.8.8/32"]
+  }
+
+  ingress {
+    description = "Quilt machine 1"
+    protocol    = "udp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["8.8.8.8/32"]
+  }
+
+  ingress {
+    description = "Quilt machine 1"
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["8.8.8.8/32"]
+  }
+
+  ingress {
+    description = "Quilt machine 2"
+    protocol    = "tcp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["8.8.8.9/32"]
+  }
+
+  ingress {
+    description = "Quilt machine 2"
+    protocol    = "udp"
+    from_port   = 1
+    to_port     = 65535
+    cidr_blocks = ["8.8.8.9/32"]
+  }
+
+  ingress {
+    description = "Quilt machine 2"
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["8.8.8.9/32"]
+  }
+
+  ingress {
+    description = "Other Quilt machines"
+    protocol    = "-1"
+    from_port   = 0
+    to_port     = 0
+    self        = true
+  }
+}
+
+resource "aws_instance" "master_1" {
+  provider        = "aws.us-west-1"
+  instance_type   = "m4.large"
+  security_groups = ["${aws_security_group.quilt_us_west_1.name}"]
+  id              = "sir-1"
+  public_ip       = "8.8.8.8"
+  private_ip      = "10.0.0.1"
+
+  tags {
+    "quilt-id"        = "1"
+    "quilt-namespace" = "audit"
+    "quilt-role"      = "Master"
+  }
+}
+
+resource "aws_instance" "worker_2" {
+  provider        = "aws.us-west-1"
+  instance_type   = "m4.large"
+  security_groups = ["${aws_security_group.quilt_us_west_1.name}"]
+  id              = "sir-2"
+  public_ip       = "8.8.8.9"
+  private_ip      = "10.0.0.2"
+
+  tags {
+    "quilt-dedicated-to" = "db"
+    "quilt-id"           = "2"
+    "quilt-namespace"    = "audit"
+    "quilt-role"         = "Worker"
+  }
+}
+
+resource "aws_instance" "worker_3" {
+  provider        = "aws.us-east-1"
+  instance_type   = "m4.xlarge"
+  security_groups = ["${aws_security_group.quilt_us_east_1.name}"]
+
+  tags {
+    "quilt-id"        = "3"
+    "quilt-namespace" = "audit"
+    "quilt-role"      = "Worker"
+  }
+}
@@ -0,0 +1,104 @@
+# Generated by `quilt export` for review only.  Quilt manages these resources, so this document must not be applied.
+# Namespace: audit
+
+resource "google_compute_firewall" "audit_1_65535" {
+  name          = "audit-1-65535"
+  network       = "audit"
+  source_ranges = ["1.2.3.4/32", "local", "8.8.8.8/32", "8.8.8.9/32"]
+
+  allow {
+    protocol = "tcp"
+    ports    = ["1-65535"]
+  }
+
+  allow {
+    protocol = "udp"
+    ports    = ["1-65535"]
+  }
+
+  allow {
+    protocol = "icmp"
+  }
+}
+
+resource "google_compute_firewall" "audit_80_80" {
+  name          = "audit-80-80"
+  network       = "audit"
+  source_ranges = ["0.0.0.0/0"]
+
+  allow {
+    protocol = "tcp"
+    ports    = ["80-80"]
+  }
+
+  allow {
+    protocol = "udp"
+    ports    = ["80-80"]
+  }
+
+  allow {
+    protocol = "icmp"
+  }
+}
+
+resource "google_compute_firewall" "audit_443_443" {
+  name          = "audit-443-443"
+  network       = "audit"
+  source_ranges = ["0.0.0.0/0"]
+
+  allow {
+    protocol = "tcp"
+    ports    = ["443-443"]
+  }
+
+  allow {
+    protocol = "udp"
+    ports    = ["443-443"]
+  }
+
+  allow {
+    protocol = "icmp"
+  }
+}
+
+resource "google_compute_instance" "master_1" {
+  name         = "quilt-1"
+  machine_type = "n1-standard-1"
+  zone         = "us-east1-b"
+
+  network_interface {
+    network    = "audit"
+    network_ip = "10.0.0.1"
+
+    access_config {
+      nat_ip = "8.8.8.8"
+    }
+  }
+
+  labels {
+    "quilt-id"        = "1"
+    "quilt-namespace" = "audit"
+    "quilt-role"      = "Master"
+  }
+}
+
+resource "google_compute_instance" "worker_2" {
+  name         = "quilt-2"
+  machine_type = "n1-standard-1"
+  zone         = "us-east1-b"
+
+  network_interface {
+    network    = "audit"
+    network_ip = "10.0.0.2"
+
+    access_config {
+      nat_ip = "8.8.8.9"
+    }
+  }
+
+  labels {
+    "quilt-id"        = "2"
+    "quilt-namespace" = "audit"
+    "quilt-role"      = "Worker"
+  }
+}
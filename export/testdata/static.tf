@@ -0,0 +1,8 @@
+# Generated by `quilt export` for review only.  Quilt manages these resources, so this document must not be applied.
+# Namespace: audit
+
+# Static machine 1 is not managed by a cloud provider:
+#   role = "Master", public_ip = "8.8.8.8", private_ip = "10.0.0.1"
+
+# Vagrant machine 2 is not managed by a cloud provider:
+#   role = "Worker", public_ip = "8.8.8.9", private_ip = "10.0.0.2"
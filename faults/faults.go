@@ -0,0 +1,122 @@
+// Package faults lets integration tests inject failures into a running daemon or
+// minion, e.g. to check that Quilt recovers when a provider runs out of capacity.
+// Faults are requested over the API with `InjectFault`, which is refused unless
+// injection was explicitly enabled with the -insecure-fault-injection flag.  Anyone
+// who can reach the API of a daemon or minion with injection enabled can break it,
+// so it must never be enabled in production.
+package faults
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// A Fault describes failures to inject.  Each count is added to the number of
+// failures of that kind still pending.
+type Fault struct {
+	// The number of upcoming provider Boot calls that should fail.
+	BootFailures int
+
+	// The number of upcoming etcd syncs that should be skipped.
+	DropEtcdSyncs int
+
+	// The number of upcoming Docker container starts that should be delayed by
+	// DockerStartDelay.
+	DelayedDockerStarts int
+	DockerStartDelay    time.Duration
+}
+
+// ErrDisabled is returned by Inject when fault injection isn't enabled.
+var ErrDisabled = errors.New("fault injection is disabled")
+
+// ErrBootFailure is the error returned by injected provider Boot failures.
+var ErrBootFailure = errors.New("injected boot failure")
+
+var (
+	mutex   sync.Mutex
+	enabled bool
+	pending Fault
+)
+
+// Enable allows faults to be injected.  It can't be undone.
+func Enable() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	enabled = true
+}
+
+// Enabled returns true if faults may be injected.
+func Enabled() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return enabled
+}
+
+// Inject adds `f` to the pending faults.
+func Inject(f Fault) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !enabled {
+		return ErrDisabled
+	}
+
+	if f.BootFailures < 0 || f.DropEtcdSyncs < 0 || f.DelayedDockerStarts < 0 ||
+		f.DockerStartDelay < 0 {
+		return errors.New("fault counts and delays must not be negative")
+	}
+
+	pending.BootFailures += f.BootFailures
+	pending.DropEtcdSyncs += f.DropEtcdSyncs
+	pending.DelayedDockerStarts += f.DelayedDockerStarts
+	if f.DelayedDockerStarts > 0 {
+		pending.DockerStartDelay = f.DockerStartDelay
+	}
+	return nil
+}
+
+// BootFailure returns ErrBootFailure if a provider Boot call should fail, and nil
+// otherwise.
+func BootFailure() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if pending.BootFailures == 0 {
+		return nil
+	}
+	pending.BootFailures--
+	return ErrBootFailure
+}
+
+// DropEtcdSync returns true if an etcd sync should be skipped.
+func DropEtcdSync() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if pending.DropEtcdSyncs == 0 {
+		return false
+	}
+	pending.DropEtcdSyncs--
+	return true
+}
+
+// DockerStartDelay returns how long a Docker container start should be delayed.
+func DockerStartDelay() time.Duration {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if pending.DelayedDockerStarts == 0 {
+		return 0
+	}
+	pending.DelayedDockerStarts--
+	return pending.DockerStartDelay
+}
+
+// reset disables fault injection and clears the pending faults, for the unit tests.
+func reset() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	enabled = false
+	pending = Fault{}
+}
@@ -0,0 +1,35 @@
+package faults
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInject(t *testing.T) {
+	reset()
+	defer reset()
+
+	assert.Equal(t, ErrDisabled, Inject(Fault{BootFailures: 1}))
+	assert.NoError(t, BootFailure())
+
+	Enable()
+	assert.True(t, Enabled())
+	assert.Error(t, Inject(Fault{BootFailures: -1}))
+
+	assert.NoError(t, Inject(Fault{BootFailures: 1, DropEtcdSyncs: 1}))
+	assert.NoError(t, Inject(Fault{BootFailures: 1, DelayedDockerStarts: 2,
+		DockerStartDelay: time.Minute}))
+
+	assert.Equal(t, ErrBootFailure, BootFailure())
+	assert.Equal(t, ErrBootFailure, BootFailure())
+	assert.NoError(t, BootFailure())
+
+	assert.True(t, DropEtcdSync())
+	assert.False(t, DropEtcdSync())
+
+	assert.Equal(t, time.Minute, DockerStartDelay())
+	assert.Equal(t, time.Minute, DockerStartDelay())
+	assert.Equal(t, time.Duration(0), DockerStartDelay())
+}
@@ -0,0 +1,56 @@
+package minion
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NetSys/quilt/util"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The file in which the boot script records when it started, in seconds since the
+// epoch.
+const bootStartFile = "/var/lib/quilt/boot-start"
+
+// The file the minion creates when it first starts, so that it can tell when it was
+// restarted.
+const minionStartedFile = "/var/lib/quilt/minion-started"
+
+// firstStart returns whether this is the first time the minion started on this
+// machine.  A restarted minion's boot timings would include the time it ran before
+// the restart, so only the first start records them.
+func firstStart() bool {
+	if _, err := util.AppFs.Stat(minionStartedFile); err == nil {
+		return false
+	}
+
+	if err := util.WriteFile(minionStartedFile, nil, 0644); err != nil {
+		log.WithError(err).Warn("Failed to record the minion's start, so its " +
+			"boot won't be timed.")
+		return false
+	}
+	return true
+}
+
+// bootScriptElapsed returns how long before `start` the boot script started, or zero
+// if it didn't record when it started.  The boot script runs only once, so if the
+// minion restarts, this includes the time until the restart.
+func bootScriptElapsed(start time.Time) time.Duration {
+	contents, err := util.ReadFile(bootStartFile)
+	if err != nil {
+		return 0
+	}
+
+	secs, err := strconv.ParseInt(strings.TrimSpace(contents), 10, 64)
+	if err != nil {
+		log.WithError(err).Warn("Malformed boot start time.")
+		return 0
+	}
+
+	if elapsed := start.Sub(time.Unix(secs, 0)); elapsed > 0 {
+		return elapsed
+	}
+	return 0
+}
@@ -0,0 +1,38 @@
+package minion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/NetSys/quilt/util"
+)
+
+func TestFirstStart(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+	assert.True(t, firstStart())
+	assert.False(t, firstStart())
+
+	// If the start can't be recorded, restarts can't be detected.
+	util.AppFs = afero.NewReadOnlyFs(afero.NewMemMapFs())
+	assert.False(t, firstStart())
+}
+
+func TestBootScriptElapsed(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+	start := time.Unix(1500000100, 0)
+
+	// The boot script didn't record when it started.
+	assert.Zero(t, bootScriptElapsed(start))
+
+	util.WriteFile(bootStartFile, []byte("1500000000\n"), 0644)
+	assert.Equal(t, 100*time.Second, bootScriptElapsed(start))
+
+	// Clock skew mustn't result in negative timings.
+	assert.Zero(t, bootScriptElapsed(time.Unix(1400000000, 0)))
+
+	util.WriteFile(bootStartFile, []byte("garbage"), 0644)
+	assert.Zero(t, bootScriptElapsed(start))
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -19,23 +20,32 @@ import (
 
 var pullCacheTimeout = time.Minute
 
+// How many seconds a container is given to exit after its stop signal before it's
+// killed, as by `docker stop`.
+const stopGracePeriod = 10
+
 // ErrNoSuchContainer is the error returned when an operation is requested on a
 // non-existent container.
 var ErrNoSuchContainer = errors.New("container does not exist")
 
 // A Container as returned by the docker client API.
 type Container struct {
-	ID     string
-	EID    string
-	Name   string
-	Image  string
-	IP     string
-	Mac    string
-	Path   string
-	Args   []string
-	Pid    int
-	Env    map[string]string
-	Labels map[string]string
+	ID      string
+	EID     string
+	Name    string
+	Image   string
+	IP      string
+	Mac     string
+	Path    string
+	Args    []string
+	Pid     int
+	Status  string
+	Env     map[string]string
+	Labels  map[string]string
+	ShmSize int
+
+	StopSignal  string // The signal that stops the container, if not the default.
+	NetworkMode string // The network the container was started in, e.g. "host".
 }
 
 // ContainerSlice is an alias for []Container to allow for joins
@@ -46,6 +56,13 @@ type Client struct {
 	client
 	*sync.Mutex
 	imageCache map[string]*cacheEntry
+	health     *health
+}
+
+// health tracks whether the Docker daemon is reachable.
+type health struct {
+	sync.Mutex
+	unavailableSince time.Time
 }
 
 type cacheEntry struct {
@@ -61,14 +78,24 @@ type RunOptions struct {
 	Labels map[string]string
 	Env    map[string]string
 
+	// Files written into the container before it starts, keyed by absolute path.
+	FilepathToContent map[string]string
+
+	ShmSize     int
+	StopSignal  string
 	NetworkMode string
 	PidMode     string
 	Privileged  bool
 	VolumesFrom []string
+
+	// The IP the container is given in its network.  If empty, Docker's IPAM
+	// chooses one.
+	IP string
 }
 
 type client interface {
 	StartContainer(id string, hostConfig *dkc.HostConfig) error
+	StopContainer(id string, timeout uint) error
 	UploadToContainer(id string, opts dkc.UploadToContainerOptions) error
 	DownloadFromContainer(id string, opts dkc.DownloadFromContainerOptions) error
 	RemoveContainer(opts dkc.RemoveContainerOptions) error
@@ -93,29 +120,42 @@ func New(sock string) Client {
 		break
 	}
 
-	return Client{client, &sync.Mutex{}, map[string]*cacheEntry{}}
+	return Client{client, &sync.Mutex{}, map[string]*cacheEntry{}, &health{}}
 }
 
 // Run creates and starts a new container in accordance RunOptions.
 func (dk Client) Run(opts RunOptions) (string, error) {
-	env := map[string]struct{}{}
-	for k, v := range opts.Env {
-		env[k+"="+v] = struct{}{}
-	}
-
 	hc := &dkc.HostConfig{
 		NetworkMode: opts.NetworkMode,
 		PidMode:     opts.PidMode,
 		Privileged:  opts.Privileged,
 		VolumesFrom: opts.VolumesFrom,
 		DNSSearch:   []string{"q"},
+		ShmSize:     int64(opts.ShmSize),
 	}
 
-	id, err := dk.create(opts.Name, opts.Image, opts.Args, opts.Labels, env, hc, nil)
+	var nc *dkc.NetworkingConfig
+	if opts.IP != "" {
+		nc = &dkc.NetworkingConfig{
+			EndpointsConfig: map[string]*dkc.EndpointConfig{
+				opts.NetworkMode: {IPAMConfig: &dkc.EndpointIPAMConfig{
+					IPv4Address: opts.IP,
+				}},
+			},
+		}
+	}
+
+	id, err := dk.create(opts.Name, opts.Image, opts.Args, opts.Labels,
+		util.EnvList(opts.Env), opts.StopSignal, hc, nc)
 	if err != nil {
 		return "", err
 	}
 
+	if err = dk.writeFiles(id, opts.FilepathToContent); err != nil {
+		dk.RemoveID(id) // Remove the container to avoid a zombie.
+		return "", err
+	}
+
 	if err = dk.StartContainer(id, hc); err != nil {
 		dk.RemoveID(id) // Remove the container to avoid a zombie.
 		return "", err
@@ -163,6 +203,44 @@ func (dk Client) WriteToContainer(id, src, dst, archiveName string,
 	return nil
 }
 
+// writeFiles uploads `files` into the created container with id ID, so that they're
+// in place before the container starts.
+func (dk Client) writeFiles(id string, files map[string]string) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, path := range paths {
+		hdr := &tar.Header{
+			Name: strings.TrimPrefix(path, "/"),
+			Mode: 0644,
+			Size: int64(len(files[path])),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write([]byte(files[path])); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return dk.UploadToContainer(id, dkc.UploadToContainerOptions{
+		InputStream: buf,
+		Path:        "/",
+	})
+}
+
 // GetFromContainer returns a string containing the content of the file named
 // SRC on the container with id ID.
 func (dk Client) GetFromContainer(id string, src string) (string, error) {
@@ -203,9 +281,16 @@ func (dk Client) Remove(name string) error {
 	return dk.RemoveID(id)
 }
 
-// RemoveID stops and deletes the container with the given ID.
+// RemoveID stops and deletes the container with the given ID.  The container is sent
+// its stop signal, and is only killed if it hasn't exited after stopGracePeriod.
 func (dk Client) RemoveID(id string) error {
-	err := dk.RemoveContainer(dkc.RemoveContainerOptions{ID: id, Force: true})
+	err := dk.StopContainer(id, stopGracePeriod)
+	if _, ok := err.(*dkc.ContainerNotRunning); err != nil && !ok {
+		log.WithError(err).WithField("id", id).Debug(
+			"Failed to stop container, removing it anyway.")
+	}
+
+	err = dk.RemoveContainer(dkc.RemoveContainerOptions{ID: id, Force: true})
 	if err != nil {
 		return err
 	}
@@ -259,7 +344,50 @@ func (dk Client) getCacheEntry(repo, tag string) *cacheEntry {
 // List returns a slice of all running containers.  The List can be be filtered with the
 // supplied `filters` map.
 func (dk Client) List(filters map[string][]string) ([]Container, error) {
-	return dk.list(filters, false)
+	containers, err := dk.list(filters, false)
+
+	dk.health.Lock()
+	switch {
+	case err == nil:
+		dk.health.unavailableSince = time.Time{}
+	case IsUnavailable(err) && dk.health.unavailableSince.IsZero():
+		dk.health.unavailableSince = time.Now()
+	}
+	dk.health.Unlock()
+
+	return containers, err
+}
+
+// ListAll returns a slice of all containers, including those that have exited.  Like
+// List, it can be filtered with the supplied `filters` map.
+func (dk Client) ListAll(filters map[string][]string) ([]Container, error) {
+	return dk.list(filters, true)
+}
+
+// Start starts the created or exited container with the given ID.
+func (dk Client) Start(id string) error {
+	return dk.StartContainer(id, nil)
+}
+
+// UnavailableSince returns when List first failed to reach the Docker daemon, or the
+// zero time if the daemon was reachable the last time List ran.
+func (dk Client) UnavailableSince() time.Time {
+	dk.health.Lock()
+	defer dk.health.Unlock()
+	return dk.health.unavailableSince
+}
+
+// IsUnavailable returns whether `err` means that the Docker daemon couldn't be
+// reached, as happens while it restarts, rather than that the request failed.
+func IsUnavailable(err error) bool {
+	_, ok := err.(net.Error)
+	return ok || err == dkc.ErrConnectionRefused
+}
+
+// isNoSuchContainer returns whether `err` means that the container doesn't exist.
+func isNoSuchContainer(err error) bool {
+	_, ok := err.(*dkc.NoSuchContainer)
+	return ok || err == ErrNoSuchContainer
 }
 
 func (dk Client) list(filters map[string][]string, all bool) ([]Container, error) {
@@ -271,10 +399,18 @@ func (dk Client) list(filters map[string][]string, all bool) ([]Container, error
 
 	var containers []Container
 	for _, apic := range apics {
+		// Containers that were removed since they were listed, or that fail to
+		// inspect, are skipped.  If the daemon itself can't be reached, however,
+		// the list fails, so that callers never mistake the containers it
+		// couldn't inspect for ones that are gone.
 		c, err := dk.Get(apic.ID)
-		if err != nil {
-			log.WithError(err).Warnf("Failed to inspect container: %s",
-				apic.ID)
+		if err != nil && IsUnavailable(err) {
+			return nil, err
+		} else if err != nil {
+			if !isNoSuchContainer(err) {
+				log.WithError(err).Warnf(
+					"Failed to inspect container: %s", apic.ID)
+			}
 			continue
 		}
 
@@ -293,7 +429,7 @@ func (dk Client) Get(id string) (Container, error) {
 
 	env := make(map[string]string)
 	for _, value := range dkc.Config.Env {
-		e := strings.Split(value, "=")
+		e := strings.SplitN(value, "=", 2)
 		if len(e) > 1 {
 			env[e[0]] = e[1]
 		}
@@ -309,8 +445,16 @@ func (dk Client) Get(id string) (Container, error) {
 		Path:   dkc.Path,
 		Args:   dkc.Args,
 		Pid:    dkc.State.Pid,
+		Status: dkc.State.StateString(),
 		Env:    env,
 		Labels: dkc.Config.Labels,
+
+		StopSignal: dkc.Config.StopSignal,
+	}
+
+	if dkc.HostConfig != nil {
+		c.ShmSize = int(dkc.HostConfig.ShmSize)
+		c.NetworkMode = dkc.HostConfig.NetworkMode
 	}
 
 	networks := keys(dkc.NetworkSettings.Networks)
@@ -346,25 +490,21 @@ func (dk Client) IsRunning(name string) (bool, error) {
 }
 
 func (dk Client) create(name, image string, args []string, labels map[string]string,
-	env map[string]struct{}, hc *dkc.HostConfig, nc *dkc.NetworkingConfig) (string,
-	error) {
+	env []string, stopSignal string, hc *dkc.HostConfig,
+	nc *dkc.NetworkingConfig) (string, error) {
 
 	if err := dk.Pull(image); err != nil {
 		return "", err
 	}
 
-	envList := make([]string, len(env))
-	for k := range env {
-		envList = append(envList, k)
-	}
-
 	container, err := dk.CreateContainer(dkc.CreateContainerOptions{
 		Name: name,
 		Config: &dkc.Config{
-			Image:  string(image),
-			Cmd:    args,
-			Labels: labels,
-			Env:    envList},
+			Image:      string(image),
+			Cmd:        args,
+			Labels:     labels,
+			Env:        env,
+			StopSignal: stopSignal},
 		HostConfig:       hc,
 		NetworkingConfig: nc,
 	})
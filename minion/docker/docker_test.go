@@ -107,12 +107,12 @@ func TestCreateGet(t *testing.T) {
 	md, dk := NewMock()
 
 	md.PullError = true
-	_, err := dk.create("name", "image", nil, nil, nil, nil, nil)
+	_, err := dk.create("name", "image", nil, nil, nil, "", nil, nil)
 	assert.NotNil(t, err)
 	md.PullError = false
 
 	md.CreateError = true
-	_, err = dk.create("name", "image", nil, nil, nil, nil, nil)
+	_, err = dk.create("name", "image", nil, nil, nil, "", nil, nil)
 	assert.NotNil(t, err)
 	md.CreateError = false
 
@@ -120,11 +120,9 @@ func TestCreateGet(t *testing.T) {
 	assert.NotNil(t, err)
 
 	args := []string{"arg1"}
-	env := map[string]struct{}{
-		"envA=B": {},
-	}
+	env := []string{"envA=B"}
 	labels := map[string]string{"label": "foo"}
-	id, err := dk.create("name", "image", args, labels, env, nil, nil)
+	id, err := dk.create("name", "image", args, labels, env, "", nil, nil)
 	assert.Nil(t, err)
 
 	container, err := dk.Get(id)
@@ -135,6 +133,7 @@ func TestCreateGet(t *testing.T) {
 		ID:     id,
 		Image:  "image",
 		Args:   args,
+		Status: "created",
 		Env:    map[string]string{"envA": "B"},
 		Labels: labels,
 	}
@@ -175,14 +174,14 @@ func TestRun(t *testing.T) {
 	id2, err := dk.Run(RunOptions{Name: "name2"})
 	assert.Nil(t, err)
 
-	md.StopContainer(id2)
+	md.StopContainer(id2, 0)
 
 	containers, err = dk.List(nil)
 	assert.Nil(t, err)
 	assert.Equal(t, 1, len(containers))
 	assert.Equal(t, id1, containers[0].ID)
 
-	containers, err = dk.list(nil, true)
+	containers, err = dk.ListAll(nil)
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(containers))
 	assert.True(t, containers[0].ID == id2 || containers[1].ID == id2)
@@ -208,11 +207,12 @@ func TestRun(t *testing.T) {
 
 func TestRunEnv(t *testing.T) {
 	t.Parallel()
-	_, dk := NewMock()
+	md, dk := NewMock()
 
 	env := map[string]string{
-		"a": "b",
 		"c": "d",
+		"a": "b",
+		"e": "f=g",
 	}
 	id, err := dk.Run(RunOptions{Name: "name1", Env: env})
 	assert.Nil(t, err)
@@ -220,6 +220,48 @@ func TestRunEnv(t *testing.T) {
 	container, err := dk.Get(id)
 	assert.Nil(t, err)
 	assert.Equal(t, env, container.Env)
+
+	// The runtime is given the environment sorted by key.
+	assert.Equal(t, []string{"a=b", "c=d", "e=f=g"},
+		md.Containers[id].Config.Env)
+}
+
+func TestRunShmSize(t *testing.T) {
+	t.Parallel()
+	_, dk := NewMock()
+
+	id, err := dk.Run(RunOptions{Name: "name1", ShmSize: 1 << 30})
+	assert.Nil(t, err)
+
+	container, err := dk.Get(id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1<<30, container.ShmSize)
+}
+
+func TestRunStopSignal(t *testing.T) {
+	t.Parallel()
+	_, dk := NewMock()
+
+	id, err := dk.Run(RunOptions{Name: "name1", StopSignal: "SIGQUIT"})
+	assert.Nil(t, err)
+
+	container, err := dk.Get(id)
+	assert.Nil(t, err)
+	assert.Equal(t, "SIGQUIT", container.StopSignal)
+}
+
+func TestRunFiles(t *testing.T) {
+	t.Parallel()
+	md, dk := NewMock()
+
+	files := map[string]string{
+		"/etc/nginx/nginx.conf": "events {}",
+		"/init.sh":              "#!/bin/sh",
+	}
+	id, err := dk.Run(RunOptions{Name: "name1", FilepathToContent: files})
+	assert.Nil(t, err)
+	assert.Equal(t, files, md.Containers[id].Files)
+	assert.True(t, md.Containers[id].Running)
 }
 
 func TestRemove(t *testing.T) {
@@ -248,8 +290,11 @@ func TestRemove(t *testing.T) {
 	err = dk.Remove("name1")
 	assert.Nil(t, err)
 
+	// Containers are given a chance to handle their stop signal before they're
+	// killed.
 	err = dk.RemoveID(id2)
 	assert.Nil(t, err)
+	assert.Equal(t, uint(stopGracePeriod), md.StopTimeouts[id2])
 
 	containers, err := dk.list(nil, true)
 	assert.Nil(t, err)
@@ -263,3 +308,44 @@ func cacheKeys(cache map[string]*cacheEntry) map[string]struct{} {
 	}
 	return res
 }
+
+func TestUnavailable(t *testing.T) {
+	t.Parallel()
+	md, dk := NewMock()
+
+	id, err := dk.Run(RunOptions{Name: "name"})
+	assert.NoError(t, err)
+
+	_, err = dk.List(nil)
+	assert.NoError(t, err)
+	assert.True(t, dk.UnavailableSince().IsZero())
+
+	md.Unavailable = true
+	_, err = dk.List(nil)
+	assert.True(t, IsUnavailable(err))
+	since := dk.UnavailableSince()
+	assert.False(t, since.IsZero())
+
+	// The outage is dated from the first failure.
+	_, err = dk.List(nil)
+	assert.True(t, IsUnavailable(err))
+	assert.Equal(t, since, dk.UnavailableSince())
+
+	// Other failures don't mean the daemon is unavailable.
+	md.Unavailable = false
+	md.ListError = true
+	_, err = dk.List(nil)
+	assert.False(t, IsUnavailable(err))
+	assert.Equal(t, since, dk.UnavailableSince())
+	md.ListError = false
+
+	containers, err := dk.List(nil)
+	assert.NoError(t, err)
+	assert.Len(t, containers, 1)
+	assert.Equal(t, id, containers[0].ID)
+	assert.True(t, dk.UnavailableSince().IsZero())
+
+	_, err = dk.Get("missing")
+	assert.True(t, isNoSuchContainer(err))
+	assert.False(t, IsUnavailable(err))
+}
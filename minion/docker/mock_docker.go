@@ -1,9 +1,14 @@
 package docker
 
 import (
+	"archive/tar"
 	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	dkc "github.com/fsouza/go-dockerclient"
 	"github.com/satori/go.uuid"
@@ -12,6 +17,7 @@ import (
 type mockContainer struct {
 	*dkc.Container
 	Running bool
+	Files   map[string]string // Uploaded files, keyed by absolute path.
 }
 
 // MockClient gives unit testers access to the internals of the mock docker client
@@ -25,15 +31,22 @@ type MockClient struct {
 	createdExecs map[string]dkc.CreateExecOptions
 	Executions   map[string][]string
 
+	// The grace period each container was last stopped with, in seconds.
+	StopTimeouts map[string]uint
+
 	CreateError     bool
 	NetworkError    bool
 	CreateExecError bool
 	InspectError    bool
 	ListError       bool
 	PullError       bool
-	RemoveError     bool
+	RemoveError     bool // Fails stopping containers as well as removing them.
 	StartError      bool
 	StartExecError  bool
+
+	// Simulates the Docker daemon being down, as it is while it restarts.  Every
+	// request fails as if the daemon refused the connection.
+	Unavailable bool
 }
 
 // NewMock creates a mock docker client suitable for use in unit tests, and a MockClient
@@ -46,8 +59,9 @@ func NewMock() (*MockClient, Client) {
 		Networks:     map[string]*dkc.Network{},
 		createdExecs: map[string]dkc.CreateExecOptions{},
 		Executions:   map[string][]string{},
+		StopTimeouts: map[string]uint{},
 	}
-	return md, Client{md, &sync.Mutex{}, map[string]*cacheEntry{}}
+	return md, Client{md, &sync.Mutex{}, map[string]*cacheEntry{}, &health{}}
 }
 
 // StartContainer starts the given docker container.
@@ -55,24 +69,55 @@ func (dk MockClient) StartContainer(id string, hostConfig *dkc.HostConfig) error
 	dk.Lock()
 	defer dk.Unlock()
 
+	if dk.Unavailable {
+		return dkc.ErrConnectionRefused
+	}
+
 	if dk.StartError {
 		return errors.New("start error")
 	}
 
-	container := dk.Containers[id]
+	container, ok := dk.Containers[id]
+	if !ok {
+		return ErrNoSuchContainer
+	}
+
 	container.Running = true
-	container.HostConfig = hostConfig
+	container.State.Running = true
+	container.State.StartedAt = time.Now()
+	if hostConfig != nil {
+		container.HostConfig = hostConfig
+	}
 	dk.Containers[id] = container
 	return nil
 }
 
 // StopContainer stops the given docker container.
-func (dk MockClient) StopContainer(id string) {
+func (dk MockClient) StopContainer(id string, timeout uint) error {
 	dk.Lock()
 	defer dk.Unlock()
-	container := dk.Containers[id]
+
+	if dk.Unavailable {
+		return dkc.ErrConnectionRefused
+	}
+
+	if dk.RemoveError {
+		return errors.New("stop error")
+	}
+
+	container, ok := dk.Containers[id]
+	if !ok {
+		return &dkc.NoSuchContainer{ID: id}
+	}
+	if !container.Running {
+		return &dkc.ContainerNotRunning{ID: id}
+	}
+
 	container.Running = false
+	container.State.Running = false
 	dk.Containers[id] = container
+	dk.StopTimeouts[id] = timeout
+	return nil
 }
 
 // RemoveContainer removes the given docker container.
@@ -80,6 +125,10 @@ func (dk MockClient) RemoveContainer(opts dkc.RemoveContainerOptions) error {
 	dk.Lock()
 	defer dk.Unlock()
 
+	if dk.Unavailable {
+		return dkc.ErrConnectionRefused
+	}
+
 	if dk.RemoveError {
 		return errors.New("remove error")
 	}
@@ -94,6 +143,10 @@ func (dk MockClient) PullImage(opts dkc.PullImageOptions,
 	dk.Lock()
 	defer dk.Unlock()
 
+	if dk.Unavailable {
+		return dkc.ErrConnectionRefused
+	}
+
 	if dk.PullError {
 		return errors.New("pull error")
 	}
@@ -102,12 +155,16 @@ func (dk MockClient) PullImage(opts dkc.PullImageOptions,
 	return nil
 }
 
-// ListContainers lists the running containers.
+// ListContainers lists the running containers, or all of them if `opts.All` is set.
 func (dk MockClient) ListContainers(opts dkc.ListContainersOptions) ([]dkc.APIContainers,
 	error) {
 	dk.Lock()
 	defer dk.Unlock()
 
+	if dk.Unavailable {
+		return nil, dkc.ErrConnectionRefused
+	}
+
 	if dk.ListError {
 		return nil, errors.New("list error")
 	}
@@ -140,6 +197,10 @@ func (dk MockClient) CreateNetwork(opts dkc.CreateNetworkOptions) (*dkc.Network,
 	dk.Lock()
 	defer dk.Unlock()
 
+	if dk.Unavailable {
+		return nil, dkc.ErrConnectionRefused
+	}
+
 	if dk.NetworkError {
 		return nil, errors.New("create network error")
 	}
@@ -158,6 +219,10 @@ func (dk MockClient) InspectContainer(id string) (*dkc.Container, error) {
 	dk.Lock()
 	defer dk.Unlock()
 
+	if dk.Unavailable {
+		return nil, dkc.ErrConnectionRefused
+	}
+
 	if dk.InspectError {
 		return nil, errors.New("inspect error")
 	}
@@ -176,6 +241,10 @@ func (dk *MockClient) CreateContainer(opts dkc.CreateContainerOptions) (*dkc.Con
 	dk.Lock()
 	defer dk.Unlock()
 
+	if dk.Unavailable {
+		return nil, dkc.ErrConnectionRefused
+	}
+
 	if dk.CreateError {
 		return nil, errors.New("create error")
 	}
@@ -199,7 +268,15 @@ func (dk *MockClient) CreateContainer(opts dkc.CreateContainerOptions) (*dkc.Con
 		HostConfig:      opts.HostConfig,
 		NetworkSettings: &dkc.NetworkSettings{},
 	}
-	dk.Containers[id] = mockContainer{container, false}
+	if opts.NetworkingConfig != nil {
+		for _, endpoint := range opts.NetworkingConfig.EndpointsConfig {
+			if endpoint.IPAMConfig != nil {
+				container.NetworkSettings.IPAddress =
+					endpoint.IPAMConfig.IPv4Address
+			}
+		}
+	}
+	dk.Containers[id] = mockContainer{container, false, map[string]string{}}
 	return container, nil
 }
 
@@ -246,10 +323,36 @@ func (dk *MockClient) ResetExec() {
 	dk.Executions = map[string][]string{}
 }
 
-// UploadToContainer is not implemented.
+// UploadToContainer extracts the tar archive in `opts` into the container's Files.
 func (dk MockClient) UploadToContainer(id string,
 	opts dkc.UploadToContainerOptions) error {
-	panic("MockClient Not Implemented")
+	dk.Lock()
+	defer dk.Unlock()
+
+	if dk.Unavailable {
+		return dkc.ErrConnectionRefused
+	}
+
+	container, ok := dk.Containers[id]
+	if !ok {
+		return ErrNoSuchContainer
+	}
+
+	tr := tar.NewReader(opts.InputStream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		container.Files[filepath.Join(opts.Path, hdr.Name)] = string(content)
+	}
 }
 
 // DownloadFromContainer is not implemented.
@@ -2,6 +2,7 @@ package minion
 
 import (
 	"sort"
+	"time"
 
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/join"
@@ -40,6 +41,7 @@ func updatePlacements(view db.Database, spec stitch.Stitch) {
 			Provider:    sp.Provider,
 			Size:        sp.Size,
 			Region:      sp.Region,
+			FloatingIP:  sp.FloatingIP,
 		})
 	}
 
@@ -66,16 +68,32 @@ func updatePlacements(view db.Database, spec stitch.Stitch) {
 }
 
 func updateConnections(view db.Database, spec stitch.Stitch) {
-	scs, vcs := stitch.ConnectionSlice(spec.Connections),
-		view.SelectFromConnection(nil)
+	// Host networked connections don't involve the overlay, so they're left to the
+	// workers' firewalls.
+	var scs stitch.ConnectionSlice
+	for _, c := range spec.Connections {
+		if !c.HostNetwork {
+			scs = append(scs, c)
+		}
+	}
+	vcs := view.SelectFromConnection(nil)
 
 	dbcKey := func(val interface{}) interface{} {
 		c := val.(db.Connection)
 		return stitch.Connection{
-			From:    c.From,
-			To:      c.To,
-			MinPort: c.MinPort,
-			MaxPort: c.MaxPort,
+			From:     c.From,
+			To:       c.To,
+			MinPort:  c.MinPort,
+			MaxPort:  c.MaxPort,
+			Protocol: c.Protocol,
+
+			BandwidthLimit:    c.BandwidthLimit,
+			DSCP:              c.DSCP,
+			AllowCrossNetwork: c.AllowCrossNetwork,
+			Probe: stitch.Probe{
+				UDPQuery:       c.ProbeUDPQuery,
+				ExpectResponse: c.ProbeExpectResponse,
+			},
 		}
 	}
 
@@ -97,6 +115,12 @@ func updateConnections(view db.Database, spec stitch.Stitch) {
 		dbc.To = stitchc.To
 		dbc.MinPort = stitchc.MinPort
 		dbc.MaxPort = stitchc.MaxPort
+		dbc.Protocol = stitchc.Protocol
+		dbc.BandwidthLimit = stitchc.BandwidthLimit
+		dbc.DSCP = stitchc.DSCP
+		dbc.AllowCrossNetwork = stitchc.AllowCrossNetwork
+		dbc.ProbeUDPQuery = stitchc.Probe.UDPQuery
+		dbc.ProbeExpectResponse = stitchc.Probe.ExpectResponse
 		view.Commit(dbc)
 	}
 }
@@ -109,12 +133,33 @@ func queryContainers(spec stitch.Stitch) []db.Container {
 			Command:  c.Command,
 			Image:    c.Image,
 			Env:      c.Env,
+			ShmSize:  c.ShmSize,
+			Init:     c.Init,
+			Arch:     c.Arch,
+			Canary:   c.Canary,
+			Network:  c.Network,
+
+			StopSignal:        c.StopSignal,
+			FilepathToContent: c.FilepathToContent,
+		}
+
+		// Overlay containers are left without a NetworkMode, so that those
+		// from specs that predate the field aren't restarted.
+		if !c.OnOverlay() {
+			containers[c.ID].NetworkMode = c.NetworkMode
 		}
 	}
 
 	for _, label := range spec.Labels {
-		for _, id := range label.IDs {
-			containers[id].Labels = append(containers[id].Labels, label.Name)
+		for i, id := range label.IDs {
+			c := containers[id]
+			c.Labels = append(c.Labels, label.Name)
+			if label.ExposesSize() {
+				c.LabelSize = len(label.IDs)
+				c.LabelIndex = i
+				c.RestartOnResize = label.HasAnnotation(
+					stitch.LabelSizeRestartAnnotation)
+			}
 		}
 	}
 
@@ -127,13 +172,14 @@ func queryContainers(spec stitch.Stitch) []db.Container {
 }
 
 func updateContainers(view db.Database, spec stitch.Stitch) {
+	specContainers := deferRollouts(queryContainers(spec),
+		view.SelectFromContainer(nil), spec.MaintenanceWindow, timeNow())
+
 	score := func(l, r interface{}) int {
 		left := l.(db.Container)
 		right := r.(db.Container)
 
-		if left.Image != right.Image ||
-			!util.StrSliceEqual(left.Command, right.Command) ||
-			!util.StrStrMapEqual(left.Env, right.Env) {
+		if left.ConfigKey() != right.ConfigKey() {
 			return -1
 		}
 
@@ -144,8 +190,8 @@ func updateContainers(view db.Database, spec stitch.Stitch) {
 		return score
 	}
 
-	pairs, news, dbcs := join.Join(queryContainers(spec),
-		view.SelectFromContainer(nil), score)
+	pairs, news, dbcs := join.Join(specContainers, view.SelectFromContainer(nil),
+		score)
 
 	for _, dbc := range dbcs {
 		view.Remove(dbc.(db.Container))
@@ -164,10 +210,66 @@ func updateContainers(view db.Database, spec stitch.Stitch) {
 		dbc.Labels = newc.Labels
 		sort.Sort(sort.StringSlice(dbc.Labels))
 
-		dbc.Command = newc.Command
-		dbc.Image = newc.Image
-		dbc.Env = newc.Env
+		// Paired containers already have the same configuration, but it may
+		// be represented differently, e.g. with a nil instead of an empty
+		// Env.  Leave it alone so that the row doesn't change needlessly.
+		if dbc.ConfigKey() != newc.ConfigKey() {
+			dbc.Command = newc.Command
+			dbc.Image = newc.Image
+			dbc.Env = newc.Env
+			dbc.ShmSize = newc.ShmSize
+			dbc.Init = newc.Init
+			dbc.StopSignal = newc.StopSignal
+			dbc.NetworkMode = newc.NetworkMode
+			dbc.FilepathToContent = newc.FilepathToContent
+		}
+		dbc.Arch = newc.Arch
+		dbc.Canary = newc.Canary
+		dbc.Network = newc.Network
+		dbc.LabelSize = newc.LabelSize
+		dbc.LabelIndex = newc.LabelIndex
+		dbc.RestartOnResize = newc.RestartOnResize
 		dbc.StitchID = newc.StitchID
+		dbc.RolloutAfter = newc.RolloutAfter
+		dbc.DesiredState = db.DesiredRunning
 		view.Commit(dbc)
 	}
 }
+
+var timeNow = time.Now
+
+// deferRollouts keeps the current image of the containers whose only change is a new
+// image, unless the maintenance window is open at `now`.  The containers are marked
+// with when the window next opens, at which point the new image is rolled out.
+func deferRollouts(specContainers, dbcs []db.Container,
+	window stitch.MaintenanceWindow, now time.Time) []db.Container {
+
+	if window.Open(now) {
+		return specContainers
+	}
+
+	current := map[int]db.Container{}
+	for _, dbc := range dbcs {
+		current[dbc.StitchID] = dbc
+	}
+
+	next := window.NextOpen(now)
+	for i, c := range specContainers {
+		dbc, ok := current[c.StitchID]
+		if !ok || dbc.Image == c.Image {
+			continue
+		}
+
+		// Other changes restart the container anyway, so there's nothing to
+		// gain by keeping the old image.
+		old := c
+		old.Image = dbc.Image
+		if old.ConfigKey() != dbc.ConfigKey() {
+			continue
+		}
+
+		old.RolloutAfter = next
+		specContainers[i] = old
+	}
+	return specContainers
+}
@@ -1,6 +1,7 @@
 package minion
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
 	"time"
@@ -83,6 +84,22 @@ func TestContainerTxn(t *testing.T) {
 
 	testContainerTxn(t, conn, spec)
 	assert.False(t, fired(trigg))
+
+	spec = `deployment.deploy(new Service("a", [
+		new Container("alpine").withFiles({"/etc/a.conf": "a"})
+	]))`
+	testContainerTxn(t, conn, spec)
+	assert.True(t, fired(trigg))
+
+	// Changing the contents of a file must replace the container.
+	spec = `deployment.deploy(new Service("a", [
+		new Container("alpine").withFiles({"/etc/a.conf": "changed"})
+	]))`
+	testContainerTxn(t, conn, spec)
+	assert.True(t, fired(trigg))
+
+	testContainerTxn(t, conn, spec)
+	assert.False(t, fired(trigg))
 }
 
 func testContainerTxn(t *testing.T, conn db.Conn, spec string) {
@@ -101,6 +118,8 @@ func testContainerTxn(t *testing.T, conn db.Conn, spec string) {
 		for i, c := range containers {
 			if e.Image == c.Image &&
 				reflect.DeepEqual(e.Command, c.Command) &&
+				util.StrStrMapEqual(e.FilepathToContent,
+					c.FilepathToContent) &&
 				util.EditDistance(c.Labels, e.Labels) == 0 {
 				containers = append(containers[:i], containers[i+1:]...)
 				found = true
@@ -114,6 +133,255 @@ func testContainerTxn(t *testing.T, conn db.Conn, spec string) {
 	assert.Empty(t, containers)
 }
 
+func TestContainerTxnRoundTrip(t *testing.T) {
+	conn := db.New()
+	trigg := conn.Trigger(db.ContainerTable).C
+
+	compiled, err := stitch.FromJavascript(`deployment.deploy(
+		new Service("a", [
+			new Container("alpine", ["tail"]).withEnv({
+				"c": "3", "a": "1", "b": "2"}),
+			new Container("alpine").withFiles({"/etc/foo": "foo"})
+		])
+	)`, stitch.DefaultImportGetter)
+	assert.NoError(t, err)
+
+	// Round tripping the spec through JSON doesn't change it.
+	roundTripped, err := stitch.FromJSON(compiled.String())
+	assert.NoError(t, err)
+	assert.Equal(t, compiled, roundTripped)
+
+	getContainers := func(spec string) []db.Container {
+		var containers []db.Container
+		conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+			updatePolicy(view, db.Master, spec)
+			containers = view.SelectFromContainer(nil)
+			return nil
+		})
+		return db.SortContainers(containers)
+	}
+
+	containers := getContainers(compiled.String())
+	assert.Len(t, containers, 2)
+	assert.True(t, fired(trigg))
+
+	assert.Equal(t, containers, getContainers(roundTripped.String()))
+	assert.False(t, fired(trigg))
+
+	// Nil and empty collections don't change the containers either.
+	for i := range roundTripped.Containers {
+		c := &roundTripped.Containers[i]
+		if len(c.Command) == 0 {
+			c.Command = nil
+		}
+		if len(c.Env) == 0 {
+			c.Env = nil
+		}
+		if len(c.FilepathToContent) == 0 {
+			c.FilepathToContent = nil
+		}
+	}
+	assert.Equal(t, containers, getContainers(roundTripped.String()))
+	assert.False(t, fired(trigg))
+}
+
+func TestContainerTxnNetworkMode(t *testing.T) {
+	conn := db.New()
+
+	getContainers := func(spec string) []db.Container {
+		compiled, err := stitch.FromJavascript(spec, stitch.DefaultImportGetter)
+		assert.NoError(t, err)
+
+		var containers []db.Container
+		conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+			updatePolicy(view, db.Master, compiled.String())
+			containers = view.SelectFromContainer(nil)
+			return nil
+		})
+		return db.SortContainers(containers)
+	}
+
+	containers := getContainers(`deployment.deploy(new Service("a", [
+		new Container("alpine"),
+		new Container("alpine").withNetworkMode("host"),
+		new Container("alpine").withNetworkMode("none")]));`)
+	assert.Len(t, containers, 3)
+	assert.Equal(t, "", containers[0].NetworkMode)
+	assert.Equal(t, "host", containers[1].NetworkMode)
+	assert.Equal(t, "none", containers[2].NetworkMode)
+
+	// Changing a container's network mode replaces it.
+	changed := getContainers(`deployment.deploy(new Service("a", [
+		new Container("alpine").withNetworkMode("host")]));`)
+	assert.Len(t, changed, 1)
+	assert.Equal(t, "host", changed[0].NetworkMode)
+	assert.Equal(t, containers[1].ID, changed[0].ID)
+
+	changed = getContainers(`deployment.deploy(new Service("a", [
+		new Container("alpine")]));`)
+	assert.Len(t, changed, 1)
+	assert.Equal(t, "", changed[0].NetworkMode)
+	assert.NotEqual(t, containers[1].ID, changed[0].ID)
+}
+
+func TestContainerTxnCanary(t *testing.T) {
+	conn := db.New()
+
+	getContainers := func(spec stitch.Stitch) []db.Container {
+		var containers []db.Container
+		conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+			updatePolicy(view, db.Master, spec.String())
+			containers = view.SelectFromContainer(nil)
+			return nil
+		})
+		return db.SortContainers(containers)
+	}
+
+	spec, err := stitch.FromJavascript(`var app = new Service("app",
+		new Container("app:v1").replicate(2));
+	app.canary({image: "app:v2"});
+	deployment.deploy(app);`, stitch.DefaultImportGetter)
+	assert.NoError(t, err)
+
+	containers := getContainers(spec)
+	assert.Len(t, containers, 3)
+	assert.False(t, containers[0].Canary)
+	assert.False(t, containers[1].Canary)
+	assert.True(t, containers[2].Canary)
+	assert.Equal(t, "app:v2", containers[2].Image)
+
+	// Promoting the canary leaves it running, and replaces the rest.
+	promoted, err := spec.PromoteCanary("app")
+	assert.NoError(t, err)
+	changed := getContainers(promoted)
+	assert.Len(t, changed, 3)
+	var ids []int
+	for _, dbc := range changed {
+		assert.Equal(t, "app:v2", dbc.Image)
+		assert.False(t, dbc.Canary)
+		ids = append(ids, dbc.ID)
+	}
+	assert.Contains(t, ids, containers[2].ID)
+	assert.NotContains(t, ids, containers[0].ID)
+	assert.NotContains(t, ids, containers[1].ID)
+
+	// Aborting the canary only removes the canary.
+	getContainers(spec)
+	aborted, err := spec.AbortCanary("app")
+	assert.NoError(t, err)
+	changed = getContainers(aborted)
+	assert.Len(t, changed, 2)
+	for _, dbc := range changed {
+		assert.Equal(t, "app:v1", dbc.Image)
+	}
+}
+
+func TestContainerTxnMaintenanceWindow(t *testing.T) {
+	conn := db.New()
+
+	// Wednesday, with the window closed until Saturday.
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time {
+		return time.Date(2017, 6, 7, 12, 0, 0, 0, time.UTC)
+	}
+
+	getContainers := func(code string) []db.Container {
+		spec, err := stitch.FromJavascript(code, stitch.DefaultImportGetter)
+		assert.NoError(t, err)
+
+		var containers []db.Container
+		conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+			updatePolicy(view, db.Master, spec.String())
+			containers = view.SelectFromContainer(nil)
+			return nil
+		})
+		return db.SortContainers(containers)
+	}
+
+	const window = `deployment.maintenanceWindow({days: ["Sat"],
+		start: "02:00", end: "04:00"});`
+	containers := getContainers(window + `deployment.deploy(
+		new Service("app", [new Container("app:v1")]));`)
+	assert.Len(t, containers, 1)
+
+	// A new image waits for the window.
+	deferred := getContainers(window + `deployment.deploy(
+		new Service("app", [new Container("app:v2")]));`)
+	assert.Len(t, deferred, 1)
+	assert.Equal(t, containers[0].ID, deferred[0].ID)
+	assert.Equal(t, "app:v1", deferred[0].Image)
+	assert.Equal(t, time.Date(2017, 6, 10, 2, 0, 0, 0, time.UTC),
+		deferred[0].RolloutAfter)
+
+	// The container restarts for other changes anyway, so the new image comes too.
+	changed := getContainers(window + `deployment.deploy(new Service("app",
+		[new Container("app:v2").withEnv({"key": "value"})]));`)
+	assert.Len(t, changed, 1)
+	assert.Equal(t, "app:v2", changed[0].Image)
+	assert.True(t, changed[0].RolloutAfter.IsZero())
+
+	// Without a window, the rollout isn't deferred.
+	getContainers(window + `deployment.deploy(new Service("app",
+		[new Container("app:v1").withEnv({"key": "value"})]));`)
+	changed = getContainers(`deployment.deploy(new Service("app",
+		[new Container("app:v2").withEnv({"key": "value"})]));`)
+	assert.Len(t, changed, 1)
+	assert.Equal(t, "app:v2", changed[0].Image)
+}
+
+func TestContainerTxnLabelSize(t *testing.T) {
+	conn := db.New()
+
+	getContainers := func(spec string) []db.Container {
+		compiled, err := stitch.FromJavascript(spec, stitch.DefaultImportGetter)
+		assert.NoError(t, err)
+
+		var containers []db.Container
+		conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+			updatePolicy(view, db.Master, compiled.String())
+			containers = view.SelectFromContainer(nil)
+			return nil
+		})
+		return db.SortContainers(containers)
+	}
+
+	deploy := `var a = new Service("a", new Container("alpine").replicate(%d));
+	a.annotate("%s");
+	deployment.deploy(a);`
+
+	containers := getContainers(fmt.Sprintf(deploy, 2, stitch.LabelSizeAnnotation))
+	assert.Len(t, containers, 2)
+	indices := map[int]struct{}{}
+	for _, dbc := range containers {
+		assert.Equal(t, 2, dbc.LabelSize)
+		assert.False(t, dbc.RestartOnResize)
+		indices[dbc.LabelIndex] = struct{}{}
+	}
+	assert.Equal(t, map[int]struct{}{0: {}, 1: {}}, indices)
+
+	// Growing the label updates the snapshot without replacing the containers.
+	resized := getContainers(fmt.Sprintf(deploy, 3, stitch.LabelSizeAnnotation))
+	assert.Len(t, resized, 3)
+	for _, dbc := range resized[:2] {
+		assert.Equal(t, 3, dbc.LabelSize)
+	}
+	assert.Equal(t, containers[0].ID, resized[0].ID)
+	assert.Equal(t, containers[1].ID, resized[1].ID)
+
+	// Unless the label asks for its containers to be restarted.
+	containers = getContainers(fmt.Sprintf(deploy, 3,
+		stitch.LabelSizeRestartAnnotation))
+	resized = getContainers(fmt.Sprintf(deploy, 2,
+		stitch.LabelSizeRestartAnnotation))
+	assert.Len(t, resized, 2)
+	for _, dbc := range resized {
+		assert.True(t, dbc.RestartOnResize)
+		for _, old := range containers {
+			assert.NotEqual(t, old.ID, dbc.ID)
+		}
+	}
+}
+
 func TestConnectionTxn(t *testing.T) {
 	conn := db.New()
 	trigg := conn.Trigger(db.ConnectionTable).C
@@ -141,6 +409,20 @@ func TestConnectionTxn(t *testing.T) {
 	testConnectionTxn(t, conn, spec)
 	assert.False(t, fired(trigg))
 
+	spec = pre + `a.connect(90, a, {dscp: 46});`
+	testConnectionTxn(t, conn, spec)
+	assert.True(t, fired(trigg))
+
+	testConnectionTxn(t, conn, spec)
+	assert.False(t, fired(trigg))
+
+	spec = pre + `a.connect(90, a, {dscp: 46, allowCrossNetwork: true});`
+	testConnectionTxn(t, conn, spec)
+	assert.True(t, fired(trigg))
+
+	testConnectionTxn(t, conn, spec)
+	assert.False(t, fired(trigg))
+
 	spec = pre + `b.connect(90, a);
 	b.connect(90, c);
 	b.connect(100, b);
@@ -157,6 +439,11 @@ func TestConnectionTxn(t *testing.T) {
 
 	testConnectionTxn(t, conn, spec)
 	assert.False(t, fired(trigg))
+
+	// Host networked connections are left to the workers' firewalls.
+	spec = pre + `deployment.openHostPort(8080);`
+	testConnectionTxn(t, conn, spec)
+	assert.False(t, fired(trigg))
 }
 
 func testConnectionTxn(t *testing.T, conn db.Conn, spec string) {
@@ -172,10 +459,14 @@ func testConnectionTxn(t *testing.T, conn db.Conn, spec string) {
 
 	exp := compiled.Connections
 	for _, e := range exp {
+		if e.HostNetwork {
+			continue
+		}
+
 		found := false
 		for i, c := range connections {
 			if e.From == c.From && e.To == c.To && e.MinPort == c.MinPort &&
-				e.MaxPort == c.MaxPort {
+				e.MaxPort == c.MaxPort && e.DSCP == c.DSCP {
 				connections = append(
 					connections[:i], connections[i+1:]...)
 				found = true
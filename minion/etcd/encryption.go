@@ -0,0 +1,170 @@
+package etcd
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The leader publishes the state of tunnel encryption to encryptionStore.  It holds no
+// key material: the workers derive each tunnel's key from the keys of its two ends.
+const encryptionStore = minionDir + "/encryption"
+
+// Stored in variables so they can be changed in the unit tests.
+var (
+	keyRotationInterval = 24 * time.Hour
+	timeNow             = time.Now
+)
+
+type encryptionState struct {
+	Status db.EncryptionStatus
+
+	// The generation of keys the tunnels are encrypted with, and the generation
+	// staged to replace it.
+	Generation int
+	Next       int
+
+	Created time.Time // When Generation was switched to.
+}
+
+func runEncryption(conn db.Conn, store Store) {
+	// Ignore the error, which just means another minion already created the state.
+	store.Create(encryptionStore, "{}", 0)
+
+	watch := store.Watch(encryptionStore, 1*time.Second)
+	trigg := conn.TriggerTick(60, db.MinionTable, db.EtcdTable)
+	for {
+		updateEncryption(conn, store)
+
+		select {
+		case <-watch:
+		case <-trigg.C:
+		}
+	}
+}
+
+// updateEncryption publishes the encryption state if this minion is the leader, and
+// records the published state in the Etcd table.
+func updateEncryption(conn db.Conn, store Store) {
+	stateStr, err := store.Get(encryptionStore)
+	if err != nil {
+		log.WithError(err).Warn("Failed to read encryption state from Etcd.")
+		return
+	}
+
+	var state encryptionState
+	if err := json.Unmarshal([]byte(stateStr), &state); err != nil {
+		log.WithError(err).Warn("Failed to parse encryption state.")
+		return
+	}
+
+	if conn.EtcdLeader() {
+		next := nextEncryptionState(encryptionRequested(conn),
+			conn.SelectFromMinion(nil), state, timeNow())
+		if next != state {
+			js, err := json.Marshal(next)
+			if err != nil {
+				panic("Failed to convert encryption state to JSON")
+			}
+
+			if err := store.Set(encryptionStore, string(js), 0); err != nil {
+				log.WithError(err).Warn(
+					"Failed to write encryption state to Etcd.")
+				return
+			}
+			state = next
+		}
+	}
+
+	conn.Txn(db.EtcdTable).Run(func(view db.Database) error {
+		etcdRows := view.SelectFromEtcd(nil)
+		if len(etcdRows) == 1 {
+			etcdRows[0].Encryption = state.Status
+			etcdRows[0].EncryptionGeneration = state.Generation
+			etcdRows[0].EncryptionNext = state.Next
+			view.Commit(etcdRows[0])
+		}
+		return nil
+	})
+}
+
+func encryptionRequested(conn db.Conn) bool {
+	self, err := conn.MinionSelf()
+	if err != nil || self.Spec == "" {
+		return false
+	}
+
+	spec, err := stitch.FromJSON(self.Spec)
+	if err != nil {
+		log.WithError(err).Warn("Failed to parse spec.")
+		return false
+	}
+	return spec.EncryptTraffic
+}
+
+// nextEncryptionState decides how the workers should encrypt their tunnels.  So that
+// a rollout never partitions the network, encryption is only enabled once every
+// worker supports it.  Until then, none of the tunnels are encrypted.  Once enabled, a
+// worker that stops supporting it, even briefly, only pauses the rotation: the other
+// tunnels keep their keys, rather than all falling back to the clear.
+//
+// Keys are rotated in stages.  First the next generation is staged, and each worker
+// publishes a key of that generation while the tunnels keep using the current one.
+// Only once every worker has published its key do the tunnels switch generations, and
+// the workers keep the keys of the previous generation for peers that lag behind.
+func nextEncryptionState(requested bool, minions []db.Minion,
+	current encryptionState, now time.Time) encryptionState {
+
+	if !requested {
+		return encryptionState{Status: db.EncryptionDisabled}
+	}
+
+	var workers []db.Minion
+	for _, m := range minions {
+		if m.Role != db.Worker {
+			continue
+		}
+
+		if !m.EncryptionSupported {
+			if current.Generation == 0 {
+				return encryptionState{Status: db.EncryptionPending}
+			}
+
+			current.Status = db.EncryptionDegraded
+			return current
+		}
+		workers = append(workers, m)
+	}
+
+	next := current
+	if next.Next == 0 && (next.Generation == 0 ||
+		now.Sub(next.Created) >= keyRotationInterval) {
+		next.Next = next.Generation + 1
+	}
+
+	if allPublished(workers, next.Next) {
+		next.Generation = next.Next
+		next.Next = 0
+		next.Created = now
+	}
+
+	next.Status = db.EncryptionPending
+	if next.Generation != 0 {
+		next.Status = db.EncryptionEnabled
+	}
+	return next
+}
+
+// allPublished returns whether every worker has published its key of `generation`.
+func allPublished(workers []db.Minion, generation int) bool {
+	for _, m := range workers {
+		if _, ok := m.EncryptionKeys[generation]; !ok {
+			return false
+		}
+	}
+	return true
+}
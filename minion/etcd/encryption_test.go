@@ -0,0 +1,155 @@
+package etcd
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextEncryptionState(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	worker := func(generations ...int) db.Minion {
+		keys := map[int]string{}
+		for _, g := range generations {
+			keys[g] = "key"
+		}
+		return db.Minion{Role: db.Worker, EncryptionSupported: true,
+			EncryptionKeys: keys}
+	}
+	unsupported := db.Minion{Role: db.Worker}
+	master := db.Minion{Role: db.Master}
+
+	state := nextEncryptionState(false, []db.Minion{worker()},
+		encryptionState{}, now)
+	assert.Equal(t, encryptionState{Status: db.EncryptionDisabled}, state)
+
+	// The first generation is staged, and encryption stays pending until every
+	// worker has published its key.  Masters don't tunnel, so they needn't.
+	minions := []db.Minion{worker(1), worker(), master}
+	state = nextEncryptionState(true, minions, encryptionState{}, now)
+	assert.Equal(t, encryptionState{Status: db.EncryptionPending, Next: 1}, state)
+
+	minions = []db.Minion{worker(1), worker(1), master}
+	state = nextEncryptionState(true, minions, state, now)
+	assert.Equal(t, encryptionState{Status: db.EncryptionEnabled, Generation: 1,
+		Created: now}, state)
+
+	// The generation is kept until it's due to be rotated.
+	later := now.Add(keyRotationInterval / 2)
+	assert.Equal(t, state, nextEncryptionState(true, minions, state, later))
+
+	// The rotation is staged, and the tunnels keep the current generation until
+	// every worker has a key of the next.
+	later = now.Add(keyRotationInterval)
+	minions = []db.Minion{worker(1, 2), worker(1), master}
+	staged := nextEncryptionState(true, minions, state, later)
+	assert.Equal(t, encryptionState{Status: db.EncryptionEnabled, Generation: 1,
+		Next: 2, Created: now}, staged)
+	assert.Equal(t, staged, nextEncryptionState(true, minions, staged,
+		later.Add(time.Minute)))
+
+	minions = []db.Minion{worker(1, 2), worker(1, 2), master}
+	rotated := nextEncryptionState(true, minions, staged, later)
+	assert.Equal(t, encryptionState{Status: db.EncryptionEnabled, Generation: 2,
+		Created: later}, rotated)
+
+	// A worker that loses support keeps the current generation, and pauses the
+	// rotation, until it recovers.
+	degraded := append(minions, unsupported)
+	state = nextEncryptionState(true, degraded, rotated,
+		later.Add(2*keyRotationInterval))
+	assert.Equal(t, encryptionState{Status: db.EncryptionDegraded, Generation: 2,
+		Created: later}, state)
+
+	minions = append(minions, worker(2))
+	state = nextEncryptionState(true, minions, state, later)
+	assert.Equal(t, rotated, state)
+
+	// Before encryption is enabled, a worker without support holds it back.
+	minions = []db.Minion{worker(1), unsupported}
+	state = nextEncryptionState(true, minions, encryptionState{}, now)
+	assert.Equal(t, encryptionState{Status: db.EncryptionPending}, state)
+}
+
+func TestUpdateEncryption(t *testing.T) {
+	t.Parallel()
+
+	conn := db.New()
+	store := NewMock()
+	store.Set(encryptionStore, "{}", 0)
+
+	spec := stitch.Stitch{EncryptTraffic: true}.String()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		self := view.InsertMinion()
+		self.Self = true
+		self.Role = db.Master
+		self.Spec = spec
+		view.Commit(self)
+
+		worker := view.InsertMinion()
+		worker.Role = db.Worker
+		view.Commit(worker)
+
+		view.Commit(view.InsertEtcd())
+		return nil
+	})
+
+	getEtcd := func() db.Etcd {
+		return conn.SelectFromEtcd(nil)[0]
+	}
+
+	// Only the leader publishes the encryption state.
+	updateEncryption(conn, store)
+	assert.Equal(t, db.EncryptionDisabled, getEtcd().Encryption)
+
+	conn.Txn(db.EtcdTable).Run(func(view db.Database) error {
+		etcd := view.SelectFromEtcd(nil)[0]
+		etcd.Leader = true
+		view.Commit(etcd)
+		return nil
+	})
+
+	// The worker doesn't support encryption yet.
+	updateEncryption(conn, store)
+	assert.Equal(t, db.EncryptionPending, getEtcd().Encryption)
+	assert.Zero(t, getEtcd().EncryptionNext)
+
+	setWorker := func(update func(*db.Minion)) {
+		conn.Txn(db.MinionTable).Run(func(view db.Database) error {
+			worker := view.SelectFromMinion(func(m db.Minion) bool {
+				return !m.Self
+			})[0]
+			update(&worker)
+			view.Commit(worker)
+			return nil
+		})
+	}
+	setWorker(func(m *db.Minion) { m.EncryptionSupported = true })
+
+	updateEncryption(conn, store)
+	etcd := getEtcd()
+	assert.Equal(t, db.EncryptionPending, etcd.Encryption)
+	assert.Equal(t, 1, etcd.EncryptionNext)
+
+	setWorker(func(m *db.Minion) { m.EncryptionKeys = map[int]string{1: "public"} })
+	updateEncryption(conn, store)
+	etcd = getEtcd()
+	assert.Equal(t, db.EncryptionEnabled, etcd.Encryption)
+	assert.Equal(t, 1, etcd.EncryptionGeneration)
+	assert.Zero(t, etcd.EncryptionNext)
+
+	// The published state holds no key material.
+	stateStr, err := store.Get(encryptionStore)
+	assert.NoError(t, err)
+	assert.NotContains(t, stateStr, "public")
+
+	var published encryptionState
+	assert.NoError(t, json.Unmarshal([]byte(stateStr), &published))
+	assert.Equal(t, 1, published.Generation)
+}
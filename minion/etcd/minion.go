@@ -124,13 +124,14 @@ func filterSelf(dbMinions, storeMinions []db.Minion) ([]db.Minion, []db.Minion)
 }
 
 func diffMinion(dbMinions, storeMinions []db.Minion) (del, add []db.Minion) {
+	// Minions are stored in etcd as JSON, so those with the same encoding match.
+	// The rows themselves can't be hashed, as they hold maps.
 	key := func(iface interface{}) interface{} {
-		m := iface.(db.Minion)
-		m.ID = 0
-		m.Spec = ""
-		m.Self = false
-		m.AuthorizedKeys = ""
-		return m
+		js, err := json.Marshal(iface.(db.Minion))
+		if err != nil {
+			panic("Failed to convert Minion to JSON")
+		}
+		return string(js)
 	}
 
 	_, lefts, rights := join.HashJoin(db.MinionSlice(dbMinions),
@@ -196,7 +197,14 @@ func updateSubnet(conn db.Conn, store Store, minion db.Minion) db.Minion {
 			panic("Invalid minion subnet: " + err.Error())
 		}
 
-		err = store.Refresh(subnetKey(*subnet), minion.PrivateIP, subnetTTL)
+		// Minions that predate the network subnets may hold a subnet that's
+		// now reserved for the networks.
+		if ipdef.MinionSubnets.Contains(subnet.IP) {
+			err = store.Refresh(subnetKey(*subnet), minion.PrivateIP,
+				subnetTTL)
+		} else {
+			err = errors.New("subnet is reserved for networks")
+		}
 		if err == nil {
 			return minion
 		}
@@ -249,8 +257,8 @@ func syncSubnet(conn db.Conn, store Store, minion db.Minion) {
 
 func randomMinionSubnet() net.IPNet {
 	submask := binary.BigEndian.Uint32(ipdef.SubMask)
-	quiltmask := binary.BigEndian.Uint32(ipdef.QuiltSubnet.Mask)
-	subnetBits := submask ^ quiltmask
+	minionsmask := binary.BigEndian.Uint32(ipdef.MinionSubnets.Mask)
+	subnetBits := submask ^ minionsmask
 
 	// Reserve the 0 subnet for labels
 	randomSubnet := uint32(0)
@@ -259,7 +267,7 @@ func randomMinionSubnet() net.IPNet {
 	}
 
 	ipNet := net.IPNet{IP: make([]byte, 4), Mask: ipdef.SubMask}
-	ip32 := binary.BigEndian.Uint32(ipdef.QuiltSubnet.IP) | randomSubnet
+	ip32 := binary.BigEndian.Uint32(ipdef.MinionSubnets.IP) | randomSubnet
 	binary.BigEndian.PutUint32(ipNet.IP, ip32)
 	return ipNet
 }
@@ -58,7 +58,9 @@ func TestWriteMinion(t *testing.T) {
 	assert.Nil(t, err)
 
 	expVal := `{"Role":"Master","PrivateIP":"1.2.3.4",` +
-		`"Provider":"Amazon","Size":"Big","Region":"Somewhere"}`
+		`"Provider":"Amazon","Size":"Big","Region":"Somewhere","Arch":"",` +
+		`"DedicatedTo":"","FloatingIP":"","Draining":false,"Sysctls":"",` +
+		`"EncryptionSupported":false}`
 	assert.Equal(t, expVal, val)
 }
 
@@ -128,16 +130,16 @@ func TestFilter(t *testing.T) {
 }
 
 // Test that GenerateSubnet generates valid subnets, and passively test that it can
-// generate enough unique subnets by generating 3500 out of the possible 4095
+// generate enough unique subnets by generating 1750 out of the possible 2047
 func TestGenerateSubnet(t *testing.T) {
 	store := newTestMock()
 	minionMaskBits, _ := ipdef.SubMask.Size()
 	emptyBits := uint32(0xffffffff >> uint(minionMaskBits))
-	for i := 0; i < 3500; i++ {
+	for i := 0; i < 1750; i++ {
 		subnet, err := generateSubnet(store, db.Minion{PrivateIP: "10.1.0.1"})
 		require.Nil(t, err)
 
-		require.True(t, ipdef.QuiltSubnet.Contains(subnet.IP))
+		require.True(t, ipdef.MinionSubnets.Contains(subnet.IP))
 		require.NotEqual(t, subnet.IP, ipdef.LabelSubnet.IP)
 		require.Equal(t, subnet.Mask, ipdef.SubMask)
 
@@ -246,6 +248,18 @@ func TestUpdateSubnet(t *testing.T) {
 	minion, err = store.Get(path.Join(subnetStore, thirdSubnet))
 	assert.Nil(t, err)
 	assert.Equal(t, "1.2.3.4", minion)
+
+	// Subnets reserved for the isolated networks are given up.
+	m.Subnet = "10.128.16.0/20"
+	done = timeUpdateSubnet()
+	timer = time.After(100 * time.Millisecond)
+	select {
+	case <-timer:
+		t.Fatal("Timed out syncing subnet")
+	case <-done:
+		break
+	}
+	assert.Equal(t, "10.0.64.0/20", m.Subnet)
 }
 
 func randMinion() db.Minion {
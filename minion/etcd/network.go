@@ -6,11 +6,13 @@ import (
 	"errors"
 	"net"
 	"path"
+	"reflect"
 	"sort"
 	"strconv"
 	"time"
 
 	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/faults"
 	"github.com/NetSys/quilt/join"
 	"github.com/NetSys/quilt/minion/ipdef"
 	"github.com/NetSys/quilt/util"
@@ -24,7 +26,16 @@ const (
 	labelToIPStore = minionDir + "/labelIP"
 	containerStore = minionDir + "/container"
 	nodeStore      = minionDir + "/nodes"
-	minionIPStore  = "ips"
+
+	// Incremented each time a leader republishes the network state.
+	generationStore = minionDir + "/generation"
+
+	// Each worker stores maps from the stitch IDs of its containers to their IPs
+	// and Docker statuses in its node directory, along with a map from the Docker
+	// IDs of the containers it's tearing down to their JSON descriptions.
+	minionIPStore     = "ips"
+	minionStatusStore = "status"
+	minionOrphanStore = "orphans"
 )
 
 // Keeping all the store data types in a struct makes it much less verbose to pass them
@@ -32,6 +43,7 @@ const (
 type storeData struct {
 	containers []storeContainer
 	multiHost  map[string]string
+	generation int
 }
 
 type storeContainer struct {
@@ -41,6 +53,16 @@ type storeContainer struct {
 	Image   string
 	Command []string
 	Env     map[string]string
+	ShmSize int
+	Init    bool
+
+	StopSignal        string
+	NetworkMode       string
+	FilepathToContent map[string]string
+
+	LabelSize       int
+	LabelIndex      int
+	RestartOnResize bool
 
 	Labels []string
 }
@@ -73,61 +95,96 @@ func wakeChan(conn db.Conn, store Store) chan struct{} {
 }
 
 func runNetwork(conn db.Conn, store Store) {
+	var synced bool
 	for range wakeChan(conn, store) {
-		// If the etcd read failed, we only want to update the db if it
-		// failed because a key was missing (has not been created yet).
-		// In all other cases, we skip this iteration.
-		etcdData, err := readEtcd(store)
+		if faults.DropEtcdSync() {
+			log.Warn("Dropping etcd sync due to an injected fault.")
+			continue
+		}
+		synced = updateNetwork(conn, store, synced)
+	}
+}
+
+// updateNetwork performs a single round of synchronization between the database and
+// etcd.  It returns whether this minion is the leader and successfully wrote the
+// network state to etcd.  If `synced` is false, the leader republishes the entire
+// network state rather than just what has changed, so that a newly elected leader
+// immediately overwrites whatever its predecessor left behind.
+func updateNetwork(conn db.Conn, store Store, synced bool) bool {
+	// If the etcd read failed, we only want to update the db if it
+	// failed because a key was missing (has not been created yet).
+	// In all other cases, we skip this iteration.
+	etcdData, err := readEtcd(store)
+	if err != nil {
+		etcdErr, ok := err.(client.Error)
+		if !ok || etcdErr.Code != client.ErrorCodeKeyNotFound {
+			log.WithError(err).Error("Etcd transaction failed.")
+			return synced
+		}
+		log.WithError(err).Debug()
+	}
+
+	leaderSynced := false
+	conn.Txn(db.ContainerTable, db.EtcdTable, db.LabelTable,
+		db.MinionTable).Run(func(view db.Database) error {
+
+		leader := view.EtcdLeader()
+		containers := view.SelectFromContainer(func(c db.Container) bool {
+			return c.Minion != ""
+		})
+
+		minion, err := view.MinionSelf()
+		if err == nil && minion.Role == db.Worker {
+			updateWorker(view, minion, store, etcdData)
+		}
+
+		ipMap, err := loadWorkerMap(store, minionIPStore)
 		if err != nil {
-			etcdErr, ok := err.(client.Error)
-			if !ok || etcdErr.Code != client.ErrorCodeKeyNotFound {
-				log.WithError(err).Error("Etcd transaction failed.")
-				continue
-			}
-			log.WithError(err).Debug()
+			log.WithError(err).Error("Etcd read minion IPs failed")
+			return nil
 		}
 
-		leader := false
-		var containers []db.Container
-		conn.Txn(db.ContainerTable, db.EtcdTable, db.LabelTable,
-			db.MinionTable).Run(func(view db.Database) error {
+		statusMap, err := loadWorkerMap(store, minionStatusStore)
+		if err != nil {
+			log.WithError(err).Error("Etcd read container statuses failed")
+			return nil
+		}
 
-			leader = view.EtcdLeader()
-			containers = view.SelectFromContainer(func(c db.Container) bool {
-				return c.Minion != ""
-			})
+		orphanMap, err := loadWorkerMap(store, minionOrphanStore)
+		if err != nil {
+			log.WithError(err).Error("Etcd read orphaned containers failed")
+			return nil
+		}
 
-			minion, err := view.MinionSelf()
-			if err == nil && minion.Role == db.Worker {
-				updateWorker(view, minion, store, etcdData)
+		// It would likely be more efficient to perform the etcd write
+		// outside of the DB transact. But, if we perform the writes
+		// after the transact, there is no way to ensure that the writes
+		// were successful before updating the DB with the information
+		// produced by the updateEtcd* functions (not considering the
+		// etcd writes they perform).
+		if leader {
+			if synced {
+				etcdData, err = updateEtcd(store, etcdData, containers)
+			} else {
+				log.Info("Republishing the network state to etcd.")
+				etcdData, err = republishEtcd(store, etcdData, containers)
 			}
 
-			ipMap, err := loadMinionIPs(store)
 			if err != nil {
-				log.WithError(err).Error("Etcd read minion IPs failed")
+				log.WithError(err).Error("Etcd update failed.")
 				return nil
 			}
 
-			// It would likely be more efficient to perform the etcd write
-			// outside of the DB transact. But, if we perform the writes
-			// after the transact, there is no way to ensure that the writes
-			// were successful before updating the DB with the information
-			// produced by the updateEtcd* functions (not considering the
-			// etcd writes they perform).
-			if leader {
-				etcdData, err = updateEtcd(store, etcdData, containers)
-				if err != nil {
-					log.WithError(err).Error("Etcd update failed.")
-					return nil
-				}
+			leaderSynced = true
+			updateLeaderDBC(view, containers, etcdData, ipMap, statusMap)
+			updateLeaderOrphans(view, orphanMap)
+		}
 
-				updateLeaderDBC(view, containers, etcdData, ipMap)
-			}
+		updateDBLabels(view, etcdData, ipMap)
+		return nil
+	})
 
-			updateDBLabels(view, etcdData, ipMap)
-			return nil
-		})
-	}
+	return leaderSynced
 }
 
 func makeEtcdDir(dir string, store Store, ttl time.Duration) {
@@ -172,14 +229,20 @@ func readEtcd(store Store) (storeData, error) {
 	json.Unmarshal([]byte(containers), &etcdContainerSlice)
 	json.Unmarshal([]byte(labels), &multiHostMap)
 
-	return storeData{etcdContainerSlice, multiHostMap}, err
+	// The generation is missing until a leader first republishes, which is the
+	// same as generation 0.
+	generationStr, _ := store.Get(generationStore)
+	generation, _ := strconv.Atoi(generationStr)
+
+	return storeData{etcdContainerSlice, multiHostMap, generation}, err
 }
 
-func loadMinionIPs(store Store) (map[string]string, error) {
-	ipMap := map[string]string{}
+// loadWorkerMap merges the maps stored under `key` by each worker.
+func loadWorkerMap(store Store, key string) (map[string]string, error) {
+	result := map[string]string{}
 	allMinions, err := store.GetTree(nodeStore)
 	if err != nil {
-		return ipMap, err
+		return result, err
 	}
 
 	for _, t := range allMinions.Children {
@@ -193,45 +256,72 @@ func loadMinionIPs(store Store) (map[string]string, error) {
 		err := json.Unmarshal([]byte(minionData.Value), &minion)
 		if err != nil {
 			log.Errorf("Failed to unmarshal minion %s self", t.Key)
-			return ipMap, err
+			return result, err
 		}
 
 		if minion.Role != db.Worker {
 			continue
 		}
 
-		minionIPData, ok := t.Children[minionIPStore]
+		workerData, ok := t.Children[key]
 		if !ok {
-			log.Debugf("Minion %s has no IP store node", t.Key)
+			log.Debugf("Minion %s has no %s store node", t.Key, key)
 			continue
 		}
 
-		minionIPMap := map[string]string{}
-		err = json.Unmarshal([]byte(minionIPData.Value), &minionIPMap)
+		workerMap := map[string]string{}
+		err = json.Unmarshal([]byte(workerData.Value), &workerMap)
 		if err != nil {
-			log.Errorf("Failed to unmarshal minion %s IP data", t.Key)
-			return ipMap, err
+			log.Errorf("Failed to unmarshal minion %s %s data", t.Key, key)
+			return result, err
 		}
 
-		for stitchID, ipAddr := range minionIPMap {
-			ipMap[stitchID] = ipAddr
+		for stitchID, val := range workerMap {
+			result[stitchID] = val
 		}
 	}
 
-	return ipMap, nil
+	return result, nil
 }
 
 func updateEtcd(s Store, etcdData storeData,
 	containers []db.Container) (storeData, error) {
 
-	if etcdData, err := updateEtcdContainer(s, etcdData, containers); err != nil {
+	etcdData, err := updateEtcdContainer(s, etcdData, containers)
+	if err != nil {
+		return etcdData, err
+	}
+
+	return updateEtcdLabel(s, etcdData, containers)
+}
+
+// republishEtcd writes the entire network state to etcd, whether or not it appears to
+// have changed, and bumps its generation.  Workers watch the minion directory, so the
+// new generation wakes them up to resync even if nothing else differs.
+func republishEtcd(s Store, etcdData storeData,
+	containers []db.Container) (storeData, error) {
+
+	etcdData, err := updateEtcd(s, etcdData, containers)
+	if err != nil {
+		return etcdData, err
+	}
+
+	containerJSON, _ := json.Marshal(etcdData.containers)
+	if err := s.Set(containerStore, string(containerJSON), 0); err != nil {
+		return etcdData, err
+	}
+
+	labelJSON, _ := json.Marshal(etcdData.multiHost)
+	if err := s.Set(labelToIPStore, string(labelJSON), 0); err != nil {
 		return etcdData, err
 	}
 
-	if etcdData, err := updateEtcdLabel(s, etcdData, containers); err != nil {
+	generation := strconv.Itoa(etcdData.generation + 1)
+	if err := s.Set(generationStore, generation, 0); err != nil {
 		return etcdData, err
 	}
 
+	etcdData.generation++
 	return etcdData, nil
 }
 
@@ -245,6 +335,16 @@ func dbSliceToStoreSlice(dbcs []db.Container) []storeContainer {
 			Command:  c.Command,
 			Labels:   c.Labels,
 			Env:      c.Env,
+			ShmSize:  c.ShmSize,
+			Init:     c.Init,
+
+			StopSignal:        c.StopSignal,
+			NetworkMode:       c.NetworkMode,
+			FilepathToContent: c.FilepathToContent,
+
+			LabelSize:       c.LabelSize,
+			LabelIndex:      c.LabelIndex,
+			RestartOnResize: c.RestartOnResize,
 		}
 		dbContainerSlice = append(dbContainerSlice, sc)
 	}
@@ -324,19 +424,52 @@ func updateEtcdLabel(s Store, etcdData storeData, containers []db.Container) (st
 }
 
 func updateLeaderDBC(view db.Database, dbcs []db.Container,
-	etcdData storeData, ipMap map[string]string) {
+	etcdData storeData, ipMap, statusMap map[string]string) {
 
 	for _, dbc := range dbcs {
-		ipVal := ipMap[strconv.Itoa(dbc.StitchID)]
+		id := strconv.Itoa(dbc.StitchID)
+		ipVal := ipMap[id]
 		mac := ipdef.IPStrToMac(ipVal)
-		if dbc.IP != ipVal || dbc.Mac != mac {
+		status := statusMap[id]
+		if dbc.IP != ipVal || dbc.Mac != mac || dbc.ActualState != status {
 			dbc.IP = ipVal
 			dbc.Mac = mac
+			dbc.ActualState = status
 			view.Commit(dbc)
 		}
 	}
 }
 
+// updateLeaderOrphans records the containers that the workers are tearing down on the
+// leader's minion row, so that they can be listed alongside the declared containers.
+func updateLeaderOrphans(view db.Database, orphanMap map[string]string) {
+	self, err := view.MinionSelf()
+	if err != nil {
+		return
+	}
+
+	var dockerIDs []string
+	for dockerID := range orphanMap {
+		dockerIDs = append(dockerIDs, dockerID)
+	}
+	sort.Strings(dockerIDs)
+
+	var orphans []db.Container
+	for _, dockerID := range dockerIDs {
+		var orphan db.Container
+		if err := json.Unmarshal([]byte(orphanMap[dockerID]), &orphan); err != nil {
+			log.WithError(err).Warn("Malformed orphaned container.")
+			continue
+		}
+		orphans = append(orphans, orphan)
+	}
+
+	if !reflect.DeepEqual(orphans, self.Orphans) {
+		self.Orphans = orphans
+		view.Commit(self)
+	}
+}
+
 func updateWorker(view db.Database, self db.Minion, store Store,
 	etcdData storeData) {
 
@@ -356,7 +489,17 @@ func updateWorker(view db.Database, self db.Minion, store Store,
 				Image:    dbc.Image,
 				Command:  dbc.Command,
 				Env:      dbc.Env,
+				ShmSize:  dbc.ShmSize,
+				Init:     dbc.Init,
 				Labels:   dbc.Labels,
+
+				StopSignal:        dbc.StopSignal,
+				NetworkMode:       dbc.NetworkMode,
+				FilepathToContent: dbc.FilepathToContent,
+
+				LabelSize:       dbc.LabelSize,
+				LabelIndex:      dbc.LabelIndex,
+				RestartOnResize: dbc.RestartOnResize,
 			}
 			return containerJoinScore(l, right.(storeContainer))
 		})
@@ -382,46 +525,71 @@ func updateWorker(view db.Database, self db.Minion, store Store,
 		dbc.Image = etcdc.Image
 		dbc.Command = etcdc.Command
 		dbc.Env = etcdc.Env
+		dbc.ShmSize = etcdc.ShmSize
+		dbc.Init = etcdc.Init
+		dbc.StopSignal = etcdc.StopSignal
+		dbc.NetworkMode = etcdc.NetworkMode
+		dbc.FilepathToContent = etcdc.FilepathToContent
 		dbc.Labels = etcdc.Labels
+		dbc.DesiredState = db.DesiredRunning
+		dbc.LabelSize = etcdc.LabelSize
+		dbc.LabelIndex = etcdc.LabelIndex
+		dbc.RestartOnResize = etcdc.RestartOnResize
 
 		view.Commit(dbc)
 	}
 
-	updateContainerIP(view.SelectFromContainer(nil), self.PrivateIP, store)
+	ipMap := map[string]string{}
+	statusMap := map[string]string{}
+	for _, dbc := range view.SelectFromContainer(nil) {
+		ipMap[strconv.Itoa(dbc.StitchID)] = dbc.IP
+		statusMap[strconv.Itoa(dbc.StitchID)] = dbc.ActualState
+	}
+
+	orphanMap := map[string]string{}
+	for _, orphan := range self.Orphans {
+		orphanJSON, err := json.Marshal(orphan)
+		if err != nil {
+			log.WithError(err).Error("Failed to marshal orphaned container")
+			continue
+		}
+		orphanMap[orphan.DockerID] = string(orphanJSON)
+	}
+
+	writeWorkerMap(store, self.PrivateIP, minionIPStore, ipMap)
+	writeWorkerMap(store, self.PrivateIP, minionStatusStore, statusMap)
+	writeWorkerMap(store, self.PrivateIP, minionOrphanStore, orphanMap)
 }
 
-func updateContainerIP(containers []db.Container, privateIP string, store Store) {
+// writeWorkerMap stores `newMap` under `key` in the node directory of the worker
+// with the given private IP, if it differs from what's already there.
+func writeWorkerMap(store Store, privateIP, key string, newMap map[string]string) {
+	storeKey := path.Join(nodeStore, privateIP, key)
+	logger := log.WithField("key", storeKey)
 
-	oldIPMap := map[string]string{}
-	selfStore := path.Join(nodeStore, privateIP)
-	etcdIPs, err := store.Get(path.Join(selfStore, minionIPStore))
+	oldMap := map[string]string{}
+	etcdData, err := store.Get(storeKey)
 	if err != nil {
 		etcdErr, ok := err.(client.Error)
 		if !ok || etcdErr.Code != client.ErrorCodeKeyNotFound {
-			log.WithError(err).Error("Failed to load current IPs from Etcd")
+			logger.WithError(err).Error("Failed to load map from Etcd")
 			return
 		}
 	}
-	json.Unmarshal([]byte(etcdIPs), &oldIPMap)
-
-	newIPMap := map[string]string{}
-	for _, c := range containers {
-		newIPMap[strconv.Itoa(c.StitchID)] = c.IP
-	}
+	json.Unmarshal([]byte(etcdData), &oldMap)
 
-	if util.StrStrMapEqual(oldIPMap, newIPMap) {
+	if util.StrStrMapEqual(oldMap, newMap) {
 		return
 	}
 
-	jsonData, err := json.Marshal(newIPMap)
+	jsonData, err := json.Marshal(newMap)
 	if err != nil {
-		log.WithError(err).Error("Failed to marshal minion container IP map")
+		logger.WithError(err).Error("Failed to marshal minion container map")
 		return
 	}
 
-	err = store.Set(path.Join(selfStore, minionIPStore), string(jsonData), 0)
-	if err != nil {
-		log.WithError(err).Error("Failed to update minion container IP map")
+	if err := store.Set(storeKey, string(jsonData), 0); err != nil {
+		logger.WithError(err).Error("Failed to update minion container map")
 	}
 }
 
@@ -530,10 +698,7 @@ func allocateIP(ipSet map[string]struct{}, subnet net.IPNet) (string, error) {
 }
 
 func containerJoinScore(left, right storeContainer) int {
-	if left.Minion != right.Minion ||
-		left.Image != right.Image ||
-		!util.StrSliceEqual(left.Command, right.Command) ||
-		!util.StrStrMapEqual(left.Env, right.Env) {
+	if left.Minion != right.Minion || left.configKey() != right.configKey() {
 		return -1
 	}
 
@@ -544,6 +709,24 @@ func containerJoinScore(left, right storeContainer) int {
 	return score
 }
 
+func (sc storeContainer) configKey() string {
+	return db.Container{
+		Image:   sc.Image,
+		Command: sc.Command,
+		Env:     sc.Env,
+		ShmSize: sc.ShmSize,
+		Init:    sc.Init,
+
+		StopSignal:        sc.StopSignal,
+		NetworkMode:       sc.NetworkMode,
+		FilepathToContent: sc.FilepathToContent,
+
+		LabelSize:       sc.LabelSize,
+		LabelIndex:      sc.LabelIndex,
+		RestartOnResize: sc.RestartOnResize,
+	}.ConfigKey()
+}
+
 func (cs storeContainerSlice) Len() int {
 	return len(cs)
 }
@@ -2,12 +2,14 @@ package etcd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"path"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/NetSys/quilt/db"
 
@@ -200,6 +202,74 @@ func TestUpdateEtcdLabel(t *testing.T) {
 	assert.Equal(t, 1, *store.writes)
 }
 
+// A leaderDiedStore fails all writes to `key`, as if the leader died before writing
+// it.
+type leaderDiedStore struct {
+	Store
+	key string
+}
+
+func (s leaderDiedStore) Set(path, value string, ttl time.Duration) error {
+	if path == s.key {
+		return errors.New("leader died")
+	}
+	return s.Store.Set(path, value, ttl)
+}
+
+func TestLeaderFailover(t *testing.T) {
+	store := newTestMock()
+	store.Mkdir(minionDir, 0)
+	store.Mkdir(nodeStore, 0)
+	store.Set(containerStore, "[]", 0)
+	store.Set(labelToIPStore, "{}", 0)
+
+	newLeader := func() db.Conn {
+		conn := db.New()
+		conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+			etcd := view.InsertEtcd()
+			etcd.Leader = true
+			view.Commit(etcd)
+
+			for i := 1; i < 4; i++ {
+				dbc := view.InsertContainer()
+				dbc.StitchID = i
+				dbc.Minion = "1.2.3.4"
+				dbc.Labels = []string{"red"}
+				view.Commit(dbc)
+			}
+			return nil
+		})
+		return conn
+	}
+
+	// The old leader writes the containers, but dies before writing the labels.
+	oldLeader := newLeader()
+	died := leaderDiedStore{store, labelToIPStore}
+	assert.False(t, updateNetwork(oldLeader, died, false))
+
+	etcdData, _ := readEtcd(store)
+	assert.Len(t, etcdData.containers, 3)
+	assert.Empty(t, etcdData.multiHost)
+	assert.Equal(t, 0, etcdData.generation)
+
+	// The new leader republishes everything as soon as it's elected.
+	conn := newLeader()
+	assert.True(t, updateNetwork(conn, store, false))
+
+	etcdData, _ = readEtcd(store)
+	assert.Len(t, etcdData.containers, 3)
+	assert.Contains(t, etcdData.multiHost, "red")
+	assert.Equal(t, 1, etcdData.generation)
+
+	// Once it has synced, nothing is written unless something changes.
+	*store.writes = 0
+	assert.True(t, updateNetwork(conn, store, true))
+	assert.Equal(t, 0, *store.writes)
+
+	etcdData, _ = readEtcd(store)
+	assert.Equal(t, 1, etcdData.generation)
+}
+
 func TestUpdateLeaderDBC(t *testing.T) {
 	conn := db.New()
 	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
@@ -209,11 +279,11 @@ func TestUpdateLeaderDBC(t *testing.T) {
 
 		updateLeaderDBC(view, view.SelectFromContainer(nil), storeData{
 			containers: []storeContainer{{StitchID: 1}},
-		}, map[string]string{"1": "foo"})
+		}, map[string]string{"1": "foo"}, map[string]string{"1": "running"})
 
 		dbcs := view.SelectFromContainer(nil)
 		if len(dbcs) != 1 || dbcs[0].StitchID != 1 || dbcs[0].IP != "foo" ||
-			dbcs[0].Mac != "" {
+			dbcs[0].Mac != "" || dbcs[0].ActualState != "running" {
 			t.Error(spew.Sprintf("Unexpected dbc: %v", dbc))
 		}
 
@@ -221,6 +291,52 @@ func TestUpdateLeaderDBC(t *testing.T) {
 	})
 }
 
+func TestOrphans(t *testing.T) {
+	orphan := db.Container{StitchID: 1, Minion: "1.2.3.4", DockerID: "docker",
+		Image: "web", DesiredState: db.DesiredRemoved, ActualState: "running"}
+
+	// Workers publish the containers they're tearing down.
+	store := newTestMock()
+	orphanKey := path.Join(nodeStore, "1.2.3.4", minionOrphanStore)
+	assert.NoError(t, store.Set(orphanKey, "{}", 0))
+
+	conn := db.New()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		updateWorker(view, db.Minion{PrivateIP: "1.2.3.4",
+			Orphans: []db.Container{orphan}}, store, storeData{})
+		return nil
+	})
+
+	orphanMap := map[string]string{}
+	storeOrphans, err := store.Get(orphanKey)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal([]byte(storeOrphans), &orphanMap))
+	assert.Len(t, orphanMap, 1)
+
+	// And the leader collects them.
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		self := view.InsertMinion()
+		self.Self = true
+		view.Commit(self)
+
+		updateLeaderOrphans(view, orphanMap)
+		return nil
+	})
+
+	self, err := conn.MinionSelf()
+	assert.NoError(t, err)
+	assert.Equal(t, []db.Container{orphan}, self.Orphans)
+
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		updateLeaderOrphans(view, map[string]string{})
+		return nil
+	})
+
+	self, err = conn.MinionSelf()
+	assert.NoError(t, err)
+	assert.Empty(t, self.Orphans)
+}
+
 func TestUpdateWorkerDBC(t *testing.T) {
 	conn := db.New()
 	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
@@ -242,6 +358,7 @@ func testUpdateWorkerDBC(t *testing.T, view db.Database) {
 		container.Minion = "1.2.3.4"
 		container.Command = []string{"echo", "hi"}
 		container.Env = map[string]string{"GOPATH": "~"}
+		container.ActualState = "running"
 		view.Commit(container)
 	}
 
@@ -277,6 +394,8 @@ func testUpdateWorkerDBC(t *testing.T, view db.Database) {
 	minionDirKey := path.Join(nodeStore, "1.2.3.4")
 	err = store.Set(path.Join(minionDirKey, minionIPStore), string(jsonNull), 0)
 	assert.Nil(t, err)
+	err = store.Set(path.Join(minionDirKey, minionStatusStore), string(jsonNull), 0)
+	assert.Nil(t, err)
 
 	updateWorker(view, db.Minion{PrivateIP: "1.2.3.4",
 		Subnet: "10.1.0.0"}, store, storeData{containers: cs})
@@ -316,6 +435,11 @@ func testUpdateWorkerDBC(t *testing.T, view db.Database) {
 	}
 
 	assert.Equal(t, expLabelMap, labelMap)
+
+	statusMap := map[string]string{}
+	storeStatuses, _ := store.Get(path.Join(minionDirKey, minionStatusStore))
+	json.Unmarshal([]byte(storeStatuses), &statusMap)
+	assert.Equal(t, map[string]string{"1": "running", "2": "running"}, statusMap)
 }
 
 func TestContainerJoinScore(t *testing.T) {
@@ -334,6 +458,14 @@ func TestContainerJoinScore(t *testing.T) {
 	b.Image = "Wrong"
 	score = containerJoinScore(a, b)
 	assert.Equal(t, -1, score)
+
+	// Nil and empty collections are equivalent.
+	b = a
+	b.Command = []string{}
+	b.Env = map[string]string{}
+	b.FilepathToContent = map[string]string{}
+	score = containerJoinScore(a, b)
+	assert.Equal(t, 0, score)
 }
 
 func TestUpdateDBLabels(t *testing.T) {
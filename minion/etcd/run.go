@@ -13,5 +13,6 @@ func Run(conn db.Conn) {
 
 	go runElection(conn, store)
 	go runNetwork(conn, store)
+	go runEncryption(conn, store)
 	runMinionSync(conn, store)
 }
@@ -1,9 +1,11 @@
 package ipdef
 
 import (
+	"encoding/binary"
 	"fmt"
 	"math"
 	"net"
+	"strings"
 	"syscall"
 )
 
@@ -21,17 +23,68 @@ var (
 	// LabelSubnet is the subnet that is reserved for label IPs.
 	LabelSubnet = net.IPNet{IP: QuiltSubnet.IP, Mask: SubMask}
 
-	minionMaskBits, _ = SubMask.Size()
-	quiltMaskBits, _  = QuiltSubnet.Mask.Size()
+	// MinionSubnets is the half of the QuiltSubnet that minion subnets are allocated
+	// from.  The other half is reserved for isolated networks.
+	MinionSubnets = net.IPNet{
+		IP:   QuiltSubnet.IP,
+		Mask: net.CIDRMask(9, 32),
+	}
+
+	// NetworkSubnets is the half of the QuiltSubnet that's reserved for isolated
+	// networks.  Each network is given a /16 of it.
+	NetworkSubnets = net.IPNet{
+		IP:   net.IPv4(10, 128, 0, 0).To4(),
+		Mask: net.CIDRMask(9, 32),
+	}
+
+	networkMask = net.CIDRMask(16, 32)
+
+	minionMaskBits, _   = SubMask.Size()
+	minionsMaskBits, _  = MinionSubnets.Mask.Size()
+	networkMaskBits, _  = networkMask.Size()
+	networksMaskBits, _ = NetworkSubnets.Mask.Size()
 
 	// MaxMinionCount is the largest number of minions that can exist, based
 	// on the number of available subnets
-	MaxMinionCount = int(math.Pow(2, float64(minionMaskBits-quiltMaskBits))+0.5) - 1
+	MaxMinionCount = int(math.Pow(2,
+		float64(minionMaskBits-minionsMaskBits))+0.5) - 1
+
+	// MaxNetworkCount is the largest number of isolated networks that can exist,
+	// based on the number of available network subnets.
+	MaxNetworkCount = 1 << uint(networkMaskBits-networksMaskBits)
 
 	// GatewayIP is the address of the border router in the logical network.
 	GatewayIP = net.IPv4(10, 0, 0, 1).To4()
 )
 
+// NetworkSubnet returns the subnet of the isolated network at `index` in a spec's
+// list of networks.
+func NetworkSubnet(index int) net.IPNet {
+	ip32 := binary.BigEndian.Uint32(NetworkSubnets.IP) |
+		uint32(index)<<uint(32-networkMaskBits)
+	return net.IPNet{IP: uint32ToIP(ip32), Mask: networkMask}
+}
+
+// MinionNetworkSubnet returns the part of `network`'s subnet from which the minion
+// with subnet `minion` assigns IPs.  Each minion subnet maps to its own slice of
+// every network, so that the minions never assign the same IP.
+func MinionNetworkSubnet(network, minion net.IPNet) net.IPNet {
+	sliceBits := uint(32 - networkMaskBits - (minionMaskBits - minionsMaskBits))
+	index := (binary.BigEndian.Uint32(minion.IP) &^
+		binary.BigEndian.Uint32(MinionSubnets.Mask)) >> uint(32-minionMaskBits)
+	ip32 := binary.BigEndian.Uint32(network.IP.To4()) | index<<sliceBits
+	return net.IPNet{
+		IP:   uint32ToIP(ip32),
+		Mask: net.CIDRMask(32-int(sliceBits), 32),
+	}
+}
+
+func uint32ToIP(ip32 uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, ip32)
+	return ip
+}
+
 // IPStrToMac converts the given IP address string into a MAC address.
 func IPStrToMac(ipStr string) string {
 	parsedIP := net.ParseIP(ipStr)
@@ -50,6 +103,34 @@ func IPToMac(ip net.IP) string {
 // Allow mocking out for unit tests.
 var ifNameSize = syscall.IFNAMSIZ
 
+// tempVethPrefix prefixes the name of the container side of a veth until Docker
+// moves it into the container and renames it.
+const tempVethPrefix = "tmp_"
+
+// VethNames returns the names the Quilt network plugin gives the veth pair of the
+// endpoint with the given ID: the host side, and the temporary name of the container
+// side.
+func VethNames(endpointID string) (outer, inner string) {
+	return IFName(endpointID), IFName(tempVethPrefix + endpointID)
+}
+
+// IsVethName returns whether `name` follows the convention of VethNames, i.e.
+// whether it may name a veth created by the Quilt network plugin.  Endpoint IDs are
+// hex strings.
+func IsVethName(name string) bool {
+	name = strings.TrimPrefix(name, tempVethPrefix)
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
 // IFName transforms a string into something suitable for an interface name.
 func IFName(name string) string {
 	// The IFNAMESIZ #define is the size of a C buffer, not the length of a string.
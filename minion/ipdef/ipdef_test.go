@@ -27,3 +27,40 @@ func TestIFName(t *testing.T) {
 	assert.Equal(t, IFName("1"), "1")
 	assert.Equal(t, IFName(""), "")
 }
+
+func TestVethNames(t *testing.T) {
+	oldIFNameSize := ifNameSize
+	ifNameSize = 16
+	defer func() { ifNameSize = oldIFNameSize }()
+
+	eid := "0123456789abcdef0123456789abcdef"
+	outer, inner := VethNames(eid)
+	assert.Equal(t, "0123456789abcde", outer)
+	assert.Equal(t, "tmp_0123456789a", inner)
+
+	assert.True(t, IsVethName(outer))
+	assert.True(t, IsVethName(inner))
+	assert.False(t, IsVethName("veth1a2b3c4"))
+	assert.False(t, IsVethName("eth0"))
+	assert.False(t, IsVethName("tmp_"))
+	assert.False(t, IsVethName(""))
+}
+
+func TestNetworkSubnets(t *testing.T) {
+	assert.Equal(t, 2047, MaxMinionCount)
+	assert.Equal(t, 128, MaxNetworkCount)
+
+	first, last := NetworkSubnet(0), NetworkSubnet(MaxNetworkCount-1)
+	assert.Equal(t, "10.128.0.0/16", first.String())
+	assert.Equal(t, "10.255.0.0/16", last.String())
+
+	_, minion, _ := net.ParseCIDR("10.0.16.0/20")
+	slice := MinionNetworkSubnet(first, *minion)
+	assert.Equal(t, "10.128.0.32/27", slice.String())
+
+	_, minion, _ = net.ParseCIDR("10.127.240.0/20")
+	slice = MinionNetworkSubnet(last, *minion)
+	assert.Equal(t, "10.255.255.224/27", slice.String())
+	assert.True(t, NetworkSubnets.Contains(slice.IP))
+	assert.False(t, MinionSubnets.Contains(slice.IP))
+}
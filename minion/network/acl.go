@@ -13,9 +13,57 @@ import (
 	log "github.com/Sirupsen/logrus"
 )
 
-func updateACLs(client ovsdb.Client, connections []db.Connection, labels []db.Label) {
-	syncAddressSets(client, labels)
-	syncACLs(client, connections)
+// The priorities of the ACLs.  Traffic is dropped unless a connection allows it, and
+// traffic between isolated networks is dropped regardless, unless the connection
+// explicitly allows crossing them.
+const (
+	defaultDropPriority  = 0
+	connectionPriority   = 1
+	networkDropPriority  = 2
+	crossNetworkPriority = 3
+)
+
+func updateACLs(client ovsdb.Client, connections []db.Connection, labels []db.Label,
+	containers []db.Container) {
+
+	networks := networkAddressSets(labels, containers)
+	syncAddressSets(client, labels, networks)
+	syncACLs(client, connections, networks)
+}
+
+// networkAddressSets returns an address set for each isolated network, holding the
+// IPs of its containers and of its labels.  Deployments with only the default
+// network need none.
+func networkAddressSets(labels []db.Label, containers []db.Container) []ovsdb.AddressSet {
+	members := map[string][]string{}
+	labelNetworks := map[string]string{}
+	for _, dbc := range containers {
+		network := stitch.NetworkName(dbc.Network)
+		members[network] = append(members[network], dbc.IP)
+		for _, label := range dbc.Labels {
+			labelNetworks[label] = network
+		}
+	}
+
+	if len(members) < 2 {
+		return nil
+	}
+
+	for _, l := range labels {
+		if network, ok := labelNetworks[l.Label]; ok {
+			members[network] = append(members[network], l.IP)
+		}
+	}
+
+	var sets []ovsdb.AddressSet
+	for network, ips := range members {
+		sets = append(sets, ovsdb.AddressSet{
+			Name:      networkAddressSetName(network),
+			Addresses: unique(ips),
+		})
+	}
+	sort.Sort(addressSetsByName(sets))
+	return sets
 }
 
 // We can't use a slice in the HashJoin key, so we represent the addresses in
@@ -38,7 +86,9 @@ func unique(lst []string) (uniq []string) {
 	return uniq
 }
 
-func syncAddressSets(ovsdbClient ovsdb.Client, labels []db.Label) {
+func syncAddressSets(ovsdbClient ovsdb.Client, labels []db.Label,
+	networks []ovsdb.AddressSet) {
+
 	ovsdbAddresses, err := ovsdbClient.ListAddressSets(lSwitch)
 	if err != nil {
 		log.WithError(err).Error("Failed to list address sets")
@@ -57,6 +107,7 @@ func syncAddressSets(ovsdbClient ovsdb.Client, labels []db.Label) {
 			},
 		)
 	}
+	expAddressSets = append(expAddressSets, networks...)
 	ovsdbKey := func(intf interface{}) interface{} {
 		addrSet := intf.(ovsdb.AddressSet)
 		// OVSDB returns the addresses in a non-deterministic order, so we
@@ -105,7 +156,9 @@ func directedACLs(acl ovsdb.ACL) (res []ovsdb.ACL) {
 	return res
 }
 
-func syncACLs(ovsdbClient ovsdb.Client, connections []db.Connection) {
+func syncACLs(ovsdbClient ovsdb.Client, connections []db.Connection,
+	networks []ovsdb.AddressSet) {
+
 	ovsdbACLs, err := ovsdbClient.ListACLs(lSwitch)
 	if err != nil {
 		log.WithError(err).Error("Failed to list ACLs")
@@ -116,10 +169,24 @@ func syncACLs(ovsdbClient ovsdb.Client, connections []db.Connection) {
 		Core: ovsdb.ACLCore{
 			Action:   "drop",
 			Match:    "ip",
-			Priority: 0,
+			Priority: defaultDropPriority,
 		},
 	})
 
+	for i, a := range networks {
+		for _, b := range networks[i+1:] {
+			expACLs = append(expACLs, directedACLs(ovsdb.ACL{
+				Core: ovsdb.ACLCore{
+					Action: "drop",
+					Match: or(
+						and(fromSet(a.Name), toSet(b.Name)),
+						and(fromSet(b.Name), toSet(a.Name))),
+					Priority: networkDropPriority,
+				},
+			})...)
+		}
+	}
+
 	for _, conn := range connections {
 		if conn.From == stitch.PublicInternetLabel ||
 			conn.To == stitch.PublicInternetLabel {
@@ -130,9 +197,22 @@ func syncACLs(ovsdbClient ovsdb.Client, connections []db.Connection) {
 				Core: ovsdb.ACLCore{
 					Action:   "allow",
 					Match:    matchString(conn),
-					Priority: 1,
+					Priority: connectionPriority,
 				},
 			})...)
+
+		// Connections that may cross networks must take precedence over the
+		// drops between them.
+		if conn.AllowCrossNetwork && len(networks) > 0 {
+			expACLs = append(expACLs, directedACLs(
+				ovsdb.ACL{
+					Core: ovsdb.ACLCore{
+						Action:   "allow",
+						Match:    matchString(conn),
+						Priority: crossNetworkPriority,
+					},
+				})...)
+		}
 	}
 
 	ovsdbKey := func(ovsdbIntf interface{}) interface{} {
@@ -160,23 +240,38 @@ func matchString(c db.Connection) string {
 	return or(
 		and(
 			and(from(c.From), to(c.To)),
-			portConstraint(c.MinPort, c.MaxPort, "dst")),
+			portConstraint(c.MinPort, c.MaxPort, c.Protocol, "dst")),
 		and(
 			and(from(c.To), to(c.From)),
-			portConstraint(c.MinPort, c.MaxPort, "src")))
+			portConstraint(c.MinPort, c.MaxPort, c.Protocol, "src")))
 }
 
-func portConstraint(minPort, maxPort int, direction string) string {
-	return fmt.Sprintf("(icmp || %[1]d <= udp.%[2]s <= %[3]d || "+
-		"%[1]d <= tcp.%[2]s <= %[3]d)", minPort, direction, maxPort)
+func portConstraint(minPort, maxPort int, protocol, direction string) string {
+	constraints := []string{"icmp"}
+	for _, proto := range []string{stitch.UDP, stitch.TCP} {
+		if protocol != "" && protocol != proto {
+			continue
+		}
+		constraints = append(constraints, fmt.Sprintf("%d <= %s.%s <= %d",
+			minPort, proto, direction, maxPort))
+	}
+	return "(" + strings.Join(constraints, " || ") + ")"
 }
 
 func from(label string) string {
-	return fmt.Sprintf("ip4.src == $%s", addressSetName(label))
+	return fromSet(addressSetName(label))
 }
 
 func to(label string) string {
-	return fmt.Sprintf("ip4.dst == $%s", addressSetName(label))
+	return toSet(addressSetName(label))
+}
+
+func fromSet(name string) string {
+	return fmt.Sprintf("ip4.src == $%s", name)
+}
+
+func toSet(name string) string {
+	return fmt.Sprintf("ip4.dst == $%s", name)
 }
 
 func or(predicates ...string) string {
@@ -199,6 +294,27 @@ func addressSetName(label string) string {
 	return label
 }
 
+// networkAddressSetName returns the name of the address set of `network`.  Labels
+// are lowercase and don't contain periods, so the prefix keeps it from conflicting
+// with a label's address set.
+func networkAddressSetName(network string) string {
+	return "NETWORK." + addressSetName(network)
+}
+
+type addressSetsByName []ovsdb.AddressSet
+
+func (sets addressSetsByName) Len() int {
+	return len(sets)
+}
+
+func (sets addressSetsByName) Less(i, j int) bool {
+	return sets[i].Name < sets[j].Name
+}
+
+func (sets addressSetsByName) Swap(i, j int) {
+	sets[i], sets[j] = sets[j], sets[i]
+}
+
 // ovsdbACLSlice is a wrapper around []ovsdb.ACL to allow us to perform a join
 type ovsdbACLSlice []ovsdb.ACL
 
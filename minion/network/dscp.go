@@ -0,0 +1,233 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NetSys/quilt/counter"
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/join"
+	"github.com/NetSys/quilt/stitch"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The comment that marks the mangle rules setting the DSCP of connections.
+const dscpComment = "quilt-dscp"
+
+var (
+	mangleAddCounter     = counter.New("network", "Add Mangle Rule")
+	mangleDeleteCounter  = counter.New("network", "Delete Mangle Rule")
+	mangleFailureCounter = counter.New("network", "Mangle Rule Failure")
+)
+
+// The DSCP rules last applied in each container's network namespace, keyed by Docker
+// ID.
+var appliedDSCP = map[string]dscpState{}
+
+type dscpState struct {
+	rules     string
+	appliedAt time.Time
+}
+
+// updateDSCP marks the traffic the local containers send over connections with a
+// DSCP value.  The traffic leaves the containers through Open vSwitch rather than the
+// host's network stack, so it's marked by rules in the mangle table's POSTROUTING
+// chain of each container's own network namespace.  A container's rules match the
+// IPs of the To label and the connection's ports, and are only resynced when they
+// change, or periodically to repair rules changed behind our back.  Other rules in
+// the containers' mangle tables are left alone.
+func updateDSCP(containers []db.Container, labels []db.Label,
+	connections []db.Connection) {
+
+	owners := map[string][]string{}
+	for _, owner := range dscpOwners(containers, labels, connections) {
+		owners[owner.containerIP] = owner.rules
+	}
+
+	next := map[string]dscpState{}
+	for _, dbc := range containers {
+		rules := owners[dbc.IP]
+		joined := strings.Join(rules, "\n")
+
+		// Containers that were never marked have nothing to repair, but the
+		// first time one is seen, the rules of a previous minion are cleared.
+		prev, ok := appliedDSCP[dbc.DockerID]
+		if ok && prev.rules == joined &&
+			(joined == "" || time.Since(prev.appliedAt) < fullSyncInterval) {
+			next[dbc.DockerID] = prev
+			continue
+		}
+
+		if !syncMangleRules(dbc.Pid, rules) {
+			// Leave the container out of `next` so that it's retried.
+			log.WithField("container", dbc.DockerID).Error(
+				"Failed to apply DSCP marking.")
+			continue
+		}
+		next[dbc.DockerID] = dscpState{joined, time.Now()}
+	}
+	appliedDSCP = next
+}
+
+// syncMangleRules replaces the DSCP rules in the network namespace of the process
+// with the given PID with `rules`.
+func syncMangleRules(pid int, rules []string) bool {
+	current, err := generateCurrentMangleRules(pid)
+	if err != nil {
+		mangleFailureCounter.Inc()
+		log.WithError(err).Error("Failed to get mangle rules.")
+		return false
+	}
+
+	var target ipRuleSlice
+	for _, r := range rules {
+		rule, err := makeIPRule(r)
+		if err != nil {
+			panic("malformed target DSCP rule")
+		}
+		target = append(target, rule)
+	}
+
+	_, rulesToDel, rulesToAdd := join.HashJoin(current, target, nil, nil)
+	return applyMangleRules(pid, rulesToDel, rulesToAdd)
+}
+
+// dscpOwners returns the target DSCP rules of each container.  Containers without
+// marked connections own no rules.
+func dscpOwners(containers []db.Container, labels []db.Label,
+	connections []db.Connection) []ruleOwner {
+
+	labelIPs := map[string][]string{}
+	for _, l := range labels {
+		ips := append([]string{l.IP}, l.ContainerIPs...)
+		sort.Strings(ips)
+		labelIPs[l.Label] = ips
+	}
+
+	var owners []ruleOwner
+	for _, dbc := range containers {
+		cLabels := map[string]struct{}{}
+		for _, label := range dbc.Labels {
+			cLabels[label] = struct{}{}
+		}
+
+		rules := map[string]struct{}{}
+		for _, conn := range connections {
+			if _, ok := cLabels[conn.From]; !ok || conn.DSCP <= 0 {
+				continue
+			}
+
+			for _, ip := range labelIPs[conn.To] {
+				if ip == "" || ip == dbc.IP {
+					continue
+				}
+				for _, rule := range dscpRules(dbc.IP, ip, conn) {
+					rules[rule] = struct{}{}
+				}
+			}
+		}
+
+		if len(rules) == 0 {
+			continue
+		}
+
+		var sorted []string
+		for rule := range rules {
+			sorted = append(sorted, rule)
+		}
+		sort.Strings(sorted)
+		owners = append(owners, ruleOwner{key: "container " + dbc.IP,
+			containerIP: dbc.IP, rules: sorted})
+	}
+	return owners
+}
+
+// dscpRules returns the rules that mark the traffic from `src` to `dst` over `conn`,
+// formatted as in the output of `iptables -S`.
+func dscpRules(src, dst string, conn db.Connection) []string {
+	prefix := fmt.Sprintf("-A POSTROUTING -s %s/32 -d %s/32", src, dst)
+	target := fmt.Sprintf("-m comment --comment %s -j DSCP --set-dscp 0x%02x",
+		dscpComment, conn.DSCP)
+
+	if conn.Protocol == stitch.ICMP {
+		return []string{fmt.Sprintf("%s -p icmp %s", prefix, target)}
+	}
+
+	ports := fmt.Sprintf("%d", conn.MinPort)
+	if conn.MaxPort != conn.MinPort {
+		ports += fmt.Sprintf(":%d", conn.MaxPort)
+	}
+
+	var rules []string
+	for _, protocol := range stitch.Protocols(conn.Protocol) {
+		rules = append(rules, fmt.Sprintf(
+			"%[1]s -p %[2]s -m %[2]s --dport %[3]s %[4]s",
+			prefix, protocol, ports, target))
+	}
+	return rules
+}
+
+// generateCurrentMangleRules returns the DSCP rules installed in the mangle table of
+// the network namespace of the process with the given PID.
+func generateCurrentMangleRules(pid int) (ipRuleSlice, error) {
+	stdout, _, err := shVerbose("%s -S", mangleCmd(pid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get IP tables: %s", err)
+	}
+
+	var rules ipRuleSlice
+	scanner := bufio.NewScanner(bytes.NewReader(stdout))
+	for scanner.Scan() {
+		rule, err := makeIPRule(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current IP rules: %s", err)
+		}
+
+		if rule.cmd == "-A" && rule.chain == "POSTROUTING" &&
+			strings.Contains(rule.opts, "--comment "+dscpComment) {
+			rules = append(rules, rule)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanner error while getting IP tables: %s", err)
+	}
+	return rules, nil
+}
+
+func applyMangleRules(pid int, rulesToDel, rulesToAdd []interface{}) bool {
+	ok := true
+	for _, rule := range rulesToDel {
+		rule := rule.(ipRule)
+		mangleDeleteCounter.Inc()
+		err := sh("%s -D %s %s", mangleCmd(pid), rule.chain, rule.opts)
+		if err != nil {
+			mangleFailureCounter.Inc()
+			log.WithError(err).Error("failed to delete mangle rule")
+			ok = false
+		}
+	}
+
+	for _, rule := range rulesToAdd {
+		rule := rule.(ipRule)
+		mangleAddCounter.Inc()
+		err := sh("%s -A %s %s", mangleCmd(pid), rule.chain, rule.opts)
+		if err != nil {
+			mangleFailureCounter.Inc()
+			log.WithError(err).Error("failed to add mangle rule")
+			ok = false
+		}
+	}
+	return ok
+}
+
+// mangleCmd returns the command that runs iptables on the mangle table of the network
+// namespace of the process with the given PID.
+func mangleCmd(pid int) string {
+	return fmt.Sprintf("nsenter --net=/hostproc/%d/ns/net iptables -t mangle", pid)
+}
@@ -0,0 +1,138 @@
+package network
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDSCPOwners(t *testing.T) {
+	t.Parallel()
+
+	containers := []db.Container{
+		{IP: "10.0.0.2", Labels: []string{"api"}},
+		{IP: "10.0.0.3", Labels: []string{"backup"}},
+	}
+	labels := []db.Label{
+		{Label: "cache", IP: "10.1.0.1", ContainerIPs: []string{"10.0.0.4"}},
+		{Label: "api", IP: "10.1.0.2", ContainerIPs: []string{"10.0.0.2"}},
+	}
+	connections := []db.Connection{
+		{From: "api", To: "cache", MinPort: 6379, MaxPort: 6379,
+			Protocol: "tcp", DSCP: 46},
+		{From: "api", To: "cache", MinPort: 0, MaxPort: 0, Protocol: "icmp",
+			DSCP: 10},
+		{From: "backup", To: "cache", MinPort: 6379, MaxPort: 6379},
+		{From: "api", To: "api", MinPort: 8000, MaxPort: 8001, DSCP: 8},
+	}
+
+	assert.Equal(t, []ruleOwner{{key: "container 10.0.0.2", containerIP: "10.0.0.2",
+		rules: []string{
+			"-A POSTROUTING -s 10.0.0.2/32 -d 10.0.0.4/32 -p icmp " +
+				"-m comment --comment quilt-dscp " +
+				"-j DSCP --set-dscp 0x0a",
+			"-A POSTROUTING -s 10.0.0.2/32 -d 10.0.0.4/32 -p tcp -m tcp " +
+				"--dport 6379 -m comment --comment quilt-dscp " +
+				"-j DSCP --set-dscp 0x2e",
+			"-A POSTROUTING -s 10.0.0.2/32 -d 10.1.0.1/32 -p icmp " +
+				"-m comment --comment quilt-dscp " +
+				"-j DSCP --set-dscp 0x0a",
+			"-A POSTROUTING -s 10.0.0.2/32 -d 10.1.0.1/32 -p tcp -m tcp " +
+				"--dport 6379 -m comment --comment quilt-dscp " +
+				"-j DSCP --set-dscp 0x2e",
+			"-A POSTROUTING -s 10.0.0.2/32 -d 10.1.0.2/32 -p tcp -m tcp " +
+				"--dport 8000:8001 -m comment --comment quilt-dscp " +
+				"-j DSCP --set-dscp 0x08",
+			"-A POSTROUTING -s 10.0.0.2/32 -d 10.1.0.2/32 -p udp -m udp " +
+				"--dport 8000:8001 -m comment --comment quilt-dscp " +
+				"-j DSCP --set-dscp 0x08",
+		}}}, dscpOwners(containers, labels, connections))
+}
+
+func TestDSCPInContainerNamespace(t *testing.T) {
+	// The host's tables must never be touched, as the containers' traffic doesn't
+	// traverse them.
+	host := &fakeNat{rules: map[string]struct{}{}}
+
+	// The mangle table of the first container has a rule of its own that isn't
+	// Quilt's.
+	const otherMangle = "-A POSTROUTING -o eth0 -j TOS --set-tos 0x10/0x3f"
+	namespaces := map[string]*fakeNat{
+		"2": {table: "mangle", rules: map[string]struct{}{
+			"-P POSTROUTING ACCEPT": {},
+			otherMangle:             {},
+		}},
+		"3": {table: "mangle", rules: map[string]struct{}{
+			"-P POSTROUTING ACCEPT": {},
+		}},
+	}
+
+	oldShVerbose := shVerbose
+	defer func() { shVerbose = oldShVerbose }()
+	shVerbose = func(format string, args ...interface{}) (
+		stdout, stderr []byte, err error) {
+
+		cmd := fmt.Sprintf(format, args...)
+		for pid, ns := range namespaces {
+			prefix := "nsenter --net=/hostproc/" + pid + "/ns/net "
+			if strings.HasPrefix(cmd, prefix) {
+				return ns.shVerbose("%s",
+					strings.TrimPrefix(cmd, prefix))
+			}
+		}
+		return host.shVerbose(format, args...)
+	}
+
+	defer func() { appliedDSCP = map[string]dscpState{} }()
+
+	containers := []db.Container{
+		{DockerID: "api", IP: "10.0.0.2", Pid: 2, Labels: []string{"api"}},
+		{DockerID: "cache", IP: "10.0.0.3", Pid: 3, Labels: []string{"cache"}},
+	}
+	labels := []db.Label{{Label: "cache", IP: "10.1.0.1"}}
+	connections := []db.Connection{
+		{From: "api", To: "cache", MinPort: 6379, MaxPort: 6379,
+			Protocol: "tcp", DSCP: 46},
+	}
+
+	dscpRule := "-A POSTROUTING -s 10.0.0.2/32 -d 10.1.0.1/32 -p tcp -m tcp " +
+		"--dport 6379 -m comment --comment quilt-dscp -j DSCP --set-dscp 0x2e"
+
+	// The sender's traffic is marked within its own namespace, and the rules that
+	// aren't Quilt's survive.
+	updateDSCP(containers, labels, connections)
+	assert.Equal(t, map[string]struct{}{
+		"-P POSTROUTING ACCEPT": {},
+		otherMangle:             {},
+		dscpRule:                {},
+	}, namespaces["2"].rules)
+	assert.Equal(t, map[string]struct{}{"-P POSTROUTING ACCEPT": {}},
+		namespaces["3"].rules)
+	assert.Empty(t, host.rules)
+
+	// Nothing changed, so no namespace is touched.
+	lists := namespaces["2"].lists + namespaces["3"].lists
+	updateDSCP(containers, labels, connections)
+	assert.Equal(t, lists, namespaces["2"].lists+namespaces["3"].lists)
+
+	// Rules deleted behind our back are repaired by the periodic full sync.
+	delete(namespaces["2"].rules, dscpRule)
+	state := appliedDSCP["api"]
+	state.appliedAt = state.appliedAt.Add(-2 * fullSyncInterval)
+	appliedDSCP["api"] = state
+	updateDSCP(containers, labels, connections)
+	assert.Contains(t, namespaces["2"].rules, dscpRule)
+
+	// Unmarking the connection only removes its rule.
+	unmarked := []db.Connection{connections[0]}
+	unmarked[0].DSCP = 0
+	updateDSCP(containers, labels, unmarked)
+	assert.Equal(t, map[string]struct{}{
+		"-P POSTROUTING ACCEPT": {},
+		otherMangle:             {},
+	}, namespaces["2"].rules)
+	assert.Empty(t, host.rules)
+}
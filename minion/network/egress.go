@@ -0,0 +1,127 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/NetSys/quilt/counter"
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/join"
+	"github.com/NetSys/quilt/stitch"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The comment that marks the filter rules dropping the egress of noEgress labels.
+const noEgressComment = "quilt-no-egress"
+
+var (
+	egressAddCounter     = counter.New("network", "Add Egress Rule")
+	egressDeleteCounter  = counter.New("network", "Delete Egress Rule")
+	egressFailureCounter = counter.New("network", "Egress Rule Failure")
+)
+
+var egressScope = newRuleScope("Egress", func(rule string) (interface{}, error) {
+	return makeIPRule(rule)
+})
+
+// updateEgress drops the traffic that the local containers of labels annotated
+// stitch.NoEgressAnnotation forward out the public interface.  MASQUERADE is a
+// single rule for the whole container subnet, so rather than excluding the
+// containers from it, each container owns a DROP rule in the filter table's FORWARD
+// chain.  The rules are inserted at the head of the chain, so that they take
+// precedence over any blanket ACCEPT rules, such as Docker's.  They only match new
+// connections, so that the replies to connections from the public internet still
+// get out.
+func updateEgress(publicInterface string, labels []stitch.Label,
+	containers []db.Container) {
+
+	egressScope.sync(noEgressOwners(publicInterface, labels, containers),
+		func() (join.List, error) {
+			rules, err := generateCurrentEgressRules()
+			return rules, err
+		}, applyEgressRules)
+}
+
+// noEgressOwners returns the DROP rule of each container in a noEgress label.
+func noEgressOwners(publicInterface string, labels []stitch.Label,
+	containers []db.Container) []ruleOwner {
+
+	noEgress := map[string]struct{}{}
+	for _, label := range labels {
+		if label.HasAnnotation(stitch.NoEgressAnnotation) {
+			noEgress[label.Name] = struct{}{}
+		}
+	}
+
+	var owners []ruleOwner
+	for _, dbc := range containers {
+		for _, label := range dbc.Labels {
+			if _, ok := noEgress[label]; !ok {
+				continue
+			}
+
+			owners = append(owners, ruleOwner{key: "container " + dbc.IP,
+				rules: []string{fmt.Sprintf("-A FORWARD -s %s/32 -o %s "+
+					"-m conntrack --ctstate NEW -m comment "+
+					"--comment %s -j DROP", dbc.IP, publicInterface,
+					noEgressComment)}})
+			break
+		}
+	}
+	return owners
+}
+
+// generateCurrentEgressRules returns the noEgress rules installed in the FORWARD
+// chain.
+func generateCurrentEgressRules() (ipRuleSlice, error) {
+	stdout, _, err := shVerbose("iptables -S FORWARD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get IP tables: %s", err)
+	}
+
+	var rules ipRuleSlice
+	scanner := bufio.NewScanner(bytes.NewReader(stdout))
+	for scanner.Scan() {
+		rule, err := makeIPRule(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current IP rules: %s", err)
+		}
+
+		if rule.cmd == "-A" && rule.chain == "FORWARD" &&
+			strings.Contains(rule.opts, "--comment "+noEgressComment) {
+			rules = append(rules, rule)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanner error while getting IP tables: %s", err)
+	}
+	return rules, nil
+}
+
+func applyEgressRules(rulesToDel, rulesToAdd []interface{}) bool {
+	ok := true
+	for _, rule := range rulesToDel {
+		rule := rule.(ipRule)
+		egressDeleteCounter.Inc()
+		if err := sh("iptables -D %s %s", rule.chain, rule.opts); err != nil {
+			egressFailureCounter.Inc()
+			log.WithError(err).Error("failed to delete egress rule")
+			ok = false
+		}
+	}
+
+	for _, rule := range rulesToAdd {
+		rule := rule.(ipRule)
+		egressAddCounter.Inc()
+		if err := sh("iptables -I %s 1 %s", rule.chain, rule.opts); err != nil {
+			egressFailureCounter.Inc()
+			log.WithError(err).Error("failed to add egress rule")
+			ok = false
+		}
+	}
+	return ok
+}
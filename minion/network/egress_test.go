@@ -0,0 +1,102 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeForward is a filter table's FORWARD chain that, unlike fakeNat, remembers the
+// order of its rules.
+type fakeForward struct {
+	rules []string
+}
+
+func (f *fakeForward) shVerbose(format string, args ...interface{}) (
+	stdout, stderr []byte, err error) {
+
+	cmd := fmt.Sprintf(format, args...)
+	switch {
+	case cmd == "iptables -S FORWARD":
+		lines := append([]string{"-P FORWARD ACCEPT"}, f.rules...)
+		return []byte(strings.Join(lines, "\n") + "\n"), nil, nil
+	case strings.HasPrefix(cmd, "iptables -I FORWARD 1 "):
+		rule := "-A FORWARD " + strings.TrimPrefix(cmd, "iptables -I FORWARD 1 ")
+		f.rules = append([]string{rule}, f.rules...)
+		return nil, nil, nil
+	case strings.HasPrefix(cmd, "iptables -D FORWARD "):
+		rule := "-A FORWARD " + strings.TrimPrefix(cmd, "iptables -D FORWARD ")
+		for i, r := range f.rules {
+			if r == rule {
+				f.rules = append(f.rules[:i], f.rules[i+1:]...)
+				return nil, nil, nil
+			}
+		}
+		return nil, nil, fmt.Errorf("no such rule: %s", rule)
+	}
+	return nil, nil, fmt.Errorf("unexpected command: %s", cmd)
+}
+
+func TestUpdateEgress(t *testing.T) {
+	// Docker's blanket rules, which would otherwise accept the containers' traffic.
+	blanket := []string{
+		"-A FORWARD -j DOCKER-ISOLATION",
+		"-A FORWARD -i docker0 ! -o docker0 -j ACCEPT",
+		"-A FORWARD -j ACCEPT",
+	}
+	forward := &fakeForward{rules: append([]string{}, blanket...)}
+
+	oldShVerbose := shVerbose
+	defer func() { shVerbose = oldShVerbose }()
+	shVerbose = forward.shVerbose
+
+	egressScope.owners = nil
+	defer func() {
+		egressScope.owners = nil
+		egressScope.stale = false
+	}()
+
+	containers := []db.Container{
+		{IP: "10.0.0.2", Labels: []string{"db"}},
+		{IP: "10.0.0.3", Labels: []string{"db", "backup"}},
+		{IP: "10.0.0.4", Labels: []string{"web"}},
+	}
+	labels := []stitch.Label{
+		{Name: "db", Annotations: []string{stitch.NoEgressAnnotation}},
+		{Name: "backup"},
+		{Name: "web"},
+	}
+
+	dropRule := func(ip string) string {
+		return fmt.Sprintf("-A FORWARD -s %s/32 -o eth0 -m conntrack "+
+			"--ctstate NEW -m comment --comment quilt-no-egress -j DROP", ip)
+	}
+
+	// The DROP rules precede the blanket rules, so they take effect.
+	updateEgress("eth0", labels, containers)
+	assert.Len(t, forward.rules, 5)
+	drops := append([]string{}, forward.rules[:2]...)
+	sort.Strings(drops)
+	assert.Equal(t, []string{dropRule("10.0.0.2"), dropRule("10.0.0.3")}, drops)
+	assert.Equal(t, blanket, forward.rules[2:])
+
+	// Removing the annotation removes the DROP rules, and nothing else.
+	labels[0].Annotations = nil
+	updateEgress("eth0", labels, containers)
+	assert.Equal(t, blanket, forward.rules)
+
+	// Rules installed by a previous run are found by the full sync, even if other
+	// rules were inserted above them since.
+	forward.rules = append([]string{"-A FORWARD -j ACCEPT", dropRule("10.0.0.9")},
+		blanket...)
+	egressScope.owners = nil
+	labels[2].Annotations = []string{stitch.NoEgressAnnotation}
+	updateEgress("eth0", labels, containers)
+	assert.Equal(t, append([]string{dropRule("10.0.0.4"), "-A FORWARD -j ACCEPT"},
+		blanket...), forward.rules)
+}
@@ -0,0 +1,224 @@
+package network
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"sort"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/minion/ovsdb"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The pre-shared key of each tunnel is derived with ECDH from the keys of the workers
+// at its two ends, so no key that could decrypt the tunnels is ever stored in etcd.
+var tunnelCurve = elliptic.P256()
+
+type tunnelKey struct {
+	private []byte
+	public  string
+}
+
+// The worker's tunnel keys by generation.  They live only in memory, so a restarted
+// worker generates and publishes new ones.
+var tunnelKeys = map[int]tunnelKey{}
+
+// The peers whose tunnels were last left in the clear, so that each change is logged
+// once rather than on every loop.
+var cleartextPeers []string
+
+// Stored in a variable so it can be mocked out by the unit tests.
+var generateTunnelKey = func() (tunnelKey, error) {
+	private, x, y, err := elliptic.GenerateKey(tunnelCurve, rand.Reader)
+	if err != nil {
+		return tunnelKey{}, err
+	}
+	return tunnelKey{private, hex.EncodeToString(elliptic.Marshal(tunnelCurve, x, y))},
+		nil
+}
+
+// updateTunnelKeys generates the keys of the generations the leader asks for, publishes
+// them in the minion's row, and returns the pre-shared key of the tunnel to each of
+// the other workers, by IP.
+func updateTunnelKeys(conn db.Conn) map[string]string {
+	var psks map[string]string
+	conn.Txn(db.EtcdTable, db.MinionTable).Run(func(view db.Database) error {
+		etcdRow, err := view.GetEtcd()
+		if err != nil {
+			return err
+		}
+
+		self, err := view.MinionSelf()
+		if err != nil {
+			return err
+		}
+
+		tunnelKeys = nextTunnelKeys(tunnelKeys, etcdRow)
+
+		public := map[int]string{}
+		for generation, key := range tunnelKeys {
+			public[generation] = key.public
+		}
+		if len(public) == 0 {
+			public = nil
+		}
+
+		if !reflect.DeepEqual(self.EncryptionKeys, public) {
+			self.EncryptionKeys = public
+			view.Commit(self)
+		}
+
+		peers := view.SelectFromMinion(func(m db.Minion) bool {
+			return !m.Self && m.Role == db.Worker
+		})
+		var cleartext []string
+		psks, cleartext = tunnelPSKs(tunnelKeys, etcdRow.EncryptionGeneration,
+			peers)
+		logCleartextPeers(cleartext)
+		return nil
+	})
+	return psks
+}
+
+// nextTunnelKeys returns the keys the worker should hold.  Those of the current and
+// staged generations are generated if missing.  Those of the previous generation are
+// kept, so that peers that haven't yet switched generations can still be reached.
+func nextTunnelKeys(keys map[int]tunnelKey, etcdRow db.Etcd) map[int]tunnelKey {
+	next := map[int]tunnelKey{}
+	if etcdRow.Encryption == db.EncryptionDisabled {
+		return next
+	}
+
+	current := etcdRow.EncryptionGeneration
+	if key, ok := keys[current-1]; ok && current > 1 {
+		next[current-1] = key
+	}
+
+	for _, generation := range []int{current, etcdRow.EncryptionNext} {
+		if generation == 0 {
+			continue
+		}
+
+		if key, ok := keys[generation]; ok {
+			next[generation] = key
+			continue
+		}
+
+		key, err := generateTunnelKey()
+		if err != nil {
+			log.WithError(err).Error("Failed to generate tunnel key")
+			continue
+		}
+		next[generation] = key
+	}
+	return next
+}
+
+// tunnelPSKs returns the pre-shared key of the tunnel to each peer, by the peer's IP.
+// A tunnel is encrypted with the keys of the current generation if both of its ends
+// have them, and otherwise with those of the previous generation.  Tunnels to peers
+// that share neither are left in the clear, and while encryption is enabled, those
+// peers are returned in `cleartext`.
+func tunnelPSKs(keys map[int]tunnelKey, generation int, peers []db.Minion) (
+	psks map[string]string, cleartext []string) {
+
+	psks = map[string]string{}
+	for _, peer := range peers {
+		for _, g := range []int{generation, generation - 1} {
+			key, ok := keys[g]
+			peerPublic, peerOK := peer.EncryptionKeys[g]
+			if g == 0 || !ok || !peerOK {
+				continue
+			}
+
+			psk, err := tunnelPSK(key, peerPublic)
+			if err != nil {
+				log.WithError(err).WithField("peer", peer.PrivateIP).Warn(
+					"Failed to derive tunnel key")
+				continue
+			}
+			psks[peer.PrivateIP] = psk
+			break
+		}
+
+		if _, ok := psks[peer.PrivateIP]; !ok && generation != 0 {
+			cleartext = append(cleartext, peer.PrivateIP)
+		}
+	}
+	sort.Strings(cleartext)
+	return psks, cleartext
+}
+
+// logCleartextPeers warns about the peers whose tunnels are left in the clear despite
+// encryption being enabled, whenever they change.
+func logCleartextPeers(cleartext []string) {
+	if reflect.DeepEqual(cleartext, cleartextPeers) {
+		return
+	}
+	cleartextPeers = cleartext
+
+	if len(cleartext) == 0 {
+		log.Info("All tunnels to peers are encrypted")
+	} else {
+		log.WithField("peers", cleartext).Warn("Tunnels to peers without a " +
+			"shared key are unencrypted")
+	}
+}
+
+// tunnelPSK derives the pre-shared key of the tunnel between the holder of `key` and
+// the holder of the private half of `peerPublic`.  Both ends derive the same key.
+func tunnelPSK(key tunnelKey, peerPublic string) (string, error) {
+	peerBytes, err := hex.DecodeString(peerPublic)
+	if err != nil {
+		return "", err
+	}
+
+	x, y := elliptic.Unmarshal(tunnelCurve, peerBytes)
+	if x == nil || !tunnelCurve.IsOnCurve(x, y) {
+		return "", errors.New("malformed public key")
+	}
+
+	shared, _ := tunnelCurve.ScalarMult(x, y, key.private)
+	sum := sha256.Sum256(shared.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// updateTunnelEncryption sets the IPsec pre-shared key of each tunnel to another worker
+// to the one in `psks` for its remote IP.  OVS encrypts the traffic of tunnels with a
+// key, and leaves the rest in the clear.
+func updateTunnelEncryption(odb ovsdb.Client, psks map[string]string) {
+	ifaces, err := odb.ListInterfaces()
+	if err != nil {
+		log.WithError(err).Error("Failed to list interfaces")
+		return
+	}
+
+	for _, iface := range tunnelsToUpdate(ifaces, psks) {
+		err := odb.SetInterfacePSK(iface.Name, psks[iface.RemoteIP])
+		if err != nil {
+			log.WithError(err).WithField("interface", iface.Name).Error(
+				"Failed to set tunnel encryption key")
+		}
+	}
+}
+
+// tunnelsToUpdate returns the tunnel interfaces whose pre-shared key isn't the one in
+// `psks` for their remote IP.
+func tunnelsToUpdate(ifaces []ovsdb.Interface,
+	psks map[string]string) []ovsdb.Interface {
+
+	var tunnels []ovsdb.Interface
+	for _, iface := range ifaces {
+		isTunnel := iface.Type == ovsdb.InterfaceTypeSTT ||
+			iface.Type == ovsdb.InterfaceTypeGeneve
+		if isTunnel && iface.PSK != psks[iface.RemoteIP] {
+			tunnels = append(tunnels, iface)
+		}
+	}
+	return tunnels
+}
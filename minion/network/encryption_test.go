@@ -0,0 +1,210 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/minion/ovsdb"
+)
+
+func TestTunnelPSK(t *testing.T) {
+	a, err := generateTunnelKey()
+	assert.NoError(t, err)
+	b, err := generateTunnelKey()
+	assert.NoError(t, err)
+	c, err := generateTunnelKey()
+	assert.NoError(t, err)
+
+	// Both ends of a tunnel derive the same key, which no one else can.
+	ab, err := tunnelPSK(a, b.public)
+	assert.NoError(t, err)
+	ba, err := tunnelPSK(b, a.public)
+	assert.NoError(t, err)
+	assert.Equal(t, ab, ba)
+	assert.Len(t, ab, 64)
+
+	ac, err := tunnelPSK(a, c.public)
+	assert.NoError(t, err)
+	assert.NotEqual(t, ab, ac)
+
+	_, err = tunnelPSK(a, "zz")
+	assert.Error(t, err)
+	_, err = tunnelPSK(a, "0400")
+	assert.EqualError(t, err, "malformed public key")
+}
+
+func TestNextTunnelKeys(t *testing.T) {
+	generated := 0
+	defer func(orig func() (tunnelKey, error)) { generateTunnelKey = orig }(
+		generateTunnelKey)
+	generateTunnelKey = func() (tunnelKey, error) {
+		generated++
+		return tunnelKey{public: string(rune('a' + generated - 1))}, nil
+	}
+
+	publics := func(keys map[int]tunnelKey) map[int]string {
+		res := map[int]string{}
+		for g, key := range keys {
+			res[g] = key.public
+		}
+		return res
+	}
+
+	etcdRow := db.Etcd{Encryption: db.EncryptionPending, EncryptionNext: 1}
+	keys := nextTunnelKeys(map[int]tunnelKey{}, etcdRow)
+	assert.Equal(t, map[int]string{1: "a"}, publics(keys))
+
+	etcdRow = db.Etcd{Encryption: db.EncryptionEnabled, EncryptionGeneration: 1}
+	keys = nextTunnelKeys(keys, etcdRow)
+	assert.Equal(t, map[int]string{1: "a"}, publics(keys))
+
+	// A staged rotation adds a key, while the current one is kept.
+	etcdRow.EncryptionNext = 2
+	keys = nextTunnelKeys(keys, etcdRow)
+	assert.Equal(t, map[int]string{1: "a", 2: "b"}, publics(keys))
+
+	// After the switch, the previous generation is kept for peers that lag.
+	etcdRow = db.Etcd{Encryption: db.EncryptionEnabled, EncryptionGeneration: 2}
+	keys = nextTunnelKeys(keys, etcdRow)
+	assert.Equal(t, map[int]string{1: "a", 2: "b"}, publics(keys))
+
+	etcdRow.EncryptionNext = 3
+	keys = nextTunnelKeys(keys, etcdRow)
+	assert.Equal(t, map[int]string{1: "a", 2: "b", 3: "c"}, publics(keys))
+
+	etcdRow = db.Etcd{Encryption: db.EncryptionEnabled, EncryptionGeneration: 3}
+	keys = nextTunnelKeys(keys, etcdRow)
+	assert.Equal(t, map[int]string{2: "b", 3: "c"}, publics(keys))
+
+	assert.Empty(t, nextTunnelKeys(keys, db.Etcd{}))
+}
+
+func TestTunnelPSKsRotation(t *testing.T) {
+	newKey := func() tunnelKey {
+		key, err := generateTunnelKey()
+		assert.NoError(t, err)
+		return key
+	}
+
+	type worker struct {
+		ip   string
+		keys map[int]tunnelKey
+	}
+	a := worker{"10.0.0.1", map[int]tunnelKey{1: newKey()}}
+	b := worker{"10.0.0.2", map[int]tunnelKey{1: newKey()}}
+
+	asPeer := func(w worker) db.Minion {
+		public := map[int]string{}
+		for g, key := range w.keys {
+			public[g] = key.public
+		}
+		return db.Minion{PrivateIP: w.ip, EncryptionKeys: public}
+	}
+
+	psk := func(from, to worker, generation int) string {
+		psks, _ := tunnelPSKs(from.keys, generation, []db.Minion{asPeer(to)})
+		return psks[to.ip]
+	}
+
+	gen1 := psk(a, b, 1)
+	assert.NotEmpty(t, gen1)
+	assert.Equal(t, gen1, psk(b, a, 1))
+
+	// The next generation is staged.  Until the switch, both ends hold both keys,
+	// and the tunnel keeps using the current one.
+	a.keys[2] = newKey()
+	assert.Equal(t, gen1, psk(a, b, 1))
+	b.keys[2] = newKey()
+	assert.Equal(t, gen1, psk(a, b, 1))
+	assert.Equal(t, gen1, psk(b, a, 1))
+
+	// Once switched, both ends derive the same new key.
+	gen2 := psk(a, b, 2)
+	assert.NotEqual(t, gen1, gen2)
+	assert.Equal(t, gen2, psk(b, a, 2))
+
+	// A peer that restarted, and so only has a key of the current generation, is
+	// still reached with it.
+	c := worker{"10.0.0.3", map[int]tunnelKey{2: newKey()}}
+	assert.Equal(t, psk(c, a, 2), psk(a, c, 2))
+
+	// A peer without keys of either generation is left in the clear, and reported
+	// as such.
+	d := worker{"10.0.0.4", map[int]tunnelKey{}}
+	assert.Empty(t, psk(a, d, 2))
+
+	psks, cleartext := tunnelPSKs(a.keys, 2, []db.Minion{asPeer(c), asPeer(d)})
+	assert.Len(t, psks, 1)
+	assert.Equal(t, []string{d.ip}, cleartext)
+
+	// Before encryption is enabled, every tunnel is in the clear by design.
+	_, cleartext = tunnelPSKs(a.keys, 0, []db.Minion{asPeer(c), asPeer(d)})
+	assert.Empty(t, cleartext)
+}
+
+func TestUpdateTunnelKeys(t *testing.T) {
+	conn := db.New()
+	peer, err := generateTunnelKey()
+	assert.NoError(t, err)
+
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		self := view.InsertMinion()
+		self.Self = true
+		self.Role = db.Worker
+		self.PrivateIP = "10.0.0.1"
+		view.Commit(self)
+
+		other := view.InsertMinion()
+		other.Role = db.Worker
+		other.PrivateIP = "10.0.0.2"
+		other.EncryptionKeys = map[int]string{1: peer.public}
+		view.Commit(other)
+
+		etcd := view.InsertEtcd()
+		etcd.Encryption = db.EncryptionEnabled
+		etcd.EncryptionGeneration = 1
+		view.Commit(etcd)
+		return nil
+	})
+
+	tunnelKeys = map[int]tunnelKey{}
+	psks := updateTunnelKeys(conn)
+
+	// The worker publishes only the public half of its key.
+	self, err := conn.MinionSelf()
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]string{1: tunnelKeys[1].public}, self.EncryptionKeys)
+
+	exp, err := tunnelPSK(peer, self.EncryptionKeys[1])
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"10.0.0.2": exp}, psks)
+}
+
+func TestTunnelsToUpdate(t *testing.T) {
+	ifaces := []ovsdb.Interface{
+		{Name: "patch", Type: ovsdb.InterfaceTypePatch},
+		{Name: "stt-new", Type: ovsdb.InterfaceTypeSTT, RemoteIP: "1"},
+		{Name: "stt-current", Type: ovsdb.InterfaceTypeSTT, RemoteIP: "2",
+			PSK: "key2"},
+		{Name: "geneve-old", Type: ovsdb.InterfaceTypeGeneve, RemoteIP: "3",
+			PSK: "old"},
+	}
+
+	names := func(ifaces []ovsdb.Interface) []string {
+		var names []string
+		for _, iface := range ifaces {
+			names = append(names, iface.Name)
+		}
+		return names
+	}
+
+	psks := map[string]string{"1": "key1", "2": "key2", "3": "key3"}
+	assert.Equal(t, []string{"stt-new", "geneve-old"},
+		names(tunnelsToUpdate(ifaces, psks)))
+
+	// Disabling encryption clears the keys of the tunnels that have one.
+	assert.Equal(t, []string{"stt-current", "geneve-old"},
+		names(tunnelsToUpdate(ifaces, nil)))
+}
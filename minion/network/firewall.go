@@ -0,0 +1,197 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/join"
+	"github.com/NetSys/quilt/stitch"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The iptables options shared by all of the INPUT rules that restrict SSH.
+const sshRuleOpts = "-p tcp -m tcp --dport 22"
+
+// The comment that marks the INPUT rules admitting host networked connections.
+const hostPortComment = "quilt-host-port"
+
+// runFirewall restricts SSH access to this machine to the ACL the spec specifies for
+// its role, or to the AdminACL if its role has none.
+func runFirewall(conn db.Conn) {
+	self, err := conn.MinionSelf()
+	if err != nil || self.Spec == "" {
+		return
+	}
+
+	spec, err := stitch.FromJSON(self.Spec)
+	if err != nil {
+		log.WithError(err).Warn("Failed to parse spec.")
+		return
+	}
+
+	targetRules := generateTargetFirewallRules(roleACL(spec, self.Role))
+
+	// Host networked services run on the workers, so only they open host ports.
+	if self.Role == db.Worker && hasHostConnections(spec) {
+		pubIntf := spec.PublicInterface
+		if pubIntf == "" {
+			pubIntf, err = getPublicInterface()
+			if err != nil {
+				log.WithError(err).Error("Failed to get public interface")
+				return
+			}
+		}
+		targetRules = append(targetRules,
+			generateHostPortRules(pubIntf, spec.Connections)...)
+	}
+
+	currRules, err := generateCurrentFirewallRules()
+	if err != nil {
+		log.WithError(err).Error("failed to get firewall rules")
+		return
+	}
+
+	_, rulesToDel, rulesToAdd := join.HashJoin(currRules, targetRules, nil, nil)
+
+	for _, rule := range rulesToDel {
+		rule := rule.(ipRule)
+		if err := sh("iptables -D %s %s", rule.chain, rule.opts); err != nil {
+			log.WithError(err).Error("failed to delete firewall rule")
+		}
+	}
+
+	for _, rule := range rulesToAdd {
+		// The ACCEPT rules must come before the DROP rule to have any effect.
+		rule := rule.(ipRule)
+		cmd := "-A"
+		if strings.HasSuffix(rule.opts, "-j ACCEPT") {
+			cmd = "-I"
+		}
+
+		err := sh("iptables %s %s %s", cmd, rule.chain, rule.opts)
+		if err != nil {
+			log.WithError(err).Error("failed to add firewall rule")
+		}
+	}
+}
+
+// roleACL returns the ACL the spec restricts SSH into machines with the given role
+// to.  It's shared with stitch.EffectiveACL, so that what the minions enforce is what
+// users are shown.
+func roleACL(spec stitch.Stitch, role db.Role) []string {
+	return spec.RoleACL(string(role))
+}
+
+func generateCurrentFirewallRules() (ipRuleSlice, error) {
+	stdout, _, err := shVerbose("iptables -S INPUT")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get IP tables: %s", err)
+	}
+
+	var rules ipRuleSlice
+	scanner := bufio.NewScanner(bytes.NewReader(stdout))
+	for scanner.Scan() {
+		rule, err := makeIPRule(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current IP rules: %s", err)
+		}
+
+		// Only the rules restricting SSH and opening host ports are managed
+		// by Quilt.
+		if rule.cmd == "-A" && (strings.Contains(rule.opts, sshRuleOpts) ||
+			strings.Contains(rule.opts, "--comment "+hostPortComment)) {
+			rules = append(rules, rule)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanner error while getting IP tables: %s", err)
+	}
+
+	return rules, nil
+}
+
+// generateTargetFirewallRules returns the INPUT rules that allow SSH only from the
+// CIDRs in `acl`.  If `acl` has no valid CIDRs, SSH isn't restricted, as dropping it
+// would lock everyone out of the machine.
+func generateTargetFirewallRules(acl []string) ipRuleSlice {
+	var rules ipRuleSlice
+	for _, cidr := range acl {
+		// Normalize the CIDR so that it matches the output of `iptables -S`.
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.WithError(err).Warn("Invalid ACL.")
+			continue
+		}
+
+		rules = append(rules, ipRule{
+			cmd:   "-A",
+			chain: "INPUT",
+			opts:  fmt.Sprintf("-s %s %s -j ACCEPT", ipNet, sshRuleOpts),
+		})
+	}
+
+	if len(rules) == 0 {
+		if len(acl) > 0 {
+			log.WithField("acl", acl).Warn(
+				"No valid CIDRs in ACL, leaving SSH unrestricted.")
+		}
+		return nil
+	}
+
+	return append(rules, ipRule{
+		cmd:   "-A",
+		chain: "INPUT",
+		opts:  sshRuleOpts + " -j DROP",
+	})
+}
+
+func hasHostConnections(spec stitch.Stitch) bool {
+	for _, c := range spec.Connections {
+		if c.HostNetwork {
+			return true
+		}
+	}
+	return false
+}
+
+// generateHostPortRules returns the INPUT rules that accept the host networked
+// connections in `conns` on the public interface.  Unlike connections to containers,
+// they aren't forwarded anywhere.
+func generateHostPortRules(publicInterface string,
+	conns []stitch.Connection) ipRuleSlice {
+
+	var rules ipRuleSlice
+	seen := map[ipRule]struct{}{}
+	for _, c := range conns {
+		if !c.HostNetwork {
+			continue
+		}
+
+		ports := strconv.Itoa(c.MinPort)
+		if c.MaxPort != c.MinPort {
+			ports += ":" + strconv.Itoa(c.MaxPort)
+		}
+
+		for _, protocol := range stitch.Protocols(c.Protocol) {
+			rule := ipRule{
+				cmd:   "-A",
+				chain: "INPUT",
+				opts: fmt.Sprintf("-i %[1]s -p %[2]s -m %[2]s --dport %[3]s "+
+					"-m comment --comment %[4]s -j ACCEPT",
+					publicInterface, protocol, ports, hostPortComment),
+			}
+			if _, ok := seen[rule]; !ok {
+				seen[rule] = struct{}{}
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
@@ -0,0 +1,109 @@
+package network
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleFirewallRules(t *testing.T) {
+	spec, err := stitch.FromJavascript(`createDeployment({
+		adminACL: ["local"],
+		masterACL: ["1.2.3.4/32"],
+		workerACL: ["10.1.2.3/16", "1.2.3.4/32"]
+	});`, stitch.DefaultImportGetter)
+	assert.NoError(t, err)
+
+	masterRules := generateTargetFirewallRules(roleACL(spec, db.Master))
+	assert.Equal(t, ipRuleSlice{
+		{"-A", "INPUT", "-s 1.2.3.4/32 -p tcp -m tcp --dport 22 -j ACCEPT"},
+		{"-A", "INPUT", "-p tcp -m tcp --dport 22 -j DROP"},
+	}, masterRules)
+
+	workerRules := generateTargetFirewallRules(roleACL(spec, db.Worker))
+	assert.Equal(t, ipRuleSlice{
+		{"-A", "INPUT", "-s 10.1.0.0/16 -p tcp -m tcp --dport 22 -j ACCEPT"},
+		{"-A", "INPUT", "-s 1.2.3.4/32 -p tcp -m tcp --dport 22 -j ACCEPT"},
+		{"-A", "INPUT", "-p tcp -m tcp --dport 22 -j DROP"},
+	}, workerRules)
+
+	// Without a role ACL, SSH is restricted to the AdminACL, rather than to every
+	// CIDR the cloud provider's firewall admits for the other role.
+	spec.WorkerACL = nil
+	spec.AdminACL = []string{"5.6.7.8/32"}
+	assert.Equal(t, ipRuleSlice{
+		{"-A", "INPUT", "-s 5.6.7.8/32 -p tcp -m tcp --dport 22 -j ACCEPT"},
+		{"-A", "INPUT", "-p tcp -m tcp --dport 22 -j DROP"},
+	}, generateTargetFirewallRules(roleACL(spec, db.Worker)))
+
+	spec.AdminACL = nil
+	assert.Empty(t, generateTargetFirewallRules(roleACL(spec, db.Worker)))
+
+	// A role ACL without valid CIDRs falls back to the AdminACL.
+	spec.WorkerACL = []string{"local"}
+	spec.AdminACL = []string{"5.6.7.8/32"}
+	assert.Equal(t, []string{"5.6.7.8/32"}, roleACL(spec, db.Worker))
+
+	// SSH is never dropped without a valid CIDR to accept it from.
+	assert.Empty(t, generateTargetFirewallRules([]string{"local", "bogus"}))
+}
+
+func TestGenerateCurrentFirewallRules(t *testing.T) {
+	oldShVerbose := shVerbose
+	defer func() { shVerbose = oldShVerbose }()
+	shVerbose = func(format string, args ...interface{}) (
+		stdout, stderr []byte, err error) {
+		return []byte("-P INPUT ACCEPT\n" +
+			"-A INPUT -i lo -j ACCEPT\n" +
+			"-A INPUT -s 1.2.3.4/32 -p tcp -m tcp --dport 22 -j ACCEPT\n" +
+			"-A INPUT -p tcp -m tcp --dport 22 -j DROP\n"), nil, nil
+	}
+
+	actual, err := generateCurrentFirewallRules()
+	assert.NoError(t, err)
+	assert.Equal(t, ipRuleSlice{
+		{"-A", "INPUT", "-s 1.2.3.4/32 -p tcp -m tcp --dport 22 -j ACCEPT"},
+		{"-A", "INPUT", "-p tcp -m tcp --dport 22 -j DROP"},
+	}, actual)
+}
+
+func TestHostNetworkConnection(t *testing.T) {
+	spec, err := stitch.FromJavascript(`var web = new Service("web",
+		[new Container("nginx")]);
+	deployment.deploy(web);
+	publicInternet.connect(80, web);
+	deployment.openHostPort(8080, "tcp");
+	deployment.openHostPort(new PortRange(9000, 9010));`, stitch.DefaultImportGetter)
+	assert.NoError(t, err)
+
+	assert.Equal(t, ipRuleSlice{
+		{"-A", "INPUT", "-i eth0 -p tcp -m tcp --dport 8080 " +
+			"-m comment --comment quilt-host-port -j ACCEPT"},
+		{"-A", "INPUT", "-i eth0 -p tcp -m tcp --dport 9000:9010 " +
+			"-m comment --comment quilt-host-port -j ACCEPT"},
+		{"-A", "INPUT", "-i eth0 -p udp -m udp --dport 9000:9010 " +
+			"-m comment --comment quilt-host-port -j ACCEPT"},
+	}, generateHostPortRules("eth0", spec.Connections))
+
+	// Only the connection to the container is forwarded.
+	var conns []db.Connection
+	for _, c := range spec.Connections {
+		conns = append(conns, db.Connection{From: c.From, To: c.To,
+			MinPort: c.MinPort, MaxPort: c.MaxPort, Protocol: c.Protocol})
+	}
+	containers := []db.Container{{IP: "10.0.0.2", Labels: []string{"web"}}}
+	var dnats []string
+	for _, rule := range generateTargetNatRules("eth0", containers, conns) {
+		if strings.Contains(rule.opts, "DNAT") {
+			dnats = append(dnats, rule.opts)
+		}
+	}
+	assert.Equal(t, []string{
+		"-i eth0 -p tcp -m tcp --dport 80 -j DNAT --to-destination 10.0.0.2:80",
+		"-i eth0 -p udp -m udp --dport 80 -j DNAT --to-destination 10.0.0.2:80",
+	}, dnats)
+}
@@ -0,0 +1,149 @@
+package network
+
+import (
+	"time"
+
+	"github.com/NetSys/quilt/counter"
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/minion/ipdef"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	vethGCInterval = 5 * time.Minute
+
+	// How long a veth must go unclaimed by any container before it's removed.  The
+	// network plugin creates a container's veth before the container is in the
+	// database, so this must comfortably exceed the time it takes to boot one.
+	vethGCGracePeriod = 10 * time.Minute
+
+	// If set in the minion's environment, orphaned veths are logged rather than
+	// removed.
+	vethGCDryRunEnv = "QUILT_VETH_GC_DRY_RUN"
+)
+
+var vethReclaimCounter = counter.New("network", "Reclaim Veth")
+
+// vethGC removes the veths of containers that died without the network plugin
+// cleaning up after them, e.g. because the minion was restarting.  Veths that don't
+// belong to any container in the database are only removed once they've been
+// orphaned for the grace period, so the veths of containers that are still booting
+// are left alone.  Their OVS ports are removed by updatePorts, which only keeps the
+// ports of containers in the database.
+type vethGC struct {
+	conn   db.Conn
+	dryRun bool
+
+	// When each orphaned veth was first seen, keyed by name.
+	orphanedAt map[string]time.Time
+
+	// Stored in fields so that they may be mocked.
+	listVeths  func() ([]string, error)
+	deleteVeth func(name string) error
+}
+
+func newVethGC(conn db.Conn, dryRun bool) *vethGC {
+	return &vethGC{
+		conn:       conn,
+		dryRun:     dryRun,
+		orphanedAt: map[string]time.Time{},
+		listVeths:  listVeths,
+		deleteVeth: deleteVeth,
+	}
+}
+
+func (gc *vethGC) run() {
+	for range time.Tick(vethGCInterval) {
+		gc.collect(time.Now())
+	}
+}
+
+// collect removes the veths that have been orphaned since before the grace period.
+func (gc *vethGC) collect(now time.Time) {
+	var minion db.Minion
+	var minionErr error
+	var containers []db.Container
+	gc.conn.Txn(db.ContainerTable, db.MinionTable).Run(func(view db.Database) error {
+		minion, minionErr = view.MinionSelf()
+		containers = view.SelectFromContainer(nil)
+		return nil
+	})
+
+	if minionErr != nil || !minion.SupervisorInit || minion.Role != db.Worker {
+		return
+	}
+
+	veths, err := gc.listVeths()
+	if err != nil {
+		log.WithError(err).Error("Failed to list veths.")
+		return
+	}
+
+	claimed := map[string]struct{}{}
+	for _, dbc := range containers {
+		if dbc.EndpointID != "" {
+			outer, inner := ipdef.VethNames(dbc.EndpointID)
+			claimed[outer] = struct{}{}
+			claimed[inner] = struct{}{}
+		}
+	}
+
+	orphanedAt := map[string]time.Time{}
+	for _, name := range veths {
+		if _, ok := claimed[name]; ok || !ipdef.IsVethName(name) {
+			continue
+		}
+
+		first, ok := gc.orphanedAt[name]
+		if !ok {
+			first = now
+		}
+
+		if now.Sub(first) < vethGCGracePeriod {
+			orphanedAt[name] = first
+			continue
+		}
+
+		if gc.dryRun {
+			log.WithField("veth", name).Info("Would reclaim orphaned veth.")
+			orphanedAt[name] = first
+			continue
+		}
+
+		if err := gc.deleteVeth(name); err != nil {
+			log.WithError(err).WithField("veth", name).Warn(
+				"Failed to reclaim orphaned veth.")
+			orphanedAt[name] = first
+			continue
+		}
+		vethReclaimCounter.Inc()
+		log.WithField("veth", name).Info("Reclaimed orphaned veth.")
+	}
+	gc.orphanedAt = orphanedAt
+}
+
+// listVeths returns the names of the veths in the host's network namespace.
+func listVeths() ([]string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, link := range links {
+		if link.Type() == "veth" {
+			names = append(names, link.Attrs().Name)
+		}
+	}
+	return names, nil
+}
+
+func deleteVeth(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkDel(link)
+}
@@ -0,0 +1,88 @@
+package network
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/minion/ipdef"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVethGC(t *testing.T) {
+	t.Parallel()
+
+	conn := db.New()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.InsertMinion()
+		m.Self = true
+		m.SupervisorInit = true
+		m.Role = db.Worker
+		view.Commit(m)
+
+		dbc := view.InsertContainer()
+		dbc.EndpointID = "aaaa"
+		view.Commit(dbc)
+		return nil
+	})
+
+	liveOuter, liveInner := ipdef.VethNames("aaaa")
+	orphanOuter, orphanInner := ipdef.VethNames("bbbb")
+	veths := []string{liveOuter, liveInner, orphanOuter, orphanInner, "docker0"}
+
+	var deleted []string
+	gc := newVethGC(conn, false)
+	gc.listVeths = func() ([]string, error) { return veths, nil }
+	gc.deleteVeth = func(name string) error {
+		deleted = append(deleted, name)
+		return nil
+	}
+
+	// Orphans are left alone until the grace period has passed.
+	start := time.Now()
+	gc.collect(start)
+	assert.Empty(t, deleted)
+
+	gc.collect(start.Add(vethGCGracePeriod / 2))
+	assert.Empty(t, deleted)
+
+	gc.collect(start.Add(vethGCGracePeriod))
+	assert.Equal(t, []string{orphanOuter, orphanInner}, deleted)
+	assert.Empty(t, gc.orphanedAt)
+
+	// An orphan that disappears on its own is forgotten.
+	deleted = nil
+	veths = []string{liveOuter, orphanOuter}
+	gc.collect(start)
+	veths = []string{liveOuter}
+	gc.collect(start.Add(vethGCGracePeriod / 2))
+	veths = []string{liveOuter, orphanOuter}
+	gc.collect(start.Add(vethGCGracePeriod))
+	assert.Empty(t, deleted)
+
+	// Veths that fail to delete are retried.
+	gc.deleteVeth = func(name string) error { return errors.New("err") }
+	gc.collect(start.Add(2 * vethGCGracePeriod))
+	assert.Contains(t, gc.orphanedAt, orphanOuter)
+
+	// Dry runs don't delete anything.
+	gc.dryRun = true
+	gc.deleteVeth = func(name string) error {
+		deleted = append(deleted, name)
+		return nil
+	}
+	gc.collect(start.Add(3 * vethGCGracePeriod))
+	assert.Empty(t, deleted)
+
+	// Masters don't collect.
+	gc.dryRun = false
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m, _ := view.MinionSelf()
+		m.Role = db.Master
+		view.Commit(m)
+		return nil
+	})
+	gc.collect(start.Add(4 * vethGCGracePeriod))
+	assert.Empty(t, deleted)
+}
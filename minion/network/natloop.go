@@ -0,0 +1,218 @@
+package network
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const defaultNATInterval = 30 * time.Second
+
+// natLoop reconciles the worker's NAT and egress rules in a loop of its own, so that
+// on busy workers it doesn't wait behind the rest of the network configuration.  It
+// runs when the containers or connections change, when triggered, and otherwise at
+// the spec's NATInterval.
+type natLoop struct {
+	conn db.Conn
+
+	// A send on `triggered` forces an immediate reconcile, rather than waiting for
+	// the next tick.
+	triggered chan struct{}
+
+	// The machine's public interface, as detected from its default routes.  An
+	// interface configured in the spec takes precedence.
+	publicInterface string
+
+	// Stored in fields so that they may be mocked.
+	update func(backend, publicInterface string, containers []db.Container,
+		connections []db.Connection)
+	clear        func()
+	updateEgress func(publicInterface string, labels []stitch.Label,
+		containers []db.Container)
+}
+
+func newNATLoop(conn db.Conn) *natLoop {
+	return &natLoop{
+		conn:      conn,
+		triggered: make(chan struct{}, 1),
+		update:    updateNAT,
+		clear:     clearNAT,
+
+		updateEgress: updateEgress,
+	}
+}
+
+// trigger forces an immediate reconcile.  It doesn't block, as a pending trigger
+// already guarantees one.
+func (loop *natLoop) trigger() {
+	select {
+	case loop.triggered <- struct{}{}:
+	default:
+	}
+}
+
+func (loop *natLoop) run() {
+	dbTrigger := loop.conn.Trigger(db.MinionTable, db.ContainerTable,
+		db.ConnectionTable)
+	defer dbTrigger.Stop()
+
+	for {
+		timer := time.NewTimer(loop.reconcile())
+		select {
+		case <-loop.triggered:
+		case <-dbTrigger.C:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// reconcile updates the NAT and egress rules, and returns how long to wait before the
+// next periodic reconcile.
+func (loop *natLoop) reconcile() time.Duration {
+	var minion db.Minion
+	var minionErr error
+	var containers []db.Container
+	var connections []db.Connection
+	loop.conn.Txn(db.ConnectionTable, db.ContainerTable,
+		db.MinionTable).Run(func(view db.Database) error {
+
+		minion, minionErr = view.MinionSelf()
+		containers = view.SelectFromContainer(func(c db.Container) bool {
+			return c.DockerID != "" && c.IP != "" && c.Mac != "" &&
+				c.Pid != 0
+		})
+		connections = view.SelectFromConnection(nil)
+		return nil
+	})
+
+	var spec stitch.Stitch
+	if minion.Spec != "" {
+		var err error
+		if spec, err = stitch.FromJSON(minion.Spec); err != nil {
+			log.WithError(err).Warn("Failed to parse spec.")
+		}
+	}
+
+	interval := defaultNATInterval
+	if spec.NATInterval > 0 {
+		interval = time.Duration(spec.NATInterval) * time.Second
+	}
+
+	if minionErr != nil || !minion.SupervisorInit || minion.Role != db.Worker {
+		return interval
+	}
+
+	// Rules installed before NAT was disabled would otherwise linger.
+	if minion.DisableNAT {
+		loop.clear()
+		return interval
+	}
+
+	pubIntf := spec.PublicInterface
+	if pubIntf == "" {
+		if loop.publicInterface == "" {
+			var err error
+			loop.publicInterface, err = getPublicInterface()
+			if err != nil {
+				log.WithError(err).Error(
+					"Failed to get public interface")
+				return interval
+			}
+		}
+		pubIntf = loop.publicInterface
+	}
+
+	withdrawnPorts = probePublicPorts(containers, connections, withdrawnPorts)
+	loop.update(spec.NATBackend, pubIntf, containers, connections)
+	loop.updateEgress(pubIntf, spec.Labels, containers)
+	return interval
+}
+
+// The public ports withdrawn from each container, by IP, because the container failed
+// the probe of the connection that opens them.  natOwners doesn't forward them.  Only
+// the NAT loop touches it.
+var withdrawnPorts = map[string]map[publicPort]struct{}{}
+
+// probePublicPorts probes the containers behind each public connection that has a
+// probe, and returns the ports to withdraw from those that fail, by container IP.
+// `withdrawn`, the ports currently withdrawn, only serves to log the changes.
+func probePublicPorts(containers []db.Container, connections []db.Connection,
+	withdrawn map[string]map[publicPort]struct{}) (
+	next map[string]map[publicPort]struct{}) {
+
+	type probe struct {
+		dbc   db.Container
+		conn  db.Connection
+		query []byte
+	}
+
+	var probes []probe
+	for _, conn := range connections {
+		if conn.From != stitch.PublicInternetLabel || conn.ProbeUDPQuery == "" {
+			continue
+		}
+
+		query, err := hex.DecodeString(conn.ProbeUDPQuery)
+		if err != nil {
+			log.WithError(err).Warnf("Malformed probe of connection %s->%s",
+				conn.From, conn.To)
+			continue
+		}
+
+		for _, dbc := range containers {
+			for _, label := range dbc.Labels {
+				if label == conn.To {
+					probes = append(probes, probe{dbc, conn, query})
+					break
+				}
+			}
+		}
+	}
+
+	// Each probe may take until it times out, so they run in parallel.
+	errs := make([]error, len(probes))
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func(i int, p probe) {
+			defer wg.Done()
+			errs[i] = probeUDP(p.dbc, p.conn.MinPort, p.query,
+				p.conn.ProbeExpectResponse)
+		}(i, p)
+	}
+	wg.Wait()
+
+	next = map[string]map[publicPort]struct{}{}
+	for i, p := range probes {
+		port := publicPort{p.conn.MinPort, p.conn.MaxPort, stitch.UDP}
+		_, wasWithdrawn := withdrawn[p.dbc.IP][port]
+		logger := log.WithFields(log.Fields{
+			"container": p.dbc.IP,
+			"port":      port.minPort,
+		})
+
+		if errs[i] == nil {
+			if wasWithdrawn {
+				logger.Info("Container passed its probe, restoring " +
+					"its public port")
+			}
+			continue
+		}
+
+		if !wasWithdrawn {
+			logger.WithError(errs[i]).Warn("Container failed its probe, " +
+				"withdrawing its public port")
+		}
+		if next[p.dbc.IP] == nil {
+			next[p.dbc.IP] = map[publicPort]struct{}{}
+		}
+		next[p.dbc.IP][port] = struct{}{}
+	}
+	return next
+}
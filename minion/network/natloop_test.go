@@ -0,0 +1,222 @@
+package network
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNATLoopTrigger(t *testing.T) {
+	conn := db.New()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.InsertMinion()
+		m.Self = true
+		m.SupervisorInit = true
+		m.Role = db.Worker
+		m.Spec = stitch.Stitch{
+			NATBackend:  stitch.NFTables,
+			NATInterval: 3600,
+		}.String()
+		view.Commit(m)
+		return nil
+	})
+
+	backends := make(chan string, 8)
+	loop := newNATLoop(conn)
+	loop.publicInterface = "eth0"
+	loop.update = func(backend, _ string, _ []db.Container, _ []db.Connection) {
+		backends <- backend
+	}
+	loop.updateEgress = func(string, []stitch.Label, []db.Container) {}
+
+	awaitUpdate := func() bool {
+		select {
+		case backend := <-backends:
+			assert.Equal(t, stitch.NFTables, backend)
+			return true
+		case <-time.After(5 * time.Second):
+			return false
+		}
+	}
+
+	go loop.run()
+	assert.True(t, awaitUpdate(), "no initial reconcile")
+
+	// The next tick is an hour away, so only the trigger can cause an update.
+	loop.trigger()
+	assert.True(t, awaitUpdate(), "trigger didn't cause a reconcile")
+
+	// Changes to the containers cause an update as well.
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		view.Commit(view.InsertContainer())
+		return nil
+	})
+	assert.True(t, awaitUpdate(), "container change didn't cause a reconcile")
+}
+
+func TestNATLoopDisabled(t *testing.T) {
+	conn := db.New()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.InsertMinion()
+		m.Self = true
+		m.SupervisorInit = true
+		m.Role = db.Worker
+		m.DisableNAT = true
+		view.Commit(m)
+		return nil
+	})
+
+	updates, clears := 0, 0
+	loop := newNATLoop(conn)
+	loop.publicInterface = "eth0"
+	loop.update = func(_, _ string, _ []db.Container, _ []db.Connection) {
+		updates++
+	}
+	loop.clear = func() { clears++ }
+
+	// Workers flagged to skip NAT have their NAT rules cleared, not updated.
+	assert.Equal(t, defaultNATInterval, loop.reconcile())
+	assert.Zero(t, updates)
+	assert.Equal(t, 1, clears)
+
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m, err := view.MinionSelf()
+		assert.NoError(t, err)
+		m.DisableNAT = false
+		view.Commit(m)
+		return nil
+	})
+	loop.reconcile()
+	assert.Equal(t, 1, updates)
+	assert.Equal(t, 1, clears)
+}
+
+func TestClearNAT(t *testing.T) {
+	nat := &fakeNat{rules: map[string]struct{}{}}
+	oldShVerbose := shVerbose
+	defer func() { shVerbose = oldShVerbose }()
+	shVerbose = nat.shVerbose
+
+	natScope.owners = nil
+	defer func() {
+		natScope.owners = nil
+		natScope.stale = false
+		activeNAT = nil
+	}()
+
+	// Nothing was installed, so there's nothing to clear.
+	activeNAT = nil
+	clearNAT()
+	assert.Nil(t, activeNAT)
+
+	dbc := db.Container{IP: "10.0.0.2", Labels: []string{"web"}}
+	conn := db.Connection{From: stitch.PublicInternetLabel, To: "web",
+		MinPort: 80, MaxPort: 80, Protocol: "tcp"}
+	updateNAT(stitch.IPTables, "eth0", []db.Container{dbc},
+		[]db.Connection{conn})
+	assert.NotNil(t, activeNAT)
+	dnat := "-A PREROUTING -i eth0 -p tcp -m tcp --dport 80 -j DNAT " +
+		"--to-destination 10.0.0.2:80"
+	assert.Contains(t, nat.rules, dnat)
+
+	clearNAT()
+	assert.Nil(t, activeNAT)
+	assert.NotContains(t, nat.rules, dnat)
+}
+
+func TestNATLoopProbe(t *testing.T) {
+	nat := &fakeNat{rules: map[string]struct{}{}}
+	oldShVerbose := shVerbose
+	defer func() { shVerbose = oldShVerbose }()
+	shVerbose = nat.shVerbose
+
+	natScope.owners = nil
+	defer func() {
+		natScope.owners = nil
+		natScope.stale = false
+		withdrawnPorts = map[string]map[publicPort]struct{}{}
+	}()
+
+	answering := map[string]bool{"10.0.0.2": true, "10.0.0.3": true}
+	oldProbeUDP := probeUDP
+	defer func() { probeUDP = oldProbeUDP }()
+	probeUDP = func(dbc db.Container, port int, query []byte,
+		expectResponse bool) error {
+
+		assert.Equal(t, 53, port)
+		assert.Equal(t, []byte{0xab, 0xcd}, query)
+		assert.True(t, expectResponse)
+		if !answering[dbc.IP] {
+			return errors.New("no reply")
+		}
+		return nil
+	}
+
+	conn := db.New()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.InsertMinion()
+		m.Self = true
+		m.SupervisorInit = true
+		m.Role = db.Worker
+		view.Commit(m)
+
+		for _, ip := range []string{"10.0.0.2", "10.0.0.3"} {
+			dbc := view.InsertContainer()
+			dbc.DockerID = ip
+			dbc.IP = ip
+			dbc.Mac = "00:00:00:00:00:00"
+			dbc.Pid = 1
+			dbc.Labels = []string{"dns"}
+			view.Commit(dbc)
+		}
+
+		for _, protocol := range []string{"udp", "tcp"} {
+			c := view.InsertConnection()
+			c.From = stitch.PublicInternetLabel
+			c.To = "dns"
+			c.MinPort = 53
+			c.MaxPort = 53
+			c.Protocol = protocol
+			if protocol == "udp" {
+				c.ProbeUDPQuery = "abcd"
+				c.ProbeExpectResponse = true
+			}
+			view.Commit(c)
+		}
+		return nil
+	})
+
+	loop := newNATLoop(conn)
+	loop.publicInterface = "eth0"
+	loop.update = func(_, publicInterface string, containers []db.Container,
+		connections []db.Connection) {
+		iptablesNAT{}.update(publicInterface, containers, connections)
+	}
+	loop.updateEgress = func(string, []stitch.Label, []db.Container) {}
+
+	dnat := func(protocol, ip string) string {
+		return "-A PREROUTING -i eth0 -p " + protocol + " -m " + protocol +
+			" --dport 53 -j DNAT --to-destination " + ip + ":53"
+	}
+
+	loop.reconcile()
+	assert.Contains(t, nat.rules, dnat("udp", "10.0.0.2"))
+	assert.Contains(t, nat.rules, dnat("udp", "10.0.0.3"))
+
+	// A container that stops answering has its UDP port withdrawn.  Its TCP port
+	// isn't probed, so it stays.
+	answering["10.0.0.3"] = false
+	loop.reconcile()
+	assert.Contains(t, nat.rules, dnat("udp", "10.0.0.2"))
+	assert.NotContains(t, nat.rules, dnat("udp", "10.0.0.3"))
+	assert.Contains(t, nat.rules, dnat("tcp", "10.0.0.3"))
+
+	// Once it answers again, the port is restored.
+	answering["10.0.0.3"] = true
+	loop.reconcile()
+	assert.Contains(t, nat.rules, dnat("udp", "10.0.0.3"))
+}
@@ -7,6 +7,7 @@ package network
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/join"
@@ -33,6 +34,9 @@ type dbslice []dbport
 
 // Run blocks implementing the network services.
 func Run(conn db.Conn, dk docker.Client) {
+	go newNATLoop(conn).run()
+	go newVethGC(conn, os.Getenv(vethGCDryRunEnv) != "").run()
+
 	loopLog := util.NewEventTimer("Network")
 	for range conn.TriggerTick(30, db.MinionTable, db.ContainerTable,
 		db.ConnectionTable, db.LabelTable, db.EtcdTable).C {
@@ -40,6 +44,7 @@ func Run(conn db.Conn, dk docker.Client) {
 		loopLog.LogStart()
 		runWorker(conn, dk)
 		runMaster(conn)
+		runFirewall(conn)
 		loopLog.LogEnd()
 	}
 }
@@ -134,7 +139,7 @@ func runMaster(conn db.Conn) {
 		}
 	}
 
-	updateACLs(ovsdbClient, connections, labels)
+	updateACLs(ovsdbClient, connections, labels, containers)
 }
 
 // Len returns the length of the slice
@@ -10,6 +10,8 @@ import (
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/join"
 	"github.com/NetSys/quilt/minion/ovsdb"
+	"github.com/NetSys/quilt/stitch"
+	"github.com/stretchr/testify/assert"
 )
 
 type lportslice []ovsdb.LPort
@@ -109,7 +111,7 @@ func TestRunMaster(t *testing.T) {
 func checkAddressSet(t *testing.T, client ovsdb.Client,
 	labels []db.Label, exp []ovsdb.AddressSet) {
 
-	syncAddressSets(client, labels)
+	syncAddressSets(client, labels, nil)
 	actual, _ := client.ListAddressSets(lSwitch)
 
 	ovsdbKey := func(intf interface{}) interface{} {
@@ -183,7 +185,7 @@ func TestAddressSetSync(t *testing.T) {
 func checkACLs(t *testing.T, client ovsdb.Client,
 	connections []db.Connection, exp []ovsdb.ACL) {
 
-	syncACLs(client, connections)
+	syncACLs(client, connections, nil)
 
 	actual, _ := client.ListACLs(lSwitch)
 
@@ -281,4 +283,91 @@ func TestACLSync(t *testing.T) {
 		[]db.Connection{dashConnection},
 		append(dropACLs, dashACLs...),
 	)
+
+	// ICMP connections don't allow any TCP or UDP traffic.
+	icmpConnection := db.Connection{
+		From:     "red",
+		To:       "blue",
+		Protocol: stitch.ICMP,
+	}
+	icmpACLs := directedACLs(ovsdb.ACL{
+		Core: ovsdb.ACLCore{
+			Priority: 1,
+			Match: "(((ip4.src == $red && ip4.dst == $blue) && (icmp)) || " +
+				"((ip4.src == $blue && ip4.dst == $red) && (icmp)))",
+			Action: "allow",
+		},
+	})
+	checkACLs(t, client,
+		[]db.Connection{icmpConnection},
+		append(dropACLs, icmpACLs...),
+	)
+}
+
+func TestNetworkACLs(t *testing.T) {
+	t.Parallel()
+
+	labels := []db.Label{
+		{Label: "web", IP: "10.0.0.2", ContainerIPs: []string{"10.1.0.2"}},
+		{Label: "vault", IP: "10.0.0.3", ContainerIPs: []string{"10.1.0.3"}},
+	}
+	containers := []db.Container{
+		{IP: "10.1.0.2", Labels: []string{"web"}},
+		{IP: "10.1.0.3", Labels: []string{"vault"}, Network: "pci"},
+	}
+
+	// Deployments without isolated networks need no extra address sets.
+	assert.Empty(t, networkAddressSets(labels, containers[:1]))
+
+	networks := networkAddressSets(labels, containers)
+	assert.Equal(t, []ovsdb.AddressSet{
+		{Name: "NETWORK.default", Addresses: []string{"10.0.0.2", "10.1.0.2"}},
+		{Name: "NETWORK.pci", Addresses: []string{"10.0.0.3", "10.1.0.3"}},
+	}, sortedAddresses(networks))
+
+	client := ovsdb.NewFakeOvsdbClient()
+	client.CreateLogicalSwitch(lSwitch)
+	syncAddressSets(client, labels, networks)
+	actual, _ := client.ListAddressSets(lSwitch)
+	assert.Len(t, actual, 4)
+
+	// Traffic between the networks is dropped, even over connections, unless
+	// they allow crossing networks.
+	mistake := db.Connection{From: "web", To: "vault", MinPort: 80, MaxPort: 80}
+	allowed := db.Connection{From: "vault", To: "web", MinPort: 443,
+		MaxPort: 443, AllowCrossNetwork: true}
+	syncACLs(client, []db.Connection{mistake, allowed}, networks)
+
+	acls, _ := client.ListACLs(lSwitch)
+	priorities := map[int][]string{}
+	for _, acl := range acls {
+		if acl.Core.Direction == "from-lport" {
+			priorities[acl.Core.Priority] = append(
+				priorities[acl.Core.Priority], acl.Core.Action)
+		}
+	}
+	assert.Equal(t, map[int][]string{
+		defaultDropPriority:  {"drop"},
+		connectionPriority:   {"allow", "allow"},
+		networkDropPriority:  {"drop"},
+		crossNetworkPriority: {"allow"},
+	}, priorities)
+
+	for _, acl := range acls {
+		switch acl.Core.Priority {
+		case networkDropPriority:
+			assert.Equal(t, "((ip4.src == $NETWORK.default && "+
+				"ip4.dst == $NETWORK.pci) || (ip4.src == $NETWORK.pci && "+
+				"ip4.dst == $NETWORK.default))", acl.Core.Match)
+		case crossNetworkPriority:
+			assert.Equal(t, matchString(allowed), acl.Core.Match)
+		}
+	}
+}
+
+func sortedAddresses(sets []ovsdb.AddressSet) []ovsdb.AddressSet {
+	for _, set := range sets {
+		sort.Strings(set.Addresses)
+	}
+	return sets
 }
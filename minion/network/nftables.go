@@ -0,0 +1,124 @@
+package network
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NetSys/quilt/counter"
+	"github.com/NetSys/quilt/db"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The nftables table that holds all of Quilt's NAT rules.
+const nftTable = "ip quilt-nat"
+
+var nftApplyCounter = counter.New("network", "Apply nftables Ruleset")
+
+// nftablesNAT installs NAT rules as a single nftables ruleset, which replaces the
+// previous one atomically.  This scales better than iptables with many rules.
+type nftablesNAT struct {
+	ruleset     string // The ruleset last applied.
+	lastApplied time.Time
+}
+
+func (nft *nftablesNAT) update(publicInterface string, containers []db.Container,
+	connections []db.Connection) {
+
+	// The ruleset is applied periodically even if it didn't change, to repair
+	// rules changed behind our back.
+	ruleset := nftRuleset(publicInterface, containers, connections)
+	if ruleset == nft.ruleset && time.Since(nft.lastApplied) < fullSyncInterval {
+		return
+	}
+
+	nftApplyCounter.Inc()
+	if err := nftApply(ruleset); err != nil {
+		natFailureCounter.Inc()
+		log.WithError(err).Error("Failed to apply nftables ruleset.")
+		nft.ruleset = ""
+		return
+	}
+	nft.ruleset = ruleset
+	nft.lastApplied = time.Now()
+}
+
+func (nft *nftablesNAT) clear() {
+	if !nftInstalled() {
+		return
+	}
+
+	// Declaring the table first makes deleting it succeed even if it's missing.
+	err := nftApply(fmt.Sprintf("table %[1]s\ndelete table %[1]s\n", nftTable))
+	if err != nil {
+		log.WithError(err).Debug("Failed to delete nftables ruleset.")
+	}
+	nft.ruleset = ""
+}
+
+// nftRuleset returns the nftables ruleset equivalent of the iptables rules returned
+// by natOwners.  It replaces Quilt's table, so applying it is atomic.
+func nftRuleset(publicInterface string, containers []db.Container,
+	connections []db.Connection) string {
+
+	var dnat []string
+	for ip, ports := range portsFromWeb(containers, connections) {
+		for port := range ports {
+			if port.maxPort != port.minPort {
+				dnat = append(dnat, fmt.Sprintf(
+					"iifname %q %s dport %d-%d dnat to %s",
+					publicInterface, port.protocol, port.minPort,
+					port.maxPort, ip))
+				continue
+			}
+
+			dnat = append(dnat, fmt.Sprintf(
+				"iifname %q %s dport %d dnat to %s:%d",
+				publicInterface, port.protocol, port.minPort, ip,
+				port.minPort))
+		}
+	}
+	sort.Strings(dnat)
+
+	lines := []string{
+		"table " + nftTable,
+		"delete table " + nftTable,
+		"table " + nftTable + " {",
+		"\tchain prerouting {",
+		"\t\ttype nat hook prerouting priority -100; policy accept;",
+	}
+	for _, rule := range dnat {
+		lines = append(lines, "\t\t"+rule)
+	}
+	lines = append(lines,
+		"\t}",
+		"\tchain postrouting {",
+		"\t\ttype nat hook postrouting priority 100; policy accept;",
+		fmt.Sprintf("\t\tip saddr 10.0.0.0/8 oifname %q masquerade",
+			publicInterface),
+		"\t}",
+		"}",
+	)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// nftInstalled returns whether the nft tool is installed.  Minion images built before
+// it was added to them lack it.  It's a variable so that it may be mocked.
+var nftInstalled = func() bool {
+	_, err := exec.LookPath("nft")
+	return err == nil
+}
+
+// nftApply applies `ruleset` with nft in a single transaction.  It's a variable so
+// that it may be mocked.
+var nftApply = func(ruleset string) error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(ruleset)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNftRuleset(t *testing.T) {
+	containers := []db.Container{
+		{IP: "10.0.0.2", Labels: []string{"web"}},
+		{IP: "10.0.0.3", Labels: []string{"dns"}},
+		{IP: "10.0.0.4", Labels: []string{"db"}},
+	}
+	connections := []db.Connection{
+		{From: "public", To: "web", MinPort: 80, MaxPort: 80, Protocol: "tcp"},
+		{From: "public", To: "dns", MinPort: 53, MaxPort: 53},
+		{From: "web", To: "db", MinPort: 5432, MaxPort: 5432},
+		{From: "public", To: "db", MinPort: stitch.EphemeralMinPort,
+			MaxPort: stitch.EphemeralMaxPort, Protocol: "tcp"},
+	}
+
+	exp := `table ip quilt-nat
+delete table ip quilt-nat
+table ip quilt-nat {
+	chain prerouting {
+		type nat hook prerouting priority -100; policy accept;
+		iifname "eth0" tcp dport 32768-60999 dnat to 10.0.0.4
+		iifname "eth0" tcp dport 53 dnat to 10.0.0.3:53
+		iifname "eth0" tcp dport 80 dnat to 10.0.0.2:80
+		iifname "eth0" udp dport 53 dnat to 10.0.0.3:53
+	}
+	chain postrouting {
+		type nat hook postrouting priority 100; policy accept;
+		ip saddr 10.0.0.0/8 oifname "eth0" masquerade
+	}
+}
+`
+	assert.Equal(t, exp, nftRuleset("eth0", containers, connections))
+}
+
+func TestUpdateNATBackend(t *testing.T) {
+	nat := &fakeNat{rules: map[string]struct{}{
+		"-P PREROUTING ACCEPT":  {},
+		"-P INPUT ACCEPT":       {},
+		"-P OUTPUT ACCEPT":      {},
+		"-P POSTROUTING ACCEPT": {},
+	}}
+	oldShVerbose := shVerbose
+	defer func() { shVerbose = oldShVerbose }()
+	shVerbose = nat.shVerbose
+
+	var applied []string
+	oldNftApply := nftApply
+	defer func() { nftApply = oldNftApply }()
+	nftApply = func(ruleset string) error {
+		applied = append(applied, ruleset)
+		return nil
+	}
+
+	installed := true
+	oldNftInstalled := nftInstalled
+	defer func() { nftInstalled = oldNftInstalled }()
+	nftInstalled = func() bool { return installed }
+
+	natScope.owners = nil
+	activeNAT = nil
+	defer func() {
+		natScope.owners = nil
+		activeNAT = nil
+		*nftNAT = nftablesNAT{}
+	}()
+
+	containers := []db.Container{{IP: "10.0.0.2", Labels: []string{"web"}}}
+	connections := []db.Connection{
+		{From: "public", To: "web", MinPort: 80, MaxPort: 80, Protocol: "tcp"},
+	}
+	dnat := "-A PREROUTING -i eth0 -p tcp -m tcp --dport 80 -j DNAT " +
+		"--to-destination 10.0.0.2:80"
+	masquerade := "-A POSTROUTING -s 10.0.0.0/8 -o eth0 -j MASQUERADE"
+
+	// The default backend is iptables, and the nftables table is cleared in case
+	// a previous minion created it.
+	updateNAT("", "eth0", containers, connections)
+	assert.Contains(t, nat.rules, dnat)
+	assert.Contains(t, nat.rules, masquerade)
+	assert.Equal(t, []string{"table ip quilt-nat\ndelete table ip quilt-nat\n"},
+		applied)
+
+	// Switching to nftables removes the iptables rules.
+	applied = nil
+	updateNAT(stitch.NFTables, "eth0", containers, connections)
+	assert.NotContains(t, nat.rules, dnat)
+	assert.NotContains(t, nat.rules, masquerade)
+	assert.Equal(t, []string{nftRuleset("eth0", containers, connections)}, applied)
+
+	// The unchanged ruleset isn't applied again.
+	updateNAT(stitch.NFTables, "eth0", containers, connections)
+	assert.Len(t, applied, 1)
+
+	applied = nil
+	updateNAT(stitch.IPTables, "eth0", containers, connections)
+	assert.Contains(t, nat.rules, dnat)
+	assert.Equal(t, []string{"table ip quilt-nat\ndelete table ip quilt-nat\n"},
+		applied)
+
+	// Without nft, nftables falls back to iptables.
+	installed = false
+	applied = nil
+	updateNAT(stitch.NFTables, "eth0", containers, connections)
+	assert.Contains(t, nat.rules, dnat)
+	assert.Contains(t, nat.rules, masquerade)
+	assert.Empty(t, applied)
+}
@@ -0,0 +1,111 @@
+package network
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/minion/ipdef"
+	"github.com/NetSys/quilt/stitch"
+)
+
+// The public interface assumed by RulePlan if the spec doesn't name one.
+const planPublicInterface = "eth0"
+
+// RulePlan computes the NAT rules each worker would install to run `spec`, keyed by
+// the worker's IP.  `placement` maps the ID of each of the spec's containers to the
+// IP of the worker it's scheduled on.  This lets the NAT logic be tested end to end
+// without a cluster.
+//
+// Containers aren't given IPs until they're scheduled, so each stands in with the
+// address PlanIP derives from its ID.  The public interface is the spec's, or eth0
+// if it doesn't name one.
+func RulePlan(spec stitch.Stitch, placement map[int]string) map[string][]string {
+	labels := map[int][]string{}
+	for _, label := range spec.Labels {
+		for _, id := range label.IDs {
+			labels[id] = append(labels[id], label.Name)
+		}
+	}
+
+	// Host networked connections are left to the firewall, as they are by the
+	// minion's engine.
+	var connections []db.Connection
+	for _, c := range spec.Connections {
+		if !c.HostNetwork {
+			connections = append(connections, db.Connection{
+				From:     c.From,
+				To:       c.To,
+				MinPort:  c.MinPort,
+				MaxPort:  c.MaxPort,
+				Protocol: c.Protocol,
+			})
+		}
+	}
+
+	// Workers without containers still install the host's rules.
+	workers := map[string][]db.Container{}
+	for _, worker := range placement {
+		workers[worker] = nil
+	}
+
+	for _, c := range spec.Containers {
+		// Containers outside the overlay have no IP to DNAT to.  Just as on
+		// a worker, where they're never assigned one, they get no rules.
+		worker, ok := placement[c.ID]
+		if !ok || !c.OnOverlay() {
+			continue
+		}
+
+		workers[worker] = append(workers[worker], db.Container{
+			StitchID: c.ID,
+			IP:       PlanIP(c.ID),
+			Labels:   labels[c.ID],
+			Minion:   worker,
+		})
+	}
+
+	publicInterface := spec.PublicInterface
+	if publicInterface == "" {
+		publicInterface = planPublicInterface
+	}
+
+	plan := map[string][]string{}
+	for worker, containers := range workers {
+		var rules []string
+		for _, owner := range natOwners(publicInterface, containers, connections) {
+			rules = append(rules, owner.rules...)
+		}
+		sort.Strings(rules)
+		plan[worker] = rules
+	}
+	return plan
+}
+
+// ContainerRules returns the NAT rules that forward traffic from `publicInterface` to
+// the container with the given IP, out of those a worker running `containers` would
+// install.  This narrows the rules down to the ones that matter when a single
+// container is unreachable.
+func ContainerRules(publicInterface, ip string, containers []db.Container,
+	connections []db.Connection) []string {
+
+	rules := []string{}
+	for _, owner := range natOwners(publicInterface, containers, connections) {
+		if owner.containerIP != "" && owner.containerIP == ip {
+			rules = append(rules, owner.rules...)
+		}
+	}
+	return rules
+}
+
+// PlanIP returns the IP that RulePlan gives the container with the given ID.  The
+// addresses follow the label subnet, so they never collide with label IPs.
+func PlanIP(id int) string {
+	ones, bits := ipdef.SubMask.Size()
+	base := binary.BigEndian.Uint32(ipdef.LabelSubnet.IP.To4())
+
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, base+1<<uint(bits-ones)+uint32(id))
+	return ip.String()
+}
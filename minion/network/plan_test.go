@@ -0,0 +1,108 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+)
+
+func TestRulePlan(t *testing.T) {
+	spec, err := stitch.FromJavascript(`
+	var web = new Service("web", new Container("nginx").replicate(2));
+	var db = new Service("db", [new Container("postgres")]);
+	publicInternet.connect(80, web, "tcp");
+	web.connect(5432, db);
+	deployment.deploy([web, db]);`, stitch.DefaultImportGetter)
+	assert.NoError(t, err)
+
+	ids := map[string][]int{}
+	for _, label := range spec.Labels {
+		ids[label.Name] = label.IDs
+	}
+	assert.Len(t, ids["web"], 2)
+	assert.Len(t, ids["db"], 1)
+
+	// One web container shares a worker with the database, the other is alone.
+	placement := map[int]string{
+		ids["web"][0]: "1.1.1.1",
+		ids["db"][0]:  "1.1.1.1",
+		ids["web"][1]: "2.2.2.2",
+	}
+	plan := RulePlan(spec, placement)
+
+	rules := func(id int) []string {
+		return []string{
+			"-A POSTROUTING -s 10.0.0.0/8 -o eth0 -j MASQUERADE",
+			"-A PREROUTING -i eth0 -p tcp -m tcp --dport 80 -j DNAT " +
+				"--to-destination " + PlanIP(id) + ":80",
+			"-P INPUT ACCEPT",
+			"-P OUTPUT ACCEPT",
+			"-P POSTROUTING ACCEPT",
+			"-P PREROUTING ACCEPT",
+		}
+	}
+	assert.Equal(t, map[string][]string{
+		"1.1.1.1": rules(ids["web"][0]),
+		"2.2.2.2": rules(ids["web"][1]),
+	}, plan)
+
+	assert.Equal(t, "10.0.16.1", PlanIP(1))
+}
+
+func TestContainerRules(t *testing.T) {
+	containers := []db.Container{
+		{IP: "10.0.0.2", Labels: []string{"web"}},
+		{IP: "10.0.0.3", Labels: []string{"web", "dns"}},
+		{IP: "10.0.0.30", Labels: []string{"dns"}},
+	}
+	connections := []db.Connection{
+		{From: "public", To: "web", MinPort: 80, MaxPort: 80, Protocol: "tcp"},
+		{From: "public", To: "dns", MinPort: 53, MaxPort: 53, Protocol: "udp"},
+		{From: "web", To: "dns", MinPort: 53, MaxPort: 53},
+	}
+
+	assert.Equal(t, []string{
+		"-A PREROUTING -i eth0 -p tcp -m tcp --dport 80 -j DNAT " +
+			"--to-destination 10.0.0.3:80",
+		"-A PREROUTING -i eth0 -p udp -m udp --dport 53 -j DNAT " +
+			"--to-destination 10.0.0.3:53",
+	}, ContainerRules("eth0", "10.0.0.3", containers, connections))
+
+	assert.Equal(t, []string{
+		"-A PREROUTING -i ens3 -p tcp -m tcp --dport 80 -j DNAT " +
+			"--to-destination 10.0.0.2:80",
+	}, ContainerRules("ens3", "10.0.0.2", containers, connections))
+
+	// The host's rules aren't owned by any container.
+	assert.Empty(t, ContainerRules("eth0", "", containers, connections))
+
+	assert.Empty(t, ContainerRules("eth0", "10.0.0.4", containers, connections))
+}
+
+func TestRulePlanHostNetworkMode(t *testing.T) {
+	spec, err := stitch.FromJavascript(`
+	var web = new Service("web", [new Container("nginx"),
+		new Container("nginx").withNetworkMode("host")]);
+	publicInternet.connect(80, web, "tcp");
+	deployment.deploy(web);`, stitch.DefaultImportGetter)
+	assert.NoError(t, err)
+
+	ids := spec.Labels[0].IDs
+	plan := RulePlan(spec, map[int]string{ids[0]: "1.1.1.1", ids[1]: "2.2.2.2"})
+
+	hostRules := []string{
+		"-A POSTROUTING -s 10.0.0.0/8 -o eth0 -j MASQUERADE",
+		"-P INPUT ACCEPT",
+		"-P OUTPUT ACCEPT",
+		"-P POSTROUTING ACCEPT",
+		"-P PREROUTING ACCEPT",
+	}
+	assert.Contains(t, plan["1.1.1.1"], "-A PREROUTING -i eth0 -p tcp -m tcp "+
+		"--dport 80 -j DNAT --to-destination "+PlanIP(ids[0])+":80")
+
+	// The host networked container has no overlay IP, so it isn't DNATed to.
+	assert.Equal(t, hostRules, plan["2.2.2.2"])
+}
@@ -102,8 +102,7 @@ func (d driver) DeleteEndpoint(req *dnet.DeleteEndpointRequest) error {
 func (d driver) Join(req *dnet.JoinRequest) (*dnet.JoinResponse, error) {
 	// We just need to create the Veth and tell Docker where it should go; Docker
 	// will take care of moving it into the container and renaming it.
-	outer := ipdef.IFName(req.EndpointID)
-	inner := ipdef.IFName("tmp_" + req.EndpointID)
+	outer, inner := ipdef.VethNames(req.EndpointID)
 	err := linkAdd(&netlink.Veth{
 		LinkAttrs: netlink.LinkAttrs{Name: outer, MTU: mtu},
 		PeerName:  inner,
@@ -142,7 +141,8 @@ func (d driver) Leave(req *dnet.LeaveRequest) error {
 }
 
 func getOuterLink(eid string) (netlink.Link, error) {
-	return linkByName(ipdef.IFName(eid))
+	outer, _ := ipdef.VethNames(eid)
+	return linkByName(outer)
 }
 
 // Mock variables for unit testing
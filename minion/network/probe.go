@@ -0,0 +1,208 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NetSys/quilt/api"
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/minion/docker"
+
+	"github.com/vishvananda/netns"
+)
+
+// How long a probe waits to connect, or for a UDP reply.
+var probeTimeout = 5 * time.Second
+
+// Probe checks whether `dbc`, which must be running on this minion, can reach `ip` on
+// `port`.  It resolves `hostname` as the container would, then routes and connects to
+// `ip` from within the container's network namespace.  UDP is connectionless, so a
+// UDP probe only succeeds if the destination echoes a reply.
+func Probe(dk docker.Client, dbc db.Container, hostname, ip string, port int,
+	protocol string) []api.ProbeHop {
+
+	var hops []api.ProbeHop
+	if hosts, err := dk.GetFromContainer(dbc.DockerID, "/etc/hosts"); err != nil {
+		hops = append(hops, api.ProbeHop{Name: "dns",
+			Detail: fmt.Sprintf("failed to read /etc/hosts: %s", err)})
+	} else {
+		hops = append(hops, probeDNS(hosts, hostname, ip))
+	}
+
+	err := inNamespace(dbc.Pid, func() {
+		route := probeRoute(ip)
+		hops = append(hops, route)
+		if route.OK {
+			hops = append(hops, probeConnect(ip, port, protocol))
+		}
+	})
+	if err != nil {
+		hops = append(hops, api.ProbeHop{Name: "route",
+			Detail: fmt.Sprintf("failed to enter network namespace: %s", err)})
+	}
+	return hops
+}
+
+// probeDNS checks that `hosts`, the container's /etc/hosts, resolves `hostname` to
+// `ip`.
+func probeDNS(hosts, hostname, ip string) api.ProbeHop {
+	hop := api.ProbeHop{Name: "dns"}
+	switch resolved := lookupHost(hosts, hostname); resolved {
+	case "":
+		hop.Detail = hostname + " isn't in /etc/hosts"
+	case ip:
+		hop.OK = true
+		hop.Detail = fmt.Sprintf("%s is %s", hostname, ip)
+	default:
+		hop.Detail = fmt.Sprintf("%s is %s, not %s", hostname, resolved, ip)
+	}
+	return hop
+}
+
+// lookupHost returns the first address that `hosts`, in the format of /etc/hosts,
+// gives for `hostname`, or the empty string if there is none.
+func lookupHost(hosts, hostname string) string {
+	for _, line := range strings.Split(hosts, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		for i := 1; i < len(fields); i++ {
+			if fields[i] == hostname {
+				return fields[0]
+			}
+		}
+	}
+	return ""
+}
+
+func probeRoute(ip string) api.ProbeHop {
+	hop := api.ProbeHop{Name: "route"}
+	routes, err := routeGet(net.ParseIP(ip))
+	if err != nil {
+		hop.Detail = fmt.Sprintf("no route to %s: %s", ip, err)
+		return hop
+	} else if len(routes) == 0 {
+		hop.Detail = "no route to " + ip
+		return hop
+	}
+
+	hop.OK = true
+	hop.Detail = "dev " + strconv.Itoa(routes[0].LinkIndex)
+	if link, err := linkByIndex(routes[0].LinkIndex); err == nil {
+		hop.Detail = "dev " + link.Attrs().Name
+	}
+	if routes[0].Gw != nil {
+		hop.Detail += " via " + routes[0].Gw.String()
+	}
+	return hop
+}
+
+func probeConnect(ip string, port int, protocol string) api.ProbeHop {
+	hop := api.ProbeHop{Name: "connect"}
+	conn, err := net.DialTimeout(protocol, net.JoinHostPort(ip, strconv.Itoa(port)),
+		probeTimeout)
+	if err != nil {
+		hop.Detail = err.Error()
+		return hop
+	}
+	defer conn.Close()
+
+	if protocol != "udp" {
+		hop.OK = true
+		hop.Detail = "connected"
+		return hop
+	}
+
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+	if _, err := conn.Write([]byte("quilt probe\n")); err != nil {
+		hop.Detail = err.Error()
+		return hop
+	}
+
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		hop.Detail = fmt.Sprintf("no reply: %s", err)
+		return hop
+	}
+
+	hop.OK = true
+	hop.Detail = "replied"
+	return hop
+}
+
+// probeUDP sends `query` to `port` of `dbc` from within the container's network
+// namespace.  It fails if the container refuses the query, or if `expectResponse` is
+// set and the container doesn't reply.
+//
+// Stored in a variable so it may be mocked out in the unit tests.
+var probeUDP = func(dbc db.Container, port int, query []byte,
+	expectResponse bool) error {
+
+	var probeErr error
+	err := inNamespace(dbc.Pid, func() {
+		probeErr = udpQuery(dbc.IP, port, query, expectResponse)
+	})
+	if err != nil {
+		return err
+	}
+	return probeErr
+}
+
+func udpQuery(ip string, port int, query []byte, expectResponse bool) error {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(ip, strconv.Itoa(port)),
+		probeTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+	if _, err := conn.Write(query); err != nil {
+		return err
+	}
+
+	// A closed port answers with an ICMP port unreachable, which fails the read.
+	// Otherwise the read times out, which only fails probes that expect a reply.
+	_, err = conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() && !expectResponse {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("no reply: %s", err)
+	}
+	return nil
+}
+
+// inNamespace runs `do` in the network namespace of the process with the given PID.
+//
+// Stored in a variable so it may be mocked out in the unit tests.
+var inNamespace = func(pid int, do func()) error {
+	// The namespace belongs to the thread, so the goroutine mustn't move to
+	// another while it's switched.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	orig, err := netns.Get()
+	if err != nil {
+		return err
+	}
+	defer orig.Close()
+
+	ns, err := netns.GetFromPath(fmt.Sprintf("/hostproc/%d/ns/net", pid))
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+
+	if err := netns.Set(ns); err != nil {
+		return err
+	}
+	defer netns.Set(orig)
+
+	do()
+	return nil
+}
@@ -0,0 +1,145 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestProbeDNS(t *testing.T) {
+	hosts := "127.0.0.1 localhost\n" +
+		"# 10.0.0.9 1.db.q\n" +
+		"10.0.0.2 web.q\n" +
+		"10.0.0.3 1.db.q   # The first database.\n"
+
+	assert.Equal(t, "10.0.0.3", lookupHost(hosts, "1.db.q"))
+	assert.Equal(t, "", lookupHost(hosts, "2.db.q"))
+	assert.Equal(t, "", lookupHost(hosts, "10.0.0.2"))
+
+	hop := probeDNS(hosts, "1.db.q", "10.0.0.3")
+	assert.True(t, hop.OK)
+	assert.Equal(t, "1.db.q is 10.0.0.3", hop.Detail)
+
+	hop = probeDNS(hosts, "web.q", "10.0.0.4")
+	assert.False(t, hop.OK)
+	assert.Equal(t, "web.q is 10.0.0.2, not 10.0.0.4", hop.Detail)
+
+	hop = probeDNS(hosts, "2.db.q", "10.0.0.4")
+	assert.False(t, hop.OK)
+	assert.Equal(t, "2.db.q isn't in /etc/hosts", hop.Detail)
+}
+
+func TestProbeRoute(t *testing.T) {
+	var routes []netlink.Route
+	var routesErr error
+	oldRouteGet := routeGet
+	defer func() { routeGet = oldRouteGet }()
+	routeGet = func(_ net.IP) ([]netlink.Route, error) {
+		return routes, routesErr
+	}
+
+	oldLinkByIndex := linkByIndex
+	defer func() { linkByIndex = oldLinkByIndex }()
+	linkByIndex = func(index int) (netlink.Link, error) {
+		if index != 1 {
+			return nil, errors.New("no link")
+		}
+		return &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}}, nil
+	}
+
+	routesErr = errors.New("network is unreachable")
+	hop := probeRoute("10.0.0.3")
+	assert.False(t, hop.OK)
+	assert.Equal(t, "no route to 10.0.0.3: network is unreachable", hop.Detail)
+
+	routesErr = nil
+	hop = probeRoute("10.0.0.3")
+	assert.False(t, hop.OK)
+	assert.Equal(t, "no route to 10.0.0.3", hop.Detail)
+
+	routes = []netlink.Route{{LinkIndex: 1}}
+	hop = probeRoute("10.0.0.3")
+	assert.True(t, hop.OK)
+	assert.Equal(t, "dev eth0", hop.Detail)
+
+	routes = []netlink.Route{{LinkIndex: 2, Gw: net.IPv4(10, 0, 0, 1)}}
+	hop = probeRoute("10.0.0.3")
+	assert.True(t, hop.OK)
+	assert.Equal(t, "dev 2 via 10.0.0.1", hop.Detail)
+}
+
+func TestProbeConnect(t *testing.T) {
+	oldTimeout := probeTimeout
+	defer func() { probeTimeout = oldTimeout }()
+	probeTimeout = 100 * time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	hop := probeConnect("127.0.0.1", port, "tcp")
+	assert.True(t, hop.OK)
+	assert.Equal(t, "connected", hop.Detail)
+
+	listener.Close()
+	hop = probeConnect("127.0.0.1", port, "tcp")
+	assert.False(t, hop.OK)
+
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 64)
+		n, addr, err := echo.ReadFrom(buf)
+		if err == nil {
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	_, portStr, _ := net.SplitHostPort(echo.LocalAddr().String())
+	port, _ = strconv.Atoi(portStr)
+	hop = probeConnect("127.0.0.1", port, "udp")
+	assert.True(t, hop.OK)
+	assert.Equal(t, "replied", hop.Detail)
+
+	// The echo server only replies once.
+	hop = probeConnect("127.0.0.1", port, "udp")
+	assert.False(t, hop.OK)
+	assert.Contains(t, hop.Detail, "no reply")
+}
+
+func TestUDPQuery(t *testing.T) {
+	oldTimeout := probeTimeout
+	defer func() { probeTimeout = oldTimeout }()
+	probeTimeout = 100 * time.Millisecond
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	queries := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, addr, err := server.ReadFrom(buf)
+		if err == nil {
+			queries <- string(buf[:n])
+			server.WriteTo([]byte("answer"), addr)
+		}
+	}()
+
+	_, portStr, _ := net.SplitHostPort(server.LocalAddr().String())
+	port, _ := strconv.Atoi(portStr)
+	assert.NoError(t, udpQuery("127.0.0.1", port, []byte("query"), true))
+	assert.Equal(t, "query", <-queries)
+
+	// The server only answers once, which only matters if a reply is expected.
+	assert.Error(t, udpQuery("127.0.0.1", port, []byte("query"), true))
+	assert.NoError(t, udpQuery("127.0.0.1", port, []byte("query"), false))
+
+	// Once the port is closed, the query is refused.
+	server.Close()
+	assert.Error(t, udpQuery("127.0.0.1", port, []byte("query"), false))
+}
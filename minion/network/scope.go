@@ -0,0 +1,128 @@
+package network
+
+import (
+	"strings"
+	"time"
+
+	"github.com/NetSys/quilt/counter"
+	"github.com/NetSys/quilt/join"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// How often a ruleScope compares every owner's rules against those actually
+// installed, to repair rules that were changed behind its back.
+const fullSyncInterval = 5 * time.Minute
+
+// A ruleOwner is the logical owner, such as a container, of a set of target rules.
+type ruleOwner struct {
+	key         string   // Identifies the owner across syncs.
+	containerIP string   // The IP of the owning container, if the owner is one.
+	rules       []string // The target rules, in a canonical order.
+}
+
+// A ruleScope syncs the rules of a set of owners, e.g. the NAT rules of each
+// container.  It remembers the rules it installed for each owner, so that usually
+// only the owners whose rules changed are parsed, diffed, and synced, and the rules of
+// every other owner are left alone.  The rules of different owners must be disjoint.
+type ruleScope struct {
+	parse func(string) (interface{}, error)
+
+	owners   map[string]ownedRules
+	stale    bool // The last sync failed, so the installed rules are unknown.
+	lastFull time.Time
+
+	fullCounter   *counter.Counter
+	resyncCounter *counter.Counter
+}
+
+type ownedRules struct {
+	text  string
+	rules []interface{}
+}
+
+type ruleList []interface{}
+
+func (rl ruleList) Get(i int) interface{} {
+	return rl[i]
+}
+
+func (rl ruleList) Len() int {
+	return len(rl)
+}
+
+func newRuleScope(name string, parse func(string) (interface{}, error)) *ruleScope {
+	return &ruleScope{
+		parse:         parse,
+		fullCounter:   counter.New("network", name+" Full Sync"),
+		resyncCounter: counter.New("network", name+" Rules Resynced"),
+	}
+}
+
+// sync installs the rules of `owners` by passing the rules that must be deleted and
+// added to `apply`, which returns false if it failed.
+//
+// Ordinarily, only the owners whose rules changed since the last sync are synced,
+// by diffing against the rules last installed for them.  The first sync, the first
+// after a failure, and periodic resyncs instead diff the rules of every owner against
+// those returned by `current`, which also removes rules that no owner wants.
+func (s *ruleScope) sync(owners []ruleOwner, current func() (join.List, error),
+	apply func(del, add []interface{}) bool) {
+
+	full := s.owners == nil || s.stale ||
+		time.Since(s.lastFull) > fullSyncInterval
+
+	var del, add, target []interface{}
+	next := map[string]ownedRules{}
+	for _, owner := range owners {
+		text := strings.Join(owner.rules, "\n")
+		prev, ok := s.owners[owner.key]
+		if ok && prev.text == text {
+			next[owner.key] = prev
+			target = append(target, prev.rules...)
+			continue
+		}
+
+		var rules []interface{}
+		for _, str := range owner.rules {
+			rule, err := s.parse(str)
+			if err != nil {
+				log.WithError(err).Error("Failed to parse target rule.")
+				return
+			}
+			rules = append(rules, rule)
+		}
+		s.resyncCounter.Add(uint64(len(rules)))
+		next[owner.key] = ownedRules{text, rules}
+		target = append(target, rules...)
+
+		if !full {
+			_, ownerDel, ownerAdd := join.HashJoin(ruleList(prev.rules),
+				ruleList(rules), nil, nil)
+			del = append(del, ownerDel...)
+			add = append(add, ownerAdd...)
+		}
+	}
+
+	if full {
+		s.fullCounter.Inc()
+		curr, err := current()
+		if err != nil {
+			log.WithError(err).Error("Failed to get current rules.")
+			return
+		}
+		_, del, add = join.HashJoin(curr, ruleList(target), nil, nil)
+	} else {
+		for key, prev := range s.owners {
+			if _, ok := next[key]; !ok {
+				del = append(del, prev.rules...)
+			}
+		}
+	}
+
+	s.owners = next
+	s.stale = !apply(del, add)
+	if full && !s.stale {
+		s.lastFull = time.Now()
+	}
+}
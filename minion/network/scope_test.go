@@ -0,0 +1,137 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNat mocks the iptables NAT table for shVerbose, or another table if `table` is
+// set.
+type fakeNat struct {
+	table string
+	rules map[string]struct{}
+	lists int
+	fail  bool
+}
+
+func (nat *fakeNat) shVerbose(format string, args ...interface{}) (
+	stdout, stderr []byte, err error) {
+
+	table := nat.table
+	if table == "" {
+		table = "nat"
+	}
+	cmd := strings.TrimPrefix(fmt.Sprintf(format, args...),
+		"iptables -t "+table+" ")
+	switch {
+	case cmd == "-S":
+		nat.lists++
+		var lines []string
+		for rule := range nat.rules {
+			lines = append(lines, rule)
+		}
+		sort.Strings(lines)
+		return []byte(strings.Join(lines, "\n")), nil, nil
+	case nat.fail:
+		return nil, nil, errors.New("iptables failed")
+	case strings.HasPrefix(cmd, "-A "):
+		nat.rules[cmd] = struct{}{}
+	case strings.HasPrefix(cmd, "-D "):
+		delete(nat.rules, "-A "+strings.TrimPrefix(cmd, "-D "))
+	}
+	return nil, nil, nil
+}
+
+type natSyncStats struct {
+	lists, resynced, added, deleted int
+}
+
+func TestNatScopeSync(t *testing.T) {
+	nat := &fakeNat{rules: map[string]struct{}{
+		"-P PREROUTING ACCEPT":  {},
+		"-P INPUT ACCEPT":       {},
+		"-P OUTPUT ACCEPT":      {},
+		"-P POSTROUTING ACCEPT": {},
+	}}
+
+	oldShVerbose := shVerbose
+	defer func() { shVerbose = oldShVerbose }()
+	shVerbose = nat.shVerbose
+
+	natScope.owners = nil
+	defer func() {
+		natScope.owners = nil
+		natScope.stale = false
+	}()
+
+	// 1000 containers that each accept one public port, for 1005 rules in total.
+	var containers []db.Container
+	var connections []db.Connection
+	for i := 0; i < 1000; i++ {
+		label := fmt.Sprintf("label%d", i)
+		containers = append(containers, db.Container{
+			IP:     fmt.Sprintf("10.0.%d.%d", i/250, i%250+2),
+			Labels: []string{label},
+		})
+		connections = append(connections, db.Connection{
+			From: "public", To: label, MinPort: 80, MaxPort: 80,
+			Protocol: "tcp",
+		})
+	}
+
+	syncNat := func(connections []db.Connection) natSyncStats {
+		lists := nat.lists
+		resynced := natScope.resyncCounter.Get()
+		added := natAddCounter.Get()
+		deleted := natDeleteCounter.Get()
+
+		iptablesNAT{}.update("eth0", containers, connections)
+		return natSyncStats{
+			lists:    nat.lists - lists,
+			resynced: int(natScope.resyncCounter.Get() - resynced),
+			added:    int(natAddCounter.Get() - added),
+			deleted:  int(natDeleteCounter.Get() - deleted),
+		}
+	}
+
+	// The first sync compares every rule against the table.
+	assert.Equal(t, natSyncStats{lists: 1, resynced: 1005, added: 1001},
+		syncNat(connections))
+	assert.Len(t, nat.rules, 1005)
+
+	// Nothing changed, so nothing is touched.
+	assert.Equal(t, natSyncStats{}, syncNat(connections))
+
+	// A new connection only resyncs the rules of the container it reaches.
+	withHTTPS := append(connections[:1000:1000], db.Connection{
+		From: "public", To: "label7", MinPort: 443, MaxPort: 443,
+		Protocol: "tcp",
+	})
+	assert.Equal(t, natSyncStats{resynced: 2, added: 1}, syncNat(withHTTPS))
+	assert.Contains(t, nat.rules, "-A PREROUTING -i eth0 -p tcp -m tcp "+
+		"--dport 443 -j DNAT --to-destination 10.0.0.9:443")
+
+	assert.Equal(t, natSyncStats{resynced: 1, deleted: 1}, syncNat(connections))
+	assert.Len(t, nat.rules, 1005)
+
+	// A failed sync forces the next one to compare against the table.
+	nat.fail = true
+	syncNat(withHTTPS)
+	nat.fail = false
+	assert.Equal(t, natSyncStats{lists: 1, resynced: 1}, syncNat(connections))
+
+	// Rules changed behind our back are repaired by the periodic full sync.
+	delete(nat.rules, "-A POSTROUTING -s 10.0.0.0/8 -o eth0 -j MASQUERADE")
+	assert.Equal(t, natSyncStats{}, syncNat(connections))
+
+	natScope.lastFull = time.Now().Add(-2 * fullSyncInterval)
+	assert.Equal(t, natSyncStats{lists: 1, added: 1}, syncNat(connections))
+	assert.Len(t, nat.rules, 1005)
+}
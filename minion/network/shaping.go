@@ -0,0 +1,228 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NetSys/quilt/counter"
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	shapingApplyCounter   = counter.New("network", "Apply Traffic Shaping")
+	shapingFailureCounter = counter.New("network", "Traffic Shaping Failure")
+)
+
+// The tc commands last applied to each container, keyed by Docker ID.
+var appliedShaping = map[string]shapingState{}
+
+type shapingState struct {
+	cmds      string
+	appliedAt time.Time
+}
+
+// updateShaping limits the bandwidth of the connections that have a BandwidthLimit.
+// Each limit is an HTB class on the eth0 of every local container of the From label,
+// with a filter that classifies the container's traffic to the To label's IP and
+// ports into it.  A container's rules are only reinstalled when they change, or
+// periodically to repair rules changed behind our back.
+func updateShaping(containers []db.Container, labels []db.Label,
+	connections []db.Connection) {
+
+	labelIPs := map[string]string{}
+	for _, l := range labels {
+		labelIPs[l.Label] = l.IP
+	}
+
+	next := map[string]shapingState{}
+	for _, dbc := range containers {
+		cmds := strings.Join(shapingCommands(dbc, labelIPs, connections), "\n")
+
+		// Containers that were never limited have nothing to repair, but the
+		// first time one is seen, the rules of a previous minion are cleared.
+		prev, ok := appliedShaping[dbc.DockerID]
+		if ok && prev.cmds == cmds &&
+			(cmds == "" || time.Since(prev.appliedAt) < fullSyncInterval) {
+			next[dbc.DockerID] = prev
+			continue
+		}
+
+		shapingApplyCounter.Inc()
+		if err := applyShaping(dbc.Pid, cmds); err != nil {
+			// Leave the container out of `next` so that it's retried.
+			shapingFailureCounter.Inc()
+			log.WithError(err).WithField("container", dbc.DockerID).Error(
+				"Failed to apply traffic shaping.")
+			continue
+		}
+		next[dbc.DockerID] = shapingState{cmds, time.Now()}
+	}
+	appliedShaping = next
+}
+
+// applyShaping replaces the traffic shaping of the container with the given PID with
+// `cmds`, a newline separated list of tc commands.
+func applyShaping(pid int, cmds string) error {
+	// Deleting the root qdisc fails if there isn't one, which is fine.
+	tcExec(pid, fmt.Sprintf("qdisc del dev %s root", innerVeth))
+
+	if cmds == "" {
+		return nil
+	}
+
+	for _, cmd := range strings.Split(cmds, "\n") {
+		if err := tcExec(pid, cmd); err != nil {
+			return fmt.Errorf("tc %s: %s", cmd, err)
+		}
+	}
+	return nil
+}
+
+// tcExec runs tc with the given arguments in the network namespace of the process
+// with the given PID.
+//
+// Stored in a variable so it can be mocked in the unit tests.
+var tcExec = func(pid int, args string) error {
+	return sh("nsenter --net=/hostproc/%d/ns/net tc %s", pid, args)
+}
+
+// A shapedConn is the traffic a container sends over a connection with a
+// BandwidthLimit.
+type shapedConn struct {
+	ip       string // The IP of the To label.
+	protocol string
+	minPort  int
+	maxPort  int
+	limit    int
+}
+
+type shapedConnSlice []shapedConn
+
+// shapingCommands returns the tc commands, without the leading "tc", that limit the
+// bandwidth `dbc` may use on its connections.  If none of them are limited, it
+// returns nil.
+func shapingCommands(dbc db.Container, labelIPs map[string]string,
+	connections []db.Connection) []string {
+
+	cLabels := map[string]struct{}{}
+	for _, label := range dbc.Labels {
+		cLabels[label] = struct{}{}
+	}
+
+	var shaped shapedConnSlice
+	for _, conn := range connections {
+		if _, ok := cLabels[conn.From]; !ok || conn.BandwidthLimit <= 0 {
+			continue
+		}
+
+		ip := labelIPs[conn.To]
+		if ip == "" {
+			continue
+		}
+
+		shaped = append(shaped, shapedConn{ip, conn.Protocol, conn.MinPort,
+			conn.MaxPort, conn.BandwidthLimit})
+	}
+
+	if len(shaped) == 0 {
+		return nil
+	}
+
+	// Sort the connections so that each is assigned the same class every time.
+	sort.Sort(shaped)
+
+	cmds := []string{fmt.Sprintf("qdisc add dev %s root handle 1: htb", innerVeth)}
+	for i, sc := range shaped {
+		class := fmt.Sprintf("1:%x", i+1)
+		cmds = append(cmds, fmt.Sprintf("class add dev %s parent 1: classid %s "+
+			"htb rate %dbit", innerVeth, class, sc.limit))
+
+		for _, match := range sc.matches() {
+			cmds = append(cmds, fmt.Sprintf("filter add dev %s parent 1: "+
+				"protocol ip prio 1 u32 %s flowid %s", innerVeth, match,
+				class))
+		}
+	}
+	return cmds
+}
+
+// The IP protocol numbers matched by the u32 filters.
+var protocolNumbers = map[string]int{
+	stitch.ICMP: 1,
+	stitch.TCP:  6,
+	stitch.UDP:  17,
+}
+
+// matches returns the u32 selectors that together match the traffic of `sc`.  The
+// selectors can only match ports under a mask, so a port range may need several.
+func (sc shapedConn) matches() []string {
+	dst := fmt.Sprintf("match ip dst %s/32", sc.ip)
+	if sc.protocol == stitch.ICMP {
+		return []string{fmt.Sprintf("%s match ip protocol %d 0xff", dst,
+			protocolNumbers[stitch.ICMP])}
+	}
+
+	var matches []string
+	for _, protocol := range stitch.Protocols(sc.protocol) {
+		proto := fmt.Sprintf("%s match ip protocol %d 0xff", dst,
+			protocolNumbers[protocol])
+		for _, pm := range portMasks(sc.minPort, sc.maxPort) {
+			if pm.mask == 0 {
+				matches = append(matches, proto)
+				continue
+			}
+			matches = append(matches, fmt.Sprintf(
+				"%s match ip dport %d 0x%04x", proto, pm.port, pm.mask))
+		}
+	}
+	return matches
+}
+
+type portMask struct {
+	port int
+	mask int
+}
+
+// portMasks splits the port range [min, max] into the fewest blocks that can each be
+// matched as a port under a mask.
+func portMasks(min, max int) []portMask {
+	var masks []portMask
+	for min <= max {
+		size := 1
+		for min%(size*2) == 0 && min+size*2-1 <= max {
+			size *= 2
+		}
+		masks = append(masks, portMask{min, 0xffff &^ (size - 1)})
+		min += size
+	}
+	return masks
+}
+
+func (scs shapedConnSlice) Len() int {
+	return len(scs)
+}
+
+func (scs shapedConnSlice) Less(i, j int) bool {
+	l, r := scs[i], scs[j]
+	switch {
+	case l.ip != r.ip:
+		return l.ip < r.ip
+	case l.protocol != r.protocol:
+		return l.protocol < r.protocol
+	case l.minPort != r.minPort:
+		return l.minPort < r.minPort
+	case l.maxPort != r.maxPort:
+		return l.maxPort < r.maxPort
+	default:
+		return l.limit < r.limit
+	}
+}
+
+func (scs shapedConnSlice) Swap(i, j int) {
+	scs[i], scs[j] = scs[j], scs[i]
+}
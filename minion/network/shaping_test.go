@@ -0,0 +1,98 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShapingCommands(t *testing.T) {
+	t.Parallel()
+
+	labelIPs := map[string]string{"db": "10.0.0.2", "cache": "10.0.0.3"}
+	connections := []db.Connection{
+		{From: "replica", To: "db", MinPort: 5432, MaxPort: 5432,
+			Protocol: "tcp", BandwidthLimit: 1000000},
+		{From: "replica", To: "cache", MinPort: 8, MaxPort: 11,
+			BandwidthLimit: 500},
+		{From: "replica", To: "db", MinPort: 22, MaxPort: 22},
+		{From: "web", To: "db", MinPort: 5432, MaxPort: 5432,
+			BandwidthLimit: 1},
+	}
+
+	dbc := db.Container{Labels: []string{"replica"}}
+	assert.Equal(t, []string{
+		"qdisc add dev eth0 root handle 1: htb",
+		"class add dev eth0 parent 1: classid 1:1 htb rate 1000000bit",
+		"filter add dev eth0 parent 1: protocol ip prio 1 u32 " +
+			"match ip dst 10.0.0.2/32 match ip protocol 6 0xff " +
+			"match ip dport 5432 0xffff flowid 1:1",
+		"class add dev eth0 parent 1: classid 1:2 htb rate 500bit",
+		"filter add dev eth0 parent 1: protocol ip prio 1 u32 " +
+			"match ip dst 10.0.0.3/32 match ip protocol 6 0xff " +
+			"match ip dport 8 0xfffc flowid 1:2",
+		"filter add dev eth0 parent 1: protocol ip prio 1 u32 " +
+			"match ip dst 10.0.0.3/32 match ip protocol 17 0xff " +
+			"match ip dport 8 0xfffc flowid 1:2",
+	}, shapingCommands(dbc, labelIPs, connections))
+
+	// Containers without limited connections aren't shaped.
+	assert.Nil(t, shapingCommands(db.Container{Labels: []string{"db"}}, labelIPs,
+		connections))
+}
+
+func TestPortMasks(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []portMask{{80, 0xffff}}, portMasks(80, 80))
+	assert.Equal(t, []portMask{{8, 0xfffc}}, portMasks(8, 11))
+	assert.Equal(t, []portMask{{7, 0xffff}, {8, 0xfff8}, {16, 0xffff}},
+		portMasks(7, 16))
+	assert.Equal(t, []portMask{{0, 0}}, portMasks(0, 65535))
+}
+
+func TestUpdateShaping(t *testing.T) {
+	oldTCExec := tcExec
+	defer func() { tcExec = oldTCExec }()
+
+	var cmds []string
+	tcExec = func(pid int, args string) error {
+		cmds = append(cmds, args)
+		return nil
+	}
+
+	appliedShaping = map[string]shapingState{}
+	defer func() { appliedShaping = map[string]shapingState{} }()
+
+	containers := []db.Container{
+		{DockerID: "a", Pid: 1, Labels: []string{"replica"}},
+		{DockerID: "b", Pid: 2, Labels: []string{"web"}},
+	}
+	labels := []db.Label{{Label: "db", IP: "10.0.0.2"}}
+	connections := []db.Connection{{From: "replica", To: "db", MinPort: 5432,
+		MaxPort: 5432, Protocol: "tcp", BandwidthLimit: 1000}}
+
+	// The first time a container is seen, its rules are replaced, even if it has
+	// none.
+	updateShaping(containers, labels, connections)
+	assert.Equal(t, []string{
+		"qdisc del dev eth0 root",
+		"qdisc add dev eth0 root handle 1: htb",
+		"class add dev eth0 parent 1: classid 1:1 htb rate 1000bit",
+		"filter add dev eth0 parent 1: protocol ip prio 1 u32 " +
+			"match ip dst 10.0.0.2/32 match ip protocol 6 0xff " +
+			"match ip dport 5432 0xffff flowid 1:1",
+	}, cmds[:4])
+	assert.Equal(t, []string{"qdisc del dev eth0 root"}, cmds[4:])
+
+	// Unchanged rules aren't reinstalled.
+	cmds = nil
+	updateShaping(containers, labels, connections)
+	assert.Empty(t, cmds)
+
+	// Removing the limit clears the container's rules.
+	connections[0].BandwidthLimit = 0
+	updateShaping(containers, labels, connections)
+	assert.Equal(t, []string{"qdisc del dev eth0 root"}, cmds)
+}
@@ -0,0 +1,128 @@
+package network
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+	"github.com/NetSys/quilt/util"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Stored in a variable so it can be changed in the unit tests.
+var sysctlDir = "/proc/sys"
+
+// updateSysctls tunes the host's kernel as the spec requests.  Settings the spec
+// leaves at zero aren't touched, and settings that already have the requested value
+// aren't rewritten.  It runs on every pass of the worker loop, so the settings are
+// restored after the machine reboots.
+func updateSysctls(spec stitch.Stitch) {
+	sysctls := keepaliveSysctls(spec.TCPKeepalive)
+	for key, value := range tuningSysctls(spec.NetworkTuning) {
+		sysctls[key] = value
+	}
+
+	var keys []string
+	for key := range sysctls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := sysctls[key]
+		if curr, err := readSysctl(key); err == nil && curr == value {
+			continue
+		}
+
+		err := util.WriteFile(sysctlPath(key), []byte(value+"\n"), 0644)
+		if err != nil {
+			log.WithError(err).WithField("sysctl", key).Error(
+				"Failed to set sysctl.")
+			continue
+		}
+		log.WithField(key, value).Info("Set sysctl.")
+	}
+}
+
+// keepaliveSysctls returns the sysctls that implement `ka`.
+func keepaliveSysctls(ka stitch.TCPKeepalive) map[string]string {
+	sysctls := map[string]string{}
+	for key, value := range map[string]int{
+		"net.ipv4.tcp_keepalive_time":   ka.Time,
+		"net.ipv4.tcp_keepalive_intvl":  ka.Interval,
+		"net.ipv4.tcp_keepalive_probes": ka.Probes,
+	} {
+		if value > 0 {
+			sysctls[key] = strconv.Itoa(value)
+		}
+	}
+	return sysctls
+}
+
+// The sysctls that NetworkTuning sets.
+const (
+	conntrackMaxSysctl   = "net.netfilter.nf_conntrack_max"
+	ephemeralPortsSysctl = "net.ipv4.ip_local_port_range"
+	somaxconnSysctl      = "net.core.somaxconn"
+)
+
+// tuningSysctls returns the sysctls that implement `nt`.
+func tuningSysctls(nt stitch.NetworkTuning) map[string]string {
+	sysctls := map[string]string{}
+	if nt.ConntrackMax > 0 {
+		sysctls[conntrackMaxSysctl] = strconv.Itoa(nt.ConntrackMax)
+	}
+
+	if nt.EphemeralPortMin > 0 && nt.EphemeralPortMax > 0 {
+		sysctls[ephemeralPortsSysctl] = fmt.Sprintf("%d %d",
+			nt.EphemeralPortMin, nt.EphemeralPortMax)
+	}
+
+	if nt.Somaxconn > 0 {
+		sysctls[somaxconnSysctl] = strconv.Itoa(nt.Somaxconn)
+	}
+	return sysctls
+}
+
+// recordSysctls stores the effective values of the NetworkTuning sysctls on the
+// minion, so that they can be checked when a worker drops connections.  They're
+// recorded whether or not the spec sets them.
+func recordSysctls(conn db.Conn) {
+	var values []string
+	for _, key := range []string{conntrackMaxSysctl, ephemeralPortsSysctl,
+		somaxconnSysctl} {
+		value, err := readSysctl(key)
+		if err != nil {
+			value = "unknown"
+		}
+		values = append(values, key+"="+value)
+	}
+	sysctls := strings.Join(values, ", ")
+
+	conn.Txn(db.MinionTable).Run(func(view db.Database) error {
+		self, err := view.MinionSelf()
+		if err == nil && self.Sysctls != sysctls {
+			self.Sysctls = sysctls
+			view.Commit(self)
+		}
+		return nil
+	})
+}
+
+// readSysctl returns the value of `key`, with its fields separated by single spaces.
+func readSysctl(key string) (string, error) {
+	value, err := util.ReadFile(sysctlPath(key))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(value), " "), nil
+}
+
+func sysctlPath(key string) string {
+	return filepath.Join(sysctlDir, strings.Replace(key, ".", "/", -1))
+}
@@ -0,0 +1,103 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+	"github.com/NetSys/quilt/util"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateSysctls(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+	defer func() { util.AppFs = afero.NewOsFs() }()
+
+	timePath := "/proc/sys/net/ipv4/tcp_keepalive_time"
+	intvlPath := "/proc/sys/net/ipv4/tcp_keepalive_intvl"
+	probesPath := "/proc/sys/net/ipv4/tcp_keepalive_probes"
+	util.WriteFile(timePath, []byte("7200\n"), 0644)
+	util.WriteFile(intvlPath, []byte("75\n"), 0644)
+	util.WriteFile(probesPath, []byte("9\n"), 0644)
+
+	read := func(path string) string {
+		contents, err := util.ReadFile(path)
+		assert.NoError(t, err)
+		return contents
+	}
+
+	// Unset values leave the host's defaults alone.
+	updateSysctls(stitch.Stitch{})
+	assert.Equal(t, "7200\n", read(timePath))
+	assert.Equal(t, "75\n", read(intvlPath))
+	assert.Equal(t, "9\n", read(probesPath))
+
+	spec := stitch.Stitch{TCPKeepalive: stitch.TCPKeepalive{Time: 60, Interval: 10}}
+	updateSysctls(spec)
+	assert.Equal(t, "60\n", read(timePath))
+	assert.Equal(t, "10\n", read(intvlPath))
+	assert.Equal(t, "9\n", read(probesPath))
+
+	// Values that are already set aren't rewritten.
+	past := time.Unix(0, 0)
+	assert.NoError(t, util.AppFs.Chtimes(timePath, past, past))
+	updateSysctls(spec)
+	info, err := util.AppFs.Stat(timePath)
+	assert.NoError(t, err)
+	assert.Equal(t, past.Unix(), info.ModTime().Unix())
+}
+
+func TestKeepaliveSysctls(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, keepaliveSysctls(stitch.TCPKeepalive{}))
+	assert.Equal(t, map[string]string{
+		"net.ipv4.tcp_keepalive_time":   "60",
+		"net.ipv4.tcp_keepalive_intvl":  "10",
+		"net.ipv4.tcp_keepalive_probes": "5",
+	}, keepaliveSysctls(stitch.TCPKeepalive{Time: 60, Interval: 10, Probes: 5}))
+}
+
+func TestTuningSysctls(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, tuningSysctls(stitch.NetworkTuning{}))
+	assert.Equal(t, map[string]string{
+		"net.netfilter.nf_conntrack_max": "262144",
+		"net.ipv4.ip_local_port_range":   "10000 65000",
+		"net.core.somaxconn":             "4096",
+	}, tuningSysctls(stitch.NetworkTuning{ConntrackMax: 262144,
+		EphemeralPortMin: 10000, EphemeralPortMax: 65000, Somaxconn: 4096}))
+}
+
+func TestRecordSysctls(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+	defer func() { util.AppFs = afero.NewOsFs() }()
+
+	portsPath := "/proc/sys/net/ipv4/ip_local_port_range"
+	util.WriteFile(portsPath, []byte("32768\t60999\n"), 0644)
+	util.WriteFile("/proc/sys/net/core/somaxconn", []byte("128\n"), 0644)
+
+	conn := db.New()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.InsertMinion()
+		m.Self = true
+		view.Commit(m)
+		return nil
+	})
+
+	updateSysctls(stitch.Stitch{NetworkTuning: stitch.NetworkTuning{
+		EphemeralPortMin: 10000, EphemeralPortMax: 65000}})
+	contents, _ := util.ReadFile(portsPath)
+	assert.Equal(t, "10000 65000\n", contents)
+
+	// Sysctls the host lacks, such as conntrack's before its module is loaded,
+	// are recorded as unknown.
+	recordSysctls(conn)
+	self, _ := conn.MinionSelf()
+	assert.Equal(t, "net.netfilter.nf_conntrack_max=unknown, "+
+		"net.ipv4.ip_local_port_range=10000 65000, net.core.somaxconn=128",
+		self.Sysctls)
+}
@@ -11,9 +11,12 @@ import (
 	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 
+	"github.com/NetSys/quilt/counter"
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/join"
 	"github.com/NetSys/quilt/minion/docker"
@@ -33,9 +36,6 @@ const (
 	concurrencyLimit int    = 32 // Adjust to change per function goroutine limit
 )
 
-// The machine's public interface.
-var publicInterface string
-
 // This represents a rule in the iptables
 type ipRule struct {
 	cmd   string
@@ -94,13 +94,18 @@ func runWorker(conn db.Conn, dk docker.Client) {
 	}
 	defer odb.Close()
 
-	if publicInterface == "" {
-		if pubIntf, err := getPublicInterface(); err == nil {
-			publicInterface = pubIntf
+	updateTunnelEncryption(odb, updateTunnelKeys(conn))
+
+	var hostnames map[string][]string
+	if minion.Spec != "" {
+		if spec, err := stitch.FromJSON(minion.Spec); err != nil {
+			log.WithError(err).Warn("Failed to parse spec.")
 		} else {
-			log.WithError(err).Error("Failed to get public interface")
+			updateSysctls(spec)
+			hostnames = splitHorizonHostnames(spec)
 		}
 	}
+	recordSysctls(conn)
 
 	// XXX: By doing all the work within a transaction, we (kind of) guarantee that
 	// containers won't be removed while we're in the process of setting them up.
@@ -127,13 +132,10 @@ func runWorker(conn db.Conn, dk docker.Client) {
 
 		wg.Add(1)
 		go func() {
-			updateEtcHosts(dk, containers, labels, connections)
+			updateEtcHosts(dk, containers, labels, connections, hostnames)
 			wg.Done()
 		}()
 
-		if publicInterface != "" {
-			updateNAT(publicInterface, containers, connections)
-		}
 		updatePorts(odb, containers)
 
 		wg.Add(1)
@@ -143,37 +145,127 @@ func runWorker(conn db.Conn, dk docker.Client) {
 		}()
 
 		updateContainerIPs(containers, labels)
+		updateShaping(containers, labels, connections)
+		updateDSCP(containers, labels, connections)
 
 		wg.Wait()
 		return nil
 	})
 }
 
-func updateNAT(publicInterface string, containers []db.Container,
+var (
+	natUpdateCounter  = counter.New("network", "Update NAT")
+	natAddCounter     = counter.New("network", "Add NAT Rule")
+	natDeleteCounter  = counter.New("network", "Delete NAT Rule")
+	natFailureCounter = counter.New("network", "NAT Rule Failure")
+)
+
+// A natBackend installs the rules that masquerade the containers' outbound traffic
+// and forward public ports to them.
+type natBackend interface {
+	update(publicInterface string, containers []db.Container,
+		connections []db.Connection)
+
+	// clear removes the rules installed by update.
+	clear()
+}
+
+var nftNAT = &nftablesNAT{}
+
+var natBackends = map[string]natBackend{
+	"":              iptablesNAT{},
+	stitch.IPTables: iptablesNAT{},
+	stitch.NFTables: nftNAT,
+}
+
+// The backend that installed the current NAT rules.
+var activeNAT natBackend
+
+// updateNAT installs the NAT rules with the backend named `backend`, first clearing
+// the rules of any other backend that might have installed them.  If nftables is
+// asked for but nft isn't installed, iptables is used instead.
+func updateNAT(backend, publicInterface string, containers []db.Container,
 	connections []db.Connection) {
 
-	targetRules := generateTargetNatRules(publicInterface, containers, connections)
-	currRules, err := generateCurrentNatRules()
-	if err != nil {
-		log.WithError(err).Error("failed to get NAT rules")
+	natUpdateCounter.Inc()
+	target, ok := natBackends[backend]
+	if !ok {
+		log.Errorf("Unknown NAT backend: %s", backend)
 		return
 	}
 
-	_, rulesToDel, rulesToAdd := join.HashJoin(currRules, targetRules, nil, nil)
+	if target == nftNAT && !nftInstalled() {
+		if activeNAT != natBackends[stitch.IPTables] {
+			log.Warn("nft isn't installed, so falling back to iptables " +
+				"for NAT.")
+		}
+		target = natBackends[stitch.IPTables]
+	}
 
+	if activeNAT != target {
+		for _, other := range []natBackend{iptablesNAT{}, nftNAT} {
+			if other != target {
+				other.clear()
+			}
+		}
+		activeNAT = target
+	}
+	target.update(publicInterface, containers, connections)
+}
+
+// clearNAT removes the rules of whichever backend installed the current NAT rules.
+func clearNAT() {
+	if activeNAT != nil {
+		activeNAT.clear()
+		activeNAT = nil
+	}
+}
+
+// iptablesNAT installs NAT rules with iptables.
+type iptablesNAT struct{}
+
+var natScope = newRuleScope("NAT", func(rule string) (interface{}, error) {
+	return makeIPRule(rule)
+})
+
+func (iptablesNAT) update(publicInterface string, containers []db.Container,
+	connections []db.Connection) {
+	syncNatOwners(natOwners(publicInterface, containers, connections))
+}
+
+func (iptablesNAT) clear() {
+	syncNatOwners([]ruleOwner{{key: "host", rules: natPolicyRules}})
+}
+
+func syncNatOwners(owners []ruleOwner) {
+	natScope.sync(owners, func() (join.List, error) {
+		rules, err := generateCurrentNatRules()
+		return rules, err
+	}, applyNatRules)
+}
+
+func applyNatRules(rulesToDel, rulesToAdd []interface{}) bool {
+	ok := true
 	for _, rule := range rulesToDel {
+		natDeleteCounter.Inc()
 		if err := deleteNatRule(rule.(ipRule)); err != nil {
+			natFailureCounter.Inc()
 			log.WithError(err).Error("failed to delete ip rule")
+			ok = false
 			continue
 		}
 	}
 
 	for _, rule := range rulesToAdd {
+		natAddCounter.Inc()
 		if err := addNatRule(rule.(ipRule)); err != nil {
+			natFailureCounter.Inc()
 			log.WithError(err).Error("failed to add ip rule")
+			ok = false
 			continue
 		}
 	}
+	return ok
 }
 
 func generateCurrentNatRules() (ipRuleSlice, error) {
@@ -203,19 +295,70 @@ func generateCurrentNatRules() (ipRuleSlice, error) {
 
 func generateTargetNatRules(publicInterface string, containers []db.Container,
 	connections []db.Connection) ipRuleSlice {
-	strRules := []string{
-		"-P PREROUTING ACCEPT",
-		"-P INPUT ACCEPT",
-		"-P OUTPUT ACCEPT",
-		"-P POSTROUTING ACCEPT",
-		fmt.Sprintf("-A POSTROUTING -s 10.0.0.0/8 -o %s -j MASQUERADE",
-			publicInterface),
+
+	var rules ipRuleSlice
+	for _, owner := range natOwners(publicInterface, containers, connections) {
+		for _, r := range owner.rules {
+			rule, err := makeIPRule(r)
+			if err != nil {
+				panic("malformed target NAT rule")
+			}
+			rules = append(rules, rule)
+		}
 	}
+	return rules
+}
+
+// natOwners returns the target NAT rules.  The rules that forward ports to each
+// container belong to that container, and the rest belong to the host.
+func natOwners(publicInterface string, containers []db.Container,
+	connections []db.Connection) []ruleOwner {
 
-	protocols := []string{"tcp", "udp"}
-	// Map each container IP to all ports on which it can receive packets
-	// from the public internet.
-	portsFromWeb := make(map[string]map[int]struct{})
+	strRules := append(append([]string{}, natPolicyRules...), fmt.Sprintf(
+		"-A POSTROUTING -s 10.0.0.0/8 -o %s -j MASQUERADE", publicInterface))
+	owners := []ruleOwner{{key: "host", rules: strRules}}
+
+	// Map the container's port to the same port of the host.
+	for ip, ports := range portsFromWeb(containers, connections) {
+		var ipRules []string
+		for port := range ports {
+			// A range is forwarded to the same ports of the container with
+			// a single rule, by leaving the destination port unchanged.
+			dport, dest := strconv.Itoa(port.minPort), ip
+			if port.maxPort != port.minPort {
+				dport += ":" + strconv.Itoa(port.maxPort)
+			} else {
+				dest += ":" + dport
+			}
+
+			ipRules = append(ipRules, fmt.Sprintf(
+				"-A PREROUTING -i %[1]s "+
+					"-p %[2]s -m %[2]s --dport %[3]s -j "+
+					"DNAT --to-destination %[4]s",
+				publicInterface, port.protocol, dport, dest))
+		}
+		sort.Strings(ipRules)
+		owners = append(owners, ruleOwner{key: "container " + ip,
+			containerIP: ip, rules: ipRules})
+	}
+	return owners
+}
+
+// The policies of the NAT table's chains, which are always present.
+var natPolicyRules = []string{
+	"-P PREROUTING ACCEPT",
+	"-P INPUT ACCEPT",
+	"-P OUTPUT ACCEPT",
+	"-P POSTROUTING ACCEPT",
+}
+
+// portsFromWeb maps each container IP to all ports on which it can receive packets
+// from the public internet, less those withdrawn because the container failed their
+// probes.
+func portsFromWeb(containers []db.Container,
+	connections []db.Connection) map[string]map[publicPort]struct{} {
+
+	portsFromWeb := make(map[string]map[publicPort]struct{})
 
 	for _, dbc := range containers {
 		for _, conn := range connections {
@@ -230,37 +373,65 @@ func generateTargetNatRules(publicInterface string, containers []db.Container,
 					continue
 				}
 
-				if _, ok := portsFromWeb[dbc.IP]; !ok {
-					portsFromWeb[dbc.IP] = make(map[int]struct{})
+				ports, ok := portsFromWeb[dbc.IP]
+				if !ok {
+					ports = make(map[publicPort]struct{})
+					portsFromWeb[dbc.IP] = ports
 				}
 
-				portsFromWeb[dbc.IP][conn.MinPort] = struct{}{}
+				for _, port := range publicPorts(conn) {
+					if _, ok := withdrawnPorts[dbc.IP][port]; !ok {
+						ports[port] = struct{}{}
+					}
+				}
 			}
 		}
 	}
 
-	// Map the container's port to the same port of the host.
-	for ip, ports := range portsFromWeb {
-		for port := range ports {
-			for _, protocol := range protocols {
-				strRules = append(strRules, fmt.Sprintf(
-					"-A PREROUTING -i %[1]s "+
-						"-p %[2]s -m %[2]s --dport %[3]d -j "+
-						"DNAT --to-destination %[4]s:%[3]d",
-					publicInterface, protocol, port, ip))
-			}
-		}
+	return portsFromWeb
+}
+
+// A publicPort is a port range and protocol on which a container communicates with
+// the public internet.  Most are a single port, but connections on the ephemeral
+// ports open the whole range.
+type publicPort struct {
+	minPort, maxPort int
+	protocol         string
+}
+
+// publicPorts returns the public ports opened by `conn`, one for each protocol it
+// allows.  ICMP connections don't have ports, so they don't open any.
+func publicPorts(conn db.Connection) []publicPort {
+	if conn.Protocol == stitch.ICMP {
+		return nil
 	}
 
-	var rules ipRuleSlice
-	for _, r := range strRules {
-		rule, err := makeIPRule(r)
-		if err != nil {
-			panic("malformed target NAT rule")
+	var ports []publicPort
+	for _, protocol := range stitch.Protocols(conn.Protocol) {
+		ports = append(ports, publicPort{conn.MinPort, conn.MaxPort, protocol})
+	}
+	return ports
+}
+
+// portMatches returns the OpenFlow matches on `field` that together match the traffic
+// of `port`, formatted as in the output of `ovs-ofctl dump-flows`.  OpenFlow can only
+// match ports under a mask, so a range takes a match for each of the blocks it splits
+// into, rather than one for every port.
+func portMatches(port publicPort, field string) []string {
+	var matches []string
+	for _, pm := range portMasks(port.minPort, port.maxPort) {
+		switch pm.mask {
+		case 0:
+			matches = append(matches, port.protocol)
+		case 0xffff:
+			matches = append(matches, fmt.Sprintf("%s,%s=%d",
+				port.protocol, field, pm.port))
+		default:
+			matches = append(matches, fmt.Sprintf("%s,%s=0x%x/0x%x",
+				port.protocol, field, pm.port, pm.mask))
 		}
-		rules = append(rules, rule)
 	}
-	return rules
+	return matches
 }
 
 // There certain exceptions, as certain ports will never be deleted.
@@ -462,26 +633,44 @@ func generateTargetIPs(dbc db.Container, labelIPs map[string]string) []string {
 func updateOpenFlow(odb ovsdb.Client, containers []db.Container,
 	labels []db.Label, connections []db.Connection) {
 
-	targetOF, err := generateTargetOpenFlow(odb, containers, labels, connections)
+	owners, err := openFlowOwners(odb, containers, labels, connections)
 	if err != nil {
 		log.WithError(err).Error("failed to get target OpenFlow flows")
 		return
 	}
-	currentOF, err := generateCurrentOpenFlow()
-	if err != nil {
-		log.WithError(err).Error("failed to get current OpenFlow flows")
-		return
-	}
 
-	_, flowsToDel, flowsToAdd := join.HashJoin(currentOF, targetOF, nil, nil)
+	ofScope.sync(owners, func() (join.List, error) {
+		flows, err := generateCurrentOpenFlow()
+		return flows, err
+	}, applyFlows)
+}
+
+var (
+	ofScope = newRuleScope("OpenFlow", func(flow string) (interface{}, error) {
+		return makeOFRule(flow)
+	})
 
+	ofAddCounter     = counter.New("network", "Add OpenFlow Flow")
+	ofDeleteCounter  = counter.New("network", "Delete OpenFlow Flow")
+	ofFailureCounter = counter.New("network", "OpenFlow Flow Failure")
+)
+
+func applyFlows(flowsToDel, flowsToAdd []interface{}) bool {
+	ok := true
+	ofDeleteCounter.Add(uint64(len(flowsToDel)))
 	if err := addOrDelFlows(flowsToDel, false); err != nil {
+		ofFailureCounter.Inc()
 		log.WithError(err).Error("error deleting OpenFlow flow")
+		ok = false
 	}
 
+	ofAddCounter.Add(uint64(len(flowsToAdd)))
 	if err := addOrDelFlows(flowsToAdd, true); err != nil {
+		ofFailureCounter.Inc()
 		log.WithError(err).Error("error adding OpenFlow flow")
+		ok = false
 	}
+	return ok
 }
 
 func generateCurrentOpenFlow() (OFRuleSlice, error) {
@@ -516,11 +705,13 @@ func generateCurrentOpenFlow() (OFRuleSlice, error) {
 	return flows, nil
 }
 
-// The target flows must be in the same format as the output from ovs-ofctl
-// dump-flows. To achieve this, we have some rather ugly hacks that handle
+// openFlowOwners returns the target flows.  The flows that connect each container
+// belong to that container, and the flows that balance each label's traffic belong to
+// that label.  The target flows must be in the same format as the output from
+// ovs-ofctl dump-flows. To achieve this, we have some rather ugly hacks that handle
 // a few special cases.
-func generateTargetOpenFlow(odb ovsdb.Client, containers []db.Container,
-	labels []db.Label, connections []db.Connection) (OFRuleSlice, error) {
+func openFlowOwners(odb ovsdb.Client, containers []db.Container,
+	labels []db.Label, connections []db.Connection) ([]ruleOwner, error) {
 
 	ifaces, err := odb.ListInterfaces()
 	if err != nil {
@@ -534,7 +725,7 @@ func generateTargetOpenFlow(odb ovsdb.Client, containers []db.Container,
 		}
 	}
 
-	var rules []string
+	var owners []ruleOwner
 	for _, dbc := range containers {
 		vethOut := ipdef.IFName(dbc.EndpointID)
 		_, peerQuilt := patchPorts(dbc.DockerID)
@@ -554,27 +745,37 @@ func generateTargetOpenFlow(odb ovsdb.Client, containers []db.Container,
 			continue
 		}
 
-		rules = append(rules, []string{
+		rules := []string{
 			fmt.Sprintf("table=0 priority=%d,in_port=%d "+
 				"actions=output:%d", 5000, ofQuilt, ofVeth),
 			fmt.Sprintf("table=2 priority=%d,in_port=%d "+
 				"actions=output:%d", 5000, ofVeth, ofQuilt),
 			fmt.Sprintf("table=0 priority=%d,in_port=%d "+
 				"actions=output:%d", 0, ofVeth, ofQuilt),
-		}...)
-
-		protocols := []string{"tcp", "udp"}
+		}
 
-		portsToWeb := make(map[int]struct{})
-		portsFromWeb := make(map[int]struct{})
+		portsToWeb := make(map[publicPort]struct{})
+		portsFromWeb := make(map[publicPort]struct{})
+		icmpWeb := false
 		for _, l := range dbc.Labels {
 			for _, conn := range connections {
+				if conn.Protocol == stitch.ICMP &&
+					(conn.From == l || conn.To == l) &&
+					(conn.From == stitch.PublicInternetLabel ||
+						conn.To == stitch.PublicInternetLabel) {
+					icmpWeb = true
+				}
+
 				if conn.From == l &&
 					conn.To == stitch.PublicInternetLabel {
-					portsToWeb[conn.MinPort] = struct{}{}
+					for _, port := range publicPorts(conn) {
+						portsToWeb[port] = struct{}{}
+					}
 				} else if conn.From ==
 					stitch.PublicInternetLabel && conn.To == l {
-					portsFromWeb[conn.MinPort] = struct{}{}
+					for _, port := range publicPorts(conn) {
+						portsFromWeb[port] = struct{}{}
+					}
 				}
 			}
 		}
@@ -582,38 +783,32 @@ func generateTargetOpenFlow(odb ovsdb.Client, containers []db.Container,
 		// LOCAL is the default quilt-int port created with the bridge.
 		egressRule := fmt.Sprintf("table=0 priority=%d,in_port=%d,",
 			5000, ofVeth) +
-			"%s,%s," + fmt.Sprintf("dl_dst=%s actions=LOCAL",
+			"%s," + fmt.Sprintf("dl_dst=%s actions=LOCAL",
 			ipdef.IPToMac(ipdef.GatewayIP))
 		ingressRule := fmt.Sprintf("table=0 priority=%d,in_port=LOCAL,", 5000) +
-			"%s,%s," + fmt.Sprintf("dl_dst=%s actions=output:%d",
+			"%s," + fmt.Sprintf("dl_dst=%s actions=output:%d",
 			dbcMac, ofVeth)
 
 		for port := range portsFromWeb {
-			for _, protocol := range protocols {
-				egressPort := fmt.Sprintf("tp_src=%d", port)
-				rules = append(rules, fmt.Sprintf(egressRule, protocol,
-					egressPort))
-
-				ingressPort := fmt.Sprintf("tp_dst=%d", port)
-				rules = append(rules, fmt.Sprintf(ingressRule, protocol,
-					ingressPort))
+			for _, match := range portMatches(port, "tp_src") {
+				rules = append(rules, fmt.Sprintf(egressRule, match))
+			}
+			for _, match := range portMatches(port, "tp_dst") {
+				rules = append(rules, fmt.Sprintf(ingressRule, match))
 			}
 		}
 
 		for port := range portsToWeb {
-			for _, protocol := range protocols {
-				egressPort := fmt.Sprintf("tp_dst=%d", port)
-				rules = append(rules, fmt.Sprintf(egressRule, protocol,
-					egressPort))
-
-				ingressPort := fmt.Sprintf("tp_src=%d", port)
-				rules = append(rules, fmt.Sprintf(ingressRule, protocol,
-					ingressPort))
+			for _, match := range portMatches(port, "tp_dst") {
+				rules = append(rules, fmt.Sprintf(egressRule, match))
+			}
+			for _, match := range portMatches(port, "tp_src") {
+				rules = append(rules, fmt.Sprintf(ingressRule, match))
 			}
 		}
 
 		var arpDst string
-		if len(portsToWeb) > 0 || len(portsFromWeb) > 0 {
+		if len(portsToWeb) > 0 || len(portsFromWeb) > 0 || icmpWeb {
 			// Allow ICMP
 			rules = append(rules,
 				fmt.Sprintf(
@@ -647,6 +842,10 @@ func generateTargetOpenFlow(odb ovsdb.Client, containers []db.Container,
 			"table=0 priority=%d,arp,in_port=LOCAL,"+
 				"dl_dst=%s actions=output:%d",
 			4500, dbcMac, ofVeth))
+
+		sort.Strings(rules)
+		owners = append(owners, ruleOwner{key: "container " + dbc.DockerID,
+			rules: rules})
 	}
 
 	LabelMacs := make(map[string]map[string]struct{})
@@ -681,10 +880,10 @@ func generateTargetOpenFlow(odb ovsdb.Client, containers []db.Container,
 		mpa := fmt.Sprintf("multipath(symmetric_l3l4,0,modulo_n,%d,0,"+
 			"NXM_NX_REG0[%s])", n, nxmRange)
 
-		rules = append(rules, fmt.Sprintf(
+		rules := []string{fmt.Sprintf(
 			"table=0 priority=%d,dl_dst=%s,ip,nw_dst=%s "+
 				"actions=%s,resubmit(,1)",
-			4000, labelMac, ip, mpa))
+			4000, labelMac, ip, mpa)}
 
 		// We need the order to make diffing consistent.
 		macList := make([]string, 0, n)
@@ -709,22 +908,30 @@ func generateTargetOpenFlow(odb ovsdb.Client, containers []db.Container,
 				ip, reg0, mac))
 			i++
 		}
+		owners = append(owners, ruleOwner{key: "label " + label.Label,
+			rules: rules})
 	}
 
-	var targetRules OFRuleSlice
-	for _, r := range rules {
-		rule, err := makeOFRule(r)
-		if err != nil {
-			return nil, fmt.Errorf("failed to make OpenFlow rule: %s", err)
+	return owners, nil
+}
+
+// splitHorizonHostnames maps each label to the external hostnames it publishes that
+// the deployment's own containers should resolve to the label's internal address.
+func splitHorizonHostnames(spec stitch.Stitch) map[string][]string {
+	hostnames := map[string][]string{}
+	for _, label := range spec.Labels {
+		for _, hostname := range label.Hostnames {
+			if hostname.SplitHorizon {
+				hostnames[label.Name] = append(hostnames[label.Name],
+					hostname.Name)
+			}
 		}
-		targetRules = append(targetRules, rule)
 	}
-
-	return targetRules, nil
+	return hostnames
 }
 
 func updateEtcHosts(dk docker.Client, containers []db.Container, labels []db.Label,
-	connections []db.Connection) {
+	connections []db.Connection, hostnames map[string][]string) {
 
 	/* Map label name to the label itself. */
 	labelMap := make(map[string]db.Label)
@@ -754,7 +961,7 @@ func updateEtcHosts(dk docker.Client, containers []db.Container, labels []db.Lab
 				continue
 			}
 
-			newHosts := generateEtcHosts(dbc, labelMap, conns)
+			newHosts := generateEtcHosts(dbc, labelMap, conns, hostnames)
 
 			if newHosts != currHosts {
 				err = dk.WriteToContainer(id, newHosts, "/etc",
@@ -783,8 +990,12 @@ func updateEtcHosts(dk docker.Client, containers []db.Container, labels []db.Lab
 	wg.Wait()
 }
 
+// generateEtcHosts returns the /etc/hosts file for `dbc`.  It has entries for the
+// labels `dbc` connects to, and for the split horizon `hostnames` of those labels and
+// of `dbc`'s own labels.  A split horizon hostname resolves to the label's IP, which
+// is its container's IP until it has several containers, and its virtual IP after.
 func generateEtcHosts(dbc db.Container, labels map[string]db.Label,
-	conns map[string][]string) string {
+	conns map[string][]string, hostnames map[string][]string) string {
 
 	type entry struct {
 		ip, host string
@@ -826,6 +1037,19 @@ func generateEtcHosts(dbc db.Container, labels map[string]db.Label,
 		}
 	}
 
+	for _, l := range dbc.Labels {
+		for _, toLabel := range append([]string{l}, conns[l]...) {
+			ip := labels[toLabel].IP
+			if ip == "" {
+				continue
+			}
+
+			for _, hostname := range hostnames[toLabel] {
+				newHosts[entry{ip, hostname}] = struct{}{}
+			}
+		}
+	}
+
 	var hosts []string
 	for h := range newHosts {
 		hosts = append(hosts, fmt.Sprintf("%-15s %s", h.ip, h.host))
@@ -942,19 +1166,104 @@ func addNatRule(rule ipRule) error {
 	return nil
 }
 
-// getPublicInterface gets the interface with the default route.
+// Stored in variables so they may be mocked out in the unit tests.
+var routeList = netlink.RouteList
+var routeGet = netlink.RouteGet
+var linkByIndex = netlink.LinkByIndex
+
+// A next hop of a default route, which may be one of several in a multipath route.
+type defaultHop struct {
+	linkIndex int
+	metric    int
+	gateway   net.IP
+}
+
+// getPublicInterface gets the interface of the default route in the main table
+// with the lowest metric.  Interfaces that are down, and point-to-point tunnels,
+// are skipped, as traffic to the internet doesn't leave the machine through them.
+// Equal metrics are broken by the lowest interface index, so that the choice is
+// stable.
 func getPublicInterface() (string, error) {
-	stdout, _, err := ipExecVerbose("", "route list")
+	routes, err := routeList(nil, netlink.FAMILY_V4)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("failed to list routes: %s", err)
+	}
+
+	var hops []defaultHop
+	for _, route := range routes {
+		if !isDefaultRoute(route) || route.Table != syscall.RT_TABLE_MAIN {
+			continue
+		}
+
+		if len(route.MultiPath) == 0 {
+			hops = append(hops, defaultHop{route.LinkIndex, route.Priority,
+				route.Gw})
+		}
+		for _, nh := range route.MultiPath {
+			hops = append(hops, defaultHop{nh.LinkIndex, route.Priority, nh.Gw})
+		}
+	}
+
+	sort.Sort(hopSlice(hops))
+
+	var skipped []string
+	for i, hop := range hops {
+		link, err := linkByIndex(hop.linkIndex)
+		if err != nil {
+			log.WithError(err).WithField("index", hop.linkIndex).Warn(
+				"Failed to get default route's interface")
+			continue
+		}
+
+		attrs := link.Attrs()
+		if attrs.Flags&net.FlagUp == 0 {
+			skipped = append(skipped, attrs.Name+" (down)")
+			continue
+		}
+
+		if attrs.Flags&net.FlagPointToPoint != 0 {
+			skipped = append(skipped, attrs.Name+" (point-to-point)")
+			continue
+		}
+
+		reason := "lowest metric default route"
+		if i+1 < len(hops) && hops[i+1].metric == hop.metric {
+			reason += ", tie broken by interface index"
+		}
+
+		log.WithFields(log.Fields{
+			"interface": attrs.Name,
+			"metric":    hop.metric,
+			"gateway":   hop.gateway,
+			"skipped":   skipped,
+		}).Infof("Chose public interface: %s", reason)
+		return attrs.Name, nil
+	}
+
+	if len(skipped) > 0 {
+		return "", fmt.Errorf("no usable default route, skipped: %s",
+			strings.Join(skipped, ", "))
 	}
+	return "", errors.New("no default route")
+}
+
+type hopSlice []defaultHop
 
-	matches := regexp.MustCompile("default .* dev (.*)").FindSubmatch(stdout)
-	if len(matches) < 2 {
-		return "", errors.New("no default route")
+func (hops hopSlice) Len() int      { return len(hops) }
+func (hops hopSlice) Swap(i, j int) { hops[i], hops[j] = hops[j], hops[i] }
+func (hops hopSlice) Less(i, j int) bool {
+	if hops[i].metric != hops[j].metric {
+		return hops[i].metric < hops[j].metric
 	}
+	return hops[i].linkIndex < hops[j].linkIndex
+}
 
-	return strings.TrimSpace(string(matches[1])), nil
+func isDefaultRoute(route netlink.Route) bool {
+	if route.Dst == nil {
+		return true
+	}
+	ones, _ := route.Dst.Mask.Size()
+	return ones == 0
 }
 
 func addOrDelFlows(flows []interface{}, add bool) error {
@@ -1,10 +1,18 @@
 package network
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"reflect"
+	"sort"
+	"syscall"
 	"testing"
 
 	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/stitch"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
 )
 
 func TestNoConnections(t *testing.T) {
@@ -16,7 +24,7 @@ func TestNoConnections(t *testing.T) {
 		Labels:   []string{"green"},
 	}
 
-	actual := generateEtcHosts(dbc, labels, connections)
+	actual := generateEtcHosts(dbc, labels, connections, nil)
 	exp := "1.1.1.1         abcdefghijkl" + localhosts()
 
 	if exp != actual {
@@ -34,7 +42,7 @@ func TestImplementsSingleLabel(t *testing.T) {
 		Labels:   []string{"red"},
 	}
 
-	actual := generateEtcHosts(dbc, labels, connections)
+	actual := generateEtcHosts(dbc, labels, connections, nil)
 	exp := `1.1.1.1         1.green.q
 1.2.2.2         abcdefghijkl
 1.3.3.3         1.blue.q
@@ -57,7 +65,7 @@ func TestImplementsMultipleLabels(t *testing.T) {
 		Labels:   []string{"red", "blue"},
 	}
 
-	actual := generateEtcHosts(dbc, labels, connections)
+	actual := generateEtcHosts(dbc, labels, connections, nil)
 	exp := `1.1.1.1         1.green.q
 1.2.2.2         1.red.q
 1.3.3.3         1.blue.q
@@ -88,7 +96,7 @@ func TestDuplicateConnections(t *testing.T) {
 
 	connections["blue"] = append(connections["blue"], "green")
 
-	actual := generateEtcHosts(dbc, labels, connections)
+	actual := generateEtcHosts(dbc, labels, connections, nil)
 	exp := `1.1.1.1         1.green.q
 1.2.2.2         1.red.q
 1.3.3.3         1.blue.q
@@ -106,6 +114,76 @@ func TestDuplicateConnections(t *testing.T) {
 	}
 }
 
+func TestSplitHorizonHostnames(t *testing.T) {
+	spec, err := stitch.FromJavascript(`var web = new Service("web",
+		[new Container("nginx")]);
+	var app = new Service("app", [new Container("app")]);
+	web.publishHostname("www.example.com", {splitHorizon: true});
+	web.publishHostname("example.com");
+	app.connect(443, web);
+	deployment.deploy([web, app]);`, stitch.DefaultImportGetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostnames := splitHorizonHostnames(spec)
+	expHostnames := map[string][]string{"web": {"www.example.com"}}
+	if !reflect.DeepEqual(hostnames, expHostnames) {
+		t.Errorf("Wrong split horizon hostnames.\nExpected:\n%v\n\nGot:\n%v\n",
+			expHostnames, hostnames)
+	}
+
+	app := db.Container{IP: "1.1.1.1", Labels: []string{"app"}}
+	web := db.Container{IP: "1.2.2.2", Labels: []string{"web"}}
+	conns := map[string][]string{"app": {"web"}}
+
+	// While web has a single container, its hostname resolves to that container.
+	labels := map[string]db.Label{
+		"app": {IP: "1.1.1.1", ContainerIPs: []string{"1.1.1.1"}},
+		"web": {IP: "1.2.2.2", ContainerIPs: []string{"1.2.2.2"}},
+	}
+
+	actual := generateEtcHosts(app, labels, conns, hostnames)
+	exp := `1.2.2.2         1.web.q
+1.2.2.2         web.q
+1.2.2.2         www.example.com` + localhosts()
+	if exp != actual {
+		t.Errorf("Generated wrong split horizon /etc/hosts."+
+			"\nExpected:\n%s\n\nGot:\n%s\n", exp, actual)
+	}
+
+	// The label's own containers resolve it as well.
+	actual = generateEtcHosts(web, labels, conns, hostnames)
+	exp = "1.2.2.2         www.example.com" + localhosts()
+	if exp != actual {
+		t.Errorf("Generated wrong split horizon /etc/hosts for its label."+
+			"\nExpected:\n%s\n\nGot:\n%s\n", exp, actual)
+	}
+
+	// Once web scales to three containers, its hostname resolves to its virtual IP.
+	labels["web"] = db.Label{IP: "10.0.0.1", MultiHost: true,
+		ContainerIPs: []string{"1.2.2.2", "1.3.3.3", "1.4.4.4"}}
+
+	actual = generateEtcHosts(app, labels, conns, hostnames)
+	exp = `1.2.2.2         1.web.q
+1.3.3.3         2.web.q
+1.4.4.4         3.web.q
+10.0.0.1        web.q
+10.0.0.1        www.example.com` + localhosts()
+	if exp != actual {
+		t.Errorf("Generated wrong split horizon /etc/hosts after scaling."+
+			"\nExpected:\n%s\n\nGot:\n%s\n", exp, actual)
+	}
+
+	// Containers that don't connect to web resolve its hostname publicly.
+	other := db.Container{IP: "1.5.5.5", Labels: []string{"other"}}
+	actual = generateEtcHosts(other, labels, conns, hostnames)
+	if exp := localhosts()[1:]; exp != actual {
+		t.Errorf("Generated wrong /etc/hosts for an unconnected container."+
+			"\nExpected:\n%s\n\nGot:\n%s\n", exp, actual)
+	}
+}
+
 func TestMakeIPRule(t *testing.T) {
 	inp := "-A INPUT -p tcp -i eth0 -m multiport --dports 465,110,995 -j ACCEPT"
 	rule, _ := makeIPRule(inp)
@@ -209,6 +287,113 @@ func TestGenerateCurrentNatRules(t *testing.T) {
 	}
 }
 
+func TestPublicUDPNatRules(t *testing.T) {
+	spec, err := stitch.FromJavascript(`
+	var dns = new Service("dns", [new Container("dns")]);
+	var dnsTCP = new Service("dnsTCP", [new Container("dnsTCP")]);
+	publicInternet.connect(53, dns, "udp");
+	publicInternet.connect(53, dnsTCP, "tcp");
+	deployment.deploy([dns, dnsTCP]);`, stitch.DefaultImportGetter)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The two services don't conflict, so they may run on the same machine.
+	for _, plcm := range spec.Placements {
+		if plcm.TargetLabel != plcm.OtherLabel {
+			t.Errorf("unexpected placement: %+v", plcm)
+		}
+	}
+
+	var connections []db.Connection
+	for _, c := range spec.Connections {
+		connections = append(connections, db.Connection{
+			From:     c.From,
+			To:       c.To,
+			MinPort:  c.MinPort,
+			MaxPort:  c.MaxPort,
+			Protocol: c.Protocol,
+		})
+	}
+
+	containers := []db.Container{
+		{IP: "10.0.0.2", Labels: []string{"dns"}},
+		{IP: "10.0.0.3", Labels: []string{"dnsTCP"}},
+	}
+
+	var dnat []string
+	for _, rule := range generateTargetNatRules("eth0", containers, connections) {
+		if rule.chain == "PREROUTING" && rule.cmd == "-A" {
+			dnat = append(dnat, rule.opts)
+		}
+	}
+	sort.Strings(dnat)
+
+	exp := []string{
+		"-i eth0 -p tcp -m tcp --dport 53 -j DNAT --to-destination 10.0.0.3:53",
+		"-i eth0 -p udp -m udp --dport 53 -j DNAT --to-destination 10.0.0.2:53",
+	}
+	if !reflect.DeepEqual(dnat, exp) {
+		t.Errorf("Generated wrong DNAT rules.\nExpected:\n%v\n\nGot:\n%v\n",
+			exp, dnat)
+	}
+}
+
+func TestPublicICMPNatRules(t *testing.T) {
+	connections := []db.Connection{
+		{From: "public", To: "web", Protocol: stitch.ICMP},
+		{From: "web", To: "public", Protocol: stitch.ICMP},
+	}
+	containers := []db.Container{{IP: "10.0.0.2", Labels: []string{"web"}}}
+
+	// ICMP doesn't have ports to forward.
+	for _, rule := range generateTargetNatRules("eth0", containers, connections) {
+		if rule.chain == "PREROUTING" && rule.cmd == "-A" {
+			t.Errorf("unexpected DNAT rule: %+v", rule)
+		}
+	}
+
+	for _, conn := range connections {
+		if ports := publicPorts(conn); len(ports) != 0 {
+			t.Errorf("unexpected public ports for %s: %v", conn, ports)
+		}
+	}
+}
+
+func TestEphemeralNatRules(t *testing.T) {
+	connections := []db.Connection{{From: "public", To: "web",
+		MinPort: stitch.EphemeralMinPort, MaxPort: stitch.EphemeralMaxPort,
+		Protocol: stitch.TCP}}
+	containers := []db.Container{{IP: "10.0.0.2", Labels: []string{"web"}}}
+
+	// The range is forwarded with a single rule, rather than one per port.
+	var dnats []string
+	for _, rule := range generateTargetNatRules("eth0", containers, connections) {
+		if rule.chain == "PREROUTING" && rule.cmd == "-A" {
+			dnats = append(dnats, rule.opts)
+		}
+	}
+	assert.Equal(t, []string{"-i eth0 -p tcp -m tcp --dport 32768:60999 " +
+		"-j DNAT --to-destination 10.0.0.2"}, dnats)
+
+	// OpenFlow can't match ranges, so it takes a match for each masked block.
+	port := publicPorts(connections[0])[0]
+	assert.Equal(t, []string{
+		"tcp,tp_dst=0x8000/0xc000",
+		"tcp,tp_dst=0xc000/0xe000",
+		"tcp,tp_dst=0xe000/0xf800",
+		"tcp,tp_dst=0xe800/0xfc00",
+		"tcp,tp_dst=0xec00/0xfe00",
+		"tcp,tp_dst=0xee00/0xffc0",
+		"tcp,tp_dst=0xee40/0xfff8",
+	}, portMatches(port, "tp_dst"))
+
+	assert.Equal(t, []string{"udp,tp_src=80"},
+		portMatches(publicPort{80, 80, stitch.UDP}, "tp_src"))
+	assert.Equal(t, []string{"tcp"},
+		portMatches(publicPort{0, 65535, stitch.TCP}, "tp_src"))
+}
+
 func TestMakeOFRule(t *testing.T) {
 	flows := []string{
 		"cookie=0x0, duration=997.526s, table=0, n_packets=0, " +
@@ -335,3 +520,87 @@ func rules() string {
 -A POSTROUTING -s 11.0.0.0/8,10.0.0.0/8 -o eth0 -j MASQUERADE
 -A POSTROUTING -s 10.0.3.0/24 ! -d 10.0.3.0/24 -j MASQUERADE`
 }
+
+func TestGetPublicInterface(t *testing.T) {
+	links := map[int]netlink.Link{}
+	for i, attrs := range []netlink.LinkAttrs{
+		{Name: "eth0", Flags: net.FlagUp},
+		{Name: "eth1", Flags: net.FlagUp},
+		{Name: "eth2"},
+		{Name: "tun0", Flags: net.FlagUp | net.FlagPointToPoint},
+	} {
+		attrs.Index = i + 1
+		links[attrs.Index] = &netlink.Dummy{LinkAttrs: attrs}
+	}
+
+	oldLinkByIndex := linkByIndex
+	defer func() { linkByIndex = oldLinkByIndex }()
+	linkByIndex = func(index int) (netlink.Link, error) {
+		if link, ok := links[index]; ok {
+			return link, nil
+		}
+		return nil, fmt.Errorf("no link %d", index)
+	}
+
+	var routes []netlink.Route
+	var routesErr error
+	oldRouteList := routeList
+	defer func() { routeList = oldRouteList }()
+	routeList = func(_ netlink.Link, _ int) ([]netlink.Route, error) {
+		return routes, routesErr
+	}
+
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/8")
+	_, everything, _ := net.ParseCIDR("0.0.0.0/0")
+	defaultRoute := func(index, metric int) netlink.Route {
+		return netlink.Route{LinkIndex: index, Priority: metric,
+			Table: syscall.RT_TABLE_MAIN}
+	}
+
+	check := func(exp string, rs ...netlink.Route) {
+		routes = rs
+		intf, err := getPublicInterface()
+		assert.NoError(t, err)
+		assert.Equal(t, exp, intf)
+	}
+
+	check("eth0", defaultRoute(1, 0))
+	check("eth1", defaultRoute(1, 200), defaultRoute(2, 100))
+
+	// Equal metrics go to the lowest interface index, regardless of order.
+	check("eth0", defaultRoute(2, 100), defaultRoute(1, 100))
+	check("eth0", defaultRoute(1, 100), defaultRoute(2, 100))
+
+	// Interfaces that are down, tunnels, and unknown interfaces are skipped.
+	check("eth1", defaultRoute(3, 0), defaultRoute(4, 0), defaultRoute(5, 0),
+		defaultRoute(2, 500))
+
+	// Only default routes in the main table count.
+	policyRoute := defaultRoute(1, 0)
+	policyRoute.Table = 100
+	subnetRoute := defaultRoute(1, 0)
+	subnetRoute.Dst = subnet
+	check("eth1", policyRoute, subnetRoute, defaultRoute(2, 100))
+
+	explicitDefault := defaultRoute(2, 0)
+	explicitDefault.Dst = everything
+	check("eth1", explicitDefault, defaultRoute(1, 100))
+
+	// Each next hop of a multipath route is a candidate.
+	multipath := defaultRoute(0, 50)
+	multipath.MultiPath = []*netlink.NexthopInfo{{LinkIndex: 4}, {LinkIndex: 2}}
+	check("eth1", defaultRoute(1, 100), multipath)
+
+	routes = []netlink.Route{subnetRoute}
+	_, err := getPublicInterface()
+	assert.EqualError(t, err, "no default route")
+
+	routes = []netlink.Route{defaultRoute(3, 0), defaultRoute(4, 0)}
+	_, err = getPublicInterface()
+	assert.EqualError(t, err, "no usable default route, skipped: "+
+		"eth2 (down), tun0 (point-to-point)")
+
+	routesErr = errors.New("netlink error")
+	_, err = getPublicInterface()
+	assert.EqualError(t, err, "failed to list routes: netlink error")
+}
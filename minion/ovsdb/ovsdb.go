@@ -57,6 +57,8 @@ type Interface struct {
 	Bridge      string
 	Type        string
 	OFPort      *int
+	PSK         string // The IPsec pre-shared key of a tunnel interface.
+	RemoteIP    string // The IP of the remote end of a tunnel interface.
 }
 
 const (
@@ -626,6 +628,53 @@ func (ovsdb Client) ModifyInterface(iface Interface) error {
 	return errorCheck(results, len(ops))
 }
 
+// SetInterfacePSK sets the pre-shared key with which OVS encrypts the tunnel interface
+// `name` using IPsec.  An empty `psk` disables encryption.
+func (ovsdb Client) SetInterfacePSK(name, psk string) error {
+	reply, err := ovsdb.transact("Open_vSwitch", ovs.Operation{
+		Op:    "select",
+		Table: "Interface",
+		Where: newCondition("name", "==", name),
+	})
+	if err != nil {
+		return fmt.Errorf("transaction error: selecting interface %s: %s",
+			name, err)
+	}
+
+	if len(reply) == 0 || len(reply[0].Rows) != 1 {
+		return fmt.Errorf("no such interface: %s", name)
+	}
+
+	options, err := ovsStringMapToMap(reply[0].Rows[0]["options"])
+	if err != nil {
+		return err
+	}
+
+	if psk == "" {
+		delete(options, "psk")
+	} else {
+		options["psk"] = psk
+	}
+
+	optionsMap, err := ovs.NewOvsMap(options)
+	if err != nil {
+		return err
+	}
+
+	results, err := ovsdb.transact("Open_vSwitch", ovs.Operation{
+		Op:    "update",
+		Table: "Interface",
+		Where: newCondition("name", "==", name),
+		Row:   row{"options": optionsMap},
+	})
+	if err != nil {
+		return fmt.Errorf("transaction error: setting psk of interface %s: %s",
+			name, err)
+	}
+
+	return errorCheck(results, 1)
+}
+
 func ifaceFromRow(row row) (Interface, error) {
 	iface := Interface{}
 
@@ -673,6 +722,9 @@ func ifaceFromRow(row row) (Interface, error) {
 		log.Debug("missing Interface key: peer.")
 	}
 
+	iface.PSK = options["psk"]
+	iface.RemoteIP = options["remote_ip"]
+
 	if amac, ok := externalIDs["attached-mac"]; ok {
 		iface.AttachedMAC = amac
 	} else {
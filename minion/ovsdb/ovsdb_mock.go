@@ -333,6 +333,13 @@ Outer:
 			}
 		}
 		for k, v := range op.Row {
+			if mp, ok := v.(*ovs.OvsMap); ok {
+				var pairs []interface{}
+				for key, val := range mp.GoMap {
+					pairs = append(pairs, []interface{}{key, val})
+				}
+				v = []interface{}{"map", pairs}
+			}
 			row[k] = v
 			updateCount++
 		}
@@ -460,3 +460,36 @@ func (slc addressSlice) Len() int {
 func (slc addressSlice) Get(i int) interface{} {
 	return slc[i]
 }
+
+func TestSetInterfacePSK(t *testing.T) {
+	ovsdbClient := NewFakeOvsdbClient()
+
+	_, err := ovsdbClient.transact("Open_vSwitch", ovs.Operation{
+		Op:    "insert",
+		Table: "Bridge",
+		Row:   map[string]interface{}{"name": "br-int"},
+	})
+	assert.Nil(t, err)
+
+	err = ovsdbClient.CreateInterface("br-int", "stt-tunnel")
+	assert.Nil(t, err)
+
+	getPSK := func() string {
+		ifaces, err := ovsdbClient.ListInterfaces()
+		assert.Nil(t, err)
+		assert.Len(t, ifaces, 1)
+		return ifaces[0].PSK
+	}
+	assert.Empty(t, getPSK())
+
+	assert.Nil(t, ovsdbClient.SetInterfacePSK("stt-tunnel", "key1"))
+	assert.Equal(t, "key1", getPSK())
+
+	assert.Nil(t, ovsdbClient.SetInterfacePSK("stt-tunnel", "key2"))
+	assert.Equal(t, "key2", getPSK())
+
+	assert.Nil(t, ovsdbClient.SetInterfacePSK("stt-tunnel", ""))
+	assert.Empty(t, getPSK())
+
+	assert.NotNil(t, ovsdbClient.SetInterfacePSK("missing", "key"))
+}
@@ -6,12 +6,18 @@
 Package pb is a generated protocol buffer package.
 
 It is generated from these files:
+
 	minion/pb/pb.proto
 
 It has these top-level messages:
+
 	MinionConfig
 	Reply
 	Request
+	MinionCounter
+	MinionCountersReply
+	ProbeRequest
+	ProbeReply
 */
 package pb
 
@@ -69,6 +75,23 @@ type MinionConfig struct {
 	Region         string            `protobuf:"bytes,7,opt,name=Region,json=region" json:"Region,omitempty"`
 	EtcdMembers    []string          `protobuf:"bytes,8,rep,name=EtcdMembers,json=etcdMembers" json:"EtcdMembers,omitempty"`
 	AuthorizedKeys []string          `protobuf:"bytes,9,rep,name=AuthorizedKeys,json=authorizedKeys" json:"AuthorizedKeys,omitempty"`
+	DedicatedTo    string            `protobuf:"bytes,10,opt,name=DedicatedTo,json=dedicatedTo" json:"DedicatedTo,omitempty"`
+	FloatingIP     string            `protobuf:"bytes,11,opt,name=FloatingIP,json=floatingIP" json:"FloatingIP,omitempty"`
+	Draining       bool              `protobuf:"varint,12,opt,name=Draining,json=draining" json:"Draining,omitempty"`
+	// Reported by the minion, ignored when set.
+	Containers int32 `protobuf:"varint,13,opt,name=Containers,json=containers" json:"Containers,omitempty"`
+	// How long the phases of the minion's boot took, in nanoseconds.  Reported
+	// by the minion, ignored when set.
+	BootMinion         int64 `protobuf:"varint,14,opt,name=BootMinion,json=bootMinion" json:"BootMinion,omitempty"`
+	BootImagePull      int64 `protobuf:"varint,15,opt,name=BootImagePull,json=bootImagePull" json:"BootImagePull,omitempty"`
+	BootFirstContainer int64 `protobuf:"varint,16,opt,name=BootFirstContainer,json=bootFirstContainer" json:"BootFirstContainer,omitempty"`
+	DisableNAT         bool  `protobuf:"varint,17,opt,name=DisableNAT,json=disableNAT" json:"DisableNAT,omitempty"`
+	// The number of containers the scheduler failed to place.  Reported by
+	// the leader, ignored when set.
+	Unscheduled int32 `protobuf:"varint,18,opt,name=Unscheduled,json=unscheduled" json:"Unscheduled,omitempty"`
+	// The JSON encoded health of the system containers run by the minion's
+	// supervisor.  Reported by the minion, ignored when set.
+	SupervisorStatus string `protobuf:"bytes,19,opt,name=SupervisorStatus,json=supervisorStatus" json:"SupervisorStatus,omitempty"`
 }
 
 func (m *MinionConfig) Reset()                    { *m = MinionConfig{} }
@@ -92,10 +115,64 @@ func (m *Request) String() string            { return proto.CompactTextString(m)
 func (*Request) ProtoMessage()               {}
 func (*Request) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
 
+type MinionCounter struct {
+	Pkg       string `protobuf:"bytes,1,opt,name=Pkg,json=pkg" json:"Pkg,omitempty"`
+	Name      string `protobuf:"bytes,2,opt,name=Name,json=name" json:"Name,omitempty"`
+	Value     uint64 `protobuf:"varint,3,opt,name=Value,json=value" json:"Value,omitempty"`
+	PrevValue uint64 `protobuf:"varint,4,opt,name=PrevValue,json=prevValue" json:"PrevValue,omitempty"`
+}
+
+func (m *MinionCounter) Reset()                    { *m = MinionCounter{} }
+func (m *MinionCounter) String() string            { return proto.CompactTextString(m) }
+func (*MinionCounter) ProtoMessage()               {}
+func (*MinionCounter) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+type MinionCountersReply struct {
+	Counters []*MinionCounter `protobuf:"bytes,1,rep,name=Counters,json=counters" json:"Counters,omitempty"`
+}
+
+func (m *MinionCountersReply) Reset()                    { *m = MinionCountersReply{} }
+func (m *MinionCountersReply) String() string            { return proto.CompactTextString(m) }
+func (*MinionCountersReply) ProtoMessage()               {}
+func (*MinionCountersReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *MinionCountersReply) GetCounters() []*MinionCounter {
+	if m != nil {
+		return m.Counters
+	}
+	return nil
+}
+
+type ProbeRequest struct {
+	FromIP   string `protobuf:"bytes,1,opt,name=FromIP,json=fromIP" json:"FromIP,omitempty"`
+	Hostname string `protobuf:"bytes,2,opt,name=Hostname,json=hostname" json:"Hostname,omitempty"`
+	IP       string `protobuf:"bytes,3,opt,name=IP,json=iP" json:"IP,omitempty"`
+	Port     int32  `protobuf:"varint,4,opt,name=Port,json=port" json:"Port,omitempty"`
+	Protocol string `protobuf:"bytes,5,opt,name=Protocol,json=protocol" json:"Protocol,omitempty"`
+}
+
+func (m *ProbeRequest) Reset()                    { *m = ProbeRequest{} }
+func (m *ProbeRequest) String() string            { return proto.CompactTextString(m) }
+func (*ProbeRequest) ProtoMessage()               {}
+func (*ProbeRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+type ProbeReply struct {
+	Hops string `protobuf:"bytes,1,opt,name=Hops,json=hops" json:"Hops,omitempty"`
+}
+
+func (m *ProbeReply) Reset()                    { *m = ProbeReply{} }
+func (m *ProbeReply) String() string            { return proto.CompactTextString(m) }
+func (*ProbeReply) ProtoMessage()               {}
+func (*ProbeReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
 func init() {
 	proto.RegisterType((*MinionConfig)(nil), "MinionConfig")
 	proto.RegisterType((*Reply)(nil), "Reply")
 	proto.RegisterType((*Request)(nil), "Request")
+	proto.RegisterType((*MinionCounter)(nil), "MinionCounter")
+	proto.RegisterType((*MinionCountersReply)(nil), "MinionCountersReply")
+	proto.RegisterType((*ProbeRequest)(nil), "ProbeRequest")
+	proto.RegisterType((*ProbeReply)(nil), "ProbeReply")
 	proto.RegisterEnum("MinionConfig_Role", MinionConfig_Role_name, MinionConfig_Role_value)
 }
 
@@ -112,6 +189,8 @@ const _ = grpc.SupportPackageIsVersion3
 type MinionClient interface {
 	SetMinionConfig(ctx context.Context, in *MinionConfig, opts ...grpc.CallOption) (*Reply, error)
 	GetMinionConfig(ctx context.Context, in *Request, opts ...grpc.CallOption) (*MinionConfig, error)
+	GetMinionCounters(ctx context.Context, in *Request, opts ...grpc.CallOption) (*MinionCountersReply, error)
+	Probe(ctx context.Context, in *ProbeRequest, opts ...grpc.CallOption) (*ProbeReply, error)
 }
 
 type minionClient struct {
@@ -140,11 +219,31 @@ func (c *minionClient) GetMinionConfig(ctx context.Context, in *Request, opts ..
 	return out, nil
 }
 
+func (c *minionClient) GetMinionCounters(ctx context.Context, in *Request, opts ...grpc.CallOption) (*MinionCountersReply, error) {
+	out := new(MinionCountersReply)
+	err := grpc.Invoke(ctx, "/Minion/GetMinionCounters", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *minionClient) Probe(ctx context.Context, in *ProbeRequest, opts ...grpc.CallOption) (*ProbeReply, error) {
+	out := new(ProbeReply)
+	err := grpc.Invoke(ctx, "/Minion/Probe", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Minion service
 
 type MinionServer interface {
 	SetMinionConfig(context.Context, *MinionConfig) (*Reply, error)
 	GetMinionConfig(context.Context, *Request) (*MinionConfig, error)
+	GetMinionCounters(context.Context, *Request) (*MinionCountersReply, error)
+	Probe(context.Context, *ProbeRequest) (*ProbeReply, error)
 }
 
 func RegisterMinionServer(s *grpc.Server, srv MinionServer) {
@@ -187,6 +286,42 @@ func _Minion_GetMinionConfig_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Minion_GetMinionCounters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MinionServer).GetMinionCounters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Minion/GetMinionCounters",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MinionServer).GetMinionCounters(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Minion_Probe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProbeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MinionServer).Probe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Minion/Probe",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MinionServer).Probe(ctx, req.(*ProbeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Minion_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "Minion",
 	HandlerType: (*MinionServer)(nil),
@@ -199,6 +334,14 @@ var _Minion_serviceDesc = grpc.ServiceDesc{
 			MethodName: "GetMinionConfig",
 			Handler:    _Minion_GetMinionConfig_Handler,
 		},
+		{
+			MethodName: "GetMinionCounters",
+			Handler:    _Minion_GetMinionCounters_Handler,
+		},
+		{
+			MethodName: "Probe",
+			Handler:    _Minion_Probe_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: fileDescriptor0,
@@ -207,26 +350,49 @@ var _Minion_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("minion/pb/pb.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 321 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x5c, 0x91, 0xcf, 0x6a, 0xea, 0x40,
-	0x14, 0xc6, 0x4d, 0x8c, 0x31, 0x39, 0xde, 0x1b, 0xe5, 0x2c, 0x2e, 0x83, 0xdc, 0x45, 0xc8, 0x42,
-	0x42, 0x29, 0x11, 0xec, 0x13, 0x48, 0x0d, 0x45, 0xc4, 0x3f, 0x8c, 0x85, 0xae, 0x8d, 0x9e, 0xda,
-	0x01, 0xcd, 0x4c, 0x27, 0x51, 0xd0, 0xe7, 0xea, 0x03, 0x16, 0x47, 0x4b, 0x9b, 0xee, 0xe6, 0xfc,
-	0xce, 0xef, 0x1b, 0x98, 0x6f, 0x00, 0xf7, 0x22, 0x17, 0x32, 0xef, 0xab, 0xac, 0xaf, 0xb2, 0x44,
-	0x69, 0x59, 0xca, 0xe8, 0xc3, 0x86, 0x3f, 0x53, 0x83, 0x1f, 0x65, 0xfe, 0x2a, 0xb6, 0x18, 0x80,
-	0x3d, 0x1e, 0x31, 0x2b, 0xb4, 0x62, 0x9f, 0xdb, 0x62, 0x84, 0x3d, 0x70, 0xb4, 0xdc, 0x11, 0xb3,
-	0x43, 0x2b, 0x0e, 0x06, 0x98, 0xfc, 0x94, 0x13, 0x2e, 0x77, 0xc4, 0xcd, 0x1e, 0xff, 0x83, 0xbf,
-	0xd0, 0xe2, 0xb8, 0x2a, 0x69, 0xbc, 0x60, 0x75, 0x13, 0xf7, 0xd5, 0x17, 0x40, 0x04, 0x67, 0xa9,
-	0x68, 0xcd, 0x1c, 0xb3, 0x70, 0x0a, 0x45, 0x6b, 0xec, 0x82, 0xb7, 0xd0, 0xf2, 0x28, 0x36, 0xa4,
-	0x59, 0xc3, 0x70, 0x4f, 0xdd, 0x66, 0xe3, 0x8b, 0x33, 0x31, 0xf7, 0xe6, 0x8b, 0x33, 0xe1, 0x3f,
-	0x70, 0x39, 0x6d, 0x85, 0xcc, 0x59, 0xd3, 0x50, 0x57, 0x9b, 0x09, 0x43, 0x68, 0xa5, 0xe5, 0x7a,
-	0x33, 0xa5, 0x7d, 0x46, 0xba, 0x60, 0x5e, 0x58, 0x8f, 0x7d, 0xde, 0xa2, 0x6f, 0x84, 0x3d, 0x08,
-	0x86, 0x87, 0xf2, 0x4d, 0x6a, 0x71, 0xa6, 0xcd, 0x84, 0x4e, 0x05, 0xf3, 0x8d, 0x14, 0xac, 0x2a,
-	0x34, 0x8a, 0xc1, 0xb9, 0xbc, 0x08, 0x3d, 0x70, 0x66, 0xf3, 0x59, 0xda, 0xa9, 0x21, 0x80, 0xfb,
-	0x32, 0xe7, 0x93, 0x94, 0x77, 0xac, 0xcb, 0x79, 0x3a, 0x5c, 0x3e, 0xa7, 0xbc, 0x63, 0x47, 0x4d,
-	0x68, 0x70, 0x52, 0xbb, 0x53, 0xe4, 0x43, 0x93, 0xd3, 0xfb, 0x81, 0x8a, 0x72, 0x90, 0x81, 0x7b,
-	0x2d, 0x07, 0xef, 0xa0, 0xbd, 0xa4, 0xb2, 0x52, 0xeb, 0xdf, 0x4a, 0x71, 0x5d, 0x37, 0xb9, 0xc6,
-	0x6b, 0x78, 0x0f, 0xed, 0xa7, 0x5f, 0xae, 0x97, 0xdc, 0xae, 0xec, 0x56, 0x53, 0x51, 0x2d, 0x73,
-	0xcd, 0xaf, 0x3d, 0x7c, 0x06, 0x00, 0x00, 0xff, 0xff, 0xe1, 0x0f, 0x6b, 0x6a, 0xcb, 0x01, 0x00,
-	0x00,
+	// 694 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x03, 0x6c, 0x54, 0xed, 0x6e, 0xea, 0x46,
+	0x10, 0xc5, 0x60, 0x3b, 0xf6, 0x10, 0x08, 0xd9, 0x44, 0xd5, 0x0a, 0x55, 0x95, 0x65, 0xb5, 0x91,
+	0x15, 0x55, 0x8e, 0x94, 0xaa, 0x0f, 0x40, 0x03, 0x69, 0x50, 0x14, 0x62, 0x2d, 0x69, 0xfb, 0xdb,
+	0x1f, 0x1b, 0x58, 0xc5, 0x78, 0xdd, 0xf5, 0x1a, 0x29, 0xf9, 0xd9, 0x87, 0xe8, 0xf3, 0xdc, 0x47,
+	0xbb, 0xda, 0xc5, 0x7c, 0xe5, 0xde, 0x7f, 0xcc, 0x39, 0x67, 0x99, 0x33, 0xc3, 0x19, 0x00, 0xad,
+	0x58, 0xc1, 0x78, 0x71, 0x53, 0x26, 0x37, 0x65, 0x12, 0x96, 0x82, 0x4b, 0xee, 0xff, 0x6f, 0xc1,
+	0xe9, 0x93, 0x86, 0xef, 0x78, 0xf1, 0xca, 0x16, 0xa8, 0x0f, 0xed, 0xe9, 0x18, 0x1b, 0x9e, 0x11,
+	0xb8, 0xa4, 0xcd, 0xc6, 0xe8, 0x0a, 0x4c, 0xc1, 0x73, 0x8a, 0xdb, 0x9e, 0x11, 0xf4, 0x6f, 0x51,
+	0x78, 0x28, 0x0e, 0x09, 0xcf, 0x29, 0xd1, 0x3c, 0xfa, 0x11, 0xdc, 0x48, 0xb0, 0x75, 0x2c, 0xe9,
+	0x34, 0xc2, 0x1d, 0xfd, 0xdc, 0x2d, 0xb7, 0x00, 0x42, 0x60, 0xce, 0x4b, 0x9a, 0x62, 0x53, 0x13,
+	0x66, 0x55, 0xd2, 0x14, 0x0d, 0xc1, 0x89, 0x04, 0x5f, 0xb3, 0x8c, 0x0a, 0x6c, 0x69, 0xdc, 0x29,
+	0x9b, 0x5a, 0xeb, 0xd9, 0x07, 0xc5, 0x76, 0xa3, 0x67, 0x1f, 0x14, 0xfd, 0x00, 0x36, 0xa1, 0x0b,
+	0xc6, 0x0b, 0x7c, 0xa2, 0x51, 0x5b, 0xe8, 0x0a, 0x79, 0xd0, 0x9d, 0xc8, 0x34, 0x7b, 0xa2, 0xab,
+	0x84, 0x8a, 0x0a, 0x3b, 0x5e, 0x27, 0x70, 0x49, 0x97, 0xee, 0x21, 0x74, 0x05, 0xfd, 0x51, 0x2d,
+	0x97, 0x5c, 0xb0, 0x0f, 0x9a, 0x3d, 0xd2, 0xf7, 0x0a, 0xbb, 0x5a, 0xd4, 0x8f, 0x8f, 0x50, 0xf5,
+	0x4d, 0x63, 0x9a, 0xb1, 0x34, 0x96, 0x34, 0x7b, 0xe1, 0x18, 0x74, 0x9b, 0x6e, 0xb6, 0x87, 0xd0,
+	0x4f, 0x00, 0xf7, 0x39, 0x8f, 0x25, 0x2b, 0x16, 0xd3, 0x08, 0x77, 0xb5, 0x00, 0x5e, 0x77, 0x88,
+	0x9a, 0x69, 0x2c, 0x62, 0x56, 0xb0, 0x62, 0x81, 0x4f, 0x3d, 0x23, 0x70, 0x88, 0x93, 0x35, 0xb5,
+	0x7a, 0x7b, 0xc7, 0x0b, 0x19, 0xb3, 0x42, 0xd9, 0xec, 0x79, 0x46, 0x60, 0x11, 0x48, 0x77, 0x88,
+	0xe2, 0xff, 0xe0, 0x5c, 0x6e, 0x16, 0x8c, 0xfb, 0x9e, 0x11, 0x74, 0x08, 0x24, 0x3b, 0x04, 0xfd,
+	0x0c, 0x3d, 0xc5, 0x4f, 0x57, 0xf1, 0x82, 0x46, 0x75, 0x9e, 0xe3, 0x33, 0x2d, 0xe9, 0x25, 0x87,
+	0x20, 0x0a, 0x01, 0x29, 0xd5, 0x3d, 0x13, 0x95, 0xdc, 0xb5, 0xc3, 0x03, 0x2d, 0x45, 0xc9, 0x37,
+	0x8c, 0xea, 0x3a, 0x66, 0x55, 0x9c, 0xe4, 0x74, 0x36, 0x7a, 0xc1, 0xe7, 0xda, 0x33, 0x64, 0x3b,
+	0x44, 0xed, 0xe4, 0xaf, 0xa2, 0x4a, 0x97, 0x34, 0xab, 0x73, 0x9a, 0x61, 0xa4, 0x6d, 0x77, 0xeb,
+	0x3d, 0x84, 0xae, 0x61, 0x30, 0xaf, 0x4b, 0x2a, 0xd6, 0xac, 0xe2, 0x62, 0x2e, 0x63, 0x59, 0x57,
+	0xf8, 0x42, 0x6f, 0x66, 0x50, 0x7d, 0xc2, 0xfd, 0x00, 0x4c, 0x95, 0x19, 0xe4, 0x80, 0x39, 0x7b,
+	0x9e, 0x4d, 0x06, 0x2d, 0x04, 0x60, 0xff, 0xf3, 0x4c, 0x1e, 0x27, 0x64, 0x60, 0xa8, 0xcf, 0x4f,
+	0xa3, 0xf9, 0xcb, 0x84, 0x0c, 0xda, 0xfe, 0x09, 0x58, 0x84, 0x96, 0xf9, 0xbb, 0xef, 0xc2, 0x09,
+	0xa1, 0xff, 0xd6, 0xb4, 0x92, 0x3e, 0x83, 0xde, 0x36, 0x7e, 0x75, 0x21, 0xa9, 0x40, 0x03, 0xe8,
+	0x44, 0x6f, 0x8b, 0x26, 0xad, 0x9d, 0xf2, 0x6d, 0xa1, 0x82, 0x33, 0x8b, 0x57, 0x9b, 0xb8, 0xba,
+	0xc4, 0x2c, 0xe2, 0x15, 0x45, 0x97, 0x60, 0xfd, 0x1d, 0xe7, 0x35, 0xd5, 0xb1, 0x34, 0x89, 0xb5,
+	0x56, 0xc5, 0x26, 0xb0, 0x74, 0xbd, 0x61, 0x4c, 0xcd, 0xb8, 0xe5, 0x16, 0xf0, 0x47, 0x70, 0x71,
+	0xd4, 0xaa, 0xd2, 0x66, 0xd0, 0x35, 0x38, 0x5b, 0x00, 0x1b, 0x5e, 0x27, 0xe8, 0xde, 0xf6, 0xc3,
+	0x23, 0x1d, 0x71, 0xd2, 0x86, 0xf7, 0xff, 0x33, 0xe0, 0x34, 0x12, 0x3c, 0xa1, 0x8d, 0x7d, 0x15,
+	0xe0, 0x7b, 0xc1, 0x57, 0xd3, 0xa8, 0x31, 0x6c, 0xbf, 0xea, 0x4a, 0x85, 0xe6, 0x81, 0x57, 0xb2,
+	0xd8, 0xfb, 0x76, 0x96, 0x4d, 0xad, 0xcf, 0x71, 0x7b, 0x4f, 0x6d, 0xa6, 0x0f, 0x29, 0xe2, 0x42,
+	0x6a, 0xc3, 0x16, 0x31, 0x4b, 0x2e, 0x64, 0x73, 0x48, 0x92, 0xa7, 0x3c, 0x3f, 0x38, 0x24, 0x5d,
+	0xfb, 0x1e, 0x40, 0xe3, 0x41, 0xd9, 0x47, 0x60, 0x3e, 0xf0, 0xb2, 0x6a, 0xfa, 0x9b, 0x4b, 0x5e,
+	0x56, 0xb7, 0x5f, 0x0c, 0xb0, 0x9b, 0x84, 0x5d, 0xc3, 0xd9, 0x9c, 0xca, 0xa3, 0xbf, 0x83, 0xde,
+	0xd1, 0xc1, 0x0f, 0xed, 0x70, 0xf3, 0xa3, 0xb4, 0xd0, 0xaf, 0x70, 0xf6, 0xe7, 0x27, 0xad, 0x13,
+	0x36, 0x93, 0x0e, 0x8f, 0x5f, 0xf9, 0x2d, 0xf4, 0x3b, 0x9c, 0x1f, 0xa8, 0x37, 0x0b, 0x3a, 0xd0,
+	0x5f, 0x86, 0xdf, 0x59, 0xb6, 0xdf, 0x42, 0xbf, 0x80, 0xa5, 0xdd, 0xa3, 0x5e, 0x78, 0xb8, 0xc9,
+	0x61, 0x37, 0xdc, 0x0f, 0xe5, 0xb7, 0x12, 0x5b, 0x8f, 0xfb, 0xdb, 0xd7, 0x01, 0x00, 0x81, 0x74,
+	0x65, 0xf1, 0xe1, 0x04, 0x00, 0x00,
 }
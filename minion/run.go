@@ -19,12 +19,14 @@ import (
 	log "github.com/Sirupsen/logrus"
 )
 
-// Run blocks executing the minion.
-func Run() {
+// Run blocks executing the minion.  The supervisor probes the system containers as
+// `probeConfig` specifies.
+func Run(probeConfig supervisor.ProbeConfig) {
 	// XXX Uncomment the following line to run the profiler
 	//runProfiler(5 * time.Minute)
 
 	log.Info("Minion Start")
+	start := time.Now()
 
 	conn := db.New()
 	dk := docker.New("unix:///var/run/docker.sock")
@@ -32,8 +34,8 @@ func Run() {
 	// Not in a goroutine, want the plugin to start before the scheduler
 	plugin.Run()
 
-	go minionServerRun(conn)
-	go supervisor.Run(conn, dk)
+	go minionServerRun(conn, dk, start)
+	go supervisor.Run(conn, dk, probeConfig)
 	go scheduler.Run(conn, dk)
 	go network.Run(conn, dk)
 	go etcd.Run(conn)
@@ -42,7 +44,9 @@ func Run() {
 	go apiServer.Run(conn, fmt.Sprintf("tcp://0.0.0.0:%d", api.DefaultRemotePort))
 
 	loopLog := util.NewEventTimer("Minion-Update")
-	for range conn.Trigger(db.MinionTable).C {
+	// The policy is also updated periodically, so that image rollouts deferred to
+	// the maintenance window begin once it opens.
+	for range conn.TriggerTick(60, db.MinionTable).C {
 		loopLog.LogStart()
 		conn.Txn(db.ConnectionTable, db.ContainerTable, db.MinionTable,
 			db.PlacementTable).Run(func(view db.Database) error {
@@ -2,11 +2,21 @@ package scheduler
 
 import (
 	"container/heap"
+	"fmt"
+	"sort"
+	"strings"
 
+	"github.com/NetSys/quilt/counter"
 	"github.com/NetSys/quilt/db"
 	log "github.com/Sirupsen/logrus"
 )
 
+var (
+	placeCounter        = counter.New("scheduler", "Place Container")
+	placeFailureCounter = counter.New("scheduler", "Place Failure")
+	unplaceCounter      = counter.New("scheduler", "Unplace Container")
+)
+
 type minion struct {
 	db.Minion
 	containers []*db.Container
@@ -53,6 +63,7 @@ func cleanupPlacements(ctx *context) {
 				valid = append(valid, dbc)
 				continue
 			}
+			unplaceCounter.Inc()
 			dbc.Minion = ""
 			ctx.unassigned = append(ctx.unassigned, dbc)
 			ctx.changed = append(ctx.changed, dbc)
@@ -67,19 +78,53 @@ func placeUnassigned(ctx *context) {
 
 Outer:
 	for _, dbc := range ctx.unassigned {
+		reasons := map[string]string{}
 		for i, m := range minions {
-			if validPlacement(ctx.constraints, *m, m.containers, dbc) {
+			reason := placementFailure(ctx.constraints, *m, m.containers, dbc)
+			if reason == "" {
+				placeCounter.Inc()
 				dbc.Minion = m.PrivateIP
+				dbc.PlacementFailure = ""
 				ctx.changed = append(ctx.changed, dbc)
 				m.containers = append(m.containers, dbc)
 				heap.Fix(&minions, i)
 				log.WithField("container", dbc).Info("Placed container.")
 				continue Outer
 			}
+			reasons[m.PrivateIP] = reason
+		}
+
+		placeFailureCounter.Inc()
+		log.WithFields(log.Fields{
+			"container": dbc,
+			"reasons":   reasons,
+		}).Warning("Failed to place container.")
+
+		if failure := summarizeFailures(reasons); dbc.PlacementFailure != failure {
+			dbc.PlacementFailure = failure
+			ctx.changed = append(ctx.changed, dbc)
 		}
+	}
+}
+
+// summarizeFailures describes why none of the minions could take a container, given
+// each minion's reason for refusing it.  Reasons shared by several minions are
+// listed once.
+func summarizeFailures(reasons map[string]string) string {
+	if len(reasons) == 0 {
+		return "no minions"
+	}
 
-		log.WithField("container", dbc).Warning("Failed to place container.")
+	var unique []string
+	seen := map[string]struct{}{}
+	for _, reason := range reasons {
+		if _, ok := seen[reason]; !ok {
+			seen[reason] = struct{}{}
+			unique = append(unique, reason)
+		}
 	}
+	sort.Strings(unique)
+	return strings.Join(unique, "; ")
 }
 
 // Compute the peer labels map if it is nil, otherwise just return it
@@ -135,19 +180,44 @@ func checkExclusionConstraint(constraint db.Placement, cLabels,
 
 func validPlacement(constraints []db.Placement, m minion, peers []*db.Container,
 	dbc *db.Container) bool {
+	return placementFailure(constraints, m, peers, dbc) == ""
+}
+
+// placementFailure explains why `dbc` can't be placed on `m` alongside `peers`, or
+// returns the empty string if it can.
+func placementFailure(constraints []db.Placement, m minion, peers []*db.Container,
+	dbc *db.Container) string {
 
 	cLabels := map[string]struct{}{}
 	for _, label := range dbc.Labels {
 		cLabels[label] = struct{}{}
 	}
 
+	// Draining minions are being emptied so that they may be terminated.
+	if m.Draining {
+		return "draining"
+	}
+
+	// Dedicated minions never run other containers, no matter how loaded the rest
+	// of the cluster is.
+	if m.DedicatedTo != "" {
+		if _, ok := cLabels[m.DedicatedTo]; !ok {
+			return "dedicated to " + m.DedicatedTo
+		}
+	}
+
+	if dbc.Arch != "" && dbc.Arch != m.Arch {
+		return fmt.Sprintf("architecture %s, not %s", m.Arch, dbc.Arch)
+	}
+
 	var peerLabels map[string]struct{}
 	for _, constraint := range constraints {
 		if constraint.OtherLabel != "" {
 			peerLabels = computePeerLabels(peerLabels, peers, dbc.ID)
 			ok := checkExclusionConstraint(constraint, cLabels, peerLabels)
 			if !ok {
-				return false
+				return fmt.Sprintf("%s and %s are exclusive",
+					constraint.TargetLabel, constraint.OtherLabel)
 			}
 		}
 
@@ -158,26 +228,36 @@ func validPlacement(constraints []db.Placement, m minion, peers []*db.Container,
 		if constraint.Provider != "" {
 			on := constraint.Provider == m.Provider
 			if constraint.Exclusive == on {
-				return false
+				return "provider " + m.Provider
 			}
 		}
 
 		if constraint.Region != "" {
 			on := constraint.Region == m.Region
 			if constraint.Exclusive == on {
-				return false
+				return "region " + m.Region
 			}
 		}
 
 		if constraint.Size != "" {
 			on := constraint.Size == m.Size
 			if constraint.Exclusive == on {
-				return false
+				return "size " + m.Size
+			}
+		}
+
+		if constraint.FloatingIP {
+			on := m.FloatingIP != ""
+			if constraint.Exclusive == on {
+				if on {
+					return "floating IP " + m.FloatingIP
+				}
+				return "no floating IP"
 			}
 		}
 	}
 
-	return true
+	return ""
 }
 
 func makeContext(minions []db.Minion, constraints []db.Placement,
@@ -266,9 +266,29 @@ func TestPlaceUnassigned(t *testing.T) {
 	containers[0].Minion = ""
 	ctx = makeContext(minions, placements, containers)
 	placeUnassigned(ctx)
+	assert.Len(t, ctx.changed, 1)
+	assert.Equal(t, "", ctx.changed[0].Minion)
+	assert.Equal(t, "region Region1; region Region2; region Region3",
+		ctx.changed[0].PlacementFailure)
+
+	// The container is only changed when the reason it can't be placed does.
+	ctx = makeContext(minions, placements, []db.Container{*ctx.changed[0]})
+	placeUnassigned(ctx)
 	assert.Nil(t, ctx.changed)
 }
 
+func TestSummarizeFailures(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "no minions", summarizeFailures(nil))
+	assert.Equal(t, "dedicated to db; region us-west-1",
+		summarizeFailures(map[string]string{
+			"1": "region us-west-1",
+			"2": "dedicated to db",
+			"3": "region us-west-1",
+		}))
+}
+
 func TestMakeContext(t *testing.T) {
 	t.Parallel()
 
@@ -615,6 +635,105 @@ func TestValidPlacementMachine(t *testing.T) {
 	assert.False(t, res)
 }
 
+func TestValidPlacementArch(t *testing.T) {
+	t.Parallel()
+
+	m := minion{}
+	m.PrivateIP = "1.2.3.4"
+	m.Arch = "arm64"
+
+	assert.True(t, validPlacement(nil, m, m.containers, &db.Container{}))
+	assert.True(t, validPlacement(nil, m, m.containers,
+		&db.Container{Arch: "arm64"}))
+	assert.False(t, validPlacement(nil, m, m.containers,
+		&db.Container{Arch: "amd64"}))
+
+	// Minions that haven't reported their architecture only run containers
+	// that don't care.
+	m.Arch = ""
+	assert.False(t, validPlacement(nil, m, m.containers,
+		&db.Container{Arch: "arm64"}))
+}
+
+func TestValidPlacementFloatingIP(t *testing.T) {
+	t.Parallel()
+
+	floating := minion{}
+	floating.FloatingIP = "8.8.8.8"
+	plain := minion{}
+
+	web := &db.Container{ID: 1, Labels: []string{"web"}}
+	onFloating := []db.Placement{{TargetLabel: "web", FloatingIP: true}}
+	assert.Equal(t, "", placementFailure(onFloating, floating, nil, web))
+	assert.Equal(t, "no floating IP", placementFailure(onFloating, plain, nil, web))
+
+	offFloating := []db.Placement{
+		{TargetLabel: "web", FloatingIP: true, Exclusive: true}}
+	assert.Equal(t, "floating IP 8.8.8.8",
+		placementFailure(offFloating, floating, nil, web))
+	assert.Equal(t, "", placementFailure(offFloating, plain, nil, web))
+
+	// Other labels don't care.
+	other := &db.Container{ID: 2, Labels: []string{"db"}}
+	assert.Equal(t, "", placementFailure(onFloating, plain, nil, other))
+}
+
+func TestDedicatedMinion(t *testing.T) {
+	t.Parallel()
+
+	m := minion{}
+	m.DedicatedTo = "database"
+
+	db1 := &db.Container{ID: 1, Labels: []string{"database", "replica"}}
+	web := &db.Container{ID: 2, Labels: []string{"web"}}
+	assert.Equal(t, "", placementFailure(nil, m, nil, db1))
+	assert.Equal(t, "dedicated to database", placementFailure(nil, m, nil, web))
+
+	// Untolerated containers are left unplaced rather than land on a dedicated
+	// minion, even if it's the only one.
+	minions := []db.Minion{{PrivateIP: "1", Role: db.Worker, DedicatedTo: "database"}}
+	ctx := makeContext(minions, nil, []db.Container{*db1, *web})
+	placeUnassigned(ctx)
+	assert.Len(t, ctx.changed, 2)
+	assert.Equal(t, 1, ctx.changed[0].ID)
+	assert.Equal(t, "1", ctx.changed[0].Minion)
+	assert.Equal(t, 2, ctx.changed[1].ID)
+	assert.Equal(t, "dedicated to database", ctx.changed[1].PlacementFailure)
+
+	// Containers already on a minion that becomes dedicated are moved off.
+	placed := []db.Container{{ID: 2, Labels: []string{"web"}, Minion: "1"}}
+	ctx = makeContext(minions, nil, placed)
+	cleanupPlacements(ctx)
+	assert.Len(t, ctx.changed, 1)
+	assert.Equal(t, "", ctx.changed[0].Minion)
+}
+
+func TestDrainingMinion(t *testing.T) {
+	t.Parallel()
+
+	minions := []db.Minion{
+		{PrivateIP: "1", Role: db.Worker, Draining: true},
+		{PrivateIP: "2", Role: db.Worker, Region: "us-west-1"},
+	}
+	web := db.Container{ID: 1, Labels: []string{"web"}, Minion: "1"}
+
+	// Containers are moved off of draining minions, onto the others.
+	ctx := makeContext(minions, nil, []db.Container{web})
+	cleanupPlacements(ctx)
+	placeUnassigned(ctx)
+	assert.Equal(t, "2", ctx.changed[0].Minion)
+
+	// Placement constraints still hold, even if that leaves containers unplaced.
+	constraints := []db.Placement{
+		{TargetLabel: "web", Region: "us-west-1", Exclusive: true}}
+	ctx = makeContext(minions, constraints, []db.Container{web})
+	cleanupPlacements(ctx)
+	placeUnassigned(ctx)
+	assert.Equal(t, "", ctx.changed[0].Minion)
+	assert.Equal(t, "draining; region us-west-1",
+		ctx.changed[0].PlacementFailure)
+}
+
 func (m minion) String() string {
 	return spew.Sprintf("(%s Containers: %s)", m.Minion, m.containers)
 }
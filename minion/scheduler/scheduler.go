@@ -9,6 +9,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/NetSys/quilt/counter"
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/minion/docker"
 	"github.com/NetSys/quilt/minion/network/plugin"
@@ -16,6 +17,16 @@ import (
 	log "github.com/Sirupsen/logrus"
 )
 
+// The longest the worker waits between attempts to reach its Docker daemon while
+// it's unavailable.
+const maxDockerBackoff = 30 * time.Second
+
+var (
+	loopCounter   = counter.New("scheduler", "Loop")
+	workerCounter = counter.New("scheduler", "Run Worker")
+	masterCounter = counter.New("scheduler", "Run Master")
+)
+
 // Run blocks implementing the scheduler module.
 func Run(conn db.Conn, dk docker.Client) {
 	bootWait(conn)
@@ -31,6 +42,7 @@ func Run(conn db.Conn, dk docker.Client) {
 		db.PlacementTable, db.EtcdTable).C
 	for range trig {
 		loopLog.LogStart()
+		loopCounter.Inc()
 		minion, err := conn.MinionSelf()
 		if err != nil {
 			log.WithError(err).Warn("Missing self in the minion table.")
@@ -38,9 +50,23 @@ func Run(conn db.Conn, dk docker.Client) {
 		}
 
 		if minion.Role == db.Worker {
+			workerCounter.Inc()
 			subnet = updateNetwork(conn, dk, subnet)
 			runWorker(conn, dk, minion.PrivateIP, subnet)
+
+			// Rather than wait for the next trigger, retry with an
+			// exponential backoff while the Docker daemon is unavailable,
+			// so that the containers are synced as soon as it's back.
+			backoff := time.Second
+			for !dk.UnavailableSince().IsZero() {
+				time.Sleep(backoff)
+				runWorker(conn, dk, minion.PrivateIP, subnet)
+				if backoff *= 2; backoff > maxDockerBackoff {
+					backoff = maxDockerBackoff
+				}
+			}
 		} else if minion.Role == db.Master {
+			masterCounter.Inc()
 			runMaster(conn)
 		}
 		loopLog.LogEnd()
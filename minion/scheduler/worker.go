@@ -1,22 +1,61 @@
 package scheduler
 
 import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
 	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/NetSys/quilt/counter"
 	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/faults"
 	"github.com/NetSys/quilt/join"
 	"github.com/NetSys/quilt/minion/docker"
+	"github.com/NetSys/quilt/minion/ipdef"
 	"github.com/NetSys/quilt/minion/network/plugin"
-	"github.com/NetSys/quilt/util"
+	"github.com/NetSys/quilt/stitch"
 	log "github.com/Sirupsen/logrus"
 )
 
 const labelKey = "quilt"
 const labelValue = "scheduler"
 const labelPair = labelKey + "=" + labelValue
+
+// configKey labels containers with a hash of the db.Container.ConfigKey they were
+// booted from, so that we notice when any part of their configuration changes.
+const configKey = "quilt-config"
+
+// Labels that tell tooling on the worker, such as cAdvisor, which Quilt container a
+// Docker container runs.  The scheduler also uses the stitch ID to adopt containers
+// after the minion restarts.  Containers booted before these labels existed lack
+// them, and keep running without them, as Docker can't relabel a container.
+const (
+	quiltLabelKey = "quilt.label"
+	stitchIDKey   = "quilt.stitchID"
+	namespaceKey  = "quilt.namespace"
+	networkKey    = "quilt.network"
+)
+
 const concurrencyLimit = 32
 
+// How long the worker waits, after the Docker daemon becomes available again, before
+// listing the containers it acts on.  A restarting daemon may answer before it has
+// restored all of its containers, and any it hasn't would look like they're gone.
+var dockerSettleTime = 5 * time.Second
+
+var (
+	bootCounter        = counter.New("scheduler", "Boot Container")
+	bootFailureCounter = counter.New("scheduler", "Boot Failure")
+	killCounter        = counter.New("scheduler", "Kill Container")
+	killFailureCounter = counter.New("scheduler", "Kill Failure")
+)
+
 func runWorker(conn db.Conn, dk docker.Client, myIP string, subnet net.IPNet) {
 	if myIP == "" {
 		return
@@ -24,49 +63,165 @@ func runWorker(conn db.Conn, dk docker.Client, myIP string, subnet net.IPNet) {
 
 	filter := map[string][]string{"label": {labelPair}}
 
+	if since := dk.UnavailableSince(); !since.IsZero() {
+		if _, err := dk.List(filter); err != nil {
+			log.WithError(err).Debug("Docker is still unavailable.")
+			setDockerUnavailable(conn, dk.UnavailableSince())
+			return
+		}
+
+		log.WithField("since", since).Info("Docker is available again. " +
+			"Waiting for it to settle before syncing containers.")
+		time.Sleep(dockerSettleTime)
+		startExited(dk, filter)
+	}
+
+	var namespace string
 	var toBoot, toKill []interface{}
 	for i := 0; i < 2; i++ {
 		dkcs, err := dk.List(filter)
 		if err != nil {
 			log.WithError(err).Warning("Failed to list docker containers.")
+			if docker.IsUnavailable(err) {
+				setDockerUnavailable(conn, dk.UnavailableSince())
+			}
 			return
 		}
 
 		conn.Txn(db.ContainerTable,
 			db.MinionTable).Run(func(view db.Database) error {
 
-			_, err := view.MinionSelf()
+			self, err := view.MinionSelf()
 			if err != nil {
 				return nil
 			}
+			spec := minionSpec(self)
+			namespace = spec.Namespace
+			networks := networkSubnets(spec.Networks, subnet)
 
 			dbcs := view.SelectFromContainer(func(dbc db.Container) bool {
 				return dbc.Minion == myIP
 			})
 
-			dkcs, badDcks := filterOnSubnet(subnet, dkcs)
+			dkcs, badDcks := filterOnSubnet(subnet, networks, dkcs)
+			if len(dkcs) > 0 && self.BootTimings.FirstContainer == 0 &&
+				!self.Started.IsZero() {
+				self.BootTimings.FirstContainer = time.Since(self.Started)
+				view.Commit(self)
+			}
+
+			if !self.DockerUnavailableSince.IsZero() {
+				self.DockerUnavailableSince = time.Time{}
+				view.Commit(self)
+			}
 
 			var changed []db.Container
 			changed, toBoot, toKill = syncWorker(dbcs, dkcs, subnet)
 			for _, dbc := range changed {
 				view.Commit(dbc)
 			}
+			toBoot = assignNetworkIPs(toBoot, dkcs, networks)
 
 			toKill = append(toKill, badDcks...)
+
+			// The containers being killed are reported until they're gone,
+			// so that the leader can list them.
+			orphans := orphanContainers(myIP, toKill)
+			if !reflect.DeepEqual(orphans, self.Orphans) {
+				self.Orphans = orphans
+				view.Commit(self)
+			}
 			return nil
 		})
 
-		doContainers(dk, toBoot, dockerRun)
+		doContainers(dk, toBoot, dockerRun(namespace))
 		doContainers(dk, toKill, dockerKill)
 	}
 }
 
-func filterOnSubnet(subnet net.IPNet, dkcs []docker.Container) (good []docker.Container,
-	bad []interface{}) {
+// startExited starts the Quilt containers that exited while the Docker daemon was
+// unavailable, as a daemon without live restore stops its containers when it
+// restarts.  Starting them keeps their local state, which re-creating them wouldn't.
+func startExited(dk docker.Client, filter map[string][]string) {
+	dkcs, err := dk.ListAll(filter)
+	if err != nil {
+		log.WithError(err).Warning("Failed to list exited docker containers.")
+		return
+	}
+
+	for _, dkc := range dkcs {
+		if dkc.Status != "exited" {
+			continue
+		}
+
+		if err := dk.Start(dkc.ID); err != nil {
+			log.WithError(err).WithField("container", dkc.ID).Warning(
+				"Failed to start exited container.")
+		}
+	}
+}
+
+// setDockerUnavailable records on the minion when its Docker daemon became
+// unavailable.
+func setDockerUnavailable(conn db.Conn, since time.Time) {
+	conn.Txn(db.MinionTable).Run(func(view db.Database) error {
+		self, err := view.MinionSelf()
+		if err == nil && !self.DockerUnavailableSince.Equal(since) {
+			self.DockerUnavailableSince = since
+			view.Commit(self)
+		}
+		return nil
+	})
+}
+
+// minionSpec returns the minion's spec, or an empty one if it hasn't received one.
+func minionSpec(self db.Minion) stitch.Stitch {
+	if self.Spec == "" {
+		return stitch.Stitch{}
+	}
+
+	spec, err := stitch.FromJSON(self.Spec)
+	if err != nil {
+		log.WithError(err).Warn("Failed to parse spec.")
+		return stitch.Stitch{}
+	}
+	return spec
+}
+
+// networkSubnets returns the part of each isolated network's subnet from which the
+// minion with `subnet` assigns IPs, keyed by network name.
+func networkSubnets(networks []string, subnet net.IPNet) map[string]net.IPNet {
+	subnets := map[string]net.IPNet{}
+	for i, network := range networks {
+		if i >= ipdef.MaxNetworkCount {
+			log.Warnf("Only the first %d networks are given subnets.",
+				ipdef.MaxNetworkCount)
+			break
+		}
+		subnets[network] = ipdef.MinionNetworkSubnet(ipdef.NetworkSubnet(i),
+			subnet)
+	}
+	return subnets
+}
+
+// filterOnSubnet separates the containers with an IP the minion may assign from those
+// without.  Containers in an isolated network must have an IP in the minion's part of
+// that network's subnet, and all others an IP in the minion's subnet.
+func filterOnSubnet(subnet net.IPNet, networks map[string]net.IPNet,
+	dkcs []docker.Container) (good []docker.Container, bad []interface{}) {
 
 	for _, dkc := range dkcs {
+		want := subnet
+		if network := dkc.Labels[networkKey]; network != "" {
+			// Networks that are no longer in the spec have no subnet, so
+			// nothing is in it.
+			want = networks[network]
+		}
+
+		// Containers outside the overlay never have an IP in the subnet.
 		dkIP := net.ParseIP(dkc.IP)
-		if subnet.Contains(dkIP) {
+		if want.Contains(dkIP) || dkc.NetworkMode == stitch.NetworkModeHost ||
+			dkc.NetworkMode == stitch.NetworkModeNone {
 			good = append(good, dkc)
 		} else {
 			bad = append(bad, dkc)
@@ -76,6 +231,87 @@ func filterOnSubnet(subnet net.IPNet, dkcs []docker.Container) (good []docker.Co
 	return good, bad
 }
 
+// assignNetworkIPs picks an IP for each container in `toBoot` that's in an isolated
+// network, from the minion's part of the network's subnet.  Docker only assigns IPs
+// from the minion's own subnet, so these are chosen here, avoiding those of the
+// running containers in `dkcs`.  Containers that can't be given one aren't booted.
+func assignNetworkIPs(toBoot []interface{}, dkcs []docker.Container,
+	networks map[string]net.IPNet) []interface{} {
+
+	used := map[string]struct{}{}
+	for _, dkc := range dkcs {
+		used[dkc.IP] = struct{}{}
+	}
+
+	var assigned []interface{}
+	for _, i := range toBoot {
+		dbc := i.(db.Container)
+		if dbc.Network == "" {
+			assigned = append(assigned, dbc)
+			continue
+		}
+
+		dbc.IP = ""
+		if subnet, ok := networks[dbc.Network]; ok {
+			dbc.IP = freeIP(subnet, used)
+		}
+		if dbc.IP == "" {
+			log.WithField("container", dbc).Warnf("No IPs left for the "+
+				"container in network %s.", dbc.Network)
+			continue
+		}
+
+		used[dbc.IP] = struct{}{}
+		assigned = append(assigned, dbc)
+	}
+	return assigned
+}
+
+// freeIP returns the first IP in `subnet` that isn't `used`, or the empty string if
+// there's none.
+func freeIP(subnet net.IPNet, used map[string]struct{}) string {
+	start := binary.BigEndian.Uint32(subnet.IP.To4())
+	ones, bits := subnet.Mask.Size()
+	for i := uint32(0); i < 1<<uint(bits-ones); i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, start+i)
+		if _, ok := used[ip.String()]; !ok {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// orphanContainers describes the Docker containers in `dkcs`, which the worker is
+// tearing down because none of the containers it's assigned match them.
+func orphanContainers(myIP string, dkcs []interface{}) []db.Container {
+	var orphans []db.Container
+	for _, i := range dkcs {
+		dkc := i.(docker.Container)
+
+		var labels []string
+		if joined := dkc.Labels[quiltLabelKey]; joined != "" {
+			labels = strings.Split(joined, ",")
+		}
+
+		// Containers booted before the stitch ID label existed are listed
+		// without one.
+		stitchID, _ := strconv.Atoi(dkc.Labels[stitchIDKey])
+		orphans = append(orphans, db.Container{
+			StitchID:     stitchID,
+			Minion:       myIP,
+			DockerID:     dkc.ID,
+			IP:           dkc.IP,
+			Image:        dkc.Image,
+			Command:      dkc.Args,
+			Labels:       labels,
+			DesiredState: db.DesiredRemoved,
+			ActualState:  dkc.Status,
+		})
+	}
+	return orphans
+}
+
 func syncWorker(dbcs []db.Container, dkcs []docker.Container, subnet net.IPNet) (
 	changed []db.Container, toBoot, toKill []interface{}) {
 
@@ -89,12 +325,13 @@ func syncWorker(dbcs []db.Container, dkcs []docker.Container, subnet net.IPNet)
 		dbc := pair.L.(db.Container)
 		dkc := pair.R.(docker.Container)
 
-		if dbc.DockerID != dkc.ID {
+		if dbc.DockerID != dkc.ID || dbc.ActualState != dkc.Status {
 			dbc.DockerID = dkc.ID
 			dbc.Pid = dkc.Pid
 			dbc.IP = dkc.IP
 			dbc.Mac = dkc.Mac
 			dbc.EndpointID = dkc.EID
+			dbc.ActualState = dkc.Status
 			changed = append(changed, dbc)
 		}
 	}
@@ -102,6 +339,12 @@ func syncWorker(dbcs []db.Container, dkcs []docker.Container, subnet net.IPNet)
 	for _, i := range dbci {
 		dbc := i.(db.Container)
 		toBoot = append(toBoot, dbc)
+
+		// The container isn't running, so whatever status it had is stale.
+		if dbc.ActualState != "" {
+			dbc.ActualState = ""
+			changed = append(changed, dbc)
+		}
 	}
 
 	return changed, toBoot, toKill
@@ -127,32 +370,110 @@ func doContainers(dk docker.Client, containers []interface{},
 	wg.Wait()
 }
 
-func dockerRun(dk docker.Client, in chan interface{}) {
-	for i := range in {
-		dbc := i.(db.Container)
-		log.WithField("container", dbc).Info("Start container")
-		_, err := dk.Run(docker.RunOptions{
-			Image:       dbc.Image,
-			Args:        dbc.Command,
-			Env:         dbc.Env,
-			Labels:      map[string]string{labelKey: labelValue},
-			NetworkMode: plugin.NetworkName,
-		})
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error":     err,
-				"container": dbc,
-			}).WithError(err).Warning("Failed to run container", dbc)
-			continue
+// dockerRun returns a function that boots the containers it receives, labeling them
+// with `namespace`.
+func dockerRun(namespace string) func(docker.Client, chan interface{}) {
+	return func(dk docker.Client, in chan interface{}) {
+		for i := range in {
+			dbc := i.(db.Container)
+			log.WithField("container", dbc).Info("Start container")
+			bootCounter.Inc()
+
+			if delay := faults.DockerStartDelay(); delay > 0 {
+				log.WithField("container", dbc).Warnf("Delaying "+
+					"container start by %s due to an injected fault.",
+					delay)
+				time.Sleep(delay)
+			}
+
+			_, err := dk.Run(docker.RunOptions{
+				Image:       dbc.Image,
+				Args:        dbc.Command,
+				Env:         runEnv(dbc),
+				ShmSize:     dbc.ShmSize,
+				StopSignal:  dbc.StopSignal,
+				Labels:      dockerLabels(dbc, namespace),
+				NetworkMode: networkMode(dbc),
+				IP:          networkIP(dbc),
+
+				FilepathToContent: dbc.FilepathToContent,
+			})
+			if err != nil {
+				bootFailureCounter.Inc()
+				log.WithFields(log.Fields{
+					"error":     err,
+					"container": dbc,
+				}).WithError(err).Warning("Failed to run container", dbc)
+				continue
+			}
 		}
 	}
 }
 
+// dockerLabels returns the Docker labels `dbc` should be booted with.  A container
+// in several Quilt labels lists them all, separated by commas.
+func dockerLabels(dbc db.Container, namespace string) map[string]string {
+	quiltLabels := append([]string{}, dbc.Labels...)
+	sort.Strings(quiltLabels)
+
+	labels := map[string]string{
+		labelKey:      labelValue,
+		configKey:     configHash(dbc),
+		quiltLabelKey: strings.Join(quiltLabels, ","),
+		stitchIDKey:   strconv.Itoa(dbc.StitchID),
+	}
+	if namespace != "" {
+		labels[namespaceKey] = namespace
+	}
+	if dbc.Network != "" {
+		labels[networkKey] = dbc.Network
+	}
+	return labels
+}
+
+// networkMode returns the Docker network `dbc` should be booted in.
+func networkMode(dbc db.Container) string {
+	if dbc.NetworkMode == "" {
+		return plugin.NetworkName
+	}
+	return dbc.NetworkMode
+}
+
+// networkIP returns the IP `dbc` should be booted with, if it's in an isolated
+// network.  assignNetworkIPs chose it.  The IPs of other containers are Docker's
+// choice.
+func networkIP(dbc db.Container) string {
+	if dbc.Network == "" {
+		return ""
+	}
+	return dbc.IP
+}
+
+// runEnv returns the environment `dbc` should be started with: its own, plus its
+// label's size if it's exposed.  The container's own environment takes precedence.
+func runEnv(dbc db.Container) map[string]string {
+	labelEnv := dbc.LabelEnv()
+	if len(labelEnv) == 0 {
+		return dbc.Env
+	}
+
+	env := map[string]string{}
+	for k, v := range labelEnv {
+		env[k] = v
+	}
+	for k, v := range dbc.Env {
+		env[k] = v
+	}
+	return env
+}
+
 func dockerKill(dk docker.Client, in chan interface{}) {
 	for i := range in {
 		dkc := i.(docker.Container)
 		log.WithField("container", dkc.ID).Info("Remove container")
+		killCounter.Inc()
 		if err := dk.RemoveID(dkc.ID); err != nil {
+			killFailureCounter.Inc()
 			log.WithFields(log.Fields{
 				"error": err,
 				"id":    dkc.ID,
@@ -165,29 +486,29 @@ func syncJoinScore(left, right interface{}) int {
 	dbc := left.(db.Container)
 	dkc := right.(docker.Container)
 
-	// Depending on the container, the command in the database could be
-	// either The command plus it's arguments, or just it's arguments.  To
-	// handle that case, we check both.
-	cmd1 := dkc.Args
-	cmd2 := append([]string{dkc.Path}, dkc.Args...)
-	dbcCmd := dbc.Command
-
-	for key, value := range dbc.Env {
-		if dkc.Env[key] != value {
-			return -1
-		}
-	}
-
+	// Docker reports the image's environment and entrypoint mixed in with our own,
+	// so rather than compare them, we compare the configuration the container was
+	// booted with.
 	switch {
 	case dbc.Image != dkc.Image:
 		return -1
-	case len(dbcCmd) != 0 &&
-		!util.StrSliceEqual(dbcCmd, cmd1) &&
-		!util.StrSliceEqual(dbcCmd, cmd2):
+	case dkc.Labels[configKey] != configHash(dbc):
 		return -1
 	case dbc.DockerID == dkc.ID:
 		return 0
-	default:
+	case dkc.Labels[stitchIDKey] == strconv.Itoa(dbc.StitchID):
 		return 1
+	case dkc.Labels[stitchIDKey] == "":
+		// Containers booted before the stitch ID label existed are adopted
+		// rather than restarted, though labeled containers are preferred.
+		return 2
+	default:
+		return 3
 	}
 }
+
+// configHash returns a hash of `dbc`'s ConfigKey that's short enough to use as a
+// Docker label.
+func configHash(dbc db.Container) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(dbc.ConfigKey())))
+}
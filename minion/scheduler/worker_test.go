@@ -2,7 +2,9 @@ package scheduler
 
 import (
 	"net"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/minion/docker"
@@ -46,11 +48,192 @@ func TestRunWorker(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, dkcs, 0)
 
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		self, _ := view.MinionSelf()
+		self.Spec = `{"Namespace": "ns"}`
+		view.Commit(self)
+		return nil
+	})
 	runWorker(conn, dk, "1.2.3.4", *subnet)
 	dkcs, err = dk.List(nil)
 	assert.NoError(t, err)
 	assert.Len(t, dkcs, 1)
 	assert.Equal(t, "Image", dkcs[0].Image)
+	assert.Equal(t, "ns", dkcs[0].Labels[namespaceKey])
+}
+
+func TestRunWorkerNetworkMode(t *testing.T) {
+	t.Parallel()
+
+	_, dk := docker.NewMock()
+	conn := db.New()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		for _, mode := range []string{"", "host"} {
+			container := view.InsertContainer()
+			container.Image = "Image" + mode
+			container.Minion = "1.2.3.4"
+			container.NetworkMode = mode
+			view.Commit(container)
+		}
+
+		m := view.InsertMinion()
+		m.Self = true
+		m.PrivateIP = "1.2.3.4"
+		view.Commit(m)
+		return nil
+	})
+
+	// Host networked containers have no IP in the subnet, but mustn't be killed
+	// for it.
+	for i := 0; i < 2; i++ {
+		runWorker(conn, dk, "1.2.3.4", *subnet)
+	}
+
+	dkcs, err := dk.List(nil)
+	assert.NoError(t, err)
+	assert.Len(t, dkcs, 2)
+
+	modes := map[string]string{}
+	for _, dkc := range dkcs {
+		modes[dkc.Image] = dkc.NetworkMode
+	}
+	assert.Equal(t, map[string]string{
+		"Image":     "quilt",
+		"Imagehost": "host",
+	}, modes)
+}
+
+func TestRunWorkerFirstContainer(t *testing.T) {
+	t.Parallel()
+
+	_, dk := docker.NewMock()
+	conn := db.New()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.InsertMinion()
+		m.Self = true
+		m.PrivateIP = "1.2.3.4"
+		m.Started = time.Now().Add(-time.Minute)
+		view.Commit(m)
+		return nil
+	})
+
+	firstContainer := func() time.Duration {
+		self, err := conn.MinionSelf()
+		assert.NoError(t, err)
+		return self.BootTimings.FirstContainer
+	}
+
+	runWorker(conn, dk, "1.2.3.4", *subnet)
+	assert.Zero(t, firstContainer())
+
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		container := view.InsertContainer()
+		container.Image = "Image"
+		container.Minion = "1.2.3.4"
+		container.NetworkMode = "host"
+		view.Commit(container)
+		return nil
+	})
+	runWorker(conn, dk, "1.2.3.4", *subnet)
+	first := firstContainer()
+	assert.True(t, first >= time.Minute)
+
+	// Only the first container is timed.
+	runWorker(conn, dk, "1.2.3.4", *subnet)
+	assert.Equal(t, first, firstContainer())
+}
+
+func TestFilterOnSubnet(t *testing.T) {
+	t.Parallel()
+
+	_, dbNet, _ := net.ParseCIDR("10.128.0.32/27")
+	networks := map[string]net.IPNet{"db": *dbNet}
+	inDB := map[string]string{networkKey: "db"}
+	inGone := map[string]string{networkKey: "gone"}
+
+	dkcs := []docker.Container{
+		{ID: "overlay", IP: "5.6.7.9"},
+		{ID: "stray", IP: "10.1.1.1"},
+		{ID: "unaddressed"},
+		{ID: "host", NetworkMode: "host"},
+		{ID: "none", NetworkMode: "none"},
+		{ID: "network", IP: "10.128.0.33", Labels: inDB},
+		{ID: "wrongNetwork", IP: "5.6.7.10", Labels: inDB},
+		{ID: "goneNetwork", IP: "10.128.0.34", Labels: inGone},
+		{ID: "unlabeled", IP: "10.128.0.35"},
+	}
+
+	good, bad := filterOnSubnet(*subnet, networks, dkcs)
+	assert.Equal(t, []docker.Container{dkcs[0], dkcs[3], dkcs[4], dkcs[5]}, good)
+	assert.Equal(t, []interface{}{dkcs[1], dkcs[2], dkcs[6], dkcs[7], dkcs[8]},
+		bad)
+}
+
+func TestRunWorkerNetwork(t *testing.T) {
+	t.Parallel()
+
+	_, dk := docker.NewMock()
+	conn := db.New()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		for _, network := range []string{"", "db", "db", "cache"} {
+			container := view.InsertContainer()
+			container.Image = "Image" + network
+			container.Minion = "1.2.3.4"
+			container.Network = network
+			container.IP = "1.1.1.1"
+			view.Commit(container)
+		}
+
+		m := view.InsertMinion()
+		m.Self = true
+		m.PrivateIP = "1.2.3.4"
+		m.Spec = `{"Networks": ["cache", "db"]}`
+		view.Commit(m)
+		return nil
+	})
+
+	_, minionSubnet, _ := net.ParseCIDR("10.0.32.0/20")
+	for i := 0; i < 2; i++ {
+		runWorker(conn, dk, "1.2.3.4", *minionSubnet)
+	}
+
+	dkcs, err := dk.List(nil)
+	assert.NoError(t, err)
+	assert.Len(t, dkcs, 4)
+
+	// The default network's container is left to Docker, and so has no IP in the
+	// mock.  The others are given IPs from the minion's part of their network.
+	ips := map[string][]string{}
+	for _, dkc := range dkcs {
+		ips[dkc.Labels[networkKey]] = append(ips[dkc.Labels[networkKey]],
+			dkc.IP)
+	}
+	sort.Strings(ips["db"])
+	assert.Equal(t, map[string][]string{
+		"":      {""},
+		"cache": {"10.128.0.64"},
+		"db":    {"10.129.0.64", "10.129.0.65"},
+	}, ips)
+
+	dbcs := conn.SelectFromContainer(func(dbc db.Container) bool {
+		return dbc.Network == "cache"
+	})
+	assert.Len(t, dbcs, 1)
+	assert.Equal(t, "10.128.0.64", dbcs[0].IP)
+}
+
+func TestFreeIP(t *testing.T) {
+	t.Parallel()
+
+	_, slice, _ := net.ParseCIDR("10.128.0.32/30")
+	used := map[string]struct{}{"10.128.0.32": {}, "10.128.0.34": {}}
+	assert.Equal(t, "10.128.0.33", freeIP(*slice, used))
+
+	used["10.128.0.33"] = struct{}{}
+	assert.Equal(t, "10.128.0.35", freeIP(*slice, used))
+
+	used["10.128.0.35"] = struct{}{}
+	assert.Equal(t, "", freeIP(*slice, used))
 }
 
 func runSync(dk docker.Client, dbcs []db.Container,
@@ -58,7 +241,7 @@ func runSync(dk docker.Client, dbcs []db.Container,
 
 	changes, tdbcs, tdkcs := syncWorker(dbcs, dkcs, subnet)
 	doContainers(dk, tdkcs, dockerKill)
-	doContainers(dk, tdbcs, dockerRun)
+	doContainers(dk, tdbcs, dockerRun(""))
 	return changes
 }
 
@@ -90,20 +273,23 @@ func TestSyncWorker(t *testing.T) {
 	}
 
 	dbcs[0].DockerID = dkcs[0].ID
+	dbcs[0].ActualState = "running"
 	assert.Equal(t, dbcs, changed)
 
 	dkcsDB := []db.Container{
 		{
-			ID:       1,
-			DockerID: dkcs[0].ID,
-			Image:    dkcs[0].Image,
-			Command:  dkcs[0].Args,
-			Env:      dkcs[0].Env,
+			ID:          1,
+			DockerID:    dkcs[0].ID,
+			ActualState: "running",
+			Image:       dkcs[0].Image,
+			Command:     dkcs[0].Args,
+			Env:         dkcs[0].Env,
 		},
 	}
 	assert.Equal(t, dkcsDB, dbcs)
 
 	dbcs[0].DockerID = ""
+	dbcs[0].ActualState = ""
 	changed = runSync(dk, dbcs, dkcs, *subnet)
 
 	newDkcs, err := dk.List(nil)
@@ -111,6 +297,7 @@ func TestSyncWorker(t *testing.T) {
 	assert.Equal(t, dkcs, newDkcs)
 
 	dbcs[0].DockerID = dkcs[0].ID
+	dbcs[0].ActualState = "running"
 	assert.Equal(t, dbcs, changed)
 
 	// Atempt a failed remove
@@ -141,32 +328,218 @@ func TestSyncJoinScore(t *testing.T) {
 		DockerID: "DockerID",
 	}
 	dkc := docker.Container{
-		Image: dbc.Image,
-		Args:  dbc.Command,
-		Env:   dbc.Env,
-		ID:    dbc.DockerID,
+		Image:  dbc.Image,
+		Args:   dbc.Command,
+		Env:    map[string]string{"a": "b", "PATH": "/bin"},
+		ID:     dbc.DockerID,
+		Labels: map[string]string{configKey: configHash(dbc)},
 	}
 
 	score := syncJoinScore(dbc, dkc)
 	assert.Zero(t, score)
 
-	dbc.Image = "Image1"
+	checkChange := func(change func(dbc *db.Container)) {
+		changed := dbc
+		change(&changed)
+		assert.Equal(t, -1, syncJoinScore(changed, dkc))
+	}
+
+	checkChange(func(dbc *db.Container) { dbc.Image = "Image1" })
+	checkChange(func(dbc *db.Container) { dbc.Command = []string{"wrong"} })
+	checkChange(func(dbc *db.Container) { dbc.Env = map[string]string{"a": "c"} })
+	checkChange(func(dbc *db.Container) { dbc.Env = nil })
+	checkChange(func(dbc *db.Container) { dbc.ShmSize = 1024 })
+	checkChange(func(dbc *db.Container) {
+		dbc.FilepathToContent = map[string]string{"/etc/foo.conf": "foo"}
+	})
+
+	// Nil and empty collections are the same configuration.
+	dbc.FilepathToContent = map[string]string{}
 	score = syncJoinScore(dbc, dkc)
-	assert.Equal(t, -1, score)
+	assert.Zero(t, score)
 
-	dbc.Image = dkc.Image
-	dbc.Command = []string{"wrong"}
+	// Containers that weren't booted with a configuration label are replaced.
+	dkc.Labels = nil
 	score = syncJoinScore(dbc, dkc)
 	assert.Equal(t, -1, score)
+	dkc.Labels = map[string]string{configKey: configHash(dbc)}
 
-	dbc.Command = dkc.Args
-	dbc.Env = map[string]string{"a": "wrong"}
+	// After the minion restarts, containers are matched by their stitch ID.
+	// Those that predate the label are adopted, but only as a last resort.
+	dbc.DockerID = ""
+	dbc.StitchID = 3
 	score = syncJoinScore(dbc, dkc)
-	assert.Equal(t, -1, score)
-	dbc.Env = dkc.Env
+	assert.Equal(t, 2, score)
 
-	dbc.DockerID = "2"
+	dkc.Labels[stitchIDKey] = "3"
 	score = syncJoinScore(dbc, dkc)
 	assert.Equal(t, 1, score)
-	dbc.DockerID = dkc.ID
+
+	dkc.Labels[stitchIDKey] = "4"
+	score = syncJoinScore(dbc, dkc)
+	assert.Equal(t, 3, score)
+}
+
+func TestDockerLabels(t *testing.T) {
+	t.Parallel()
+
+	dbc := db.Container{StitchID: 7, Image: "nginx", Labels: []string{"web", "lb"}}
+	assert.Equal(t, map[string]string{
+		labelKey:      labelValue,
+		configKey:     configHash(dbc),
+		quiltLabelKey: "lb,web",
+		stitchIDKey:   "7",
+		namespaceKey:  "ns",
+	}, dockerLabels(dbc, "ns"))
+	assert.Equal(t, []string{"web", "lb"}, dbc.Labels)
+
+	_, ok := dockerLabels(dbc, "")[namespaceKey]
+	assert.False(t, ok)
+}
+
+func TestRunEnv(t *testing.T) {
+	t.Parallel()
+
+	dbc := db.Container{Env: map[string]string{"a": "1"}}
+	assert.Equal(t, dbc.Env, runEnv(dbc))
+
+	dbc.LabelSize = 3
+	dbc.LabelIndex = 2
+	assert.Equal(t, map[string]string{
+		"a":              "1",
+		db.LabelSizeEnv:  "3",
+		db.LabelIndexEnv: "2",
+	}, runEnv(dbc))
+
+	// The container's own environment wins.
+	dbc.Env[db.LabelSizeEnv] = "mine"
+	assert.Equal(t, "mine", runEnv(dbc)[db.LabelSizeEnv])
+	assert.Equal(t, "3", dbc.LabelEnv()[db.LabelSizeEnv])
+}
+
+func TestRunWorkerDockerOutage(t *testing.T) {
+	defer func(settle time.Duration) { dockerSettleTime = settle }(dockerSettleTime)
+	dockerSettleTime = 0
+
+	md, dk := docker.NewMock()
+	conn := db.New()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		for _, image := range []string{"web", "db"} {
+			container := view.InsertContainer()
+			container.Image = image
+			container.Minion = "1.2.3.4"
+			container.NetworkMode = "host"
+			view.Commit(container)
+		}
+
+		m := view.InsertMinion()
+		m.Self = true
+		m.PrivateIP = "1.2.3.4"
+		view.Commit(m)
+		return nil
+	})
+
+	dockerIDs := func() []string {
+		var ids []string
+		for id := range md.Containers {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	unavailableSince := func() time.Time {
+		self, err := conn.MinionSelf()
+		assert.NoError(t, err)
+		return self.DockerUnavailableSince
+	}
+
+	runWorker(conn, dk, "1.2.3.4", *subnet)
+	booted := dockerIDs()
+	assert.Len(t, booted, 2)
+	assert.True(t, unavailableSince().IsZero())
+
+	// A 30 second outage, during which the worker retries every second.
+	md.Unavailable = true
+	for i := 0; i < 30; i++ {
+		runWorker(conn, dk, "1.2.3.4", *subnet)
+	}
+	since := unavailableSince()
+	assert.False(t, since.IsZero())
+	assert.Equal(t, dk.UnavailableSince(), since)
+
+	for _, dbc := range conn.SelectFromContainer(nil) {
+		assert.Equal(t, "running", dbc.ActualState)
+	}
+
+	// The daemon restarted without live restore, so it stopped the containers.
+	// They're started again rather than re-created.
+	for _, id := range booted {
+		md.StopContainer(id, 0)
+	}
+	md.Unavailable = false
+
+	for i := 0; i < 2; i++ {
+		runWorker(conn, dk, "1.2.3.4", *subnet)
+	}
+	assert.Equal(t, booted, dockerIDs())
+	running, err := dk.List(nil)
+	assert.NoError(t, err)
+	assert.Len(t, running, 2)
+	assert.True(t, unavailableSince().IsZero())
+	assert.True(t, dk.UnavailableSince().IsZero())
+}
+
+func TestRunWorkerOrphans(t *testing.T) {
+	md, dk := docker.NewMock()
+	conn := db.New()
+	conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		container := view.InsertContainer()
+		container.StitchID = 3
+		container.Image = "web"
+		container.Labels = []string{"web"}
+		container.Minion = "1.2.3.4"
+		container.NetworkMode = "host"
+		view.Commit(container)
+
+		m := view.InsertMinion()
+		m.Self = true
+		m.PrivateIP = "1.2.3.4"
+		view.Commit(m)
+		return nil
+	})
+
+	orphans := func() []db.Container {
+		self, err := conn.MinionSelf()
+		assert.NoError(t, err)
+		return self.Orphans
+	}
+
+	runWorker(conn, dk, "1.2.3.4", *subnet)
+	assert.Empty(t, orphans())
+
+	var dockerID string
+	for id := range md.Containers {
+		dockerID = id
+	}
+
+	// The container is no longer declared, but fails to be removed.
+	conn.Txn(db.ContainerTable).Run(func(view db.Database) error {
+		view.Remove(view.SelectFromContainer(nil)[0])
+		return nil
+	})
+	md.RemoveError = true
+	runWorker(conn, dk, "1.2.3.4", *subnet)
+	assert.Equal(t, []db.Container{{
+		StitchID:     3,
+		Minion:       "1.2.3.4",
+		DockerID:     dockerID,
+		Image:        "web",
+		Labels:       []string{"web"},
+		DesiredState: db.DesiredRemoved,
+		ActualState:  "running",
+	}}, orphans())
+
+	md.RemoveError = false
+	runWorker(conn, dk, "1.2.3.4", *subnet)
+	assert.Empty(t, orphans())
 }
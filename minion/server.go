@@ -1,12 +1,18 @@
 package minion
 
 import (
+	"encoding/json"
+	"fmt"
 	"net"
+	"runtime"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/NetSys/quilt/counter"
 	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/minion/docker"
+	"github.com/NetSys/quilt/minion/network"
 	"github.com/NetSys/quilt/minion/pb"
 
 	"golang.org/x/net/context"
@@ -17,11 +23,14 @@ import (
 
 type server struct {
 	db.Conn
+	dk docker.Client
+
+	start time.Time // When the minion started.
 }
 
-func minionServerRun(conn db.Conn) {
+func minionServerRun(conn db.Conn, dk docker.Client, start time.Time) {
 	var sock net.Listener
-	server := server{conn}
+	server := server{conn, dk, start}
 	for {
 		var err error
 		sock, err = net.Listen("tcp", ":9999")
@@ -52,14 +61,51 @@ func (s server) GetMinionConfig(cts context.Context,
 		cfg.Size = m.Size
 		cfg.Region = m.Region
 		cfg.AuthorizedKeys = strings.Split(m.AuthorizedKeys, "\n")
+		cfg.DedicatedTo = m.DedicatedTo
+		cfg.FloatingIP = m.FloatingIP
+		cfg.Draining = m.Draining
+		cfg.DisableNAT = m.DisableNAT
+		cfg.BootMinion = int64(m.BootTimings.Minion)
+		cfg.BootImagePull = int64(m.BootTimings.ImagePull)
+		cfg.BootFirstContainer = int64(m.BootTimings.FirstContainer)
+
+		if len(m.SupervisorStatus) > 0 {
+			status, err := json.Marshal(m.SupervisorStatus)
+			if err != nil {
+				log.WithError(err).Warn("Failed to encode supervisor status.")
+			} else {
+				cfg.SupervisorStatus = string(status)
+			}
+		}
 	} else {
 		cfg.Role = db.RoleToPB(db.None)
 	}
 
-	s.Txn(db.EtcdTable).Run(func(view db.Database) error {
-		if etcdRow, err := view.GetEtcd(); err == nil {
+	s.Txn(db.ContainerTable, db.EtcdTable).Run(func(view db.Database) error {
+		etcdRow, err := view.GetEtcd()
+		if err == nil {
 			cfg.EtcdMembers = etcdRow.EtcdIPs
 		}
+
+		// Only the leader schedules containers, so the other minions' counts
+		// may be stale.  The daemon boots workers to make room for the
+		// containers the scheduler failed to place.
+		if err == nil && etcdRow.Leader {
+			cfg.Unscheduled = int32(len(view.SelectFromContainer(
+				func(dbc db.Container) bool {
+					return dbc.Minion == "" &&
+						dbc.PlacementFailure != ""
+				})))
+		}
+
+		// The daemon waits for draining minions to empty before terminating
+		// them.
+		if cfg.PrivateIP != "" {
+			cfg.Containers = int32(len(view.SelectFromContainer(
+				func(dbc db.Container) bool {
+					return dbc.Minion == cfg.PrivateIP
+				})))
+		}
 		return nil
 	})
 
@@ -75,6 +121,10 @@ func (s server) SetMinionConfig(ctx context.Context,
 		if err != nil {
 			log.Info("Received initial configuation.")
 			minion = view.InsertMinion()
+			if firstStart() {
+				minion.Started = s.start
+				minion.BootTimings.Minion = bootScriptElapsed(s.start)
+			}
 		}
 
 		minion.Role = db.PBToRole(msg.Role)
@@ -84,6 +134,11 @@ func (s server) SetMinionConfig(ctx context.Context,
 		minion.Size = msg.Size
 		minion.Region = msg.Region
 		minion.AuthorizedKeys = strings.Join(msg.AuthorizedKeys, "\n")
+		minion.DedicatedTo = msg.DedicatedTo
+		minion.FloatingIP = msg.FloatingIP
+		minion.Draining = msg.Draining
+		minion.DisableNAT = msg.DisableNAT
+		minion.Arch = runtime.GOARCH
 		minion.Self = true
 		view.Commit(minion)
 
@@ -102,3 +157,39 @@ func (s server) SetMinionConfig(ctx context.Context,
 
 	return &pb.Reply{}, nil
 }
+
+func (s server) GetMinionCounters(ctx context.Context, _ *pb.Request) (
+	*pb.MinionCountersReply, error) {
+
+	reply := &pb.MinionCountersReply{}
+	for _, c := range counter.Dump() {
+		reply.Counters = append(reply.Counters, &pb.MinionCounter{
+			Pkg:       c.Pkg,
+			Name:      c.Name,
+			Value:     c.Value,
+			PrevValue: c.PrevValue,
+		})
+	}
+	return reply, nil
+}
+
+// Probe checks whether the running container with the requested source IP can reach
+// the requested destination.  The container must be on this minion.
+func (s server) Probe(ctx context.Context, in *pb.ProbeRequest) (*pb.ProbeReply,
+	error) {
+
+	dbcs := s.SelectFromContainer(func(dbc db.Container) bool {
+		return dbc.IP == in.FromIP && dbc.DockerID != "" && dbc.Pid != 0
+	})
+	if in.FromIP == "" || len(dbcs) == 0 {
+		return nil, fmt.Errorf("no running container with IP %s", in.FromIP)
+	}
+
+	hops := network.Probe(s.dk, dbcs[0], in.Hostname, in.IP, int(in.Port),
+		in.Protocol)
+	hopsJSON, err := json.Marshal(hops)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ProbeReply{Hops: string(hopsJSON)}, nil
+}
@@ -2,6 +2,7 @@ package minion
 
 import (
 	"reflect"
+	"runtime"
 	"testing"
 	"time"
 
@@ -13,7 +14,7 @@ import (
 
 func TestSetMinionConfig(t *testing.T) {
 	t.Parallel()
-	s := server{db.New()}
+	s := server{Conn: db.New()}
 
 	cfg := pb.MinionConfig{
 		Role:           pb.MinionConfig_MASTER,
@@ -24,6 +25,8 @@ func TestSetMinionConfig(t *testing.T) {
 		Region:         "region",
 		EtcdMembers:    []string{"etcd1", "etcd2"},
 		AuthorizedKeys: []string{"key1", "key2"},
+		Draining:       true,
+		DisableNAT:     true,
 	}
 	expMinion := db.Minion{
 		Self:           true,
@@ -33,7 +36,10 @@ func TestSetMinionConfig(t *testing.T) {
 		Provider:       "provider",
 		Size:           "size",
 		Region:         "region",
+		Arch:           runtime.GOARCH,
 		AuthorizedKeys: "key1\nkey2",
+		Draining:       true,
+		DisableNAT:     true,
 	}
 	_, err := s.SetMinionConfig(nil, &cfg)
 	assert.NoError(t, err)
@@ -97,7 +103,7 @@ func checkEtcdEquals(t *testing.T, conn db.Conn, exp db.Etcd) {
 
 func TestGetMinionConfig(t *testing.T) {
 	t.Parallel()
-	s := server{db.New()}
+	s := server{Conn: db.New()}
 
 	// Should set Role to None if no config.
 	cfg, err := s.GetMinionConfig(nil, &pb.Request{})
@@ -145,4 +151,69 @@ func TestGetMinionConfig(t *testing.T) {
 		EtcdMembers:    []string{"etcd1", "etcd2"},
 		AuthorizedKeys: []string{"key1", "key2"},
 	}, *cfg)
+
+	// Draining minions report the containers still scheduled on them.
+	s.Conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.SelectFromMinion(nil)[0]
+		m.Draining = true
+		view.Commit(m)
+
+		for _, ip := range []string{"priv", "other", ""} {
+			dbc := view.InsertContainer()
+			dbc.Minion = ip
+			view.Commit(dbc)
+		}
+		return nil
+	})
+	cfg, err = s.GetMinionConfig(nil, &pb.Request{})
+	assert.NoError(t, err)
+	assert.True(t, cfg.Draining)
+	assert.Equal(t, int32(1), cfg.Containers)
+	assert.Zero(t, cfg.Unscheduled)
+
+	// The leader reports the containers the scheduler failed to place.
+	s.Conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		etcd := view.SelectFromEtcd(nil)[0]
+		etcd.Leader = true
+		view.Commit(etcd)
+
+		dbc := view.InsertContainer()
+		dbc.PlacementFailure = "dedicated to web"
+		view.Commit(dbc)
+		return nil
+	})
+	cfg, err = s.GetMinionConfig(nil, &pb.Request{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), cfg.Unscheduled)
+
+	// Minions report how long their boot took.
+	s.Conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.SelectFromMinion(nil)[0]
+		m.BootTimings = db.BootTimings{
+			Minion:         time.Minute,
+			ImagePull:      time.Second,
+			FirstContainer: time.Hour,
+		}
+		view.Commit(m)
+		return nil
+	})
+	cfg, err = s.GetMinionConfig(nil, &pb.Request{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(time.Minute), cfg.BootMinion)
+	assert.Equal(t, int64(time.Second), cfg.BootImagePull)
+	assert.Equal(t, int64(time.Hour), cfg.BootFirstContainer)
+
+	// Minions report the health of their system containers.
+	s.Conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m := view.SelectFromMinion(nil)[0]
+		m.SupervisorStatus = map[string]db.ComponentStatus{
+			"etcd": {State: db.ComponentUnhealthy, Restarts: 2},
+		}
+		view.Commit(m)
+		return nil
+	})
+	cfg, err = s.GetMinionConfig(nil, &pb.Request{})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"etcd":{"State":"unhealthy","Restarts":2}}`,
+		cfg.SupervisorStatus)
 }
@@ -0,0 +1,278 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/minion/docker"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ProbeConfig tunes the liveness probes of the system containers.
+type ProbeConfig struct {
+	// How often each container is probed.
+	Interval time.Duration
+
+	// The number of probes in a row a container must fail before it's restarted.
+	FailureThreshold int
+}
+
+// DefaultProbeConfig is the ProbeConfig used unless the minion is told otherwise.
+var DefaultProbeConfig = ProbeConfig{Interval: 10 * time.Second, FailureThreshold: 3}
+
+// After a restart, a container isn't probed again until a backoff has passed.  The
+// backoff starts at the probe interval and doubles with each restart, up to
+// maxRestartBackoff.  It's reset once the container has been healthy for that long.
+const maxRestartBackoff = 5 * time.Minute
+
+// The longest a probe waits for a container to respond.
+const probeTimeout = 5 * time.Second
+
+// The address of the local etcd's client API.  It's a variable so that the unit tests
+// can mock it out.
+var etcdURL = "http://127.0.0.1:2379"
+
+// probes check that the system containers respond, as a container that's running
+// may still be wedged.  They're variables so that the unit tests can mock them out.
+var probes = map[string]func() error{
+	Etcd:          probeEtcd,
+	Ovsdb:         probeOvsdb,
+	Ovsvswitchd:   appctlProbe(Ovsvswitchd),
+	Ovncontroller: appctlProbe(Ovncontroller),
+	Ovnnorthd:     appctlProbe(Ovnnorthd),
+}
+
+// probeIPsec checks that ovs-monitor-ipsec, which negotiates IPsec for the tunnels OVS
+// is given keys for, is running.  Without it, OVS sends all tunnel traffic in the
+// clear.  It's a variable so that the unit tests can mock it out.
+var probeIPsec = appctlProbe("ovs-monitor-ipsec")
+
+// A component is a system container that the supervisor has started, and monitors.
+type component struct {
+	args []string
+
+	state    string
+	failures int // The probes failed in a row.
+	restarts int
+
+	backoff      time.Duration
+	nextProbe    time.Time
+	healthySince time.Time
+}
+
+// monitor probes the system containers every probe interval.
+func (sv *supervisor) monitor() {
+	for range time.Tick(sv.probeConfig.Interval) {
+		sv.monitorOnce(time.Now())
+	}
+}
+
+// monitorOnce probes each of the system containers that are due, restarts those that
+// have failed FailureThreshold probes in a row, and records their health.  The probes
+// and restarts, which may wait on a slow container or an image pull, are made without
+// the lock, so that they don't hold up changes to the minion's configuration.
+func (sv *supervisor) monitorOnce(now time.Time) {
+	results := map[string]error{}
+	for name, probe := range sv.dueProbes(now) {
+		results[name] = probe()
+	}
+
+	sv.lock.Lock()
+	restarts := sv.recordProbes(now, results)
+	sv.recordStatus()
+	sv.lock.Unlock()
+
+	for name, c := range restarts {
+		sv.restart(name, c)
+	}
+	sv.recordEncryptionSupport()
+}
+
+// dueProbes returns the probes of the monitored containers that are due at `now`.
+func (sv *supervisor) dueProbes(now time.Time) map[string]func() error {
+	sv.lock.Lock()
+	defer sv.lock.Unlock()
+
+	due := map[string]func() error{}
+	for name, c := range sv.components {
+		if probe, ok := probes[name]; ok && !now.Before(c.nextProbe) {
+			due[name] = probe
+		}
+	}
+	return due
+}
+
+// recordProbes updates the health of the containers according to the `results` of
+// their probes, and returns those that should be restarted.  The caller must hold the
+// lock.
+func (sv *supervisor) recordProbes(now time.Time,
+	results map[string]error) map[string]*component {
+
+	restarts := map[string]*component{}
+	for name, err := range results {
+		// The container may have been removed while it was probed.
+		c, ok := sv.components[name]
+		if !ok {
+			continue
+		}
+
+		if err == nil {
+			if c.state != db.ComponentHealthy {
+				c.healthySince = now
+			}
+			if now.Sub(c.healthySince) >= maxRestartBackoff {
+				c.backoff = 0
+			}
+			c.state = db.ComponentHealthy
+			c.failures = 0
+			continue
+		}
+
+		c.state = db.ComponentUnhealthy
+		c.failures++
+		log.WithError(err).WithField("failures", c.failures).Warnf(
+			"%s failed its liveness probe.", name)
+		if c.failures < sv.probeConfig.FailureThreshold {
+			continue
+		}
+
+		c.backoff *= 2
+		if c.backoff == 0 {
+			c.backoff = sv.probeConfig.Interval
+		}
+		if c.backoff > maxRestartBackoff {
+			c.backoff = maxRestartBackoff
+		}
+		log.WithField("backoff", c.backoff).Warnf("Restarting %s.", name)
+
+		c.state = db.ComponentStarting
+		c.failures = 0
+		c.restarts++
+		c.nextProbe = now.Add(c.backoff)
+		restarts[name] = c
+	}
+	return restarts
+}
+
+// restart removes and re-runs the container `name`, which is monitored as `c`.  It's
+// called without the lock, so the container may stop being monitored meanwhile, in
+// which case it's removed again rather than left running.
+func (sv *supervisor) restart(name string, c *component) {
+	sv.lock.Lock()
+	args := c.args
+	sv.lock.Unlock()
+
+	err := sv.dk.Remove(name)
+	if err != nil && err != docker.ErrNoSuchContainer {
+		log.WithError(err).Warnf("Failed to remove %s.", name)
+	}
+	sv.run(name, args...)
+
+	sv.lock.Lock()
+	defer sv.lock.Unlock()
+	if _, ok := sv.components[name]; !ok {
+		sv.Remove(name)
+	}
+}
+
+// track starts monitoring the container `name`, which was run with `args`.
+func (sv *supervisor) track(name string, args []string) {
+	if sv.components == nil {
+		sv.components = map[string]*component{}
+	}
+
+	if c, ok := sv.components[name]; ok {
+		c.args = args
+		return
+	}
+	sv.components[name] = &component{args: args, state: db.ComponentStarting}
+}
+
+// recordStatus stores the health of the monitored containers in the minion's row.
+func (sv *supervisor) recordStatus() {
+	status := map[string]db.ComponentStatus{}
+	for name, c := range sv.components {
+		status[name] = db.ComponentStatus{State: c.state, Restarts: c.restarts}
+	}
+
+	sv.conn.Txn(db.MinionTable).Run(func(view db.Database) error {
+		self, err := view.MinionSelf()
+		if err == nil && !reflect.DeepEqual(self.SupervisorStatus, status) {
+			self.SupervisorStatus = status
+			view.Commit(self)
+		}
+		return err
+	})
+}
+
+// recordEncryptionSupport records in a worker's row whether it's able to encrypt its
+// tunnels.  The leader only enables encryption once every worker is.
+func (sv *supervisor) recordEncryptionSupport() {
+	self, err := sv.conn.MinionSelf()
+	if err != nil || self.Role != db.Worker {
+		return
+	}
+
+	supported := probeIPsec() == nil
+	sv.conn.Txn(db.MinionTable).Run(func(view db.Database) error {
+		self, err := view.MinionSelf()
+		if err == nil && self.EncryptionSupported != supported {
+			self.EncryptionSupported = supported
+			view.Commit(self)
+		}
+		return err
+	})
+}
+
+// probeEtcd asks the local etcd for its version.  Unlike etcd's health endpoint, which
+// fails whenever there's no quorum, it only checks that the member itself responds, so
+// that a partition doesn't make every member restart its etcd.
+func probeEtcd() error {
+	client := http.Client{Timeout: probeTimeout}
+	resp, err := client.Get(etcdURL + "/version")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("version check returned %s", resp.Status)
+	}
+
+	var version struct {
+		Server string `json:"etcdserver"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return fmt.Errorf("malformed version check: %s", err)
+	}
+
+	if version.Server == "" {
+		return errors.New("version check returned no server version")
+	}
+	return nil
+}
+
+// probeOvsdb queries the OVSDB server.
+func probeOvsdb() error {
+	return execRun("ovs-vsctl", fmt.Sprintf("--timeout=%d", probeSeconds()),
+		"show")
+}
+
+// appctlProbe returns a probe that asks the OVS daemon `name` for its version over
+// its control socket, which it only answers if its main loop is running.
+func appctlProbe(name string) func() error {
+	return func() error {
+		return execRun("ovs-appctl", fmt.Sprintf("--timeout=%d",
+			probeSeconds()), "-t", name, "version")
+	}
+}
+
+func probeSeconds() int {
+	return int(probeTimeout / time.Second)
+}
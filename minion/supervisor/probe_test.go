@@ -0,0 +1,216 @@
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/NetSys/quilt/db"
+)
+
+func TestMonitor(t *testing.T) {
+	ctx := initTest()
+	ctx.sv.probeConfig = ProbeConfig{Interval: time.Second, FailureThreshold: 2}
+
+	healthy := map[string]bool{Etcd: true, Ovsdb: true, Ovnnorthd: true}
+	defer func(orig map[string]func() error) { probes = orig }(probes)
+	probes = map[string]func() error{}
+	for name := range healthy {
+		name := name
+		probes[name] = func() error {
+			if !healthy[name] {
+				return errors.New("timeout")
+			}
+			return nil
+		}
+	}
+
+	ctx.conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		m, _ := view.MinionSelf()
+		e := view.SelectFromEtcd(nil)[0]
+		m.Role = db.Master
+		m.PrivateIP = "1.2.3.4"
+		e.EtcdIPs = []string{"1.2.3.4"}
+		e.Leader = true
+		view.Commit(m)
+		view.Commit(e)
+		return nil
+	})
+	ctx.run()
+
+	etcdID := func() string {
+		containers, _ := ctx.fd.List(nil)
+		for _, c := range containers {
+			if c.Name == Etcd {
+				return c.ID
+			}
+		}
+		return ""
+	}
+
+	checkStatus := func(exp map[string]db.ComponentStatus) {
+		self, err := ctx.conn.MinionSelf()
+		assert.NoError(t, err)
+		assert.Equal(t, exp, self.SupervisorStatus)
+	}
+
+	now := time.Now()
+	ctx.sv.monitorOnce(now)
+	checkStatus(map[string]db.ComponentStatus{
+		Etcd:      {State: db.ComponentHealthy},
+		Ovsdb:     {State: db.ComponentHealthy},
+		Ovnnorthd: {State: db.ComponentHealthy},
+	})
+
+	// Etcd wedges, and is restarted once it fails enough probes in a row.
+	healthy[Etcd] = false
+	origID := etcdID()
+	ctx.sv.monitorOnce(now.Add(time.Second))
+	assert.Equal(t, db.ComponentStatus{State: db.ComponentUnhealthy},
+		ctx.sv.status(Etcd))
+	assert.Equal(t, origID, etcdID())
+
+	ctx.sv.monitorOnce(now.Add(2 * time.Second))
+	assert.Equal(t, db.ComponentStatus{State: db.ComponentStarting, Restarts: 1},
+		ctx.sv.status(Etcd))
+	assert.NotEqual(t, origID, etcdID())
+	assert.Equal(t, etcdArgsMaster("1.2.3.4", []string{"1.2.3.4"}),
+		ctx.fd.running()[Etcd])
+
+	// It isn't probed again until the backoff has passed, and the backoff doubles
+	// with each restart.
+	ctx.sv.monitorOnce(now.Add(2500 * time.Millisecond))
+	assert.Equal(t, db.ComponentStatus{State: db.ComponentStarting, Restarts: 1},
+		ctx.sv.status(Etcd))
+
+	ctx.sv.monitorOnce(now.Add(3 * time.Second))
+	ctx.sv.monitorOnce(now.Add(4 * time.Second))
+	assert.Equal(t, db.ComponentStatus{State: db.ComponentStarting, Restarts: 2},
+		ctx.sv.status(Etcd))
+
+	ctx.sv.monitorOnce(now.Add(5 * time.Second))
+	assert.Equal(t, db.ComponentStatus{State: db.ComponentStarting, Restarts: 2},
+		ctx.sv.status(Etcd))
+
+	healthy[Etcd] = true
+	ctx.sv.monitorOnce(now.Add(6 * time.Second))
+	checkStatus(map[string]db.ComponentStatus{
+		Etcd:      {State: db.ComponentHealthy, Restarts: 2},
+		Ovsdb:     {State: db.ComponentHealthy},
+		Ovnnorthd: {State: db.ComponentHealthy},
+	})
+
+	// Containers the supervisor removes are no longer monitored.
+	ctx.conn.Txn(db.AllTables...).Run(func(view db.Database) error {
+		e := view.SelectFromEtcd(nil)[0]
+		e.Leader = false
+		view.Commit(e)
+		return nil
+	})
+	ctx.run()
+
+	healthy[Ovnnorthd] = false
+	ctx.sv.monitorOnce(now.Add(7 * time.Second))
+	ctx.sv.monitorOnce(now.Add(8 * time.Second))
+	checkStatus(map[string]db.ComponentStatus{
+		Etcd:  {State: db.ComponentHealthy, Restarts: 2},
+		Ovsdb: {State: db.ComponentHealthy},
+	})
+	_, ok := ctx.fd.running()[Ovnnorthd]
+	assert.False(t, ok)
+}
+
+func (sv *supervisor) status(name string) db.ComponentStatus {
+	c := sv.components[name]
+	return db.ComponentStatus{State: c.state, Restarts: c.restarts}
+}
+
+func TestRecordEncryptionSupport(t *testing.T) {
+	ctx := initTest()
+
+	var ipsecErr error
+	defer func(orig func() error) { probeIPsec = orig }(probeIPsec)
+	probeIPsec = func() error { return ipsecErr }
+
+	supported := func() bool {
+		self, err := ctx.conn.MinionSelf()
+		assert.NoError(t, err)
+		return self.EncryptionSupported
+	}
+
+	// Only workers tunnel, so masters don't probe.
+	setRole := func(role db.Role) {
+		ctx.conn.Txn(db.MinionTable).Run(func(view db.Database) error {
+			m, _ := view.MinionSelf()
+			m.Role = role
+			view.Commit(m)
+			return nil
+		})
+	}
+	setRole(db.Master)
+	ctx.sv.recordEncryptionSupport()
+	assert.False(t, supported())
+
+	setRole(db.Worker)
+	ctx.sv.recordEncryptionSupport()
+	assert.True(t, supported())
+
+	ipsecErr = errors.New("timeout")
+	ctx.sv.recordEncryptionSupport()
+	assert.False(t, supported())
+}
+
+func TestMonitorUnlocked(t *testing.T) {
+	ctx := initTest()
+	ctx.sv.probeConfig = ProbeConfig{Interval: time.Second, FailureThreshold: 1}
+	ctx.sv.track(Etcd, nil)
+
+	// A slow probe doesn't hold up changes to the configuration, which take the
+	// lock.
+	defer func(orig map[string]func() error) { probes = orig }(probes)
+	probes = map[string]func() error{Etcd: func() error {
+		locked := make(chan struct{})
+		go func() {
+			ctx.sv.lock.Lock()
+			ctx.sv.lock.Unlock()
+			close(locked)
+		}()
+
+		select {
+		case <-locked:
+			return nil
+		case <-time.After(time.Second):
+			return errors.New("probed while holding the lock")
+		}
+	}}
+
+	ctx.sv.monitorOnce(time.Now())
+	assert.Equal(t, db.ComponentStatus{State: db.ComponentHealthy},
+		ctx.sv.status(Etcd))
+}
+
+func TestProbeEtcd(t *testing.T) {
+	version := `{"etcdserver":"3.0.17","etcdcluster":"3.0.0"}`
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/version", r.URL.Path)
+			fmt.Fprint(w, version)
+		}))
+	defer server.Close()
+
+	defer func(orig string) { etcdURL = orig }(etcdURL)
+	etcdURL = server.URL
+
+	assert.NoError(t, probeEtcd())
+
+	version = "{}"
+	assert.Error(t, probeEtcd())
+
+	server.Close()
+	assert.Error(t, probeEtcd())
+}
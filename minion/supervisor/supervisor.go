@@ -6,6 +6,8 @@ import (
 	"os/exec"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/minion/docker"
@@ -61,34 +63,87 @@ type supervisor struct {
 	provider string
 	region   string
 	size     string
+
+	// The lock serializes changes to the system containers, which are made both
+	// as the minion's configuration changes, and as their probes fail.
+	lock        sync.Mutex
+	probeConfig ProbeConfig
+	components  map[string]*component
 }
 
 // Run blocks implementing the supervisor module.
-func Run(conn db.Conn, dk docker.Client) {
-	sv := supervisor{conn: conn, dk: dk}
+func Run(conn db.Conn, dk docker.Client, probeConfig ProbeConfig) {
+	sv := supervisor{conn: conn, dk: dk, probeConfig: probeConfig}
+	go sv.monitor()
 	sv.runSystem()
 }
 
 // Manage system infrstracture containers that support the application.
 func (sv *supervisor) runSystem() {
+	go sv.prePull()
+
+	loopLog := util.NewEventTimer("Supervisor")
+	for range sv.conn.Trigger(db.MinionTable, db.EtcdTable).C {
+		loopLog.LogStart()
+		sv.runSystemOnce()
+		loopLog.LogEnd()
+	}
+}
+
+// prePull pulls the images of the system containers concurrently, so that they're
+// ready by the time the minion is configured, and records how long it took.
+func (sv *supervisor) prePull() {
 	imageSet := map[string]struct{}{}
 	for _, image := range images {
 		imageSet[image] = struct{}{}
 	}
 
+	start := time.Now()
+	errs := make(chan error, len(imageSet))
 	for image := range imageSet {
-		go sv.dk.Pull(image)
+		go func(image string) {
+			errs <- sv.dk.Pull(image)
+		}(image)
 	}
 
-	loopLog := util.NewEventTimer("Supervisor")
-	for range sv.conn.Trigger(db.MinionTable, db.EtcdTable).C {
-		loopLog.LogStart()
-		sv.runSystemOnce()
-		loopLog.LogEnd()
+	var failed bool
+	for range imageSet {
+		if err := <-errs; err != nil {
+			failed = true
+		}
+	}
+
+	// The images are pulled again when their containers are run, so there's no
+	// timing to report if any of them failed.
+	if !failed {
+		sv.recordImagePull(time.Since(start))
+	}
+}
+
+// recordImagePull records how long pulling the system images took, once the minion
+// has been configured.  Restarted minions have no start time, and pull images that
+// are already cached, so their pulls aren't recorded.
+func (sv *supervisor) recordImagePull(duration time.Duration) {
+	for {
+		err := sv.conn.Txn(db.MinionTable).Run(func(view db.Database) error {
+			self, err := view.MinionSelf()
+			if err == nil && !self.Started.IsZero() {
+				self.BootTimings.ImagePull = duration
+				view.Commit(self)
+			}
+			return err
+		})
+		if err == nil {
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
 	}
 }
 
 func (sv *supervisor) runSystemOnce() {
+	sv.lock.Lock()
+	defer sv.lock.Unlock()
+
 	minion, err := sv.conn.MinionSelf()
 	if err != nil {
 		return
@@ -139,13 +194,17 @@ func (sv *supervisor) updateWorker(IP string, leaderIP string, etcdIPs []string)
 		sv.Remove(Etcd)
 	}
 
-	sv.run(Etcd, fmt.Sprintf("--initial-cluster=%s", initialClusterString(etcdIPs)),
-		"--heartbeat-interval="+etcdHeartbeatInterval,
-		"--election-timeout="+etcdElectionTimeout,
-		"--proxy=on")
-
-	sv.run(Ovsdb, "ovsdb-server")
-	sv.run(Ovsvswitchd, "ovs-vswitchd")
+	sv.runAll(map[string][]string{
+		Etcd: {
+			fmt.Sprintf("--initial-cluster=%s",
+				initialClusterString(etcdIPs)),
+			"--heartbeat-interval=" + etcdHeartbeatInterval,
+			"--election-timeout=" + etcdElectionTimeout,
+			"--proxy=on",
+		},
+		Ovsdb:       {"ovsdb-server"},
+		Ovsvswitchd: {"ovs-vswitchd"},
+	})
 
 	if leaderIP == "" || IP == "" {
 		return
@@ -183,6 +242,7 @@ func (sv *supervisor) updateWorker(IP string, leaderIP string, etcdIPs []string)
 	 * So, we need to restart the container when the leader changes. */
 	sv.Remove(Ovncontroller)
 	sv.run(Ovncontroller, "ovn-controller")
+	sv.track(Ovncontroller, []string{"ovn-controller"})
 	sv.SetInit(true)
 }
 
@@ -195,29 +255,54 @@ func (sv *supervisor) updateMaster(IP string, etcdIPs []string, leader bool) {
 		return
 	}
 
-	sv.run(Etcd, fmt.Sprintf("--name=master-%s", IP),
-		fmt.Sprintf("--initial-cluster=%s", initialClusterString(etcdIPs)),
-		fmt.Sprintf("--advertise-client-urls=http://%s:2379", IP),
-		fmt.Sprintf("--listen-peer-urls=http://%s:2380", IP),
-		fmt.Sprintf("--initial-advertise-peer-urls=http://%s:2380", IP),
-		"--listen-client-urls=http://0.0.0.0:2379",
-		"--heartbeat-interval="+etcdHeartbeatInterval,
-		"--initial-cluster-state=new",
-		"--election-timeout="+etcdElectionTimeout)
-	sv.run(Ovsdb, "ovsdb-server")
+	containers := map[string][]string{
+		Etcd: {
+			fmt.Sprintf("--name=master-%s", IP),
+			fmt.Sprintf("--initial-cluster=%s",
+				initialClusterString(etcdIPs)),
+			fmt.Sprintf("--advertise-client-urls=http://%s:2379", IP),
+			fmt.Sprintf("--listen-peer-urls=http://%s:2380", IP),
+			fmt.Sprintf("--initial-advertise-peer-urls=http://%s:2380", IP),
+			"--listen-client-urls=http://0.0.0.0:2379",
+			"--heartbeat-interval=" + etcdHeartbeatInterval,
+			"--initial-cluster-state=new",
+			"--election-timeout=" + etcdElectionTimeout,
+		},
+		Ovsdb: {"ovsdb-server"},
+	}
 
 	if leader {
 		/* XXX: If we fail to boot ovn-northd, we should give up
 		* our leadership somehow.  This ties into the general
 		* problem of monitoring health. */
-		sv.run(Ovnnorthd, "ovn-northd")
+		containers[Ovnnorthd] = []string{"ovn-northd"}
 	} else {
 		sv.Remove(Ovnnorthd)
 	}
+	sv.runAll(containers)
 
 	sv.SetInit(true)
 }
 
+// runAll runs the containers named in `containers` with their arguments, and
+// monitors them.  They're started concurrently, as each may have to wait for its
+// image to be pulled.
+func (sv *supervisor) runAll(containers map[string][]string) {
+	var wg sync.WaitGroup
+	wg.Add(len(containers))
+	for name, args := range containers {
+		go func(name string, args []string) {
+			sv.run(name, args...)
+			wg.Done()
+		}(name, args)
+	}
+	wg.Wait()
+
+	for name, args := range containers {
+		sv.track(name, args)
+	}
+}
+
 func (sv *supervisor) run(name string, args ...string) {
 	isRunning, err := sv.dk.IsRunning(name)
 	if err != nil {
@@ -248,6 +333,8 @@ func (sv *supervisor) run(name string, args ...string) {
 }
 
 func (sv *supervisor) Remove(name string) {
+	delete(sv.components, name)
+
 	log.WithField("name", name).Info("Removing container")
 	err := sv.dk.Remove(name)
 	if err != nil && err != docker.ErrNoSuchContainer {
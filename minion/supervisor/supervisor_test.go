@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/minion/docker"
@@ -367,6 +368,34 @@ type testCtx struct {
 	trigg db.Trigger
 }
 
+func TestRecordImagePull(t *testing.T) {
+	ctx := initTest()
+	imagePull := func() time.Duration {
+		self, err := ctx.conn.MinionSelf()
+		if err != nil {
+			t.Fatalf("no minion: %s", err)
+		}
+		return self.BootTimings.ImagePull
+	}
+
+	// A restarted minion has no start time, and its pulls aren't its boot's.
+	ctx.sv.recordImagePull(time.Minute)
+	if pull := imagePull(); pull != 0 {
+		t.Errorf("restarted minion recorded an image pull of %s", pull)
+	}
+
+	ctx.conn.Txn(db.MinionTable).Run(func(view db.Database) error {
+		self, _ := view.MinionSelf()
+		self.Started = time.Now()
+		view.Commit(self)
+		return nil
+	})
+	ctx.sv.recordImagePull(time.Minute)
+	if pull := imagePull(); pull != time.Minute {
+		t.Errorf("expected an image pull of %s, got %s", time.Minute, pull)
+	}
+}
+
 func initTest() *testCtx {
 	conn := db.New()
 	md, dk := docker.NewMock()
@@ -0,0 +1,8 @@
+var infrastructure = require("github.com/NetSys/quilt/quilt-tester/config/infrastructure");
+
+var deployment = createDeployment({});
+deployment.deploy(infrastructure);
+
+var nWorker = 3;
+var pause = new Service("pause", new Container("google/pause").replicate(nWorker));
+deployment.deploy(pause);
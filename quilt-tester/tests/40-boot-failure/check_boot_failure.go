@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/NetSys/quilt/api"
+	"github.com/NetSys/quilt/api/client"
+	"github.com/NetSys/quilt/api/client/getter"
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/faults"
+)
+
+// The number of boot attempts that fail before the replacement worker boots.
+const bootFailures = 2
+
+// Checks that the daemon replaces a worker whose spot instance was terminated, even
+// if the provider fails to boot its replacement a few times, and that its
+// containers are rescheduled.
+func main() {
+	clientGetter := getter.New()
+	c, err := clientGetter.Client(api.DefaultSocket)
+	if err != nil {
+		log.WithError(err).Fatal("FAILED, couldn't get quiltctl client")
+	}
+	defer c.Close()
+
+	machines, err := c.QueryMachines()
+	if err != nil {
+		log.WithError(err).Fatal("FAILED, couldn't query the machines")
+	}
+
+	var victim db.Machine
+	for _, m := range machines {
+		if m.Role == db.Worker && m.PublicIP != "" {
+			victim = m
+			break
+		}
+	}
+	if victim.PublicIP == "" {
+		log.Fatal("FAILED, no booted worker to terminate")
+	}
+	fmt.Println("Terminating", victim)
+
+	err = c.InjectFault(faults.Fault{BootFailures: bootFailures})
+	if err != nil {
+		log.WithError(err).Fatal("FAILED, couldn't inject boot failures")
+	}
+
+	// Shutting down a spot instance terminates it.  The SSH session is cut off
+	// when the machine goes down, so its error is expected.
+	exec.Command("quilt", "ssh", strconv.Itoa(victim.ID), "sudo", "shutdown",
+		"-h", "now").Run()
+
+	err = waitFor(func() bool { return recovered(clientGetter, c, victim) },
+		20*time.Minute)
+	if err != nil {
+		fmt.Println("FAILED, the cluster didn't recover from the terminated " +
+			"worker")
+		return
+	}
+	fmt.Println("PASSED")
+}
+
+// recovered returns true if `victim` has been replaced by a booted machine, and all
+// containers are running.
+func recovered(clientGetter client.Getter, c client.Client, victim db.Machine) bool {
+	machines, err := c.QueryMachines()
+	if err != nil {
+		log.WithError(err).Warn("Failed to query machines")
+		return false
+	}
+
+	for _, m := range machines {
+		if m.CloudID == victim.CloudID || m.PublicIP == "" {
+			return false
+		}
+	}
+
+	leader, err := clientGetter.LeaderClient(c)
+	if err != nil {
+		log.WithError(err).Warn("Failed to get leader client")
+		return false
+	}
+	defer leader.Close()
+
+	containers, err := leader.QueryContainers()
+	if err != nil {
+		log.WithError(err).Warn("Failed to query containers")
+		return false
+	}
+
+	for _, dbc := range containers {
+		if dbc.Minion == "" || dbc.Minion == victim.PrivateIP ||
+			dbc.DockerID == "" {
+			return false
+		}
+	}
+	return len(containers) > 0
+}
+
+func waitFor(pred func() bool, timeout time.Duration) error {
+	timeoutChan := time.After(timeout)
+	for {
+		select {
+		case <-timeoutChan:
+			return errors.New("timed out")
+		case <-time.After(15 * time.Second):
+			if pred() {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/NetSys/quilt/api"
+	"github.com/NetSys/quilt/api/client"
+	"github.com/NetSys/quilt/api/client/getter"
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/faults"
+)
+
+// How long the delayed container start, which stands in for a slow image pull,
+// takes.
+const startDelay = 5 * time.Minute
+
+// Checks that a container that's slow to start doesn't hold up the other containers
+// on its worker.
+func main() {
+	clientGetter := getter.New()
+	c, err := clientGetter.Client(api.DefaultSocket)
+	if err != nil {
+		log.WithError(err).Fatal("FAILED, couldn't get quiltctl client")
+	}
+	defer c.Close()
+
+	leader, err := clientGetter.LeaderClient(c)
+	if err != nil {
+		log.WithError(err).Fatal("FAILED, couldn't get leader client")
+	}
+	defer leader.Close()
+
+	machines, err := c.QueryMachines()
+	if err != nil {
+		log.WithError(err).Fatal("FAILED, couldn't query the machines")
+	}
+
+	containers, err := leader.QueryContainers()
+	if err != nil {
+		log.WithError(err).Fatal("FAILED, couldn't query the containers")
+	}
+
+	worker, old := busiestWorker(machines, containers)
+	if len(old) < 2 {
+		log.Fatal("FAILED, no worker runs more than one container")
+	}
+	fmt.Printf("Restarting %d containers on %s\n", len(old), worker)
+
+	minion, err := clientGetter.Client(api.RemoteAddress(worker.PublicIP))
+	if err != nil {
+		log.WithError(err).Fatal("FAILED, couldn't get minion client")
+	}
+	defer minion.Close()
+
+	err = minion.InjectFault(faults.Fault{
+		DelayedDockerStarts: 1,
+		DockerStartDelay:    startDelay,
+	})
+	if err != nil {
+		log.WithError(err).Fatal("FAILED, couldn't inject a slow start")
+	}
+
+	output, err := exec.Command("quilt", "ssh", strconv.Itoa(worker.ID),
+		"docker rm -f $(docker ps -q --filter label=quilt=scheduler)").
+		CombinedOutput()
+	if err != nil {
+		fmt.Println(string(output))
+		log.WithError(err).Fatal("FAILED, couldn't remove the containers")
+	}
+
+	// All but the delayed container should restart well before it does.
+	err = waitFor(func() bool {
+		return restarted(leader, worker, old) >= len(old)-1
+	}, startDelay/2)
+	if err != nil {
+		fmt.Println("FAILED, a slow container start blocked the others")
+		return
+	}
+
+	err = waitFor(func() bool {
+		return restarted(leader, worker, old) == len(old)
+	}, 2*startDelay)
+	if err != nil {
+		fmt.Println("FAILED, the delayed container never started")
+		return
+	}
+	fmt.Println("PASSED")
+}
+
+// busiestWorker returns the worker running the most containers, and the Docker IDs
+// of those containers.
+func busiestWorker(machines []db.Machine, containers []db.Container) (db.Machine,
+	map[string]struct{}) {
+
+	var worker db.Machine
+	var dockerIDs map[string]struct{}
+	for _, m := range machines {
+		if m.Role != db.Worker || m.PublicIP == "" {
+			continue
+		}
+
+		ids := map[string]struct{}{}
+		for _, dbc := range containers {
+			if dbc.Minion == m.PrivateIP && dbc.DockerID != "" {
+				ids[dbc.DockerID] = struct{}{}
+			}
+		}
+
+		if len(ids) > len(dockerIDs) {
+			worker, dockerIDs = m, ids
+		}
+	}
+	return worker, dockerIDs
+}
+
+// restarted returns the number of containers on `worker` that have been started
+// since the containers with the Docker IDs in `old` were removed.
+func restarted(leader client.Client, worker db.Machine, old map[string]struct{}) int {
+	containers, err := leader.QueryContainers()
+	if err != nil {
+		log.WithError(err).Warn("Failed to query containers")
+		return 0
+	}
+
+	var count int
+	for _, dbc := range containers {
+		if _, ok := old[dbc.DockerID]; dbc.Minion == worker.PrivateIP &&
+			dbc.DockerID != "" && !ok {
+			count++
+		}
+	}
+	return count
+}
+
+func waitFor(pred func() bool, timeout time.Duration) error {
+	timeoutChan := time.After(timeout)
+	for {
+		select {
+		case <-timeoutChan:
+			return errors.New("timed out")
+		case <-time.After(15 * time.Second):
+			if pred() {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,9 @@
+var infrastructure = require("github.com/NetSys/quilt/quilt-tester/config/infrastructure");
+
+var deployment = createDeployment({});
+deployment.deploy(infrastructure);
+
+var nWorker = 3;
+var pause = new Service("pause",
+    new Container("google/pause").replicate(4 * nWorker));
+deployment.deploy(pause);
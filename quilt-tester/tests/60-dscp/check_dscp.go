@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/NetSys/quilt/api"
+	"github.com/NetSys/quilt/api/client/getter"
+	"github.com/NetSys/quilt/db"
+)
+
+// The TOS byte of packets marked with DSCP 46, which occupies its upper six bits.
+const expectedTOS = "tos 0xb8"
+
+// Checks that the packets the sender pings the receiver with arrive marked with the
+// DSCP value of their connection.
+func main() {
+	clientGetter := getter.New()
+	c, err := clientGetter.Client(api.DefaultSocket)
+	if err != nil {
+		log.WithError(err).Fatal("FAILED, couldn't get quiltctl client")
+	}
+	defer c.Close()
+
+	leader, err := clientGetter.LeaderClient(c)
+	if err != nil {
+		log.WithError(err).Fatal("FAILED, couldn't get leader client")
+	}
+	defer leader.Close()
+
+	containers, err := leader.QueryContainers()
+	if err != nil {
+		log.WithError(err).Fatal("FAILED, couldn't query the containers")
+	}
+
+	sender, ok := withLabel(containers, "sender")
+	if !ok {
+		log.Fatal("FAILED, no sender container")
+	}
+	receiver, ok := withLabel(containers, "receiver")
+	if !ok {
+		log.Fatal("FAILED, no receiver container")
+	}
+
+	// Capture a single ICMP echo request on the receiver while the sender pings
+	// it.
+	captured := make(chan string, 1)
+	go func() {
+		output, _ := exec.Command("quilt", "exec",
+			strconv.Itoa(receiver.StitchID), "timeout", "60", "tcpdump",
+			"-c", "1", "-v", "-n", "-i", "eth0", "icmp[icmptype] == 8").
+			CombinedOutput()
+		captured <- string(output)
+	}()
+
+	// Give tcpdump time to start before pinging.
+	time.Sleep(10 * time.Second)
+	output, err := exec.Command("quilt", "exec", strconv.Itoa(sender.StitchID),
+		"ping", "-c", "5", "-W", "1", "receiver.q").CombinedOutput()
+	if err != nil {
+		fmt.Println(string(output))
+		log.WithError(err).Fatal("FAILED, sender couldn't ping the receiver")
+	}
+
+	capture := <-captured
+	fmt.Println(capture)
+	if !strings.Contains(capture, expectedTOS) {
+		log.Fatalf("FAILED, the sender's packets weren't marked with %s",
+			expectedTOS)
+	}
+	fmt.Println("PASSED")
+}
+
+func withLabel(containers []db.Container, label string) (db.Container, bool) {
+	for _, dbc := range containers {
+		for _, l := range dbc.Labels {
+			if l == label {
+				return dbc, true
+			}
+		}
+	}
+	return db.Container{}, false
+}
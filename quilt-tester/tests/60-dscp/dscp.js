@@ -0,0 +1,15 @@
+var infrastructure = require("github.com/NetSys/quilt/quilt-tester/config/infrastructure")
+
+var deployment = createDeployment({});
+deployment.deploy(infrastructure);
+
+var sender = new Service("sender",
+    [new Container("alpine", ["tail", "-f", "/dev/null"])]);
+var receiver = new Service("receiver", [new Container("alpine",
+    ["sh", "-c", "apk add --no-cache tcpdump && tail -f /dev/null"])]);
+
+// Expedited forwarding.
+sender.connect(new Icmp(), receiver, {dscp: 46});
+
+deployment.deploy(sender);
+deployment.deploy(receiver);
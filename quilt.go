@@ -26,7 +26,12 @@ func main() {
 			"stop <namespace> | get <import_path> | " +
 			"machines | containers | ps | ssh <machine> | " +
 			"exec <container> <command> | " +
-			"logs <container>]")
+			"logs <container> | counters [machine] | export | " +
+			"freeze-machines on|off | drain <machine> | status | " +
+			"nettest <from_label> <to_label>:<port> | " +
+			"promote-canary [-abort] <label> | " +
+			"tunnel [<local_port>:]<label>:<port> ... | " +
+			"vendor <stitch> | check [-frozen-vendor] <stitch>]")
 		fmt.Println("\nWhen provided a stitch, quilt takes responsibility\n" +
 			"for deploying it as specified.  Alternatively, quilt may be\n" +
 			"instructed to stop all deployments in a given namespace,\n" +
@@ -0,0 +1,77 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/NetSys/quilt/api/client"
+	"github.com/NetSys/quilt/api/client/getter"
+)
+
+// PromoteCanary contains the options for promoting or aborting a label's canaries.
+type PromoteCanary struct {
+	label string
+	abort bool
+
+	common       *commonFlags
+	clientGetter client.Getter
+}
+
+// NewPromoteCanaryCommand creates a new PromoteCanary command instance.
+func NewPromoteCanaryCommand() *PromoteCanary {
+	return &PromoteCanary{
+		clientGetter: getter.New(),
+		common:       &commonFlags{},
+	}
+}
+
+// InstallFlags sets up parsing for command line flags.
+func (pCmd *PromoteCanary) InstallFlags(flags *flag.FlagSet) {
+	pCmd.common.InstallFlags(flags)
+	flags.BoolVar(&pCmd.abort, "abort", false,
+		"remove the canaries rather than promote them")
+
+	flags.Usage = func() {
+		fmt.Println("usage: quilt promote-canary [-H=<daemon_host>] [-abort] " +
+			"<label>")
+		fmt.Println("`promote-canary` rewrites the deployed Stitch so that the " +
+			"label's containers all run the image of its canaries, which " +
+			"then become ordinary members of the label.  The label's other " +
+			"containers are restarted with the new image.  With -abort, " +
+			"only the canaries are removed instead.")
+		flags.PrintDefaults()
+	}
+}
+
+// Parse parses the command line arguments for the promote-canary command.
+func (pCmd *PromoteCanary) Parse(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected exactly one label, got %d arguments",
+			len(args))
+	}
+
+	pCmd.label = args[0]
+	return nil
+}
+
+// Run promotes or aborts the canaries.
+func (pCmd *PromoteCanary) Run() int {
+	c, err := pCmd.clientGetter.Client(pCmd.common.host)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	defer c.Close()
+
+	if err := c.PromoteCanary(pCmd.label, pCmd.abort); err != nil {
+		action := "promote"
+		if pCmd.abort {
+			action = "abort"
+		}
+		log.WithError(err).Errorf("Unable to %s canary.", action)
+		return 1
+	}
+	return 0
+}
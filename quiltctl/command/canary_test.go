@@ -0,0 +1,51 @@
+package command
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	clientMock "github.com/NetSys/quilt/api/client/mocks"
+	"github.com/NetSys/quilt/quiltctl/testutils"
+)
+
+func TestPromoteCanaryFlags(t *testing.T) {
+	t.Parallel()
+
+	cmd := NewPromoteCanaryCommand()
+	assert.NoError(t, parseHelper(cmd, []string{"-H", "IP", "web"}))
+	assert.Equal(t, "IP", cmd.common.host)
+	assert.Equal(t, "web", cmd.label)
+	assert.False(t, cmd.abort)
+
+	cmd = NewPromoteCanaryCommand()
+	assert.NoError(t, parseHelper(cmd, []string{"-abort", "web"}))
+	assert.Equal(t, "web", cmd.label)
+	assert.True(t, cmd.abort)
+
+	assert.EqualError(t, parseHelper(NewPromoteCanaryCommand(), []string{}),
+		"expected exactly one label, got 0 arguments")
+}
+
+func TestPromoteCanary(t *testing.T) {
+	t.Parallel()
+
+	c := new(clientMock.Client)
+	mockGetter := new(testutils.Getter)
+	mockGetter.On("Client", mock.Anything).Return(c, nil)
+
+	cmd := &PromoteCanary{label: "web", common: &commonFlags{},
+		clientGetter: mockGetter}
+	assert.Equal(t, 0, cmd.Run())
+	assert.Equal(t, []string{"web"}, c.PromoteCanaryArgs)
+	assert.Empty(t, c.AbortCanaryArgs)
+
+	cmd.abort = true
+	assert.Equal(t, 0, cmd.Run())
+	assert.Equal(t, []string{"web"}, c.AbortCanaryArgs)
+
+	c.PromoteCanaryErr = errors.New("error")
+	assert.Equal(t, 1, cmd.Run())
+}
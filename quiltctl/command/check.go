@@ -0,0 +1,81 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/NetSys/quilt/stitch"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/robertkrimen/otto"
+)
+
+// Check contains the options for checking that Stitches evaluate.
+type Check struct {
+	stitch       string
+	frozenVendor bool
+
+	// Stored in a field so that it may be mocked.
+	getter stitch.ImportGetter
+}
+
+// NewCheckCommand creates a new Check command instance.
+func NewCheckCommand() *Check {
+	return &Check{getter: stitch.DefaultImportGetter}
+}
+
+// InstallFlags sets up parsing for command line flags.
+func (cCmd *Check) InstallFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&cCmd.frozenVendor, "frozen-vendor", false, "refuse to "+
+		"evaluate the stitch if any import isn't vendored, or would need "+
+		"the network")
+
+	flags.Usage = func() {
+		fmt.Println("usage: quilt check [-frozen-vendor] <stitch>")
+		fmt.Println("`check` evaluates the stitch without deploying it, and " +
+			"fails if it's invalid.  Imports are resolved from the " +
+			"vendored copies made by `quilt vendor`, if any.  With " +
+			"`-frozen-vendor`, every import must be vendored, which " +
+			"suits continuous integration.")
+		flags.PrintDefaults()
+	}
+}
+
+// Parse parses the command line arguments for the check command.
+func (cCmd *Check) Parse(args []string) error {
+	if len(args) != 1 {
+		return errors.New("must specify exactly one stitch to check")
+	}
+	cCmd.stitch = args[0]
+	return nil
+}
+
+// Run evaluates the stitch.
+func (cCmd *Check) Run() int {
+	err := cCmd.check()
+	if err != nil {
+		// Print the stacktrace if it's an Otto error.
+		if ottoError, ok := err.(*otto.Error); ok {
+			log.Error(ottoError.String())
+		} else {
+			log.Error(err)
+		}
+		return 1
+	}
+
+	fmt.Printf("%s is valid.\n", cCmd.stitch)
+	return 0
+}
+
+func (cCmd *Check) check() error {
+	getter, err := cCmd.getter.WithVendor(filepath.Dir(cCmd.stitch),
+		cCmd.frozenVendor)
+	if err != nil {
+		return err
+	}
+
+	_, err = stitch.FromFile(cCmd.stitch, getter)
+	return err
+}
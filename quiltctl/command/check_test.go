@@ -0,0 +1,51 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/NetSys/quilt/stitch"
+	"github.com/NetSys/quilt/util"
+)
+
+func TestCheckFlags(t *testing.T) {
+	t.Parallel()
+
+	cmd := NewCheckCommand()
+	assert.NoError(t, parseHelper(cmd, []string{"-frozen-vendor", "main.js"}))
+	assert.True(t, cmd.frozenVendor)
+	assert.Equal(t, "main.js", cmd.stitch)
+
+	assert.EqualError(t, parseHelper(NewCheckCommand(), []string{}),
+		"must specify exactly one stitch to check")
+}
+
+func TestCheck(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+
+	util.WriteFile("/quilt/github.com/quilt/nginx/index.js", []byte(
+		`exports.n = 2;`), 0644)
+	util.WriteFile("/specs/main.js", []byte(
+		`var n = require("github.com/quilt/nginx").n;
+		deployment.deploy(new Service("web",
+			new Container("nginx").replicate(n)));`), 0644)
+	util.WriteFile("/specs/broken.js", []byte(`require("missing");`), 0644)
+
+	getter := stitch.ImportGetter{Path: "/quilt"}
+	check := func(spec string, frozen bool) int {
+		cmd := &Check{stitch: spec, frozenVendor: frozen, getter: getter}
+		return cmd.Run()
+	}
+
+	assert.Equal(t, 0, check("/specs/main.js", false))
+	assert.Equal(t, 1, check("/specs/broken.js", false))
+
+	// Without vendored copies, frozen checks can't import the repo.
+	assert.Equal(t, 1, check("/specs/main.js", true))
+
+	_, err := stitch.Vendor("/specs/main.js", getter)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, check("/specs/main.js", true))
+}
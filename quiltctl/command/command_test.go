@@ -6,12 +6,15 @@ import (
 	"flag"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
 	clientMock "github.com/NetSys/quilt/api/client/mocks"
+	"github.com/NetSys/quilt/api/pb"
 	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/minion/supervisor"
 	"github.com/NetSys/quilt/quiltctl/testutils"
 )
 
@@ -37,10 +40,30 @@ func TestMachineOutput(t *testing.T) {
 		Region:   "us-west-1",
 		Size:     "m4.large",
 		PublicIP: "8.8.8.8",
+	}, {
+		ID:       2,
+		Role:     db.Worker,
+		Provider: "Amazon",
+		Region:   "us-west-1",
+		Size:     "m4.large",
+		PublicIP: "9.9.9.9",
+		Draining: true,
+	}, {
+		ID:       3,
+		Role:     db.Worker,
+		Provider: "Amazon",
+		Region:   "us-west-1",
+		Size:     "m4.large",
+		PublicIP: "7.7.7.7",
+		SupervisorStatus: map[string]db.ComponentStatus{
+			"ovs-vswitchd": {State: db.ComponentUnhealthy, Restarts: 2},
+			"etcd":         {State: db.ComponentHealthy, Restarts: 1},
+			"ovsdb-server": {State: db.ComponentHealthy},
+		},
 	}}
 
 	var b bytes.Buffer
-	writeMachines(&b, machines)
+	writeMachines(&b, machines, false)
 	result := string(b.Bytes())
 
 	/* By replacing space with underscore, we make the spaces explicit and whitespace
@@ -48,11 +71,43 @@ func TestMachineOutput(t *testing.T) {
 	result = strings.Replace(result, " ", "_", -1)
 
 	exp := `ID____ROLE______PROVIDER____REGION_______SIZE` +
-		`________PUBLIC_IP____CONNECTED
-1_____Master____Amazon______us-west-1____m4.large____8.8.8.8______false
+		`________PUBLIC_IP____CONNECTED____STATUS
+1_____Master____Amazon______us-west-1____m4.large____8.8.8.8______false________
+2_____Worker____Amazon______us-west-1____m4.large____9.9.9.9______false________DRAINING
+3_____Worker____Amazon______us-west-1____m4.large____7.7.7.7______false________` +
+		`etcd_healthy_(1_restarts),_ovs-vswitchd_unhealthy_(2_restarts)
 `
 
 	assert.Equal(t, exp, result)
+
+	b.Reset()
+	writeMachines(&b, machines, true)
+	result = strings.Replace(string(b.Bytes()), " ", "_", -1)
+
+	exp = "FROZEN:_machines_won't_be_booted_or_terminated_until_" +
+		"`quilt_freeze-machines_off`\n" + exp
+	assert.Equal(t, exp, result)
+}
+
+func TestMinionFlags(t *testing.T) {
+	t.Parallel()
+
+	minionCmd := &Minion{}
+	err := parseHelper(minionCmd, []string{"-probe-interval", "1m",
+		"-probe-failures", "5"})
+	assert.NoError(t, err)
+	assert.Equal(t, supervisor.ProbeConfig{Interval: time.Minute,
+		FailureThreshold: 5}, minionCmd.probeConfig)
+
+	minionCmd = &Minion{}
+	assert.NoError(t, parseHelper(minionCmd, nil))
+	assert.Equal(t, supervisor.DefaultProbeConfig, minionCmd.probeConfig)
+
+	err = parseHelper(&Minion{}, []string{"-probe-interval", "0s"})
+	assert.EqualError(t, err, "-probe-interval must be positive")
+
+	err = parseHelper(&Minion{}, []string{"-probe-failures", "0"})
+	assert.EqualError(t, err, "-probe-failures must be at least 1")
 }
 
 func TestContainerFlags(t *testing.T) {
@@ -74,7 +129,7 @@ func TestContainerOutput(t *testing.T) {
 		{ID: 1, StitchID: 3, Minion: "3.3.3.3", Image: "image1",
 			Command: []string{"cmd", "1"}},
 		{ID: 2, StitchID: 1, Minion: "1.1.1.1", Image: "image2",
-			Labels: []string{"label1", "label2"}},
+			Labels: []string{"label1", "label2"}, ActualState: "running"},
 		{ID: 3, StitchID: 4, Minion: "1.1.1.1", Image: "image3",
 			Command: []string{"cmd"},
 			Labels:  []string{"label1"}},
@@ -102,15 +157,16 @@ func TestContainerOutput(t *testing.T) {
 	/* By replacing space with underscore, we make the spaces explicit and whitespace
 	* errors easier to debug. */
 	result = strings.Replace(result, " ", "_", -1)
-	expected := `ID____MACHINE______CONTAINER_________LABELS____________PUBLIC_IP
-3__________________image1_cmd_1________________________
-_______________________________________________________
-1_____Machine-5____image2____________label1,_label2____7.7.7.7:80
-4_____Machine-5____image3_cmd________label1____________7.7.7.7:80
-_______________________________________________________
-7_____Machine-6____image1_cmd_3_4____label1____________
-_______________________________________________________
-8_____Machine-7____image1______________________________
+	expected := `ID____MACHINE______CONTAINER_________LABELS____________` +
+		`STATUS________PUBLIC_IP
+3__________________image1_cmd_1________________________scheduled_____
+_____________________________________________________________________
+1_____Machine-5____image2____________label1,_label2____running_______7.7.7.7:80
+4_____Machine-5____image3_cmd________label1____________scheduled_____7.7.7.7:80
+_____________________________________________________________________
+7_____Machine-6____image1_cmd_3_4____label1____________scheduled_____
+_____________________________________________________________________
+8_____Machine-7____image1______________________________scheduling____
 `
 
 	assert.Equal(t, expected, result)
@@ -130,6 +186,16 @@ func TestContainerStr(t *testing.T) {
 		containerStr("container", []string{"arg0", "arg1"}))
 }
 
+func TestStatusStr(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "scheduling", statusStr(db.Container{}))
+	assert.Equal(t, "scheduled", statusStr(db.Container{Minion: "1.2.3.4"}))
+	assert.Equal(t, "exited", statusStr(db.Container{Minion: "1.2.3.4",
+		ActualState: "exited"}))
+	assert.Equal(t, "removing", statusStr(db.Container{Minion: "1.2.3.4",
+		DesiredState: db.DesiredRemoved, ActualState: "running"}))
+}
+
 func TestPublicIPStr(t *testing.T) {
 	t.Parallel()
 	assert.Equal(t, "", publicIPStr("", nil))
@@ -240,6 +306,67 @@ func TestStopNamespace(t *testing.T) {
 	assert.Equal(t, expStitch, c.DeployArg)
 }
 
+func TestCountersFlags(t *testing.T) {
+	t.Parallel()
+
+	countersCmd := NewCountersCommand()
+	assert.NoError(t, parseHelper(countersCmd, []string{"2"}))
+	assert.Equal(t, 2, countersCmd.targetMachine)
+
+	countersCmd = NewCountersCommand()
+	assert.NoError(t, parseHelper(countersCmd, []string{}))
+	assert.Equal(t, 0, countersCmd.targetMachine)
+
+	assert.EqualError(t, parseHelper(NewCountersCommand(), []string{"foo"}),
+		"target machine must be a number: foo")
+}
+
+func TestCountersOutput(t *testing.T) {
+	t.Parallel()
+
+	counters := []pb.Counter{
+		{Pkg: "network", Name: "Update NAT", Value: 10, PrevValue: 4},
+		{Pkg: "scheduler", Name: "Loop", Value: 7, PrevValue: 7},
+	}
+
+	var b bytes.Buffer
+	writeCounters(&b, counters)
+	result := strings.Replace(b.String(), " ", "_", -1)
+
+	exp := `PACKAGE______COUNTER_______VALUE____DELTA
+network______Update_NAT____10_______6
+scheduler____Loop__________7________0
+`
+	assert.Equal(t, exp, result)
+}
+
+func TestQueryMinionCounters(t *testing.T) {
+	t.Parallel()
+
+	exp := []pb.Counter{{Pkg: "scheduler", Name: "Loop", Value: 1}}
+	c := &clientMock.Client{
+		MachineReturn: []db.Machine{
+			{ID: 1, PublicIP: "8.8.8.8"},
+			{ID: 2},
+		},
+		MinionCountersReturn: map[string][]pb.Counter{"8.8.8.8": exp},
+	}
+
+	countersCmd := NewCountersCommand()
+	countersCmd.targetMachine = 1
+	counters, err := countersCmd.queryMinionCounters(c)
+	assert.NoError(t, err)
+	assert.Equal(t, exp, counters)
+
+	countersCmd.targetMachine = 2
+	_, err = countersCmd.queryMinionCounters(c)
+	assert.EqualError(t, err, "machine 2 has no public IP")
+
+	countersCmd.targetMachine = 3
+	_, err = countersCmd.queryMinionCounters(c)
+	assert.EqualError(t, err, "unable to find machine `3`")
+}
+
 func TestSSHCommandCreation(t *testing.T) {
 	t.Parallel()
 
@@ -89,11 +89,11 @@ func writeContainers(fd io.Writer, containers []db.Container, machines []db.Mach
 	connections []db.Connection) {
 	w := tabwriter.NewWriter(fd, 0, 0, 4, ' ', 0)
 	defer w.Flush()
-	fmt.Fprintln(w, "ID\tMACHINE\tCONTAINER\tLABELS\tPUBLIC IP")
+	fmt.Fprintln(w, "ID\tMACHINE\tCONTAINER\tLABELS\tSTATUS\tPUBLIC IP")
 
 	labelPublicPortMap := map[string]string{}
 	for _, c := range connections {
-		if c.From != "public" {
+		if c.From != "public" || c.Protocol == "icmp" {
 			continue
 		}
 
@@ -129,7 +129,7 @@ func writeContainers(fd io.Writer, containers []db.Container, machines []db.Mach
 			// Insert a blank line between each machine.
 			// Need to print tabs in a blank line; otherwise, spacing will
 			// change in subsequent lines.
-			fmt.Fprintf(w, "\t\t\t\t\n")
+			fmt.Fprintf(w, "\t\t\t\t\t\n")
 		}
 
 		for _, dbc := range db.SortContainers(machineDBC[machineID]) {
@@ -143,11 +143,12 @@ func writeContainers(fd io.Writer, containers []db.Container, machines []db.Mach
 			machine := machineStr(machineID)
 			container := containerStr(dbc.Image, dbc.Command)
 			labels := strings.Join(dbc.Labels, ", ")
+			status := statusStr(dbc)
 			publicIP := publicIPStr(idMachineMap[machineID].PublicIP,
 				publicPorts)
 
-			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n",
-				dbc.StitchID, machine, container, labels, publicIP)
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", dbc.StitchID,
+				machine, container, labels, status, publicIP)
 		}
 	}
 }
@@ -165,6 +166,22 @@ func containerStr(image string, args []string) string {
 	return fmt.Sprintf("%s %s", image, strings.Join(args, " "))
 }
 
+// statusStr describes how far along a container is: not yet placed on a minion,
+// placed but not yet reported by Docker, or the state Docker reports.  Containers that
+// are no longer declared are being removed.
+func statusStr(dbc db.Container) string {
+	switch {
+	case dbc.DesiredState == db.DesiredRemoved:
+		return "removing"
+	case dbc.Minion == "":
+		return "scheduling"
+	case dbc.ActualState == "":
+		return "scheduled"
+	default:
+		return dbc.ActualState
+	}
+}
+
 func publicIPStr(hostPublicIP string, publicPorts []string) string {
 	if hostPublicIP == "" || len(publicPorts) == 0 {
 		return ""
@@ -0,0 +1,116 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/NetSys/quilt/api/client"
+	"github.com/NetSys/quilt/api/client/getter"
+	"github.com/NetSys/quilt/api/pb"
+)
+
+// Counters contains the options for querying debugging counters.
+type Counters struct {
+	targetMachine int
+
+	common       *commonFlags
+	clientGetter client.Getter
+}
+
+// NewCountersCommand creates a new Counters command instance.
+func NewCountersCommand() *Counters {
+	return &Counters{
+		clientGetter: getter.New(),
+		common:       &commonFlags{},
+	}
+}
+
+// InstallFlags sets up parsing for command line flags.
+func (cCmd *Counters) InstallFlags(flags *flag.FlagSet) {
+	cCmd.common.InstallFlags(flags)
+
+	flags.Usage = func() {
+		fmt.Println("usage: quilt counters [-H=<daemon_host>] [machine_num]")
+		fmt.Println("`counters` prints the debugging counters of the Quilt " +
+			"daemon, or of the minion on the given machine.  The machine " +
+			"is identified by the database ID produced by `quilt machines`.")
+		fmt.Println("The DELTA column is the change in each counter since the " +
+			"last time it was queried.")
+		flags.PrintDefaults()
+	}
+}
+
+// Parse parses the command line arguments for the counters command.
+func (cCmd *Counters) Parse(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	targetMachine, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("target machine must be a number: %s", args[0])
+	}
+
+	cCmd.targetMachine = targetMachine
+	return nil
+}
+
+// Run retrieves and prints the requested counters.
+func (cCmd *Counters) Run() int {
+	c, err := cCmd.clientGetter.Client(cCmd.common.host)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	defer c.Close()
+
+	var counters []pb.Counter
+	if cCmd.targetMachine == 0 {
+		counters, err = c.QueryCounters()
+	} else {
+		counters, err = cCmd.queryMinionCounters(c)
+	}
+
+	if err != nil {
+		log.WithError(err).Error("Unable to query counters.")
+		return 1
+	}
+
+	writeCounters(os.Stdout, counters)
+	return 0
+}
+
+func (cCmd *Counters) queryMinionCounters(c client.Client) ([]pb.Counter, error) {
+	machines, err := c.QueryMachines()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range machines {
+		if m.ID == cCmd.targetMachine {
+			if m.PublicIP == "" {
+				return nil, fmt.Errorf("machine %d has no public IP",
+					m.ID)
+			}
+			return c.QueryMinionCounters(m.PublicIP)
+		}
+	}
+	return nil, fmt.Errorf("unable to find machine `%d`", cCmd.targetMachine)
+}
+
+func writeCounters(fd io.Writer, counters []pb.Counter) {
+	w := tabwriter.NewWriter(fd, 0, 0, 4, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "PACKAGE\tCOUNTER\tVALUE\tDELTA")
+
+	for _, c := range counters {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", c.Pkg, c.Name, c.Value,
+			c.Value-c.PrevValue)
+	}
+}
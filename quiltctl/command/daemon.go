@@ -8,10 +8,13 @@ import (
 	"github.com/NetSys/quilt/cluster"
 	"github.com/NetSys/quilt/db"
 	"github.com/NetSys/quilt/engine"
+	"github.com/NetSys/quilt/faults"
 )
 
 // Daemon contains the options for running the Quilt daemon.
 type Daemon struct {
+	faultInjection bool
+
 	common *commonFlags
 }
 
@@ -25,6 +28,8 @@ func NewDaemonCommand() *Daemon {
 // InstallFlags sets up parsing for command line flags
 func (dCmd *Daemon) InstallFlags(flags *flag.FlagSet) {
 	dCmd.common.InstallFlags(flags)
+	flags.BoolVar(&dCmd.faultInjection, faultInjectionFlag, false,
+		faultInjectionUsage)
 	flags.Usage = func() {
 		fmt.Println("usage: quilt daemon [-H=<daemon_host>]")
 		fmt.Println("`daemon` starts the quilt daemon, which listens for" +
@@ -41,6 +46,10 @@ func (dCmd *Daemon) Parse(args []string) error {
 
 // Run starts the daemon.
 func (dCmd *Daemon) Run() int {
+	if dCmd.faultInjection {
+		faults.Enable()
+	}
+
 	conn := db.New()
 	go engine.Run(conn)
 	go server.Run(conn, dCmd.common.host)
@@ -0,0 +1,74 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/NetSys/quilt/api/client"
+	"github.com/NetSys/quilt/api/client/getter"
+)
+
+// Drain contains the options for draining a machine.
+type Drain struct {
+	targetMachine int
+
+	common       *commonFlags
+	clientGetter client.Getter
+}
+
+// NewDrainCommand creates a new Drain command instance.
+func NewDrainCommand() *Drain {
+	return &Drain{
+		clientGetter: getter.New(),
+		common:       &commonFlags{},
+	}
+}
+
+// InstallFlags sets up parsing for command line flags.
+func (dCmd *Drain) InstallFlags(flags *flag.FlagSet) {
+	dCmd.common.InstallFlags(flags)
+
+	flags.Usage = func() {
+		fmt.Println("usage: quilt drain [-H=<daemon_host>] <machine_num>")
+		fmt.Println("`drain` moves the containers off of the specified " +
+			"machine, then terminates it.  If the deployed Stitch still " +
+			"calls for the machine, a replacement is booted.  The machine " +
+			"is identified by the database ID produced by `quilt machines`.")
+		flags.PrintDefaults()
+	}
+}
+
+// Parse parses the command line arguments for the drain command.
+func (dCmd *Drain) Parse(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected exactly one machine, got %d arguments",
+			len(args))
+	}
+
+	targetMachine, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("target machine must be a number: %s", args[0])
+	}
+
+	dCmd.targetMachine = targetMachine
+	return nil
+}
+
+// Run drains the machine.
+func (dCmd *Drain) Run() int {
+	c, err := dCmd.clientGetter.Client(dCmd.common.host)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	defer c.Close()
+
+	if err := c.DrainMachine(dCmd.targetMachine); err != nil {
+		log.WithError(err).Error("Unable to drain machine.")
+		return 1
+	}
+	return 0
+}
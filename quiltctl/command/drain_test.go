@@ -0,0 +1,42 @@
+package command
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	clientMock "github.com/NetSys/quilt/api/client/mocks"
+	"github.com/NetSys/quilt/quiltctl/testutils"
+)
+
+func TestDrainFlags(t *testing.T) {
+	t.Parallel()
+
+	cmd := NewDrainCommand()
+	assert.NoError(t, parseHelper(cmd, []string{"-H", "IP", "3"}))
+	assert.Equal(t, "IP", cmd.common.host)
+	assert.Equal(t, 3, cmd.targetMachine)
+
+	assert.EqualError(t, parseHelper(NewDrainCommand(), []string{}),
+		"expected exactly one machine, got 0 arguments")
+	assert.EqualError(t, parseHelper(NewDrainCommand(), []string{"web"}),
+		"target machine must be a number: web")
+}
+
+func TestDrain(t *testing.T) {
+	t.Parallel()
+
+	c := new(clientMock.Client)
+	mockGetter := new(testutils.Getter)
+	mockGetter.On("Client", mock.Anything).Return(c, nil)
+
+	cmd := &Drain{targetMachine: 3, common: &commonFlags{},
+		clientGetter: mockGetter}
+	assert.Equal(t, 0, cmd.Run())
+	assert.Equal(t, []int{3}, c.DrainArgs)
+
+	c.DrainErr = errors.New("error")
+	assert.Equal(t, 1, cmd.Run())
+}
@@ -0,0 +1,94 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/NetSys/quilt/api/client"
+	"github.com/NetSys/quilt/api/client/getter"
+	"github.com/NetSys/quilt/export"
+	"github.com/NetSys/quilt/stitch"
+)
+
+// Export contains the options for exporting the deployment for audits.
+type Export struct {
+	format string
+
+	common       *commonFlags
+	clientGetter client.Getter
+}
+
+// NewExportCommand creates a new Export command instance.
+func NewExportCommand() *Export {
+	return &Export{
+		clientGetter: getter.New(),
+		common:       &commonFlags{},
+	}
+}
+
+// InstallFlags sets up parsing for command line flags.
+func (eCmd *Export) InstallFlags(flags *flag.FlagSet) {
+	eCmd.common.InstallFlags(flags)
+
+	flags.StringVar(&eCmd.format, "format", export.Terraform,
+		"the format to export to, terraform or cloudformation")
+
+	flags.Usage = func() {
+		fmt.Println("usage: quilt export [-H=<daemon_host>] " +
+			"[-format=<terraform|cloudformation>]")
+		fmt.Println("`export` prints the current deployment's machines and " +
+			"firewall rules as a Terraform or CloudFormation document, so " +
+			"that it can be reviewed with other infrastructure.")
+		fmt.Println("The document is for review only, and must not be " +
+			"applied.  Quilt manages the resources it describes.")
+		flags.PrintDefaults()
+	}
+}
+
+// Parse parses the command line arguments for the export command.
+func (eCmd *Export) Parse(args []string) error {
+	if len(args) > 0 {
+		return errors.New("export takes no arguments")
+	}
+	return nil
+}
+
+// Run prints the exported deployment.
+func (eCmd *Export) Run() int {
+	out, err := eCmd.run()
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	fmt.Print(out)
+	return 0
+}
+
+func (eCmd *Export) run() (string, error) {
+	c, err := eCmd.clientGetter.Client(eCmd.common.host)
+	if err != nil {
+		return "", fmt.Errorf("error connecting to quilt daemon: %s", err)
+	}
+	defer c.Close()
+
+	specStr, err := getCurrentDeployment(c)
+	if err != nil {
+		return "", fmt.Errorf("unable to query deployment: %s", err)
+	}
+
+	spec, err := stitch.FromJSON(specStr)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse deployment: %s", err)
+	}
+
+	machines, err := c.QueryMachines()
+	if err != nil {
+		return "", fmt.Errorf("unable to query machines: %s", err)
+	}
+
+	return export.Export(eCmd.format, spec, machines)
+}
@@ -0,0 +1,58 @@
+package command
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	clientMock "github.com/NetSys/quilt/api/client/mocks"
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/export"
+	"github.com/NetSys/quilt/quiltctl/testutils"
+)
+
+func TestExportFlags(t *testing.T) {
+	t.Parallel()
+
+	cmd := NewExportCommand()
+	assert.NoError(t, parseHelper(cmd, []string{"-format", "cloudformation"}))
+	assert.Equal(t, "cloudformation", cmd.format)
+
+	cmd = NewExportCommand()
+	assert.NoError(t, parseHelper(cmd, nil))
+	assert.Equal(t, "terraform", cmd.format)
+
+	assert.EqualError(t, parseHelper(NewExportCommand(), []string{"extra"}),
+		"export takes no arguments")
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	mockGetter := new(testutils.Getter)
+	mockClient := &clientMock.Client{
+		ClusterReturn: []db.Cluster{{Spec: `{"Namespace": "audit"}`}},
+		MachineReturn: []db.Machine{{ID: 1, Provider: db.Static,
+			Role: db.Master, PublicIP: "8.8.8.8"}},
+	}
+	mockGetter.On("Client", mock.Anything).Return(mockClient, nil)
+
+	cmd := &Export{export.Terraform, &commonFlags{}, mockGetter}
+	out, err := cmd.run()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "# Namespace: audit\n")
+	assert.Contains(t, out, "# Static machine 1 is not managed")
+
+	cmd.format = "pulumi"
+	_, err = cmd.run()
+	assert.EqualError(t, err, "unknown export format: pulumi")
+
+	mockGetter = new(testutils.Getter)
+	mockGetter.On("Client", mock.Anything).Return(&clientMock.Client{
+		MachineErr: errors.New("error")}, nil)
+	cmd = &Export{export.Terraform, &commonFlags{}, mockGetter}
+	_, err = cmd.run()
+	assert.EqualError(t, err, "unable to query machines: error")
+}
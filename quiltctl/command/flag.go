@@ -6,6 +6,13 @@ import (
 	"github.com/NetSys/quilt/api"
 )
 
+// The flag that allows the daemon and minion to be sent faults to inject, for
+// integration testing.  Anyone who can reach the API may then break them.
+const faultInjectionFlag = "insecure-fault-injection"
+
+const faultInjectionUsage = "allow failures to be injected over the API for " +
+	"testing (INSECURE)"
+
 type flagParser interface {
 	// InstallFlags sets up parsing for command line flags.
 	InstallFlags(*flag.FlagSet)
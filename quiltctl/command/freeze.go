@@ -0,0 +1,76 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/NetSys/quilt/api/client"
+	"github.com/NetSys/quilt/api/client/getter"
+)
+
+// FreezeMachines contains the options for freezing and thawing machines.
+type FreezeMachines struct {
+	frozen bool
+
+	common       *commonFlags
+	clientGetter client.Getter
+}
+
+// NewFreezeMachinesCommand creates a new FreezeMachines command instance.
+func NewFreezeMachinesCommand() *FreezeMachines {
+	return &FreezeMachines{
+		clientGetter: getter.New(),
+		common:       &commonFlags{},
+	}
+}
+
+// InstallFlags sets up parsing for command line flags.
+func (fCmd *FreezeMachines) InstallFlags(flags *flag.FlagSet) {
+	fCmd.common.InstallFlags(flags)
+
+	flags.Usage = func() {
+		fmt.Println("usage: quilt freeze-machines [-H=<daemon_host>] on|off")
+		fmt.Println("`freeze-machines on` stops the Quilt daemon from booting " +
+			"or terminating machines, even if the deployed Stitch calls for " +
+			"it.  Containers are still scheduled onto the existing machines.")
+		fmt.Println("`freeze-machines off` allows the daemon to boot and " +
+			"terminate machines again.")
+		flags.PrintDefaults()
+	}
+}
+
+// Parse parses the command line arguments for the freeze-machines command.
+func (fCmd *FreezeMachines) Parse(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected exactly one of on or off, got %d arguments",
+			len(args))
+	}
+
+	switch args[0] {
+	case "on":
+		fCmd.frozen = true
+	case "off":
+		fCmd.frozen = false
+	default:
+		return fmt.Errorf("expected on or off: %s", args[0])
+	}
+	return nil
+}
+
+// Run freezes or thaws the machines.
+func (fCmd *FreezeMachines) Run() int {
+	c, err := fCmd.clientGetter.Client(fCmd.common.host)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	defer c.Close()
+
+	if err := c.FreezeMachines(fCmd.frozen); err != nil {
+		log.WithError(err).Error("Unable to freeze machines.")
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package command
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	clientMock "github.com/NetSys/quilt/api/client/mocks"
+	"github.com/NetSys/quilt/quiltctl/testutils"
+)
+
+func TestFreezeMachinesFlags(t *testing.T) {
+	t.Parallel()
+
+	cmd := NewFreezeMachinesCommand()
+	assert.NoError(t, parseHelper(cmd, []string{"-H", "IP", "on"}))
+	assert.Equal(t, "IP", cmd.common.host)
+	assert.True(t, cmd.frozen)
+
+	cmd = NewFreezeMachinesCommand()
+	assert.NoError(t, parseHelper(cmd, []string{"off"}))
+	assert.False(t, cmd.frozen)
+
+	assert.EqualError(t, parseHelper(NewFreezeMachinesCommand(), []string{}),
+		"expected exactly one of on or off, got 0 arguments")
+	assert.EqualError(t, parseHelper(NewFreezeMachinesCommand(),
+		[]string{"yes"}), "expected on or off: yes")
+}
+
+func TestFreezeMachines(t *testing.T) {
+	t.Parallel()
+
+	c := new(clientMock.Client)
+	mockGetter := new(testutils.Getter)
+	mockGetter.On("Client", mock.Anything).Return(c, nil)
+
+	cmd := &FreezeMachines{frozen: true, common: &commonFlags{},
+		clientGetter: mockGetter}
+	assert.Equal(t, 0, cmd.Run())
+
+	cmd.frozen = false
+	assert.Equal(t, 0, cmd.Run())
+	assert.Equal(t, []bool{true, false}, c.FreezeArgs)
+
+	c.FreezeErr = errors.New("error")
+	assert.Equal(t, 1, cmd.Run())
+}
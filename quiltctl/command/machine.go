@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strings"
 	"text/tabwriter"
 
 	log "github.com/Sirupsen/logrus"
@@ -58,18 +60,55 @@ func (mCmd *Machine) Run() int {
 		return 1
 	}
 
-	writeMachines(os.Stdout, machines)
+	clusters, err := c.QueryClusters()
+	if err != nil {
+		log.WithError(err).Error("Unable to query clusters.")
+		return 1
+	}
+
+	frozen := len(clusters) == 1 && clusters[0].FreezeMachines
+	writeMachines(os.Stdout, machines, frozen)
 	return 0
 }
 
-func writeMachines(fd io.Writer, machines []db.Machine) {
+func writeMachines(fd io.Writer, machines []db.Machine, frozen bool) {
+	if frozen {
+		fmt.Fprintln(fd, "FROZEN: machines won't be booted or terminated until "+
+			"`quilt freeze-machines off`")
+	}
+
 	w := tabwriter.NewWriter(fd, 0, 0, 4, ' ', 0)
 	defer w.Flush()
-	fmt.Fprintln(w, "ID\tROLE\tPROVIDER\tREGION\tSIZE\tPUBLIC IP\tCONNECTED")
+	fmt.Fprintln(w, "ID\tROLE\tPROVIDER\tREGION\tSIZE\tPUBLIC IP\tCONNECTED"+
+		"\tSTATUS")
 
 	for _, m := range db.SortMachines(machines) {
-		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
 			m.ID, m.Role, m.Provider, m.Region, m.Size, m.PublicIP,
-			m.Connected)
+			m.Connected, machineStatus(m))
+	}
+}
+
+// machineStatus describes whether the machine is draining, and the system
+// containers that are failing their probes or have been restarted because of them.
+func machineStatus(m db.Machine) string {
+	var status []string
+	if m.Draining {
+		status = append(status, "DRAINING")
+	}
+
+	var names []string
+	for name := range m.SupervisorStatus {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cs := m.SupervisorStatus[name]
+		if cs.State == db.ComponentUnhealthy || cs.Restarts > 0 {
+			status = append(status, fmt.Sprintf("%s %s (%d restarts)",
+				name, cs.State, cs.Restarts))
+		}
 	}
+	return strings.Join(status, ", ")
 }
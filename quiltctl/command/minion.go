@@ -1,25 +1,50 @@
 package command
 
 import (
+	"errors"
 	"flag"
 
+	"github.com/NetSys/quilt/faults"
 	"github.com/NetSys/quilt/minion"
+	"github.com/NetSys/quilt/minion/supervisor"
 )
 
 // Minion contains the options for running the Quilt minion.
-type Minion struct{}
+type Minion struct {
+	faultInjection bool
+	probeConfig    supervisor.ProbeConfig
+}
 
 // InstallFlags sets up parsing for command line flags.
 func (mCmd *Minion) InstallFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&mCmd.faultInjection, faultInjectionFlag, false,
+		faultInjectionUsage)
+	flags.DurationVar(&mCmd.probeConfig.Interval, "probe-interval",
+		supervisor.DefaultProbeConfig.Interval,
+		"how often to probe the liveness of the system containers")
+	flags.IntVar(&mCmd.probeConfig.FailureThreshold, "probe-failures",
+		supervisor.DefaultProbeConfig.FailureThreshold,
+		"the number of probes in a row a system container must fail to be "+
+			"restarted")
 }
 
 // Parse parses the command line arguments for the minion command.
 func (mCmd *Minion) Parse(args []string) error {
+	if mCmd.probeConfig.Interval <= 0 {
+		return errors.New("-probe-interval must be positive")
+	}
+	if mCmd.probeConfig.FailureThreshold < 1 {
+		return errors.New("-probe-failures must be at least 1")
+	}
 	return nil
 }
 
 // Run starts the minion.
 func (mCmd *Minion) Run() int {
-	minion.Run()
+	if mCmd.faultInjection {
+		faults.Enable()
+	}
+
+	minion.Run(mCmd.probeConfig)
 	return 0
 }
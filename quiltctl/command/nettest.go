@@ -0,0 +1,139 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/NetSys/quilt/api"
+	"github.com/NetSys/quilt/api/client"
+	"github.com/NetSys/quilt/api/client/getter"
+)
+
+// NetTest contains the options for probing connectivity between two labels.
+type NetTest struct {
+	from, to string
+	port     int
+	protocol string
+
+	common       *commonFlags
+	clientGetter client.Getter
+}
+
+// NewNetTestCommand creates a new NetTest command instance.
+func NewNetTestCommand() *NetTest {
+	return &NetTest{
+		clientGetter: getter.New(),
+		common:       &commonFlags{},
+	}
+}
+
+// InstallFlags sets up parsing for command line flags.
+func (nCmd *NetTest) InstallFlags(flags *flag.FlagSet) {
+	nCmd.common.InstallFlags(flags)
+
+	flags.StringVar(&nCmd.protocol, "protocol", "tcp",
+		"the protocol to probe with, tcp or udp")
+
+	flags.Usage = func() {
+		fmt.Println("usage: quilt nettest [-H=<daemon_host>] " +
+			"[-protocol=<tcp|udp>] <from_label> <to_label>:<port>")
+		fmt.Println("`nettest` probes whether each container of the from " +
+			"label can reach each container of the to label.  The probes " +
+			"run in the source containers' network namespaces, and report " +
+			"whether the destination's hostname resolves, whether it's " +
+			"routable, and whether it accepts a connection.  UDP probes " +
+			"only succeed if the destination echoes a reply.")
+		flags.PrintDefaults()
+	}
+}
+
+// Parse parses the command line arguments for the nettest command.
+func (nCmd *NetTest) Parse(args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected a from label and a to label:port")
+	}
+
+	if nCmd.protocol != "tcp" && nCmd.protocol != "udp" {
+		return fmt.Errorf("unknown protocol: %s", nCmd.protocol)
+	}
+
+	parts := strings.Split(args[1], ":")
+	if len(parts) != 2 {
+		return fmt.Errorf("expected label:port, got %s", args[1])
+	}
+
+	port, err := strconv.Atoi(parts[1])
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("malformed port: %s", parts[1])
+	}
+
+	nCmd.from = args[0]
+	nCmd.to = parts[0]
+	nCmd.port = port
+	return nil
+}
+
+// Run probes the connectivity between the labels, and prints each hop's result.
+func (nCmd *NetTest) Run() int {
+	localClient, err := nCmd.clientGetter.Client(nCmd.common.host)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	defer localClient.Close()
+
+	// Only the leader knows where each container is running.
+	leaderClient, err := nCmd.clientGetter.LeaderClient(localClient)
+	if err != nil {
+		log.WithError(err).Error("Unable to connect to a cluster leader.")
+		return 1
+	}
+	defer leaderClient.Close()
+
+	results, err := leaderClient.NetTest(nCmd.from, nCmd.to, nCmd.port,
+		nCmd.protocol)
+	if err != nil {
+		log.WithError(err).Error("Unable to test the network.")
+		return 1
+	}
+
+	writeProbeResults(os.Stdout, nCmd.from, results)
+	for _, result := range results {
+		if !result.OK() {
+			return 1
+		}
+	}
+	return 0
+}
+
+func writeProbeResults(fd io.Writer, from string, results []api.ProbeResult) {
+	w := tabwriter.NewWriter(fd, 0, 0, 4, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "FROM\tTO\tHOP\tRESULT\tDETAIL")
+
+	for _, result := range results {
+		src := fmt.Sprintf("%s %s", from, result.FromIP)
+		dst := fmt.Sprintf("%s (%s)", result.ToHostname, result.ToIP)
+		if result.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\t\tERROR\t%s\n", src, dst, result.Error)
+			continue
+		}
+
+		for _, hop := range result.Hops {
+			status := "FAIL"
+			if hop.OK {
+				status = "OK"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", src, dst, hop.Name, status,
+				hop.Detail)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/NetSys/quilt/api"
+	clientMock "github.com/NetSys/quilt/api/client/mocks"
+	"github.com/NetSys/quilt/quiltctl/testutils"
+)
+
+func TestNetTestFlags(t *testing.T) {
+	t.Parallel()
+
+	cmd := NewNetTestCommand()
+	assert.NoError(t, parseHelper(cmd, []string{"-H", "IP", "web", "db:5432"}))
+	assert.Equal(t, "IP", cmd.common.host)
+	assert.Equal(t, "web", cmd.from)
+	assert.Equal(t, "db", cmd.to)
+	assert.Equal(t, 5432, cmd.port)
+	assert.Equal(t, "tcp", cmd.protocol)
+
+	cmd = NewNetTestCommand()
+	assert.NoError(t, parseHelper(cmd, []string{"-protocol", "udp", "a", "b:53"}))
+	assert.Equal(t, "udp", cmd.protocol)
+
+	assert.EqualError(t, parseHelper(NewNetTestCommand(), []string{"web"}),
+		"expected a from label and a to label:port")
+	assert.EqualError(t, parseHelper(NewNetTestCommand(), []string{"web", "db"}),
+		"expected label:port, got db")
+	assert.EqualError(t, parseHelper(NewNetTestCommand(),
+		[]string{"web", "db:http"}), "malformed port: http")
+	assert.EqualError(t, parseHelper(NewNetTestCommand(),
+		[]string{"-protocol", "icmp", "web", "db:80"}), "unknown protocol: icmp")
+}
+
+func TestNetTest(t *testing.T) {
+	t.Parallel()
+
+	c := new(clientMock.Client)
+	leader := &clientMock.Client{NetTestReturn: []api.ProbeResult{{
+		FromIP: "10.0.0.2", ToHostname: "1.db.q", ToIP: "10.0.0.3",
+		Hops: []api.ProbeHop{{Name: "connect", OK: true}},
+	}}}
+	mockGetter := new(testutils.Getter)
+	mockGetter.On("Client", mock.Anything).Return(c, nil)
+	mockGetter.On("LeaderClient", mock.Anything).Return(leader, nil)
+
+	cmd := &NetTest{from: "web", to: "db", port: 80, protocol: "tcp",
+		common: &commonFlags{}, clientGetter: mockGetter}
+	assert.Equal(t, 0, cmd.Run())
+
+	leader.NetTestReturn[0].Hops[0].OK = false
+	assert.Equal(t, 1, cmd.Run())
+
+	leader.NetTestErr = errors.New("no label: db")
+	assert.Equal(t, 1, cmd.Run())
+}
+
+func TestWriteProbeResults(t *testing.T) {
+	t.Parallel()
+
+	results := []api.ProbeResult{
+		{
+			FromIP: "10.0.0.2", ToHostname: "1.db.q", ToIP: "10.0.0.3",
+			Hops: []api.ProbeHop{
+				{Name: "dns", OK: true, Detail: "1.db.q is 10.0.0.3"},
+				{Name: "connect", Detail: "connection refused"},
+			},
+		},
+		{
+			FromIP: "10.0.0.4", ToHostname: "1.db.q", ToIP: "10.0.0.3",
+			Error: "source container isn't running",
+		},
+	}
+
+	var b bytes.Buffer
+	writeProbeResults(&b, "web", results)
+
+	exp := "FROM            TO                   HOP        RESULT    DETAIL\n" +
+		"web 10.0.0.2    1.db.q (10.0.0.3)    dns        OK        " +
+		"1.db.q is 10.0.0.3\n" +
+		"web 10.0.0.2    1.db.q (10.0.0.3)    connect    FAIL      " +
+		"connection refused\n" +
+		"web 10.0.0.4    1.db.q (10.0.0.3)               ERROR     " +
+		"source container isn't running\n"
+	assert.Equal(t, exp, b.String())
+}
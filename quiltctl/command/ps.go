@@ -90,7 +90,7 @@ func (pCmd *Ps) run() error {
 	}
 
 	fmt.Println("MACHINES")
-	writeMachines(os.Stdout, machines)
+	writeMachines(os.Stdout, machines, false)
 	fmt.Println()
 
 	if leadErr != nil {
@@ -8,33 +8,52 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	homedir "github.com/mitchellh/go-homedir"
 	"github.com/pmezard/go-difflib/difflib"
 	"github.com/robertkrimen/otto"
 
 	"github.com/NetSys/quilt/api/client"
 	"github.com/NetSys/quilt/api/client/getter"
+	"github.com/NetSys/quilt/cluster"
 	"github.com/NetSys/quilt/stitch"
+	"github.com/NetSys/quilt/util"
 )
 
 // Run contains the options for running Stitches.
 type Run struct {
-	stitch string
-	force  bool
+	stitch   string
+	dir      string
+	force    bool
+	noCache  bool
+	sets     stringsFlag
+	setFiles stringsFlag
+	params   map[string]interface{}
 
 	common       *commonFlags
 	clientGetter client.Getter
+	stdin        io.Reader
 }
 
+// The stitch argument denoting that the stitch should be read from stdin.
+const stdinStitch = "-"
+
+// The directory, relative to the user's home directory, that evaluated stitches are
+// cached in.
+const evalCacheDir = ".cache/quilt/stitch"
+
 // NewRunCommand creates a new Run command instance.
 func NewRunCommand() *Run {
 	return &Run{
 		common:       &commonFlags{},
 		clientGetter: getter.New(),
+		stdin:        os.Stdin,
 	}
 }
 
@@ -43,16 +62,33 @@ func (rCmd *Run) InstallFlags(flags *flag.FlagSet) {
 	rCmd.common.InstallFlags(flags)
 
 	flags.StringVar(&rCmd.stitch, "stitch", "", "the stitch to run")
+	flags.StringVar(&rCmd.dir, "dir", "",
+		"the directory relative imports are resolved against when the "+
+			"stitch is read from stdin")
 	flags.BoolVar(&rCmd.force, "f", false, "deploy without confirming changes")
+	flags.BoolVar(&rCmd.noCache, "no-cache", false, "evaluate the stitch even if "+
+		"an identical one was evaluated before")
+	flags.Var(&rCmd.sets, "set", "set the stitch parameter `name=value`, "+
+		"may be repeated")
+	flags.Var(&rCmd.setFiles, "set-file", "set the stitch parameter "+
+		"`name=path` to the contents of a file, may be repeated")
 
 	flags.Usage = func() {
-		fmt.Println("usage: quilt run [-H=<daemon_host>] [-f] " +
-			"[-stitch=<stitch>] <stitch>")
+		fmt.Println("usage: quilt run [-H=<daemon_host>] [-f] [-no-cache] " +
+			"[-dir=<dir>] [-set=<name=value>]... " +
+			"[-set-file=<name=path>]... [-stitch=<stitch>] <stitch>")
 		fmt.Println("`run` compiles the provided stitch, and sends the " +
 			"result to the Quilt daemon to be executed. Confirmation is " +
 			"required if deploying the stitch would cause changes to an " +
 			"existing cluster. Confirmation can be skipped with the " +
-			"`-f` flag.")
+			"`-f` flag. The stitch may be a path, an https:// URL, or " +
+			"`-` to read it from stdin.")
+		fmt.Println("Parameters are read by the stitch from the `params` " +
+			"object, where dotted names nest. Values that parse as JSON are " +
+			"used as such, and otherwise as strings.")
+		fmt.Println("The compiled stitch is cached, and reused until the " +
+			"stitch, its parameters, or the files it imports or reads " +
+			"change. Use `-no-cache` to compile it regardless.")
 		flags.PrintDefaults()
 	}
 }
@@ -66,24 +102,69 @@ func (rCmd *Run) Parse(args []string) error {
 		rCmd.stitch = args[0]
 	}
 
+	params, err := parseParams(rCmd.sets, rCmd.setFiles)
+	if err != nil {
+		return err
+	}
+	rCmd.params = params
 	return nil
 }
 
+// parseParams parses the `name=value` parameters set on the command line, and the
+// `name=path` parameters whose values are read from files.
+func parseParams(sets, setFiles []string) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+	add := func(param string, readFile bool) error {
+		parts := strings.SplitN(param, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("malformed parameter: %s", param)
+		}
+
+		name, raw := parts[0], parts[1]
+		if _, ok := params[name]; ok {
+			return fmt.Errorf("parameter set more than once: %s", name)
+		}
+
+		if readFile {
+			contents, err := util.ReadFile(raw)
+			if err != nil {
+				return fmt.Errorf("parameter %s: %s", name, err)
+			}
+			raw = contents
+		}
+
+		var value interface{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			value = raw
+		}
+		params[name] = value
+		return nil
+	}
+
+	for _, param := range sets {
+		if err := add(param, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, param := range setFiles {
+		if err := add(param, true); err != nil {
+			return nil, err
+		}
+	}
+	return params, nil
+}
+
 const emptyDeployment = "{}"
 
 // Run starts the run for the provided Stitch.
 func (rCmd *Run) Run() int {
-	stitchPath := rCmd.stitch
-	compiled, err := stitch.FromFile(stitchPath, stitch.DefaultImportGetter)
-	if err != nil && os.IsNotExist(err) && !filepath.IsAbs(stitchPath) {
-		// Automatically add the ".js" file suffix if it's not provided.
-		if !strings.HasSuffix(stitchPath, ".js") {
-			stitchPath += ".js"
-		}
-		compiled, err = stitch.FromFile(
-			filepath.Join(stitch.GetQuiltPath(), stitchPath),
-			stitch.DefaultImportGetter)
+	if rCmd.stitch == stdinStitch && !rCmd.force {
+		log.Error("Stdin can't be used to both read the stitch and confirm " +
+			"the deployment. Use the `-f` flag to skip confirmation.")
+		return 1
 	}
+
+	compiled, err := rCmd.compile()
 	if err != nil {
 		// Print the stacktrace if it's an Otto error.
 		if ottoError, ok := err.(*otto.Error); ok {
@@ -119,6 +200,10 @@ func (rCmd *Run) Run() int {
 			fmt.Println("No change.")
 		} else {
 			fmt.Println(diff)
+			if note := deferralNote(compiled.MaintenanceWindow,
+				time.Now()); note != "" {
+				fmt.Println(note)
+			}
 		}
 		shouldDeploy, err := confirm(os.Stdin, "Continue with deployment?")
 		if err != nil {
@@ -142,6 +227,61 @@ func (rCmd *Run) Run() int {
 	return 0
 }
 
+func (rCmd *Run) compile() (stitch.Stitch, error) {
+	// The parameters are applied before the deployment is diffed, so that the
+	// changes confirmed are exactly those deployed.
+	opts := []stitch.Option{stitch.WithParams(rCmd.params),
+		stitch.WithRegions(cluster.Regions())}
+
+	// Evaluating a stitch may fetch imports and keys, so resubmitting the same
+	// stitch reuses the previous evaluation unless told otherwise.
+	if !rCmd.noCache {
+		if dir, err := homedir.Dir(); err == nil {
+			opts = append(opts, stitch.WithCache(filepath.Join(dir, evalCacheDir)))
+		}
+	}
+
+	stitchPath := rCmd.stitch
+	switch {
+	case stitchPath == stdinStitch:
+		specStr, err := ioutil.ReadAll(rCmd.stdin)
+		if err != nil {
+			return stitch.Stitch{}, err
+		}
+
+		// Relative imports are resolved against the directory of the
+		// filename, so placing the synthetic filename in `dir` makes them
+		// relative to it.
+		filename := stitch.StdinFilename
+		if rCmd.dir != "" {
+			filename = filepath.Join(rCmd.dir, filename)
+		}
+		return stitch.New(filename, string(specStr), stitch.DefaultImportGetter,
+			opts...)
+	case strings.HasPrefix(stitchPath, "https://"):
+		return stitch.FromURL(stitchPath, stitch.DefaultImportGetter, opts...)
+	}
+
+	// Prefer the copies of imports vendored next to the stitch.
+	importGetter, err := stitch.DefaultImportGetter.WithVendor(
+		filepath.Dir(stitchPath), false)
+	if err != nil {
+		return stitch.Stitch{}, err
+	}
+
+	compiled, err := stitch.FromFile(stitchPath, importGetter, opts...)
+	if err != nil && os.IsNotExist(err) && !filepath.IsAbs(stitchPath) {
+		// Automatically add the ".js" file suffix if it's not provided.
+		if !strings.HasSuffix(stitchPath, ".js") {
+			stitchPath += ".js"
+		}
+		compiled, err = stitch.FromFile(
+			filepath.Join(stitch.GetQuiltPath(), stitchPath),
+			stitch.DefaultImportGetter, opts...)
+	}
+	return compiled, err
+}
+
 func getCurrentDeployment(c client.Client) (string, error) {
 	clusters, err := c.QueryClusters()
 	if err != nil {
@@ -177,6 +317,17 @@ func diffDeployment(currRaw, newRaw string) (string, error) {
 	return difflib.GetUnifiedDiffString(diff)
 }
 
+// deferralNote describes the disruptive changes that won't begin until the
+// maintenance window opens, or returns the empty string if it's open at `now`.
+func deferralNote(window stitch.MaintenanceWindow, now time.Time) string {
+	if window.Open(now) {
+		return ""
+	}
+	return fmt.Sprintf("The maintenance window is closed, so machine "+
+		"replacements and image rollouts are deferred until %s.",
+		formatDeferred(window.NextOpen(now)))
+}
+
 func prettifyJSON(toPrettify string) (string, error) {
 	var prettified bytes.Buffer
 	err := json.Indent(&prettified, []byte(toPrettify), "", "\t")
@@ -206,3 +357,15 @@ var confirm = func(in io.Reader, prompt string) (bool, error) {
 		}
 	}
 }
+
+// A stringsFlag collects the values of a flag that may be repeated.
+type stringsFlag []string
+
+func (sf *stringsFlag) String() string {
+	return strings.Join(*sf, " ")
+}
+
+func (sf *stringsFlag) Set(value string) error {
+	*sf = append(*sf, value)
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	logrusTestHook "github.com/Sirupsen/logrus/hooks/test"
@@ -41,8 +42,28 @@ func TestRunSpec(t *testing.T) {
 	exJSON := `{"Containers":[],"Labels":[],"Connections":[],"Placements":[],` +
 		`"Machines":[{"Provider":"","Role":"","Size":"",` +
 		`"CPU":{"Min":0,"Max":0},"RAM":{"Min":0,"Max":0},"DiskSize":0,` +
-		`"Region":"","SSHKeys":[]}],"AdminACL":[],"MaxPrice":0,` +
-		`"Namespace":"default-namespace","Invariants":[]}`
+		`"Region":"","SSHKeys":[],"Arch":"","SpotPrice":0,"PublicIP":"",` +
+		`"PrivateIP":"","SSHKeyPath":"","DedicatedTo":"","FloatingIP":"",` +
+		`"DisableNAT":false,"GPUs":0,"SizeFallbacks":[],"NetworkTier":""}],` +
+		`"LoadBalancers":[],"AdminACL":[],` +
+		`"MaxPrice":0,` +
+		`"Namespace":"default-namespace","MasterACL":[],"WorkerACL":[],` +
+		`"EncryptTraffic":false,` +
+		`"TCPKeepalive":{"Time":0,"Interval":0,"Probes":0},` +
+		`"NetworkTuning":{"ConntrackMax":0,"EphemeralPortMin":0,` +
+		`"EphemeralPortMax":0,"Somaxconn":0},"NATBackend":"","NATInterval":0,` +
+		`"MaxNATRules":0,` +
+		`"PublicInterface":"",` +
+		`"MaintenanceWindow":{"Days":null,"Start":"","End":"","TZ":""},` +
+		`"Networks":[],"DefaultSpread":false,` +
+		`"AutoscaleWorkers":{"Min":0,"Max":0,"Template":{"Provider":"",` +
+		`"Role":"","Size":"","CPU":{"Min":0,"Max":0},"RAM":{"Min":0,"Max":0},` +
+		`"DiskSize":0,"Region":"","SSHKeys":null,"Arch":"","SpotPrice":0,` +
+		`"PublicIP":"","PrivateIP":"","SSHKeyPath":"","DedicatedTo":"",` +
+		`"FloatingIP":"","DisableNAT":false,"GPUs":0,"SizeFallbacks":null,` +
+		`"NetworkTier":""}},` +
+		`"Invariants":[],` +
+		`"BindingsVersion":"` + stitch.BindingsVersion() + `","Params":null}`
 	tests := []runTest{
 		{
 			files: []file{
@@ -215,6 +236,21 @@ func TestDeploymentDiff(t *testing.T) {
 	}
 }
 
+func TestDeferralNote(t *testing.T) {
+	t.Parallel()
+
+	window := stitch.MaintenanceWindow{Days: []string{"Sat"}, Start: "02:00",
+		End: "04:00"}
+	wednesday := time.Date(2017, 6, 7, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "The maintenance window is closed, so machine replacements "+
+		"and image rollouts are deferred until Sat 2017-06-10 02:00 +0000.",
+		deferralNote(window, wednesday))
+
+	saturday := time.Date(2017, 6, 10, 3, 0, 0, 0, time.UTC)
+	assert.Empty(t, deferralNote(window, saturday))
+	assert.Empty(t, deferralNote(stitch.MaintenanceWindow{}, wednesday))
+}
+
 type confirmTest struct {
 	inputs []string
 	exp    bool
@@ -290,6 +326,71 @@ func TestPromptsUser(t *testing.T) {
 	}
 }
 
+func TestRunStdin(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+	util.WriteFile("/specs/machine.js",
+		[]byte(`exports.machine = new Machine({});`), 0644)
+
+	mockGetter := new(testutils.Getter)
+	c := &clientMock.Client{}
+	mockGetter.On("Client", mock.Anything).Return(c, nil)
+
+	logHook := logrusTestHook.NewGlobal()
+
+	// Confirmation is impossible when stdin holds the stitch.
+	runCmd := NewRunCommand()
+	runCmd.clientGetter = mockGetter
+	runCmd.stitch = "-"
+	runCmd.stdin = bytes.NewBufferString(`deployment.deploy(new Machine({}));`)
+	assert.Equal(t, 1, runCmd.Run())
+	assert.Empty(t, c.DeployArg)
+
+	runCmd.force = true
+	assert.Equal(t, 0, runCmd.Run())
+	assert.Contains(t, c.DeployArg, `"Machines":[{`)
+
+	// Relative imports need a base directory.
+	logHook.Reset()
+	c.DeployArg = ""
+	runCmd.stdin = bytes.NewBufferString(
+		`deployment.deploy(require("./machine").machine);`)
+	assert.Equal(t, 1, runCmd.Run())
+	assert.Empty(t, c.DeployArg)
+	assert.Contains(t, logHook.LastEntry().Message, "quilt run -dir")
+
+	runCmd.dir = "/specs"
+	runCmd.stdin = bytes.NewBufferString(
+		`deployment.deploy(require("./machine").machine);`)
+	assert.Equal(t, 0, runCmd.Run())
+	assert.Contains(t, c.DeployArg, `"Machines":[{`)
+}
+
+func TestRunRegions(t *testing.T) {
+	mockGetter := new(testutils.Getter)
+	c := &clientMock.Client{}
+	mockGetter.On("Client", mock.Anything).Return(c, nil)
+
+	logHook := logrusTestHook.NewGlobal()
+
+	// Machines in regions their provider doesn't have are rejected before
+	// they're deployed.
+	runCmd := NewRunCommand()
+	runCmd.clientGetter = mockGetter
+	runCmd.force = true
+	runCmd.stitch = "-"
+	runCmd.stdin = bytes.NewBufferString(`deployment.deploy(new Machine({
+		provider: "Google", region: "us-west-1"}));`)
+	assert.Equal(t, 1, runCmd.Run())
+	assert.Empty(t, c.DeployArg)
+	assert.Equal(t, "machines have invalid regions: us-west-1 (Google)",
+		logHook.LastEntry().Message)
+
+	runCmd.stdin = bytes.NewBufferString(`deployment.deploy(new Machine({
+		provider: "Amazon", region: "us-west-1"}));`)
+	assert.Equal(t, 0, runCmd.Run())
+	assert.Contains(t, c.DeployArg, `"Region":"us-west-1"`)
+}
+
 func TestRunFlags(t *testing.T) {
 	t.Parallel()
 
@@ -298,9 +399,67 @@ func TestRunFlags(t *testing.T) {
 	checkRunParsing(t, []string{expStitch}, Run{stitch: expStitch}, nil)
 	checkRunParsing(t, []string{"-f", expStitch},
 		Run{force: true, stitch: expStitch}, nil)
+	checkRunParsing(t, []string{"-f", "-dir", "/specs", "-"},
+		Run{force: true, dir: "/specs", stitch: "-"}, nil)
 	checkRunParsing(t, []string{}, Run{}, errors.New("no spec specified"))
 }
 
+func TestRunParams(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+	util.WriteFile("/prod.json", []byte(`{"acl": ["1.2.3.4/32"]}`), 0644)
+	util.WriteFile("/test.js", []byte(`deployment.deploy(new Machine({}));
+	var web = new Service("web", new Container(params.image)
+		.replicate(params.replicas.web));
+	deployment.deploy(web);
+	deployment.adminACL = params.overrides.acl;`), 0644)
+
+	runCmd := NewRunCommand()
+	assert.NoError(t, parseHelper(runCmd, []string{"-set", "replicas.web=3",
+		"-set", "image=nginx:1.13", "-set-file", "overrides=/prod.json",
+		"/test.js"}))
+	assert.Equal(t, map[string]interface{}{
+		"replicas.web": float64(3),
+		"image":        "nginx:1.13",
+		"overrides": map[string]interface{}{
+			"acl": []interface{}{"1.2.3.4/32"},
+		},
+	}, runCmd.params)
+
+	mockGetter := new(testutils.Getter)
+	c := &clientMock.Client{}
+	mockGetter.On("Client", mock.Anything).Return(c, nil)
+	runCmd.clientGetter = mockGetter
+	runCmd.force = true
+	assert.Equal(t, 0, runCmd.Run())
+
+	deployed, err := stitch.FromJSON(c.DeployArg)
+	assert.NoError(t, err)
+	assert.Len(t, deployed.Containers, 3)
+	assert.Equal(t, "nginx:1.13", deployed.Containers[0].Image)
+	assert.Equal(t, []string{"1.2.3.4/32"}, deployed.AdminACL)
+	assert.Equal(t, runCmd.params, deployed.Params)
+
+	// Parameters the stitch doesn't read are rejected.
+	runCmd = NewRunCommand()
+	assert.NoError(t, parseHelper(runCmd, []string{"-set", "image=nginx",
+		"-set", "replicas.web=1", "-set", "replicas.wbe=2",
+		"-set-file", "overrides=/prod.json", "/test.js"}))
+	logHook := logrusTestHook.NewGlobal()
+	runCmd.clientGetter = mockGetter
+	assert.Equal(t, 1, runCmd.Run())
+	assert.Len(t, logHook.Entries, 1)
+	assert.Equal(t, "unknown parameter: replicas.wbe", logHook.LastEntry().Message)
+
+	checkRunParsing(t, []string{"-set", "image", "/test.js"}, Run{},
+		errors.New("malformed parameter: image"))
+	checkRunParsing(t, []string{"-set", "=nginx", "/test.js"}, Run{},
+		errors.New("malformed parameter: =nginx"))
+	checkRunParsing(t, []string{"-set", "image=a", "-set", "image=b", "/test.js"},
+		Run{}, errors.New("parameter set more than once: image"))
+	checkRunParsing(t, []string{"-set-file", "image=/dne", "/test.js"}, Run{},
+		errors.New("parameter image: open /dne: file does not exist"))
+}
+
 func checkRunParsing(t *testing.T, args []string, expFlags Run, expErr error) {
 	runCmd := NewRunCommand()
 	err := parseHelper(runCmd, args)
@@ -316,4 +475,5 @@ func checkRunParsing(t *testing.T, args []string, expFlags Run, expErr error) {
 	assert.Nil(t, err)
 	assert.Equal(t, expFlags.stitch, runCmd.stitch)
 	assert.Equal(t, expFlags.force, runCmd.force)
+	assert.Equal(t, expFlags.dir, runCmd.dir)
 }
@@ -0,0 +1,341 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/NetSys/quilt/api"
+	"github.com/NetSys/quilt/api/client"
+	"github.com/NetSys/quilt/api/client/getter"
+	"github.com/NetSys/quilt/db"
+)
+
+// The formats the status command prints in.
+const (
+	tableFormat = "table"
+	jsonFormat  = "json"
+)
+
+// The exit code of the status command when a label is degraded, so that scripts
+// can tell degraded labels apart from failures to reach the daemon.
+const degradedExitCode = 2
+
+// How often the status command refreshes when watching.
+const statusInterval = 5 * time.Second
+
+// Status contains the options for summarizing the health of each label.
+type Status struct {
+	watch   bool
+	timings bool
+	format  string
+
+	common       *commonFlags
+	clientGetter client.Getter
+}
+
+// NewStatusCommand creates a new Status command instance.
+func NewStatusCommand() *Status {
+	return &Status{
+		clientGetter: getter.New(),
+		common:       &commonFlags{},
+	}
+}
+
+// InstallFlags sets up parsing for command line flags.
+func (sCmd *Status) InstallFlags(flags *flag.FlagSet) {
+	sCmd.common.InstallFlags(flags)
+
+	flags.BoolVar(&sCmd.watch, "watch", false, "refresh the status until killed")
+	flags.BoolVar(&sCmd.timings, "timings", false,
+		"print how long each phase of booting the machines took instead")
+	flags.StringVar(&sCmd.format, "format", tableFormat,
+		"the format to print in, table or json")
+
+	flags.Usage = func() {
+		fmt.Println("usage: quilt status [-H=<daemon_host>] [-watch] " +
+			"[-timings] [-format=<table|json>]")
+		fmt.Println("`status` summarizes the health of each label's " +
+			"containers: how many are declared, running, and unhealthy, the " +
+			"machines they're on, their public ports, and why the oldest " +
+			"unplaced container couldn't be scheduled.  Image rollouts and " +
+			"machine replacements deferred until the maintenance window " +
+			"opens are listed with when they may begin.")
+		fmt.Printf("It exits with status %d if fewer of any label's "+
+			"containers are running than are declared.\n", degradedExitCode)
+		fmt.Println("With -timings, it instead prints how long each phase " +
+			"of booting each machine took: from requesting the instance " +
+			"until it ran, from the boot script starting until the minion " +
+			"ran, pulling the system images, and from the minion starting " +
+			"until its first container ran.")
+		flags.PrintDefaults()
+	}
+}
+
+// Parse parses the command line arguments for the status command.
+func (sCmd *Status) Parse(args []string) error {
+	if len(args) > 0 {
+		return errors.New("status takes no arguments")
+	}
+
+	if sCmd.format != tableFormat && sCmd.format != jsonFormat {
+		return fmt.Errorf("unknown format: %s", sCmd.format)
+	}
+
+	if sCmd.watch && sCmd.timings {
+		return errors.New("timings can't be watched")
+	}
+	return nil
+}
+
+// Run prints the status of each label, refreshing it until killed if watching.
+func (sCmd *Status) Run() int {
+	if sCmd.timings {
+		return sCmd.runTimings()
+	}
+
+	if sCmd.watch {
+		sCmd.runWatch()
+	}
+
+	statuses, deferred, err := sCmd.queryStatus()
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	sCmd.writeStatus(os.Stdout, statuses, deferred)
+	if anyDegraded(statuses) {
+		return degradedExitCode
+	}
+	return 0
+}
+
+func (sCmd *Status) runWatch() {
+	for {
+		statuses, deferred, err := sCmd.queryStatus()
+		if err != nil {
+			log.Error(err)
+		} else {
+			if sCmd.format == tableFormat {
+				// Clear the terminal, so that the table is redrawn in
+				// place.
+				fmt.Print("\033[H\033[2J")
+			}
+			sCmd.writeStatus(os.Stdout, statuses, deferred)
+		}
+		time.Sleep(statusInterval)
+	}
+}
+
+// queryStatus returns the status of each label, and the machines whose replacement
+// is deferred until the maintenance window opens.
+func (sCmd *Status) queryStatus() ([]api.LabelStatus, []db.Machine, error) {
+	localClient, err := sCmd.clientGetter.Client(sCmd.common.host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to quilt daemon: %s", err)
+	}
+	defer localClient.Close()
+
+	// The machines are managed by the daemon.
+	machines, err := localClient.QueryMachines()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to query machines: %s", err)
+	}
+
+	var deferred []db.Machine
+	for _, m := range machines {
+		if !m.ReplaceAfter.IsZero() {
+			deferred = append(deferred, m)
+		}
+	}
+
+	// Only the leader tracks the containers.
+	leaderClient, err := sCmd.clientGetter.LeaderClient(localClient)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to a cluster leader: %s",
+			err)
+	}
+	defer leaderClient.Close()
+
+	statuses, err := leaderClient.QueryStatus()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to query status: %s", err)
+	}
+	return statuses, deferred, nil
+}
+
+func (sCmd *Status) writeStatus(w io.Writer, statuses []api.LabelStatus,
+	deferred []db.Machine) {
+	if sCmd.format == jsonFormat {
+		writeStatusJSON(w, statuses)
+	} else {
+		writeStatusTable(w, statuses)
+		writeDeferredMachines(w, deferred)
+	}
+}
+
+// The format of the times at which deferred actions may begin.
+const deferredTimeFormat = "Mon 2006-01-02 15:04 -0700"
+
+// formatDeferred formats when a deferred action may begin, or the empty string if
+// nothing is deferred.
+func formatDeferred(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(deferredTimeFormat)
+}
+
+func writeStatusTable(fd io.Writer, statuses []api.LabelStatus) {
+	w := tabwriter.NewWriter(fd, 0, 0, 4, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "LABEL\tDESIRED\tRUNNING\tUNHEALTHY\tCANARIES\tMACHINES\t"+
+		"PUBLIC PORTS\tROLLOUT DEFERRED UNTIL\tPLACEMENT FAILURE")
+
+	for _, status := range statuses {
+		var ports []string
+		for _, port := range status.PublicPorts {
+			ports = append(ports, strconv.Itoa(port))
+		}
+
+		label := status.Label
+		if status.Degraded() {
+			label += " (degraded)"
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\n", label,
+			status.Desired, status.Running, status.Unhealthy, status.Canaries,
+			status.Machines, strings.Join(ports, ","),
+			formatDeferred(status.RolloutAfter), status.PlacementFailure)
+	}
+}
+
+// writeDeferredMachines writes the machines whose replacement is deferred until the
+// maintenance window opens, if there are any.
+func writeDeferredMachines(fd io.Writer, machines []db.Machine) {
+	if len(machines) == 0 {
+		return
+	}
+
+	fmt.Fprintln(fd)
+	w := tabwriter.NewWriter(fd, 0, 0, 4, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "MACHINE\tROLE\tPROVIDER\tREPLACEMENT DEFERRED UNTIL")
+	for _, m := range db.SortMachines(machines) {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", m.ID, m.Role, m.Provider,
+			formatDeferred(m.ReplaceAfter))
+	}
+}
+
+// writeStatusJSON writes the statuses as a single line of JSON, so that each
+// refresh of a watch is its own document.
+func writeStatusJSON(w io.Writer, statuses []api.LabelStatus) {
+	type labelJSON struct {
+		api.LabelStatus
+		Degraded bool
+	}
+
+	labels := []labelJSON{}
+	for _, status := range statuses {
+		labels = append(labels, labelJSON{status, status.Degraded()})
+	}
+
+	jsonBytes, err := json.Marshal(labels)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintln(w, string(jsonBytes))
+}
+
+func (sCmd *Status) runTimings() int {
+	c, err := sCmd.clientGetter.Client(sCmd.common.host)
+	if err != nil {
+		log.Error(fmt.Errorf("error connecting to quilt daemon: %s", err))
+		return 1
+	}
+	defer c.Close()
+
+	machines, err := c.QueryMachines()
+	if err != nil {
+		log.WithError(err).Error("Unable to query machines.")
+		return 1
+	}
+
+	if sCmd.format == jsonFormat {
+		writeTimingsJSON(os.Stdout, machines)
+	} else {
+		writeTimingsTable(os.Stdout, machines)
+	}
+	return 0
+}
+
+// writeTimingsTable writes how long each phase of booting the machines took.  Phases
+// that haven't completed are left blank.
+func writeTimingsTable(fd io.Writer, machines []db.Machine) {
+	w := tabwriter.NewWriter(fd, 0, 0, 4, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "MACHINE\tROLE\tPROVIDER\tINSTANCE\tMINION\tIMAGE PULL\t"+
+		"FIRST CONTAINER\tTOTAL")
+
+	for _, m := range db.SortMachines(machines) {
+		bt := m.BootTimings
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", m.ID, m.Role,
+			m.Provider, formatTiming(bt.Instance), formatTiming(bt.Minion),
+			formatTiming(bt.ImagePull), formatTiming(bt.FirstContainer),
+			formatTiming(bt.Total()))
+	}
+}
+
+// formatTiming formats `d` to the nearest second, or as the empty string if it's zero.
+func formatTiming(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return ((d + time.Second/2) / time.Second * time.Second).String()
+}
+
+// writeTimingsJSON writes the machines' boot timings in seconds.
+func writeTimingsJSON(w io.Writer, machines []db.Machine) {
+	type timingsJSON struct {
+		Machine                                            int
+		Instance, Minion, ImagePull, FirstContainer, Total float64
+	}
+
+	timings := []timingsJSON{}
+	for _, m := range db.SortMachines(machines) {
+		bt := m.BootTimings
+		timings = append(timings, timingsJSON{
+			Machine:        m.ID,
+			Instance:       bt.Instance.Seconds(),
+			Minion:         bt.Minion.Seconds(),
+			ImagePull:      bt.ImagePull.Seconds(),
+			FirstContainer: bt.FirstContainer.Seconds(),
+			Total:          bt.Total().Seconds(),
+		})
+	}
+
+	jsonBytes, err := json.Marshal(timings)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintln(w, string(jsonBytes))
+}
+
+func anyDegraded(statuses []api.LabelStatus) bool {
+	for _, status := range statuses {
+		if status.Degraded() {
+			return true
+		}
+	}
+	return false
+}
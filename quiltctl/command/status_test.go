@@ -0,0 +1,209 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/NetSys/quilt/api"
+	clientMock "github.com/NetSys/quilt/api/client/mocks"
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/quiltctl/testutils"
+)
+
+func TestStatusFlags(t *testing.T) {
+	t.Parallel()
+
+	cmd := NewStatusCommand()
+	assert.NoError(t, parseHelper(cmd, []string{"-H", "IP"}))
+	assert.Equal(t, "IP", cmd.common.host)
+	assert.False(t, cmd.watch)
+	assert.Equal(t, tableFormat, cmd.format)
+
+	cmd = NewStatusCommand()
+	assert.NoError(t, parseHelper(cmd, []string{"-watch", "-format", "json"}))
+	assert.True(t, cmd.watch)
+	assert.Equal(t, jsonFormat, cmd.format)
+
+	assert.EqualError(t, parseHelper(NewStatusCommand(), []string{"-format", "xml"}),
+		"unknown format: xml")
+	assert.EqualError(t, parseHelper(NewStatusCommand(), []string{"web"}),
+		"status takes no arguments")
+
+	cmd = NewStatusCommand()
+	assert.NoError(t, parseHelper(cmd, []string{"-timings"}))
+	assert.True(t, cmd.timings)
+	assert.EqualError(t, parseHelper(NewStatusCommand(),
+		[]string{"-timings", "-watch"}), "timings can't be watched")
+}
+
+var testStatuses = []api.LabelStatus{
+	{
+		Label:            "db",
+		Desired:          2,
+		Running:          1,
+		Machines:         1,
+		PlacementFailure: "dedicated to web",
+	},
+	{
+		Label:       "web",
+		Desired:     2,
+		Running:     2,
+		Canaries:    1,
+		Machines:    2,
+		PublicPorts: []int{80, 443},
+		RolloutAfter: time.Date(2017, 6, 3, 2, 0, 0, 0,
+			time.FixedZone("PDT", -7*60*60)),
+	},
+}
+
+func TestStatusOutput(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	writeStatusTable(&b, testStatuses)
+	result := strings.Replace(b.String(), " ", "_", -1)
+
+	exp := "LABEL____________DESIRED____RUNNING____UNHEALTHY____CANARIES____" +
+		"MACHINES____PUBLIC_PORTS____ROLLOUT_DEFERRED_UNTIL________" +
+		"PLACEMENT_FAILURE\n" +
+		"db_(degraded)____2__________1__________0____________0___________" +
+		"1_________________________________________________________" +
+		"dedicated_to_web\n" +
+		"web______________2__________2__________0____________1___________" +
+		"2___________80,443__________Sat_2017-06-03_02:00_-0700____\n"
+	assert.Equal(t, exp, result)
+
+	b.Reset()
+	writeStatusJSON(&b, testStatuses)
+	assert.Equal(t, `[{"Label":"db","Desired":2,"Running":1,"Unhealthy":0,`+
+		`"Canaries":0,"Machines":1,"PublicPorts":null,`+
+		`"PlacementFailure":"dedicated to web",`+
+		`"RolloutAfter":"0001-01-01T00:00:00Z","Degraded":true},`+
+		`{"Label":"web","Desired":2,"Running":2,"Unhealthy":0,`+
+		`"Canaries":1,"Machines":2,"PublicPorts":[80,443],"PlacementFailure":"",`+
+		`"RolloutAfter":"2017-06-03T02:00:00-07:00","Degraded":false}]`+"\n",
+		b.String())
+
+	b.Reset()
+	writeStatusJSON(&b, nil)
+	assert.Equal(t, "[]\n", b.String())
+}
+
+func TestDeferredMachinesOutput(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	writeDeferredMachines(&b, nil)
+	assert.Empty(t, b.String())
+
+	writeDeferredMachines(&b, []db.Machine{{
+		ID:           3,
+		Role:         db.Worker,
+		Provider:     db.Amazon,
+		ReplaceAfter: time.Date(2017, 6, 3, 9, 0, 0, 0, time.UTC),
+	}})
+	result := strings.Replace(b.String(), " ", "_", -1)
+
+	exp := "\nMACHINE____ROLE______PROVIDER____REPLACEMENT_DEFERRED_UNTIL\n" +
+		"3__________Worker____Amazon______Sat_2017-06-03_09:00_+0000\n"
+	assert.Equal(t, exp, result)
+}
+
+func TestTimingsOutput(t *testing.T) {
+	t.Parallel()
+
+	machines := []db.Machine{
+		{
+			ID:       2,
+			Role:     db.Worker,
+			Provider: db.Amazon,
+			BootTimings: db.BootTimings{
+				Instance:  70 * time.Second,
+				Minion:    3*time.Minute + 400*time.Millisecond,
+				ImagePull: 90*time.Second + 600*time.Millisecond,
+			},
+		},
+		{
+			ID:       1,
+			Role:     db.Master,
+			Provider: db.Amazon,
+			BootTimings: db.BootTimings{
+				Instance:       time.Minute,
+				Minion:         2 * time.Minute,
+				ImagePull:      time.Minute,
+				FirstContainer: 30 * time.Second,
+			},
+		},
+	}
+
+	var b bytes.Buffer
+	writeTimingsTable(&b, machines)
+	result := strings.Replace(b.String(), " ", "_", -1)
+
+	exp := "MACHINE____ROLE______PROVIDER____INSTANCE____MINION____" +
+		"IMAGE_PULL____FIRST_CONTAINER____TOTAL\n" +
+		"1__________Master____Amazon______1m0s________2m0s______" +
+		"1m0s__________30s________________3m30s\n" +
+		"2__________Worker____Amazon______1m10s_______3m0s______" +
+		"1m31s____________________________4m10s\n"
+	assert.Equal(t, exp, result)
+
+	b.Reset()
+	writeTimingsJSON(&b, machines[:1])
+	assert.Equal(t, `[{"Machine":2,"Instance":70,"Minion":180.4,`+
+		`"ImagePull":90.6,"FirstContainer":0,"Total":250.4}]`+"\n", b.String())
+}
+
+func TestStatusTimings(t *testing.T) {
+	t.Parallel()
+
+	run := func(c *clientMock.Client) int {
+		mockGetter := new(testutils.Getter)
+		mockGetter.On("Client", mock.Anything).Return(c, nil)
+
+		cmd := &Status{timings: true, format: jsonFormat,
+			common: &commonFlags{}, clientGetter: mockGetter}
+		return cmd.Run()
+	}
+
+	assert.Equal(t, 0, run(&clientMock.Client{MachineReturn: []db.Machine{{}}}))
+	assert.Equal(t, 1, run(&clientMock.Client{MachineErr: errors.New("error")}))
+}
+
+func TestStatus(t *testing.T) {
+	t.Parallel()
+
+	run := func(leader *clientMock.Client, leaderErr error) int {
+		mockGetter := new(testutils.Getter)
+		mockGetter.On("Client", mock.Anything).Return(new(clientMock.Client), nil)
+		mockGetter.On("LeaderClient", mock.Anything).Return(leader, leaderErr)
+
+		cmd := &Status{format: jsonFormat, common: &commonFlags{},
+			clientGetter: mockGetter}
+		return cmd.Run()
+	}
+
+	// Healthy labels exit cleanly, so the command works as a smoke check.
+	assert.Equal(t, 0, run(&clientMock.Client{
+		StatusReturn: testStatuses[1:]}, nil))
+	assert.Equal(t, degradedExitCode, run(&clientMock.Client{
+		StatusReturn: testStatuses}, nil))
+
+	assert.Equal(t, 1, run(nil, errors.New("no leader")))
+	assert.Equal(t, 1, run(&clientMock.Client{
+		StatusErr: errors.New("error")}, nil))
+
+	// The deferred machine replacements are queried from the daemon.
+	mockGetter := new(testutils.Getter)
+	mockGetter.On("Client", mock.Anything).Return(&clientMock.Client{
+		MachineErr: errors.New("error")}, nil)
+	cmd := &Status{format: jsonFormat, common: &commonFlags{},
+		clientGetter: mockGetter}
+	assert.Equal(t, 1, cmd.Run())
+}
@@ -0,0 +1,354 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/NetSys/quilt/api/client"
+	"github.com/NetSys/quilt/api/client/getter"
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/quiltctl/ssh"
+)
+
+// Tunnel contains the options for forwarding local ports to containers' ports on
+// the overlay.
+type Tunnel struct {
+	privateKey string
+	localPort  int
+	targets    []tunnelTarget
+
+	common       *commonFlags
+	clientGetter client.Getter
+
+	// Stored in fields so that they may be mocked.
+	dialSSH func(host, keyPath string) (ssh.Dialer, error)
+	listen  func(network, addr string) (net.Listener, error)
+}
+
+// A tunnelTarget forwards `localPort` to `port` of a container of `label`.
+type tunnelTarget struct {
+	label     string
+	port      int
+	localPort int
+}
+
+// NewTunnelCommand creates a new Tunnel command instance.
+func NewTunnelCommand() *Tunnel {
+	return &Tunnel{
+		clientGetter: getter.New(),
+		common:       &commonFlags{},
+		dialSSH:      ssh.NewNativeDialer,
+		listen:       net.Listen,
+	}
+}
+
+// InstallFlags sets up parsing for command line flags.
+func (tCmd *Tunnel) InstallFlags(flags *flag.FlagSet) {
+	tCmd.common.InstallFlags(flags)
+
+	flags.StringVar(&tCmd.privateKey, "i", "",
+		"the private key to use to connect to the workers")
+	flags.IntVar(&tCmd.localPort, "local", 0,
+		"the local port to forward, if only one tunnel is given")
+
+	flags.Usage = func() {
+		fmt.Println("usage: quilt tunnel [-H=<daemon_host>] [-i=<private_key>] " +
+			"[-local=<port>] [<local_port>:]<label>:<port> ...")
+		fmt.Println("`tunnel` forwards local ports to containers that are " +
+			"only reachable on the overlay, through SSH connections to the " +
+			"workers hosting them.  Each tunnel picks a container of its " +
+			"label, and picks another if the container moves or stops.  " +
+			"The local port defaults to the container's port.  The tunnels " +
+			"stay open until interrupted.")
+		fmt.Println("For example, to reach port 80 of the admin label at " +
+			"localhost:8080: quilt tunnel -local 8080 admin:80")
+		flags.PrintDefaults()
+	}
+}
+
+// Parse parses the command line arguments for the tunnel command.
+func (tCmd *Tunnel) Parse(args []string) error {
+	if len(args) == 0 {
+		return errors.New("must specify at least one label:port to tunnel to")
+	}
+
+	if tCmd.localPort != 0 && len(args) != 1 {
+		return errors.New("-local may only be used with a single tunnel")
+	}
+
+	tCmd.targets = nil
+	localPorts := map[int]struct{}{}
+	for _, arg := range args {
+		target, err := parseTunnelTarget(arg)
+		if err != nil {
+			return err
+		}
+
+		if tCmd.localPort != 0 {
+			if target.localPort != target.port {
+				return errors.New("-local conflicts with the local " +
+					"port of " + arg)
+			}
+			target.localPort = tCmd.localPort
+		}
+
+		if target.localPort < 1 || target.localPort > 65535 {
+			return fmt.Errorf("malformed local port: %d", target.localPort)
+		}
+
+		if _, ok := localPorts[target.localPort]; ok {
+			return fmt.Errorf("local port %d is used by more than one "+
+				"tunnel", target.localPort)
+		}
+		localPorts[target.localPort] = struct{}{}
+		tCmd.targets = append(tCmd.targets, target)
+	}
+	return nil
+}
+
+// parseTunnelTarget parses a target of the form [<local_port>:]<label>:<port>.
+func parseTunnelTarget(arg string) (tunnelTarget, error) {
+	parts := strings.Split(arg, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return tunnelTarget{}, fmt.Errorf(
+			"expected [local_port:]label:port, got %s", arg)
+	}
+
+	portStr := parts[len(parts)-1]
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return tunnelTarget{}, fmt.Errorf("malformed port: %s", portStr)
+	}
+
+	target := tunnelTarget{label: parts[len(parts)-2], port: port,
+		localPort: port}
+	if target.label == "" {
+		return tunnelTarget{}, fmt.Errorf("missing label: %s", arg)
+	}
+
+	if len(parts) == 3 {
+		target.localPort, err = strconv.Atoi(parts[0])
+		if err != nil {
+			return tunnelTarget{}, fmt.Errorf("malformed local port: %s",
+				parts[0])
+		}
+	}
+	return target, nil
+}
+
+// Run opens the tunnels, and forwards connections through them until interrupted.
+func (tCmd *Tunnel) Run() int {
+	localClient, err := tCmd.clientGetter.Client(tCmd.common.host)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	defer localClient.Close()
+
+	var listeners []net.Listener
+	var tunnels []*tunnel
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		for _, t := range tunnels {
+			t.close()
+		}
+	}()
+
+	for _, target := range tCmd.targets {
+		listener, err := tCmd.listen("tcp",
+			fmt.Sprintf("127.0.0.1:%d", target.localPort))
+		if err != nil {
+			log.WithError(err).Errorf("Unable to listen on port %d.",
+				target.localPort)
+			return 1
+		}
+		listeners = append(listeners, listener)
+
+		t := &tunnel{tunnelTarget: target, cmd: tCmd, localClient: localClient}
+		tunnels = append(tunnels, t)
+		go t.serve(listener)
+
+		fmt.Printf("Forwarding localhost:%d to %s:%d\n", target.localPort,
+			target.label, target.port)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	<-interrupt
+
+	log.Info("Closing tunnels.")
+	return 0
+}
+
+// A tunnel forwards the connections accepted on its local port to a container of its
+// label, through an SSH connection to the worker hosting the container.
+type tunnel struct {
+	tunnelTarget
+	cmd         *Tunnel
+	localClient client.Client
+
+	// The container currently tunnelled to, and the SSH connection to its worker.
+	mu          sync.Mutex
+	host        string
+	containerIP string
+	dialer      ssh.Dialer
+}
+
+func (t *tunnel) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// The listener was closed.
+			return
+		}
+		go t.forward(conn)
+	}
+}
+
+// forward copies traffic between `local` and the container until either side
+// closes.
+func (t *tunnel) forward(local net.Conn) {
+	defer local.Close()
+
+	remote, err := t.dial()
+	if err != nil {
+		log.WithError(err).Errorf("Unable to tunnel to %s:%d.", t.label, t.port)
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// dial connects to the container through the SSH connection to its worker.  If the
+// container can't be reached, the label is resolved again and the SSH connection
+// reopened, as the container may have moved to another worker.
+func (t *tunnel) dial() (net.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.dialer != nil {
+		conn, err := t.dialer.Dial("tcp", t.addr())
+		if err == nil {
+			return conn, nil
+		}
+		log.WithError(err).Debugf("Unable to reach %s, reconnecting.", t.addr())
+		t.dialer.Close()
+		t.dialer = nil
+	}
+
+	host, containerIP, err := t.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	if t.host != "" && host != t.host {
+		log.Warnf("The container of %s moved from worker %s to %s, "+
+			"reconnecting.", t.label, t.host, host)
+	}
+
+	dialer, err := t.cmd.dialSSH(host, t.cmd.privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to SSH to %s: %s", host, err)
+	}
+	t.host, t.containerIP, t.dialer = host, containerIP, dialer
+	return t.dialer.Dial("tcp", t.addr())
+}
+
+// resolve returns the public IP of the worker hosting a container of the label, and
+// the container's IP.  The current container is preferred while it's running.
+func (t *tunnel) resolve() (host, containerIP string, err error) {
+	// Only the leader knows where each container is running.
+	leaderClient, err := t.cmd.clientGetter.LeaderClient(t.localClient)
+	if err != nil {
+		return "", "", err
+	}
+	defer leaderClient.Close()
+
+	containers, err := leaderClient.QueryContainers()
+	if err != nil {
+		return "", "", fmt.Errorf("unable to query containers: %s", err)
+	}
+
+	machines, err := t.localClient.QueryMachines()
+	if err != nil {
+		return "", "", fmt.Errorf("unable to query machines: %s", err)
+	}
+
+	publicIPs := map[string]string{}
+	for _, m := range machines {
+		if m.PrivateIP != "" && m.PublicIP != "" {
+			publicIPs[m.PrivateIP] = m.PublicIP
+		}
+	}
+
+	var chosen *db.Container
+	for i, dbc := range containers {
+		if dbc.IP == "" || publicIPs[dbc.Minion] == "" ||
+			!containsString(dbc.Labels, t.label) {
+			continue
+		}
+
+		if dbc.IP == t.containerIP {
+			chosen = &containers[i]
+			break
+		}
+
+		// Otherwise, choose deterministically.
+		if chosen == nil || dbc.StitchID < chosen.StitchID {
+			chosen = &containers[i]
+		}
+	}
+
+	if chosen == nil {
+		return "", "", fmt.Errorf("no running container of %s", t.label)
+	}
+	return publicIPs[chosen.Minion], chosen.IP, nil
+}
+
+func (t *tunnel) addr() string {
+	return fmt.Sprintf("%s:%d", t.containerIP, t.port)
+}
+
+// close closes the SSH connection, along with the connections forwarded through it.
+func (t *tunnel) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.dialer != nil {
+		t.dialer.Close()
+		t.dialer = nil
+	}
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
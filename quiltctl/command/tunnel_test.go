@@ -0,0 +1,167 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	clientMock "github.com/NetSys/quilt/api/client/mocks"
+	"github.com/NetSys/quilt/db"
+	"github.com/NetSys/quilt/quiltctl/ssh"
+	"github.com/NetSys/quilt/quiltctl/testutils"
+)
+
+func TestTunnelParse(t *testing.T) {
+	t.Parallel()
+
+	checkParse := func(args []string, expTargets []tunnelTarget, expErr string) {
+		tCmd := NewTunnelCommand()
+		flags := flag.NewFlagSet("tunnel", flag.ContinueOnError)
+		tCmd.InstallFlags(flags)
+		assert.NoError(t, flags.Parse(args))
+
+		err := tCmd.Parse(flags.Args())
+		if expErr != "" {
+			assert.EqualError(t, err, expErr)
+			return
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, expTargets, tCmd.targets)
+	}
+
+	checkParse([]string{"admin:80"},
+		[]tunnelTarget{{label: "admin", port: 80, localPort: 80}}, "")
+	checkParse([]string{"-local", "8080", "admin:80"},
+		[]tunnelTarget{{label: "admin", port: 80, localPort: 8080}}, "")
+	checkParse([]string{"8080:admin:80", "metrics:9090"},
+		[]tunnelTarget{
+			{label: "admin", port: 80, localPort: 8080},
+			{label: "metrics", port: 9090, localPort: 9090},
+		}, "")
+
+	checkParse(nil, nil, "must specify at least one label:port to tunnel to")
+	checkParse([]string{"-local", "8080", "admin:80", "metrics:9090"}, nil,
+		"-local may only be used with a single tunnel")
+	checkParse([]string{"admin"}, nil, "expected [local_port:]label:port, got admin")
+	checkParse([]string{"admin:http"}, nil, "malformed port: http")
+	checkParse([]string{":80"}, nil, "missing label: :80")
+	checkParse([]string{"70000:admin:80"}, nil, "malformed local port: 70000")
+	checkParse([]string{"admin:80", "80:metrics:9090"}, nil,
+		"local port 80 is used by more than one tunnel")
+}
+
+// fakeWorkers are SSH servers that can reach the containers listening on them.
+type fakeWorkers struct {
+	listening map[string]map[string]bool // Worker -> container address -> up.
+	dialed    []string
+	closed    []string
+}
+
+func (workers *fakeWorkers) dialSSH(host, keyPath string) (ssh.Dialer, error) {
+	if _, ok := workers.listening[host]; !ok {
+		return nil, errors.New("connection refused")
+	}
+	workers.dialed = append(workers.dialed, host)
+	return fakeDialer{workers, host}, nil
+}
+
+type fakeDialer struct {
+	workers *fakeWorkers
+	host    string
+}
+
+// Dial connects to a container that echoes what it's sent.
+func (d fakeDialer) Dial(network, addr string) (net.Conn, error) {
+	if !d.workers.listening[d.host][addr] {
+		return nil, errors.New("no route to host")
+	}
+
+	local, remote := net.Pipe()
+	go io.Copy(remote, remote)
+	return local, nil
+}
+
+func (d fakeDialer) Close() error {
+	d.workers.closed = append(d.workers.closed, d.host)
+	return nil
+}
+
+func TestTunnelDial(t *testing.T) {
+	t.Parallel()
+
+	localClient := &clientMock.Client{MachineReturn: []db.Machine{
+		{PrivateIP: "172.16.0.2", PublicIP: "1.1.1.1"},
+		{PrivateIP: "172.16.0.3", PublicIP: "2.2.2.2"},
+	}}
+	leaderClient := &clientMock.Client{ContainerReturn: []db.Container{
+		{StitchID: 2, IP: "10.0.0.3", Minion: "172.16.0.3",
+			Labels: []string{"admin"}},
+		{StitchID: 1, IP: "10.0.0.2", Minion: "172.16.0.2",
+			Labels: []string{"admin"}},
+		{StitchID: 3, IP: "10.0.0.4", Minion: "172.16.0.3",
+			Labels: []string{"web"}},
+	}}
+
+	mockGetter := new(testutils.Getter)
+	mockGetter.On("LeaderClient", mock.Anything).Return(leaderClient, nil)
+
+	workers := &fakeWorkers{listening: map[string]map[string]bool{
+		"1.1.1.1": {"10.0.0.2:80": true},
+		"2.2.2.2": {"10.0.0.3:80": true},
+	}}
+
+	tun := &tunnel{
+		tunnelTarget: tunnelTarget{label: "admin", port: 80, localPort: 8080},
+		cmd: &Tunnel{
+			privateKey:   "key",
+			clientGetter: mockGetter,
+			dialSSH:      workers.dialSSH,
+		},
+		localClient: localClient,
+	}
+
+	checkEcho := func() {
+		local, remote := net.Pipe()
+		go tun.forward(remote)
+		defer local.Close()
+
+		_, err := local.Write([]byte("ping"))
+		assert.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(local, buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+	}
+
+	// The container with the lowest stitch ID is chosen, and the SSH connection to
+	// its worker is reused.
+	checkEcho()
+	checkEcho()
+	assert.Equal(t, []string{"1.1.1.1"}, workers.dialed)
+	assert.Equal(t, "10.0.0.2", tun.containerIP)
+
+	// The container moves to the other worker.
+	leaderClient.ContainerReturn = []db.Container{
+		{StitchID: 2, IP: "10.0.0.3", Minion: "172.16.0.3",
+			Labels: []string{"admin"}},
+	}
+	workers.listening["1.1.1.1"] = map[string]bool{}
+	checkEcho()
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, workers.dialed)
+	assert.Equal(t, []string{"1.1.1.1"}, workers.closed)
+	assert.Equal(t, "10.0.0.3", tun.containerIP)
+
+	// With no container of the label left, the tunnel fails.
+	leaderClient.ContainerReturn = nil
+	workers.listening["2.2.2.2"] = map[string]bool{}
+	_, err := tun.dial()
+	assert.EqualError(t, err, "no running container of admin")
+
+	tun.close()
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, workers.closed)
+}
@@ -0,0 +1,73 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/NetSys/quilt/stitch"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/robertkrimen/otto"
+)
+
+// Vendor contains the options for vendoring the imports of Stitches.
+type Vendor struct {
+	stitch string
+
+	// Stored in a field so that it may be mocked.
+	getter stitch.ImportGetter
+}
+
+// NewVendorCommand creates a new Vendor command instance.
+func NewVendorCommand() *Vendor {
+	getter := stitch.DefaultImportGetter
+	getter.AutoDownload = true
+	return &Vendor{getter: getter}
+}
+
+// InstallFlags sets up parsing for command line flags.
+func (vCmd *Vendor) InstallFlags(flags *flag.FlagSet) {
+	flags.Usage = func() {
+		fmt.Println("usage: quilt vendor <stitch>")
+		fmt.Printf("`vendor` copies the repos the stitch imports, directly or "+
+			"through its imports, from %s into %s/ next to the stitch, and "+
+			"records their revisions and hashes in %s/%s.  Repos that "+
+			"haven't been downloaded are downloaded first.  Afterwards, "+
+			"`run` and `check` import the vendored copies, and refuse "+
+			"them if they've been modified.\n", stitch.QuiltPathKey,
+			stitch.VendorDir, stitch.VendorDir, stitch.LockfileName)
+		flags.PrintDefaults()
+	}
+}
+
+// Parse parses the command line arguments for the vendor command.
+func (vCmd *Vendor) Parse(args []string) error {
+	if len(args) != 1 {
+		return errors.New("must specify exactly one stitch to vendor")
+	}
+	vCmd.stitch = args[0]
+	return nil
+}
+
+// Run vendors the imports of the stitch.
+func (vCmd *Vendor) Run() int {
+	lock, err := stitch.Vendor(vCmd.stitch, vCmd.getter)
+	if err != nil {
+		// Print the stacktrace if it's an Otto error.
+		if ottoError, ok := err.(*otto.Error); ok {
+			log.Error(ottoError.String())
+		} else {
+			log.Error(err)
+		}
+		return 1
+	}
+
+	dir := filepath.Join(filepath.Dir(vCmd.stitch), stitch.VendorDir)
+	for _, repo := range lock.Repos {
+		fmt.Printf("Vendored %s at %s\n", repo.Source, repo.Revision)
+	}
+	fmt.Printf("Vendored %d repos into %s.\n", len(lock.Repos), dir)
+	return 0
+}
@@ -11,18 +11,28 @@ import (
 )
 
 var commands = map[string]command.SubCommand{
-	"containers": command.NewContainerCommand(),
-	"daemon":     command.NewDaemonCommand(),
-	"exec":       command.NewExecCommand(ssh.NewNativeClient()),
-	"get":        &command.Get{},
-	"inspect":    &command.Inspect{},
-	"logs":       command.NewLogCommand(ssh.NewNativeClient()),
-	"machines":   command.NewMachineCommand(),
-	"minion":     &command.Minion{},
-	"ps":         command.NewPsCommand(),
-	"run":        command.NewRunCommand(),
-	"ssh":        command.NewSSHCommand(),
-	"stop":       command.NewStopCommand(),
+	"check":           command.NewCheckCommand(),
+	"containers":      command.NewContainerCommand(),
+	"counters":        command.NewCountersCommand(),
+	"daemon":          command.NewDaemonCommand(),
+	"drain":           command.NewDrainCommand(),
+	"exec":            command.NewExecCommand(ssh.NewNativeClient()),
+	"export":          command.NewExportCommand(),
+	"freeze-machines": command.NewFreezeMachinesCommand(),
+	"get":             &command.Get{},
+	"inspect":         &command.Inspect{},
+	"logs":            command.NewLogCommand(ssh.NewNativeClient()),
+	"machines":        command.NewMachineCommand(),
+	"minion":          &command.Minion{},
+	"nettest":         command.NewNetTestCommand(),
+	"promote-canary":  command.NewPromoteCanaryCommand(),
+	"ps":              command.NewPsCommand(),
+	"run":             command.NewRunCommand(),
+	"ssh":             command.NewSSHCommand(),
+	"status":          command.NewStatusCommand(),
+	"stop":            command.NewStopCommand(),
+	"tunnel":          command.NewTunnelCommand(),
+	"vendor":          command.NewVendorCommand(),
 }
 
 // Run parses and runs the quiltctl subcommand given the command line arguments.
@@ -31,11 +31,30 @@ func NewNativeClient() *NativeClient {
 
 // Connect establishes an SSH session with reasonable, quilt-specific defaults.
 func (c *NativeClient) Connect(host string, keyPath string) error {
+	conn, err := dial(host, keyPath)
+	if err != nil {
+		return err
+	}
+
+	c.session, err = conn.NewSession()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// NewNativeDialer opens an SSH connection to `host` with the same defaults as
+// Connect, through which connections may be dialed.
+func NewNativeDialer(host string, keyPath string) (Dialer, error) {
+	return dial(host, keyPath)
+}
+
+func dial(host string, keyPath string) (*ssh.Client, error) {
 	var auth ssh.AuthMethod
 	if keyPath != "" {
 		signer, err := signerFromFile(keyPath)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		auth = ssh.PublicKeys(signer)
 	} else {
@@ -46,16 +65,7 @@ func (c *NativeClient) Connect(host string, keyPath string) error {
 		Auth: []ssh.AuthMethod{auth},
 	}
 
-	conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", host), sshConfig)
-	if err != nil {
-		return err
-	}
-
-	c.session, err = conn.NewSession()
-	if err != nil {
-		return err
-	}
-	return nil
+	return ssh.Dial("tcp", fmt.Sprintf("%s:22", host), sshConfig)
 }
 
 var defaultKeys = []string{"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519"}
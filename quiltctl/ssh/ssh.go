@@ -1,5 +1,7 @@
 package ssh
 
+import "net"
+
 // Client is an SSH client used for `quilt` commands.
 type Client interface {
 	// Connect establishes an SSH connection.
@@ -11,3 +13,12 @@ type Client interface {
 	// Disconnect closes the SSH connection.
 	Disconnect() error
 }
+
+// Dialer opens connections to addresses reachable from an SSH server, as with
+// `ssh -L`.
+type Dialer interface {
+	// Dial connects to `addr` from the SSH server.
+	Dial(network, addr string) (net.Conn, error)
+	// Close closes the SSH connection, and the connections dialed through it.
+	Close() error
+}
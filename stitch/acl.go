@@ -0,0 +1,48 @@
+package stitch
+
+import (
+	"net"
+	"sort"
+)
+
+// RoleACL returns the ACL the minions restrict SSH into machines of `role` to: the
+// role's own ACL if it has any valid CIDRs, and otherwise the AdminACL.  The cloud
+// provider's firewall admits every role's CIDRs to SSH, so it can't enforce the
+// roles' ACLs itself.
+func (stitch Stitch) RoleACL(role string) []string {
+	var acl []string
+	switch role {
+	case "Master":
+		acl = stitch.MasterACL
+	case "Worker":
+		acl = stitch.WorkerACL
+	}
+
+	for _, cidr := range acl {
+		if _, _, err := net.ParseCIDR(cidr); err == nil {
+			return acl
+		}
+	}
+	return stitch.AdminACL
+}
+
+// EffectiveACL returns the CIDRs allowed to SSH into `machine`, which are those of the
+// RoleACL of its role.  The CIDRs are normalized, de-duplicated, and sorted.  Entries
+// that aren't CIDRs, such as "local", are returned as is.
+func (stitch Stitch) EffectiveACL(machine Machine) []string {
+	seen := map[string]struct{}{}
+	for _, cidr := range stitch.RoleACL(machine.Role) {
+		// Normalize the CIDR so that it matches the output of `iptables -S`.
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			cidr = ipNet.String()
+		}
+		seen[cidr] = struct{}{}
+	}
+
+	result := []string{}
+	for cidr := range seen {
+		result = append(result, cidr)
+	}
+	sort.Strings(result)
+	return result
+}
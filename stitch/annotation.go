@@ -0,0 +1,48 @@
+package stitch
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// An annotatedConnection is a connection as written by the Javascript bindings.  If
+// ToAnnotation is set, it stands for a connection to each label with that
+// annotation, and To is empty.
+type annotatedConnection struct {
+	Connection
+	ToAnnotation string
+}
+
+// Annotations selecting labels must be of the form key=value.
+var annotationSelectorRE = regexp.MustCompile(`^[A-Za-z0-9_.-]+=[A-Za-z0-9_.-]+$`)
+
+// expandAnnotatedConnections replaces each connection to an annotation with a
+// connection to every label that has it, in the order of `labels`.  This is exactly
+// the connections that would have been created by connecting to each label by name.
+func expandAnnotatedConnections(conns []annotatedConnection,
+	labels []Label) ([]Connection, error) {
+
+	// Preserve an empty list of connections, rather than dropping it.
+	expanded := make([]Connection, 0, len(conns))
+	for _, ac := range conns {
+		if ac.ToAnnotation == "" {
+			expanded = append(expanded, ac.Connection)
+			continue
+		}
+
+		if !annotationSelectorRE.MatchString(ac.ToAnnotation) {
+			return nil, fmt.Errorf("%s connects to an invalid annotation %q: "+
+				"annotations must be of the form key=value", ac.From,
+				ac.ToAnnotation)
+		}
+
+		for _, label := range labels {
+			if label.HasAnnotation(ac.ToAnnotation) {
+				conn := ac.Connection
+				conn.To = label.Name
+				expanded = append(expanded, conn)
+			}
+		}
+	}
+	return expanded, nil
+}
@@ -2,6 +2,9 @@
 
 package stitch
 
+// The SHA-256 checksum of the bindings source this file was generated from.
+const bindingsChecksum = "c7be55d97e87f98942d90ff97c9a4cc34ae1ac8deea3ab87a4d1521e6c6341c8"
+
 var javascriptBindings = `// The default deployment object. createDeployment overwrites this.
 var deployment = new Deployment({});
 
@@ -21,6 +24,19 @@ function Deployment(deploymentOpts) {
     this.maxPrice = deploymentOpts.maxPrice || 0;
     this.namespace = deploymentOpts.namespace || "default-namespace";
     this.adminACL = deploymentOpts.adminACL || [];
+    this.masterACL = deploymentOpts.masterACL || [];
+    this.workerACL = deploymentOpts.workerACL || [];
+    this.tcpKeepalive = deploymentOpts.tcpKeepalive || {};
+    this.networkTuning = deploymentOpts.networkTuning || {};
+    this.natBackend = deploymentOpts.natBackend || "";
+    this.natInterval = deploymentOpts.natInterval || 0;
+    this.maxNATRules = deploymentOpts.maxNATRules || 0;
+    this.publicInterface = deploymentOpts.publicInterface || "";
+    this.defaultSpread = deploymentOpts.defaultSpread || false;
+    this.encrypted = false;
+    this.maintenance = {};
+    this.networks = [];
+    this.autoscale = {};
 
     this.machines = [];
     this.containers = {};
@@ -28,14 +44,19 @@ function Deployment(deploymentOpts) {
     this.connections = [];
     this.placements = [];
     this.invariants = [];
+    this.hostConnections = [];
+    this.loadBalancers = [];
 }
 
 // Convert the deployment to the QRI deployment format.
 Deployment.prototype.toQuiltRepresentation = function() {
     this.vet();
 
-    // Map from container ID to container.
-    var containerMap = {};
+    // Containers are renumbered in the order they're deployed, so that the IDs
+    // don't depend on how many intermediate containers the spec created.  Parsing
+    // the same spec therefore always produces the same IDs.
+    var containers = [];
+    var deployedIDs = {}; // From a container's ID to its deployed ID.
 
     var services = [];
     var connections = [];
@@ -47,23 +68,57 @@ Deployment.prototype.toQuiltRepresentation = function() {
         connections = connections.concat(service.getQuiltConnections());
         placements = placements.concat(service.getQuiltPlacements());
 
-        // Collect the containers IDs, and add them to the container map.
+        // Collect the containers IDs, numbering containers the first time
+        // they're seen.
         var ids = [];
         service.containers.forEach(function(container) {
-            ids.push(container.id);
-            containerMap[container.id] = container;
+            if (deployedIDs[container.id] === undefined) {
+                // _.clone would also copy the prototype's methods.
+                var deployed = {};
+                Object.keys(container).forEach(function(key) {
+                    deployed[key] = container[key];
+                });
+                deployed.id = containers.length + 1;
+                deployed.network = service.network;
+                deployedIDs[container.id] = deployed.id;
+                containers.push(deployed);
+            }
+            ids.push(deployedIDs[container.id]);
         });
 
         services.push({
             name: service.name,
             ids: ids,
-            annotations: service.annotations
+            annotations: service.annotations,
+            hostnames: service.hostnames,
+            network: service.network
         });
     });
 
-    var containers = [];
-    Object.keys(containerMap).forEach(function(cid) {
-        containers.push(containerMap[cid]);
+    // Sidecars refer to their primaries by ID, so they must be renumbered as well.
+    containers.forEach(function(container) {
+        if (container.sidecarOf === 0) {
+            return;
+        }
+
+        var primaryID = deployedIDs[container.sidecarOf];
+        if (primaryID === undefined) {
+            throw "container " + container.id + " is a sidecar of an " +
+                "undeployed container";
+        }
+        container.sidecarOf = primaryID;
+    });
+
+    this.hostConnections.forEach(function(conn) {
+        connections.push({
+            from: publicInternetLabel,
+            to: "",
+            minPort: conn.minPort,
+            maxPort: conn.maxPort,
+            protocol: conn.protocol,
+            hostNetwork: true,
+            ephemeral: conn.ephemeral
+        });
     });
 
     return {
@@ -73,9 +128,23 @@ Deployment.prototype.toQuiltRepresentation = function() {
         connections: connections,
         placements: placements,
         invariants: this.invariants,
+        loadBalancers: this.loadBalancers,
 
         namespace: this.namespace,
         adminACL: this.adminACL,
+        masterACL: this.masterACL,
+        workerACL: this.workerACL,
+        encryptTraffic: this.encrypted,
+        tcpKeepalive: this.tcpKeepalive,
+        networkTuning: this.networkTuning,
+        natBackend: this.natBackend,
+        natInterval: this.natInterval,
+        maxNATRules: this.maxNATRules,
+        publicInterface: this.publicInterface,
+        maintenanceWindow: this.maintenance,
+        networks: this.networks,
+        defaultSpread: this.defaultSpread,
+        autoscaleWorkers: this.autoscale,
         maxPrice: this.maxPrice
     };
 };
@@ -89,6 +158,11 @@ Deployment.prototype.vet = function() {
 
     this.services.forEach(function(service) {
         service.connections.forEach(function(conn) {
+            // Connections to annotated services only target deployed services.
+            if (conn.annotation !== undefined) {
+                return;
+            }
+
             var to = conn.to.name;
             if (!labelMap[to]) {
                 throw service.name + " has a connection to undeployed service: " + to;
@@ -125,11 +199,80 @@ Deployment.prototype.assert = function(rule, desired) {
     this.invariants.push(new Assertion(rule, desired));
 };
 
+// Allow the public internet to connect to the given port range on the workers
+// themselves, for services bound to the host's network rather than the overlay.
+// Unlike connections to containers, the traffic isn't forwarded anywhere.
+Deployment.prototype.openHostPort = function(range, protocol) {
+    range = boxRange(range);
+    protocol = checkProtocol(protocol);
+    if (range instanceof Icmp || protocol === "icmp") {
+        throw "host ports cannot be ICMP";
+    }
+    this.hostConnections.push(new Connection(range, null, protocol));
+};
+
+// Restrict when voluntary disruptive actions, such as replacing machines whose
+// configuration changed and rolling out new images, may begin to a weekly window,
+// e.g. {days: ["Sat", "Sun"], start: "02:00", end: "06:00",
+// tz: "America/Los_Angeles"}.  Dead machines are still replaced right away.
+Deployment.prototype.maintenanceWindow = function(window) {
+    this.maintenance = {
+        days: window.days || [],
+        start: window.start || "",
+        end: window.end || "",
+        tz: window.tz || ""
+    };
+};
+
+// Encrypt the traffic tunneled between worker machines.
+Deployment.prototype.encryptTraffic = function(enabled) {
+    this.encrypted = (enabled !== false);
+};
+
+// Have the daemon boot workers from ` + "`" + `opts.template` + "`" + `, a Machine, while containers
+// can't be scheduled, keeping between ` + "`" + `opts.min` + "`" + ` and ` + "`" + `opts.max` + "`" + ` of them in addition to
+// the deployed machines.  Autoscaled workers that have been idle for a while are
+// drained and terminated.
+Deployment.prototype.autoscaleWorkers = function(opts) {
+    if (!(opts.template instanceof Machine)) {
+        throw "autoscaleWorkers requires a Machine template";
+    }
+
+    var min = opts.min || 0;
+    var max = opts.max || 0;
+    if (min < 0 || max < 1 || min > max) {
+        throw "autoscaleWorkers requires 0 <= min <= max, and max > 0";
+    }
+
+    var template = opts.template;
+    if (template.role === "") {
+        template = template.asWorker();
+    }
+    this.autoscale = {min: min, max: max, template: template};
+};
+
+// Create an isolated container network named ` + "`" + `name` + "`" + `, which services join with
+// inNetwork().  Traffic between networks is dropped, unless it's over a connection
+// made with ` + "`" + `allowCrossNetwork: true` + "`" + `.  Each network is given its own subnet, so a
+// deployment can create at most 128 of them, and each machine can run at most 32
+// containers in each network.
+Deployment.prototype.createNetwork = function(name) {
+    if (typeof name !== "string" || name === "") {
+        throw "networks must have a name";
+    }
+    if (this.networks.indexOf(name) !== -1) {
+        throw "network " + name + " already exists";
+    }
+    this.networks.push(name);
+};
+
 function Service(name, containers) {
     this.name = uniqueLabelName(name);
     this.containers = containers;
     this.annotations = [];
+    this.hostnames = [];
     this.placements = [];
+    this.network = "";
 
     this.connections = [];
     this.outgoingPublic = [];
@@ -151,10 +294,30 @@ Service.prototype.children = function() {
     return res;
 };
 
+// Publish an external hostname for the service.  If opts.splitHorizon is true, the
+// deployment's own containers resolve the hostname to the service's internal
+// addresses, so that connecting to it doesn't leave the overlay.
+Service.prototype.publishHostname = function(name, opts) {
+    opts = opts || {};
+    this.hostnames.push({
+        name: name,
+        splitHorizon: opts.splitHorizon === true
+    });
+};
+
 Service.prototype.annotate = function(annotation) {
     this.annotations.push(annotation);
 };
 
+// Move the service into the isolated network ` + "`" + `name` + "`" + `, which must be created with
+// deployment.createNetwork().
+Service.prototype.inNetwork = function(name) {
+    if (typeof name !== "string" || name === "") {
+        throw "inNetwork requires the name of a network";
+    }
+    this.network = name;
+};
+
 Service.prototype.canReach = function(target) {
     if (target === publicInternet) {
         return reachable(this.name, publicInternetLabel);
@@ -179,20 +342,150 @@ Service.prototype.deploy = function(deployment) {
     deployment.services.push(this);
 };
 
-Service.prototype.connect = function(range, to) {
+// Allow traffic to the destination service on the given port range.  If the protocol is
+// "tcp" or "udp", only that protocol is allowed, otherwise both are.  Passing an Icmp
+// in place of the port range allows ICMP traffic instead.  A list of ports and port
+// ranges is equivalent to connecting on each of them separately.
+//
+// In place of the protocol, an object may be passed with the optional fields
+// ` + "`" + `protocol` + "`" + `, ` + "`" + `dscp` + "`" + `, ` + "`" + `allowCrossNetwork` + "`" + `, ` + "`" + `allowCrossRegion` + "`" + `, and ` + "`" + `requireMTLS` + "`" + `.  A
+// ` + "`" + `dscp` + "`" + ` between 0 and 63 marks the traffic the service sends over the connection
+// with that DSCP value, e.g. 46 for expedited forwarding.  ` + "`" + `allowCrossNetwork` + "`" + ` allows
+// the connection between services in different networks, ` + "`" + `allowCrossRegion` + "`" + ` exempts
+// it from the coRegional invariant, and ` + "`" + `requireMTLS` + "`" + ` marks it as requiring mutual
+// TLS for the service mesh.  Only connections from the public internet may set a
+// ` + "`" + `probe` + "`" + `, as described in publicInternet.connect().
+Service.prototype.connect = function(range, to, options) {
+    if (Array.isArray(range)) {
+        var that = this;
+        range.forEach(function(r) {
+            that.connect(r, to, options);
+        });
+        return;
+    }
+
+    var opts = connectOptions(options);
     range = boxRange(range);
+    var protocol = rangeProtocol(range, opts.protocol);
+    if (opts.probe !== null) {
+        throw "only connections from the public internet can be probed";
+    }
     if (to === publicInternet) {
-        return this.connectToPublic(range);
+        if (opts.dscp) {
+            throw "connections to the public internet cannot set DSCP";
+        }
+        if (opts.requireMTLS) {
+            throw "connections to the public internet cannot require mTLS";
+        }
+        return this.connectToPublic(range, protocol);
+    }
+
+    var conn = new Connection(range, to, protocol);
+    conn.dscp = opts.dscp;
+    conn.allowCrossNetwork = opts.allowCrossNetwork;
+    conn.allowCrossRegion = opts.allowCrossRegion;
+    conn.requireMTLS = opts.requireMTLS;
+    this.connections.push(conn);
+};
+
+// Allow traffic on the given port range to every deployed service annotated with
+// ` + "`" + `annotation` + "`" + `, which must be of the form "key=value", e.g. "tier=data".  The
+// services are selected once the deployment is complete, so this is equivalent to
+// connecting to each of them in the order they were deployed.  The range and options
+// are as in connect().
+Service.prototype.connectToAnnotated = function(range, annotation, options) {
+    if (Array.isArray(range)) {
+        var that = this;
+        range.forEach(function(r) {
+            that.connectToAnnotated(r, annotation, options);
+        });
+        return;
+    }
+
+    if (typeof annotation !== "string") {
+        throw "connectToAnnotated requires an annotation of the form key=value";
+    }
+
+    var opts = connectOptions(options);
+    if (opts.probe !== null) {
+        throw "only connections from the public internet can be probed";
+    }
+    range = boxRange(range);
+    var conn = new Connection(range, null, rangeProtocol(range, opts.protocol));
+    conn.dscp = opts.dscp;
+    conn.allowCrossNetwork = opts.allowCrossNetwork;
+    conn.allowCrossRegion = opts.allowCrossRegion;
+    conn.requireMTLS = opts.requireMTLS;
+    conn.annotation = annotation;
+    this.connections.push(conn);
+};
+
+// Split the options of connect(), which are either a protocol or an object with the
+// optional fields ` + "`" + `protocol` + "`" + `, ` + "`" + `dscp` + "`" + `, ` + "`" + `allowCrossNetwork` + "`" + `, ` + "`" + `allowCrossRegion` + "`" + `,
+// ` + "`" + `requireMTLS` + "`" + `, and ` + "`" + `probe` + "`" + `.
+function connectOptions(options) {
+    if (options !== null && typeof options === "object") {
+        return {
+            protocol: options.protocol,
+            dscp: options.dscp || 0,
+            allowCrossNetwork: options.allowCrossNetwork === true,
+            allowCrossRegion: options.allowCrossRegion === true,
+            requireMTLS: options.requireMTLS === true,
+            probe: connectProbe(options.probe)
+        };
+    }
+    return {protocol: options, dscp: 0, allowCrossNetwork: false,
+        allowCrossRegion: false, requireMTLS: false, probe: null};
+}
+
+// Convert the ` + "`" + `probe` + "`" + ` option of connect() to the form of the Quilt connection, or null
+// if there is none.
+function connectProbe(probe) {
+    if (probe === undefined || probe === null) {
+        return null;
+    }
+    if (typeof probe.udpQuery !== "string") {
+        throw "probes require a udpQuery of hex encoded bytes";
+    }
+    return {
+        udpQuery: probe.udpQuery,
+        expectResponse: probe.expectResponse === true
+    };
+}
+
+// Limit the bits per second each container in the service may send over its
+// connections to the destination service.  The connections must already exist.
+Service.prototype.limitBandwidth = function(to, bitsPerSecond) {
+    var limited = false;
+    this.connections.forEach(function(conn) {
+        if (conn.to === to) {
+            conn.bandwidthLimit = bitsPerSecond;
+            limited = true;
+        }
+    });
+    if (!limited) {
+        throw this.name + " has no connections to " + to.name + " to limit";
     }
-    this.connections.push(new Connection(range, to));
 };
 
 // publicInternet is an object that looks like another service that can be
 // connected to or from. However, it is actually just syntactic sugar to hide
 // the connectToPublic and connectFromPublic functions.
+//
+// The options of publicInternet.connect() are as in connect().  Connections on a single
+// UDP port may also set a ` + "`" + `probe` + "`" + ` of the form {udpQuery: hexPayload, expectResponse:
+// true}, which the workers periodically send to each container.  A container that
+// doesn't accept the query, or if ` + "`" + `expectResponse` + "`" + ` is set doesn't reply to it, stops
+// receiving public traffic on the port until it passes the probe again.
 var publicInternet = {
-    connect: function(range, to) {
-        to.connectFromPublic(range);
+    connect: function(range, to, options) {
+        if (Array.isArray(range)) {
+            range.forEach(function(r) {
+                to.connectFromPublic(r, options);
+            });
+            return;
+        }
+        to.connectFromPublic(range, options);
     },
     canReach: function(to) {
         return reachable(publicInternetLabel, to.name);
@@ -200,55 +493,106 @@ var publicInternet = {
 };
 
 // Allow outbound traffic from the service to public internet.
-Service.prototype.connectToPublic = function(range) {
+Service.prototype.connectToPublic = function(range, protocol) {
     range = boxRange(range);
-    if (range.min != range.max) {
+    if (range.min != range.max && !range.ephemeral) {
         throw "public internet cannot connect on port ranges";
     }
-    this.outgoingPublic.push(range);
+    this.outgoingPublic.push(new Connection(range, publicInternet,
+        rangeProtocol(range, protocol)));
 };
 
-// Allow inbound traffic from public internet to the service.
-Service.prototype.connectFromPublic = function(range) {
+// Allow inbound traffic from public internet to the service.  Of the options of
+// connect(), only ` + "`" + `protocol` + "`" + ` and ` + "`" + `probe` + "`" + ` apply.
+Service.prototype.connectFromPublic = function(range, options) {
+    var opts = connectOptions(options);
     range = boxRange(range);
-    if (range.min != range.max) {
+    if (range.min != range.max && !range.ephemeral) {
         throw "public internet cannot connect on port ranges";
     }
-    this.incomingPublic.push(range);
+    var conn = new Connection(range, publicInternet,
+        rangeProtocol(range, opts.protocol));
+    if (opts.probe !== null) {
+        conn.probe = opts.probe;
+    }
+    this.incomingPublic.push(conn);
 };
 
 Service.prototype.place = function(rule) {
     this.placements.push(rule);
 };
 
+// Add canaries to the service: opts.count (1 by default) copies of its first
+// container, running opts.image instead.  The canaries share the service's
+// connections and load balancing until ` + "`" + `quilt promote-canary` + "`" + ` either rolls the rest
+// of the service onto the canaries' image, or aborts and removes them.
+Service.prototype.canary = function(opts) {
+    opts = opts || {};
+    if (!opts.image) {
+        throw "canary of " + this.name + " must specify an image";
+    }
+    if (this.containers.length === 0) {
+        throw "cannot canary " + this.name + " because it has no containers";
+    }
+
+    var count = (opts.count === undefined) ? 1 : opts.count;
+    var canary = this.containers[0].clone();
+    canary.image = opts.image;
+    canary.canary = true;
+    this.containers = this.containers.concat(canary.replicate(count));
+};
+
 Service.prototype.getQuiltConnections = function() {
     var connections = [];
     var that = this;
 
     this.connections.forEach(function(conn) {
+        // Connections to annotated services are expanded into a connection to
+        // each of them once the deployment is parsed.
+        var to = "";
+        var annotation = "";
+        if (conn.annotation !== undefined) {
+            annotation = conn.annotation;
+        } else {
+            to = conn.to.name;
+        }
+
         connections.push({
             from: that.name,
-            to: conn.to.name,
+            to: to,
+            toAnnotation: annotation,
             minPort: conn.minPort,
-            maxPort: conn.maxPort
+            maxPort: conn.maxPort,
+            protocol: conn.protocol,
+            bandwidthLimit: conn.bandwidthLimit,
+            dscp: conn.dscp,
+            allowCrossNetwork: conn.allowCrossNetwork,
+            allowCrossRegion: conn.allowCrossRegion,
+            requireMTLS: conn.requireMTLS,
+            ephemeral: conn.ephemeral
         });
     });
 
-    this.outgoingPublic.forEach(function(rng) {
+    this.outgoingPublic.forEach(function(conn) {
         connections.push({
             from: that.name,
             to: publicInternetLabel,
-            minPort: rng.min,
-            maxPort: rng.max
+            minPort: conn.minPort,
+            maxPort: conn.maxPort,
+            protocol: conn.protocol,
+            ephemeral: conn.ephemeral
         });
     });
 
-    this.incomingPublic.forEach(function(rng) {
+    this.incomingPublic.forEach(function(conn) {
         connections.push({
             from: publicInternetLabel,
             to: that.name,
-            minPort: rng.min,
-            maxPort: rng.max
+            minPort: conn.minPort,
+            maxPort: conn.maxPort,
+            protocol: conn.protocol,
+            ephemeral: conn.ephemeral,
+            probe: conn.probe
         });
     });
 
@@ -266,12 +610,31 @@ Service.prototype.getQuiltPlacements = function() {
             otherLabel: placement.otherLabel || "",
             provider: placement.provider || "",
             size: placement.size || "",
-            region: placement.region || ""
+            region: placement.region || "",
+            floatingIP: placement.floatingIP || false,
+            gpu: placement.gpu || false
         });
     });
     return placements;
 };
 
+// A load balancer, provisioned by the cloud provider, in front of ` + "`" + `target` + "`" + `, which may
+// be a Service or the name of one.  It listens on ` + "`" + `opts.listenerPort` + "`" + `, and forwards
+// to ` + "`" + `opts.backendPort` + "`" + ` on the service's containers.  The backend port defaults to
+// the listener port.  If ` + "`" + `opts.healthPath` + "`" + ` is set, only containers that respond to
+// HTTP requests for it receive traffic.
+function LoadBalancer(target, opts) {
+    opts = opts || {};
+    this.targetLabel = (target instanceof Service) ? target.name : target;
+    this.listenerPort = opts.listenerPort || 0;
+    this.backendPort = opts.backendPort || this.listenerPort;
+    this.healthPath = opts.healthPath || "";
+}
+
+LoadBalancer.prototype.deploy = function(deployment) {
+    deployment.loadBalancers.push(this);
+};
+
 var labelNameCount = {};
 function uniqueLabelName(name) {
     if (!(name in labelNameCount)) {
@@ -289,42 +652,133 @@ function boxRange(x) {
     if (x === undefined) {
         return new Range(0, 0);
     }
-    if (typeof x === "number") {
+    if (typeof x === "number" || typeof x === "string") {
         x = new Range(x, x);
     }
     return x;
 }
 
+// The bytes in each unit a size may be given in.  Units must be spelled out, as
+// "4G", for example, could mean either GB or GiB.
+var sizeUnits = {
+    "B": 1,
+    "KB": 1e3,
+    "MB": 1e6,
+    "GB": 1e9,
+    "TB": 1e12,
+    "KiB": Math.pow(2, 10),
+    "MiB": Math.pow(2, 20),
+    "GiB": Math.pow(2, 30),
+    "TiB": Math.pow(2, 40)
+};
+
+// Convert ` + "`" + `size` + "`" + ` to a number of ` + "`" + `unit` + "`" + `s.  Strings such as "1.5TB" are a
+// non-negative number followed by one of sizeUnits, and are rounded to the nearest
+// byte.  Numbers are already in ` + "`" + `unit` + "`" + `, and are returned as is.
+function parseSize(size, unit) {
+    if (typeof size !== "string") {
+        return size;
+    }
+
+    var match = /^\s*(-?)(\d+(?:\.\d+)?|\.\d+)\s*([A-Za-z]+)\s*$/.exec(size);
+    if (match === null || !sizeUnits.hasOwnProperty(match[3])) {
+        throw "invalid size \"" + size + "\": sizes must be a number followed " +
+            "by one of B, KB, MB, GB, TB, KiB, MiB, GiB, or TiB";
+    }
+    if (match[1] === "-") {
+        throw "invalid size \"" + size + "\": sizes must not be negative";
+    }
+    var bytes = Math.round(Number(match[2]) * sizeUnits[match[3]]);
+    return bytes / sizeUnits[unit];
+}
+
+// Convert ` + "`" + `diskSize` + "`" + ` to whole GiB, rounding strings up to the next GiB.
+function parseDiskSize(diskSize) {
+    if (typeof diskSize === "string") {
+        return Math.ceil(parseSize(diskSize, "GiB"));
+    }
+    return diskSize;
+}
+
+// Convert the bounds of the RAM range ` + "`" + `ram` + "`" + ` to GiB.
+function parseRAM(ram) {
+    ram = boxRange(ram);
+    return new Range(parseSize(ram.min, "GiB"), parseSize(ram.max, "GiB"));
+}
+
 function Machine(optionalArgs) {
     this.provider = optionalArgs.provider || "";
     this.role = optionalArgs.role || "";
     this.region = optionalArgs.region || "";
     this.size = optionalArgs.size || "";
-    this.diskSize = optionalArgs.diskSize || 0;
+    this.arch = optionalArgs.arch || "";
+    this.diskSize = parseDiskSize(optionalArgs.diskSize || 0);
+    this.spotPrice = optionalArgs.spotPrice || 0;
     this.sshKeys = optionalArgs.sshKeys || [];
     this.cpu = boxRange(optionalArgs.cpu);
-    this.ram = boxRange(optionalArgs.ram);
+    this.ram = parseRAM(optionalArgs.ram);
+    this.publicIP = optionalArgs.publicIP || "";
+    this.privateIP = optionalArgs.privateIP || "";
+    this.sshKeyPath = optionalArgs.sshKeyPath || "";
+    this.dedicatedTo = optionalArgs.dedicatedTo || "";
+    this.floatingIP = optionalArgs.floatingIP || "";
+    this.disableNAT = optionalArgs.disableNAT || false;
+    this.gpus = optionalArgs.gpus || 0;
+    this.sizeFallbacks = optionalArgs.sizeFallbacks || [];
+
+    this.networkTier = optionalArgs.networkTier || "";
+    if (typeof this.networkTier !== "string") {
+        throw "networkTier must be a string";
+    }
 }
 
+// Declare an existing machine, such as a bare-metal server, that Quilt should adopt
+// rather than boot.  It's identified by its ` + "`" + `publicIP` + "`" + ` and ` + "`" + `privateIP` + "`" + `, and Quilt
+// logs into it as root with the private key at ` + "`" + `sshKeyPath` + "`" + `.
+Machine.static = function(optionalArgs) {
+    var args = _.clone(optionalArgs);
+    args.provider = "Static";
+    return new Machine(args);
+};
+
 Machine.prototype.deploy = function(deployment) {
     deployment.machines.push(this);
 };
 
 // Create a new machine with the same attributes.
 Machine.prototype.clone = function() {
-    // _.clone only creates a shallow copy, so we must clone sshKeys ourselves.
+    // _.clone only creates a shallow copy, so we must clone sshKeys and
+    // sizeFallbacks ourselves.
     var keyClone = _.clone(this.sshKeys);
+    var fallbackClone = _.clone(this.sizeFallbacks);
     var cloned = _.clone(this);
     cloned.sshKeys = keyClone;
+    cloned.sizeFallbacks = fallbackClone;
     return new Machine(cloned);
 };
 
+// Create a copy of the machine that, if the provider is out of capacity for its
+// size, is booted with the next of ` + "`" + `sizes` + "`" + ` instead.
+Machine.prototype.withSizeFallbacks = function(sizes) {
+    var copy = this.clone();
+    copy.sizeFallbacks = _.clone(sizes);
+    return copy;
+};
+
 Machine.prototype.withRole = function(role) {
     var copy = this.clone();
     copy.role = role;
     return copy;
 };
 
+// Create a copy of the machine that only runs containers in ` + "`" + `label` + "`" + `, which may be
+// a Service or the name of one.  Other containers are never scheduled on it.
+Machine.prototype.dedicateTo = function(label) {
+    var copy = this.clone();
+    copy.dedicatedTo = (label instanceof Service) ? label.name : label;
+    return copy;
+};
+
 Machine.prototype.asWorker = function() {
     return this.withRole("Worker");
 };
@@ -350,12 +804,36 @@ function Container(image, command) {
     this.image = image;
     this.command = command || [];
     this.env = {};
+    this.shmSize = 0;
+    this.init = false;
+    this.stopSignal = "";
+    this.arch = "";
+    this.gpus = 0;
+    this.cpuSet = "";
+    this.networkMode = "overlay";
+    this.filepathToContent = {};
+    this.tmpfs = [];
+    this.exposedPorts = [];
+    this.canary = false;
+    this.sidecarOf = 0;
 }
 
 // Create a new Container with the same attributes.
 Container.prototype.clone = function() {
     var cloned = new Container(this.image, _.clone(this.command));
     cloned.env = _.clone(this.env);
+    cloned.shmSize = this.shmSize;
+    cloned.init = this.init;
+    cloned.stopSignal = this.stopSignal;
+    cloned.arch = this.arch;
+    cloned.gpus = this.gpus;
+    cloned.cpuSet = this.cpuSet;
+    cloned.networkMode = this.networkMode;
+    cloned.filepathToContent = _.clone(this.filepathToContent);
+    cloned.tmpfs = _.clone(this.tmpfs);
+    cloned.exposedPorts = _.clone(this.exposedPorts);
+    cloned.canary = this.canary;
+    cloned.sidecarOf = this.sidecarOf;
     return cloned;
 };
 
@@ -379,7 +857,112 @@ Container.prototype.withEnv = function(env) {
     return cloned;
 };
 
+// Create a new Container whose /dev/shm is the given size, either a number of bytes
+// or a string such as "64MiB".
+Container.prototype.withShmSize = function(size) {
+    var cloned = this.clone();
+    cloned.shmSize = parseSize(size, "B");
+    return cloned;
+};
+
+// Create a new Container that runs an init process as PID 1, which reaps zombie
+// processes left behind by the container's command.
+Container.prototype.withInit = function() {
+    var cloned = this.clone();
+    cloned.init = true;
+    return cloned;
+};
+
+// Create a new Container that's stopped with the given signal, e.g. "SIGQUIT",
+// rather than the image's default.
+Container.prototype.withStopSignal = function(signal) {
+    var cloned = this.clone();
+    cloned.stopSignal = signal;
+    return cloned;
+};
+
+// Create a new Container that may only run on machines with the given CPU
+// architecture, e.g. "arm64".
+Container.prototype.withArch = function(arch) {
+    var cloned = this.clone();
+    cloned.arch = arch;
+    return cloned;
+};
+
+// Create a new Container that requires the given number of GPUs.  The container's
+// service must be placed on machines with GPUs, e.g. with
+// ` + "`" + `new MachineRule(false, {gpu: true})` + "`" + `.
+Container.prototype.withGPUs = function(n) {
+    var cloned = this.clone();
+    cloned.gpus = n;
+    return cloned;
+};
+
+// Create a new Container pinned to the given CPUs, a list of CPUs and ranges of CPUs
+// such as "0-3,8", so that latency-critical work isn't migrated between them.
+Container.prototype.withCPUSet = function(cpus) {
+    var cloned = this.clone();
+    cloned.cpuSet = cpus;
+    return cloned;
+};
+
+// Create a new Container with the given network mode: "overlay", the default, to
+// join Quilt's network; "host" to share the machine's network stack, as monitoring
+// agents do; or "none" for no network at all.
+Container.prototype.withNetworkMode = function(mode) {
+    var cloned = this.clone();
+    cloned.networkMode = mode;
+    return cloned;
+};
+
+// Create a new Container that is a sidecar of ` + "`" + `primary` + "`" + `, sharing its lifecycle and
+// network.  Both containers must be deployed.
+Container.prototype.asSidecarOf = function(primary) {
+    var cloned = this.clone();
+    cloned.sidecarOf = primary.id;
+    return cloned;
+};
+
+// Create a new Container with the given files written into it before it starts.
+// The files are given as a map from absolute path to contents.
+Container.prototype.withFiles = function(fileMap) {
+    var cloned = this.clone();
+    cloned.filepathToContent = fileMap;
+    return cloned;
+};
+
+// Create a new Container with an in-memory filesystem mounted at each of the given
+// absolute paths, e.g. for scratch data that shouldn't be written to disk.
+Container.prototype.withTmpfs = function(paths) {
+    var cloned = this.clone();
+    cloned.tmpfs = paths;
+    return cloned;
+};
+
+// Create a new Container that declares the ports it listens on.  The public
+// internet may then only connect to the container on these ports, which catches
+// public connections to ports nothing listens on.
+Container.prototype.withExposedPorts = function(ports) {
+    var cloned = this.clone();
+    cloned.exposedPorts = ports;
+    return cloned;
+};
+
 var enough = { form: "enough" };
+
+// An invariant that connected services are pinned to the same region by their
+// placements, unless the connection was made with ` + "`" + `allowCrossRegion: true` + "`" + `.
+// Services that aren't pinned to a region may be connected to any other.
+var coRegional = { form: "coRegional" };
+
+// An invariant that the deployment exposes at most ` + "`" + `limit` + "`" + ` distinct ports to the
+// public internet.
+function publicPortsAtMost(limit) {
+    if (typeof limit !== "number" || limit < 0 || limit % 1 !== 0) {
+        throw "public port limit must be a non-negative integer: " + limit;
+    }
+    return { form: "publicPorts", nodes: [String(limit)] };
+}
 var between = invariantType("between");
 var neighbor = invariantType("reachDirect");
 var reachableACL = invariantType("reachACL");
@@ -424,12 +1007,56 @@ function MachineRule(exclusive, optionalArgs) {
     if (optionalArgs.region) {
         this.region = optionalArgs.region;
     }
+    if (optionalArgs.floatingIP) {
+        this.floatingIP = true;
+    }
+    if (optionalArgs.gpu) {
+        this.gpu = true;
+    }
 }
 
-function Connection(ports, to) {
+function Connection(ports, to, protocol) {
     this.minPort = ports.min;
     this.maxPort = ports.max;
+    this.ephemeral = ports.ephemeral === true;
     this.to = to;
+    this.protocol = protocol || "";
+    this.bandwidthLimit = 0;
+    this.dscp = 0;
+    this.allowCrossNetwork = false;
+    this.allowCrossRegion = false;
+    this.requireMTLS = false;
+}
+
+// Check that a connection may be restricted to the given protocol.  An empty
+// protocol allows both TCP and UDP.
+function checkProtocol(protocol) {
+    protocol = protocol || "";
+    if (protocol !== "" && protocol !== "tcp" && protocol !== "udp" &&
+        protocol !== "icmp") {
+        throw "unknown protocol: " + protocol;
+    }
+    return protocol;
+}
+
+// Determine the protocol of a connection on ` + "`" + `range` + "`" + `.  Connections on an Icmp are
+// always ICMP connections.
+function rangeProtocol(range, protocol) {
+    protocol = checkProtocol(protocol);
+    if (range instanceof Icmp) {
+        if (protocol !== "" && protocol !== "icmp") {
+            throw "ICMP connections cannot use protocol: " + protocol;
+        }
+        return "icmp";
+    }
+    return protocol;
+}
+
+// Icmp may be passed to connect() in place of a port range to allow ICMP traffic,
+// which has no ports.
+function Icmp() {
+    this.min = 0;
+    this.max = 0;
 }
 
 function Range(min, max) {
@@ -442,4 +1069,14 @@ function Port(p) {
 }
 
 var PortRange = Range;
+
+// The ports Linux assigns to sockets that don't bind one of their own, for services
+// that listen on whichever port they're given and register it elsewhere.  Unlike
+// other port ranges, the public internet may connect on them, and doing so doesn't
+// keep the services it connects to off of the same machine.
+function ephemeralPorts() {
+    var range = new Range(32768, 60999);
+    range.ephemeral = true;
+    return range;
+}
 `
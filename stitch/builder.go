@@ -0,0 +1,177 @@
+package stitch
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A Deployment builds a Stitch directly in Go, for programs that generate their
+// deployments rather than writing them in Javascript.  Errors are deferred until
+// Build, so that calls may be chained.
+type Deployment struct {
+	spec   Stitch
+	labels map[string]int // From label name to its index in spec.Labels.
+	err    error
+}
+
+// NewDeployment creates a Deployment in the given namespace.
+func NewDeployment(namespace string) *Deployment {
+	return &Deployment{
+		spec: Stitch{
+			Containers:  []Container{},
+			Labels:      []Label{},
+			Connections: []Connection{},
+			Placements:  []Placement{},
+			Machines:    []Machine{},
+			AdminACL:    []string{},
+			MasterACL:   []string{},
+			WorkerACL:   []string{},
+			Namespace:   namespace,
+			Invariants:  []invariant{},
+			Networks:    []string{},
+
+			LoadBalancers: []LoadBalancer{},
+		},
+		labels: map[string]int{},
+	}
+}
+
+// AddMachine adds `m` to the deployment.
+func (d *Deployment) AddMachine(m Machine) *Deployment {
+	if m.SSHKeys == nil {
+		m.SSHKeys = []string{}
+	}
+	if m.SizeFallbacks == nil {
+		m.SizeFallbacks = []string{}
+	}
+	d.spec.Machines = append(d.spec.Machines, m)
+	return d
+}
+
+// AddContainer adds `c` to the deployment as a member of `label`, which is created
+// if it doesn't exist yet.  The container's ID is assigned in the order containers
+// are added, just as the Javascript bindings assign them.
+func (d *Deployment) AddContainer(label string, c Container) *Deployment {
+	if label == PublicInternetLabel {
+		d.fail(fmt.Errorf("label name is reserved: %s", label))
+		return d
+	}
+
+	c.ID = len(d.spec.Containers) + 1
+	if c.Command == nil {
+		c.Command = []string{}
+	}
+	if c.Env == nil {
+		c.Env = map[string]string{}
+	}
+	if c.FilepathToContent == nil {
+		c.FilepathToContent = map[string]string{}
+	}
+	if c.Tmpfs == nil {
+		c.Tmpfs = []string{}
+	}
+	if c.ExposedPorts == nil {
+		c.ExposedPorts = []int{}
+	}
+	if c.NetworkMode == "" {
+		c.NetworkMode = NetworkModeOverlay
+	}
+	d.spec.Containers = append(d.spec.Containers, c)
+
+	i, ok := d.labels[label]
+	if !ok {
+		i = len(d.spec.Labels)
+		d.labels[label] = i
+		d.spec.Labels = append(d.spec.Labels, Label{
+			Name:        label,
+			IDs:         []int{},
+			Annotations: []string{},
+			Hostnames:   []Hostname{},
+		})
+	}
+	d.spec.Labels[i].IDs = append(d.spec.Labels[i].IDs, c.ID)
+	return d
+}
+
+// Connect allows the containers of `c.From` to connect to those of `c.To`.  Either
+// may be PublicInternetLabel.
+func (d *Deployment) Connect(c Connection) *Deployment {
+	for _, label := range []string{c.From, c.To} {
+		if _, ok := d.labels[label]; !ok && label != PublicInternetLabel &&
+			!(c.HostNetwork && label == "") {
+			d.fail(fmt.Errorf("%s has a connection to undeployed "+
+				"service: %s", c.From, label))
+			return d
+		}
+	}
+
+	public := c.From == PublicInternetLabel || c.To == PublicInternetLabel
+	if public && !c.HostNetwork && !c.Ephemeral && c.MinPort != c.MaxPort {
+		d.fail(errors.New("public internet cannot connect on port ranges"))
+		return d
+	}
+
+	d.spec.Connections = append(d.spec.Connections, c)
+	return d
+}
+
+// Place constrains where the containers of `p.TargetLabel` are scheduled.
+func (d *Deployment) Place(p Placement) *Deployment {
+	for _, label := range []string{p.TargetLabel, p.OtherLabel} {
+		if _, ok := d.labels[label]; !ok && label != "" {
+			d.fail(fmt.Errorf("%s has a placement in terms of an "+
+				"undeployed service: %s", p.TargetLabel, label))
+			return d
+		}
+	}
+
+	d.spec.Placements = append(d.spec.Placements, p)
+	return d
+}
+
+// AddLoadBalancer adds a load balancer in front of `lb.TargetLabel`.
+func (d *Deployment) AddLoadBalancer(lb LoadBalancer) *Deployment {
+	d.spec.LoadBalancers = append(d.spec.LoadBalancers, lb)
+	return d
+}
+
+// Build returns the Stitch, checked exactly as New checks those written in
+// Javascript, or the first error encountered while building it.
+func (d *Deployment) Build() (Stitch, error) {
+	if d.err != nil {
+		return Stitch{}, d.err
+	}
+
+	// The Stitch is copied so that later additions to `d` don't alias it.
+	spec := d.spec
+	spec.Containers = append([]Container{}, spec.Containers...)
+	spec.Connections = append([]Connection{}, spec.Connections...)
+	spec.Placements = append([]Placement{}, spec.Placements...)
+	spec.Machines = append([]Machine{}, spec.Machines...)
+	spec.LoadBalancers = append([]LoadBalancer{}, spec.LoadBalancers...)
+	spec.Labels = nil
+	for _, label := range d.spec.Labels {
+		label.IDs = append([]int{}, label.IDs...)
+		spec.Labels = append(spec.Labels, label)
+	}
+	if spec.Labels == nil {
+		spec.Labels = []Label{}
+	}
+
+	// Builder deployments use the same representation as the bindings compiled
+	// into this binary.
+	spec.BindingsVersion = BindingsVersion()
+	spec.dedupConnections()
+	spec.createPortRules()
+
+	if err := spec.Validate(); err != nil {
+		return Stitch{}, err
+	}
+	return spec, nil
+}
+
+func (d *Deployment) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
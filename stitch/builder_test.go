@@ -0,0 +1,187 @@
+package stitch
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuilderMatchesJavascript(t *testing.T) {
+	t.Parallel()
+
+	code := `var machine = new Machine({provider: "Amazon", size: "m4.large",
+		sshKeys: ["key"]});
+	deployment.deploy(machine.asMaster());
+	deployment.deploy(machine.asWorker().replicate(2));
+
+	var web = new Service("web",
+		new Container("nginx").withEnv({"port": "80"}).replicate(2));
+	var db = new Service("db",
+		[new Container("postgres", ["postgres", "-D", "/data"])]);
+	web.connect(5432, db);
+	web.connect(443, publicInternet);
+	publicInternet.connect(80, web);
+	db.place(new LabelRule(true, web));
+	deployment.deploy([web, db]);
+	deployment.deploy(new LoadBalancer(web, {listenerPort: 80}));`
+
+	js, err := FromJavascript(code, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+
+	machine := Machine{Provider: "Amazon", Size: "m4.large",
+		SSHKeys: []string{"key"}}
+	master, worker := machine, machine
+	master.Role = "Master"
+	worker.Role = "Worker"
+
+	nginx := Container{Image: "nginx", Env: map[string]string{"port": "80"}}
+	built, err := NewDeployment("default-namespace").
+		AddMachine(master).
+		AddMachine(worker).
+		AddMachine(worker).
+		AddContainer("web", nginx).
+		AddContainer("web", nginx).
+		AddContainer("db", Container{Image: "postgres",
+			Command: []string{"postgres", "-D", "/data"}}).
+		Connect(Connection{From: "web", To: PublicInternetLabel,
+			MinPort: 443, MaxPort: 443}).
+		Connect(Connection{From: PublicInternetLabel, To: "web",
+			MinPort: 80, MaxPort: 80}).
+		Connect(Connection{From: "web", To: "db", MinPort: 5432, MaxPort: 5432}).
+		Place(Placement{TargetLabel: "db", Exclusive: true, OtherLabel: "web"}).
+		AddLoadBalancer(LoadBalancer{TargetLabel: "web", ListenerPort: 80,
+			BackendPort: 80}).
+		Build()
+	assert.NoError(t, err)
+
+	assert.Equal(t, canonicalJSON(t, js), canonicalJSON(t, built))
+}
+
+func TestBuilderHostConnections(t *testing.T) {
+	t.Parallel()
+
+	js, err := FromJavascript(`deployment.openHostPort(new PortRange(9000, 9010));
+	deployment.deploy(new Service("web", [new Container("nginx")]));`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+
+	built, err := NewDeployment("default-namespace").
+		AddContainer("web", Container{Image: "nginx"}).
+		Connect(Connection{From: PublicInternetLabel, MinPort: 9000,
+			MaxPort: 9010, HostNetwork: true}).
+		Build()
+	assert.NoError(t, err)
+
+	assert.Equal(t, canonicalJSON(t, js), canonicalJSON(t, built))
+}
+
+func TestBuilderErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewDeployment("ns").
+		AddContainer("web", Container{Image: "nginx"}).
+		Connect(Connection{From: "web", To: "db", MinPort: 80, MaxPort: 80}).
+		Build()
+	assert.EqualError(t, err, "web has a connection to undeployed service: db")
+
+	_, err = NewDeployment("ns").
+		AddContainer("web", Container{Image: "nginx"}).
+		Place(Placement{TargetLabel: "web", Exclusive: true, OtherLabel: "db"}).
+		Build()
+	assert.EqualError(t, err,
+		"web has a placement in terms of an undeployed service: db")
+
+	_, err = NewDeployment("ns").
+		AddContainer("web", Container{Image: "nginx"}).
+		Connect(Connection{From: PublicInternetLabel, To: "web",
+			MinPort: 80, MaxPort: 81}).
+		Build()
+	assert.EqualError(t, err, "public internet cannot connect on port ranges")
+
+	_, err = NewDeployment("ns").
+		AddContainer(PublicInternetLabel, Container{Image: "nginx"}).
+		Build()
+	assert.EqualError(t, err, "label name is reserved: public")
+
+	// Errors found by Validate are reported just as they are for Javascript.
+	_, err = NewDeployment("ns").
+		AddContainer("web", Container{Image: "nginx", ShmSize: -1}).
+		Build()
+	assert.EqualError(t, err, "container 1 has negative shm size: -1")
+	_, err = NewDeployment("ns").
+		AddMachine(Machine{Provider: "Amazon", SpotPrice: -1}).
+		Build()
+	assert.EqualError(t, err, "spot price must not be negative: -1")
+}
+
+func TestBuilderPortRules(t *testing.T) {
+	t.Parallel()
+
+	spec, err := NewDeployment("ns").
+		AddContainer("a", Container{Image: "nginx"}).
+		AddContainer("b", Container{Image: "nginx"}).
+		Connect(Connection{From: PublicInternetLabel, To: "a",
+			MinPort: 80, MaxPort: 80, Protocol: TCP}).
+		Connect(Connection{From: PublicInternetLabel, To: "b",
+			MinPort: 80, MaxPort: 80, Protocol: TCP}).
+		Connect(Connection{From: PublicInternetLabel, To: "b",
+			MinPort: 80, MaxPort: 80, Protocol: TCP}).
+		Build()
+	assert.NoError(t, err)
+
+	assert.Len(t, spec.Connections, 2)
+	assert.Equal(t, []Placement{
+		{TargetLabel: "a", Exclusive: true, OtherLabel: "a"},
+		{TargetLabel: "a", Exclusive: true, OtherLabel: "b"},
+		{TargetLabel: "b", Exclusive: true, OtherLabel: "a"},
+		{TargetLabel: "b", Exclusive: true, OtherLabel: "b"},
+	}, spec.Placements)
+}
+
+func TestBuilderCopies(t *testing.T) {
+	t.Parallel()
+
+	d := NewDeployment("ns").AddContainer("web", Container{Image: "nginx"})
+	first, err := d.Build()
+	assert.NoError(t, err)
+
+	d.AddContainer("web", Container{Image: "nginx"})
+	second, err := d.Build()
+	assert.NoError(t, err)
+
+	assert.Equal(t, []int{1}, first.Labels[0].IDs)
+	assert.Equal(t, []int{1, 2}, second.Labels[0].IDs)
+	assert.Len(t, first.Containers, 1)
+}
+
+// canonicalJSON returns `spec` in its deployment representation, with the labels,
+// connections, and placements sorted, as their order has no meaning.
+func canonicalJSON(t *testing.T, spec Stitch) string {
+	var labels, connections, placements []string
+	for _, l := range spec.Labels {
+		labels = append(labels, toJSON(t, l))
+	}
+	for _, c := range spec.Connections {
+		connections = append(connections, toJSON(t, c))
+	}
+	for _, p := range spec.Placements {
+		placements = append(placements, toJSON(t, p))
+	}
+	sort.Strings(labels)
+	sort.Strings(connections)
+	sort.Strings(placements)
+
+	// The sorted fields shadow those of the embedded Stitch.
+	return toJSON(t, struct {
+		Stitch
+		Labels, Connections, Placements []string
+	}{spec, labels, connections, placements})
+}
+
+func toJSON(t *testing.T, v interface{}) string {
+	jsonBytes, err := json.Marshal(v)
+	assert.NoError(t, err)
+	return string(jsonBytes)
+}
@@ -0,0 +1,130 @@
+package stitch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/NetSys/quilt/counter"
+	"github.com/NetSys/quilt/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+var (
+	cacheHitCounter  = counter.New("stitch", "Evaluation Cache Hit")
+	cacheMissCounter = counter.New("stitch", "Evaluation Cache Miss")
+)
+
+// WithCache causes New to save the deployments it evaluates in `dir`, and to reuse
+// them rather than evaluating the same spec again.  A saved deployment is reused if
+// the spec's text, filename, parameters, and import path are the same, and none of
+// the files it imported or read have changed since.  Specs that import over HTTPS
+// are never saved.  The keys returned by githubKeys are saved along with the
+// deployment, so changes to them aren't noticed until the spec's inputs change.
+func WithCache(dir string) Option {
+	return func(opts *options) {
+		opts.cacheDir = dir
+	}
+}
+
+// A cacheEntry is a deployment saved by WithCache.
+type cacheEntry struct {
+	// The hashes of the files the spec imported or read, by path.
+	Inputs map[string]string
+	Spec   Stitch
+}
+
+// cacheKey returns the name of the file the deployment evaluated from `specStr` is
+// saved in.  The files the spec reads aren't known until it's evaluated, so they're
+// checked against the entry rather than being part of the key.
+func cacheKey(getter ImportGetter, filename, specStr string,
+	params map[string]interface{}) (string, error) {
+
+	// Maps are marshalled with sorted keys, so equal parameters hash equally.
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+
+	// Vendoring changes where imports are resolved, without changing the spec.
+	var vendorDir, lockHash string
+	if getter.vendor != nil {
+		vendorDir, lockHash = getter.vendor.dir, getter.vendor.lockHash
+	}
+
+	key, err := json.Marshal([]string{BindingsVersion(), getter.Path,
+		getter.sandboxRoot, vendorDir, lockHash, filename, string(paramsJSON),
+		strconv.FormatBool(getter.strict), specStr})
+	if err != nil {
+		return "", err
+	}
+	return hashString(string(key)) + ".json", nil
+}
+
+// readCache returns the deployment saved in `dir` under `key`, if its inputs haven't
+// changed.
+func readCache(dir, key string) (Stitch, bool) {
+	contents, err := util.ReadFile(filepath.Join(dir, key))
+	if err != nil {
+		return Stitch{}, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal([]byte(contents), &entry); err != nil {
+		log.WithError(err).Debug("Ignoring malformed evaluation cache entry.")
+		return Stitch{}, false
+	}
+
+	for path, hash := range entry.Inputs {
+		contents, err := util.ReadFile(path)
+		if err != nil || hash == "" || hashString(contents) != hash {
+			return Stitch{}, false
+		}
+	}
+	return entry.Spec, true
+}
+
+// writeCache saves `spec` in `dir` under `key`, unless one of its inputs can't be
+// checked for changes.
+func writeCache(dir, key string, inputs map[string]string, spec Stitch) error {
+	for _, hash := range inputs {
+		if hash == "" {
+			return nil
+		}
+	}
+
+	entry, err := json.Marshal(cacheEntry{Inputs: inputs, Spec: spec})
+	if err != nil {
+		return err
+	}
+
+	if err := util.AppFs.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %s", dir, err)
+	}
+
+	// Write the entry in full before moving it into place, so that concurrent
+	// evaluations never read half of it.
+	tmp, err := afero.TempFile(util.AppFs, dir, key)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(entry)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		util.AppFs.Remove(tmp.Name())
+		return err
+	}
+	return util.AppFs.Rename(tmp.Name(), filepath.Join(dir, key))
+}
+
+func hashString(str string) string {
+	hash := sha256.Sum256([]byte(str))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,110 @@
+package stitch
+
+import (
+	"fmt"
+)
+
+// PromoteCanary returns a copy of the spec in which the canaries of `label` are
+// promoted.  The label's other containers are switched to the canaries' image, and
+// the canaries become ordinary members of the label.
+func (stitch Stitch) PromoteCanary(label string) (Stitch, error) {
+	canaries, err := stitch.canaries(label)
+	if err != nil {
+		return Stitch{}, err
+	}
+
+	var image string
+	for _, c := range stitch.Containers {
+		if !canaries[c.ID] {
+			continue
+		}
+
+		if image != "" && c.Image != image {
+			return Stitch{}, fmt.Errorf("canaries of %s run different images: "+
+				"%s and %s", label, image, c.Image)
+		}
+		image = c.Image
+	}
+
+	members := stitch.labelMembers(label)
+	containers := make([]Container, len(stitch.Containers))
+	copy(containers, stitch.Containers)
+	for i, c := range containers {
+		if canaries[c.ID] {
+			containers[i].Canary = false
+		} else if members[c.ID] {
+			containers[i].Image = image
+		}
+	}
+	stitch.Containers = containers
+	return stitch, nil
+}
+
+// AbortCanary returns a copy of the spec without the canaries of `label`.  The
+// label's other containers are left as they are.
+func (stitch Stitch) AbortCanary(label string) (Stitch, error) {
+	canaries, err := stitch.canaries(label)
+	if err != nil {
+		return Stitch{}, err
+	}
+
+	var containers []Container
+	for _, c := range stitch.Containers {
+		if !canaries[c.ID] {
+			containers = append(containers, c)
+		}
+	}
+
+	var labels []Label
+	for _, l := range stitch.Labels {
+		var ids []int
+		for _, id := range l.IDs {
+			if !canaries[id] {
+				ids = append(ids, id)
+			}
+		}
+		l.IDs = ids
+		labels = append(labels, l)
+	}
+
+	stitch.Containers = containers
+	stitch.Labels = labels
+	return stitch, nil
+}
+
+// canaries returns the IDs of the canaries in `label`.  It fails if there are none.
+func (stitch Stitch) canaries(label string) (map[int]bool, error) {
+	members := stitch.labelMembers(label)
+	if members == nil {
+		return nil, fmt.Errorf("no label: %s", label)
+	}
+
+	canaries := map[int]bool{}
+	for _, c := range stitch.Containers {
+		if c.Canary && members[c.ID] {
+			canaries[c.ID] = true
+		}
+	}
+
+	if len(canaries) == 0 {
+		return nil, fmt.Errorf("label %s has no canaries", label)
+	}
+	return canaries, nil
+}
+
+// labelMembers returns the IDs of the containers in `label`, or nil if there's no
+// such label.
+func (stitch Stitch) labelMembers(label string) map[int]bool {
+	for _, l := range stitch.Labels {
+		if l.Name != label {
+			continue
+		}
+
+		members := map[int]bool{}
+		for _, id := range l.IDs {
+			members[id] = true
+		}
+		return members
+	}
+	return nil
+}
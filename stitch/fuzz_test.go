@@ -0,0 +1,76 @@
+//go:build go1.18
+// +build go1.18
+
+package stitch
+
+import (
+	"testing"
+)
+
+// Deployments are submitted to the daemon as JSON, so no JSON may make FromJSON, or
+// the methods the daemon calls on the deployments it accepts, panic or hang.
+func FuzzFromJSON(f *testing.F) {
+	spec, err := FromJavascript(`var web = new Service("web",
+		new Container("nginx").replicate(2));
+	var db = new Service("db", [new Container("postgres")]);
+	web.connect(new Port(5432), db);
+	publicInternet.connect(80, web);
+	web.place(new LabelRule(true, db));
+	deployment.deploy([web, db]);
+	deployment.deploy(new Machine({provider: "Amazon", role: "Worker"}));
+	deployment.assert(publicInternet.canReach(web), true);`,
+		ImportGetter{Path: "."})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, seed := range []string{
+		spec.String(),
+		``,
+		`null`,
+		`[]`,
+		`{"Containers": null}`,
+		`{"Labels": [{"Name": "a", "IDs": [1, 1, -1]}]}`,
+		`{"Connections": [{"From": "a", "To": "b", "MinPort": 9, "MaxPort": 1}]}`,
+		`{"Connections": [{"From": "public", "To": "public"}]}`,
+		`{"Connections": [{"From": "public", "To": "a", "MaxPort": 4000000000000000000}]}`,
+		`{"Placements": [{"TargetLabel": "a", "OtherLabel": "a"}]}`,
+		`{"Invariants": [{"Form": "reachACL", "Nodes": []}]}`,
+		`{"Invariants": [{"Form": "between", "Nodes": ["a"]}]}`,
+		`{"Containers": [{"ID": 1, "SidecarOf": 1}]}`,
+		`{"Machines": [{"Sizes": [], "DiskSize": -1}]}`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, jsonStr string) {
+		spec, err := FromJSON(jsonStr)
+		if err != nil {
+			return
+		}
+
+		// The daemon stores deployments as their String, so it must round trip.
+		if _, err := FromJSON(spec.String()); err != nil {
+			t.Fatalf("%s doesn't round trip: %s", jsonStr, err)
+		}
+
+		spec.Hash()
+		spec.DeploymentID()
+		if err := spec.Validate(); err != nil {
+			return
+		}
+
+		spec.MachineNamePrefix()
+		spec.SimplifyPlacements()
+		spec.PublicExposure()
+		spec.UnsatisfiedLocality()
+		spec.IdleMachines()
+		for _, label := range spec.Labels {
+			spec.EgressPorts(label.Name)
+		}
+
+		if graph, err := InitializeGraph(spec); err == nil {
+			spec.CheckInvariants(graph)
+		}
+	})
+}
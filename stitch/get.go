@@ -5,7 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"golang.org/x/tools/go/vcs"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -21,6 +25,11 @@ import (
 // QuiltPathKey is the environment variable key we use to lookup the Quilt path.
 const QuiltPathKey = "QUILT_PATH"
 
+// StdinFilename is the filename given to specs read from standard input.  Specs
+// with this filename have no directory, so relative imports within them can't be
+// resolved.  Prefix it with a directory to resolve relative imports against it.
+const StdinFilename = "<stdin>"
+
 // GetQuiltPath returns the user-defined QUILT_PATH, or the default absolute QUILT_PATH,
 // which is ~/.quilt if the user did not specify a QUILT_PATH.
 func GetQuiltPath() string {
@@ -46,16 +55,65 @@ type ImportGetter struct {
 
 	// Used to detect import cycles.
 	importPath []string
+
+	// If set, specs may only import and read files within this directory.
+	sandboxRoot string
+
+	// If set, the hashes of the files the spec imports or reads, by path.  Imports
+	// fetched over HTTPS are recorded with an empty hash, as they can't be
+	// checked for changes without fetching them again.
+	inputs map[string]string
+
+	// If set, imports of the repos vendored for the spec are resolved from the
+	// vendored copies.  See WithVendor.
+	vendor *vendorState
+
+	// If set, the imports of repos the spec makes are recorded here.  See Vendor.
+	imported map[string]struct{}
+
+	// If set, modules fail if they assign undeclared variables.  See WithStrict.
+	strict bool
+
+	// The parameters the spec was evaluated with.  Modules fail if they read any
+	// others.  See WithParams.
+	params map[string]interface{}
+}
+
+// SandboxedImportGetter returns an ImportGetter for evaluating untrusted specs.  Specs
+// evaluated with it may only import, and read with readFile, files within `root`,
+// which is also where non-relative imports are resolved.  It never downloads
+// imports, and refuses to fetch them over HTTPS.  Paths are checked lexically, so
+// `root` must not contain symlinks that lead out of it.
+func SandboxedImportGetter(root string) ImportGetter {
+	root = filepath.Clean(root)
+	return ImportGetter{Path: root, sandboxRoot: root}
 }
 
 func (getter ImportGetter) withAutoDownload(autoDownload bool) ImportGetter {
 	return ImportGetter{
 		Path:         getter.Path,
-		AutoDownload: autoDownload,
+		AutoDownload: autoDownload && getter.sandboxRoot == "",
 		repoFactory:  getter.repoFactory,
+		sandboxRoot:  getter.sandboxRoot,
+		vendor:       getter.vendor,
 	}
 }
 
+// checkSandbox returns an error if `path` is outside of the getter's sandbox.
+func (getter ImportGetter) checkSandbox(path string) error {
+	if getter.sandboxRoot == "" {
+		return nil
+	}
+
+	rel, err := filepath.Rel(getter.sandboxRoot, filepath.Clean(path))
+	if err != nil || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside of the sandbox %s", path,
+			getter.sandboxRoot)
+	}
+	return nil
+}
+
 type repo interface {
 	// Pull the latest changes in the repo to `dir`.
 	update(dir string) error
@@ -65,6 +123,9 @@ type repo interface {
 
 	// Get the root of the repo.
 	root() string
+
+	// Get the revision checked out in `dir`.
+	revision(dir string) (string, error)
 }
 
 // `goRepo` is a wrapper around `vcs.RepoRoot` that satisfies the `repo` interface.
@@ -84,6 +145,22 @@ func (gr goRepo) root() string {
 	return gr.repo.Root
 }
 
+func (gr goRepo) revision(dir string) (string, error) {
+	var cmd *exec.Cmd
+	switch gr.repo.VCS.Cmd {
+	case "git":
+		cmd = exec.Command("git", "-C", dir, "rev-parse", "HEAD")
+	case "hg":
+		cmd = exec.Command("hg", "--cwd", dir, "id", "--id")
+	default:
+		return "", fmt.Errorf("unable to get revisions from %s",
+			gr.repo.VCS.Name)
+	}
+
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
 func goRepoFactory(repoName string) (repo, error) {
 	vcsRepo, err := vcs.RepoRootForImportPath(repoName, true)
 	return goRepo{vcsRepo}, err
@@ -140,19 +217,23 @@ var errNoLoadableFile = errors.New("no loadable file")
 
 // loadAsFile searches for and evaluates `imp`, `imp`.js`, and finally `imp`.json.
 // Once a loadable import file is found, it stops searching.
-func loadAsFile(vm *otto.Otto, imp string) (otto.Value, error) {
+func (getter ImportGetter) loadAsFile(vm *otto.Otto, imp string) (otto.Value, error) {
+	if err := getter.checkSandbox(imp); err != nil {
+		return otto.Value{}, err
+	}
+
 	for _, suffix := range []string{"", ".js"} {
 		if path := imp + suffix; isFile(path) {
-			spec, err := util.ReadFile(path)
+			spec, err := getter.readInput(path)
 			if err != nil {
 				return otto.Value{}, err
 			}
-			return runSpec(vm, path, spec)
+			return getter.runSpec(vm, path, spec)
 		}
 	}
 
 	if path := imp + ".json"; isFile(path) {
-		unmarshalled, err := unmarshalFile(path)
+		unmarshalled, err := getter.unmarshalFile(path)
 		if err != nil {
 			return otto.Value{}, err
 		}
@@ -166,9 +247,13 @@ func loadAsFile(vm *otto.Otto, imp string) (otto.Value, error) {
 // doesn't exist, it tries to load the import `dir`/index by following the file
 // loading rules.
 // Once a loadable import file is found, it stops searching.
-func loadAsDir(vm *otto.Otto, dir string) (otto.Value, error) {
+func (getter ImportGetter) loadAsDir(vm *otto.Otto, dir string) (otto.Value, error) {
+	if err := getter.checkSandbox(dir); err != nil {
+		return otto.Value{}, err
+	}
+
 	if path := filepath.Join(dir, "package.json"); isFile(path) {
-		intf, err := unmarshalFile(path)
+		intf, err := getter.unmarshalFile(path)
 		if err != nil {
 			return otto.Value{}, err
 		}
@@ -179,17 +264,17 @@ func loadAsDir(vm *otto.Otto, dir string) (otto.Value, error) {
 		if !ok || !ok2 || !ok3 {
 			return otto.Value{}, errors.New("bad package.json format")
 		}
-		return loadAsFile(vm, filepath.Join(dir, main))
+		return getter.loadAsFile(vm, filepath.Join(dir, main))
 	}
 
-	return loadAsFile(vm, filepath.Join(dir, "index"))
+	return getter.loadAsFile(vm, filepath.Join(dir, "index"))
 }
 
-func tryImport(vm *otto.Otto, path string) (otto.Value, error) {
-	if imp, err := loadAsFile(vm, path); err != errNoLoadableFile {
+func (getter ImportGetter) tryImport(vm *otto.Otto, path string) (otto.Value, error) {
+	if imp, err := getter.loadAsFile(vm, path); err != errNoLoadableFile {
 		return imp, err
 	}
-	return loadAsDir(vm, path)
+	return getter.loadAsDir(vm, path)
 }
 
 func (getter ImportGetter) resolveImportHelper(vm *otto.Otto, callerDir, name string) (
@@ -197,11 +282,11 @@ func (getter ImportGetter) resolveImportHelper(vm *otto.Otto, callerDir, name st
 
 	switch {
 	case isRelative(name):
-		imp, err = tryImport(vm, filepath.Join(callerDir, name))
+		imp, err = getter.tryImport(vm, filepath.Join(callerDir, name))
 	case filepath.IsAbs(name):
-		imp, err = tryImport(vm, name)
+		imp, err = getter.tryImport(vm, name)
 	default:
-		imp, err = tryImport(vm, filepath.Join(getter.Path, name))
+		imp, err = getter.tryImport(vm, filepath.Join(getter.Path, name))
 	}
 	return imp, err
 }
@@ -209,10 +294,22 @@ func (getter ImportGetter) resolveImportHelper(vm *otto.Otto, callerDir, name st
 func (getter ImportGetter) resolveImport(vm *otto.Otto, callerDir, name string) (
 	imp otto.Value, err error) {
 
-	imp, err = getter.resolveImportHelper(vm, callerDir, name)
+	var vendored bool
+	if getter.vendor != nil && !isRelative(name) && !filepath.IsAbs(name) {
+		if vendored, err = getter.vendor.check(name); err != nil {
+			return otto.Value{}, err
+		}
+	}
+
+	if vendored {
+		imp, err = getter.tryImport(vm, filepath.Join(getter.vendor.dir, name))
+	} else {
+		imp, err = getter.resolveImportHelper(vm, callerDir, name)
+	}
+
 	// Autodownload if the import doesn't exist, and it's not a filesystem import.
-	if err == errNoLoadableFile && !isRelative(name) && !filepath.IsAbs(name) &&
-		getter.AutoDownload {
+	if err == errNoLoadableFile && !vendored && !isRelative(name) &&
+		!filepath.IsAbs(name) && getter.AutoDownload {
 		getter.Get(name)
 		imp, err = getter.resolveImportHelper(vm, callerDir, name)
 	}
@@ -253,10 +350,143 @@ func (getter *ImportGetter) requireImpl(call otto.FunctionCall) (otto.Value, err
 		getter.importPath = getter.importPath[:len(getter.importPath)-1]
 	}()
 
-	callerDir := filepath.Dir(call.Otto.Context().Filename)
+	callerFile := call.Otto.Context().Filename
+	if getter.sandboxRoot != "" && (isURL(name) || isURL(callerFile)) {
+		return otto.Value{}, fmt.Errorf("unable to import %s: sandboxed specs "+
+			"can't import over HTTPS", name)
+	}
+
+	overHTTPS := isURL(name) || (isURL(callerFile) && isRelative(name))
+	switch {
+	case overHTTPS && getter.imported != nil:
+		return otto.Value{}, fmt.Errorf("unable to vendor %s: imports over "+
+			"HTTPS can't be vendored", name)
+	case overHTTPS && getter.vendor != nil && getter.vendor.frozen:
+		return otto.Value{}, fmt.Errorf("unable to import %s: the vendored "+
+			"imports are frozen, and imports over HTTPS need the network",
+			name)
+	case getter.imported != nil && !isRelative(name) && !filepath.IsAbs(name):
+		getter.imported[name] = struct{}{}
+	}
+
+	switch {
+	case isURL(name):
+		return getter.resolveURLImport(call.Otto, name)
+	case isURL(callerFile) && isRelative(name):
+		impURL, err := resolveURL(callerFile, name)
+		if err != nil {
+			return otto.Value{}, err
+		}
+		return getter.resolveURLImport(call.Otto, impURL)
+	case callerFile == StdinFilename && isRelative(name):
+		return otto.Value{}, fmt.Errorf("unable to resolve relative import %s "+
+			"in a spec read from stdin: there is no directory to resolve it "+
+			"against, so set the base path for the spec (quilt run -dir)",
+			name)
+	}
+
+	callerDir := filepath.Dir(callerFile)
 	return getter.resolveImport(call.Otto, callerDir, name)
 }
 
+// readFileImpl returns the contents of a local file.  Relative paths are resolved
+// against the directory of the calling spec, just like imports.
+func (getter *ImportGetter) readFileImpl(call otto.FunctionCall) (otto.Value, error) {
+	if len(call.ArgumentList) != 1 {
+		return otto.Value{}, errors.New(
+			"readFile requires the path as an argument")
+	}
+	path, err := call.Argument(0).ToString()
+	if err != nil {
+		return otto.Value{}, err
+	}
+
+	callerFile := call.Otto.Context().Filename
+	if isURL(callerFile) {
+		return otto.Value{}, fmt.Errorf("unable to read %s: specs served over "+
+			"HTTPS can't read local files", path)
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(callerFile), path)
+	}
+
+	if err := getter.checkSandbox(path); err != nil {
+		return otto.Value{}, err
+	}
+
+	contents, err := getter.readInput(path)
+	if err != nil {
+		return otto.Value{}, err
+	}
+	return call.Otto.ToValue(contents)
+}
+
+// readInput returns the contents of the file at `path`, recording its hash among the
+// spec's inputs.
+func (getter ImportGetter) readInput(path string) (string, error) {
+	contents, err := util.ReadFile(path)
+	if err == nil && getter.inputs != nil {
+		getter.inputs[path] = hashString(contents)
+	}
+	return contents, err
+}
+
+// resolveURLImport fetches and evaluates the import at `impURL`.  Like file imports,
+// the ".js" suffix is optional.
+func (getter ImportGetter) resolveURLImport(vm *otto.Otto, impURL string) (
+	otto.Value, error) {
+
+	if filepath.Ext(impURL) != ".js" {
+		impURL += ".js"
+	}
+
+	if getter.inputs != nil {
+		getter.inputs[impURL] = ""
+	}
+
+	spec, err := getURL(impURL)
+	if err != nil {
+		return otto.Value{}, fmt.Errorf("unable to open import %s: %s",
+			impURL, err.Error())
+	}
+	return getter.runSpec(vm, impURL, spec)
+}
+
+// resolveURL resolves the import `name` relative to the spec at `base`.
+func resolveURL(base, name string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	nameURL, err := url.Parse(name)
+	if err != nil {
+		return "", err
+	}
+	return baseURL.ResolveReference(nameURL).String(), nil
+}
+
+// getURL fetches the spec served at `specURL`.
+func getURL(specURL string) (string, error) {
+	res, err := HTTPGet(specURL)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad response: %s", res.Status)
+	}
+
+	spec, err := ioutil.ReadAll(res.Body)
+	return string(spec), err
+}
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "https://")
+}
+
 func isFile(path string) bool {
 	info, err := util.AppFs.Stat(path)
 	return err == nil && !info.IsDir()
@@ -266,8 +496,8 @@ func isRelative(path string) bool {
 	return strings.HasPrefix(path, ".") || strings.HasPrefix(path, "..")
 }
 
-func unmarshalFile(path string) (parsed interface{}, err error) {
-	contents, err := util.ReadFile(path)
+func (getter ImportGetter) unmarshalFile(path string) (parsed interface{}, err error) {
+	contents, err := getter.readInput(path)
 	if err != nil {
 		return nil, err
 	}
@@ -1,7 +1,10 @@
 package stitch
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -82,6 +85,10 @@ func (mr *mockRepo) create(dir string) error {
 	return nil
 }
 
+func (mr *mockRepo) revision(dir string) (string, error) {
+	return "rev-" + mr.root(), nil
+}
+
 // The root is always the directory.
 // e.g. github.com/NetSys/quilt/specs/spark => github.com/NetSys/quilt/specs,
 // NOT github.com/NetSys/quilt
@@ -110,6 +117,62 @@ func TestImportExists(t *testing.T) {
 	assert.Empty(t, logger.updated, "Shouldn't update any repos")
 }
 
+func TestReadFile(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+
+	util.WriteFile("/specs/nginx.conf", []byte("events {}"), 0644)
+	util.WriteFile("/specs/main.js", []byte(`deployment.deploy(new Service("web", [
+	new Container("nginx").withFiles({
+		"/etc/nginx/nginx.conf": readFile("nginx.conf")
+	})]));`), 0644)
+
+	spec, err := FromFile("/specs/main.js", ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Len(t, spec.Containers, 1)
+	assert.Equal(t, map[string]string{"/etc/nginx/nginx.conf": "events {}"},
+		spec.Containers[0].FilepathToContent)
+
+	_, err = FromJavascript(`readFile("/missing.conf")`, ImportGetter{Path: "."})
+	assert.Error(t, err)
+}
+
+func TestSandboxedImportGetter(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+
+	util.WriteFile("/sandbox/lib/web.js", []byte(
+		`exports.image = "nginx";`), 0644)
+	util.WriteFile("/secret.js", []byte(`exports.image = "secret";`), 0644)
+	util.WriteFile("/secret.conf", []byte("secret"), 0644)
+
+	getter := SandboxedImportGetter("/sandbox")
+	checkSpec := func(spec, expErr string) {
+		util.WriteFile("/sandbox/main.js", []byte(spec), 0644)
+		_, err := FromFile("/sandbox/main.js", getter)
+		if expErr == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, expErr)
+		}
+	}
+
+	checkSpec(`require("./lib/web"); require("lib/web");`, "")
+	checkSpec(`require("./lib/../../secret");`,
+		"StitchError: unable to open import ./lib/../../secret: "+
+			"/secret is outside of the sandbox /sandbox")
+	checkSpec(`require("/secret");`, "StitchError: unable to open import "+
+		"/secret: /secret is outside of the sandbox /sandbox")
+	checkSpec(`require("https://example.com/web.js");`,
+		"StitchError: unable to import https://example.com/web.js: "+
+			"sandboxed specs can't import over HTTPS")
+	checkSpec(`readFile("../secret.conf");`,
+		"StitchError: /secret.conf is outside of the sandbox /sandbox")
+
+	util.WriteFile("/sandbox/pkg/package.json", []byte(
+		`{"main": "../../secret"}`), 0644)
+	checkSpec(`require("./pkg");`, "StitchError: unable to open import ./pkg: "+
+		"/secret is outside of the sandbox /sandbox")
+}
+
 func TestAutoDownload(t *testing.T) {
 	util.AppFs = afero.NewMemMapFs()
 
@@ -498,3 +561,185 @@ func TestRequire(t *testing.T) {
 		}
 	}
 }
+
+func TestURLImport(t *testing.T) {
+	served := map[string]string{
+		"https://example.com/specs/main.js": `var lib = require("./lib/square");
+			deployment.deploy(new Service("foo",
+				new Container("image").replicate(lib.square(2))));`,
+		"https://example.com/specs/lib/square.js": `exports.square = function(x) {
+			return x*x;
+		};`,
+		"https://example.com/specs/broken.js": `require("../missing");`,
+	}
+
+	defer func(get func(string) (*http.Response, error)) {
+		HTTPGet = get
+	}(HTTPGet)
+	HTTPGet = func(url string) (*http.Response, error) {
+		spec, ok := served[url]
+		if !ok {
+			return &http.Response{
+				Status:     "404 Not Found",
+				StatusCode: http.StatusNotFound,
+				Body:       ioutil.NopCloser(&bytes.Buffer{}),
+			}, nil
+		}
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(spec)),
+		}, nil
+	}
+
+	spec, err := FromURL("https://example.com/specs/main.js", ImportGetter{})
+	assert.NoError(t, err)
+	assert.Len(t, spec.Containers, 4)
+
+	_, err = FromURL("https://example.com/specs/broken.js", ImportGetter{})
+	assert.EqualError(t, err, "StitchError: unable to open import "+
+		"https://example.com/missing.js: bad response: 404 Not Found")
+
+	_, err = FromURL("https://example.com/specs/dne.js", ImportGetter{})
+	assert.EqualError(t, err, "unable to fetch https://example.com/specs/dne.js: "+
+		"bad response: 404 Not Found")
+}
+
+func TestStdinImport(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+	util.WriteFile("/specs/square.js", []byte(`exports.square = function(x) {
+		return x*x;
+	};`), 0644)
+
+	mainFile := `require("./square").square(5);`
+
+	testVM, _ := newVM(ImportGetter{})
+	_, err := run(testVM, StdinFilename, mainFile)
+	assert.EqualError(t, err, "StitchError: unable to resolve relative import "+
+		"./square in a spec read from stdin: there is no directory to resolve "+
+		"it against, so set the base path for the spec (quilt run -dir)")
+
+	testVM, _ = newVM(ImportGetter{})
+	res, err := run(testVM, filepath.Join("/specs", StdinFilename), mainFile)
+	assert.NoError(t, err)
+	resIntf, _ := res.Export()
+	assert.Equal(t, float64(25), resIntf)
+}
+
+func TestEvaluationCache(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+
+	util.WriteFile("/specs/lib.js", []byte(`exports.image = "nginx";`), 0644)
+	util.WriteFile("/specs/nginx.conf", []byte("events {}"), 0644)
+	util.WriteFile("/specs/main.js", []byte(`var lib = require("./lib");
+	deployment.deploy(new Service("web", new Container(lib.image).withFiles({
+		"/etc/nginx/nginx.conf": readFile("nginx.conf")
+	}).replicate(params.replicas)));`), 0644)
+
+	eval := func(replicas int) (Stitch, bool) {
+		hits := cacheHitCounter.Get()
+		spec, err := FromFile("/specs/main.js", ImportGetter{Path: "."},
+			WithCache("/cache"), WithParams(map[string]interface{}{
+				"replicas": replicas,
+			}))
+		assert.NoError(t, err)
+		return spec, cacheHitCounter.Get() > hits
+	}
+
+	spec, hit := eval(2)
+	assert.False(t, hit)
+	assert.Len(t, spec.Containers, 2)
+
+	cached, hit := eval(2)
+	assert.True(t, hit)
+	assert.Equal(t, spec.String(), cached.String())
+
+	// Changing the parameters, an import, or a file read invalidates the cache.
+	spec, hit = eval(3)
+	assert.False(t, hit)
+	assert.Len(t, spec.Containers, 3)
+
+	util.WriteFile("/specs/lib.js", []byte(`exports.image = "httpd";`), 0644)
+	spec, hit = eval(3)
+	assert.False(t, hit)
+	assert.Equal(t, "httpd", spec.Containers[0].Image)
+
+	util.WriteFile("/specs/nginx.conf", []byte("events {} http {}"), 0644)
+	spec, hit = eval(3)
+	assert.False(t, hit)
+	assert.Equal(t, map[string]string{"/etc/nginx/nginx.conf": "events {} http {}"},
+		spec.Containers[0].FilepathToContent)
+
+	_, hit = eval(3)
+	assert.True(t, hit)
+
+	// Specs that import over HTTPS aren't cached.
+	defer func(get func(string) (*http.Response, error)) {
+		HTTPGet = get
+	}(HTTPGet)
+	HTTPGet = func(url string) (*http.Response, error) {
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(bytes.NewBufferString("")),
+		}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		hits := cacheHitCounter.Get()
+		_, err := New("/specs/url.js", `require("https://example.com/lib.js");`,
+			ImportGetter{Path: "."}, WithCache("/cache"))
+		assert.NoError(t, err)
+		assert.Equal(t, hits, cacheHitCounter.Get())
+	}
+}
+
+func TestStrict(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+
+	// Both modules keep their state in an undeclared, and therefore global, counter.
+	util.WriteFile("/specs/web.js", []byte(`replicas = 2;
+	exports.replicas = function() { return replicas; };`), 0644)
+	util.WriteFile("/specs/db.js", []byte(`replicas = 3;
+	exports.replicas = function() { return replicas; };`), 0644)
+	main := `var web = require("./web");
+	var db = require("./db");
+	deployment.deploy(new Service("web",
+		new Container("nginx").replicate(web.replicas())));`
+
+	// Without strict mode, the modules silently collide.
+	spec, err := New("/specs/main.js", main, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Len(t, spec.Containers, 3)
+
+	expErr := "StitchError: unable to open import ./web: /specs/web.js " +
+		"assigned the undeclared variable replicas, which would be shared with " +
+		`every module: declare it with var, or share it through exports ` +
+		`("use quilt strict")`
+	_, err = New("/specs/main.js", main, ImportGetter{Path: "."}, WithStrict())
+	assert.EqualError(t, err, expErr)
+
+	_, err = New("/specs/main.js", "// A comment.\n'use quilt strict';\n"+main,
+		ImportGetter{Path: "."})
+	assert.EqualError(t, err, expErr)
+
+	// The directive must come first.
+	_, err = New("/specs/main.js", main+"\n'use quilt strict';",
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+
+	// Modules that declare their variables are unaffected.
+	util.WriteFile("/specs/web.js", []byte(`var replicas = 2;
+	exports.replicas = function() { return replicas; };`), 0644)
+	util.WriteFile("/specs/db.js", []byte(`var replicas = 3;
+	exports.replicas = function() { return replicas; };`), 0644)
+	spec, err = New("/specs/main.js", main, ImportGetter{Path: "."}, WithStrict())
+	assert.NoError(t, err)
+	assert.Len(t, spec.Containers, 2)
+
+	_, err = New("/specs/main.js", `count = 1;`, ImportGetter{Path: "."},
+		WithStrict())
+	assert.EqualError(t, err, "/specs/main.js assigned the undeclared variable "+
+		"count, which would be shared with every module: declare it with var, "+
+		`or share it through exports ("use quilt strict")`)
+}
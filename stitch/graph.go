@@ -28,6 +28,12 @@ type Graph struct {
 	// Constraints on which containers can be placed together.
 	Placement map[string][]string
 	Machines  []Machine
+	// The connections the public internet may initiate into the deployment.
+	Exposure []Exposure
+	// The connections between labels, and the region each label is pinned to by
+	// its inclusive Region placements.
+	LabelConnections []Connection
+	Regions          map[string]string
 }
 
 // InitializeGraph queries the Stitch to fill in the Graph structure.
@@ -38,6 +44,7 @@ func InitializeGraph(spec Stitch) (Graph, error) {
 		Availability: []AvailabilitySet{{}},
 		Placement:    map[string][]string{},
 		Machines:     []Machine{},
+		Regions:      map[string]string{},
 	}
 
 	for _, label := range spec.Labels {
@@ -53,18 +60,25 @@ func InitializeGraph(spec Stitch) (Graph, error) {
 			return Graph{}, err
 		}
 	}
+	g.LabelConnections = spec.Connections
 
 	for _, pl := range spec.Placements {
 		err := g.addPlacementRule(pl)
 		if err != nil {
 			return Graph{}, err
 		}
+
+		if pl.Region != "" && !pl.Exclusive {
+			g.Regions[pl.TargetLabel] = pl.Region
+		}
 	}
 
 	for _, m := range spec.Machines {
 		g.Machines = append(g.Machines, m)
 	}
 
+	g.Exposure = spec.PublicExposure()
+
 	return g, nil
 }
 
@@ -2,6 +2,7 @@ package stitch
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -19,6 +20,10 @@ const (
 	betweenInvariant = "between"
 	// Schedulability (enough): zero arguments
 	schedulabilityInvariant = "enough"
+	// Public port limit (publicPorts): one argument, <limit>
+	publicPortsInvariant = "publicPorts"
+	// Co-regional connections (coRegional): zero arguments
+	coRegionalInvariant = "coRegional"
 )
 
 // Annotations.
@@ -53,6 +58,17 @@ func (inv invariant) String() string {
 
 var formImpls map[invariantType]func(graph Graph, inv invariant) bool
 
+// The number of nodes each form of invariant operates on.
+var formArities = map[invariantType]int{
+	reachInvariant:          2,
+	neighborInvariant:       2,
+	reachACLInvariant:       2,
+	betweenInvariant:        3,
+	schedulabilityInvariant: 0,
+	publicPortsInvariant:    1,
+	coRegionalInvariant:     0,
+}
+
 func init() {
 	formImpls = map[invariantType]func(graph Graph, inv invariant) bool{
 		reachInvariant:          reachImpl,
@@ -60,11 +76,20 @@ func init() {
 		reachACLInvariant:       reachACLImpl,
 		betweenInvariant:        betweenImpl,
 		schedulabilityInvariant: schedulabilityImpl,
+		publicPortsInvariant:    publicPortsImpl,
+		coRegionalInvariant:     coRegionalImpl,
 	}
 }
 
-func checkInvariants(graph Graph, invs []invariant) error {
-	for _, asrt := range invs {
+// CheckInvariants returns an error describing the first of the spec's invariants that
+// doesn't hold in `graph`.  The graph needn't come from InitializeGraph, so callers
+// may check the invariants against a graph they've modified.
+func (stitch Stitch) CheckInvariants(graph Graph) error {
+	for _, asrt := range stitch.Invariants {
+		if err := asrt.validate(); err != nil {
+			return err
+		}
+
 		if val := formImpls[asrt.Form](graph, asrt); !val {
 			return invariantError{asrt}
 		}
@@ -73,6 +98,20 @@ func checkInvariants(graph Graph, invs []invariant) error {
 	return nil
 }
 
+// validate checks that the invariant has a known form, and the nodes it requires.
+func (inv invariant) validate() error {
+	arity, ok := formArities[inv.Form]
+	if !ok {
+		return fmt.Errorf("unknown invariant form: %q", inv.Form)
+	}
+
+	if len(inv.Nodes) < arity {
+		return fmt.Errorf("%s invariants take %d nodes, but %d were given",
+			inv.Form, arity, len(inv.Nodes))
+	}
+	return nil
+}
+
 func reachImpl(graph Graph, inv invariant) bool {
 	var fromNodes []Node
 	var toNodes []Node
@@ -218,3 +257,38 @@ func schedulabilityImpl(graph Graph, inv invariant) bool {
 	}
 	return len(machines) >= len(avSets)
 }
+
+// publicPortsImpl checks whether the deployment exposes at most `limit` distinct
+// ports to the public internet.  A port counts once however many protocols and
+// labels it's exposed on.
+func publicPortsImpl(graph Graph, inv invariant) bool {
+	limit, err := strconv.Atoi(inv.Nodes[0])
+	if err != nil {
+		return false
+	}
+
+	ports := map[int]struct{}{}
+	for _, exp := range graph.Exposure {
+		for _, p := range exp.Ports {
+			ports[p] = struct{}{}
+		}
+	}
+	return (len(ports) <= limit) == inv.Target
+}
+
+// coRegionalImpl checks whether each connection is between labels pinned to the same
+// region, unless it allows crossing regions.  Connections with labels that aren't
+// pinned, such as the public internet, may cross regions.
+func coRegionalImpl(graph Graph, inv invariant) bool {
+	for _, conn := range graph.LabelConnections {
+		if conn.AllowCrossRegion {
+			continue
+		}
+
+		from, to := graph.Regions[conn.From], graph.Regions[conn.To]
+		if from != "" && to != "" && from != to {
+			return !inv.Target
+		}
+	}
+	return inv.Target
+}
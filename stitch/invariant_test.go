@@ -1,7 +1,10 @@
 package stitch
 
 import (
+	"fmt"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func initSpec(src string) (Stitch, error) {
@@ -49,6 +52,23 @@ func TestReachPublic(t *testing.T) {
 	}
 }
 
+func TestReachICMP(t *testing.T) {
+	stc := `var monitor = new Service("monitor", [new Container("ubuntu")]);
+	var target = new Service("target", [new Container("ubuntu")]);
+	var other = new Service("other", [new Container("ubuntu")]);
+	monitor.connect(new Icmp(), target);
+
+	deployment.deploy([monitor, target, other]);
+
+	deployment.assert(monitor.canReach(target), true);
+	deployment.assert(target.canReach(monitor), false);
+	deployment.assert(monitor.canReach(other), false);`
+	_, err := initSpec(stc)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func TestNeighbor(t *testing.T) {
 	stc := `var a = new Service("a", [new Container("ubuntu")]);
 	var b = new Service("b", [new Container("ubuntu")]);
@@ -104,6 +124,20 @@ func TestFail(t *testing.T) {
 	}
 }
 
+func TestMalformedInvariants(t *testing.T) {
+	t.Parallel()
+
+	checkError(t, `var a = new Service("a", [new Container("ubuntu")]);
+	deployment.deploy(a);
+	deployment.assert(reachable(a.name), true);`,
+		"reach invariants take 2 nodes, but 1 were given")
+
+	spec := Stitch{Invariants: []invariant{{Form: "unknown"}}}
+	assert.EqualError(t, spec.Validate(), `unknown invariant form: "unknown"`)
+	assert.EqualError(t, spec.CheckInvariants(Graph{}),
+		`unknown invariant form: "unknown"`)
+}
+
 func TestBetween(t *testing.T) {
 	stc := `var a = new Service("a", [new Container("ubuntu")]);
 	var b = new Service("b", [new Container("ubuntu")]);
@@ -204,3 +238,92 @@ func TestPlacementInvs(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestPublicPorts(t *testing.T) {
+	stc := `var a = new Service("a", [new Container("ubuntu")]);
+	var b = new Service("b", [new Container("ubuntu")]);
+	publicInternet.connect(80, a);
+	publicInternet.connect(80, b);
+	publicInternet.connect(53, b, "udp");
+	publicInternet.connect(new Icmp(), b);
+	a.connect(22, b);
+	b.connect(443, publicInternet);
+
+	deployment.deploy([a, b]);
+	deployment.assert(publicPortsAtMost(%d), true);`
+
+	// Port 80 is exposed over both TCP and UDP, and port 53 over UDP, but each
+	// port only counts once.
+	if _, err := initSpec(fmt.Sprintf(stc, 2)); err != nil {
+		t.Error(err)
+	}
+
+	expectedFailure := `invariant failed: publicPorts true "1"`
+	if _, err := initSpec(fmt.Sprintf(stc, 1)); err == nil {
+		t.Errorf("got no error, expected %s", expectedFailure)
+	} else if err.Error() != expectedFailure {
+		t.Errorf("got error %s, expected %s", err, expectedFailure)
+	}
+
+	if _, err := initSpec(`publicPortsAtMost(-1);`); err == nil {
+		t.Error("expected a negative limit to be rejected")
+	}
+}
+
+func TestCoRegional(t *testing.T) {
+	stc := `var web = new Service("web", [new Container("ubuntu")]);
+	var db = new Service("db", [new Container("ubuntu")]);
+	var cache = new Service("cache", [new Container("ubuntu")]);
+	web.place(new MachineRule(false, {region: "us-west-1"}));
+	db.place(new MachineRule(false, {region: "%s"}));
+	web.connect(5432, db%s);
+	web.connect(6379, cache);
+	publicInternet.connect(80, web);
+
+	deployment.deploy([web, db, cache]);
+	deployment.assert(coRegional, true);`
+
+	// The cache isn't pinned to a region, so it may be anywhere.
+	if _, err := initSpec(fmt.Sprintf(stc, "us-west-1", "")); err != nil {
+		t.Error(err)
+	}
+
+	expectedFailure := "invariant failed: coRegional true"
+	if _, err := initSpec(fmt.Sprintf(stc, "eu-central-1", "")); err == nil {
+		t.Errorf("got no error, expected %s", expectedFailure)
+	} else if err.Error() != expectedFailure {
+		t.Errorf("got error %s, expected %s", err, expectedFailure)
+	}
+
+	_, err := initSpec(fmt.Sprintf(stc, "eu-central-1",
+		", {allowCrossRegion: true}"))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckInvariants(t *testing.T) {
+	spec := Stitch{
+		Labels: []Label{{Name: "a", IDs: []int{1}}, {Name: "b", IDs: []int{2}}},
+		Invariants: []invariant{
+			{Form: reachInvariant, Target: true, Nodes: []string{"a", "b"}},
+		},
+	}
+
+	graph, err := InitializeGraph(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedFailure := `invariant failed: reach true "a" "b"`
+	if err := spec.CheckInvariants(graph); err == nil {
+		t.Errorf("got no error, expected %s", expectedFailure)
+	} else if err.Error() != expectedFailure {
+		t.Errorf("got error %s, expected %s", err, expectedFailure)
+	}
+
+	graph.addConnection("a", "b")
+	if err := spec.CheckInvariants(graph); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,144 @@
+package stitch
+
+import (
+	"fmt"
+	"time"
+)
+
+// A MaintenanceWindow restricts when voluntary disruptive actions, such as replacing
+// machines whose configuration changed or rolling out new images, may begin.  The
+// window opens on each of Days at Start, and closes at End, both "HH:MM" in the TZ
+// time zone.  A window that ends before it starts closes the next day.  A window
+// without Days is always open.
+type MaintenanceWindow struct {
+	Days  []string // Abbreviated weekday names, e.g. "Sat".
+	Start string
+	End   string
+	TZ    string // An IANA time zone, e.g. "America/Los_Angeles".  Empty is UTC.
+}
+
+// The abbreviated weekday names allowed in a MaintenanceWindow's Days.
+var weekdays = map[string]time.Weekday{
+	"Sun": time.Sunday,
+	"Mon": time.Monday,
+	"Tue": time.Tuesday,
+	"Wed": time.Wednesday,
+	"Thu": time.Thursday,
+	"Fri": time.Friday,
+	"Sat": time.Saturday,
+}
+
+// IsSet returns whether the window restricts when disruptive actions may begin.
+func (w MaintenanceWindow) IsSet() bool {
+	return len(w.Days) > 0
+}
+
+// Open returns whether disruptive actions may begin at `t`.
+func (w MaintenanceWindow) Open(t time.Time) bool {
+	return !w.NextOpen(t).After(t)
+}
+
+// NextOpen returns the earliest time, no earlier than `t`, at which the window is
+// open.
+func (w MaintenanceWindow) NextOpen(t time.Time) time.Time {
+	if !w.IsSet() {
+		return t
+	}
+
+	loc, start, length, err := w.parse()
+	if err != nil {
+		// Validate rejects such windows, so they're never enforced.
+		return t
+	}
+
+	// A window may have opened as late as the day before `t`, and the next opens
+	// at most a week later.
+	local := t.In(loc)
+	next := time.Time{}
+	for day := -1; day <= 7; day++ {
+		date := local.AddDate(0, 0, day)
+		if !w.hasDay(date.Weekday()) {
+			continue
+		}
+
+		opens := time.Date(date.Year(), date.Month(), date.Day(),
+			start/60, start%60, 0, 0, loc)
+		closes := opens.Add(length)
+		switch {
+		case !t.Before(opens) && t.Before(closes):
+			return t
+		case opens.After(t) && (next.IsZero() || opens.Before(next)):
+			next = opens
+		}
+	}
+	return next
+}
+
+func (w MaintenanceWindow) hasDay(weekday time.Weekday) bool {
+	for _, day := range w.Days {
+		if weekdays[day] == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// parse returns the window's location, its start in minutes after midnight, and how
+// long it stays open.
+func (w MaintenanceWindow) parse() (*time.Location, int, time.Duration, error) {
+	loc, err := time.LoadLocation(w.TZ)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("unknown time zone: %s", w.TZ)
+	}
+
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	end, err := parseClock(w.End)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if start == end {
+		return nil, 0, 0, fmt.Errorf("maintenance window must not start "+
+			"and end at the same time: %s", w.Start)
+	}
+
+	length := end - start
+	if length < 0 {
+		length += 24 * 60
+	}
+	return loc, start, time.Duration(length) * time.Minute, nil
+}
+
+// parseClock parses an "HH:MM" time of day into minutes after midnight.
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil || len(clock) != len("HH:MM") {
+		return 0, fmt.Errorf("invalid time of day (must be HH:MM): %q", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (stitch Stitch) validateMaintenanceWindow() error {
+	w := stitch.MaintenanceWindow
+	if !w.IsSet() {
+		if w.Start != "" || w.End != "" || w.TZ != "" {
+			return fmt.Errorf("maintenance window must have days")
+		}
+		return nil
+	}
+
+	for _, day := range w.Days {
+		if _, ok := weekdays[day]; !ok {
+			return fmt.Errorf("unknown maintenance window day: %q", day)
+		}
+	}
+
+	if _, _, _, err := w.parse(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package stitch
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// DefaultNetwork is the name of the network of labels that aren't in any of the
+// spec's Networks.
+const DefaultNetwork = "default"
+
+var networkNameRegex = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// NetworkName returns the name of `network`, the Network of a label or container,
+// substituting DefaultNetwork for the empty string.
+func NetworkName(network string) string {
+	if network == "" {
+		return DefaultNetwork
+	}
+	return network
+}
+
+// validateNetworks checks that the labels are in declared networks, that each
+// container is in the network of its labels, and that connections only cross from
+// one network into another if they explicitly allow it.
+func (stitch Stitch) validateNetworks() error {
+	declared := map[string]struct{}{}
+	for _, network := range stitch.Networks {
+		if network == DefaultNetwork {
+			return fmt.Errorf("network %s is created implicitly", DefaultNetwork)
+		}
+		if !networkNameRegex.MatchString(network) {
+			return fmt.Errorf("invalid network name: %q (must be lowercase "+
+				"letters, digits, and hyphens)", network)
+		}
+		if _, ok := declared[network]; ok {
+			return fmt.Errorf("duplicate network: %s", network)
+		}
+		declared[network] = struct{}{}
+	}
+
+	labelNetworks := map[string]string{}
+	containerNetworks := map[int]string{}
+	for _, label := range stitch.Labels {
+		if _, ok := declared[label.Network]; label.Network != "" && !ok {
+			return fmt.Errorf("label %s is in undeclared network: %s",
+				label.Name, label.Network)
+		}
+		labelNetworks[label.Name] = label.Network
+
+		for _, id := range label.IDs {
+			network, ok := containerNetworks[id]
+			if ok && network != label.Network {
+				return fmt.Errorf("container %d is in networks %s and %s",
+					id, NetworkName(network), NetworkName(label.Network))
+			}
+			containerNetworks[id] = label.Network
+		}
+	}
+
+	for _, c := range stitch.Containers {
+		if c.Network != containerNetworks[c.ID] {
+			return fmt.Errorf("container %d is in network %s, but its labels "+
+				"are in %s", c.ID, NetworkName(c.Network),
+				NetworkName(containerNetworks[c.ID]))
+		}
+
+		if c.Network != "" && c.NetworkMode != "" &&
+			c.NetworkMode != NetworkModeOverlay {
+			return fmt.Errorf("container %d is in network %s, so it must "+
+				"join the overlay", c.ID, c.Network)
+		}
+	}
+
+	for _, conn := range stitch.Connections {
+		if conn.From == PublicInternetLabel || conn.To == PublicInternetLabel ||
+			conn.AllowCrossNetwork {
+			continue
+		}
+
+		from, to := labelNetworks[conn.From], labelNetworks[conn.To]
+		if from != to {
+			return fmt.Errorf("connection %s->%s crosses from network %s "+
+				"to %s without allowCrossNetwork", conn.From, conn.To,
+				NetworkName(from), NetworkName(to))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package stitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/robertkrimen/otto"
+	"github.com/robertkrimen/otto/ast"
+	jsparser "github.com/robertkrimen/otto/parser"
+)
+
+// WithParams exposes `params` to the stitch as the `params` object, so that one
+// stitch can be deployed with different values in each environment.  Dotted names
+// nest, so the parameter "replicas.web" is read as `params.replicas.web`.  Evaluation
+// fails if the stitch reads a parameter that wasn't provided, or never reads one that
+// was, as either is most likely misspelled.
+func WithParams(params map[string]interface{}) Option {
+	return func(opts *options) {
+		opts.params = params
+	}
+}
+
+// setParams defines the `params` object in `vm`.  Each parameter is a getter that
+// records its name in the returned set when the stitch reads it.
+func setParams(vm *otto.Otto, params map[string]interface{}) (
+	map[string]struct{}, error) {
+
+	root, err := vm.Object("({})")
+	if err != nil {
+		return nil, err
+	}
+
+	objects := map[string]*otto.Object{"": root}
+	var object func(path []string) (*otto.Object, error)
+	object = func(path []string) (*otto.Object, error) {
+		name := strings.Join(path, ".")
+		if obj, ok := objects[name]; ok {
+			return obj, nil
+		}
+		if _, ok := params[name]; ok {
+			return nil, fmt.Errorf("parameter %s can't have fields", name)
+		}
+
+		parent, err := object(path[:len(path)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		obj, err := vm.Object("({})")
+		if err != nil {
+			return nil, err
+		}
+		if err := parent.Set(path[len(path)-1], obj); err != nil {
+			return nil, err
+		}
+		objects[name] = obj
+		return obj, nil
+	}
+
+	// Sort the names so that conflicting parameters are reported consistently.
+	var names []string
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	used := map[string]struct{}{}
+	for _, name := range names {
+		path := strings.Split(name, ".")
+		for _, part := range path {
+			if part == "" {
+				return nil, fmt.Errorf("malformed parameter name: %q", name)
+			}
+		}
+
+		parent, err := object(path[:len(path)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		// Round trip the value through JSON so that the stitch sees native
+		// Javascript objects and arrays, rather than wrapped Go values.
+		valueJSON, err := json.Marshal(params[name])
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %s", name, err)
+		}
+		value, err := vm.Call("JSON.parse", nil, string(valueJSON))
+		if err != nil {
+			return nil, err
+		}
+
+		name := name
+		getter := func(otto.FunctionCall) otto.Value {
+			used[name] = struct{}{}
+			return value
+		}
+		descriptor, err := vm.Object("({enumerable: true})")
+		if err != nil {
+			return nil, err
+		}
+		if err := descriptor.Set("get", getter); err != nil {
+			return nil, err
+		}
+
+		_, err = vm.Call("Object.defineProperty", nil, parent,
+			path[len(path)-1], descriptor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return used, vm.Set("params", root)
+}
+
+// checkParamsUsed returns an error naming the first of `params`, in sorted order,
+// that isn't in `used`.
+func checkParamsUsed(params map[string]interface{}, used map[string]struct{}) error {
+	var unused []string
+	for name := range params {
+		if _, ok := used[name]; !ok {
+			unused = append(unused, name)
+		}
+	}
+
+	if len(unused) == 0 {
+		return nil
+	}
+	sort.Strings(unused)
+	return fmt.Errorf("unknown parameter: %s", unused[0])
+}
+
+// checkParamRefs returns an error naming the first parameter that `code` reads, but
+// that isn't in `params`.  Only the references that name their parameter outright are
+// checked, e.g. `params.replicas.web` or `params["replicas"].web`, and functions that
+// declare a `params` of their own are skipped.  Code that fails to parse is left for
+// the VM to report.
+func checkParamRefs(filename, code string, params map[string]interface{}) error {
+	program, err := jsparser.ParseFile(nil, filename, code, 0)
+	if err != nil {
+		return nil
+	}
+
+	var refs [][]string
+	if !declaresParams(program.DeclarationList) {
+		walkParamRefs(reflect.ValueOf(program.Body), &refs)
+	}
+
+	for _, ref := range refs {
+		if !paramDeclared(params, ref) {
+			return fmt.Errorf("unknown parameter: %s",
+				strings.Join(ref, "."))
+		}
+	}
+	return nil
+}
+
+var (
+	nodeType     = reflect.TypeOf((*ast.Node)(nil)).Elem()
+	fileType     = reflect.TypeOf(ast.Program{}.File)
+	commentsType = reflect.TypeOf(ast.CommentMap{})
+)
+
+// walkParamRefs appends the path of each parameter reference within the AST `val` to
+// `refs`.
+func walkParamRefs(val reflect.Value, refs *[][]string) {
+	switch val.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if val.IsNil() || val.Type() == fileType {
+			return
+		}
+
+		if val.Type().Implements(nodeType) {
+			node := val.Interface().(ast.Node)
+			if path, ok := paramPath(node); ok {
+				*refs = append(*refs, path)
+				return
+			}
+
+			if fn, ok := node.(*ast.FunctionLiteral); ok &&
+				declaresParams(fn.DeclarationList, fn.ParameterList) {
+				return
+			}
+		}
+		walkParamRefs(val.Elem(), refs)
+	case reflect.Slice:
+		for i := 0; i < val.Len(); i++ {
+			walkParamRefs(val.Index(i), refs)
+		}
+	case reflect.Struct:
+		for i := 0; i < val.NumField(); i++ {
+			if val.Field(i).Type() != commentsType {
+				walkParamRefs(val.Field(i), refs)
+			}
+		}
+	}
+}
+
+// paramPath returns the path of the parameter `node` reads, if it's a reference to
+// one.  A bare `params` is a reference with an empty path.
+func paramPath(node ast.Node) ([]string, bool) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		return nil, node.Name == "params"
+	case *ast.DotExpression:
+		path, ok := paramPath(node.Left)
+		return append(path, node.Identifier.Name), ok
+	case *ast.BracketExpression:
+		member, isString := node.Member.(*ast.StringLiteral)
+		path, ok := paramPath(node.Left)
+		if !isString {
+			// A computed member still reads from the path before it.
+			return path, ok
+		}
+		return append(path, member.Value), ok
+	default:
+		return nil, false
+	}
+}
+
+// declaresParams returns whether the declarations or parameters shadow the `params`
+// object.
+func declaresParams(decls []ast.Declaration, paramLists ...*ast.ParameterList) bool {
+	for _, list := range paramLists {
+		if list == nil {
+			continue
+		}
+		for _, ident := range list.List {
+			if ident.Name == "params" {
+				return true
+			}
+		}
+	}
+
+	for _, decl := range decls {
+		switch decl := decl.(type) {
+		case *ast.VariableDeclaration:
+			for _, v := range decl.List {
+				if v.Name == "params" {
+					return true
+				}
+			}
+		case *ast.FunctionDeclaration:
+			fn := decl.Function
+			if fn.Name != nil && fn.Name.Name == "params" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// paramDeclared returns whether `path` is provided by `params`, either as a parameter,
+// as a field within one, or as an object nesting them.
+func paramDeclared(params map[string]interface{}, path []string) bool {
+	if len(path) == 0 {
+		return true
+	}
+
+	for i := range path {
+		if _, ok := params[strings.Join(path[:i+1], ".")]; ok {
+			return true
+		}
+	}
+
+	prefix := strings.Join(path, ".") + "."
+	for name := range params {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,7 +3,13 @@
 package stitch
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
 
 	"github.com/robertkrimen/otto"
 
@@ -12,6 +18,8 @@ import (
 	_ "github.com/robertkrimen/otto/underscore"
 
 	"github.com/NetSys/quilt/util"
+
+	log "github.com/Sirupsen/logrus"
 )
 
 // A Stitch is an abstract representation of the policy language.
@@ -22,11 +30,96 @@ type Stitch struct {
 	Placements  []Placement
 	Machines    []Machine
 
+	// Load balancers the cloud provider provisions in front of labels.
+	LoadBalancers []LoadBalancer
+
 	AdminACL  []string
 	MaxPrice  float64
 	Namespace string
 
+	// CIDRs allowed to SSH into masters and workers respectively.  Roles without
+	// their own ACL are governed by the AdminACL.
+	MasterACL []string
+	WorkerACL []string
+
+	// Whether the tunnels between workers should be encrypted with IPsec.
+	EncryptTraffic bool
+
+	TCPKeepalive  TCPKeepalive
+	NetworkTuning NetworkTuning
+
+	// How the workers install their NAT rules, IPTables or NFTables.  Empty means
+	// IPTables.
+	NATBackend string
+
+	// Seconds between the workers' periodic NAT reconciles, in addition to those
+	// triggered by changes to the containers and connections.  Zero means the
+	// default of 30 seconds.
+	NATInterval int
+
+	// The most DNAT rules a worker may need to forward the ports connected to
+	// from the public internet.  Zero means DefaultMaxNATRules.
+	MaxNATRules int
+
+	// The interface the workers NAT containers' traffic out of.  Empty means the
+	// interface of the lowest metric default route that's up and isn't a tunnel.
+	PublicInterface string
+
+	// When machine replacements and image rollouts may begin.  Replacing dead
+	// machines isn't restricted.
+	MaintenanceWindow MaintenanceWindow
+
+	// The isolated container networks created by the spec.  Containers in
+	// different networks can't communicate unless a connection explicitly allows
+	// it.  Labels that aren't in any of them are in the DefaultNetwork.
+	Networks []string
+
+	// Whether each label's containers are spread across machines by default, as
+	// if every label had an exclusive placement relative to itself.  Placements
+	// the spec authors between a label and itself take precedence.
+	DefaultSpread bool
+
+	// Boots workers beyond the spec's Machines while containers can't be
+	// scheduled, and terminates them once they're idle.
+	AutoscaleWorkers Autoscale
+
 	Invariants []invariant
+
+	// The version of the Javascript bindings the Stitch was evaluated with.
+	BindingsVersion string
+
+	// The parameters the Stitch was evaluated with.  See WithParams.
+	Params map[string]interface{}
+}
+
+// TCPKeepalive tunes when the workers' kernels probe idle TCP connections, so that
+// long lived connections survive NATs that drop idle flows.  Zero values leave the
+// host's defaults in place.
+type TCPKeepalive struct {
+	Time     int // Seconds a connection idles before the first probe.
+	Interval int // Seconds between unanswered probes.
+	Probes   int // Unanswered probes before the connection is dropped.
+}
+
+// NetworkTuning sizes the workers' kernel networking tables, so that heavily loaded
+// workers don't drop new connections.  Zero values leave the host's defaults in
+// place.
+type NetworkTuning struct {
+	ConntrackMax int // The maximum number of connections tracked for NAT.
+
+	// The range of local ports assigned to outbound connections.
+	EphemeralPortMin int
+	EphemeralPortMax int
+
+	Somaxconn int // The maximum backlog of pending connections per socket.
+}
+
+// Autoscale bounds the number of workers the daemon boots from Template, in addition
+// to the spec's Machines.  A zero Max disables autoscaling.
+type Autoscale struct {
+	Min      int
+	Max      int
+	Template Machine
 }
 
 // A Placement constraint guides where containers may be scheduled, either relative to
@@ -40,9 +133,21 @@ type Placement struct {
 	OtherLabel string
 
 	// Machine Constraints
-	Provider string
-	Size     string
-	Region   string
+	Provider   string
+	Size       string
+	Region     string
+	FloatingIP bool // Constrains whether the machine has a floating IP.
+	GPU        bool // Constrains whether the machine has GPUs.
+}
+
+// A LoadBalancer is managed by the cloud provider in front of the containers of
+// TargetLabel.  It listens on ListenerPort, and forwards to BackendPort on those
+// containers that respond to HTTP requests for HealthPath.
+type LoadBalancer struct {
+	TargetLabel  string
+	ListenerPort int
+	BackendPort  int
+	HealthPath   string // Empty health checks by opening a TCP connection.
 }
 
 // A Container may be instantiated in the stitch and queried by users.
@@ -51,6 +156,50 @@ type Container struct {
 	Image   string
 	Command []string
 	Env     map[string]string
+	ShmSize int  // The size of /dev/shm in bytes.  Zero uses the runtime default.
+	Init    bool // Run an init process as PID 1 to reap zombie processes.
+
+	// The signal that stops the container, e.g. "SIGQUIT".  Empty uses the
+	// image's default.
+	StopSignal string
+
+	// The CPU architecture the image requires, e.g. "arm64".  Empty if the
+	// container may run on any architecture.
+	Arch string
+
+	// The number of GPUs the container requires.  Such containers must be placed
+	// on machines with GPUs.
+	GPUs int
+
+	// The CPUs the container is pinned to, as a list of CPUs and ranges of CPUs
+	// such as "0-3,8".  Empty if it may run on any CPU.
+	CPUSet string
+
+	// The network the container joins: NetworkModeOverlay, NetworkModeHost, or
+	// NetworkModeNone.  Empty is treated as NetworkModeOverlay.
+	NetworkMode string
+
+	// Files written into the container before it starts, keyed by absolute path.
+	FilepathToContent map[string]string
+
+	// The absolute paths at which in-memory filesystems are mounted.
+	Tmpfs []string
+
+	// The ports the container listens on.  If any are declared, the container may
+	// only be connected to from the public internet on these ports.
+	ExposedPorts []int
+
+	// Whether the container is one of its label's canaries, which run a new image
+	// alongside the label's other containers until promoted or aborted.
+	Canary bool
+
+	// The ID of the primary container this container is a sidecar of, and so
+	// shares its lifecycle and network with.  Zero if it isn't a sidecar.
+	SidecarOf int
+
+	// The isolated network the container's labels are in, or empty for the
+	// DefaultNetwork.
+	Network string
 }
 
 // A Label represents a logical group of containers.
@@ -58,17 +207,112 @@ type Label struct {
 	Name        string
 	IDs         []int
 	Annotations []string
+	Hostnames   []Hostname
+
+	// The isolated network the label is in, or empty for the DefaultNetwork.
+	Network string
+}
+
+// A Hostname is an external DNS name published for a label.  If SplitHorizon is set,
+// the deployment's own containers resolve it to the label's internal addresses
+// rather than its public one, so that their connections to it stay in the overlay.
+type Hostname struct {
+	Name         string
+	SplitHorizon bool
+}
+
+// Annotations that expose a label's size to its containers.  A LabelSize label's
+// containers are started with its size and their index within it in
+// QUILT_LABEL_SIZE and QUILT_LABEL_INDEX.  These are snapshots, so resizing the label
+// leaves running containers alone, unless it's annotated LabelSizeRestart instead.
+const (
+	LabelSizeAnnotation        = "LabelSize"
+	LabelSizeRestartAnnotation = "LabelSizeRestart"
+)
+
+// NoEgressAnnotation keeps a label's containers off the internet.  Workers drop the
+// traffic they send out the public interface, even if other firewall rules would
+// accept it, so the label may not connect to the public internet either.
+const NoEgressAnnotation = "network.noEgress"
+
+// HasAnnotation returns whether `label` is annotated with `annotation`.
+func (label Label) HasAnnotation(annotation string) bool {
+	for _, a := range label.Annotations {
+		if a == annotation {
+			return true
+		}
+	}
+	return false
+}
+
+// ExposesSize returns whether `label`'s size is injected into its containers.
+func (label Label) ExposesSize() bool {
+	return label.HasAnnotation(LabelSizeAnnotation) ||
+		label.HasAnnotation(LabelSizeRestartAnnotation)
 }
 
 // A Connection allows containers implementing the From label to speak to containers
-// implementing the To label in ports in the range [MinPort, MaxPort]
+// implementing the To label in ports in the range [MinPort, MaxPort].  If Protocol is
+// set, only traffic of that protocol is allowed.  ICMP connections have no ports, so
+// their MinPort and MaxPort are zero.
 type Connection struct {
-	From    string
-	To      string
-	MinPort int
-	MaxPort int
+	From     string
+	To       string
+	MinPort  int
+	MaxPort  int
+	Protocol string
+
+	// The bits per second each From container may send over the connection.
+	// Zero means unlimited.
+	BandwidthLimit int
+
+	// The DSCP value, from 0 to 63, with which the traffic of the From containers
+	// is marked.  Zero leaves the traffic unmarked.
+	DSCP int
+
+	// Whether the connection may cross from one isolated network into another.
+	// Without it, such connections are rejected, and their traffic dropped.
+	AllowCrossNetwork bool
+
+	// Whether the connection may be between labels pinned to different regions.
+	// Without it, such connections fail the coRegional invariant.
+	AllowCrossRegion bool
+
+	// Whether the connection must use mutual TLS.  Quilt doesn't enforce it, but
+	// carries it for the service mesh that does.
+	RequireMTLS bool
+
+	// Host networked connections admit the public internet to the ports on the
+	// workers themselves, rather than forwarding them to containers.  They have
+	// no To label.
+	HostNetwork bool
+
+	// Whether the connection is on the ephemeral ports, from EphemeralMinPort to
+	// EphemeralMaxPort.  Such connections are for services that listen on a port
+	// the kernel assigns, and are enforced with a rule for the whole range.
+	Ephemeral bool
+
+	// Checks that the To containers answer on a connection from the public
+	// internet.  The containers that fail it have the connection's public port
+	// withdrawn until they pass again.
+	Probe Probe
+}
+
+// A Probe checks that a container answers on a public UDP port by sending it
+// UDPQuery, the hex encoded payload of a datagram.  If ExpectResponse is set, the
+// container must also reply.
+type Probe struct {
+	UDPQuery       string
+	ExpectResponse bool
 }
 
+// The range of ports Linux assigns to sockets that don't bind one of their own, by
+// default.
+const (
+	EphemeralMinPort = 32768
+	EphemeralMaxPort = 60999
+)
+
 // A ConnectionSlice allows for slices of Collections to be used in joins
 type ConnectionSlice []Connection
 
@@ -78,10 +322,45 @@ type Machine struct {
 	Role     string
 	Size     string
 	CPU      Range
-	RAM      Range
-	DiskSize int
+	RAM      Range // In GiB.
+	DiskSize int   // In GiB.
 	Region   string
 	SSHKeys  []string
+
+	// The CPU architecture of the machine.  If empty, it's inferred from the
+	// Size.
+	Arch string
+
+	// The maximum bid for this machine's spot instance.  Zero means the
+	// provider's default.
+	SpotPrice float64
+
+	// Static machines already exist, so rather than booting them, Quilt adopts
+	// them at these addresses, logging in with the private key at SSHKeyPath.
+	PublicIP   string
+	PrivateIP  string
+	SSHKeyPath string
+
+	// If set, only containers with this label may be scheduled on the machine.
+	DedicatedTo string
+
+	// The floating IP reserved for the machine, if any.  Placements may require
+	// containers to run on, or away from, machines that have one.
+	FloatingIP string
+
+	// If set, the machine doesn't NAT its containers' traffic, even if it's a
+	// worker.  Useful for bastion and utility machines.
+	DisableNAT bool
+
+	// The number of GPUs the machine has.
+	GPUs int
+
+	// The sizes to boot, in order, if the provider is out of capacity for Size.
+	SizeFallbacks []string
+
+	// The provider specific networking performance to boot the machine with,
+	// e.g. "enhanced" on Amazon.  Empty means the provider's default.
+	NetworkTier string
 }
 
 // A Range defines a range of acceptable values for a Machine attribute
@@ -94,6 +373,54 @@ type Range struct {
 // network.
 const PublicInternetLabel = "public"
 
+// The protocols a Connection may be restricted to.
+const (
+	TCP  = "tcp"
+	UDP  = "udp"
+	ICMP = "icmp"
+)
+
+// The backends workers may install their NAT rules with.  NFTables requires a kernel
+// and minion image with nftables support.
+const (
+	IPTables = "iptables"
+	NFTables = "nftables"
+)
+
+// The CPU architectures Machines and Containers may specify, named as in Go's
+// GOARCH.
+const (
+	AMD64 = "amd64"
+	ARM64 = "arm64"
+)
+
+// The networks a Container may join.  Only overlay containers are given Quilt IPs, and
+// are reachable through Connections.
+const (
+	NetworkModeOverlay = "overlay"
+	NetworkModeHost    = "host"
+	NetworkModeNone    = "none"
+)
+
+// OnOverlay returns whether `c` joins Quilt's overlay network.
+func (c Container) OnOverlay() bool {
+	return c.NetworkMode == "" || c.NetworkMode == NetworkModeOverlay
+}
+
+// SortedEnv returns `c`'s environment as KEY=VALUE pairs sorted by key.
+func (c Container) SortedEnv() []string {
+	return util.EnvList(c.Env)
+}
+
+// Protocols returns the protocols allowed by a connection with the given Protocol.
+// Connections that don't specify a protocol allow both TCP and UDP.
+func Protocols(protocol string) []string {
+	if protocol == "" {
+		return []string{TCP, UDP}
+	}
+	return []string{protocol}
+}
+
 // Accepts returns true if `x` is within the range specified by `stitchr` (include),
 // or if no max is specified and `x` is larger than `stitchr.min`.
 func (stitchr Range) Accepts(x float64) bool {
@@ -118,13 +445,19 @@ func newVM(getter ImportGetter) (*otto.Otto, error) {
 	if err := vm.Set("require", toOttoFunc(getter.requireImpl)); err != nil {
 		return vm, err
 	}
+	if err := vm.Set("readFile", toOttoFunc(getter.readFileImpl)); err != nil {
+		return vm, err
+	}
 
 	_, err := run(vm, "<javascript_bindings>", javascriptBindings)
 	return vm, err
 }
 
-// `runSpec` evaluates `spec` within a module closure.
-func runSpec(vm *otto.Otto, filename string, spec string) (otto.Value, error) {
+// `runSpec` evaluates `spec` within a module closure.  It fails if the module reads
+// a parameter that wasn't provided, and in strict mode, if it assigns any undeclared
+// variables.
+func (getter ImportGetter) runSpec(vm *otto.Otto, filename string, spec string) (
+	otto.Value, error) {
 	// The function declaration must be prepended to the first line of the
 	// import or else stacktraces will show an offset line number.
 	exec := "(function() {" +
@@ -134,25 +467,101 @@ func runSpec(vm *otto.Otto, filename string, spec string) (otto.Value, error) {
 		"})(module, module.exports);" +
 		"return module.exports" +
 		"})()"
+	if err := checkParamRefs(filename, exec, getter.params); err != nil {
+		return otto.Value{}, err
+	}
+
+	if getter.strict {
+		return runStrict(vm, filename, exec)
+	}
 	return run(vm, filename, exec)
 }
 
+// An Option configures how New evaluates a stitch.
+type Option func(*options)
+
+type options struct {
+	debugWriter io.Writer
+	noLatestTag bool
+	regions     map[string][]string
+	params      map[string]interface{}
+	cacheDir    string
+	strict      bool
+}
+
+// WithDebugWriter causes New to write the parsed Stitch to `w` before its invariants
+// are checked.  This shows exactly what the invariant graph is built from.
+func WithDebugWriter(w io.Writer) Option {
+	return func(opts *options) {
+		opts.debugWriter = w
+	}
+}
+
+// WithNoLatestTag causes New to reject stitches that run images tagged `latest`,
+// either explicitly or by omitting the tag.  Such deployments aren't reproducible,
+// because the image may change between runs.
+func WithNoLatestTag() Option {
+	return func(opts *options) {
+		opts.noLatestTag = true
+	}
+}
+
+// WithRegions causes New to reject machines whose region isn't among those listed
+// for their provider in `regions`, which maps provider names to their valid regions.
+// Providers missing from `regions`, and machines without a region, aren't checked.
+func WithRegions(regions map[string][]string) Option {
+	return func(opts *options) {
+		opts.regions = regions
+	}
+}
+
 // New parses and executes a stitch (in text form), and returns an abstract Dsl handle.
-func New(filename string, specStr string, getter ImportGetter) (Stitch, error) {
-	vm, err := newVM(getter)
+func New(filename string, specStr string, getter ImportGetter, opts ...Option) (
+	Stitch, error) {
+
+	var options options
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	spec, err := evaluate(filename, specStr, getter, options)
 	if err != nil {
 		return Stitch{}, err
 	}
+	spec.BindingsVersion = BindingsVersion()
+	if len(options.params) > 0 {
+		spec.Params = options.params
+	}
+	spec.dedupConnections()
+	spec.createPortRules()
+	spec.createSpreadRules()
 
-	if _, err := runSpec(vm, filename, specStr); err != nil {
-		return Stitch{}, err
+	if options.debugWriter != nil {
+		if _, err := fmt.Fprintln(options.debugWriter, spec); err != nil {
+			return Stitch{}, err
+		}
 	}
 
-	spec, err := parseContext(vm)
-	if err != nil {
+	if err := spec.Validate(); err != nil {
 		return Stitch{}, err
 	}
-	spec.createPortRules()
+
+	for _, warning := range append(spec.dedicationWarnings(),
+		spec.ephemeralWarnings()...) {
+		log.Warn(warning)
+	}
+
+	if options.noLatestTag {
+		if err := spec.checkNoLatestTag(); err != nil {
+			return Stitch{}, err
+		}
+	}
+
+	if options.regions != nil {
+		if err := spec.checkRegions(options.regions); err != nil {
+			return Stitch{}, err
+		}
+	}
 
 	if len(spec.Invariants) == 0 {
 		return spec, nil
@@ -163,28 +572,103 @@ func New(filename string, specStr string, getter ImportGetter) (Stitch, error) {
 		return Stitch{}, err
 	}
 
-	if err := checkInvariants(graph, spec.Invariants); err != nil {
+	if err := spec.CheckInvariants(graph); err != nil {
+		return Stitch{}, err
+	}
+
+	return spec, nil
+}
+
+// evaluate runs `specStr` and returns the deployment it creates, reusing the result
+// of a previous evaluation if `options` has a cache.
+func evaluate(filename string, specStr string, getter ImportGetter,
+	options options) (Stitch, error) {
+
+	getter.strict = options.strict || hasStrictDirective(specStr)
+	getter.params = options.params
+
+	var key string
+	if options.cacheDir != "" {
+		var err error
+		key, err = cacheKey(getter, filename, specStr, options.params)
+		if err != nil {
+			return Stitch{}, err
+		}
+
+		if spec, ok := readCache(options.cacheDir, key); ok {
+			cacheHitCounter.Inc()
+			log.WithField("spec", filename).Debug("Reused cached evaluation.")
+			return spec, nil
+		}
+		cacheMissCounter.Inc()
+		getter.inputs = map[string]string{}
+	}
+
+	vm, err := newVM(getter)
+	if err != nil {
+		return Stitch{}, err
+	}
+
+	usedParams, err := setParams(vm, options.params)
+	if err != nil {
+		return Stitch{}, err
+	}
+
+	if _, err := getter.runSpec(vm, filename, specStr); err != nil {
+		return Stitch{}, err
+	}
+
+	if err := checkParamsUsed(options.params, usedParams); err != nil {
+		return Stitch{}, err
+	}
+
+	spec, err := parseContext(vm)
+	if err != nil {
 		return Stitch{}, err
 	}
 
+	if options.cacheDir != "" {
+		err := writeCache(options.cacheDir, key, getter.inputs, spec)
+		if err != nil {
+			log.WithError(err).Warn("Failed to cache evaluation.")
+		}
+	}
 	return spec, nil
 }
 
+// BindingsVersion returns an identifier for the Javascript bindings compiled into
+// this binary.  It changes whenever bindings.js changes.
+func BindingsVersion() string {
+	return bindingsChecksum[:12]
+}
+
 // FromJavascript gets a Stitch handle from a string containing Javascript code.
 func FromJavascript(specStr string, getter ImportGetter) (Stitch, error) {
 	return New("<raw_string>", specStr, getter)
 }
 
 // FromFile gets a Stitch handle from a file on disk.
-func FromFile(filename string, getter ImportGetter) (Stitch, error) {
+func FromFile(filename string, getter ImportGetter, opts ...Option) (Stitch, error) {
 	specStr, err := util.ReadFile(filename)
 	if err != nil {
 		return Stitch{}, err
 	}
-	return New(filename, specStr, getter)
+	return New(filename, specStr, getter, opts...)
 }
 
-// FromJSON gets a Stitch handle from the deployment representation.
+// FromURL gets a Stitch handle from a spec served over HTTPS.  Relative imports
+// within the spec are fetched relative to `specURL`.
+func FromURL(specURL string, getter ImportGetter, opts ...Option) (Stitch, error) {
+	specStr, err := getURL(specURL)
+	if err != nil {
+		return Stitch{}, fmt.Errorf("unable to fetch %s: %s", specURL, err)
+	}
+	return New(specURL, specStr, getter, opts...)
+}
+
+// FromJSON gets a Stitch handle from the deployment representation.  Deployments
+// may be submitted by users, so the result must be validated before it's used:
+// Validate rejects deployments that would make the other methods fail.
 func FromJSON(jsonStr string) (stc Stitch, err error) {
 	err = json.Unmarshal([]byte(jsonStr), &stc)
 	return stc, err
@@ -203,16 +687,52 @@ func parseContext(vm *otto.Otto) (stc Stitch, err error) {
 	if err != nil {
 		return stc, err
 	}
-	err = json.Unmarshal(ctxStr, &stc)
+
+	// The connections may target annotated labels, rather than a label by name.
+	var ctx struct {
+		Stitch
+		Connections []annotatedConnection
+	}
+	if err := json.Unmarshal(ctxStr, &ctx); err != nil {
+		return stc, err
+	}
+
+	stc = ctx.Stitch
+	stc.Connections, err = expandAnnotatedConnections(ctx.Connections, stc.Labels)
 	return stc, err
 }
 
+// dedupConnections removes connections identical to an earlier one.
+func (stitch *Stitch) dedupConnections() {
+	seen := map[Connection]struct{}{}
+	connections := stitch.Connections[:0]
+	for _, c := range stitch.Connections {
+		if _, ok := seen[c]; ok {
+			continue
+		}
+		seen[c] = struct{}{}
+		connections = append(connections, c)
+	}
+	stitch.Connections = connections
+}
+
 // createPortRules creates exclusive placement rules such that no two containers
-// listening on the same public port get placed on the same machine.
+// listening on the same public port and protocol get placed on the same machine.
 func (stitch *Stitch) createPortRules() {
-	ports := make(map[int][]string)
+	type publicPort struct {
+		protocol string
+		port     int
+	}
+
+	// Iterate over the ports in the order they're first seen, so that the rules
+	// are generated deterministically.
+	var keys []publicPort
+	ports := make(map[publicPort][]string)
 	for _, c := range stitch.Connections {
-		if c.From != PublicInternetLabel && c.To != PublicInternetLabel {
+		// Services on the ephemeral ports listen on whichever port they're
+		// assigned, so they don't contend for any one of them.
+		if c.From != PublicInternetLabel && c.To != PublicInternetLabel ||
+			c.Protocol == ICMP || c.HostNetwork || c.Ephemeral {
 			continue
 		}
 
@@ -221,24 +741,614 @@ func (stitch *Stitch) createPortRules() {
 			target = c.To
 		}
 
-		min := c.MinPort
-		ports[min] = append(ports[min], target)
+		for _, protocol := range Protocols(c.Protocol) {
+			key := publicPort{protocol, c.MinPort}
+			if _, ok := ports[key]; !ok {
+				keys = append(keys, key)
+			}
+			ports[key] = append(ports[key], target)
+		}
 	}
 
-	for _, labels := range ports {
+	// Connections allowing both protocols conflict on each of them, so the same
+	// rule may be generated more than once.
+	created := map[Placement]struct{}{}
+	for _, key := range keys {
+		labels := ports[key]
 		for _, tgt := range labels {
 			for _, other := range labels {
-				stitch.Placements = append(stitch.Placements,
-					Placement{
-						Exclusive:   true,
-						TargetLabel: tgt,
-						OtherLabel:  other,
-					})
+				rule := Placement{
+					Exclusive:   true,
+					TargetLabel: tgt,
+					OtherLabel:  other,
+				}
+				if _, ok := created[rule]; ok {
+					continue
+				}
+				created[rule] = struct{}{}
+				stitch.Placements = append(stitch.Placements, rule)
 			}
 		}
 	}
 }
 
+// createSpreadRules creates exclusive placement rules that keep the containers of
+// each label on separate machines, if the Stitch spreads them by default.  Labels
+// that are already placed relative to themselves, by the spec or by a port rule, are
+// left alone.
+func (stitch *Stitch) createSpreadRules() {
+	if !stitch.DefaultSpread {
+		return
+	}
+
+	placed := map[string]struct{}{}
+	for _, plcm := range stitch.Placements {
+		if plcm.OtherLabel == plcm.TargetLabel {
+			placed[plcm.TargetLabel] = struct{}{}
+		}
+	}
+
+	for _, label := range stitch.Labels {
+		if _, ok := placed[label.Name]; ok || len(label.IDs) < 2 {
+			continue
+		}
+
+		stitch.Placements = append(stitch.Placements, Placement{
+			Exclusive:   true,
+			TargetLabel: label.Name,
+			OtherLabel:  label.Name,
+		})
+	}
+}
+
+// SimplifyPlacements returns a copy of the Stitch without the placements that are
+// implied by the others.  A placement is a conjunction of constraints, one for each
+// of its OtherLabel, Provider, Size, Region, FloatingIP, and GPU that is set, and is
+// redundant if each of its constraints is implied by a constraint of another
+// remaining placement with the same TargetLabel.  A constraint is implied by:
+//
+//   - An identical constraint, i.e. one on the same field and value, with the same
+//     Exclusive.
+//   - For an exclusive machine constraint, an inclusive constraint on the same
+//     field with a different value.  Requiring the "Amazon" provider, for example,
+//     implies avoiding "Google".
+//
+// Nothing else is assumed.  In particular, constraints on different fields never
+// imply each other (even if a region only exists at one provider), and exclusive
+// label constraints aren't treated as symmetric.  Placements without any
+// constraints are always redundant, and of several equivalent placements, the first
+// is kept.
+func (stitch Stitch) SimplifyPlacements() Stitch {
+	// Walk backwards so that placements are only ever removed in favor of
+	// earlier ones.
+	removed := make([]bool, len(stitch.Placements))
+	for i := len(stitch.Placements) - 1; i >= 0; i-- {
+		removed[i] = stitch.placementImplied(i, removed)
+	}
+
+	var placements []Placement
+	for i, plcm := range stitch.Placements {
+		if !removed[i] {
+			placements = append(placements, plcm)
+		}
+	}
+	stitch.Placements = placements
+	return stitch
+}
+
+// placementImplied returns true if the i'th placement is implied by the placements
+// that haven't been removed.
+func (stitch Stitch) placementImplied(i int, removed []bool) bool {
+	plcm := stitch.Placements[i]
+	for _, c := range plcm.constraints() {
+		implied := false
+		for j, other := range stitch.Placements {
+			if j == i || removed[j] || other.TargetLabel != plcm.TargetLabel {
+				continue
+			}
+
+			for _, otherC := range other.constraints() {
+				implied = implied || c.impliedBy(otherC)
+			}
+		}
+
+		if !implied {
+			return false
+		}
+	}
+	return true
+}
+
+type placementConstraint struct {
+	exclusive bool
+	field     string
+	value     string
+}
+
+func (plcm Placement) constraints() []placementConstraint {
+	var constraints []placementConstraint
+	for _, c := range []placementConstraint{
+		{plcm.Exclusive, "OtherLabel", plcm.OtherLabel},
+		{plcm.Exclusive, "Provider", plcm.Provider},
+		{plcm.Exclusive, "Size", plcm.Size},
+		{plcm.Exclusive, "Region", plcm.Region},
+		{plcm.Exclusive, "FloatingIP", flagValue(plcm.FloatingIP)},
+		{plcm.Exclusive, "GPU", flagValue(plcm.GPU)},
+	} {
+		if c.value != "" {
+			constraints = append(constraints, c)
+		}
+	}
+	return constraints
+}
+
+// flagValue represents a boolean constraint, such as FloatingIP or GPU, as a
+// placementConstraint value.  A false flag places no constraint, so it has no value.
+func flagValue(flag bool) string {
+	if flag {
+		return "true"
+	}
+	return ""
+}
+
+func (c placementConstraint) impliedBy(other placementConstraint) bool {
+	if c == other {
+		return true
+	}
+
+	// A machine has exactly one value for each of its fields, so requiring one
+	// value excludes all of the others.
+	return c.field == other.field && c.field != "OtherLabel" &&
+		c.exclusive && !other.exclusive && c.value != other.value
+}
+
+// EgressPorts returns the ports that containers implementing `label` may initiate
+// connections to, keyed by the destination label.  Each port list is sorted and
+// free of duplicates.
+func (stitch Stitch) EgressPorts(label string) map[string][]int {
+	portSets := map[string]map[int]struct{}{}
+	for _, c := range stitch.Connections {
+		if c.From != label || c.Protocol == ICMP {
+			continue
+		}
+
+		if _, ok := portSets[c.To]; !ok {
+			portSets[c.To] = map[int]struct{}{}
+		}
+
+		for p := c.MinPort; p <= c.MaxPort; p++ {
+			portSets[c.To][p] = struct{}{}
+		}
+	}
+
+	egress := map[string][]int{}
+	for to, set := range portSets {
+		var ports []int
+		for p := range set {
+			ports = append(ports, p)
+		}
+		sort.Ints(ports)
+		egress[to] = ports
+	}
+	return egress
+}
+
+// DependentConnections returns the connections from or to `label`, in the order they
+// appear in the Stitch.  Removing the label breaks each of them, so they should be
+// checked before it's removed.
+func (stitch Stitch) DependentConnections(label string) []Connection {
+	var dependents []Connection
+	for _, c := range stitch.Connections {
+		if c.From == label || c.To == label {
+			dependents = append(dependents, c)
+		}
+	}
+	return dependents
+}
+
+// An Impact lists what refers to a label, and so would be affected by removing it.
+type Impact struct {
+	Connections []Connection
+	Placements  []Placement
+}
+
+// ImpactOf returns the connections from or to `label`, and the placements that
+// target it or constrain other labels by it.
+func (stitch Stitch) ImpactOf(label string) Impact {
+	impact := Impact{Connections: stitch.DependentConnections(label)}
+	for _, plcm := range stitch.Placements {
+		if plcm.TargetLabel == label || plcm.OtherLabel == label {
+			impact.Placements = append(impact.Placements, plcm)
+		}
+	}
+	return impact
+}
+
+// MTLSConnections returns the connections that require mutual TLS, in the order they
+// appear in the Stitch.
+func (stitch Stitch) MTLSConnections() []Connection {
+	var mtls []Connection
+	for _, c := range stitch.Connections {
+		if c.RequireMTLS {
+			mtls = append(mtls, c)
+		}
+	}
+	return mtls
+}
+
+// An Exposure lists the ports on which the public internet may connect to containers
+// implementing Label using Protocol.
+type Exposure struct {
+	Label    string
+	Protocol string
+	Ports    []int
+}
+
+// PublicExposure summarizes every connection the public internet may initiate into
+// the deployment.  The result is sorted by label and then protocol, and each port
+// list is sorted and free of duplicates, so that reports can be diffed to catch new
+// exposures.
+func (stitch Stitch) PublicExposure() []Exposure {
+	type target struct {
+		label    string
+		protocol string
+	}
+
+	portSets := map[target]map[int]struct{}{}
+	for _, c := range stitch.Connections {
+		// Inbound ICMP isn't forwarded to containers, so it exposes nothing.
+		if c.From != PublicInternetLabel || c.Protocol == ICMP {
+			continue
+		}
+
+		for _, protocol := range Protocols(c.Protocol) {
+			key := target{c.To, protocol}
+			if _, ok := portSets[key]; !ok {
+				portSets[key] = map[int]struct{}{}
+			}
+
+			for p := c.MinPort; p <= c.MaxPort; p++ {
+				portSets[key][p] = struct{}{}
+			}
+		}
+	}
+
+	exposures := []Exposure{}
+	for key, set := range portSets {
+		var ports []int
+		for p := range set {
+			ports = append(ports, p)
+		}
+		sort.Ints(ports)
+		exposures = append(exposures, Exposure{key.label, key.protocol, ports})
+	}
+
+	sort.Sort(exposureSlice(exposures))
+	return exposures
+}
+
+type exposureSlice []Exposure
+
+func (slc exposureSlice) Len() int {
+	return len(slc)
+}
+
+func (slc exposureSlice) Less(i, j int) bool {
+	if slc[i].Label != slc[j].Label {
+		return slc[i].Label < slc[j].Label
+	}
+	return slc[i].Protocol < slc[j].Protocol
+}
+
+func (slc exposureSlice) Swap(i, j int) {
+	slc[i], slc[j] = slc[j], slc[i]
+}
+
+// UnsatisfiedLocality returns the sorted labels whose Region placements can't be met
+// by the region of any worker in the Stitch.  A worker meets a label's placements if
+// it's in the region of each of its inclusive Region placements, and in none of the
+// regions of its exclusive ones.  Unlike Validate, this doesn't reject the Stitch --
+// it's meant to help plan which machines a deployment still needs.
+func (stitch Stitch) UnsatisfiedLocality() []string {
+	var targets []string
+	regionPlacements := map[string][]Placement{}
+	for _, plcm := range stitch.Placements {
+		if plcm.Region == "" {
+			continue
+		}
+
+		if _, ok := regionPlacements[plcm.TargetLabel]; !ok {
+			targets = append(targets, plcm.TargetLabel)
+		}
+		regionPlacements[plcm.TargetLabel] = append(
+			regionPlacements[plcm.TargetLabel], plcm)
+	}
+
+	var unsatisfied []string
+	for _, label := range targets {
+		satisfied := false
+		for _, m := range stitch.Machines {
+			if m.Role == "Worker" && regionAllowed(m.Region,
+				regionPlacements[label]) {
+				satisfied = true
+				break
+			}
+		}
+
+		if !satisfied {
+			unsatisfied = append(unsatisfied, label)
+		}
+	}
+	sort.Strings(unsatisfied)
+	return unsatisfied
+}
+
+// regionAllowed returns true if a machine in `region` meets each of `placements`'
+// Region constraints.
+func regionAllowed(region string, placements []Placement) bool {
+	for _, plcm := range placements {
+		if plcm.Exclusive == (plcm.Region == region) {
+			return false
+		}
+	}
+	return true
+}
+
+// IdleMachines returns the workers that none of the Stitch's containers may be
+// scheduled on, because of the workers' dedications and architectures, and the
+// machine constraints of the placements.  Such machines cost money without doing
+// any work.  Fields that a machine leaves empty, such as a Size the provider chooses
+// from its CPU and RAM, are assumed to meet any placement.
+func (stitch Stitch) IdleMachines() []Machine {
+	placements := map[string][]Placement{}
+	for _, plcm := range stitch.Placements {
+		placements[plcm.TargetLabel] = append(placements[plcm.TargetLabel], plcm)
+	}
+
+	containers := map[int]Container{}
+	for _, c := range stitch.Containers {
+		containers[c.ID] = c
+	}
+
+	var idle []Machine
+	for _, m := range stitch.Machines {
+		if m.Role != "Worker" {
+			continue
+		}
+
+		usable := false
+		for _, label := range stitch.Labels {
+			if !machineAllowed(m, label.Name, placements[label.Name]) {
+				continue
+			}
+
+			for _, id := range label.IDs {
+				c := containers[id]
+				archOK := c.Arch == "" || m.Arch == "" || c.Arch == m.Arch
+				usable = usable || archOK && c.GPUs <= m.GPUs
+			}
+		}
+
+		if !usable {
+			idle = append(idle, m)
+		}
+	}
+	return idle
+}
+
+// machineAllowed returns true if `m` meets the dedication of, and the machine
+// constraints in `placements` on, `label`.
+func machineAllowed(m Machine, label string, placements []Placement) bool {
+	if m.DedicatedTo != "" && m.DedicatedTo != label {
+		return false
+	}
+
+	for _, plcm := range placements {
+		for _, field := range []struct{ want, have string }{
+			{plcm.Provider, m.Provider},
+			{plcm.Region, m.Region},
+			{plcm.Size, m.Size},
+		} {
+			if field.want != "" && field.have != "" &&
+				plcm.Exclusive == (field.want == field.have) {
+				return false
+			}
+		}
+
+		if plcm.FloatingIP && plcm.Exclusive == (m.FloatingIP != "") {
+			return false
+		}
+
+		if plcm.GPU && plcm.Exclusive == (m.GPUs > 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// ConnectionDiff returns the connections that are in `new` but not `old`, and those
+// that are in `old` but not `new`.  Connections are only equal if all of their fields
+// are, so changing any of them, such as a BandwidthLimit, both adds and removes a
+// connection.  Both slices are sorted, so that the difference between two deployments
+// is always reported the same way.
+func ConnectionDiff(old, new Stitch) (added, removed []Connection) {
+	return connectionsMissing(new.Connections, old.Connections),
+		connectionsMissing(old.Connections, new.Connections)
+}
+
+// connectionsMissing returns the sorted connections in `conns` that aren't in
+// `from`, without duplicates.
+func connectionsMissing(conns, from []Connection) []Connection {
+	excluded := map[Connection]struct{}{}
+	for _, c := range from {
+		excluded[c] = struct{}{}
+	}
+
+	var missing []Connection
+	for _, c := range conns {
+		if _, ok := excluded[c]; !ok {
+			excluded[c] = struct{}{}
+			missing = append(missing, c)
+		}
+	}
+	sort.Sort(ConnectionSlice(missing))
+	return missing
+}
+
+// The longest the namespace may be in a machine name prefix, which leaves room in a
+// 63 character DNS label for the rest of the prefix and a per-machine suffix.
+const maxPrefixNamespace = 40
+
+// MachineNamePrefix returns a prefix for the names of the machines booted for the
+// Stitch that's safe to use in a DNS label.  It's the namespace, lowercased with
+// each run of characters other than letters and digits replaced by a dash, followed
+// by a hash of the original namespace.  The hash keeps namespaces that sanitize to
+// the same string, such as "my_app" and "my-app", from colliding.  The prefix always
+// starts with "quilt-", so it's valid even if the namespace is empty.
+func (stitch Stitch) MachineNamePrefix() string {
+	words := strings.FieldsFunc(strings.ToLower(stitch.Namespace), func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9')
+	})
+	sanitized := strings.Join(words, "-")
+	if len(sanitized) > maxPrefixNamespace {
+		sanitized = strings.TrimRight(sanitized[:maxPrefixNamespace], "-")
+	}
+
+	prefix := "quilt-"
+	if sanitized != "" {
+		prefix += sanitized + "-"
+	}
+
+	hash := sha1.Sum([]byte(stitch.Namespace))
+	return prefix + hex.EncodeToString(hash[:4])
+}
+
+// Hash returns a hash of the deployment the Stitch describes.  Only the meaning of
+// the Stitch is hashed, so it's unaffected by the order in which machines, labels,
+// connections, and the like were declared, and by the IDs that order assigns to
+// containers.
+func (stitch Stitch) Hash() string {
+	// Containers are identified by their contents rather than their IDs, which
+	// depend on the order they were deployed in.
+	containers := map[int]string{}
+	for _, c := range stitch.Containers {
+		id := c.ID
+		c.ID = 0
+		containers[id] = encodeJSON(c)
+	}
+
+	var labels []string
+	labeled := map[int]struct{}{}
+	for _, label := range stitch.Labels {
+		var members []string
+		for _, id := range label.IDs {
+			members = append(members, containers[id])
+			labeled[id] = struct{}{}
+		}
+		label.IDs = nil
+		labels = append(labels, encodeJSON(struct {
+			Label
+			Annotations, Hostnames, Members []string
+		}{label, sortedJSON(label.Annotations), sortedJSON(label.Hostnames),
+			sortedStrings(members)}))
+	}
+
+	var unlabeled []string
+	for id, c := range containers {
+		if _, ok := labeled[id]; !ok {
+			unlabeled = append(unlabeled, c)
+		}
+	}
+
+	// Evaluating the same spec with new bindings doesn't change the deployment.
+	stitch.BindingsVersion = ""
+
+	// The sorted fields shadow those of the embedded Stitch.
+	hash := sha1.Sum([]byte(encodeJSON(struct {
+		Stitch
+		Containers, Labels, Connections, Placements, Machines []string
+		LoadBalancers                                         []string
+		AdminACL, MasterACL, WorkerACL, Invariants            []string
+	}{
+		stitch,
+		sortedStrings(unlabeled),
+		sortedStrings(labels),
+		sortedJSON(stitch.Connections),
+		sortedJSON(stitch.Placements),
+		sortedJSON(stitch.Machines),
+		sortedJSON(stitch.LoadBalancers),
+		sortedJSON(stitch.AdminACL),
+		sortedJSON(stitch.MasterACL),
+		sortedJSON(stitch.WorkerACL),
+		sortedJSON(stitch.Invariants),
+	})))
+	return hex.EncodeToString(hash[:])
+}
+
+// DeploymentID returns a short identifier for the deployment that changes only when
+// the deployment itself does, for use in logs and as a cache key.  It's the
+// namespace followed by a prefix of the Stitch's Hash.
+func (stitch Stitch) DeploymentID() string {
+	hash := stitch.Hash()[:8]
+	if stitch.Namespace == "" {
+		return hash
+	}
+	return stitch.Namespace + "-" + hash
+}
+
+// VerifyDeployment reports whether `deploymentJSON` is the deployment the
+// Javascript `specStr` evaluates to, so that a deployment can be audited against
+// its reviewed source.  The spec is evaluated with the parameters the deployment
+// records.  Deployments are compared by their Hash, so the order in which the JSON
+// lists machines, containers, and the like doesn't matter, nor does the version of
+// the bindings it was evaluated with.
+func VerifyDeployment(specStr string, getter ImportGetter, deploymentJSON string) (
+	bool, error) {
+
+	actual, err := FromJSON(deploymentJSON)
+	if err != nil {
+		return false, fmt.Errorf("malformed deployment: %s", err)
+	}
+
+	expected, err := New("<raw_string>", specStr, getter,
+		WithParams(actual.Params))
+	if err != nil {
+		return false, err
+	}
+
+	return expected.Hash() == actual.Hash(), nil
+}
+
+// encodeJSON returns the JSON encoding of `v`.  Maps are encoded with their keys
+// sorted, so the encoding is deterministic.
+func encodeJSON(v interface{}) string {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(jsonBytes)
+}
+
+// sortedJSON returns the JSON encodings of the elements of the slice `slc`, sorted.
+func sortedJSON(slc interface{}) []string {
+	var elems []json.RawMessage
+	if err := json.Unmarshal([]byte(encodeJSON(slc)), &elems); err != nil {
+		panic(err)
+	}
+
+	var strs []string
+	for _, elem := range elems {
+		strs = append(strs, string(elem))
+	}
+	return sortedStrings(strs)
+}
+
+func sortedStrings(strs []string) []string {
+	sorted := append([]string{}, strs...)
+	sort.Strings(sorted)
+	return sorted
+}
+
 // String returns the Stitch in its deployment representation.
 func (stitch Stitch) String() string {
 	jsonBytes, err := json.Marshal(stitch)
@@ -258,6 +1368,47 @@ func (cs ConnectionSlice) Len() int {
 	return len(cs)
 }
 
+// Less orders connections by From, To, Protocol, and then ports.  The remaining fields
+// break ties, so that the order is total.
+func (cs ConnectionSlice) Less(i, j int) bool {
+	l, r := cs[i], cs[j]
+	switch {
+	case l.From != r.From:
+		return l.From < r.From
+	case l.To != r.To:
+		return l.To < r.To
+	case l.Protocol != r.Protocol:
+		return l.Protocol < r.Protocol
+	case l.MinPort != r.MinPort:
+		return l.MinPort < r.MinPort
+	case l.MaxPort != r.MaxPort:
+		return l.MaxPort < r.MaxPort
+	case l.BandwidthLimit != r.BandwidthLimit:
+		return l.BandwidthLimit < r.BandwidthLimit
+	case l.DSCP != r.DSCP:
+		return l.DSCP < r.DSCP
+	case l.AllowCrossNetwork != r.AllowCrossNetwork:
+		return !l.AllowCrossNetwork
+	case l.AllowCrossRegion != r.AllowCrossRegion:
+		return !l.AllowCrossRegion
+	case l.RequireMTLS != r.RequireMTLS:
+		return !l.RequireMTLS
+	case l.HostNetwork != r.HostNetwork:
+		return !l.HostNetwork
+	case l.Ephemeral != r.Ephemeral:
+		return !l.Ephemeral
+	case l.Probe.UDPQuery != r.Probe.UDPQuery:
+		return l.Probe.UDPQuery < r.Probe.UDPQuery
+	default:
+		return !l.Probe.ExpectResponse && r.Probe.ExpectResponse
+	}
+}
+
+// Swap swaps the connections at the given indices.
+func (cs ConnectionSlice) Swap(i, j int) {
+	cs[i], cs[j] = cs[j], cs[i]
+}
+
 func stitchError(vm *otto.Otto, err error) otto.Value {
 	return vm.MakeCustomError("StitchError", err.Error())
 }
@@ -2,10 +2,15 @@ package stitch
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
@@ -27,28 +32,31 @@ func TestMachine(t *testing.T) {
 	})])`,
 		[]Machine{
 			{
-				Role:     "Worker",
-				Provider: "Amazon",
-				Region:   "us-west-2",
-				Size:     "m4.large",
-				CPU:      Range{2, 4},
-				RAM:      Range{4, 8},
-				DiskSize: 32,
-				SSHKeys:  []string{"key1", "key2"},
+				Role:          "Worker",
+				Provider:      "Amazon",
+				Region:        "us-west-2",
+				Size:          "m4.large",
+				CPU:           Range{2, 4},
+				RAM:           Range{4, 8},
+				DiskSize:      32,
+				SSHKeys:       []string{"key1", "key2"},
+				SizeFallbacks: []string{},
 			}})
 
 	checkMachines(t, `var baseMachine = new Machine({provider: "Amazon"});
 		deployment.deploy(baseMachine.asMaster().replicate(2));`,
 		[]Machine{
 			{
-				Role:     "Master",
-				Provider: "Amazon",
-				SSHKeys:  []string{},
+				Role:          "Master",
+				Provider:      "Amazon",
+				SSHKeys:       []string{},
+				SizeFallbacks: []string{},
 			},
 			{
-				Role:     "Master",
-				Provider: "Amazon",
-				SSHKeys:  []string{},
+				Role:          "Master",
+				Provider:      "Amazon",
+				SSHKeys:       []string{},
+				SizeFallbacks: []string{},
 			},
 		},
 	)
@@ -59,19 +67,48 @@ func TestMachine(t *testing.T) {
 		deployment.deploy(machines);`,
 		[]Machine{
 			{
-				Role:     "Master",
-				Provider: "Amazon",
-				SSHKeys:  []string{"key"},
+				Role:          "Master",
+				Provider:      "Amazon",
+				SSHKeys:       []string{"key"},
+				SizeFallbacks: []string{},
 			},
 			{
-				Role:     "Master",
-				Provider: "Amazon",
-				SSHKeys:  []string{},
+				Role:          "Master",
+				Provider:      "Amazon",
+				SSHKeys:       []string{},
+				SizeFallbacks: []string{},
 			},
 		},
 	)
 }
 
+func TestMachineSizes(t *testing.T) {
+	t.Parallel()
+
+	checkMachines(t, `deployment.deploy([new Machine({
+		ram: new Range("512MiB", "1.5GiB"),
+		diskSize: "32GB"
+	}), new Machine({ram: "4GiB", diskSize: "1.5TiB"}),
+	new Machine({ram: 2, diskSize: 16})])`,
+		[]Machine{
+			{RAM: Range{0.5, 1.5}, DiskSize: 30, SSHKeys: []string{},
+				SizeFallbacks: []string{}},
+			{RAM: Range{4, 4}, DiskSize: 1536, SSHKeys: []string{},
+				SizeFallbacks: []string{}},
+			{RAM: Range{2, 2}, DiskSize: 16, SSHKeys: []string{},
+				SizeFallbacks: []string{}},
+		})
+
+	checkError(t, `new Machine({ram: "4G"})`, `invalid size "4G": sizes `+
+		`must be a number followed by one of B, KB, MB, GB, TB, KiB, MiB, GiB, `+
+		`or TiB`)
+	checkError(t, `new Machine({diskSize: "-32GiB"})`,
+		`invalid size "-32GiB": sizes must not be negative`)
+	checkError(t, `new Machine({ram: new Range("1GiB", "lots")})`,
+		`invalid size "lots": sizes must be a number followed by one of B, KB, `+
+			`MB, GB, TB, KiB, MiB, GiB, or TiB`)
+}
+
 func TestContainer(t *testing.T) {
 	t.Parallel()
 
@@ -79,11 +116,17 @@ func TestContainer(t *testing.T) {
 	new Container("image", ["arg1", "arg2"]).withEnv({"foo": "bar"})
 	]));`,
 		map[int]Container{
-			2: {
-				ID:      2,
+			1: {
+				ID:      1,
 				Image:   "image",
 				Command: []string{"arg1", "arg2"},
 				Env:     map[string]string{"foo": "bar"},
+
+				NetworkMode: "overlay",
+
+				FilepathToContent: map[string]string{},
+				Tmpfs:             []string{},
+				ExposedPorts:      []int{},
 			},
 		})
 
@@ -96,6 +139,12 @@ func TestContainer(t *testing.T) {
 				Image:   "image",
 				Command: []string{"arg1", "arg2"},
 				Env:     map[string]string{},
+
+				NetworkMode: "overlay",
+
+				FilepathToContent: map[string]string{},
+				Tmpfs:             []string{},
+				ExposedPorts:      []int{},
 			},
 		})
 
@@ -110,6 +159,12 @@ func TestContainer(t *testing.T) {
 				Image:   "image",
 				Command: []string{},
 				Env:     map[string]string{},
+
+				NetworkMode: "overlay",
+
+				FilepathToContent: map[string]string{},
+				Tmpfs:             []string{},
+				ExposedPorts:      []int{},
 			},
 		})
 
@@ -122,6 +177,12 @@ func TestContainer(t *testing.T) {
 				Image:   "image",
 				Command: []string{},
 				Env:     map[string]string{"foo": "bar"},
+
+				NetworkMode: "overlay",
+
+				FilepathToContent: map[string]string{},
+				Tmpfs:             []string{},
+				ExposedPorts:      []int{},
 			},
 		})
 
@@ -129,18 +190,29 @@ func TestContainer(t *testing.T) {
 		new Service("foo", new Container("image", ["arg"]).replicate(2))
 	);`,
 		map[int]Container{
-			// IDs start from 2 because the reference container has ID 1.
-			2: {
-				ID:      2,
+			1: {
+				ID:      1,
 				Image:   "image",
 				Command: []string{"arg"},
 				Env:     map[string]string{},
+
+				NetworkMode: "overlay",
+
+				FilepathToContent: map[string]string{},
+				Tmpfs:             []string{},
+				ExposedPorts:      []int{},
 			},
-			3: {
-				ID:      3,
+			2: {
+				ID:      2,
 				Image:   "image",
 				Command: []string{"arg"},
 				Env:     map[string]string{},
+
+				NetworkMode: "overlay",
+
+				FilepathToContent: map[string]string{},
+				Tmpfs:             []string{},
+				ExposedPorts:      []int{},
 			},
 		})
 
@@ -152,24 +224,277 @@ func TestContainer(t *testing.T) {
 		new Service("baz", repl)
 	);`,
 		map[int]Container{
-			// IDs start from 2 because the reference container has ID 1.
-			2: {
-				ID:      2,
+			1: {
+				ID:      1,
 				Image:   "image",
 				Command: []string{"arg", "changed"},
 				Env: map[string]string{
 					"foo": "bar",
 				},
+
+				NetworkMode: "overlay",
+
+				FilepathToContent: map[string]string{},
+				Tmpfs:             []string{},
+				ExposedPorts:      []int{},
 			},
-			3: {
-				ID:      3,
+			2: {
+				ID:      2,
 				Image:   "image",
 				Command: []string{"arg"},
 				Env:     map[string]string{},
+
+				NetworkMode: "overlay",
+
+				FilepathToContent: map[string]string{},
+				Tmpfs:             []string{},
+				ExposedPorts:      []int{},
 			},
 		})
 }
 
+func TestSortedEnv(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`deployment.deploy(new Service("foo", [
+	new Container("image").withEnv({"zeta": "1", "alpha": "2", "mu": "a=b"})
+	]));`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+
+	exp := []string{"alpha=2", "mu=a=b", "zeta=1"}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, exp, spec.Containers[0].SortedEnv())
+	}
+
+	assert.Empty(t, Container{}.SortedEnv())
+}
+
+func TestContainerIDs(t *testing.T) {
+	t.Parallel()
+
+	code := `var web = new Container("nginx").withEnv({"port": "80"}).replicate(2);
+	var db = new Container("postgres");
+	new Container("unused");
+	deployment.deploy([new Service("web", web), new Service("db", [db]),
+		new Service("all", web.concat([db]))]);`
+
+	first, err := FromJavascript(code, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	second, err := FromJavascript(code, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, first.Containers, second.Containers)
+	assert.Equal(t, first.Labels, second.Labels)
+
+	// Containers are numbered in the order they're deployed, skipping the ones
+	// that never are.
+	var ids []int
+	for _, c := range first.Containers {
+		ids = append(ids, c.ID)
+	}
+	assert.Equal(t, []int{1, 2, 3}, ids)
+	assert.Equal(t, "postgres", first.Containers[2].Image)
+
+	labelIDs := map[string][]int{}
+	for _, label := range first.Labels {
+		labelIDs[label.Name] = label.IDs
+	}
+	assert.Equal(t, map[string][]int{
+		"web": {1, 2},
+		"db":  {3},
+		"all": {1, 2, 3},
+	}, labelIDs)
+}
+
+func TestDeploymentID(t *testing.T) {
+	t.Parallel()
+
+	parse := func(code string) Stitch {
+		spec, err := FromJavascript(code, ImportGetter{Path: "."})
+		assert.NoError(t, err)
+		return spec
+	}
+
+	spec := parse(`createDeployment({namespace: "myapp"});
+	deployment.deploy([new Machine({role: "Master"}), new Machine({role: "Worker"})]);
+	var web = new Service("web", new Container("nginx").replicate(2));
+	var db = new Service("db", [new Container("postgres")]);
+	web.connect(5432, db);
+	publicInternet.connect(80, web);
+	deployment.deploy([web, db]);`)
+
+	// The same deployment, declared in a different order.
+	reordered := parse(`createDeployment({namespace: "myapp"});
+	var db = new Service("db", [new Container("postgres")]);
+	var web = new Service("web", new Container("nginx").replicate(2));
+	publicInternet.connect(80, web);
+	web.connect(5432, db);
+	deployment.deploy([db, web]);
+	deployment.deploy([new Machine({role: "Worker"}), new Machine({role: "Master"})]);`)
+
+	edited := parse(`createDeployment({namespace: "myapp"});
+	deployment.deploy([new Machine({role: "Master"}), new Machine({role: "Worker"})]);
+	var web = new Service("web", new Container("nginx").replicate(2));
+	var db = new Service("db", [new Container("postgres:9.6")]);
+	web.connect(5432, db);
+	publicInternet.connect(80, web);
+	deployment.deploy([web, db]);`)
+
+	id := spec.DeploymentID()
+	assert.Regexp(t, "^myapp-[0-9a-f]{8}$", id)
+	assert.NotEqual(t, spec.Containers, reordered.Containers)
+	assert.Equal(t, id, reordered.DeploymentID())
+	assert.NotEqual(t, id, edited.DeploymentID())
+
+	// Only the meaning of the Stitch is hashed, not its encoding.
+	fromJSON, err := FromJSON(spec.String())
+	assert.NoError(t, err)
+	fromJSON.BindingsVersion = "other"
+	assert.Equal(t, id, fromJSON.DeploymentID())
+
+	spec.Namespace = ""
+	assert.Regexp(t, "^[0-9a-f]{8}$", spec.DeploymentID())
+}
+
+func TestVerifyDeployment(t *testing.T) {
+	t.Parallel()
+
+	code := `var web = new Service("web", new Container("nginx").replicate(2));
+	publicInternet.connect(80, web);
+	deployment.deploy(web);`
+	spec, err := FromJavascript(code, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+
+	ok, err := VerifyDeployment(code, ImportGetter{Path: "."}, spec.String())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	tampered := spec
+	tampered.Connections = append([]Connection{{From: PublicInternetLabel,
+		To: "web", MinPort: 22, MaxPort: 22}}, spec.Connections...)
+	ok, err = VerifyDeployment(code, ImportGetter{Path: "."}, tampered.String())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = VerifyDeployment(code, ImportGetter{Path: "."}, "{")
+	assert.EqualError(t, err, "malformed deployment: unexpected end of JSON input")
+
+	_, err = VerifyDeployment("foo", ImportGetter{Path: "."}, spec.String())
+	assert.Error(t, err)
+}
+
+func TestParams(t *testing.T) {
+	t.Parallel()
+
+	eval := func(code string, params map[string]interface{}) (Stitch, error) {
+		return New("<raw_string>", code, ImportGetter{Path: "."},
+			WithParams(params))
+	}
+
+	code := `var web = new Service("web", new Container(params.image)
+		.replicate(params.replicas.web || 1));
+	deployment.deploy(web);
+	deployment.adminACL = params.acl.admin;`
+	params := map[string]interface{}{
+		"image":        "nginx",
+		"replicas.web": 3,
+		"acl":          map[string]interface{}{"admin": []string{"local"}},
+	}
+	spec, err := eval(code, params)
+	assert.NoError(t, err)
+	assert.Len(t, spec.Containers, 3)
+	assert.Equal(t, "nginx", spec.Containers[0].Image)
+	assert.Equal(t, []string{"local"}, spec.AdminACL)
+	assert.Equal(t, params, spec.Params)
+
+	// Reading a parameter that wasn't provided fails, even if it's defaulted.
+	_, err = eval(`deployment.deploy(new Service("web",
+		new Container("nginx").replicate(params.replicas || 2)));`, nil)
+	assert.EqualError(t, err, "unknown parameter: replicas")
+
+	_, err = eval(`var image = params.image;
+	deployment.deploy(new Service("web", new Container(image)
+		.replicate(params.replicas["db"])));`, params)
+	assert.EqualError(t, err, "unknown parameter: replicas.db")
+
+	// A `params` of the stitch's own isn't checked.
+	spec, err = eval(`function replicate(params) {
+		return new Container("nginx").replicate(params.count);
+	}
+	deployment.deploy(new Service("web", replicate({count: 2})));`, nil)
+	assert.NoError(t, err)
+	assert.Len(t, spec.Containers, 2)
+	assert.Nil(t, spec.Params)
+
+	params["replicas.wbe"] = 3
+	_, err = eval(code, params)
+	assert.EqualError(t, err, "unknown parameter: replicas.wbe")
+
+	_, err = eval(code, map[string]interface{}{"replicas": 1, "replicas.web": 3})
+	assert.EqualError(t, err, "parameter replicas can't have fields")
+
+	_, err = eval(code, map[string]interface{}{"replicas..web": 3})
+	assert.EqualError(t, err, `malformed parameter name: "replicas..web"`)
+
+	// Deployments record their parameters, so they can be verified.
+	params = map[string]interface{}{"image": "nginx", "replicas.web": 2,
+		"acl.admin": []string{}}
+	spec, err = eval(code, params)
+	assert.NoError(t, err)
+	ok, err := VerifyDeployment(code, ImportGetter{Path: "."}, spec.String())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	spec.Params["image"] = "postgres"
+	ok, err = VerifyDeployment(code, ImportGetter{Path: "."}, spec.String())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	checkContainers(t, `var c = new Container("image").withInit();
+	deployment.deploy(new Service("foo", [c, c.clone()]));`,
+		map[int]Container{
+			1: {
+				ID:      1,
+				Image:   "image",
+				Command: []string{},
+				Env:     map[string]string{},
+				Init:    true,
+
+				NetworkMode: "overlay",
+
+				FilepathToContent: map[string]string{},
+				Tmpfs:             []string{},
+				ExposedPorts:      []int{},
+			},
+			2: {
+				ID:      2,
+				Image:   "image",
+				Command: []string{},
+				Env:     map[string]string{},
+				Init:    true,
+
+				NetworkMode: "overlay",
+
+				FilepathToContent: map[string]string{},
+				Tmpfs:             []string{},
+				ExposedPorts:      []int{},
+			},
+		})
+
+	// Containers without an init omit the field, and so default to false.
+	actual, err := FromJSON(`{"Containers": [{"ID": 1, "Image": "image"}]}`)
+	assert.Nil(t, err)
+	assert.False(t, actual.Containers[0].Init)
+
+	exp := Stitch{Containers: []Container{{ID: 1, Image: "image", Init: true}}}
+	actual, err = FromJSON(exp.String())
+	assert.Nil(t, err)
+	assert.Equal(t, exp, actual)
+}
+
 func TestPlacement(t *testing.T) {
 	t.Parallel()
 
@@ -213,6 +538,38 @@ func TestPlacement(t *testing.T) {
 		})
 }
 
+func TestDefaultSpread(t *testing.T) {
+	t.Parallel()
+
+	code := `createDeployment({defaultSpread: true});
+	var web = new Service("web", new Container("nginx").replicate(3));
+	var db = new Service("db", new Container("postgres").replicate(2));
+	var lb = new Service("lb", [new Container("haproxy")]);
+	db.place(new LabelRule(false, db));
+	deployment.deploy([web, db, lb]);`
+	spec, err := FromJavascript(code, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.True(t, spec.DefaultSpread)
+
+	// The authored placement of db wins, and lb has nothing to spread.
+	assert.Equal(t, []Placement{
+		{TargetLabel: "db", OtherLabel: "db"},
+		{TargetLabel: "web", OtherLabel: "web", Exclusive: true},
+	}, spec.Placements)
+
+	actual, err := FromJSON(spec.String())
+	assert.NoError(t, err)
+	assert.Equal(t, spec.DefaultSpread, actual.DefaultSpread)
+	assert.Equal(t, spec.Placements, actual.Placements)
+
+	spec, err = FromJavascript(`deployment.deploy(
+		new Service("web", new Container("nginx").replicate(3)));`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.False(t, spec.DefaultSpread)
+	assert.Empty(t, spec.Placements)
+}
+
 func TestLabel(t *testing.T) {
 	t.Parallel()
 
@@ -224,6 +581,7 @@ func TestLabel(t *testing.T) {
 				Name:        "web_tier",
 				IDs:         []int{1},
 				Annotations: []string{},
+				Hostnames:   []Hostname{},
 			},
 		})
 
@@ -238,6 +596,7 @@ func TestLabel(t *testing.T) {
 				Name:        "web_tier",
 				IDs:         []int{1, 2},
 				Annotations: []string{},
+				Hostnames:   []Hostname{},
 			},
 		})
 
@@ -249,11 +608,13 @@ func TestLabel(t *testing.T) {
 				Name:        "foo",
 				IDs:         []int{},
 				Annotations: []string{},
+				Hostnames:   []Hostname{},
 			},
 			"foo2": {
 				Name:        "foo2",
 				IDs:         []int{},
 				Annotations: []string{},
+				Hostnames:   []Hostname{},
 			},
 		})
 
@@ -328,10 +689,234 @@ func TestConnect(t *testing.T) {
 			},
 		})
 
+	checkConnections(t, pre+`publicInternet.connect(53, foo, "udp");`,
+		[]Connection{
+			{
+				From:     "public",
+				To:       "foo",
+				MinPort:  53,
+				MaxPort:  53,
+				Protocol: "udp",
+			},
+		})
+
+	checkConnections(t, pre+`foo.connect(80, bar, "tcp");`,
+		[]Connection{
+			{
+				From:     "foo",
+				To:       "bar",
+				MinPort:  80,
+				MaxPort:  80,
+				Protocol: "tcp",
+			},
+		})
+
+	expConns := []Connection{
+		{From: "foo", To: "bar", MinPort: 6000, MaxPort: 6100, Protocol: "tcp"},
+		{From: "foo", To: "bar", MinPort: 9000, MaxPort: 9000, Protocol: "tcp"},
+	}
+	checkConnections(t, pre+`foo.connect([new PortRange(6000, 6100), 9000], bar,
+		"tcp");`, expConns)
+	checkConnections(t, pre+`foo.connect(new PortRange(6000, 6100), bar, "tcp");
+	foo.connect(9000, bar, "tcp");`, expConns)
+
+	checkConnections(t, pre+`publicInternet.connect([80, 443], foo);`,
+		[]Connection{
+			{From: "public", To: "foo", MinPort: 80, MaxPort: 80},
+			{From: "public", To: "foo", MinPort: 443, MaxPort: 443},
+		})
+
+	checkError(t, pre+`foo.connect([80, new PortRange(80, 81)], publicInternet);`,
+		"public internet cannot connect on port ranges")
 	checkError(t, pre+`foo.connect(new PortRange(80, 81), publicInternet);`,
 		"public internet cannot connect on port ranges")
 	checkError(t, pre+`publicInternet.connect(new PortRange(80, 81), foo);`,
 		"public internet cannot connect on port ranges")
+	checkError(t, pre+`publicInternet.connect(80, foo, "sctp");`,
+		"unknown protocol: sctp")
+}
+
+func TestConnectICMP(t *testing.T) {
+	t.Parallel()
+
+	pre := `var foo = new Service("foo", []);
+	var bar = new Service("bar", []);
+	deployment.deploy([foo, bar]);`
+
+	checkConnections(t, pre+`foo.connect(new Icmp(), bar);`,
+		[]Connection{{From: "foo", To: "bar", Protocol: "icmp"}})
+	checkConnections(t, pre+`foo.connect(new Icmp(), publicInternet);`,
+		[]Connection{{From: "foo", To: "public", Protocol: "icmp"}})
+	checkConnections(t, pre+`publicInternet.connect(new Icmp(), foo, "icmp");`,
+		[]Connection{{From: "public", To: "foo", Protocol: "icmp"}})
+
+	checkError(t, pre+`foo.connect(new Icmp(), bar, "tcp");`,
+		"ICMP connections cannot use protocol: tcp")
+	checkError(t, pre+`foo.connect(80, publicInternet, "icmp");`,
+		"ICMP connection foo->public cannot have ports")
+
+	// ICMP connections to the public internet don't claim a public port.
+	checkPlacements(t, pre+`publicInternet.connect(new Icmp(), foo);
+	publicInternet.connect(new Icmp(), bar);`, []Placement{})
+}
+
+func TestEphemeralPorts(t *testing.T) {
+	t.Parallel()
+
+	pre := `var foo = new Service("foo", [new Container("image")]);
+	var bar = new Service("bar", [new Container("image")]);
+	deployment.deploy([foo, bar]);`
+
+	checkConnections(t, pre+`foo.connect(ephemeralPorts(), bar);`,
+		[]Connection{{From: "foo", To: "bar", MinPort: EphemeralMinPort,
+			MaxPort: EphemeralMaxPort, Ephemeral: true}})
+
+	// Unlike other ranges, the public internet may connect on the ephemeral ports,
+	// and doing so doesn't keep the services off of the same machine.
+	checkConnections(t, pre+`publicInternet.connect(ephemeralPorts(), foo);`,
+		[]Connection{{From: "public", To: "foo", MinPort: EphemeralMinPort,
+			MaxPort: EphemeralMaxPort, Ephemeral: true}})
+	checkPlacements(t, pre+`publicInternet.connect(ephemeralPorts(), foo);
+	publicInternet.connect(ephemeralPorts(), bar);`, []Placement{})
+	checkError(t, pre+`publicInternet.connect(new PortRange(32768, 60999), foo);`,
+		"public internet cannot connect on port ranges")
+
+	spec, err := FromJavascript(pre+`publicInternet.connect(ephemeralPorts(), foo);
+	foo.connect(ephemeralPorts(), bar);
+	deployment.openHostPort(new PortRange(30000, 40000));`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"public connection public->foo on ports 32768-60999 spans the " +
+			"ephemeral ports, which exposes whatever is assigned them",
+		"public connection public->the workers on ports 30000-40000 spans " +
+			"the ephemeral ports, which exposes whatever is assigned them",
+	}, spec.ephemeralWarnings())
+
+	// A public ephemeral range takes a single NAT rule for each protocol.
+	assert.Equal(t, 2, spec.EstimateRuleCount())
+
+	// The containers of a label on the ephemeral ports may share a worker, so
+	// each of them takes its own rules.
+	spec, err = FromJavascript(`var foo = new Service("foo",
+		[new Container("image"), new Container("image")]);
+	deployment.deploy(foo);
+	publicInternet.connect(ephemeralPorts(), foo);`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, spec.EstimateRuleCount())
+
+	spec.Connections = []Connection{{From: "foo", To: "bar", MinPort: 80,
+		MaxPort: 90, Ephemeral: true}}
+	assert.EqualError(t, spec.Validate(), "ephemeral connection foo->bar must be "+
+		"on ports 32768-60999, not 80-90")
+}
+
+func TestConnectToAnnotated(t *testing.T) {
+	t.Parallel()
+
+	pre := `var web = new Service("web", []);
+	var db = new Service("db", []);
+	db.annotate("tier=data");
+	var cache = new Service("cache", []);
+	var queue = new Service("queue", []);
+	queue.annotate("tier=data");
+	queue.annotate("durable");
+	deployment.deploy([web, db, cache, queue]);`
+
+	// The selector matches the two annotated labels, in the order they were
+	// deployed, and the result is the same as connecting to each of them.
+	exp := []Connection{
+		{From: "web", To: "db", MinPort: 80, MaxPort: 80, Protocol: "tcp"},
+		{From: "web", To: "queue", MinPort: 80, MaxPort: 80, Protocol: "tcp"},
+	}
+	checkConnections(t, pre+`web.connectToAnnotated(80, "tier=data", "tcp");`, exp)
+	checkConnections(t, pre+`web.connect(80, db, "tcp");
+	web.connect(80, queue, "tcp");`, exp)
+
+	// Labels annotated after the connection are selected too.
+	checkConnections(t, pre+`web.connectToAnnotated([22, 443], "role=admin");
+	cache.annotate("role=admin");`, []Connection{
+		{From: "web", To: "cache", MinPort: 22, MaxPort: 22},
+		{From: "web", To: "cache", MinPort: 443, MaxPort: 443},
+	})
+
+	checkConnections(t, pre+`web.connectToAnnotated(80, "tier=none");`,
+		[]Connection{})
+
+	checkError(t, pre+`web.connectToAnnotated(80, "durable");`,
+		`web connects to an invalid annotation "durable": annotations must `+
+			`be of the form key=value`)
+	checkError(t, pre+`web.connectToAnnotated(80, "tier = data");`,
+		`web connects to an invalid annotation "tier = data": annotations `+
+			`must be of the form key=value`)
+	checkError(t, pre+`web.connectToAnnotated(80);`,
+		"connectToAnnotated requires an annotation of the form key=value")
+}
+
+func TestPublicPortProtocols(t *testing.T) {
+	t.Parallel()
+
+	pre := `var dns = new Service("dns", []);
+	var dnsTCP = new Service("dnsTCP", []);
+	var other = new Service("other", []);
+	deployment.deploy([dns, dnsTCP, other]);`
+
+	exclusive := func(target, other string) Placement {
+		return Placement{
+			TargetLabel: target,
+			OtherLabel:  other,
+			Exclusive:   true,
+		}
+	}
+
+	// Services listening on the same port with different protocols may share a
+	// machine.
+	checkPlacements(t, pre+`publicInternet.connect(53, dns, "udp");
+	publicInternet.connect(53, dnsTCP, "tcp");`,
+		[]Placement{exclusive("dns", "dns"), exclusive("dnsTCP", "dnsTCP")})
+
+	// A connection on both protocols conflicts with either of them.
+	checkPlacements(t, pre+`publicInternet.connect(53, dns, "udp");
+	publicInternet.connect(53, other);`,
+		[]Placement{
+			exclusive("dns", "dns"),
+			exclusive("dns", "other"),
+			exclusive("other", "dns"),
+			exclusive("other", "other"),
+		})
+}
+
+func TestPublicProbe(t *testing.T) {
+	t.Parallel()
+
+	pre := `var dns = new Service("dns", []);
+	deployment.deploy([dns]);`
+
+	spec, err := FromJavascript(pre+`publicInternet.connect(53, dns, {
+		protocol: "udp",
+		probe: {udpQuery: "abcd0100", expectResponse: true}
+	});
+	publicInternet.connect(80, dns, "tcp");`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, []Connection{
+		{From: PublicInternetLabel, To: "dns", MinPort: 53, MaxPort: 53,
+			Protocol: "udp", Probe: Probe{UDPQuery: "abcd0100",
+				ExpectResponse: true}},
+		{From: PublicInternetLabel, To: "dns", MinPort: 80, MaxPort: 80,
+			Protocol: "tcp"},
+	}, spec.Connections)
+
+	checkError(t, pre+`publicInternet.connect(53, dns, {probe: {udpQuery: "ab"}});`,
+		"the probe of connection public->dns requires the udp protocol")
+	checkError(t, pre+`publicInternet.connect(53, dns, {protocol: "udp",
+		probe: {udpQuery: "xyz"}});`,
+		`connection public->dns has an invalid UDP probe query: "xyz"`)
+	checkError(t, pre+`publicInternet.connect(53, dns, {protocol: "udp",
+		probe: {expectResponse: true}});`,
+		"probes require a udpQuery of hex encoded bytes")
+	checkError(t, pre+`dns.connect(53, dns, {protocol: "udp",
+		probe: {udpQuery: "ab"}});`,
+		"only connections from the public internet can be probed")
 }
 
 func TestVet(t *testing.T) {
@@ -367,11 +952,13 @@ func TestCustomDeploy(t *testing.T) {
 				Name:        "web_tier",
 				IDs:         []int{1},
 				Annotations: []string{},
+				Hostnames:   []Hostname{},
 			},
 			"web_tier2": {
 				Name:        "web_tier2",
 				IDs:         []int{2},
 				Annotations: []string{},
+				Hostnames:   []Hostname{},
 			},
 		})
 
@@ -418,6 +1005,23 @@ func TestQuery(t *testing.T) {
 	maxPriceChecker(t, ``, 0.0)
 	adminACLChecker(t, `createDeployment({adminACL: ["local"]});`, []string{"local"})
 	adminACLChecker(t, ``, []string{})
+
+	roleACLChecker := queryChecker(func(handle Stitch) interface{} {
+		return [][]string{handle.MasterACL, handle.WorkerACL}
+	})
+	roleACLChecker(t, `createDeployment({
+		masterACL: ["1.2.3.4/32"],
+		workerACL: ["10.0.0.0/8", "1.2.3.4/32"]
+	});`, [][]string{{"1.2.3.4/32"}, {"10.0.0.0/8", "1.2.3.4/32"}})
+	roleACLChecker(t, ``, [][]string{{}, {}})
+
+	encryptChecker := queryChecker(func(handle Stitch) interface{} {
+		return handle.EncryptTraffic
+	})
+	encryptChecker(t, `deployment.encryptTraffic(true);`, true)
+	encryptChecker(t, `deployment.encryptTraffic();`, true)
+	encryptChecker(t, `deployment.encryptTraffic(false);`, false)
+	encryptChecker(t, ``, false)
 }
 
 func TestMarshal(t *testing.T) {
@@ -441,6 +1045,461 @@ func TestMarshal(t *testing.T) {
 	assert.Equal(t, exp, actual)
 }
 
+func TestDebugWriter(t *testing.T) {
+	t.Parallel()
+
+	code := `var foo = new Service("foo", [new Container("image")]);
+	publicInternet.connect(80, foo);
+	deployment.deploy(foo);`
+
+	var buf bytes.Buffer
+	spec, err := New("<test_code>", code, ImportGetter{Path: "."},
+		WithDebugWriter(&buf))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	assert.Equal(t, spec.String()+"\n", buf.String())
+
+	// Invariant failures still print the parsed spec.
+	buf.Reset()
+	_, err = New("<test_code>", code+`deployment.assert(
+		publicInternet.canReach(foo), false);`,
+		ImportGetter{Path: "."}, WithDebugWriter(&buf))
+	assert.NotNil(t, err)
+	assert.NotEmpty(t, buf.String())
+}
+
+func TestSimplifyPlacements(t *testing.T) {
+	t.Parallel()
+
+	onAmazonWest := Placement{TargetLabel: "web", Provider: "Amazon",
+		Region: "us-west-1"}
+	onAmazon := Placement{TargetLabel: "web", Provider: "Amazon"}
+	notGoogle := Placement{TargetLabel: "web", Exclusive: true,
+		Provider: "Google"}
+	notSmall := Placement{TargetLabel: "web", Exclusive: true, Size: "m4.small"}
+	notWithDB := Placement{TargetLabel: "web", Exclusive: true, OtherLabel: "db"}
+	dbOnAmazon := Placement{TargetLabel: "db", Provider: "Amazon"}
+	dbNotWithWeb := Placement{TargetLabel: "db", Exclusive: true, OtherLabel: "web"}
+
+	spec := Stitch{Placements: []Placement{
+		onAmazon,
+		notGoogle,
+		onAmazonWest,
+		notSmall,
+		notWithDB,
+		notWithDB,
+		{TargetLabel: "web"},
+		dbOnAmazon,
+		dbNotWithWeb,
+	}}
+	exp := []Placement{onAmazonWest, notSmall, notWithDB, dbOnAmazon,
+		dbNotWithWeb}
+	assert.Equal(t, exp, spec.SimplifyPlacements().Placements)
+
+	// The original Stitch is left alone.
+	assert.Len(t, spec.Placements, 9)
+
+	// A constraint on one field says nothing about the others.
+	spec = Stitch{Placements: []Placement{onAmazon, notSmall,
+		{TargetLabel: "web", Region: "us-west-1"}}}
+	assert.Equal(t, spec.Placements, spec.SimplifyPlacements().Placements)
+
+	// Placements that imply each other are only removed once.
+	spec = Stitch{Placements: []Placement{onAmazon, onAmazon}}
+	assert.Equal(t, []Placement{onAmazon}, spec.SimplifyPlacements().Placements)
+
+	// GPU placements are constraints like any other, so they aren't dropped.
+	onGPU := Placement{TargetLabel: "web", GPU: true}
+	notGPU := Placement{TargetLabel: "web", Exclusive: true, GPU: true}
+	spec = Stitch{Placements: []Placement{onAmazon, onGPU, notGPU, onGPU}}
+	assert.Equal(t, []Placement{onAmazon, onGPU, notGPU},
+		spec.SimplifyPlacements().Placements)
+}
+
+func TestEgressPorts(t *testing.T) {
+	t.Parallel()
+
+	spec := Stitch{
+		Connections: []Connection{
+			{From: "app", To: "db", MinPort: 3306, MaxPort: 3306},
+			{From: "app", To: "public", MinPort: 443, MaxPort: 443},
+			{From: "app", To: "cache", MinPort: 6380, MaxPort: 6381},
+			{From: "app", To: "cache", MinPort: 6379, MaxPort: 6380},
+			{From: "app", To: "db", Protocol: ICMP},
+			{From: "db", To: "app", MinPort: 80, MaxPort: 80},
+			{From: "public", To: "app", MinPort: 80, MaxPort: 80},
+		},
+	}
+
+	exp := map[string][]int{
+		"db":     {3306},
+		"public": {443},
+		"cache":  {6379, 6380, 6381},
+	}
+	assert.Equal(t, exp, spec.EgressPorts("app"))
+	assert.Equal(t, map[string][]int{}, spec.EgressPorts("unknown"))
+}
+
+func TestMTLSConnections(t *testing.T) {
+	t.Parallel()
+
+	code := `var foo = new Service("foo", []);
+	var bar = new Service("bar", []);
+	var baz = new Service("baz", []);
+	baz.annotate("tier=data");
+	deployment.deploy([foo, bar, baz]);
+	foo.connect(80, bar, {requireMTLS: true});
+	foo.connect(81, bar);
+	bar.connectToAnnotated(5432, "tier=data", {requireMTLS: true});`
+	spec, err := FromJavascript(code, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+
+	mtls := []Connection{
+		{From: "foo", To: "bar", MinPort: 80, MaxPort: 80, RequireMTLS: true},
+		{From: "bar", To: "baz", MinPort: 5432, MaxPort: 5432,
+			RequireMTLS: true},
+	}
+	assert.Equal(t, mtls, spec.MTLSConnections())
+
+	// The flag survives the round trip through JSON to the daemon.
+	parsed, err := FromJSON(spec.String())
+	assert.NoError(t, err)
+	assert.Equal(t, mtls, parsed.MTLSConnections())
+	assert.Len(t, parsed.Connections, 3)
+
+	checkError(t, code+`foo.connect(80, publicInternet, {requireMTLS: true});`,
+		"connections to the public internet cannot require mTLS")
+}
+
+func TestDependentConnections(t *testing.T) {
+	t.Parallel()
+
+	appToDB := Connection{From: "app", To: "db", MinPort: 3306, MaxPort: 3306}
+	dbToBackup := Connection{From: "db", To: "backup", MinPort: 22, MaxPort: 22}
+	spec := Stitch{
+		Connections: []Connection{
+			appToDB,
+			{From: "public", To: "app", MinPort: 80, MaxPort: 80},
+			dbToBackup,
+		},
+		Placements: []Placement{
+			{TargetLabel: "db", Exclusive: true, OtherLabel: "app"},
+			{TargetLabel: "backup", Exclusive: true, OtherLabel: "db"},
+			{TargetLabel: "app", Provider: "Amazon"},
+		},
+	}
+
+	assert.Equal(t, []Connection{appToDB, dbToBackup},
+		spec.DependentConnections("db"))
+	assert.Empty(t, spec.DependentConnections("unknown"))
+
+	assert.Equal(t, Impact{
+		Connections: []Connection{appToDB, dbToBackup},
+		Placements:  spec.Placements[:2],
+	}, spec.ImpactOf("db"))
+	assert.Equal(t, Impact{}, spec.ImpactOf("unknown"))
+}
+
+func TestPublicExposure(t *testing.T) {
+	t.Parallel()
+
+	spec := Stitch{
+		Connections: []Connection{
+			{From: "public", To: "web", MinPort: 443, MaxPort: 443},
+			{From: "public", To: "web", MinPort: 80, MaxPort: 80,
+				Protocol: TCP},
+			{From: "public", To: "dns", MinPort: 53, MaxPort: 53,
+				Protocol: UDP},
+			{From: "public", To: "dns", MinPort: 53, MaxPort: 53,
+				Protocol: UDP},
+			{From: "public", To: "web", Protocol: ICMP},
+			{From: "web", To: "public", MinPort: 8080, MaxPort: 8080},
+			{From: "web", To: "db", MinPort: 5432, MaxPort: 5432},
+		},
+	}
+
+	exp := []Exposure{
+		{Label: "dns", Protocol: UDP, Ports: []int{53}},
+		{Label: "web", Protocol: TCP, Ports: []int{80, 443}},
+		{Label: "web", Protocol: UDP, Ports: []int{443}},
+	}
+	assert.Equal(t, exp, spec.PublicExposure())
+	assert.Equal(t, []Exposure{}, Stitch{}.PublicExposure())
+}
+
+func TestUnsatisfiedLocality(t *testing.T) {
+	t.Parallel()
+
+	spec := Stitch{
+		Machines: []Machine{
+			{Role: "Master", Region: "eu-west-1"},
+			{Role: "Worker", Region: "us-west-1"},
+			{Role: "Worker", Region: "us-east-1"},
+		},
+		Placements: []Placement{
+			{TargetLabel: "eu", Region: "eu-west-1"},
+			{TargetLabel: "us", Region: "us-west-1"},
+			{TargetLabel: "notUS", Region: "us-west-1", Exclusive: true},
+			{TargetLabel: "notUS", Region: "us-east-1", Exclusive: true},
+			{TargetLabel: "east", Region: "us-west-1", Exclusive: true},
+			{TargetLabel: "size", Size: "m4.large"},
+		},
+	}
+	assert.Equal(t, []string{"eu", "notUS"}, spec.UnsatisfiedLocality())
+
+	spec.Machines = append(spec.Machines,
+		Machine{Role: "Worker", Region: "eu-west-1"})
+	assert.Nil(t, spec.UnsatisfiedLocality())
+}
+
+func TestMachineNamePrefix(t *testing.T) {
+	t.Parallel()
+
+	prefix := func(namespace string) string {
+		return Stitch{Namespace: namespace}.MachineNamePrefix()
+	}
+
+	assert.Equal(t, "quilt-my-app-431cf07b", prefix("my-app"))
+	assert.Equal(t, "quilt-my-app-prod-9c47d208", prefix("My_App.Prod!"))
+	assert.Equal(t, "quilt-my-app-02687489", prefix("my_app"))
+	assert.Equal(t, "quilt-da39a3ee", prefix(""))
+	assert.Equal(t, "quilt-9a7b006d", prefix("!!!"))
+
+	long := strings.Repeat("a", 39) + "-" + strings.Repeat("b", 30)
+	assert.Equal(t, "quilt-"+strings.Repeat("a", 39)+"-9e53a9fc", prefix(long))
+
+	assert.Equal(t, prefix("my-app"), prefix("my-app"))
+}
+
+func TestIdleMachines(t *testing.T) {
+	t.Parallel()
+
+	gpu := Machine{Role: "Worker", Provider: "Amazon", Size: "p2.xlarge"}
+	generic := Machine{Role: "Worker", Provider: "Amazon", Size: "m4.large"}
+	spec := Stitch{
+		Containers: []Container{{ID: 1}, {ID: 2}},
+		Labels: []Label{
+			{Name: "ml", IDs: []int{1}},
+			{Name: "web", IDs: []int{2}},
+		},
+		Machines: []Machine{{Role: "Master", Size: "m4.large"}, gpu, generic},
+	}
+
+	// Without placements, any container may run anywhere.
+	assert.Nil(t, spec.IdleMachines())
+
+	// Only the ml containers may use the GPU machine.
+	spec.Placements = []Placement{
+		{TargetLabel: "ml", Size: "p2.xlarge"},
+		{TargetLabel: "web", Size: "p2.xlarge", Exclusive: true},
+	}
+	assert.Nil(t, spec.IdleMachines())
+
+	// If ml doesn't require a GPU either, nothing may use the GPU machine.
+	spec.Placements[0].Size = "m4.large"
+	assert.Equal(t, []Machine{gpu}, spec.IdleMachines())
+
+	// Labels without containers don't use machines.
+	spec.Placements[0].Size = "p2.xlarge"
+	spec.Labels[1].IDs = nil
+	assert.Equal(t, []Machine{generic}, spec.IdleMachines())
+}
+
+func TestConnectionDiff(t *testing.T) {
+	t.Parallel()
+
+	web := Connection{From: "public", To: "web", MinPort: 80, MaxPort: 80}
+	db := Connection{From: "web", To: "db", MinPort: 5432, MaxPort: 5432}
+	https := Connection{From: "public", To: "web", MinPort: 443, MaxPort: 443,
+		Protocol: TCP}
+	ssh := Connection{From: "web", To: "bastion", MinPort: 22, MaxPort: 22}
+
+	old := Stitch{Connections: []Connection{db, web, ssh}}
+	new := Stitch{Connections: []Connection{https, web, db}}
+
+	added, removed := ConnectionDiff(old, new)
+	assert.Equal(t, []Connection{https}, added)
+	assert.Equal(t, []Connection{ssh}, removed)
+
+	// A change of protocol both adds and removes a connection, and the results
+	// are sorted.
+	udpWeb := web
+	udpWeb.Protocol = UDP
+	added, removed = ConnectionDiff(new, Stitch{
+		Connections: []Connection{udpWeb, https, ssh, db, ssh},
+	})
+	assert.Equal(t, []Connection{udpWeb, ssh}, added)
+	assert.Equal(t, []Connection{web}, removed)
+
+	added, removed = ConnectionDiff(old, old)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+
+	// Connections that differ in any field are distinct, and still sorted.
+	limited := web
+	limited.BandwidthLimit = 1000
+	hostWeb := web
+	hostWeb.HostNetwork = true
+	probed := hostWeb
+	probed.Probe = Probe{UDPQuery: "abcd", ExpectResponse: true}
+	added, removed = ConnectionDiff(old, Stitch{
+		Connections: []Connection{probed, db, hostWeb, limited, ssh}})
+	assert.Equal(t, []Connection{hostWeb, probed, limited}, added)
+	assert.Equal(t, []Connection{web}, removed)
+}
+
+func TestEffectiveACL(t *testing.T) {
+	t.Parallel()
+
+	master := Machine{Role: "Master"}
+	worker := Machine{Role: "Worker"}
+
+	spec := Stitch{AdminACL: []string{"local", "8.8.8.8/32", "1.2.3.4/16"}}
+	assert.Equal(t, []string{"1.2.0.0/16", "8.8.8.8/32", "local"},
+		spec.EffectiveACL(master))
+	assert.Equal(t, spec.EffectiveACL(master), spec.EffectiveACL(worker))
+
+	// A role's own ACL takes precedence over the others.
+	spec.MasterACL = []string{"10.0.0.0/8", "10.1.2.3/8", "8.8.8.8/32"}
+	assert.Equal(t, []string{"10.0.0.0/8", "8.8.8.8/32"}, spec.EffectiveACL(master))
+
+	// Roles without their own ACL fall back to the AdminACL, as on the minions.
+	assert.Equal(t, []string{"1.2.0.0/16", "8.8.8.8/32", "local"},
+		spec.EffectiveACL(worker))
+
+	spec.WorkerACL = []string{"192.168.1.0/24"}
+	assert.Equal(t, []string{"192.168.1.0/24"}, spec.EffectiveACL(worker))
+
+	assert.Equal(t, []string{}, Stitch{}.EffectiveACL(worker))
+}
+
+func TestCanary(t *testing.T) {
+	t.Parallel()
+
+	code := `var app = new Service("app", new Container("app:v1").replicate(2));
+	app.canary({image: "app:v2"});
+	publicInternet.connect(80, app);
+	deployment.deploy(app);`
+	spec, err := FromJavascript(code, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+
+	var images []string
+	for _, c := range spec.Containers {
+		images = append(images, c.Image)
+	}
+	assert.Equal(t, []string{"app:v1", "app:v1", "app:v2"}, images)
+	assert.Equal(t, []bool{false, false, true}, []bool{spec.Containers[0].Canary,
+		spec.Containers[1].Canary, spec.Containers[2].Canary})
+	assert.Equal(t, []int{1, 2, 3}, spec.Labels[0].IDs)
+
+	promoted, err := spec.PromoteCanary("app")
+	assert.NoError(t, err)
+	for _, c := range promoted.Containers {
+		assert.Equal(t, "app:v2", c.Image)
+		assert.False(t, c.Canary)
+	}
+	assert.Equal(t, spec.Labels, promoted.Labels)
+	assert.Equal(t, "app:v1", spec.Containers[0].Image)
+	assert.True(t, spec.Containers[2].Canary)
+
+	aborted, err := spec.AbortCanary("app")
+	assert.NoError(t, err)
+	assert.Equal(t, spec.Containers[:2], aborted.Containers)
+	assert.Equal(t, []int{1, 2}, aborted.Labels[0].IDs)
+	assert.Equal(t, []int{1, 2, 3}, spec.Labels[0].IDs)
+
+	_, err = promoted.PromoteCanary("app")
+	assert.EqualError(t, err, "label app has no canaries")
+
+	_, err = spec.AbortCanary("web")
+	assert.EqualError(t, err, "no label: web")
+
+	spec.Containers[1].Canary = true
+	spec.Containers[1].Image = "app:v3"
+	_, err = spec.PromoteCanary("app")
+	assert.EqualError(t, err, "canaries of app run different images: "+
+		"app:v3 and app:v2")
+
+	_, err = FromJavascript(`var app = new Service("app", []);
+	app.canary({image: "app:v2"});`, ImportGetter{Path: "."})
+	assert.EqualError(t, err, "cannot canary app because it has no containers")
+}
+
+var updateGolden = flag.Bool("update", false, "update the golden deployment files")
+
+func TestBindingsChecksum(t *testing.T) {
+	t.Parallel()
+
+	embedded := sha256.Sum256([]byte(javascriptBindings))
+	if hex.EncodeToString(embedded[:]) != bindingsChecksum {
+		t.Error("The embedded bindings don't match their checksum. " +
+			"Regenerate them with `go generate`.")
+	}
+
+	src, err := ioutil.ReadFile("bindings.js")
+	if err != nil {
+		t.Fatalf("Failed to read bindings source: %s", err)
+	}
+	if string(src) != javascriptBindings {
+		t.Error("bindings.js.go is out of date with bindings.js. " +
+			"Regenerate it with `go generate`.")
+	}
+}
+
+func TestGoldenDeployment(t *testing.T) {
+	t.Parallel()
+
+	// Read the spec directly rather than through FromFile because other tests
+	// swap out util.AppFs.
+	specPath := "testdata/canonical.js"
+	src, err := ioutil.ReadFile(specPath)
+	if err != nil {
+		t.Fatalf("Failed to read spec: %s", err)
+	}
+
+	spec, err := New(specPath, string(src), ImportGetter{Path: "."})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if spec.BindingsVersion != BindingsVersion() {
+		t.Errorf("Expected bindings version %s, got %s",
+			BindingsVersion(), spec.BindingsVersion)
+	}
+
+	// The bindings version changes with every edit to bindings.js, so it's
+	// excluded from the comparison.  What matters is the deployment it produces.
+	spec.BindingsVersion = ""
+
+	goldenPath := "testdata/canonical.json"
+	if *updateGolden {
+		out, err := json.MarshalIndent(spec, "", "\t")
+		if err != nil {
+			t.Fatalf("Failed to marshal spec: %s", err)
+		}
+		if err := ioutil.WriteFile(goldenPath, out, 0644); err != nil {
+			t.Fatalf("Failed to write golden file: %s", err)
+		}
+	}
+
+	golden, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("Failed to read golden file: %s", err)
+	}
+
+	exp, err := FromJSON(string(golden))
+	if err != nil {
+		t.Fatalf("Failed to parse golden file: %s", err)
+	}
+
+	if !reflect.DeepEqual(exp, spec) {
+		t.Errorf("Evaluated deployment differs from %s. If the change is "+
+			"intended, rerun with -update.\nExpected %s\nGot %s",
+			goldenPath, spew.Sdump(exp), spew.Sdump(spec))
+	}
+}
+
 func checkJavascript(t *testing.T, code string, exp interface{}) {
 	resultKey := "result"
 
@@ -453,7 +1512,7 @@ func checkJavascript(t *testing.T, code string, exp interface{}) {
 	}
 
 	exec := fmt.Sprintf(`exports.%s = %s;`, resultKey, code)
-	moduleVal, err := runSpec(vm, "<test_code>", exec)
+	moduleVal, err := ImportGetter{}.runSpec(vm, "<test_code>", exec)
 	if err != nil {
 		t.Errorf(`Unexpected error: "%s".`, err.Error())
 		return
@@ -0,0 +1,89 @@
+package stitch
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/robertkrimen/otto"
+)
+
+// StrictDirective, as the first statement of a spec, evaluates it in strict mode.  In
+// strict mode, modules may only share state through the deployment and their
+// exports: a module that assigns a variable it never declared, which Javascript
+// makes a global, fails.  The directive applies to every module the spec imports.
+const StrictDirective = "use quilt strict"
+
+// Matches specs that begin with the StrictDirective, after any comments.
+var strictDirectiveRE = regexp.MustCompile(
+	`^(?:\s|//[^\n]*|/\*(?s:.*?)\*/)*["']` + StrictDirective + `["']`)
+
+// WithStrict causes New to evaluate the spec in strict mode, as if it began with the
+// StrictDirective.
+func WithStrict() Option {
+	return func(opts *options) {
+		opts.strict = true
+	}
+}
+
+func hasStrictDirective(spec string) bool {
+	return strictDirectiveRE.MatchString(spec)
+}
+
+// runStrict runs `code`, and fails if it created any globals.  Modules are
+// evaluated within a closure, so any global they create was assigned without being
+// declared.
+func runStrict(vm *otto.Otto, filename, code string) (otto.Value, error) {
+	before, err := globalNames(vm)
+	if err != nil {
+		return otto.Value{}, err
+	}
+
+	val, err := run(vm, filename, code)
+	if err != nil {
+		return otto.Value{}, err
+	}
+
+	after, err := globalNames(vm)
+	if err != nil {
+		return otto.Value{}, err
+	}
+
+	var leaked []string
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			leaked = append(leaked, name)
+		}
+	}
+	sort.Strings(leaked)
+
+	if len(leaked) > 0 {
+		return otto.Value{}, fmt.Errorf("%s assigned the undeclared variable "+
+			"%s, which would be shared with every module: declare it with "+
+			"var, or share it through exports (%q)", filename, leaked[0],
+			StrictDirective)
+	}
+	return val, nil
+}
+
+// globalNames returns the names of the properties of the VM's global object.
+func globalNames(vm *otto.Otto) (map[string]struct{}, error) {
+	val, err := vm.Run("Object.getOwnPropertyNames(this)")
+	if err != nil {
+		return nil, err
+	}
+
+	exp, _ := val.Export()
+	names := map[string]struct{}{}
+	switch exp := exp.(type) {
+	case []string:
+		for _, name := range exp {
+			names[name] = struct{}{}
+		}
+	case []interface{}:
+		for _, name := range exp {
+			names[fmt.Sprint(name)] = struct{}{}
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,29 @@
+// A spec exercising most of the bindings.  Its evaluation is compared against
+// canonical.json to catch unintended changes to the bindings.
+createDeployment({
+    namespace: "canonical",
+    maxPrice: 1,
+    adminACL: ["10.0.0.0/8"]
+});
+
+var baseMachine = new Machine({
+    provider: "Amazon",
+    region: "us-west-2",
+    size: "m4.large",
+    diskSize: 32,
+    sshKeys: ["key"]
+});
+deployment.deploy(baseMachine.asMaster());
+deployment.deploy(baseMachine.asWorker().replicate(2));
+
+var web = new Service("web", new Container("nginx", ["run"]).replicate(2));
+var db = new Service("db", [new Container("postgres").withEnv({"USER": "quilt"})]);
+db.annotate("ACL");
+web.connect(5432, db);
+publicInternet.connect(80, web);
+db.place(new MachineRule(true, {provider: "Amazon"}));
+deployment.deploy([web, db]);
+deployment.deploy(new LoadBalancer(web, {listenerPort: 443, backendPort: 80,
+    healthPath: "/"}));
+
+deployment.assert(publicInternet.canReach(web), true);
@@ -0,0 +1,1091 @@
+package stitch
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MaxFileContentSize is the maximum number of bytes of files that may be injected
+// into a single container.  Larger files should be built into the image instead.
+const MaxFileContentSize = 1 << 20
+
+// Validate checks that the Stitch is internally consistent, returning an error
+// describing the first problem found.
+func (stitch Stitch) Validate() error {
+	for _, validator := range []func() error{
+		stitch.validateSpotPrices,
+		stitch.validateSizeFallbacks,
+		stitch.validateNetworkTiers,
+		stitch.validateProtocols,
+		stitch.validatePorts,
+		stitch.validatePortRanges,
+		stitch.validateBandwidthLimits,
+		stitch.validateDSCP,
+		stitch.validateProbes,
+		stitch.validateHostConnections,
+		stitch.validateExposedPorts,
+		stitch.validateLabelIDs,
+		stitch.validateLabelSizes,
+		stitch.validateNoEgress,
+		stitch.validateRoleACLs,
+		stitch.validateShmSizes,
+		stitch.validateFiles,
+		stitch.validateTmpfs,
+		stitch.validateTCPKeepalive,
+		stitch.validateNetworkTuning,
+		stitch.validateNATBackend,
+		stitch.validateNATRuleCount,
+		stitch.validateMaintenanceWindow,
+		stitch.validateNetworks,
+		stitch.validateArchs,
+		stitch.validateGPUs,
+		stitch.validateStopSignals,
+		stitch.validateCPUSets,
+		stitch.validateNetworkModes,
+		stitch.validateSidecars,
+		stitch.validateStaticMachines,
+		stitch.validateAutoscale,
+		stitch.validateFloatingIPs,
+		stitch.validateHostnames,
+		stitch.validateLoadBalancers,
+		stitch.validateInvariants,
+	} {
+		if err := validator(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (stitch Stitch) validateSpotPrices() error {
+	for _, m := range stitch.Machines {
+		if m.SpotPrice < 0 {
+			return fmt.Errorf("spot price must not be negative: %g", m.SpotPrice)
+		}
+
+		if stitch.MaxPrice != 0 && m.SpotPrice > stitch.MaxPrice {
+			return fmt.Errorf("spot price %g exceeds the max price %g",
+				m.SpotPrice, stitch.MaxPrice)
+		}
+	}
+	return nil
+}
+
+// NetworkTiers are the network tiers each provider can boot machines with.
+var NetworkTiers = map[string][]string{
+	"Amazon": {NetworkTierEnhanced},
+}
+
+// NetworkTierEnhanced boots Amazon machines with enhanced networking, which only
+// some sizes offer.
+const NetworkTierEnhanced = "enhanced"
+
+func (stitch Stitch) validateNetworkTiers() error {
+	machines := stitch.Machines
+	if stitch.AutoscaleWorkers.Max > 0 {
+		machines = append(machines, stitch.AutoscaleWorkers.Template)
+	}
+
+	for _, m := range machines {
+		if m.NetworkTier == "" {
+			continue
+		}
+
+		tiers := NetworkTiers[m.Provider]
+		if !contains(tiers, m.NetworkTier) {
+			return fmt.Errorf("%s machines have no network tier %q, only %v",
+				m.Provider, m.NetworkTier, tiers)
+		}
+	}
+	return nil
+}
+
+func (stitch Stitch) validateSizeFallbacks() error {
+	for _, m := range stitch.Machines {
+		if len(m.SizeFallbacks) != 0 && m.Size == "" {
+			return fmt.Errorf("machine has size fallbacks, but no size: %v",
+				m.SizeFallbacks)
+		}
+
+		sizes := map[string]struct{}{m.Size: {}}
+		for _, size := range m.SizeFallbacks {
+			if size == "" {
+				return errors.New("machine has an empty size fallback")
+			}
+
+			if _, ok := sizes[size]; ok {
+				return fmt.Errorf("machine lists size %s more than once",
+					size)
+			}
+			sizes[size] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func (stitch Stitch) validateProtocols() error {
+	for _, c := range stitch.Connections {
+		switch c.Protocol {
+		case "", TCP, UDP:
+		case ICMP:
+			if c.MinPort != 0 || c.MaxPort != 0 {
+				return fmt.Errorf("ICMP connection %s->%s cannot "+
+					"have ports", c.From, c.To)
+			}
+		default:
+			return fmt.Errorf("connection %s->%s has unknown protocol: %s",
+				c.From, c.To, c.Protocol)
+		}
+	}
+	return nil
+}
+
+// validatePorts rejects connections whose port ranges are empty, or fall outside of
+// the valid port numbers.
+func (stitch Stitch) validatePorts() error {
+	for _, c := range stitch.Connections {
+		if c.MinPort < 0 || c.MaxPort > 65535 || c.MinPort > c.MaxPort {
+			return fmt.Errorf("connection %s->%s has an invalid port range: "+
+				"%d-%d", c.From, c.To, c.MinPort, c.MaxPort)
+		}
+
+		if c.Ephemeral && (c.MinPort != EphemeralMinPort ||
+			c.MaxPort != EphemeralMaxPort) {
+			return fmt.Errorf("ephemeral connection %s->%s must be on ports "+
+				"%d-%d, not %d-%d", c.From, c.To, EphemeralMinPort,
+				EphemeralMaxPort, c.MinPort, c.MaxPort)
+		}
+	}
+	return nil
+}
+
+// validatePortRanges rejects connections between the same labels with the same
+// protocol whose port ranges overlap without being equal.  These are almost
+// certainly mistakes, and make it ambiguous how the ranges should be coalesced.
+func (stitch Stitch) validatePortRanges() error {
+	for i, c := range stitch.Connections {
+		for _, other := range stitch.Connections[:i] {
+			if c.From != other.From || c.To != other.To ||
+				c.Protocol != other.Protocol {
+				continue
+			}
+
+			if c.MinPort == other.MinPort && c.MaxPort == other.MaxPort {
+				continue
+			}
+
+			if c.MinPort > other.MaxPort || other.MinPort > c.MaxPort {
+				continue
+			}
+
+			return fmt.Errorf("connections %s->%s have overlapping port "+
+				"ranges: %d-%d and %d-%d", c.From, c.To, other.MinPort,
+				other.MaxPort, c.MinPort, c.MaxPort)
+		}
+	}
+	return nil
+}
+
+// validateBandwidthLimits rejects negative limits, and limits on connections with the
+// public internet, which are shaped by the cloud provider rather than Quilt.
+func (stitch Stitch) validateBandwidthLimits() error {
+	for _, c := range stitch.Connections {
+		if c.BandwidthLimit < 0 {
+			return fmt.Errorf("connection %s->%s has a negative bandwidth "+
+				"limit: %d", c.From, c.To, c.BandwidthLimit)
+		}
+
+		if c.BandwidthLimit > 0 && (c.From == PublicInternetLabel ||
+			c.To == PublicInternetLabel) {
+			return fmt.Errorf("public connection %s->%s cannot have a "+
+				"bandwidth limit", c.From, c.To)
+		}
+	}
+	return nil
+}
+
+// The largest DSCP value, as the field is six bits wide.
+const maxDSCP = 63
+
+// validateDSCP checks that the connections' DSCP values fit in the six bit field, and
+// that only connections between containers are marked.
+func (stitch Stitch) validateDSCP() error {
+	for _, c := range stitch.Connections {
+		if c.DSCP < 0 || c.DSCP > maxDSCP {
+			return fmt.Errorf("connection %s->%s has an invalid DSCP value: "+
+				"%d (must be 0-%d)", c.From, c.To, c.DSCP, maxDSCP)
+		}
+
+		if c.DSCP > 0 && (c.From == PublicInternetLabel ||
+			c.To == PublicInternetLabel) {
+			return fmt.Errorf("public connection %s->%s cannot set DSCP",
+				c.From, c.To)
+		}
+	}
+	return nil
+}
+
+// validateProbes checks that only UDP connections from the public internet are
+// probed, and that their queries are valid hex.
+func (stitch Stitch) validateProbes() error {
+	for _, c := range stitch.Connections {
+		if c.Probe == (Probe{}) {
+			continue
+		}
+
+		if c.From != PublicInternetLabel || c.HostNetwork {
+			return fmt.Errorf("connection %s->%s cannot be probed, as only "+
+				"connections from the public internet to containers can",
+				c.From, c.To)
+		}
+
+		if c.Protocol != UDP {
+			return fmt.Errorf("the probe of connection %s->%s requires the "+
+				"udp protocol", c.From, c.To)
+		}
+
+		if c.MinPort != c.MaxPort {
+			return fmt.Errorf("the probe of connection %s->%s requires a "+
+				"single port", c.From, c.To)
+		}
+
+		query, err := hex.DecodeString(c.Probe.UDPQuery)
+		if err != nil || len(query) == 0 {
+			return fmt.Errorf("connection %s->%s has an invalid UDP probe "+
+				"query: %q", c.From, c.To, c.Probe.UDPQuery)
+		}
+	}
+	return nil
+}
+
+// validateHostConnections checks that host networked connections come from the public
+// internet, and don't also target containers.
+func (stitch Stitch) validateHostConnections() error {
+	for _, c := range stitch.Connections {
+		if !c.HostNetwork {
+			continue
+		}
+
+		switch {
+		case c.From != PublicInternetLabel:
+			return fmt.Errorf("host networked connection %s->%s must come "+
+				"from the public internet", c.From, c.To)
+		case c.To != "":
+			return fmt.Errorf("host networked connection %s->%s cannot "+
+				"also target containers", c.From, c.To)
+		case c.Protocol == ICMP:
+			return errors.New("host networked connections cannot be ICMP")
+		}
+	}
+	return nil
+}
+
+func (stitch Stitch) validateLabelIDs() error {
+	ids := map[int]struct{}{}
+	for _, c := range stitch.Containers {
+		ids[c.ID] = struct{}{}
+	}
+
+	for _, label := range stitch.Labels {
+		for _, id := range label.IDs {
+			if _, ok := ids[id]; !ok {
+				return fmt.Errorf("label %s references undeclared "+
+					"container %d", label.Name, id)
+			}
+		}
+	}
+	return nil
+}
+
+// validateLabelSizes rejects containers in more than one label that exposes its
+// size, as they'd have no way to tell which label QUILT_LABEL_SIZE describes.
+func (stitch Stitch) validateLabelSizes() error {
+	sizeLabel := map[int]string{}
+	for _, label := range stitch.Labels {
+		if !label.ExposesSize() {
+			continue
+		}
+
+		for _, id := range label.IDs {
+			if other, ok := sizeLabel[id]; ok {
+				return fmt.Errorf("container %d is in labels %s "+
+					"and %s, which both expose their size", id,
+					other, label.Name)
+			}
+			sizeLabel[id] = label.Name
+		}
+	}
+	return nil
+}
+
+// validateNoEgress rejects connections to the public internet from labels that are
+// annotated to have no egress, as the workers would drop their traffic anyway.
+func (stitch Stitch) validateNoEgress() error {
+	noEgress := map[string]struct{}{}
+	for _, label := range stitch.Labels {
+		if label.HasAnnotation(NoEgressAnnotation) {
+			noEgress[label.Name] = struct{}{}
+		}
+	}
+
+	for _, c := range stitch.Connections {
+		if _, ok := noEgress[c.From]; ok && c.To == PublicInternetLabel {
+			return fmt.Errorf("connection %s->%s contradicts the %s "+
+				"annotation of %s", c.From, c.To, NoEgressAnnotation,
+				c.From)
+		}
+	}
+	return nil
+}
+
+func (stitch Stitch) validateRoleACLs() error {
+	for _, acl := range []struct {
+		role  string
+		cidrs []string
+	}{{"master", stitch.MasterACL}, {"worker", stitch.WorkerACL}} {
+		for _, cidr := range acl.cidrs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid %s ACL: %s", acl.role, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (stitch Stitch) validateShmSizes() error {
+	for _, c := range stitch.Containers {
+		if c.ShmSize < 0 {
+			return fmt.Errorf("container %d has negative shm size: %d",
+				c.ID, c.ShmSize)
+		}
+	}
+	return nil
+}
+
+func (stitch Stitch) validateFiles() error {
+	for _, c := range stitch.Containers {
+		var size int
+		for path, content := range c.FilepathToContent {
+			if !filepath.IsAbs(path) {
+				return fmt.Errorf("container %d has a relative file "+
+					"path: %s", c.ID, path)
+			}
+			size += len(content)
+		}
+
+		if size > MaxFileContentSize {
+			return fmt.Errorf("container %d has %d bytes of files, "+
+				"more than the limit of %d", c.ID, size,
+				MaxFileContentSize)
+		}
+	}
+	return nil
+}
+
+func (stitch Stitch) validateTmpfs() error {
+	for _, c := range stitch.Containers {
+		paths := map[string]struct{}{}
+		for _, path := range c.Tmpfs {
+			if !filepath.IsAbs(path) {
+				return fmt.Errorf("container %d has a relative tmpfs "+
+					"path: %s", c.ID, path)
+			}
+
+			clean := filepath.Clean(path)
+			if _, ok := paths[clean]; ok {
+				return fmt.Errorf("container %d mounts tmpfs at %s "+
+					"twice", c.ID, clean)
+			}
+			paths[clean] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// validateExposedPorts rejects public connections to ports that containers in the
+// target label don't listen on.  Containers that don't declare their exposed ports
+// aren't checked.
+func (stitch Stitch) validateExposedPorts() error {
+	exposed := map[int]map[int]struct{}{}
+	for _, c := range stitch.Containers {
+		if len(c.ExposedPorts) == 0 {
+			continue
+		}
+
+		exposed[c.ID] = map[int]struct{}{}
+		for _, port := range c.ExposedPorts {
+			if port < 1 || port > 65535 {
+				return fmt.Errorf("container %d exposes invalid port: %d",
+					c.ID, port)
+			}
+			exposed[c.ID][port] = struct{}{}
+		}
+	}
+
+	labelIDs := map[string][]int{}
+	for _, label := range stitch.Labels {
+		labelIDs[label.Name] = label.IDs
+	}
+
+	for _, conn := range stitch.Connections {
+		if conn.From != PublicInternetLabel || conn.Protocol == ICMP {
+			continue
+		}
+
+		for _, id := range labelIDs[conn.To] {
+			ports, ok := exposed[id]
+			if !ok {
+				continue
+			}
+
+			for port := conn.MinPort; port <= conn.MaxPort; port++ {
+				if _, ok := ports[port]; !ok {
+					return fmt.Errorf("container %d is connected to from "+
+						"public on port %d, which it doesn't expose",
+						id, port)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (stitch Stitch) validateTCPKeepalive() error {
+	ka := stitch.TCPKeepalive
+	if ka.Time < 0 || ka.Interval < 0 || ka.Probes < 0 {
+		return fmt.Errorf("TCP keepalive settings must not be negative: %+v", ka)
+	}
+	return nil
+}
+
+// The bounds on NetworkTuning.  Values outside of them are either too small to be
+// useful, or more than the kernel supports.
+const (
+	minConntrackMax  = 1 << 16
+	maxConntrackMax  = 1 << 24
+	minEphemeralPort = 1024
+	maxEphemeralPort = 65535
+	minSomaxconn     = 128
+	maxSomaxconn     = 65535
+)
+
+func (stitch Stitch) validateNetworkTuning() error {
+	nt := stitch.NetworkTuning
+	if nt.ConntrackMax != 0 &&
+		(nt.ConntrackMax < minConntrackMax || nt.ConntrackMax > maxConntrackMax) {
+		return fmt.Errorf("conntrack max must be between %d and %d: %d",
+			minConntrackMax, maxConntrackMax, nt.ConntrackMax)
+	}
+
+	if nt.EphemeralPortMin != 0 || nt.EphemeralPortMax != 0 {
+		if nt.EphemeralPortMin < minEphemeralPort ||
+			nt.EphemeralPortMax > maxEphemeralPort ||
+			nt.EphemeralPortMin >= nt.EphemeralPortMax {
+			return fmt.Errorf("ephemeral port range must be within %d-%d: "+
+				"%d-%d", minEphemeralPort, maxEphemeralPort,
+				nt.EphemeralPortMin, nt.EphemeralPortMax)
+		}
+	}
+
+	if nt.Somaxconn != 0 &&
+		(nt.Somaxconn < minSomaxconn || nt.Somaxconn > maxSomaxconn) {
+		return fmt.Errorf("somaxconn must be between %d and %d: %d",
+			minSomaxconn, maxSomaxconn, nt.Somaxconn)
+	}
+	return nil
+}
+
+func (stitch Stitch) validateNATBackend() error {
+	switch stitch.NATBackend {
+	case "", IPTables, NFTables:
+	default:
+		return fmt.Errorf("unknown NAT backend: %s", stitch.NATBackend)
+	}
+
+	if stitch.NATInterval < 0 {
+		return fmt.Errorf("NAT interval must not be negative: %d",
+			stitch.NATInterval)
+	}
+	return nil
+}
+
+// DefaultMaxNATRules is the MaxNATRules of specs that don't set their own.
+const DefaultMaxNATRules = 10000
+
+// EstimateRuleCount estimates the number of DNAT rules a worker needs to forward the
+// ports connected to from the public internet.  Each port range and protocol
+// forwarded to a container takes a rule, however many ports the range spans.  A
+// label's containers are kept apart if the public internet connects to them, so
+// each such label contributes its ranges at most once.  Connections on the
+// ephemeral ports don't keep containers apart, so their ranges count once for each
+// of the label's containers.  Containers in several of these labels are counted once
+// for each, making the estimate an upper bound.
+func (stitch Stitch) EstimateRuleCount() int {
+	type publicPort struct {
+		minPort, maxPort int
+		protocol         string
+		ephemeral        bool
+	}
+
+	labelPorts := map[string]map[publicPort]struct{}{}
+	for _, c := range stitch.Connections {
+		if c.From != PublicInternetLabel || c.HostNetwork ||
+			c.Protocol == ICMP {
+			continue
+		}
+
+		ports, ok := labelPorts[c.To]
+		if !ok {
+			ports = map[publicPort]struct{}{}
+			labelPorts[c.To] = ports
+		}
+		for _, protocol := range Protocols(c.Protocol) {
+			key := publicPort{c.MinPort, c.MaxPort, protocol, c.Ephemeral}
+			ports[key] = struct{}{}
+		}
+	}
+
+	labelSizes := map[string]int{}
+	for _, label := range stitch.Labels {
+		labelSizes[label.Name] = len(label.IDs)
+	}
+
+	count := 0
+	for label, ports := range labelPorts {
+		for port := range ports {
+			if port.ephemeral {
+				count += labelSizes[label]
+			} else {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// validateNATRuleCount rejects specs whose public connections would need more DNAT
+// rules than a worker allows.  Forwarding many individual ports, rather than the
+// ranges they fall in, is the usual culprit.
+func (stitch Stitch) validateNATRuleCount() error {
+	if stitch.MaxNATRules < 0 {
+		return fmt.Errorf("max NAT rules must not be negative: %d",
+			stitch.MaxNATRules)
+	}
+
+	limit := stitch.MaxNATRules
+	if limit == 0 {
+		limit = DefaultMaxNATRules
+	}
+
+	if count := stitch.EstimateRuleCount(); count > limit {
+		return fmt.Errorf("connections from the public internet need an "+
+			"estimated %d NAT rules per worker, more than the limit of %d; "+
+			"forward port ranges rather than individual ports, or raise "+
+			"maxNATRules", count, limit)
+	}
+	return nil
+}
+
+func (stitch Stitch) validateArchs() error {
+	for _, m := range stitch.Machines {
+		if !validArch(m.Arch) {
+			return fmt.Errorf("machine has unknown architecture: %s", m.Arch)
+		}
+	}
+
+	for _, c := range stitch.Containers {
+		if !validArch(c.Arch) {
+			return fmt.Errorf("container %d has unknown architecture: %s",
+				c.ID, c.Arch)
+		}
+	}
+	return nil
+}
+
+func validArch(arch string) bool {
+	return arch == "" || arch == AMD64 || arch == ARM64
+}
+
+func (stitch Stitch) validateGPUs() error {
+	maxGPUs := 0
+	for _, m := range stitch.Machines {
+		if m.GPUs < 0 {
+			return fmt.Errorf("machine has a negative number of GPUs: %d",
+				m.GPUs)
+		}
+
+		if m.Role == "Worker" && m.GPUs > maxGPUs {
+			maxGPUs = m.GPUs
+		}
+	}
+
+	gpuLabels := map[string]struct{}{}
+	for _, plcm := range stitch.Placements {
+		if plcm.GPU && !plcm.Exclusive {
+			gpuLabels[plcm.TargetLabel] = struct{}{}
+		}
+	}
+
+	onGPUs := map[int]struct{}{}
+	for _, label := range stitch.Labels {
+		if _, ok := gpuLabels[label.Name]; ok {
+			for _, id := range label.IDs {
+				onGPUs[id] = struct{}{}
+			}
+		}
+	}
+
+	for _, c := range stitch.Containers {
+		if c.GPUs < 0 {
+			return fmt.Errorf("container %d requires a negative number of "+
+				"GPUs: %d", c.ID, c.GPUs)
+		}
+
+		if c.GPUs == 0 {
+			continue
+		}
+
+		if _, ok := onGPUs[c.ID]; !ok {
+			return fmt.Errorf("container %d requires GPUs, but isn't placed "+
+				"on machines with GPUs", c.ID)
+		}
+
+		if c.GPUs > maxGPUs {
+			return fmt.Errorf("container %d requires %d GPUs, but no worker "+
+				"has that many", c.ID, c.GPUs)
+		}
+	}
+	return nil
+}
+
+// The signals a container may be stopped with.
+var stopSignals = map[string]struct{}{
+	"SIGABRT": {}, "SIGALRM": {}, "SIGBUS": {}, "SIGCHLD": {}, "SIGCONT": {},
+	"SIGFPE": {}, "SIGHUP": {}, "SIGILL": {}, "SIGINT": {}, "SIGIO": {},
+	"SIGKILL": {}, "SIGPIPE": {}, "SIGPROF": {}, "SIGPWR": {}, "SIGQUIT": {},
+	"SIGSEGV": {}, "SIGSTKFLT": {}, "SIGSTOP": {}, "SIGSYS": {}, "SIGTERM": {},
+	"SIGTRAP": {}, "SIGTSTP": {}, "SIGTTIN": {}, "SIGTTOU": {}, "SIGURG": {},
+	"SIGUSR1": {}, "SIGUSR2": {}, "SIGVTALRM": {}, "SIGWINCH": {}, "SIGXCPU": {},
+	"SIGXFSZ": {},
+}
+
+func (stitch Stitch) validateStopSignals() error {
+	for _, c := range stitch.Containers {
+		if c.StopSignal == "" {
+			continue
+		}
+
+		if _, ok := stopSignals[c.StopSignal]; !ok {
+			return fmt.Errorf("container %d has unknown stop signal: %s",
+				c.ID, c.StopSignal)
+		}
+	}
+	return nil
+}
+
+func (stitch Stitch) validateNetworkModes() error {
+	for _, c := range stitch.Containers {
+		switch c.NetworkMode {
+		case "", NetworkModeOverlay, NetworkModeHost, NetworkModeNone:
+		default:
+			return fmt.Errorf("container %d has unknown network mode: %s",
+				c.ID, c.NetworkMode)
+		}
+	}
+	return nil
+}
+
+// validateSidecars checks that each sidecar's primary exists and isn't a sidecar
+// itself.  Sidecars therefore can't form cycles.
+func (stitch Stitch) validateSidecars() error {
+	sidecarOf := map[int]int{}
+	for _, c := range stitch.Containers {
+		sidecarOf[c.ID] = c.SidecarOf
+	}
+
+	for _, c := range stitch.Containers {
+		if c.SidecarOf == 0 {
+			continue
+		}
+
+		primarySidecarOf, ok := sidecarOf[c.SidecarOf]
+		switch {
+		case c.SidecarOf == c.ID:
+			return fmt.Errorf("container %d is a sidecar of itself", c.ID)
+		case !ok:
+			return fmt.Errorf("container %d is a sidecar of nonexistent "+
+				"container %d", c.ID, c.SidecarOf)
+		case primarySidecarOf != 0:
+			return fmt.Errorf("container %d is a sidecar of container %d, "+
+				"which is a sidecar itself", c.ID, c.SidecarOf)
+		}
+	}
+	return nil
+}
+
+func (stitch Stitch) validateCPUSets() error {
+	for _, c := range stitch.Containers {
+		if c.CPUSet != "" && !validCPUSet(c.CPUSet) {
+			return fmt.Errorf("container %d has malformed cpuset: %s",
+				c.ID, c.CPUSet)
+		}
+	}
+	return nil
+}
+
+// validCPUSet returns whether `cpus` is a comma separated list of CPU numbers and
+// ascending ranges of them, in the format of the Linux cpuset.cpus file.
+func validCPUSet(cpus string) bool {
+	for _, item := range strings.Split(cpus, ",") {
+		bounds := strings.Split(item, "-")
+		if len(bounds) > 2 {
+			return false
+		}
+
+		var nums []int
+		for _, bound := range bounds {
+			// Atoi alone would accept signs.
+			if bound == "" || strings.Trim(bound, "0123456789") != "" {
+				return false
+			}
+
+			num, err := strconv.Atoi(bound)
+			if err != nil {
+				return false
+			}
+			nums = append(nums, num)
+		}
+
+		if len(nums) == 2 && nums[0] > nums[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkNoLatestTag returns an error listing every image that's tagged `latest`,
+// either explicitly or implicitly by having no tag.  Images pinned to a digest
+// pass.
+func (stitch Stitch) checkNoLatestTag() error {
+	var images []string
+	seen := map[string]struct{}{}
+	for _, c := range stitch.Containers {
+		if _, ok := seen[c.Image]; ok || !usesLatestTag(c.Image) {
+			continue
+		}
+		seen[c.Image] = struct{}{}
+		images = append(images, c.Image)
+	}
+
+	if len(images) > 0 {
+		return fmt.Errorf("images must be pinned to a tag other than latest: %s",
+			strings.Join(images, ", "))
+	}
+	return nil
+}
+
+func usesLatestTag(image string) bool {
+	if strings.Contains(image, "@") {
+		return false
+	}
+
+	// The registry's address may contain a port, so only the last component of the
+	// name can hold a tag.
+	name := image[strings.LastIndex(image, "/")+1:]
+	colon := strings.LastIndex(name, ":")
+	return colon < 0 || name[colon+1:] == "latest"
+}
+
+// checkRegions returns an error listing every machine whose region isn't among those
+// `regions` allows for its provider.
+func (stitch Stitch) checkRegions(regions map[string][]string) error {
+	var invalid []string
+	for _, m := range stitch.Machines {
+		valid, ok := regions[m.Provider]
+		if !ok || m.Region == "" {
+			continue
+		}
+
+		found := false
+		for _, region := range valid {
+			if region == m.Region {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			invalid = append(invalid, fmt.Sprintf("%s (%s)", m.Region,
+				m.Provider))
+		}
+	}
+
+	if len(invalid) > 0 {
+		return fmt.Errorf("machines have invalid regions: %s",
+			strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// The provider of machines that Quilt adopts rather than boots.
+const staticProvider = "Static"
+
+func (stitch Stitch) validateStaticMachines() error {
+	publicIPs := map[string]struct{}{}
+	for _, m := range stitch.Machines {
+		if m.Provider != staticProvider {
+			if m.PublicIP != "" || m.PrivateIP != "" || m.SSHKeyPath != "" {
+				return fmt.Errorf("only static machines may specify "+
+					"addresses and SSH keys: %s", m.Provider)
+			}
+			continue
+		}
+
+		if net.ParseIP(m.PublicIP) == nil || net.ParseIP(m.PrivateIP) == nil {
+			return fmt.Errorf("static machine has invalid IPs: public %q, "+
+				"private %q", m.PublicIP, m.PrivateIP)
+		}
+
+		if m.SSHKeyPath == "" {
+			return fmt.Errorf("static machine %s has no SSH key", m.PublicIP)
+		}
+
+		if _, ok := publicIPs[m.PublicIP]; ok {
+			return fmt.Errorf("static machine %s is declared twice",
+				m.PublicIP)
+		}
+		publicIPs[m.PublicIP] = struct{}{}
+	}
+	return nil
+}
+
+// validateAutoscale checks that the autoscaled workers can be booted on demand.  Each
+// is a copy of the template, so they can't share a static machine or floating IP.
+func (stitch Stitch) validateAutoscale() error {
+	as := stitch.AutoscaleWorkers
+	if as.Min == 0 && as.Max == 0 {
+		return nil
+	}
+
+	if as.Min < 0 || as.Min > as.Max {
+		return fmt.Errorf("autoscaled workers must satisfy 0 <= min <= max: "+
+			"min %d, max %d", as.Min, as.Max)
+	}
+
+	tmpl := as.Template
+	switch {
+	case tmpl.Role != "Worker":
+		return fmt.Errorf("autoscale template must be a Worker, not %q",
+			tmpl.Role)
+	case tmpl.Provider == "" || tmpl.Provider == staticProvider:
+		return fmt.Errorf("autoscale template must be bootable by a provider, "+
+			"not %q", tmpl.Provider)
+	case tmpl.FloatingIP != "":
+		return errors.New("autoscale template may not have a floating IP")
+	}
+	return nil
+}
+
+// validateFloatingIPs checks that there are enough workers with floating IPs for the
+// containers that must run on them.  Containers listening on the same public port
+// and protocol can't share a machine, so each port needs a floating IP worker for
+// every such container that listens on it.
+func (stitch Stitch) validateFloatingIPs() error {
+	floatingLabels := map[string]struct{}{}
+	for _, plcm := range stitch.Placements {
+		if plcm.FloatingIP && !plcm.Exclusive {
+			floatingLabels[plcm.TargetLabel] = struct{}{}
+		}
+	}
+
+	if len(floatingLabels) == 0 {
+		return nil
+	}
+
+	var workers int
+	for _, m := range stitch.Machines {
+		if m.Role == "Worker" && m.FloatingIP != "" {
+			workers++
+		}
+	}
+
+	labelIDs := map[string][]int{}
+	for _, label := range stitch.Labels {
+		labelIDs[label.Name] = label.IDs
+	}
+
+	type publicPort struct {
+		protocol string
+		port     int
+	}
+
+	var ports []publicPort
+	portIDs := map[publicPort]map[int]struct{}{}
+	for _, c := range stitch.Connections {
+		if c.From != PublicInternetLabel && c.To != PublicInternetLabel ||
+			c.Protocol == ICMP || c.Ephemeral {
+			continue
+		}
+
+		target := c.From
+		if c.From == PublicInternetLabel {
+			target = c.To
+		}
+
+		if _, ok := floatingLabels[target]; !ok {
+			continue
+		}
+
+		for _, protocol := range Protocols(c.Protocol) {
+			key := publicPort{protocol, c.MinPort}
+			if _, ok := portIDs[key]; !ok {
+				ports = append(ports, key)
+				portIDs[key] = map[int]struct{}{}
+			}
+
+			for _, id := range labelIDs[target] {
+				portIDs[key][id] = struct{}{}
+			}
+		}
+	}
+
+	for _, key := range ports {
+		if need := len(portIDs[key]); need > workers {
+			return fmt.Errorf("%d containers on floating IP workers listen "+
+				"on public %s port %d, but there are only %d such "+
+				"workers", need, key.protocol, key.port, workers)
+		}
+	}
+	return nil
+}
+
+func (stitch Stitch) validateHostnames() error {
+	publishers := map[string]string{}
+	for _, label := range stitch.Labels {
+		for _, hostname := range label.Hostnames {
+			if hostname.Name == "" {
+				return fmt.Errorf("%s publishes an empty hostname", label.Name)
+			}
+
+			other, ok := publishers[hostname.Name]
+			if ok && other != label.Name {
+				return fmt.Errorf("hostname %s is published by both %s "+
+					"and %s", hostname.Name, other, label.Name)
+			}
+			publishers[hostname.Name] = label.Name
+		}
+	}
+	return nil
+}
+
+// validateLoadBalancers checks that load balancers target deployed labels with valid
+// ports.  A label whose containers are also placed on floating IP machines would have
+// two public entry points, so that's rejected as well.
+func (stitch Stitch) validateLoadBalancers() error {
+	labels := map[string]struct{}{}
+	for _, label := range stitch.Labels {
+		labels[label.Name] = struct{}{}
+	}
+
+	floatingLabels := map[string]struct{}{}
+	for _, plcm := range stitch.Placements {
+		if plcm.FloatingIP && !plcm.Exclusive {
+			floatingLabels[plcm.TargetLabel] = struct{}{}
+		}
+	}
+	for _, m := range stitch.Machines {
+		if m.FloatingIP != "" && m.DedicatedTo != "" {
+			floatingLabels[m.DedicatedTo] = struct{}{}
+		}
+	}
+
+	for _, lb := range stitch.LoadBalancers {
+		if _, ok := labels[lb.TargetLabel]; !ok {
+			return fmt.Errorf("load balancer targets undeployed label: %s",
+				lb.TargetLabel)
+		}
+
+		for _, port := range []int{lb.ListenerPort, lb.BackendPort} {
+			if port < 1 || port > 65535 {
+				return fmt.Errorf("load balancer for %s has an invalid "+
+					"port: %d", lb.TargetLabel, port)
+			}
+		}
+
+		if lb.HealthPath != "" && !strings.HasPrefix(lb.HealthPath, "/") {
+			return fmt.Errorf("load balancer for %s has a malformed health "+
+				"path: %s", lb.TargetLabel, lb.HealthPath)
+		}
+
+		if _, ok := floatingLabels[lb.TargetLabel]; ok {
+			return fmt.Errorf("%s is behind a load balancer, but is also "+
+				"placed on floating IP machines, so its public entry "+
+				"point is ambiguous", lb.TargetLabel)
+		}
+	}
+	return nil
+}
+
+func (stitch Stitch) validateInvariants() error {
+	for _, inv := range stitch.Invariants {
+		if err := inv.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ephemeralWarnings returns a warning for each connection that exposes the ephemeral
+// ports to the public internet.  Any service on the workers that's assigned one of
+// those ports becomes reachable, not just the intended one.
+func (stitch Stitch) ephemeralWarnings() []string {
+	var warnings []string
+	for _, c := range stitch.Connections {
+		if c.From != PublicInternetLabel && c.To != PublicInternetLabel {
+			continue
+		}
+
+		if c.Ephemeral || c.MinPort <= EphemeralMaxPort &&
+			c.MaxPort >= EphemeralMinPort && c.MinPort != c.MaxPort {
+			to := c.To
+			if c.HostNetwork {
+				to = "the workers"
+			}
+			warnings = append(warnings, fmt.Sprintf("public connection "+
+				"%s->%s on ports %d-%d spans the ephemeral ports, which "+
+				"exposes whatever is assigned them", c.From, to,
+				c.MinPort, c.MaxPort))
+		}
+	}
+	return warnings
+}
+
+// dedicationWarnings returns a warning for each label that machines are dedicated to,
+// but that has no containers.  Such machines would sit idle.
+func (stitch Stitch) dedicationWarnings() []string {
+	sizes := map[string]int{}
+	for _, label := range stitch.Labels {
+		sizes[label.Name] = len(label.IDs)
+	}
+
+	var warnings []string
+	warned := map[string]struct{}{}
+	for _, m := range stitch.Machines {
+		if m.DedicatedTo == "" {
+			continue
+		}
+
+		if _, ok := warned[m.DedicatedTo]; ok || sizes[m.DedicatedTo] > 0 {
+			continue
+		}
+		warned[m.DedicatedTo] = struct{}{}
+		warnings = append(warnings, fmt.Sprintf("machines are dedicated to "+
+			"label %s, which has no containers", m.DedicatedTo))
+	}
+	return warnings
+}
@@ -0,0 +1,1154 @@
+package stitch
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpotPrice(t *testing.T) {
+	t.Parallel()
+
+	checkMachines(t, `createDeployment({maxPrice: 1});
+	deployment.deploy(new Machine({
+		provider: "Amazon",
+		role: "Worker",
+		spotPrice: 0.25
+	}));`,
+		[]Machine{
+			{
+				Role:          "Worker",
+				Provider:      "Amazon",
+				SpotPrice:     0.25,
+				SSHKeys:       []string{},
+				SizeFallbacks: []string{},
+			},
+		})
+
+	checkError(t, `createDeployment({maxPrice: 1});
+	deployment.deploy(new Machine({spotPrice: 1.5}));`,
+		"spot price 1.5 exceeds the max price 1")
+	checkError(t, `deployment.deploy(new Machine({spotPrice: -1}));`,
+		"spot price must not be negative: -1")
+
+	spec := Stitch{Machines: []Machine{{SpotPrice: 0.5}}}
+	actual, err := FromJSON(spec.String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if actual.Machines[0].SpotPrice != 0.5 {
+		t.Errorf("Spot price didn't round trip: %v", actual.Machines[0])
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	conn := Connection{From: "public", To: "dns", MinPort: 53, MaxPort: 53}
+	for _, protocol := range []string{"", TCP, UDP} {
+		conn.Protocol = protocol
+		stc := Stitch{Connections: []Connection{conn}}
+		if err := stc.Validate(); err != nil {
+			t.Errorf("unexpected error for protocol %q: %s", protocol, err)
+		}
+	}
+
+	conn.Protocol = "sctp"
+	stc := Stitch{Connections: []Connection{conn}}
+	exp := "connection public->dns has unknown protocol: sctp"
+	if err := stc.Validate(); err == nil || err.Error() != exp {
+		t.Errorf("expected error %q, got %v", exp, err)
+	}
+
+	conn.Protocol = ICMP
+	stc = Stitch{Connections: []Connection{conn}}
+	exp = "ICMP connection public->dns cannot have ports"
+	if err := stc.Validate(); err == nil || err.Error() != exp {
+		t.Errorf("expected error %q, got %v", exp, err)
+	}
+
+	conn.MinPort, conn.MaxPort = 0, 0
+	stc = Stitch{Connections: []Connection{conn}}
+	if err := stc.Validate(); err != nil {
+		t.Errorf("unexpected error for ICMP connection: %s", err)
+	}
+}
+
+func TestBandwidthLimits(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`var web = new Service("web", []);
+	var db = new Service("db", []);
+	web.connect(5432, db);
+	web.limitBandwidth(db, 1000000);
+	deployment.deploy([web, db]);`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, []Connection{{From: "web", To: "db", MinPort: 5432,
+		MaxPort: 5432, BandwidthLimit: 1000000}}, spec.Connections)
+
+	checkError(t, `var web = new Service("web", []);
+	web.limitBandwidth(new Service("db", []), 1000);`,
+		"web has no connections to db to limit")
+	checkError(t, `var web = new Service("web", []);
+	var db = new Service("db", []);
+	web.connect(5432, db);
+	web.limitBandwidth(db, -1);
+	deployment.deploy([web, db]);`,
+		"connection web->db has a negative bandwidth limit: -1")
+
+	stc := Stitch{Connections: []Connection{{From: "web", To: PublicInternetLabel,
+		MinPort: 80, MaxPort: 80, BandwidthLimit: 1000}}}
+	exp := "public connection web->public cannot have a bandwidth limit"
+	if err := stc.Validate(); err == nil || err.Error() != exp {
+		t.Errorf("expected error %q, got %v", exp, err)
+	}
+}
+
+func TestDSCP(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`var api = new Service("api", []);
+	var cache = new Service("cache", []);
+	api.connect(6379, cache, {protocol: "tcp", dscp: 46});
+	api.connect([80, 443], cache, {dscp: 10});
+	cache.connect(22, api, "tcp");
+	deployment.deploy([api, cache]);`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, []Connection{
+		{From: "api", To: "cache", MinPort: 6379, MaxPort: 6379,
+			Protocol: "tcp", DSCP: 46},
+		{From: "api", To: "cache", MinPort: 80, MaxPort: 80, DSCP: 10},
+		{From: "api", To: "cache", MinPort: 443, MaxPort: 443, DSCP: 10},
+		{From: "cache", To: "api", MinPort: 22, MaxPort: 22, Protocol: "tcp"},
+	}, spec.Connections)
+
+	checkError(t, `var api = new Service("api", []);
+	var cache = new Service("cache", []);
+	api.connect(6379, cache, {dscp: 64});
+	deployment.deploy([api, cache]);`,
+		"connection api->cache has an invalid DSCP value: 64 (must be 0-63)")
+	checkError(t, `var api = new Service("api", []);
+	api.connect(443, publicInternet, {dscp: 46});`,
+		"connections to the public internet cannot set DSCP")
+
+	stc := Stitch{Connections: []Connection{{From: "api", To: "cache",
+		MinPort: 80, MaxPort: 80, DSCP: -1}}}
+	assert.EqualError(t, stc.Validate(),
+		"connection api->cache has an invalid DSCP value: -1 (must be 0-63)")
+
+	stc = Stitch{Connections: []Connection{{From: PublicInternetLabel,
+		To: "api", MinPort: 80, MaxPort: 80, DSCP: 46}}}
+	assert.EqualError(t, stc.Validate(),
+		"public connection public->api cannot set DSCP")
+}
+
+func TestHostConnections(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`deployment.openHostPort(8080);
+	deployment.openHostPort(new PortRange(9000, 9010), "udp");`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, []Connection{
+		{From: PublicInternetLabel, MinPort: 8080, MaxPort: 8080,
+			HostNetwork: true},
+		{From: PublicInternetLabel, MinPort: 9000, MaxPort: 9010,
+			Protocol: UDP, HostNetwork: true},
+	}, spec.Connections)
+	assert.Empty(t, spec.Placements)
+
+	checkError(t, `deployment.openHostPort(new Icmp());`,
+		"host ports cannot be ICMP")
+
+	stc := Stitch{Connections: []Connection{{From: PublicInternetLabel,
+		To: "web", MinPort: 80, MaxPort: 80, HostNetwork: true}}}
+	exp := "host networked connection public->web cannot also target containers"
+	if err := stc.Validate(); err == nil || err.Error() != exp {
+		t.Errorf("expected error %q, got %v", exp, err)
+	}
+
+	stc = Stitch{Connections: []Connection{{From: "web", MinPort: 80,
+		MaxPort: 80, HostNetwork: true}}}
+	exp = "host networked connection web-> must come from the public internet"
+	if err := stc.Validate(); err == nil || err.Error() != exp {
+		t.Errorf("expected error %q, got %v", exp, err)
+	}
+}
+
+func TestLabelIDs(t *testing.T) {
+	stc := Stitch{
+		Containers: []Container{{ID: 1}, {ID: 2}},
+		Labels: []Label{
+			{Name: "web", IDs: []int{1, 2}},
+			{Name: "db", IDs: []int{2, 3}},
+		},
+	}
+
+	exp := "label db references undeclared container 3"
+	if err := stc.Validate(); err == nil || err.Error() != exp {
+		t.Errorf("expected error %q, got %v", exp, err)
+	}
+
+	stc.Labels[1].IDs = []int{2}
+	if err := stc.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestLabelSizes(t *testing.T) {
+	stc := Stitch{
+		Containers: []Container{{ID: 1}, {ID: 2}},
+		Labels: []Label{
+			{Name: "web", IDs: []int{1, 2},
+				Annotations: []string{LabelSizeAnnotation}},
+			{Name: "db", IDs: []int{2},
+				Annotations: []string{LabelSizeRestartAnnotation}},
+		},
+	}
+
+	exp := "container 2 is in labels web and db, which both expose their size"
+	if err := stc.Validate(); err == nil || err.Error() != exp {
+		t.Errorf("expected error %q, got %v", exp, err)
+	}
+
+	stc.Labels[1].Annotations = nil
+	if err := stc.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestNoEgress(t *testing.T) {
+	t.Parallel()
+
+	checkError(t, `var web = new Service("web", [new Container("image")]);
+	web.annotate("network.noEgress");
+	web.connect(443, publicInternet);
+	deployment.deploy(web);`,
+		"connection web->public contradicts the network.noEgress "+
+			"annotation of web")
+
+	// Connections from the public internet are unaffected.
+	_, err := FromJavascript(`var web = new Service("web",
+		[new Container("image")]);
+	web.annotate("network.noEgress");
+	publicInternet.connect(80, web);
+	deployment.deploy(web);`, DefaultImportGetter)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestRoleACLs(t *testing.T) {
+	t.Parallel()
+
+	checkError(t, `createDeployment({masterACL: ["local"]});`,
+		"invalid master ACL: invalid CIDR address: local")
+	checkError(t, `createDeployment({workerACL: ["1.2.3.4"]});`,
+		"invalid worker ACL: invalid CIDR address: 1.2.3.4")
+
+	spec := Stitch{
+		AdminACL:  []string{"local"},
+		MasterACL: []string{"1.2.3.4/32"},
+		WorkerACL: []string{"10.0.0.0/8"},
+	}
+	actual, err := FromJSON(spec.String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(spec, actual) {
+		t.Errorf("Role ACLs didn't round trip: %v", actual)
+	}
+}
+
+func TestShmSize(t *testing.T) {
+	t.Parallel()
+
+	checkContainers(t, `deployment.deploy(new Service("foo", [
+	new Container("image").withShmSize(1024)
+	]));`,
+		map[int]Container{
+			1: {
+				ID:      1,
+				Image:   "image",
+				Command: []string{},
+				Env:     map[string]string{},
+				ShmSize: 1024,
+
+				NetworkMode: "overlay",
+
+				FilepathToContent: map[string]string{},
+				Tmpfs:             []string{},
+				ExposedPorts:      []int{},
+			},
+		})
+
+	checkError(t, `deployment.deploy(new Service("foo", [
+	new Container("image").withShmSize(-1)
+	]));`, "container 1 has negative shm size: -1")
+
+	checkContainers(t, `deployment.deploy(new Service("foo", [
+	new Container("image").withShmSize("64MiB")
+	]));`,
+		map[int]Container{
+			1: {
+				ID:      1,
+				Image:   "image",
+				Command: []string{},
+				Env:     map[string]string{},
+				ShmSize: 64 << 20,
+
+				NetworkMode: "overlay",
+
+				FilepathToContent: map[string]string{},
+				Tmpfs:             []string{},
+				ExposedPorts:      []int{},
+			},
+		})
+
+	checkError(t, `new Container("image").withShmSize("64mb")`,
+		`invalid size "64mb": sizes must be a number followed by one of B, KB, `+
+			`MB, GB, TB, KiB, MiB, GiB, or TiB`)
+
+	spec := Stitch{Containers: []Container{{ID: 1, ShmSize: 1 << 30}}}
+	actual, err := FromJSON(spec.String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(spec, actual) {
+		t.Errorf("Shm size didn't round trip: %v", actual)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	t.Parallel()
+
+	checkError(t, `deployment.deploy(new Service("foo", [
+	new Container("image").withFiles({"etc/foo.conf": "foo"})
+	]));`, "container 1 has a relative file path: etc/foo.conf")
+
+	big := strings.Repeat("a", MaxFileContentSize/2+1)
+	stc := Stitch{Containers: []Container{{
+		ID: 1,
+		FilepathToContent: map[string]string{
+			"/a": big,
+			"/b": big,
+		},
+	}}}
+	exp := fmt.Sprintf("container 1 has %d bytes of files, more than the "+
+		"limit of %d", 2*len(big), MaxFileContentSize)
+	if err := stc.Validate(); err == nil || err.Error() != exp {
+		t.Errorf("expected error %q, got %v", exp, err)
+	}
+
+	spec := Stitch{Containers: []Container{{
+		ID:                1,
+		FilepathToContent: map[string]string{"/etc/foo.conf": "foo"},
+	}}}
+	actual, err := FromJSON(spec.String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(spec, actual) {
+		t.Errorf("Files didn't round trip: %v", actual)
+	}
+}
+
+func TestTmpfs(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`deployment.deploy(new Service("foo",
+		[new Container("image").withTmpfs(["/tmp", "/var/scratch"])]));`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/tmp", "/var/scratch"}, spec.Containers[0].Tmpfs)
+
+	actual, err := FromJSON(spec.String())
+	assert.NoError(t, err)
+	assert.Equal(t, spec.Containers, actual.Containers)
+
+	checkError(t, `deployment.deploy(new Service("foo",
+		[new Container("image").withTmpfs(["tmp"])]));`,
+		"container 1 has a relative tmpfs path: tmp")
+	checkError(t, `deployment.deploy(new Service("foo",
+		[new Container("image").withTmpfs(["/tmp", "/tmp/"])]));`,
+		"container 1 mounts tmpfs at /tmp twice")
+}
+
+func TestExposedPorts(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`var web = new Service("web",
+		[new Container("nginx").withExposedPorts([80, 443]),
+		 new Container("nginx")]);
+	publicInternet.connect(new PortRange(80, 80), web);
+	publicInternet.connect(443, web);
+	publicInternet.connect(8000, new Service("api", [new Container("api")]));
+	deployment.deploy(web);`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{80, 443}, spec.Containers[0].ExposedPorts)
+
+	checkError(t, `var web = new Service("web",
+		[new Container("nginx").withExposedPorts([80])]);
+	publicInternet.connect(8080, web);
+	deployment.deploy(web);`,
+		"container 1 is connected to from public on port 8080, which it "+
+			"doesn't expose")
+	checkError(t, `deployment.deploy(new Service("web",
+		[new Container("nginx").withExposedPorts([0])]));`,
+		"container 1 exposes invalid port: 0")
+}
+
+func TestNoLatestTag(t *testing.T) {
+	t.Parallel()
+
+	check := func(images []string) error {
+		var containers []string
+		for _, image := range images {
+			containers = append(containers,
+				fmt.Sprintf("new Container(%q)", image))
+		}
+
+		code := fmt.Sprintf(`deployment.deploy(new Service("foo", [%s]));`,
+			strings.Join(containers, ", "))
+		_, err := New("<test_code>", code, ImportGetter{Path: "."},
+			WithNoLatestTag())
+		return err
+	}
+
+	assert.EqualError(t, check([]string{"nginx:latest"}),
+		"images must be pinned to a tag other than latest: nginx:latest")
+	assert.EqualError(t, check([]string{"nginx"}),
+		"images must be pinned to a tag other than latest: nginx")
+	assert.EqualError(t, check([]string{"localhost:5000/nginx"}),
+		"images must be pinned to a tag other than latest: "+
+			"localhost:5000/nginx")
+
+	// Every offending image is listed once.
+	assert.EqualError(t, check([]string{"nginx", "redis:3.2", "etcd:latest",
+		"nginx"}), "images must be pinned to a tag other than latest: "+
+		"nginx, etcd:latest")
+
+	assert.NoError(t, check([]string{"nginx:1.11"}))
+	assert.NoError(t, check([]string{"localhost:5000/nginx:1.11"}))
+	assert.NoError(t, check([]string{"nginx@sha256:" + strings.Repeat("0", 64)}))
+
+	// The check is opt in.
+	_, err := FromJavascript(`deployment.deploy(new Service("foo", [
+		new Container("nginx")]));`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+}
+
+func TestRegions(t *testing.T) {
+	t.Parallel()
+
+	regions := map[string][]string{
+		"Amazon": {"us-east-1", "us-west-2"},
+		"Google": {"us-east1-b"},
+	}
+
+	check := func(machines string) error {
+		_, err := New("<test_code>", fmt.Sprintf("deployment.deploy([%s]);",
+			machines), ImportGetter{Path: "."}, WithRegions(regions))
+		return err
+	}
+
+	assert.NoError(t, check(`new Machine({provider: "Amazon", region: "us-west-2"}),
+		new Machine({provider: "Google", region: "us-east1-b"}),
+		new Machine({provider: "Google"}),
+		new Machine({provider: "Vagrant", region: "anywhere"})`))
+
+	assert.EqualError(t, check(
+		`new Machine({provider: "Google", region: "us-east-1"}),
+		new Machine({provider: "Amazon", region: "us-east-1"}),
+		new Machine({provider: "Amazon", region: "us-east1-b"})`),
+		"machines have invalid regions: us-east-1 (Google), us-east1-b (Amazon)")
+}
+
+func TestTCPKeepalive(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`createDeployment({
+		tcpKeepalive: {time: 60, interval: 10, probes: 5}});`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, TCPKeepalive{Time: 60, Interval: 10, Probes: 5},
+		spec.TCPKeepalive)
+
+	spec, err = FromJavascript(`createDeployment({});`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, TCPKeepalive{}, spec.TCPKeepalive)
+
+	checkError(t, `createDeployment({tcpKeepalive: {time: -1}});`,
+		"TCP keepalive settings must not be negative: "+
+			"{Time:-1 Interval:0 Probes:0}")
+}
+
+func TestNetworkTuning(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`createDeployment({networkTuning: {
+		conntrackMax: 262144, ephemeralPortMin: 10000,
+		ephemeralPortMax: 65000, somaxconn: 4096}});`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, NetworkTuning{ConntrackMax: 262144, EphemeralPortMin: 10000,
+		EphemeralPortMax: 65000, Somaxconn: 4096}, spec.NetworkTuning)
+
+	checkError(t, `createDeployment({networkTuning: {conntrackMax: 1000}});`,
+		"conntrack max must be between 65536 and 16777216: 1000")
+	checkError(t, `createDeployment({networkTuning: {ephemeralPortMin: 10000}});`,
+		"ephemeral port range must be within 1024-65535: 10000-0")
+	checkError(t, `createDeployment({networkTuning: {ephemeralPortMin: 80,
+		ephemeralPortMax: 65000}});`,
+		"ephemeral port range must be within 1024-65535: 80-65000")
+	checkError(t, `createDeployment({networkTuning: {somaxconn: 1 << 20}});`,
+		"somaxconn must be between 128 and 65535: 1048576")
+}
+
+func TestArch(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`deployment.deploy([
+		new Machine({provider: "Amazon", role: "Worker", arch: "arm64"}),
+		new Service("foo", [new Container("image").withArch("arm64")])]);`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, ARM64, spec.Machines[0].Arch)
+	assert.Equal(t, ARM64, spec.Containers[0].Arch)
+
+	// The architecture is carried over to clones.
+	spec, err = FromJavascript(`var c = new Container("image").withArch("amd64");
+		deployment.deploy(new Service("foo", [c.clone()]));`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, AMD64, spec.Containers[0].Arch)
+
+	checkError(t, `deployment.deploy(new Machine({arch: "sparc"}));`,
+		"machine has unknown architecture: sparc")
+	checkError(t, `deployment.deploy(new Service("foo",
+		[new Container("image").withArch("sparc")]));`,
+		"container 1 has unknown architecture: sparc")
+}
+
+func TestFloatingIPs(t *testing.T) {
+	t.Parallel()
+
+	check := func(replicas int) error {
+		_, err := FromJavascript(fmt.Sprintf(`
+		var web = new Service("web", new Container("nginx").replicate(%d));
+		web.connectFromPublic(80);
+		web.place(new MachineRule(false, {floatingIP: true}));
+		deployment.deploy([web,
+			new Machine({role: "Worker", floatingIP: "8.8.8.8"}),
+			new Machine({role: "Worker", floatingIP: "8.8.4.4"}),
+			new Machine({role: "Worker"})]);`, replicas),
+			ImportGetter{Path: "."})
+		return err
+	}
+
+	assert.NoError(t, check(2))
+	assert.EqualError(t, check(3), "3 containers on floating IP workers "+
+		"listen on public tcp port 80, but there are only 2 such workers")
+
+	spec, err := FromJavascript(`var web = new Service("web", []);
+		web.place(new MachineRule(false, {floatingIP: true}));
+		deployment.deploy([web,
+			new Machine({role: "Worker", floatingIP: "8.8.8.8"})]);`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, "8.8.8.8", spec.Machines[0].FloatingIP)
+	assert.Equal(t, []Placement{{TargetLabel: "web", FloatingIP: true}},
+		spec.Placements)
+}
+
+func TestNATBackend(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`createDeployment({natBackend: "nftables"});`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, NFTables, spec.NATBackend)
+
+	checkError(t, `createDeployment({natBackend: "pf"});`,
+		"unknown NAT backend: pf")
+
+	spec, err = FromJavascript(`createDeployment({natInterval: 5});`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, spec.NATInterval)
+
+	checkError(t, `createDeployment({natInterval: -1});`,
+		"NAT interval must not be negative: -1")
+
+	spec, err = FromJavascript(`createDeployment({publicInterface: "ens5"});`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, "ens5", spec.PublicInterface)
+}
+
+func TestNATRuleCount(t *testing.T) {
+	t.Parallel()
+
+	stc := Stitch{
+		Containers: []Container{{ID: 1}, {ID: 2}},
+		Labels: []Label{
+			{Name: "web", IDs: []int{1}},
+			{Name: "dns", IDs: []int{2}},
+		},
+		Connections: []Connection{
+			{From: PublicInternetLabel, To: "web", MinPort: 1000,
+				MaxPort: 5999, Protocol: TCP},
+			{From: PublicInternetLabel, To: "dns", MinPort: 5000,
+				MaxPort: 7500},
+			{From: PublicInternetLabel, To: "dns", Protocol: ICMP},
+		},
+	}
+	// Each range takes a single rule for each protocol, however many ports it
+	// spans.
+	assert.Equal(t, 3, stc.EstimateRuleCount())
+	assert.NoError(t, stc.Validate())
+
+	stc.MaxNATRules = 2
+	assert.EqualError(t, stc.Validate(), "connections from the public internet "+
+		"need an estimated 3 NAT rules per worker, more than the limit "+
+		"of 2; forward port ranges rather than individual ports, or raise "+
+		"maxNATRules")
+
+	checkError(t, `createDeployment({maxNATRules: 3});
+	var web = new Service("web", [new Container("image")]);
+	publicInternet.connect([80, 443], web);
+	deployment.deploy(web);`, "connections from the public internet need an "+
+		"estimated 4 NAT rules per worker, more than the limit of 3; forward "+
+		"port ranges rather than individual ports, or raise maxNATRules")
+
+	checkError(t, `createDeployment({maxNATRules: -1});`,
+		"max NAT rules must not be negative: -1")
+}
+
+func TestStaticMachines(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`deployment.deploy(Machine.static({
+		role: "Worker", publicIP: "8.8.8.8", privateIP: "10.0.0.2",
+		sshKeyPath: "~/.ssh/id_rsa"}));`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, []Machine{{
+		Provider:      "Static",
+		Role:          "Worker",
+		SSHKeys:       []string{},
+		SizeFallbacks: []string{},
+		PublicIP:      "8.8.8.8",
+		PrivateIP:     "10.0.0.2",
+		SSHKeyPath:    "~/.ssh/id_rsa",
+	}}, spec.Machines)
+
+	checkError(t, `deployment.deploy(Machine.static({publicIP: "8.8.8.8",
+		sshKeyPath: "key"}));`,
+		`static machine has invalid IPs: public "8.8.8.8", private ""`)
+	checkError(t, `deployment.deploy(Machine.static({publicIP: "8.8.8.8",
+		privateIP: "10.0.0.2"}));`, "static machine 8.8.8.8 has no SSH key")
+	checkError(t, `deployment.deploy(Machine.static({publicIP: "8.8.8.8",
+		privateIP: "10.0.0.2", sshKeyPath: "key"}).replicate(2));`,
+		"static machine 8.8.8.8 is declared twice")
+	checkError(t, `deployment.deploy(new Machine({provider: "Amazon",
+		publicIP: "8.8.8.8"}));`,
+		"only static machines may specify addresses and SSH keys: Amazon")
+}
+
+func TestNetworkTier(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`deployment.deploy(new Machine({
+		provider: "Amazon", role: "Worker", networkTier: "enhanced"}));`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, "enhanced", spec.Machines[0].NetworkTier)
+
+	actual, err := FromJSON(spec.String())
+	assert.NoError(t, err)
+	assert.Equal(t, spec.Machines, actual.Machines)
+
+	spec, err = FromJavascript(`deployment.deploy(new Machine({
+		provider: "Amazon", networkTier: "enhanced"}).asWorker());`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, "enhanced", spec.Machines[0].NetworkTier)
+
+	checkError(t, `deployment.deploy(new Machine({provider: "Amazon",
+		networkTier: 10}));`, "networkTier must be a string")
+	checkError(t, `deployment.deploy(new Machine({provider: "Amazon",
+		networkTier: "ultra"}));`,
+		`Amazon machines have no network tier "ultra", only [enhanced]`)
+	checkError(t, `deployment.deploy(new Machine({provider: "Google",
+		networkTier: "enhanced"}));`,
+		`Google machines have no network tier "enhanced", only []`)
+}
+
+func TestAutoscaleWorkers(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`deployment.autoscaleWorkers({min: 1, max: 5,
+		template: new Machine({provider: "Amazon", size: "m4.large"})});`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, Autoscale{Min: 1, Max: 5, Template: Machine{
+		Provider:      "Amazon",
+		Role:          "Worker",
+		Size:          "m4.large",
+		SSHKeys:       []string{},
+		SizeFallbacks: []string{},
+	}}, spec.AutoscaleWorkers)
+
+	actual, err := FromJSON(spec.String())
+	assert.NoError(t, err)
+	assert.Equal(t, spec.AutoscaleWorkers, actual.AutoscaleWorkers)
+
+	checkError(t, `deployment.autoscaleWorkers({max: 5, template: {}});`,
+		"autoscaleWorkers requires a Machine template")
+	checkError(t, `deployment.autoscaleWorkers({min: 3, max: 2,
+		template: new Machine({provider: "Amazon"})});`,
+		"autoscaleWorkers requires 0 <= min <= max, and max > 0")
+	checkError(t, `deployment.autoscaleWorkers({max: 2,
+		template: new Machine({provider: "Amazon", role: "Master"})});`,
+		`autoscale template must be a Worker, not "Master"`)
+	checkError(t, `deployment.autoscaleWorkers({max: 2,
+		template: new Machine({role: "Worker"})});`,
+		`autoscale template must be bootable by a provider, not ""`)
+	checkError(t, `deployment.autoscaleWorkers({max: 2,
+		template: new Machine({provider: "Amazon", floatingIP: "8.8.8.8"})});`,
+		"autoscale template may not have a floating IP")
+
+	stc := Stitch{AutoscaleWorkers: Autoscale{Min: 2, Max: 1}}
+	assert.EqualError(t, stc.validateAutoscale(), "autoscaled workers must "+
+		"satisfy 0 <= min <= max: min 2, max 1")
+}
+
+func TestStopSignal(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`deployment.deploy(new Service("foo",
+		[new Container("image").withStopSignal("SIGQUIT")]));`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, "SIGQUIT", spec.Containers[0].StopSignal)
+
+	actual, err := FromJSON(spec.String())
+	assert.NoError(t, err)
+	assert.Equal(t, spec.Containers, actual.Containers)
+
+	checkError(t, `deployment.deploy(new Service("foo",
+		[new Container("image").withStopSignal("SIGFOO")]));`,
+		"container 1 has unknown stop signal: SIGFOO")
+}
+
+func TestCPUSet(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`var c = new Container("image").withCPUSet("0-3,8");
+	deployment.deploy(new Service("foo", [c, c.clone()]));`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, "0-3,8", spec.Containers[0].CPUSet)
+	assert.Equal(t, "0-3,8", spec.Containers[1].CPUSet)
+
+	actual, err := FromJSON(spec.String())
+	assert.NoError(t, err)
+	assert.Equal(t, spec.Containers, actual.Containers)
+
+	checkError(t, `deployment.deploy(new Service("foo",
+		[new Container("image").withCPUSet("3-0")]));`,
+		"container 1 has malformed cpuset: 3-0")
+
+	for _, cpus := range []string{"0", "0-3", "1,3,5-7", "010"} {
+		assert.True(t, validCPUSet(cpus), cpus)
+	}
+	for _, cpus := range []string{",", "0,", "-1", "1-", "0-3-5", "a", "+1",
+		"0 - 3", "1-99999999999999999999"} {
+		assert.False(t, validCPUSet(cpus), cpus)
+	}
+}
+
+func TestNetworkMode(t *testing.T) {
+	t.Parallel()
+
+	for _, mode := range []string{"overlay", "host", "none"} {
+		spec, err := FromJavascript(fmt.Sprintf(`var c = new Container("image")
+		.withNetworkMode(%q);
+		deployment.deploy(new Service("foo", [c, c.clone()]));`, mode),
+			ImportGetter{Path: "."})
+		assert.NoError(t, err)
+		assert.Equal(t, mode, spec.Containers[0].NetworkMode)
+		assert.Equal(t, mode, spec.Containers[1].NetworkMode)
+		assert.Equal(t, mode == NetworkModeOverlay, spec.Containers[0].OnOverlay())
+
+		actual, err := FromJSON(spec.String())
+		assert.NoError(t, err)
+		assert.Equal(t, spec.Containers, actual.Containers)
+	}
+
+	spec, err := FromJavascript(`deployment.deploy(new Service("foo",
+		[new Container("image")]));`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, NetworkModeOverlay, spec.Containers[0].NetworkMode)
+
+	// Specs that predate the field join the overlay.
+	assert.True(t, Container{}.OnOverlay())
+
+	checkError(t, `deployment.deploy(new Service("foo",
+		[new Container("image").withNetworkMode("bridge")]));`,
+		"container 1 has unknown network mode: bridge")
+}
+
+func TestSidecars(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`var app = new Container("app");
+	var proxy = new Container("proxy").asSidecarOf(app);
+	deployment.deploy([new Service("proxy", [proxy]), new Service("app", [app])]);`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+
+	// Containers are renumbered as they're deployed, and sidecars follow suit.
+	assert.Equal(t, "proxy", spec.Containers[0].Image)
+	assert.Equal(t, 2, spec.Containers[0].SidecarOf)
+	assert.Equal(t, 0, spec.Containers[1].SidecarOf)
+
+	actual, err := FromJSON(spec.String())
+	assert.NoError(t, err)
+	assert.Equal(t, spec.Containers, actual.Containers)
+
+	checkError(t, `var app = new Container("app");
+	deployment.deploy(new Service("proxy",
+		[new Container("proxy").asSidecarOf(app)]));`,
+		"container 1 is a sidecar of an undeployed container")
+
+	for _, test := range []struct {
+		containers []Container
+		err        string
+	}{
+		{
+			containers: []Container{{ID: 1}, {ID: 2, SidecarOf: 3}},
+			err:        "container 2 is a sidecar of nonexistent container 3",
+		},
+		{
+			containers: []Container{{ID: 1, SidecarOf: 1}},
+			err:        "container 1 is a sidecar of itself",
+		},
+		{
+			containers: []Container{{ID: 1, SidecarOf: 2}, {ID: 2, SidecarOf: 1}},
+			err: "container 1 is a sidecar of container 2, which is a " +
+				"sidecar itself",
+		},
+		{
+			containers: []Container{{ID: 1}, {ID: 2, SidecarOf: 1},
+				{ID: 3, SidecarOf: 2}},
+			err: "container 3 is a sidecar of container 2, which is a " +
+				"sidecar itself",
+		},
+	} {
+		spec := Stitch{Containers: test.containers}
+		assert.EqualError(t, spec.validateSidecars(), test.err)
+	}
+}
+
+func TestDedicatedMachines(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`var db = new Service("database",
+		[new Container("postgres")]);
+	deployment.deploy(db);
+	var m = new Machine({provider: "Amazon"});
+	deployment.deploy([m.dedicateTo(db), m.dedicateTo("database"),
+		m.dedicateTo("cache"), m]);`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+
+	var dedications []string
+	for _, m := range spec.Machines {
+		dedications = append(dedications, m.DedicatedTo)
+	}
+	assert.Equal(t, []string{"database", "database", "cache", ""}, dedications)
+
+	assert.Equal(t, []string{"machines are dedicated to label cache, which " +
+		"has no containers"}, spec.dedicationWarnings())
+}
+
+func TestPortRanges(t *testing.T) {
+	t.Parallel()
+
+	services := `var a = new Service("a", [new Container("image")]);
+	var b = new Service("b", [new Container("image")]);
+	deployment.deploy([a, b]);`
+
+	checkError(t, services+`a.connect(new Range(80, 90), b);
+		a.connect(new Range(85, 100), b);`,
+		"connections a->b have overlapping port ranges: 80-90 and 85-100")
+
+	// Exact duplicates are harmless, so they're deduplicated instead.
+	spec, err := FromJavascript(services+`a.connect(new Range(80, 90), b);
+		a.connect(new Range(80, 90), b);`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, []Connection{{From: "a", To: "b", MinPort: 80, MaxPort: 90}},
+		spec.Connections)
+
+	// Disjoint ranges, and overlapping ranges with different protocols, are fine.
+	spec, err = FromJavascript(services+`a.connect(new Range(80, 90), b);
+		a.connect(new Range(91, 100), b);
+		a.connect(new Range(85, 95), b, "udp");`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Len(t, spec.Connections, 3)
+	// Ranges must be valid port numbers.
+	checkError(t, services+`a.connect(new Range(80, 1000000), b);`,
+		"connection a->b has an invalid port range: 80-1000000")
+	checkError(t, services+`a.connect(new Range(90, 80), b);`,
+		"connection a->b has an invalid port range: 90-80")
+}
+
+func TestHostnames(t *testing.T) {
+	t.Parallel()
+
+	services := `var a = new Service("a", [new Container("image")]);
+	var b = new Service("b", [new Container("image")]);
+	deployment.deploy([a, b]);`
+
+	checkLabels(t, `var web = new Service("web", []);
+	web.publishHostname("www.example.com", {splitHorizon: true});
+	web.publishHostname("example.com");
+	deployment.deploy(web);`,
+		map[string]Label{
+			"web": {
+				Name:        "web",
+				IDs:         []int{},
+				Annotations: []string{},
+				Hostnames: []Hostname{
+					{Name: "www.example.com", SplitHorizon: true},
+					{Name: "example.com"},
+				},
+			},
+		})
+
+	checkError(t, services+`a.publishHostname("");`, "a publishes an empty hostname")
+	checkError(t, services+`a.publishHostname("example.com");
+	b.publishHostname("example.com");`,
+		"hostname example.com is published by both a and b")
+}
+
+func TestLoadBalancers(t *testing.T) {
+	t.Parallel()
+
+	web := `var web = new Service("web", [new Container("nginx")]);
+	deployment.deploy(web);`
+
+	spec, err := FromJavascript(web+`deployment.deploy([
+		new LoadBalancer(web, {listenerPort: 443, backendPort: 8443,
+			healthPath: "/health"}),
+		new LoadBalancer("web", {listenerPort: 80})]);`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, []LoadBalancer{
+		{TargetLabel: "web", ListenerPort: 443, BackendPort: 8443,
+			HealthPath: "/health"},
+		{TargetLabel: "web", ListenerPort: 80, BackendPort: 80},
+	}, spec.LoadBalancers)
+
+	fromJSON, err := FromJSON(spec.String())
+	assert.NoError(t, err)
+	assert.Equal(t, spec.LoadBalancers, fromJSON.LoadBalancers)
+
+	checkError(t, web+`deployment.deploy(
+		new LoadBalancer("db", {listenerPort: 80}));`,
+		"load balancer targets undeployed label: db")
+	checkError(t, web+`deployment.deploy(new LoadBalancer(web, {}));`,
+		"load balancer for web has an invalid port: 0")
+	checkError(t, web+`deployment.deploy(
+		new LoadBalancer(web, {listenerPort: 80, backendPort: 70000}));`,
+		"load balancer for web has an invalid port: 70000")
+	checkError(t, web+`deployment.deploy(
+		new LoadBalancer(web, {listenerPort: 80, healthPath: "health"}));`,
+		"load balancer for web has a malformed health path: health")
+
+	lb := `deployment.deploy(new LoadBalancer(web, {listenerPort: 80}));`
+	floating := `web.place(new MachineRule(false, {floatingIP: true}));
+	deployment.deploy(new Machine({role: "Worker", floatingIP: "8.8.8.8"}));`
+	dedicated := `deployment.deploy(new Machine({role: "Worker",
+		floatingIP: "8.8.8.8"}).dedicateTo(web));`
+	conflict := "web is behind a load balancer, but is also placed on " +
+		"floating IP machines, so its public entry point is ambiguous"
+
+	checkError(t, web+lb+floating, conflict)
+	checkError(t, web+lb+dedicated, conflict)
+	for _, spec := range []string{web + lb, web + floating, web + dedicated} {
+		_, err = FromJavascript(spec, ImportGetter{Path: "."})
+		assert.NoError(t, err)
+	}
+}
+
+func TestGPUs(t *testing.T) {
+	t.Parallel()
+
+	machines := `deployment.deploy(new Machine({role: "Worker", gpus: 2}));`
+	onGPUs := `s.place(new MachineRule(false, {gpu: true}));`
+
+	spec, err := FromJavascript(machines+`var s = new Service("train",
+		[new Container("tensorflow").withGPUs(2)]);`+onGPUs+`deployment.deploy(s);`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, spec.Machines[0].GPUs)
+	assert.Equal(t, 2, spec.Containers[0].GPUs)
+	assert.Equal(t, []Placement{{TargetLabel: "train", GPU: true}}, spec.Placements)
+
+	roundTrip, err := FromJSON(spec.String())
+	assert.NoError(t, err)
+	assert.Equal(t, spec, roundTrip)
+
+	checkError(t, machines+`var s = new Service("train",
+		[new Container("tensorflow").withGPUs(1)]);
+		deployment.deploy(s);`,
+		"container 1 requires GPUs, but isn't placed on machines with GPUs")
+	checkError(t, machines+`var s = new Service("train",
+		[new Container("tensorflow").withGPUs(4)]);`+onGPUs+`deployment.deploy(s);`,
+		"container 1 requires 4 GPUs, but no worker has that many")
+	checkError(t, machines+`var s = new Service("train",
+		[new Container("tensorflow").withGPUs(-1)]);`+onGPUs+`deployment.deploy(s);`,
+		"container 1 requires a negative number of GPUs: -1")
+	checkError(t, `deployment.deploy(new Machine({gpus: -1}));`,
+		"machine has a negative number of GPUs: -1")
+}
+
+func TestSizeFallbacks(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`var m = new Machine({size: "m5.large"});
+		deployment.deploy(m.withSizeFallbacks(["m5a.large", "m4.large"]));
+		deployment.deploy(m);`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"m5a.large", "m4.large"}, spec.Machines[0].SizeFallbacks)
+	assert.Equal(t, []string{}, spec.Machines[1].SizeFallbacks)
+
+	checkError(t, `deployment.deploy(new Machine({}).withSizeFallbacks(["m4.large"]));`,
+		"machine has size fallbacks, but no size: [m4.large]")
+	checkError(t, `deployment.deploy(new Machine({size: "m5.large"})
+		.withSizeFallbacks([""]));`, "machine has an empty size fallback")
+	checkError(t, `deployment.deploy(new Machine({size: "m5.large"})
+		.withSizeFallbacks(["m4.large", "m5.large"]));`,
+		"machine lists size m5.large more than once")
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`deployment.maintenanceWindow({days: ["Sat", "Sun"],
+		start: "22:00", end: "02:00", tz: "America/Los_Angeles"});`,
+		ImportGetter{Path: "."})
+	assert.NoError(t, err)
+
+	w := spec.MaintenanceWindow
+	assert.Equal(t, MaintenanceWindow{Days: []string{"Sat", "Sun"},
+		Start: "22:00", End: "02:00", TZ: "America/Los_Angeles"}, w)
+
+	pdt, err := time.LoadLocation("America/Los_Angeles")
+	assert.NoError(t, err)
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2017, 6, day, hour, min, 0, 0, pdt)
+	}
+
+	// June 3rd, 2017 is a Saturday.  The window wraps past midnight, so it's open
+	// early Monday morning, but not early Saturday morning.
+	for _, test := range []struct {
+		t, next time.Time
+	}{
+		{at(3, 1, 0), at(3, 22, 0)},
+		{at(3, 21, 59), at(3, 22, 0)},
+		{at(3, 22, 0), at(3, 22, 0)},
+		{at(4, 1, 30), at(4, 1, 30)},
+		{at(5, 1, 59), at(5, 1, 59)},
+		{at(5, 2, 0), at(10, 22, 0)},
+		{at(7, 12, 0).UTC(), at(10, 22, 0)},
+	} {
+		next := w.NextOpen(test.t)
+		assert.True(t, test.next.Equal(next), "NextOpen(%s) = %s, expected %s",
+			test.t, next, test.next)
+		assert.Equal(t, test.next.Equal(test.t), w.Open(test.t))
+	}
+
+	// Without days, the window is always open.
+	now := time.Now()
+	assert.True(t, MaintenanceWindow{}.Open(now))
+	assert.Equal(t, now, MaintenanceWindow{}.NextOpen(now))
+
+	checkError(t, `deployment.maintenanceWindow({start: "02:00", end: "04:00"});`,
+		"maintenance window must have days")
+	checkError(t, `deployment.maintenanceWindow({days: ["Saturday"],
+		start: "02:00", end: "04:00"});`,
+		`unknown maintenance window day: "Saturday"`)
+	checkError(t, `deployment.maintenanceWindow({days: ["Sat"],
+		start: "2:00", end: "04:00"});`,
+		`invalid time of day (must be HH:MM): "2:00"`)
+	checkError(t, `deployment.maintenanceWindow({days: ["Sat"],
+		start: "02:00", end: "24:00"});`,
+		`invalid time of day (must be HH:MM): "24:00"`)
+	checkError(t, `deployment.maintenanceWindow({days: ["Sat"],
+		start: "02:00", end: "02:00"});`,
+		"maintenance window must not start and end at the same time: 02:00")
+	checkError(t, `deployment.maintenanceWindow({days: ["Sat"],
+		start: "02:00", end: "04:00", tz: "Mars/Olympus_Mons"});`,
+		"unknown time zone: Mars/Olympus_Mons")
+}
+
+func TestNetworks(t *testing.T) {
+	t.Parallel()
+
+	spec, err := FromJavascript(`deployment.createNetwork("pci");
+	var web = new Service("web", [new Container("nginx")]);
+	var vault = new Service("vault", [new Container("vault")]);
+	vault.inNetwork("pci");
+	web.connect(8200, vault, {allowCrossNetwork: true});
+	deployment.deploy([web, vault]);`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pci"}, spec.Networks)
+	assert.Equal(t, "", spec.Labels[0].Network)
+	assert.Equal(t, "pci", spec.Labels[1].Network)
+	assert.Equal(t, "", spec.Containers[0].Network)
+	assert.Equal(t, "pci", spec.Containers[1].Network)
+	assert.Equal(t, []Connection{{From: "web", To: "vault", MinPort: 8200,
+		MaxPort: 8200, AllowCrossNetwork: true}}, spec.Connections)
+
+	checkError(t, `deployment.createNetwork("pci");
+	var web = new Service("web", []);
+	var vault = new Service("vault", []);
+	vault.inNetwork("pci");
+	web.connect(8200, vault);
+	deployment.deploy([web, vault]);`,
+		"connection web->vault crosses from network default to pci without "+
+			"allowCrossNetwork")
+	checkError(t, `var vault = new Service("vault", []);
+	vault.inNetwork("pci");
+	deployment.deploy(vault);`, "label vault is in undeclared network: pci")
+	checkError(t, `deployment.createNetwork("pci");
+	var c = new Container("vault");
+	var vault = new Service("vault", [c]);
+	vault.inNetwork("pci");
+	deployment.deploy([vault, new Service("backup", [c])]);`,
+		"container 1 is in networks pci and default")
+	checkError(t, `deployment.createNetwork("pci");
+	var vault = new Service("vault", [new Container("vault").withNetworkMode("host")]);
+	vault.inNetwork("pci");
+	deployment.deploy(vault);`, "container 1 is in network pci, so it must join "+
+		"the overlay")
+	checkError(t, `deployment.createNetwork("pci");
+	deployment.createNetwork("pci");`, "network pci already exists")
+	checkError(t, `deployment.createNetwork("default");`,
+		"network default is created implicitly")
+	checkError(t, `deployment.createNetwork("PCI");`,
+		`invalid network name: "PCI" (must be lowercase letters, digits, `+
+			`and hyphens)`)
+
+	// Connections to and from the public internet aren't cross-network.
+	_, err = FromJavascript(`deployment.createNetwork("pci");
+	var vault = new Service("vault", []);
+	vault.inNetwork("pci");
+	publicInternet.connect(443, vault);
+	deployment.deploy(vault);`, ImportGetter{Path: "."})
+	assert.NoError(t, err)
+}
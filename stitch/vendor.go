@@ -0,0 +1,253 @@
+package stitch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/NetSys/quilt/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+// VendorDir is the directory, next to a spec, that Vendor copies the spec's imports
+// into.
+const VendorDir = "quilt_vendor"
+
+// LockfileName is the name of the lockfile within the VendorDir.
+const LockfileName = "lock.json"
+
+// A Lockfile records the repos vendored for a spec.
+type Lockfile struct {
+	Repos []VendoredRepo
+}
+
+// A VendoredRepo is a repo copied into the VendorDir.
+type VendoredRepo struct {
+	Source   string // The repo's import path, e.g. github.com/quilt/spark.
+	Revision string // The revision copied.  Empty if the VCS didn't report one.
+	Hash     string // The hash of the repo's files.  See hashTree.
+}
+
+// vendorState resolves imports from a spec's VendorDir.
+type vendorState struct {
+	dir      string
+	frozen   bool
+	lockHash string
+	repos    []VendoredRepo
+
+	// The sources whose vendored files have been checked against their hash.
+	verified map[string]struct{}
+}
+
+// The directories of version control metadata, which aren't vendored.
+var vcsDirs = map[string]struct{}{".git": {}, ".hg": {}, ".svn": {}, ".bzr": {}}
+
+// Vendor copies the repos that the spec at `specPath` imports, directly or through
+// its imports, into the VendorDir next to it, and records them in its lockfile.  The
+// repos are copied from the getter's Path, and with AutoDownload, any that haven't
+// been downloaded are downloaded first.  Imports over HTTPS can't be vendored.
+func Vendor(specPath string, getter ImportGetter) (Lockfile, error) {
+	getter.vendor = nil
+	getter.imported = map[string]struct{}{}
+	if _, err := FromFile(specPath, getter); err != nil {
+		return Lockfile{}, err
+	}
+
+	repoFactory := getter.repoFactory
+	if repoFactory == nil {
+		repoFactory = goRepoFactory
+	}
+
+	roots := map[string]repo{}
+	for name := range getter.imported {
+		r, err := repoFactory(name)
+		if err != nil {
+			return Lockfile{}, fmt.Errorf("unable to find the repo of %s: %s",
+				name, err)
+		}
+		roots[filepath.Clean(r.root())] = r
+	}
+
+	vendorDir := filepath.Join(filepath.Dir(specPath), VendorDir)
+	if err := util.AppFs.RemoveAll(vendorDir); err != nil {
+		return Lockfile{}, err
+	}
+
+	lock := Lockfile{Repos: []VendoredRepo{}}
+	for root, r := range roots {
+		src := filepath.Join(getter.Path, root)
+		if err := copyTree(src, filepath.Join(vendorDir, root)); err != nil {
+			return Lockfile{}, fmt.Errorf("unable to vendor %s: %s", root, err)
+		}
+
+		hash, err := hashTree(src)
+		if err != nil {
+			return Lockfile{}, fmt.Errorf("unable to vendor %s: %s", root, err)
+		}
+
+		revision, err := r.revision(src)
+		if err != nil {
+			log.WithError(err).Warnf("Unable to get the revision of %s.", root)
+		}
+		lock.Repos = append(lock.Repos, VendoredRepo{
+			Source: root, Revision: revision, Hash: hash})
+	}
+	sort.Sort(vendoredRepoSlice(lock.Repos))
+
+	lockJSON, err := json.MarshalIndent(lock, "", "\t")
+	if err != nil {
+		return Lockfile{}, err
+	}
+
+	lockPath := filepath.Join(vendorDir, LockfileName)
+	if err := util.AppFs.MkdirAll(vendorDir, 0755); err != nil {
+		return Lockfile{}, err
+	}
+	return lock, util.WriteFile(lockPath, append(lockJSON, '\n'), 0644)
+}
+
+// WithVendor returns a copy of the getter that resolves the imports of vendored repos
+// from the VendorDir in `dir`, after checking that their files still match the
+// lockfile.  Other repos are resolved as usual, unless `frozen` is set.  Frozen
+// vendoring refuses any import that would need the network: repos that aren't
+// vendored, and imports over HTTPS.  If there's no lockfile, nothing is vendored.
+func (getter ImportGetter) WithVendor(dir string, frozen bool) (ImportGetter, error) {
+	vendorDir := filepath.Join(dir, VendorDir)
+	vendor := &vendorState{dir: vendorDir, frozen: frozen,
+		verified: map[string]struct{}{}}
+
+	lockPath := filepath.Join(vendorDir, LockfileName)
+	switch contents, err := util.ReadFile(lockPath); {
+	case os.IsNotExist(err) && !frozen:
+		return getter, nil
+	case os.IsNotExist(err):
+	case err != nil:
+		return ImportGetter{}, err
+	default:
+		var lock Lockfile
+		if err := json.Unmarshal([]byte(contents), &lock); err != nil {
+			return ImportGetter{}, fmt.Errorf("malformed %s: %s", lockPath, err)
+		}
+		vendor.repos = lock.Repos
+		vendor.lockHash = hashString(contents)
+	}
+
+	getter.vendor = vendor
+	return getter, nil
+}
+
+// check returns whether `name` should be imported from the VendorDir, and whether the
+// vendored files of its repo match their hash.
+func (vendor *vendorState) check(name string) (bool, error) {
+	var match *VendoredRepo
+	for i, r := range vendor.repos {
+		if (name == r.Source || strings.HasPrefix(name, r.Source+"/")) &&
+			(match == nil || len(r.Source) > len(match.Source)) {
+			match = &vendor.repos[i]
+		}
+	}
+
+	if match == nil {
+		if vendor.frozen {
+			return false, fmt.Errorf("unable to open import %s: it isn't "+
+				"vendored, and the vendored imports are frozen (run "+
+				"quilt vendor)", name)
+		}
+		return false, nil
+	}
+
+	if _, ok := vendor.verified[match.Source]; ok {
+		return true, nil
+	}
+
+	hash, err := hashTree(filepath.Join(vendor.dir, match.Source))
+	if err != nil {
+		return false, fmt.Errorf("unable to verify vendored %s: %s",
+			match.Source, err)
+	}
+
+	if hash != match.Hash {
+		return false, fmt.Errorf("vendored %s has hash %s, but the lockfile "+
+			"expects %s: it was modified after it was vendored (run quilt "+
+			"vendor to vendor it again)", match.Source, hash, match.Hash)
+	}
+	vendor.verified[match.Source] = struct{}{}
+	return true, nil
+}
+
+// hashTree hashes the paths and contents of the files within `dir`, except for version
+// control metadata.
+func hashTree(dir string) (string, error) {
+	hash := sha256.New()
+	err := walkTree(dir, func(rel, contents string) error {
+		fmt.Fprintf(hash, "%s\x00%d\x00%s", filepath.ToSlash(rel), len(contents),
+			contents)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// copyTree copies the files within `src` to `dst`, except for version control
+// metadata.
+func copyTree(src, dst string) error {
+	return walkTree(src, func(rel, contents string) error {
+		path := filepath.Join(dst, rel)
+		if err := util.AppFs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return util.WriteFile(path, []byte(contents), 0644)
+	})
+}
+
+// walkTree calls `fn` with the path, relative to `dir`, and contents of each file
+// within `dir` in lexical order.  Version control metadata is skipped.
+func walkTree(dir string, fn func(rel, contents string) error) error {
+	return afero.Walk(util.AppFs, dir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				if _, ok := vcsDirs[info.Name()]; ok {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+
+			contents, err := util.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return fn(rel, contents)
+		})
+}
+
+type vendoredRepoSlice []VendoredRepo
+
+func (slc vendoredRepoSlice) Len() int {
+	return len(slc)
+}
+
+func (slc vendoredRepoSlice) Less(i, j int) bool {
+	return slc[i].Source < slc[j].Source
+}
+
+func (slc vendoredRepoSlice) Swap(i, j int) {
+	slc[i], slc[j] = slc[j], slc[i]
+}
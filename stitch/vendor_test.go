@@ -0,0 +1,129 @@
+package stitch
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/NetSys/quilt/util"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRepo is a repo whose root is fixed.
+type fakeRepo struct {
+	repoRoot string
+}
+
+func (r fakeRepo) update(dir string) error { return nil }
+func (r fakeRepo) create(dir string) error { return nil }
+func (r fakeRepo) root() string            { return r.repoRoot }
+
+func (r fakeRepo) revision(dir string) (string, error) {
+	return "rev-" + filepath.Base(r.repoRoot), nil
+}
+
+func TestVendor(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+
+	util.WriteFile("/quilt/github.com/quilt/spark/index.js", []byte(
+		`var zk = require("github.com/quilt/zookeeper");
+		var lib = require("./lib");
+		exports.deploy = function() {
+			deployment.deploy(new Service("spark",
+				new Container("spark").replicate(lib.workers + zk.n)));
+		};`), 0644)
+	util.WriteFile("/quilt/github.com/quilt/spark/lib.js",
+		[]byte(`exports.workers = 2;`), 0644)
+	util.WriteFile("/quilt/github.com/quilt/spark/.git/HEAD",
+		[]byte("ref: refs/heads/master"), 0644)
+	util.WriteFile("/quilt/github.com/quilt/zookeeper/index.js",
+		[]byte(`exports.n = 1;`), 0644)
+	util.WriteFile("/specs/main.js",
+		[]byte(`require("github.com/quilt/spark").deploy();`), 0644)
+
+	getter := ImportGetter{
+		Path: "/quilt",
+		repoFactory: func(name string) (repo, error) {
+			return fakeRepo{repoRoot: name}, nil
+		},
+	}
+
+	lock, err := Vendor("/specs/main.js", getter)
+	assert.NoError(t, err)
+	assert.Len(t, lock.Repos, 2)
+	assert.Equal(t, "github.com/quilt/spark", lock.Repos[0].Source)
+	assert.Equal(t, "rev-spark", lock.Repos[0].Revision)
+	assert.Equal(t, "github.com/quilt/zookeeper", lock.Repos[1].Source)
+	assert.Equal(t, "rev-zookeeper", lock.Repos[1].Revision)
+
+	lockJSON, err := util.ReadFile("/specs/quilt_vendor/lock.json")
+	assert.NoError(t, err)
+	var written Lockfile
+	assert.NoError(t, json.Unmarshal([]byte(lockJSON), &written))
+	assert.Equal(t, lock, written)
+
+	assert.True(t, isFile("/specs/quilt_vendor/github.com/quilt/spark/lib.js"))
+	assert.False(t, isFile("/specs/quilt_vendor/github.com/quilt/spark/.git/HEAD"))
+
+	// The vendored copies are preferred to upstream changes.
+	util.WriteFile("/quilt/github.com/quilt/spark/lib.js",
+		[]byte(`exports.workers = 10;`), 0644)
+	vendored, err := getter.WithVendor("/specs", false)
+	assert.NoError(t, err)
+	spec, err := FromFile("/specs/main.js", vendored)
+	assert.NoError(t, err)
+	assert.Len(t, spec.Containers, 3)
+
+	spec, err = FromFile("/specs/main.js", getter)
+	assert.NoError(t, err)
+	assert.Len(t, spec.Containers, 11)
+
+	// Modified vendored copies are refused.
+	util.WriteFile("/specs/quilt_vendor/github.com/quilt/zookeeper/index.js",
+		[]byte(`exports.n = 5;`), 0644)
+	vendored, err = getter.WithVendor("/specs", false)
+	assert.NoError(t, err)
+	_, err = FromFile("/specs/main.js", vendored)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "vendored github.com/quilt/zookeeper has hash")
+
+	// Vendoring again picks up the changes.
+	_, err = Vendor("/specs/main.js", getter)
+	assert.NoError(t, err)
+	vendored, err = getter.WithVendor("/specs", true)
+	assert.NoError(t, err)
+	spec, err = FromFile("/specs/main.js", vendored)
+	assert.NoError(t, err)
+	assert.Len(t, spec.Containers, 11)
+
+	// Frozen vendoring refuses imports that would need the network.
+	util.WriteFile("/specs/other.js", []byte(`require("github.com/quilt/redis");`),
+		0644)
+	_, err = FromFile("/specs/other.js", vendored)
+	assert.EqualError(t, err, "StitchError: unable to open import "+
+		"github.com/quilt/redis: it isn't vendored, and the vendored imports are "+
+		"frozen (run quilt vendor)")
+
+	util.WriteFile("/specs/url.js",
+		[]byte(`require("https://example.com/lib.js");`), 0644)
+	_, err = FromFile("/specs/url.js", vendored)
+	assert.EqualError(t, err, "StitchError: unable to import "+
+		"https://example.com/lib.js: the vendored imports are frozen, and "+
+		"imports over HTTPS need the network")
+
+	_, err = Vendor("/specs/url.js", getter)
+	assert.EqualError(t, err, "StitchError: unable to vendor "+
+		"https://example.com/lib.js: imports over HTTPS can't be vendored")
+
+	// Without a lockfile, nothing is vendored unless frozen.
+	unvendored, err := getter.WithVendor("/elsewhere", false)
+	assert.NoError(t, err)
+	assert.Nil(t, unvendored.vendor)
+
+	frozen, err := getter.WithVendor("/elsewhere", true)
+	assert.NoError(t, err)
+	_, err = FromFile("/specs/main.js", frozen)
+	assert.Error(t, err)
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/spf13/afero"
@@ -144,3 +145,19 @@ func StrStrMapEqual(x, y map[string]string) bool {
 	}
 	return true
 }
+
+// EnvList flattens the environment `env` into KEY=VALUE pairs sorted by key, so that
+// the same environment always produces the same list.
+func EnvList(env map[string]string) []string {
+	var keys []string
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var list []string
+	for _, k := range keys {
+		list = append(list, k+"="+env[k])
+	}
+	return list
+}